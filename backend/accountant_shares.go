@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// An outside accountant is given a link rather than a login. A share
+// opens one period's books, a closed fiscal year or a range of dates,
+// read only, until it expires or is revoked: the transactions and
+// expenses in the period, as JSON or CSV, and its profit and loss and
+// closing balance sheet. Like an invite, the link's token is the
+// credential and only its hash is kept. Every view and export through a
+// share is logged for the admins.
+//
+// Admins manage shares:
+//
+//	POST   /api/accountant-shares           {"name", "email"?, "fiscal_year_id" | "start_date" and "end_date", "expires_in_days"?}
+//	GET    /api/accountant-shares           with how often and when each was last used
+//	DELETE /api/accountant-shares/:id       revoke
+//	GET    /api/accountant-shares/:id/log   what the accountant viewed and exported
+//
+// The accountant reads, with ?from= and ?to= (YYYY-MM-DD) narrowing the
+// period and ?format=csv exporting the lists:
+//
+//	GET /api/shared/:token                         the organization and period
+//	GET /api/shared/:token/transactions
+//	GET /api/shared/:token/expenses
+//	GET /api/shared/:token/reports/profit-loss
+//	GET /api/shared/:token/reports/balance-sheet   as of the end of the period
+//
+// Configuration:
+//
+//	APP_URL  share links are APP_URL/shared/<token> (default: this server)
+
+const (
+	shareDefaultDays = 30
+	shareMaxDays     = 365
+)
+
+type accountantShare struct {
+	id, orgID, name      string
+	startDate, endDate   string
+	periodFrom, periodTo time.Time // periodTo is the day after end_date
+}
+
+func registerAccountantShareRoutes(app *fiber.App) {
+	r := app.Group("/api/accountant-shares", requireAuth, requireRole("admin"))
+	r.Get("/", handleListAccountantShares)
+	r.Post("/", handleCreateAccountantShare)
+	r.Delete("/:id", handleRevokeAccountantShare)
+	r.Get("/:id/log", handleAccountantShareLog)
+
+	// the token is the credential here
+	s := app.Group("/api/shared/:token", requireShare)
+	s.Get("/", handleGetShared)
+	s.Get("/transactions", handleSharedTransactions)
+	s.Get("/expenses", handleSharedExpenses)
+	s.Get("/reports/profit-loss", handleSharedProfitLoss)
+	s.Get("/reports/balance-sheet", handleSharedBalanceSheet)
+}
+
+func handleCreateAccountantShare(c *fiber.Ctx) error {
+	var req struct {
+		Name          string `json:"name"`
+		Email         string `json:"email"`
+		FiscalYearID  string `json:"fiscal_year_id"`
+		StartDate     string `json:"start_date"`
+		EndDate       string `json:"end_date"`
+		ExpiresInDays int    `json:"expires_in_days"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	req.Name, req.Email = strings.TrimSpace(req.Name), strings.ToLower(strings.TrimSpace(req.Email))
+	if req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name required"})
+	}
+	if req.Email != "" && !strings.Contains(req.Email, "@") {
+		return c.Status(400).JSON(fiber.Map{"error": "valid email required"})
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = shareDefaultDays
+	}
+	if req.ExpiresInDays < 1 || req.ExpiresInDays > shareMaxDays {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("expires_in_days must be 1 to %d", shareMaxDays)})
+	}
+	orgID := currentOrgID(c)
+	if req.FiscalYearID != "" {
+		err := dbFor(c).QueryRow(`SELECT start_date, end_date FROM fiscal_years WHERE id = ? AND organization_id = ?`, req.FiscalYearID, orgID).Scan(&req.StartDate, &req.EndDate)
+		if err == sql.ErrNoRows {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown fiscal year"})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	start, err1 := time.ParseInLocation("2006-01-02", req.StartDate, time.Local)
+	end, err2 := time.ParseInLocation("2006-01-02", req.EndDate, time.Local)
+	if err1 != nil || err2 != nil || end.Before(start) {
+		return c.Status(400).JSON(fiber.Map{"error": "a fiscal_year_id, or start_date and end_date as YYYY-MM-DD with end not before start, is required"})
+	}
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	id, now := genID(), time.Now()
+	expires := now.AddDate(0, 0, req.ExpiresInDays)
+	if _, err := dbFor(c).Exec(`INSERT INTO accountant_shares (id,organization_id,token_hash,name,email,fiscal_year_id,start_date,end_date,created_by,created_at,expires_at) VALUES (?,?,?,?,NULLIF(?, ''),NULLIF(?, ''),?,?,?,?,?)`,
+		id, orgID, hashRefreshToken(token), req.Name, req.Email, req.FiscalYearID, req.StartDate, req.EndDate, currentUserID(c), now.Format(time.RFC3339), expires.Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	link := appURL(c) + "/shared/" + token
+	out := fiber.Map{"id": id, "token": token, "link": link, "start_date": req.StartDate, "end_date": req.EndDate, "expires_at": expires.Format(time.RFC3339)}
+	if req.Email != "" {
+		profile, err := organizationProfile(dbFor(c), orgID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		orgName, _ := profile["name"].(string)
+		body := fmt.Sprintf("%s has shared its books for %s to %s with you, read only, until %s: %s", orgName, req.StartDate, req.EndDate, expires.Format("2 Jan 2006"), link)
+		out["sent"] = true
+		if _, err := sendEmail(req.Email, orgName+" books for "+req.StartDate+" to "+req.EndDate, body); err != nil {
+			out["sent"], out["delivery_error"] = false, err.Error()
+		}
+	}
+	return c.Status(201).JSON(out)
+}
+
+func handleListAccountantShares(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT s.id, s.name, s.email, s.fiscal_year_id, s.start_date, s.end_date, s.created_by, s.created_at, s.expires_at, s.revoked_at,
+		(SELECT COUNT(1) FROM accountant_share_log l WHERE l.share_id = s.id) AS accesses, (SELECT MAX(l.created_at) FROM accountant_share_log l WHERE l.share_id = s.id) AS last_accessed_at
+		FROM accountant_shares s WHERE s.organization_id = ? ORDER BY s.created_at DESC`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func handleRevokeAccountantShare(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`UPDATE accountant_shares SET revoked_at = ? WHERE id = ? AND organization_id = ? AND revoked_at IS NULL`,
+		time.Now().Format(time.RFC3339), c.Params("id"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "no active share"})
+	}
+	return c.SendStatus(204)
+}
+
+func handleAccountantShareLog(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "accountant_shares", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err := dbFor(c).Query(`SELECT resource, action, ip, user_agent, created_at FROM accountant_share_log WHERE share_id = ? ORDER BY created_at DESC, id DESC`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "items": items})
+}
+
+// findShare looks up the share token is for; it fails with a status and
+// message fit for the response when the share cannot be used.
+func findShare(q *DB, token string) (accountantShare, int, string) {
+	var s accountantShare
+	var expiresAt string
+	var revokedAt sql.NullString
+	err := q.QueryRow(`SELECT id, organization_id, name, start_date, end_date, expires_at, revoked_at FROM accountant_shares WHERE token_hash = ?`, hashRefreshToken(token)).
+		Scan(&s.id, &s.orgID, &s.name, &s.startDate, &s.endDate, &expiresAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return s, 404, "share not found"
+	}
+	if err != nil {
+		return s, 500, err.Error()
+	}
+	if exp, _ := time.Parse(time.RFC3339, expiresAt); revokedAt.Valid || time.Now().After(exp) {
+		return s, 410, "share expired"
+	}
+	s.periodFrom, _ = time.ParseInLocation("2006-01-02", s.startDate, time.Local)
+	end, _ := time.ParseInLocation("2006-01-02", s.endDate, time.Local)
+	s.periodTo = end.AddDate(0, 0, 1)
+	return s, 0, ""
+}
+
+// requireShare admits requests with a usable share token, storing the
+// share for currentShare.
+func requireShare(c *fiber.Ctx) error {
+	s, status, msg := findShare(dbFor(c), c.Params("token"))
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	c.Locals("share", s)
+	return c.Next()
+}
+
+func currentShare(c *fiber.Ctx) accountantShare {
+	s, _ := c.Locals("share").(accountantShare)
+	return s
+}
+
+// sharedPeriod reads from/to (YYYY-MM-DD, to inclusive) within the
+// share's period, defaulting to all of it. to is returned exclusive.
+func sharedPeriod(c *fiber.Ctx, s accountantShare) (time.Time, time.Time, error) {
+	from, to := s.periodFrom, s.periodTo
+	if v := c.Query("from"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return from, to, fiber.NewError(400, "from must be a date (YYYY-MM-DD)")
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, time.Local)
+		if err != nil {
+			return from, to, fiber.NewError(400, "to must be a date (YYYY-MM-DD)")
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	if from.Before(s.periodFrom) || to.After(s.periodTo) {
+		return from, to, fiber.NewError(403, "outside the shared period "+s.startDate+" to "+s.endDate)
+	}
+	return from, to, nil
+}
+
+// logShareAccess records that resource was viewed or exported through s.
+// Nothing is shown that could not be logged.
+func logShareAccess(c *fiber.Ctx, s accountantShare, resource, action string) error {
+	_, err := dbFor(c).Exec(`INSERT INTO accountant_share_log (id,share_id,resource,action,ip,user_agent,created_at) VALUES (?,?,?,?,?,?,?)`,
+		genID(), s.id, resource, action, c.IP(), c.Get(fiber.HeaderUserAgent), time.Now().Format(time.RFC3339))
+	return err
+}
+
+func handleGetShared(c *fiber.Ctx) error {
+	s := currentShare(c)
+	profile, err := organizationProfile(dbFor(c), s.orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := logShareAccess(c, s, "summary", "view"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"organization_name": profile["name"], "name": s.name, "start_date": s.startDate, "end_date": s.endDate})
+}
+
+const sharedTransactionsSQL = `SELECT id, type, amount, paid_amount, due_amount, COALESCE(contact_name, '') AS contact_name, COALESCE(payment_method, '') AS payment_method,
+	COALESCE(receipt_number, '') AS receipt_number, COALESCE(tax_amount, 0) AS tax_amount, COALESCE(source, '') AS source, COALESCE(voided_at, '') AS voided_at, created_at
+	FROM transactions WHERE organization_id = ? AND created_at >= ? AND created_at < ? ORDER BY created_at, id`
+
+func handleSharedTransactions(c *fiber.Ctx) error {
+	return sharedList(c, "transactions", sharedTransactionsSQL, []string{"amount", "paid_amount", "due_amount", "tax_amount"})
+}
+
+func handleSharedExpenses(c *fiber.Ctx) error {
+	return sharedList(c, "expenses", expenseSQL+` AND m.created_at >= ? AND m.created_at < ? ORDER BY m.created_at`, []string{"amount"})
+}
+
+// sharedList answers the rows query selects for the share's organization
+// and period, as JSON or, with ?format=csv, as a CSV download.
+func sharedList(c *fiber.Ctx, resource, query string, amounts []string) error {
+	s := currentShare(c)
+	from, to, err := sharedPeriod(c, s)
+	if fe, ok := err.(*fiber.Error); ok {
+		return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+	}
+	rows, err := dbFor(c).Query(query, s.orgID, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	moneyColumns(items, amounts...)
+	if c.Query("format") != "csv" {
+		if err := logShareAccess(c, s, resource, "view"); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"items": items})
+	}
+	var b strings.Builder
+	w := csv.NewWriter(&b)
+	w.Write(columns)
+	for _, item := range items {
+		record := make([]string, len(columns))
+		for i, col := range columns {
+			if v := item[col]; v != nil {
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	if err := logShareAccess(c, s, resource, "export"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s-%s-%s.csv"`, resource, from.Format("2006-01-02"), to.AddDate(0, 0, -1).Format("2006-01-02")))
+	return c.SendString(b.String())
+}
+
+func handleSharedProfitLoss(c *fiber.Ctx) error {
+	s := currentShare(c)
+	from, to, err := sharedPeriod(c, s)
+	if fe, ok := err.(*fiber.Error); ok {
+		return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+	}
+	pl, err := profitAndLoss(c.UserContext(), dbFor(c), s.orgID, from, to)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := logShareAccess(c, s, "profit-loss", "view"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(pl)
+}
+
+func handleSharedBalanceSheet(c *fiber.Ctx) error {
+	s := currentShare(c)
+	_, to, err := sharedPeriod(c, s)
+	if fe, ok := err.(*fiber.Error); ok {
+		return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+	}
+	bs, err := balanceSheet(c.UserContext(), dbFor(c), s.orgID, to)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := logShareAccess(c, s, "balance-sheet", "view"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(bs)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAccountantShare(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,contact_name,organization_id,created_at) VALUES ('t-1','inflow',12000,12000,0,'c-1','Walk-in','org-1','2024-03-10T10:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-2','inflow',8000,8000,0,'c-1','org-1','2025-02-01T10:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-3','inflow',5000,5000,0,'c-1','org-2','2024-03-10T10:00:00Z')`,
+		`INSERT INTO fiscal_years (id,name,start_date,end_date,status,organization_id) VALUES ('fy-1','FY 2024','2024-01-01','2024-12-31','closed','org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if role != "" {
+			req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, raw
+	}
+
+	if code, _ := call("manager", "POST", "/api/accountant-shares", `{"name":"Karim & Co","fiscal_year_id":"fy-1"}`); code != 403 {
+		t.Errorf("manager sharing: got %d, want 403", code)
+	}
+	if code, _ := call("admin", "POST", "/api/accountant-shares", `{"name":"Karim & Co","start_date":"2024-12-31","end_date":"2024-01-01"}`); code != 400 {
+		t.Errorf("backwards period: got %d, want 400", code)
+	}
+	code, raw := call("admin", "POST", "/api/accountant-shares", `{"name":"Karim & Co","fiscal_year_id":"fy-1","expires_in_days":14}`)
+	var share map[string]interface{}
+	_ = json.Unmarshal(raw, &share)
+	if code != 201 || share["start_date"] != "2024-01-01" || share["end_date"] != "2024-12-31" || !strings.HasSuffix(toString(share["link"]), "/shared/"+toString(share["token"])) {
+		t.Fatalf("share: %d %s", code, raw)
+	}
+	base := "/api/shared/" + toString(share["token"])
+
+	code, raw = call("", "GET", base+"/transactions", "")
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &list); code != 200 || err != nil || len(list.Items) != 1 || list.Items[0]["id"] != "t-1" || list.Items[0]["amount"] != 120.0 {
+		t.Fatalf("shared transactions: %d %s", code, raw)
+	}
+	code, raw = call("", "GET", base+"/transactions?format=csv", "")
+	if lines := strings.Split(strings.TrimSpace(string(raw)), "\n"); code != 200 || len(lines) != 2 || !strings.HasPrefix(lines[0], "id,type,amount") || !strings.Contains(lines[1], "120.00") {
+		t.Errorf("csv export: %d %s", code, raw)
+	}
+	if code, raw := call("", "GET", base+"/reports/profit-loss", ""); code != 200 || !strings.Contains(string(raw), `"sales":120`) {
+		t.Errorf("profit and loss: %d %s", code, raw)
+	}
+	if code, _ := call("", "GET", base+"/transactions?from=2024-06-01&to=2025-03-01", ""); code != 403 {
+		t.Errorf("outside the period: got %d, want 403", code)
+	}
+	// the share is for reading only
+	if code, _ := call("", "POST", base+"/transactions", `{}`); code != 404 && code != 405 {
+		t.Errorf("writing through a share: got %d", code)
+	}
+
+	code, raw = call("admin", "GET", "/api/accountant-shares/"+toString(share["id"])+"/log", "")
+	var log struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(raw, &log); code != 200 || err != nil || len(log.Items) != 3 {
+		t.Fatalf("access log: %d %s", code, raw)
+	}
+	actions := map[string]int{}
+	for _, e := range log.Items {
+		actions[toString(e["resource"])+" "+toString(e["action"])]++
+	}
+	if actions["transactions view"] != 1 || actions["transactions export"] != 1 || actions["profit-loss view"] != 1 {
+		t.Errorf("logged: %v", actions)
+	}
+
+	if code, _ := call("admin", "DELETE", "/api/accountant-shares/"+toString(share["id"]), ""); code != 204 {
+		t.Errorf("revoke: got %d", code)
+	}
+	if code, _ := call("", "GET", base, ""); code != 410 {
+		t.Errorf("revoked share: got %d, want 410", code)
+	}
+	if code, _ := call("", "GET", "/api/shared/nope", ""); code != 404 {
+		t.Errorf("unknown share: got %d, want 404", code)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Alerts are raised by background checks rather than by requests. The
+// low stock check runs every LOW_STOCK_CHECK_MINUTES (default 15; 0 turns
+// it off): an item with a reorder_level at or above its quantity gets an
+// open low_stock alert, and the alert is resolved once the item is
+// restocked. New alerts are sent to the organization's
+// low_stock_alert_email and POSTed as JSON to its low_stock_alert_webhook
+// when those settings are set. Anomaly alerts (see anomalies.go) share the
+// anomaly_alert_email and anomaly_alert_webhook settings.
+
+var alertStatuses = []string{"open", "acknowledged", "resolved"}
+
+func registerAlertRoutes(app *fiber.App) {
+	a := app.Group("/api/alerts", requireAuth)
+	a.Get("/", handleListAlerts)
+	a.Post("/check", requireRole("admin", "manager"), handleCheckAlerts)
+	a.Post("/:id/acknowledge", handleAcknowledgeAlert)
+}
+
+func startLowStockMonitor() {
+	minutes := 15
+	if v, err := strconv.Atoi(os.Getenv("LOW_STOCK_CHECK_MINUTES")); err == nil {
+		minutes = v
+	}
+	if minutes <= 0 {
+		return
+	}
+	go func() {
+		for {
+			for _, orgID := range organizationIDs() {
+				if raised, resolved, err := checkLowStock(db, orgID); err != nil {
+					log.Printf("low stock check for %s: %v", orgID, err)
+				} else if raised+resolved > 0 {
+					log.Printf("low stock check for %s: %d new, %d resolved", orgID, raised, resolved)
+				}
+			}
+			time.Sleep(time.Duration(minutes) * time.Minute)
+		}
+	}()
+}
+
+// raiseAlert opens an alert unless one for the same kind and ref_id is
+// still unresolved. It reports whether a new alert was opened.
+func raiseAlert(q *DB, orgID, kind, refID, message string, details fiber.Map) (bool, error) {
+	raw, _ := json.Marshal(details)
+	res, err := q.Exec(`INSERT INTO alerts (id,organization_id,kind,ref_id,message,details,status,created_at) VALUES (?,?,?,?,?,?,'open',?) ON CONFLICT DO NOTHING`,
+		genID(), orgID, kind, refID, message, string(raw), time.Now().Format(time.RFC3339))
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// checkLowStock raises low_stock alerts for orgID's items at or below their
+// reorder level, resolves those of items restocked since, and sends out
+// the new ones.
+func checkLowStock(q *DB, orgID string) (raised, resolved int, err error) {
+	rows, err := q.Query(`SELECT id, COALESCE(name, ''), COALESCE(sku, ''), quantity, reorder_level FROM inventory_items WHERE organization_id = ? AND reorder_level > 0 AND quantity <= reorder_level`, orgID)
+	if err != nil {
+		return 0, 0, err
+	}
+	type lowItem struct {
+		id, name, sku       string
+		quantity, reorderAt int
+	}
+	var low []lowItem
+	for rows.Next() {
+		var it lowItem
+		if err := rows.Scan(&it.id, &it.name, &it.sku, &it.quantity, &it.reorderAt); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		low = append(low, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	for _, it := range low {
+		msg := fmt.Sprintf("%s (%s) is down to %d; reorder level is %d", it.name, it.sku, it.quantity, it.reorderAt)
+		if it.quantity <= 0 {
+			msg = fmt.Sprintf("%s (%s) is out of stock", it.name, it.sku)
+		}
+		opened, err := raiseAlert(q, orgID, "low_stock", it.id, msg, fiber.Map{"sku": it.sku, "quantity": it.quantity, "reorder_level": it.reorderAt})
+		if err != nil {
+			return raised, 0, err
+		}
+		if opened {
+			raised++
+			var alertID string
+			if err := q.QueryRow(`SELECT id FROM alerts WHERE organization_id = ? AND kind = 'low_stock' AND ref_id = ? AND status = 'open'`, orgID, it.id).Scan(&alertID); err == nil {
+				fireRestHooks(orgID, "low_stock", alertID)
+			}
+		}
+	}
+
+	res, err := q.Exec(`UPDATE alerts SET status = 'resolved', resolved_at = ? WHERE organization_id = ? AND kind = 'low_stock' AND status <> 'resolved'
+		AND NOT EXISTS (SELECT 1 FROM inventory_items i WHERE i.id = alerts.ref_id AND i.reorder_level > 0 AND i.quantity <= i.reorder_level)`,
+		time.Now().Format(time.RFC3339), orgID)
+	if err != nil {
+		return raised, 0, err
+	}
+	n, _ := res.RowsAffected()
+	resolved = int(n)
+
+	if raised > 0 {
+		notifyAlerts(q, orgID, "low_stock")
+	}
+	return raised, resolved, nil
+}
+
+// notifyAlerts sends orgID's open alerts of kind not sent yet to the
+// configured email address and webhook, and marks them sent. Delivery
+// failures are logged and the alerts left unsent for the next check.
+func notifyAlerts(q *DB, orgID, kind string) {
+	prefix := kind
+	if isAnomalyKind(kind) {
+		prefix = "anomaly"
+	}
+	email, _ := orgSetting(orgID, prefix+"_alert_email").(string)
+	webhook, _ := orgSetting(orgID, prefix+"_alert_webhook").(string)
+	if email == "" && webhook == "" {
+		return
+	}
+	rows, err := q.Query(`SELECT id, ref_id, message, COALESCE(details, '{}'), created_at FROM alerts WHERE organization_id = ? AND kind = ? AND status = 'open' AND notified_at IS NULL ORDER BY created_at`, orgID, kind)
+	if err != nil {
+		log.Printf("alerts: %v", err)
+		return
+	}
+	var ids, lines []string
+	payload := []fiber.Map{}
+	for rows.Next() {
+		var id, refID, message, details, createdAt string
+		if err := rows.Scan(&id, &refID, &message, &details, &createdAt); err != nil {
+			rows.Close()
+			log.Printf("alerts: %v", err)
+			return
+		}
+		ids = append(ids, id)
+		lines = append(lines, "- "+message)
+		payload = append(payload, fiber.Map{"id": id, "kind": kind, "ref_id": refID, "message": message, "details": json.RawMessage(details), "created_at": createdAt})
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return
+	}
+
+	if email != "" {
+		subject := fmt.Sprintf("%d new %s alerts", len(ids), strings.ReplaceAll(kind, "_", " "))
+		if _, err := sendEmail(email, subject, strings.Join(lines, "\n")+"\n"); err != nil {
+			log.Printf("alerts: email to %s: %v", email, err)
+			return
+		}
+	}
+	if webhook != "" {
+		body, _ := json.Marshal(fiber.Map{"organization_id": orgID, "kind": kind, "alerts": payload})
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("alerts: webhook %s: %v", webhook, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("alerts: webhook %s answered %s", webhook, resp.Status)
+			return
+		}
+	}
+	now := time.Now().Format(time.RFC3339)
+	for _, id := range ids {
+		if _, err := q.Exec(`UPDATE alerts SET notified_at = ? WHERE id = ?`, now, id); err != nil {
+			log.Printf("alerts: %s: %v", id, err)
+		}
+	}
+}
+
+// handleListAlerts lists alerts, unresolved ones by default; status and
+// kind narrow the list.
+func handleListAlerts(c *fiber.Ctx) error {
+	query := `SELECT id, kind, ref_id, message, details, status, created_at, acknowledged_at, acknowledged_by, resolved_at, notified_at FROM alerts WHERE organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	switch status := c.Query("status"); status {
+	case "":
+		query += ` AND status <> 'resolved'`
+	case "all":
+	default:
+		valid := false
+		for _, s := range alertStatuses {
+			valid = valid || s == status
+		}
+		if !valid {
+			return c.Status(400).JSON(fiber.Map{"error": "status must be one of " + strings.Join(alertStatuses, ", ") + " or all"})
+		}
+		query += ` AND status = ?`
+		args = append(args, status)
+	}
+	if kind := c.Query("kind"); kind != "" {
+		query += ` AND kind = ?`
+		args = append(args, kind)
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY created_at DESC LIMIT 500`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, it := range items {
+		if raw, ok := it["details"].(string); ok {
+			var details interface{}
+			if json.Unmarshal([]byte(raw), &details) == nil {
+				it["details"] = details
+			}
+		}
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleCheckAlerts runs the low stock check for the caller's organization
+// now instead of waiting for the next scheduled run.
+func handleCheckAlerts(c *fiber.Ctx) error {
+	raised, resolved, err := checkLowStock(dbFor(c), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"raised": raised, "resolved": resolved})
+}
+
+func handleAcknowledgeAlert(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`UPDATE alerts SET status = 'acknowledged', acknowledged_at = ?, acknowledged_by = ? WHERE id = ? AND organization_id = ? AND status = 'open'`,
+		time.Now().Format(time.RFC3339), currentUserID(c), c.Params("id"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if !orgOwns(dbFor(c), "alerts", c.Params("id"), currentOrgID(c)) {
+			return c.Status(404).JSON(fiber.Map{"error": "alert not found"})
+		}
+		return c.Status(409).JSON(fiber.Map{"error": "alert is not open"})
+	}
+	return c.JSON(fiber.Map{"id": c.Params("id"), "status": "acknowledged"})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLowStockAlerts(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,reorder_level,organization_id) VALUES
+		('i-1','Pen','PEN',3,15,5,'org-1'),
+		('i-2','Ink','INK',50,40,5,'org-1'),
+		('i-3','Clip','CLP',0,2,0,'org-1'),
+		('i-4','Other','OTH',0,2,5,'org-2')`); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var hooks []map[string]interface{}
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		hooks = append(hooks, body)
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("PUT", "/api/settings/organization", `{"low_stock_alert_webhook":"`+hook.URL+`"}`); code != 200 {
+		t.Fatalf("settings: got %d", code)
+	}
+	if code, out := call("POST", "/api/alerts/check", ""); code != 200 || out["raised"] != 1.0 {
+		t.Fatalf("check: got %d %v, want one alert raised", code, out)
+	}
+	if code, out := call("POST", "/api/alerts/check", ""); code != 200 || out["raised"] != 0.0 {
+		t.Fatalf("re-check: got %d %v, want no new alert", code, out)
+	}
+
+	_, out := call("GET", "/api/alerts", "")
+	items := out["items"].([]interface{})
+	if len(items) != 1 {
+		t.Fatalf("got %d alerts, want 1 (only Pen is at or below its reorder level)", len(items))
+	}
+	alert := items[0].(map[string]interface{})
+	if alert["kind"] != "low_stock" || alert["ref_id"] != "i-1" || alert["status"] != "open" || alert["notified_at"] == nil {
+		t.Errorf("alert = %v", alert)
+	}
+	if d, _ := alert["details"].(map[string]interface{}); d["quantity"] != 3.0 || d["reorder_level"] != 5.0 {
+		t.Errorf("details = %v", alert["details"])
+	}
+	mu.Lock()
+	if len(hooks) != 1 || len(hooks[0]["alerts"].([]interface{})) != 1 {
+		t.Errorf("webhook calls = %v, want one with one alert", hooks)
+	}
+	mu.Unlock()
+
+	id := alert["id"].(string)
+	if code, _ := call("POST", "/api/alerts/"+id+"/acknowledge", ""); code != 200 {
+		t.Fatalf("acknowledge: got %d", code)
+	}
+	if code, _ := call("POST", "/api/alerts/"+id+"/acknowledge", ""); code != 409 {
+		t.Errorf("acknowledge twice: got %d, want 409", code)
+	}
+	if code, _ := call("POST", "/api/alerts/missing/acknowledge", ""); code != 404 {
+		t.Errorf("acknowledge missing: got %d, want 404", code)
+	}
+	if _, out := call("GET", "/api/alerts?status=acknowledged", ""); len(out["items"].([]interface{})) != 1 {
+		t.Errorf("acknowledged alerts = %v", out["items"])
+	}
+
+	if _, err := db.Exec(`UPDATE inventory_items SET quantity = 20 WHERE id = 'i-1'`); err != nil {
+		t.Fatal(err)
+	}
+	if code, out := call("POST", "/api/alerts/check", ""); code != 200 || out["resolved"] != 1.0 {
+		t.Fatalf("check after restock: got %d %v, want one resolved", code, out)
+	}
+	if _, out := call("GET", "/api/alerts", ""); len(out["items"].([]interface{})) != 0 {
+		t.Errorf("unresolved alerts after restock = %v", out["items"])
+	}
+	if _, out := call("GET", "/api/alerts?status=resolved", ""); len(out["items"].([]interface{})) != 1 {
+		t.Errorf("resolved alerts = %v", out["items"])
+	}
+
+	// running low again opens a fresh alert
+	if _, err := db.Exec(`UPDATE inventory_items SET quantity = 0 WHERE id = 'i-1'`); err != nil {
+		t.Fatal(err)
+	}
+	if _, out := call("POST", "/api/alerts/check", ""); out["raised"] != 1.0 {
+		t.Errorf("check after selling out: %v, want one raised", out)
+	}
+	if code, _ := call("GET", "/api/alerts?status=bogus", ""); code != 400 {
+		t.Errorf("bad status: got %d, want 400", code)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Analytics endpoints return chart-ready series: {labels, datasets} where
+// each dataset has a label and one data point per label. Timestamps are
+// bucketed in server local time, which SQLite's strftime can't do for
+// offset-suffixed RFC3339 values, so grouping happens here rather than in SQL.
+
+type chartDataset struct {
+	Label string    `json:"label"`
+	Data  []float64 `json:"data"`
+}
+
+type chartSeries struct {
+	Labels   []string       `json:"labels"`
+	Datasets []chartDataset `json:"datasets"`
+}
+
+func registerAnalyticsRoutes(app *fiber.App) {
+	r := app.Group("/api/analytics", requireAuth, cachedReport)
+	r.Get("/sales-trend", handleSalesTrend)
+	r.Get("/category-mix", handleCategoryMix)
+	r.Get("/payment-method-mix", handlePaymentMethodMix)
+	r.Get("/hour-heatmap", handleHourHeatmap)
+	r.Get("/new-vs-returning", handleNewVsReturning)
+	r.Get("/purchase-intervals", handlePurchaseIntervals)
+	r.Get("/lapsed-customers", handleLapsedCustomers)
+}
+
+// trendInterval returns, for a day, week or month interval, the functions
+// giving the start of a time's bucket and the start of the next, and the
+// layout of bucket labels.
+func trendInterval(interval string) (bucket, step func(time.Time) time.Time, layout string, ok bool) {
+	layout = "2006-01-02"
+	switch interval {
+	case "day":
+		bucket = func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local) }
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	case "week":
+		bucket = func(t time.Time) time.Time {
+			d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+			return d.AddDate(0, 0, -int(d.Weekday()))
+		}
+		step = func(t time.Time) time.Time { return t.AddDate(0, 0, 7) }
+	case "month":
+		bucket = func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.Local) }
+		step = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+		layout = "2006-01"
+	default:
+		return nil, nil, "", false
+	}
+	return bucket, step, layout, true
+}
+
+// handleSalesTrend returns sales and purchase totals per day, week or month.
+func handleSalesTrend(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	bucket, step, layout, ok := trendInterval(c.Query("interval", "day"))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "interval must be day, week or month"})
+	}
+
+	series := chartSeries{}
+	index := map[string]int{}
+	for t := bucket(from.Local()); t.Before(to); t = step(t) {
+		index[t.Format(layout)] = len(series.Labels)
+		series.Labels = append(series.Labels, t.Format(layout))
+		if len(series.Labels) > 1000 {
+			return c.Status(400).JSON(fiber.Map{"error": "date range too large for interval"})
+		}
+	}
+	sales := make([]float64, len(series.Labels))
+	purchases := make([]float64, len(series.Labels))
+
+	rows, err := dbFor(c).Query(`SELECT type, amount, created_at FROM transactions WHERE organization_id = ? AND created_at >= ? AND created_at < ? AND COALESCE(source, '') <> 'opening' AND voided_at IS NULL`, currentOrgID(c), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var typ, createdAt string
+		var amount money
+		if err := rows.Scan(&typ, &amount, &createdAt); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		t, err := parseTime(createdAt)
+		if err != nil {
+			continue
+		}
+		i, ok := index[bucket(t.Local()).Format(layout)]
+		if !ok {
+			continue
+		}
+		if typ == "inflow" {
+			sales[i] += amount.float()
+		} else {
+			purchases[i] += amount.float()
+		}
+	}
+	series.Datasets = []chartDataset{{Label: "Sales", Data: sales}, {Label: "Purchases", Data: purchases}}
+	return c.JSON(series)
+}
+
+// handleCategoryMix returns sales revenue per item category.
+func handleCategoryMix(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	rows, err := dbFor(c).Query(`SELECT COALESCE(NULLIF(i.category, ''), 'Uncategorized') as category, SUM(ti.total_price) FROM transaction_items ti JOIN transactions t ON ti.transaction_id = t.id LEFT JOIN inventory_items i ON ti.item_id = i.id WHERE t.organization_id = ? AND t.type = 'inflow' AND t.created_at >= ? AND t.created_at < ? AND COALESCE(t.source, '') <> 'opening' AND t.voided_at IS NULL GROUP BY 1 ORDER BY 2 DESC`, currentOrgID(c), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	series := chartSeries{Labels: []string{}}
+	data := []float64{}
+	for rows.Next() {
+		var label string
+		var total money
+		if err := rows.Scan(&label, &total); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		series.Labels = append(series.Labels, label)
+		data = append(data, total.float())
+	}
+	series.Datasets = []chartDataset{{Label: "Revenue", Data: data}}
+	return c.JSON(series)
+}
+
+// handlePaymentMethodMix returns the amount received from sales per payment method.
+func handlePaymentMethodMix(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	rows, err := dbFor(c).Query(`SELECT method, SUM(amount) FROM (`+paymentLinesSQL+`) l WHERE organization_id = ? AND type = 'inflow' AND created_at >= ? AND created_at < ? AND COALESCE(source, '') <> 'opening' GROUP BY method ORDER BY 2 DESC`, currentOrgID(c), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	series := chartSeries{Labels: []string{}}
+	data := []float64{}
+	for rows.Next() {
+		var label string
+		var total float64
+		if err := rows.Scan(&label, &total); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		series.Labels = append(series.Labels, label)
+		data = append(data, total)
+	}
+	series.Datasets = []chartDataset{{Label: "Received", Data: data}}
+	return c.JSON(series)
+}
+
+// handleHourHeatmap returns one dataset per weekday with sales totals for
+// each hour of the day (labels "00".."23"). metric=count counts sales instead.
+func handleHourHeatmap(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	counts, amounts, err := salesByHour(dbFor(c), currentOrgID(c), from, to, time.Local)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	grid := amounts
+	if c.Query("metric") == "count" {
+		grid = counts
+	}
+	series := chartSeries{}
+	for h := 0; h < 24; h++ {
+		series.Labels = append(series.Labels, fmt.Sprintf("%02d", h))
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		series.Datasets = append(series.Datasets, chartDataset{Label: d.String(), Data: grid[d][:]})
+	}
+	return c.JSON(series)
+}
+
+// salesByHour counts and totals orgID's sales in [from, to) by weekday and
+// hour of the day in loc.
+func salesByHour(q *DB, orgID string, from, to time.Time, loc *time.Location) (counts, amounts [7][24]float64, err error) {
+	rows, err := q.Query(`SELECT amount, created_at FROM transactions WHERE organization_id = ? AND type = 'inflow' AND created_at >= ? AND created_at < ? AND COALESCE(source, '') <> 'opening' AND voided_at IS NULL`, orgID, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if err != nil {
+		return counts, amounts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var amount money
+		var createdAt string
+		if err := rows.Scan(&amount, &createdAt); err != nil {
+			return counts, amounts, err
+		}
+		t, err := parseTime(createdAt)
+		if err != nil {
+			continue
+		}
+		t = t.In(loc)
+		counts[t.Weekday()][t.Hour()]++
+		amounts[t.Weekday()][t.Hour()] += amount.float()
+	}
+	return counts, amounts, rows.Err()
+}
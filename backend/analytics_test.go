@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// The chart endpoints shape a period's sales into labels and datasets,
+// leaving out opening balances and voided sales.
+func TestAnalyticsSeries(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	at := func(day, hour int) string {
+		return time.Date(2024, 3, day, hour, 30, 0, 0, time.Local).Format(time.RFC3339)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,category,organization_id) VALUES ('i-1','Pen','PEN',10,15,'Stationery','org-1'),('i-2','Tea','TEA',10,50,'','org-1')`,
+		// Monday 4 March 09:30, Tuesday 5 March 14:30 (twice), Thursday 14 March 09:30
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,payment_method,contact_id,organization_id,created_at) VALUES
+			('t-1','inflow',4500,4500,0,'cash','c-1','org-1','` + at(4, 9) + `'),
+			('t-2','inflow',10000,6000,4000,'','c-1','org-1','` + at(5, 14) + `'),
+			('t-3','inflow',2000,2000,0,'cash','c-1','org-1','` + at(5, 14) + `'),
+			('t-4','inflow',1500,1500,0,'cash','c-1','org-1','` + at(14, 9) + `'),
+			('p-1','outflow',3000,3000,0,'cash','c-1','org-1','` + at(4, 11) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,source,contact_id,organization_id,created_at) VALUES ('o-1','inflow',9900,0,9900,'opening','c-1','org-1','` + at(4, 8) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,payment_method,contact_id,voided_at,organization_id,created_at) VALUES ('v-1','inflow',7700,7700,0,'cash','c-1','` + at(6, 9) + `','org-1','` + at(4, 9) + `')`,
+		`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES
+			('ti-1','t-1','i-1',3,1500,4500),
+			('ti-2','t-2','i-2',2,5000,10000),
+			('ti-3','t-4','i-1',1,1500,1500),
+			('ti-4','v-1','i-1',5,1540,7700)`,
+		`INSERT INTO transaction_payments (id,transaction_id,method,amount,created_at) VALUES ('tp-1','t-2','bkash',60,'` + at(5, 14) + `')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	get := func(path string, query url.Values) (int, chartSeries) {
+		t.Helper()
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("from", time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local).Format(time.RFC3339))
+		query.Set("to", time.Date(2024, 4, 1, 0, 0, 0, 0, time.Local).Format(time.RFC3339))
+		req := httptest.NewRequest("GET", path+"?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out chartSeries
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	mix := func(s chartSeries) map[string]float64 {
+		m := map[string]float64{}
+		for i, l := range s.Labels {
+			m[l] = s.Datasets[0].Data[i]
+		}
+		return m
+	}
+
+	code, daily := get("/api/analytics/sales-trend", nil)
+	if code != 200 || len(daily.Labels) != 31 || daily.Labels[0] != "2024-03-01" || len(daily.Datasets) != 2 {
+		t.Fatalf("daily trend: %d %v", code, daily)
+	}
+	if sales, purchases := daily.Datasets[0].Data, daily.Datasets[1].Data; sales[3] != 45 || sales[4] != 120 || sales[13] != 15 || purchases[3] != 30 || sales[5] != 0 {
+		t.Errorf("daily sales %v, purchases %v", sales, purchases)
+	}
+	code, weekly := get("/api/analytics/sales-trend", url.Values{"interval": {"week"}})
+	if code != 200 || weekly.Labels[0] != "2024-02-25" || weekly.Labels[1] != "2024-03-03" || weekly.Datasets[0].Data[1] != 165 || weekly.Datasets[0].Data[2] != 15 {
+		t.Errorf("weekly trend: %d %v", code, weekly)
+	}
+	code, monthly := get("/api/analytics/sales-trend", url.Values{"interval": {"month"}})
+	if code != 200 || len(monthly.Labels) != 1 || monthly.Labels[0] != "2024-03" || monthly.Datasets[0].Data[0] != 180 {
+		t.Errorf("monthly trend: %d %v", code, monthly)
+	}
+	if code, _ := get("/api/analytics/sales-trend", url.Values{"interval": {"year"}}); code != 400 {
+		t.Errorf("yearly interval: got %d, want 400", code)
+	}
+
+	code, categories := get("/api/analytics/category-mix", nil)
+	if m := mix(categories); code != 200 || len(m) != 2 || m["Stationery"] != 60 || m["Uncategorized"] != 100 || categories.Labels[0] != "Uncategorized" {
+		t.Errorf("category mix: %d %v", code, categories)
+	}
+
+	code, methods := get("/api/analytics/payment-method-mix", nil)
+	if m := mix(methods); code != 200 || len(m) != 2 || m["cash"] != 80 || m["bkash"] != 60 {
+		t.Errorf("payment method mix: %d %v", code, methods)
+	}
+
+	code, heatmap := get("/api/analytics/hour-heatmap", nil)
+	if code != 200 || len(heatmap.Labels) != 24 || heatmap.Labels[9] != "09" || len(heatmap.Datasets) != 7 || heatmap.Datasets[1].Label != "Monday" {
+		t.Fatalf("hour heatmap: %d %v", code, heatmap)
+	}
+	if monday, tuesday, thursday := heatmap.Datasets[1].Data, heatmap.Datasets[2].Data, heatmap.Datasets[4].Data; monday[9] != 45 || monday[8] != 0 || tuesday[14] != 120 || thursday[9] != 15 {
+		t.Errorf("hour heatmap amounts: %v", heatmap.Datasets)
+	}
+	_, counts := get("/api/analytics/hour-heatmap", url.Values{"metric": {"count"}})
+	if tuesday := counts.Datasets[2].Data; tuesday[14] != 2 {
+		t.Errorf("sales on Tuesday at 14:00 = %v, want 2", tuesday[14])
+	}
+}
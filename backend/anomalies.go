@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Anomaly alerts flag activity worth a second look, as it happens:
+//
+//   - failed_logins: failed_login_alert_threshold failed sign-ins to one
+//     account within 15 minutes, raised in every organization the account
+//     belongs to.
+//   - void_spike: void_alert_threshold transactions voided by one user
+//     within an hour.
+//   - after_hours_stock: a stock adjustment, restock or stocktake recorded
+//     outside business_hours (in the business timezone).
+//
+// A threshold of 0 turns its check off, as does an empty business_hours.
+// The alerts join the others in /api/alerts and are sent to
+// anomaly_alert_email and anomaly_alert_webhook.
+
+const (
+	failedLoginWindow = 15 * time.Minute
+	voidSpikeWindow   = time.Hour
+)
+
+var anomalyKinds = []string{"failed_logins", "void_spike", "after_hours_stock"}
+
+// stock movement types that are counted adjustments rather than trade
+var adjustmentTypes = []string{"adjustment", "restock", "stocktake"}
+
+func isAnomalyKind(kind string) bool {
+	for _, k := range anomalyKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func isAdjustmentType(txType string) bool {
+	for _, t := range adjustmentTypes {
+		if t == txType {
+			return true
+		}
+	}
+	return false
+}
+
+// raiseAnomaly opens an alert and sends it out. Alerts counted over a
+// window are keyed by the window they fall in, so a burst raises one alert
+// and a later burst another even if the first is still unresolved.
+func raiseAnomaly(q *DB, orgID, kind, refID, message string, details fiber.Map) {
+	opened, err := raiseAlert(q, orgID, kind, refID, message, details)
+	if err != nil {
+		log.Printf("anomaly %s for %s: %v", kind, orgID, err)
+		return
+	}
+	if opened {
+		notifyAlerts(q, orgID, kind)
+	}
+}
+
+// recordLoginFailure keeps a failed sign-in and raises failed_logins
+// alerts once an account sees too many. userID is empty for unknown emails.
+func recordLoginFailure(c *fiber.Ctx, email, userID string) {
+	now := time.Now()
+	if _, err := dbFor(c).Exec(`INSERT INTO login_failures (id,email,user_id,ip,created_at) VALUES (?,?,NULLIF(?, ''),?,?)`, genID(), email, userID, c.IP(), now.Format(time.RFC3339)); err != nil {
+		log.Printf("login failures: %v", err)
+		return
+	}
+	if userID == "" {
+		return
+	}
+	var failures int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM login_failures WHERE user_id = ? AND created_at >= ?`, userID, now.Add(-failedLoginWindow).Format(time.RFC3339)).Scan(&failures); err != nil {
+		log.Printf("login failures: %v", err)
+		return
+	}
+	memberships, err := userMemberships(dbFor(c), userID)
+	if err != nil {
+		log.Printf("login failures: %v", err)
+		return
+	}
+	refID := userID + "@" + now.Truncate(failedLoginWindow).Format(time.RFC3339)
+	for _, m := range memberships {
+		orgID := toString(m["organization_id"])
+		threshold, _ := orgSetting(orgID, "failed_login_alert_threshold").(float64)
+		if threshold <= 0 || float64(failures) < threshold {
+			continue
+		}
+		msg := fmt.Sprintf("%d failed sign-ins to %s in the last %d minutes, latest from %s", failures, email, int(failedLoginWindow.Minutes()), c.IP())
+		raiseAnomaly(dbFor(c), orgID, "failed_logins", refID, msg, fiber.Map{"user_id": userID, "email": email, "failures": failures, "ip": c.IP()})
+	}
+}
+
+// userEmail is the email of userID for an alert message, or "" when it
+// cannot be found.
+func userEmail(q *DB, userID string) string {
+	var email string
+	if err := q.QueryRow(`SELECT email FROM users WHERE id = ?`, userID).Scan(&email); err != nil && err != sql.ErrNoRows {
+		log.Printf("email of user %s: %v", userID, err)
+	}
+	return email
+}
+
+// checkVoidSpike raises a void_spike alert when userID has voided too many
+// of orgID's transactions within the last hour.
+func checkVoidSpike(q *DB, orgID, userID string) {
+	threshold, _ := orgSetting(orgID, "void_alert_threshold").(float64)
+	if threshold <= 0 || userID == "" {
+		return
+	}
+	now := time.Now()
+	var voids int
+	var total money
+	if err := q.QueryRow(`SELECT COUNT(1), COALESCE(SUM(amount), 0) FROM transactions WHERE organization_id = ? AND voided_by = ? AND voided_at >= ?`,
+		orgID, userID, now.Add(-voidSpikeWindow).Format(time.RFC3339)).Scan(&voids, &total); err != nil {
+		log.Printf("void spike check for %s: %v", orgID, err)
+		return
+	}
+	if float64(voids) < threshold {
+		return
+	}
+	email := userEmail(q, userID)
+	msg := fmt.Sprintf("%s voided %d transactions worth %s in the last hour", email, voids, total)
+	raiseAnomaly(q, orgID, "void_spike", userID+"@"+now.Truncate(voidSpikeWindow).Format(time.RFC3339), msg, fiber.Map{"user_id": userID, "email": email, "voids": voids, "amount": total})
+}
+
+// outsideBusinessHours reports whether t falls outside orgID's
+// business_hours, {"open": "HH:MM", "close": "HH:MM"}. Hours that close
+// past midnight (open after close) are allowed.
+func outsideBusinessHours(orgID string, t time.Time) bool {
+	hours, _ := orgSetting(orgID, "business_hours").(map[string]interface{})
+	open, _ := hours["open"].(string)
+	closing, _ := hours["close"].(string)
+	if open == "" || closing == "" {
+		return false
+	}
+	now := t.In(businessLocation(orgID)).Format("15:04")
+	if open <= closing {
+		return now < open || now >= closing
+	}
+	return now < open && now >= closing
+}
+
+// validateBusinessHours checks a business_hours setting; an empty object
+// turns the after-hours check off.
+func validateBusinessHours(v interface{}) string {
+	hours, ok := v.(map[string]interface{})
+	if !ok {
+		return `business_hours must be {"open": "HH:MM", "close": "HH:MM"}`
+	}
+	if len(hours) == 0 {
+		return ""
+	}
+	for _, key := range []string{"open", "close"} {
+		s, _ := hours[key].(string)
+		if _, err := time.Parse("15:04", s); err != nil || len(s) != 5 {
+			return "business_hours: " + key + " must be a time such as 08:00"
+		}
+	}
+	return ""
+}
+
+// checkAfterHoursAdjustment raises an after_hours_stock alert for a stock
+// adjustment recorded now outside business hours. refID is the movement
+// (or stocktake) and what describes it.
+func checkAfterHoursAdjustment(q *DB, orgID, userID, refID, what string) {
+	now := time.Now()
+	if !outsideBusinessHours(orgID, now) {
+		return
+	}
+	email := userEmail(q, userID)
+	if email == "" {
+		email = "someone"
+	}
+	local := now.In(businessLocation(orgID)).Format("15:04")
+	msg := fmt.Sprintf("%s recorded %s at %s, outside business hours", email, what, local)
+	raiseAnomaly(q, orgID, "after_hours_stock", refID, msg, fiber.Map{"user_id": userID, "email": email, "local_time": local, "what": what})
+}
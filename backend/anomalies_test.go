@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAnomalyAlerts(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if jwtSecret == nil {
+		jwtSecret = []byte("test-secret")
+	}
+	app := newApp()
+	call := func(token, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	alerts := func(token, kind string) []interface{} {
+		t.Helper()
+		_, out := call(token, "GET", "/api/alerts?kind="+kind, "")
+		return out["items"].([]interface{})
+	}
+
+	_, reg := call("", "POST", "/api/auth/register", `{"email":"owner@example.com","password":"password1","name":"Owner"}`)
+	owner := reg["token"].(string)
+	if code, _ := call(owner, "PUT", "/api/settings/organization", `{"failed_login_alert_threshold":3,"void_alert_threshold":2}`); code != 200 {
+		t.Fatalf("settings: got %d", code)
+	}
+	for i := 0; i < 2; i++ {
+		call("", "POST", "/api/auth/login", `{"email":"owner@example.com","password":"wrong"}`)
+	}
+	call("", "POST", "/api/auth/login", `{"email":"nobody@example.com","password":"wrong"}`)
+	if got := alerts(owner, "failed_logins"); len(got) != 0 {
+		t.Errorf("alerts below the threshold: %v", got)
+	}
+	for i := 0; i < 2; i++ {
+		call("", "POST", "/api/auth/login", `{"email":"owner@example.com","password":"wrong"}`)
+	}
+	got := alerts(owner, "failed_logins")
+	if len(got) != 1 || !strings.Contains(got[0].(map[string]interface{})["message"].(string), "3 failed sign-ins to owner@example.com") {
+		t.Errorf("failed login alerts: %v", got)
+	}
+
+	// voids by one user
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := db.Exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) SELECT 't-' || n, 'inflow', 10000, 10000, 0, '', organization_id, ? FROM (SELECT 1 AS n UNION SELECT 2 UNION SELECT 3), users WHERE email = 'owner@example.com'`, now); err != nil {
+		t.Fatal(err)
+	}
+	void := func(filter string) {
+		t.Helper()
+		_, preview := call(owner, "POST", "/api/bulk/transactions", `{"action":"void","filter":"`+filter+`"}`)
+		if code, out := call(owner, "POST", "/api/bulk/transactions", `{"action":"void","filter":"`+filter+`","dry_run":false,"confirm_token":"`+toString(preview["confirm_token"])+`"}`); code != 200 {
+			t.Fatalf("void %s: %d %v", filter, code, out)
+		}
+	}
+	void("id = 't-1'")
+	if got := alerts(owner, "void_spike"); len(got) != 0 {
+		t.Errorf("one void raised %v", got)
+	}
+	void("id = 't-2' || id = 't-3'")
+	got = alerts(owner, "void_spike")
+	if len(got) != 1 || !strings.Contains(got[0].(map[string]interface{})["message"].(string), "voided 3 transactions worth 300.00") {
+		t.Errorf("void spike alerts: %v", got)
+	}
+
+	// stock adjustments outside business hours; open and close at the same
+	// time leaves no hours open
+	if _, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) SELECT 'i-1','Pen','PEN',10,15,organization_id FROM users WHERE email = 'owner@example.com'`); err != nil {
+		t.Fatal(err)
+	}
+	adjust := `{"item_id":"i-1","quantity_change":-2,"previous_quantity":10,"new_quantity":8,"transaction_type":"adjustment"}`
+	if code, _ := call(owner, "PUT", "/api/settings/organization", `{"business_hours":{"open":"25:00","close":"22:00"}}`); code != 400 {
+		t.Errorf("invalid business_hours: got %d, want 400", code)
+	}
+	if code, _ := call(owner, "PUT", "/api/settings/organization", `{"business_hours":{}}`); code != 200 {
+		t.Fatalf("clear business_hours: got %d", code)
+	}
+	call(owner, "POST", "/api/collections/inventory_transactions/records", adjust)
+	if got := alerts(owner, "after_hours_stock"); len(got) != 0 {
+		t.Errorf("adjustment with no business hours set raised %v", got)
+	}
+	call(owner, "PUT", "/api/settings/organization", `{"business_hours":{"open":"00:00","close":"00:00"}}`)
+	call(owner, "POST", "/api/collections/inventory_transactions/records", strings.Replace(adjust, "adjustment", "sale", 1))
+	call(owner, "POST", "/api/collections/inventory_transactions/records", adjust)
+	got = alerts(owner, "after_hours_stock")
+	if len(got) != 1 || !strings.Contains(got[0].(map[string]interface{})["message"].(string), "owner@example.com recorded a stock adjustment of -2") {
+		t.Errorf("after hours alerts: %v", got)
+	}
+}
+
+func TestOutsideBusinessHours(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO settings (scope,scope_id,key,value) VALUES ('organization','org-1','timezone','"UTC"'),('organization','org-2','timezone','"UTC"'),('organization','org-2','business_hours','{"open":"18:00","close":"02:00"}')`); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		org, at string
+		want    bool
+	}{
+		{"org-1", "07:59", true},
+		{"org-1", "08:00", false},
+		{"org-1", "21:59", false},
+		{"org-1", "22:00", true},
+		{"org-2", "17:00", true},
+		{"org-2", "23:00", false},
+		{"org-2", "01:00", false},
+		{"org-2", "03:00", true},
+	} {
+		at, _ := time.Parse("2006-01-02 15:04", "2024-03-10 "+tc.at)
+		if got := outsideBusinessHours(tc.org, at); got != tc.want {
+			t.Errorf("%s at %s: got %v, want %v", tc.org, tc.at, got, tc.want)
+		}
+	}
+}
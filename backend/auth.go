@@ -0,0 +1,426 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Authentication uses short-lived HS256 access tokens plus long-lived,
+// single-use refresh tokens stored (hashed) in refresh_tokens.
+//
+// Configuration:
+//
+//	JWT_SECRET               signing key; a random key is generated when
+//	                         unset, which logs everyone out on restart
+//	JWT_EXPIRY_MINUTES       access token lifetime (default 60)
+//	JWT_REFRESH_EXPIRY_DAYS  refresh token lifetime (default 30)
+
+var jwtSecret []byte
+
+func initAuth() {
+	if s := os.Getenv("JWT_SECRET"); s != "" {
+		jwtSecret = []byte(s)
+		return
+	}
+	jwtSecret = make([]byte, 32)
+	if _, err := rand.Read(jwtSecret); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("JWT_SECRET not set; using a random key, tokens will not survive a restart")
+}
+
+func envDuration(name string, def int, unit time.Duration) time.Duration {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return time.Duration(v) * unit
+	}
+	return time.Duration(def) * unit
+}
+
+func accessTokenTTL() time.Duration  { return envDuration("JWT_EXPIRY_MINUTES", 60, time.Minute) }
+func refreshTokenTTL() time.Duration { return envDuration("JWT_REFRESH_EXPIRY_DAYS", 30, 24*time.Hour) }
+
+func registerAuthRoutes(app *fiber.App) {
+	r := app.Group("/api/auth")
+	r.Post("/register", handleRegister)
+	r.Post("/login", handleLogin)
+	r.Post("/refresh", handleRefresh)
+	r.Post("/logout", handleLogout)
+	r.Get("/me", requireAuth, handleMe)
+}
+
+// ---------- Passwords ----------
+
+const passwordIterations = 120000
+
+// hashPassword derives a PBKDF2-SHA256 key and encodes it together with
+// its parameters as pbkdf2_sha256$iterations$salt$hash.
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2SHA256([]byte(password), salt, passwordIterations, 32)
+	return fmt.Sprintf("pbkdf2_sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func checkPassword(encoded, password string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2_sha256" {
+		return false
+	}
+	iter, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+	salt, err1 := base64.RawStdEncoding.DecodeString(parts[2])
+	want, err2 := base64.RawStdEncoding.DecodeString(parts[3])
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	got := pbkdf2SHA256([]byte(password), salt, iter, len(want))
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+// pbkdf2SHA256 implements RFC 8018 PBKDF2 with HMAC-SHA256.
+func pbkdf2SHA256(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var out []byte
+	for block := uint32(1); len(out) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], block)
+		prf.Write(b[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iter; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		out = append(out, t...)
+	}
+	return out[:keyLen]
+}
+
+// ---------- Tokens ----------
+
+type authClaims struct {
+	Subject string `json:"sub"`
+	OrgID   string `json:"org,omitempty"`
+	Role    string `json:"role"`
+	Expires int64  `json:"exp"`
+	Issued  int64  `json:"iat"`
+}
+
+func signToken(claims authClaims) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(body)
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+func parseToken(token string) (authClaims, error) {
+	var claims authClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	hb, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(hb, &header) != nil || header.Alg != "HS256" {
+		return claims, errInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return claims, errInvalidToken
+	}
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return claims, errInvalidToken
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(body, &claims) != nil {
+		return claims, errInvalidToken
+	}
+	if time.Now().Unix() >= claims.Expires {
+		return claims, errors.New("token expired")
+	}
+	return claims, nil
+}
+
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueTokens creates an access token and a new refresh token for a user
+// acting in orgID.
+func issueTokens(q *DB, userID, orgID, role string) (fiber.Map, error) {
+	now := time.Now()
+	access, err := signToken(authClaims{Subject: userID, OrgID: orgID, Role: role, Issued: now.Unix(), Expires: now.Add(accessTokenTTL()).Unix()})
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	refresh := base64.RawURLEncoding.EncodeToString(raw)
+	refreshExpires := now.Add(refreshTokenTTL())
+	if _, err := q.Exec(`INSERT INTO refresh_tokens (id,user_id,organization_id,expires_at,created_at) VALUES (?,?,?,?,?)`,
+		hashRefreshToken(refresh), userID, orgID, refreshExpires.Format(time.RFC3339), now.Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+	return fiber.Map{
+		"token":              access,
+		"expires_at":         now.Add(accessTokenTTL()).Format(time.RFC3339),
+		"refresh_token":      refresh,
+		"refresh_expires_at": refreshExpires.Format(time.RFC3339),
+	}, nil
+}
+
+// requireAuth rejects requests without a valid bearer token and stores the
+// caller's identity in the context for currentUserID / currentOrgID.
+func requireAuth(c *fiber.Ctx) error {
+	header := c.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return c.Status(401).JSON(fiber.Map{"error": "authentication required"})
+	}
+	claims, err := parseToken(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": err.Error()})
+	}
+	// the token is the only source of the organization; a token without
+	// one would leave every query unscoped
+	if claims.Subject == "" || claims.OrgID == "" {
+		return c.Status(401).JSON(fiber.Map{"error": "token has no organization"})
+	}
+	c.Locals("userID", claims.Subject)
+	c.Locals("orgID", claims.OrgID)
+	c.Locals("role", claims.Role)
+	// viewers can still manage their own session
+	if claims.Role == "viewer" && c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead && !strings.HasPrefix(c.Path(), "/api/auth/") {
+		return c.Status(403).JSON(fiber.Map{"error": "viewers cannot make changes"})
+	}
+	if status, msg := sessionAllowed(c, claims); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	return c.Next()
+}
+
+// ---------- Handlers ----------
+
+type credentials struct {
+	Email            string `json:"email"`
+	Password         string `json:"password"`
+	Name             string `json:"name"`
+	OrganizationName string `json:"organization_name"`
+}
+
+func handleRegister(c *fiber.Ctx) error {
+	var req credentials
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	if req.Email == "" || !strings.Contains(req.Email, "@") {
+		return c.Status(400).JSON(fiber.Map{"error": "valid email required"})
+	}
+	if len(req.Password) < 8 {
+		return c.Status(400).JSON(fiber.Map{"error": "password must be at least 8 characters"})
+	}
+	var exists int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM users WHERE email = ?`, req.Email).Scan(&exists); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if exists > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "email already registered"})
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	// the first account takes over the organization that holds existing
+	// data; every later registration starts a new organization. Either way
+	// the new user administers it.
+	id := genID()
+	var orgID string
+	var users int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM users`).Scan(&users); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if users == 0 {
+		if orgID, err = firstOrganizationID(dbFor(c)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if orgID == "" {
+		name := req.OrganizationName
+		if name == "" {
+			name = req.Name
+		}
+		if orgID, err = createOrganization(dbFor(c), name, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	role := "admin"
+	now := time.Now().Format(time.RFC3339)
+	if _, err := dbFor(c).Exec(`INSERT INTO users (id,email,password_hash,name,organization_id,role,created_at) VALUES (?,?,?,?,?,?,?)`,
+		id, req.Email, hash, req.Name, orgID, role, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := addMember(dbFor(c), id, orgID, role); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status, msg := checkSessionAccess(c, id, orgID, role); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	tokens, err := issueTokens(dbFor(c), id, orgID, role)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	tokens["user"] = fiber.Map{"id": id, "email": req.Email, "name": req.Name, "organization_id": orgID, "role": role}
+	return c.Status(201).JSON(tokens)
+}
+
+func handleLogin(c *fiber.Ctx) error {
+	var req credentials
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	var id, hash, name, home string
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	err := dbFor(c).QueryRow(`SELECT id, password_hash, COALESCE(name,''), COALESCE(organization_id,'') FROM users WHERE email = ?`, email).Scan(&id, &hash, &name, &home)
+	if err == sql.ErrNoRows || (err == nil && !checkPassword(hash, req.Password)) {
+		recordLoginFailure(c, email, id)
+		return c.Status(401).JSON(fiber.Map{"error": "invalid email or password"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	orgID, role, err := loginMembership(dbFor(c), id, home)
+	if err == sql.ErrNoRows {
+		return c.Status(403).JSON(fiber.Map{"error": "you are not a member of any organization"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status, msg := checkSessionAccess(c, id, orgID, role); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	tokens, err := issueTokens(dbFor(c), id, orgID, role)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	tokens["user"] = fiber.Map{"id": id, "email": email, "name": name, "organization_id": orgID, "role": role}
+	return c.JSON(tokens)
+}
+
+// handleRefresh exchanges a refresh token for a new token pair. Refresh
+// tokens are single use: the presented one is revoked.
+func handleRefresh(c *fiber.Ctx) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	var userID, orgID, expiresAt string
+	var revoked sql.NullString
+	err := dbFor(c).QueryRow(`SELECT user_id, COALESCE(organization_id,''), expires_at, revoked_at FROM refresh_tokens WHERE id = ?`, hashRefreshToken(req.RefreshToken)).Scan(&userID, &orgID, &expiresAt, &revoked)
+	if err == sql.ErrNoRows {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid refresh token"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if exp, _ := time.Parse(time.RFC3339, expiresAt); revoked.Valid || time.Now().After(exp) {
+		return c.Status(401).JSON(fiber.Map{"error": "refresh token expired"})
+	}
+	if _, err := dbFor(c).Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE id = ?`, time.Now().Format(time.RFC3339), hashRefreshToken(req.RefreshToken)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	// the role is looked up again so changes apply; a user removed from the
+	// organization has to log in again
+	role, err := memberRole(dbFor(c), userID, orgID)
+	if err == sql.ErrNoRows {
+		return c.Status(401).JSON(fiber.Map{"error": "no longer a member of this organization"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status, msg := checkSessionAccess(c, userID, orgID, role); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	tokens, err := issueTokens(dbFor(c), userID, orgID, role)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(tokens)
+}
+
+func handleLogout(c *fiber.Ctx) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if _, err := dbFor(c).Exec(`UPDATE refresh_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now().Format(time.RFC3339), hashRefreshToken(req.RefreshToken)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"ok": true})
+}
+
+func handleMe(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT id,email,name,created_at FROM users WHERE id = ?`, currentUserID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(items) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	me := items[0]
+	me["organization_id"] = currentOrgID(c)
+	me["role"] = currentRole(c)
+	if me["organizations"], err = userMemberships(dbFor(c), currentUserID(c)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(me)
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Items can be labelled with their SKU as a barcode:
+//
+//	GET /api/inventory_items/:id/barcode?format=code128|qr&type=png|svg
+//	GET /api/inventory_items/labels.pdf?ids=a,b,c&format=code128|qr&copies=n
+//
+// The first returns one barcode image (Code 128 and PNG by default, scale
+// sets the pixels per module of a PNG); the second an A4 sheet of shelf
+// labels, 3 by 8 to a page, with name, barcode, SKU and price. Both are
+// drawn here rather than by a library, like the PDFs in pdf.go.
+//
+// GET /api/inventory_items/lookup?code=... turns a scan back into the item
+// with one query on the (organization_id, sku) index, or on the item's
+// other codes (item_codes.go), so a till adds scanned items without
+// loading the item list.
+
+const labelsMaxItems = 500
+
+// code128Patterns are the bar and space widths of the Code 128 symbols,
+// starting with a bar; 103-105 are the start codes A, B and C, 106 stop.
+var code128Patterns = [107]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+// encodeCode128 returns the modules of s as Code 128, dark being true,
+// without quiet zones. All-digit text of even length uses code set C,
+// which packs two digits a symbol; anything else code set B.
+func encodeCode128(s string) ([]bool, error) {
+	if s == "" {
+		return nil, errors.New("nothing to encode")
+	}
+	var values []int
+	if len(s)%2 == 0 && strings.Trim(s, "0123456789") == "" {
+		values = append(values, 105)
+		for i := 0; i < len(s); i += 2 {
+			values = append(values, int(s[i]-'0')*10+int(s[i+1]-'0'))
+		}
+	} else {
+		values = append(values, 104)
+		for _, r := range s {
+			if r < 32 || r > 126 {
+				return nil, fmt.Errorf("%q cannot be encoded in Code 128", r)
+			}
+			values = append(values, int(r-32))
+		}
+	}
+	check := values[0]
+	for i, v := range values[1:] {
+		check += (i + 1) * v
+	}
+	values = append(values, check%103, 106)
+	var modules []bool
+	for _, v := range values {
+		for i, w := range code128Patterns[v] {
+			for n := 0; n < int(w-'0'); n++ {
+				modules = append(modules, i%2 == 0)
+			}
+		}
+	}
+	return modules, nil
+}
+
+// barcodeMatrix returns the modules of s in format as rows, with the quiet
+// zone the format asks for. A Code 128 symbol is a single row.
+func barcodeMatrix(format, s string) ([][]bool, error) {
+	switch format {
+	case "code128":
+		bars, err := encodeCode128(s)
+		if err != nil {
+			return nil, err
+		}
+		row := make([]bool, 10, len(bars)+20)
+		row = append(append(row, bars...), make([]bool, 10)...)
+		return [][]bool{row}, nil
+	case "qr":
+		modules, err := encodeQR(s)
+		if err != nil {
+			return nil, err
+		}
+		const quiet = 4
+		rows := make([][]bool, len(modules)+2*quiet)
+		for i := range rows {
+			rows[i] = make([]bool, len(modules)+2*quiet)
+			if i >= quiet && i < quiet+len(modules) {
+				copy(rows[i][quiet:], modules[i-quiet])
+			}
+		}
+		return rows, nil
+	}
+	return nil, errors.New("format must be code128 or qr")
+}
+
+// barcodePNG draws rows at scale pixels per module; a single row (Code
+// 128) is drawn height modules high.
+func barcodePNG(rows [][]bool, scale, height int) ([]byte, error) {
+	width := len(rows[0])
+	if len(rows) > 1 {
+		height = len(rows)
+	}
+	img := image.NewGray(image.Rect(0, 0, width*scale, height*scale))
+	for y := 0; y < height*scale; y++ {
+		row := rows[0]
+		if len(rows) > 1 {
+			row = rows[y/scale]
+		}
+		for x := 0; x < width*scale; x++ {
+			if row[x/scale] {
+				img.SetGray(x, y, color.Gray{})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	var out bytes.Buffer
+	err := png.Encode(&out, img)
+	return out.Bytes(), err
+}
+
+// barcodeSVG draws rows in module units, merging runs of dark modules.
+func barcodeSVG(rows [][]bool, height int) []byte {
+	width := len(rows[0])
+	rowHeight := height
+	if len(rows) > 1 {
+		height, rowHeight = len(rows), 1
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`, width, height, width*4, height*4)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, width, height)
+	for y, row := range rows {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv%dh-%dz", start, y, x-start, rowHeight, x-start)
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes()
+}
+
+func registerBarcodeRoutes(app *fiber.App) {
+	app.Get("/api/inventory_items/lookup", requireAuth, handleItemLookup)
+	app.Get("/api/inventory_items/labels.pdf", requireAuth, handleLabelSheet)
+	app.Get("/api/inventory_items/:id/barcode", requireAuth, handleItemBarcode)
+}
+
+// scannedItem is an item found by one of its codes; a code standing for
+// one of the item's units says which.
+type scannedItem struct {
+	Item
+	ScannedUnit string `json:"scanned_unit,omitempty"`
+}
+
+// handleItemLookup returns the item whose SKU, or other code, is code.
+// Several items with the same code answer 409 with their ids, so the till
+// can ask which.
+func handleItemLookup(c *fiber.Ctx) error {
+	code := strings.TrimSpace(c.Query("code"))
+	if code == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "code is required"})
+	}
+	items, err := Items(dbFor(c)).BySKU(c.UserContext(), currentOrgID(c), code)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	found := make([]scannedItem, 0, 2)
+	for _, it := range items {
+		found = append(found, scannedItem{Item: it})
+	}
+	if len(found) < 2 {
+		it, unit, err := Items(dbFor(c)).ByCode(c.UserContext(), currentOrgID(c), code)
+		switch {
+		case err == nil:
+			found = append(found, scannedItem{Item: it, ScannedUnit: unit})
+		case err != errNotFound:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	switch len(found) {
+	case 0:
+		return c.Status(404).JSON(fiber.Map{"error": "no item with code " + code})
+	case 1:
+		return c.JSON(found[0])
+	}
+	return c.Status(409).JSON(fiber.Map{"error": "several items have code " + code, "ids": []string{found[0].ID, found[1].ID}})
+}
+
+func handleItemBarcode(c *fiber.Ctx) error {
+	it, err := Items(dbFor(c)).Get(c.UserContext(), currentOrgID(c), c.Params("id"))
+	if err != nil {
+		return recordError(c, err)
+	}
+	if it.SKU == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "item has no SKU"})
+	}
+	rows, err := barcodeMatrix(c.Query("format", "code128"), it.SKU)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	switch c.Query("type", "png") {
+	case "svg":
+		c.Set("Content-Type", "image/svg+xml")
+		return c.Send(barcodeSVG(rows, 40))
+	case "png":
+		scale, err := strconv.Atoi(c.Query("scale", "4"))
+		if err != nil || scale < 1 || scale > 20 {
+			return c.Status(400).JSON(fiber.Map{"error": "scale must be 1 to 20"})
+		}
+		data, err := barcodePNG(rows, scale, 40)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		c.Set("Content-Type", "image/png")
+		return c.Send(data)
+	}
+	return c.Status(400).JSON(fiber.Map{"error": "type must be png or svg"})
+}
+
+func handleLabelSheet(c *fiber.Ctx) error {
+	var ids []string
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 || len(ids) > labelsMaxItems {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("ids must list 1 to %d items", labelsMaxItems)})
+	}
+	copies, err := strconv.Atoi(c.Query("copies", "1"))
+	if err != nil || copies < 1 || copies > 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "copies must be 1 to 100"})
+	}
+	format := c.Query("format", "code128")
+	var labels []shelfLabel
+	for _, id := range ids {
+		it, err := Items(dbFor(c)).Get(c.UserContext(), currentOrgID(c), id)
+		if err == errNotFound {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown item " + id})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if it.SKU == "" {
+			return c.Status(400).JSON(fiber.Map{"error": it.Name + " has no SKU"})
+		}
+		rows, err := barcodeMatrix(format, it.SKU)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": it.SKU + ": " + err.Error()})
+		}
+		for n := 0; n < copies; n++ {
+			labels = append(labels, shelfLabel{name: it.Name, sku: it.SKU, price: it.UnitPrice, rows: rows})
+		}
+	}
+	c.Set("Content-Type", "application/pdf")
+	c.Set("Content-Disposition", `inline; filename="labels.pdf"`)
+	return c.Send(renderLabelSheet(labels))
+}
+
+type shelfLabel struct {
+	name, sku string
+	price     float64
+	rows      [][]bool
+}
+
+// renderLabelSheet lays labels out 3 across and 8 down on A4 pages.
+func renderLabelSheet(labels []shelfLabel) []byte {
+	const cols, perPage = 3, 24
+	const margin = 20.0
+	labelW := (pdfPageWidth - 2*margin) / cols
+	labelH := (pdfPageHeight - 2*margin) / (perPage / cols)
+	d := newPDF()
+	for i, l := range labels {
+		if i > 0 && i%perPage == 0 {
+			d.addPage()
+		}
+		x := margin + float64(i%perPage%cols)*labelW + 8
+		y := margin + float64(i%perPage/cols)*labelH + 6
+		w := labelW - 16
+		d.text(x, y+10, 9, true, pdfFit(l.name, 9, w))
+		// the barcode fills the middle, keeping modules square for QR
+		top, h := y+16, labelH-44
+		module := w / float64(len(l.rows[0]))
+		rowH := h
+		if len(l.rows) > 1 {
+			module = h / float64(len(l.rows))
+			rowH = module
+		}
+		for r, row := range l.rows {
+			for c := 0; c < len(row); c++ {
+				if !row[c] {
+					continue
+				}
+				start := c
+				for c < len(row) && row[c] {
+					c++
+				}
+				d.rect(x+float64(start)*module, top+float64(r)*rowH, float64(c-start)*module, rowH)
+			}
+		}
+		d.text(x, y+labelH-16, 8, false, pdfFit(l.sku, 8, w/2))
+		d.textRight(x+w, y+labelH-16, 10, true, invoiceMoney(l.price))
+	}
+	return d.bytes()
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at 1-M, the standard's worked example
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomon(data, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestQRFunctionPatterns(t *testing.T) {
+	modules, err := encodeQR("TEA-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != 21 {
+		t.Fatalf("size %d, want version 1", len(modules))
+	}
+	// the top-left finder and the timing row
+	for c, want := range []bool{true, true, true, true, true, true, true, false} {
+		if modules[0][c] != want || modules[c][0] != want {
+			t.Errorf("finder edge at %d", c)
+		}
+	}
+	for c := 8; c < 13; c++ {
+		if modules[6][c] != (c%2 == 0) {
+			t.Errorf("timing module %d", c)
+		}
+	}
+	// both copies of the format information carry a valid level M word
+	formats := map[string]bool{"101010000010010": true, "101000100100101": true, "101111001111100": true, "101101101001011": true,
+		"100010111111001": true, "100000011001110": true, "100111110010111": true, "100101010100000": true}
+	var first, second string
+	bit := func(dark bool) string {
+		if dark {
+			return "1"
+		}
+		return "0"
+	}
+	for c := 0; c < 6; c++ {
+		first += bit(modules[8][c])
+	}
+	first += bit(modules[8][7]) + bit(modules[8][8]) + bit(modules[7][8])
+	for r := 5; r >= 0; r-- {
+		first += bit(modules[r][8])
+	}
+	for r := 20; r > 13; r-- {
+		second += bit(modules[r][8])
+	}
+	for c := 13; c < 21; c++ {
+		second += bit(modules[8][c])
+	}
+	if !formats[first] || first != second {
+		t.Errorf("format information %s / %s", first, second)
+	}
+
+	q := newQRCode(7)
+	var version int
+	for i := 0; i < 18; i++ {
+		if q.modules[i/3][q.size-11+i%3] {
+			version |= 1 << uint(i)
+		}
+	}
+	if version != 0x07C94 {
+		t.Errorf("version 7 information %#x, want 0x7c94", version)
+	}
+	if _, err := encodeQR(strings.Repeat("x", 214)); err != errQRTooLong {
+		t.Errorf("214 bytes: %v", err)
+	}
+}
+
+func TestCode128(t *testing.T) {
+	for i, p := range code128Patterns {
+		sum := 0
+		for _, w := range p {
+			sum += int(w - '0')
+		}
+		want := 11
+		if i == 106 {
+			want = 13 // the stop symbol ends in a two-module bar
+		}
+		if sum != want {
+			t.Errorf("pattern %d is %d modules wide", i, sum)
+		}
+	}
+	// start B, A, B, check digit (104 + 33 + 2*34) % 103 = 102, stop
+	got, err := encodeCode128("AB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []bool
+	for _, v := range []int{104, 33, 34, 102, 106} {
+		for i, w := range code128Patterns[v] {
+			for n := 0; n < int(w-'0'); n++ {
+				want = append(want, i%2 == 0)
+			}
+		}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("AB encoded wrongly")
+	}
+	// even-length digits pack two to a symbol in code set C
+	if digits, _ := encodeCode128("123456"); len(digits) != 11*(1+3+1)+13 {
+		t.Errorf("123456 is %d modules, want set C", len(digits))
+	}
+	if _, err := encodeCode128("চা"); err == nil {
+		t.Error("non-ASCII text encoded")
+	}
+}
+
+func TestItemBarcodes(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Tea','TEA-001',10,5,'org-1'),('i-2','Loose sugar','',7,3,'org-1'),('i-3','Salt','SALT',4,2,'org-2')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	get := func(path string) (int, string, []byte) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "viewer"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("Content-Type"), body
+	}
+
+	code, ctype, body := get("/api/inventory_items/i-1/barcode?format=qr&scale=2")
+	if code != 200 || ctype != "image/png" {
+		t.Fatalf("qr png: %d %s", code, ctype)
+	}
+	img, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 2*(21+8) || b.Dy() != b.Dx() {
+		t.Errorf("qr png is %v", b)
+	}
+	code, ctype, body = get("/api/inventory_items/i-1/barcode?type=svg")
+	if code != 200 || ctype != "image/svg+xml" || !bytes.HasPrefix(body, []byte("<svg")) {
+		t.Errorf("code128 svg: %d %s %.40s", code, ctype, body)
+	}
+	for path, want := range map[string]int{
+		"/api/inventory_items/i-2/barcode":             400,
+		"/api/inventory_items/i-3/barcode":             404,
+		"/api/inventory_items/i-1/barcode?format=ean8": 400,
+		"/api/inventory_items/i-1/barcode?type=gif":    400,
+		"/api/inventory_items/labels.pdf?ids=i-1,i-2":  400,
+		"/api/inventory_items/labels.pdf?ids=i-3":      400,
+		"/api/inventory_items/labels.pdf":              400,
+	} {
+		if code, _, _ := get(path); code != want {
+			t.Errorf("%s: got %d, want %d", path, code, want)
+		}
+	}
+
+	// 25 labels take a second page
+	code, ctype, body = get("/api/inventory_items/labels.pdf?ids=i-1&copies=25&format=qr")
+	if code != 200 || ctype != "application/pdf" || !bytes.HasPrefix(body, []byte("%PDF")) {
+		t.Fatalf("labels: %d %s", code, ctype)
+	}
+	if !bytes.Contains(body, []byte("/Count 2")) || !bytes.Contains(body, []byte("(TEA-001)")) {
+		t.Error("label sheet is missing a page or the SKU")
+	}
+}
+
+func TestItemLookup(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Tea','8901234567890',10,5,'org-1'),('i-2','Sugar','DUP',7,3,'org-1'),('i-3','Salt','DUP',4,2,'org-1'),('i-4','Rice','RICE',4,2,'org-2')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	get := func(path string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "cashier"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	if code, out := get("/api/inventory_items/lookup?code=%208901234567890%0A"); code != 200 || out["id"] != "i-1" || out["quantity"] != 10.0 {
+		t.Errorf("lookup: %d %v", code, out)
+	}
+	if code, out := get("/api/inventory_items/lookup?code=DUP"); code != 409 || len(out["ids"].([]interface{})) != 2 {
+		t.Errorf("shared SKU: %d %v", code, out)
+	}
+	for path, want := range map[string]int{
+		"/api/inventory_items/lookup?code=RICE": 404,
+		"/api/inventory_items/lookup":           400,
+	} {
+		if code, _ := get(path); code != want {
+			t.Errorf("%s: got %d, want %d", path, code, want)
+		}
+	}
+}
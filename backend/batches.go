@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Perishable stock is tracked in batches. Every purchase (a transaction,
+// an import or a purchase order delivery) puts what it received into a
+// new batch with a lot number and, if given, an expiry date; lines carry
+// lot_number and expiry_date, and a missing lot number is issued from the
+// "batch" sequence. Sales take units from the batch expiring first
+// (FEFO), batches without an expiry last. Stock that is in no batch, such
+// as what was on hand before batches existed, is sold after every batch;
+// POST /api/batches puts such stock into a batch.
+//
+// GET /api/batches/expiring?days=N lists what is left of batches expiring
+// within N days (and those already expired), so it can be discounted
+// before it goes to waste, and POST /api/batches/:id/write-off takes
+// spoiled units out of stock.
+
+func registerBatchRoutes(app *fiber.App) {
+	r := app.Group("/api/batches", requireAuth)
+	r.Get("/", handleListBatches)
+	r.Get("/expiring", handleExpiringBatches)
+	r.Post("/", requireRole("admin", "manager"), handleCreateBatch)
+	r.Post("/:id/write-off", requireRole("admin", "manager"), handleWriteOffBatch)
+}
+
+// validExpiryDate reports whether s is empty or a YYYY-MM-DD date.
+func validExpiryDate(s string) bool {
+	if s == "" {
+		return true
+	}
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
+
+// receiveBatch records quantity units of itemID received by transactionID
+// as a new batch and returns its id and lot number. The expiry date is the
+// caller's to validate.
+func receiveBatch(tx *Tx, orgID, itemID, transactionID string, quantity int, unitCost float64, lotNumber, expiryDate string) (string, string, error) {
+	lotNumber = strings.TrimSpace(lotNumber)
+	if lotNumber == "" {
+		var err error
+		if lotNumber, err = nextSequenceNumber(tx, orgID, "batch"); err != nil {
+			return "", "", err
+		}
+	}
+	id, now := genID(), time.Now().Format(time.RFC3339)
+	if _, err := tx.Exec(`INSERT INTO item_batches (id,organization_id,item_id,lot_number,expiry_date,received_quantity,quantity,unit_cost,transaction_id,received_at) VALUES (?,?,?,?,NULLIF(?, ''),?,?,?,NULLIF(?, ''),?)`,
+		id, orgID, itemID, lotNumber, expiryDate, quantity, quantity, unitCost, transactionID, now); err != nil {
+		return "", "", err
+	}
+	if _, err := tx.Exec(`INSERT INTO batch_movements (id,batch_id,transaction_id,quantity,created_at) VALUES (?,?,NULLIF(?, ''),?,?)`, genID(), id, transactionID, quantity, now); err != nil {
+		return "", "", err
+	}
+	return id, lotNumber, nil
+}
+
+// consumeBatches takes quantity units of itemID out of its batches, the
+// one expiring first first, on behalf of transactionID ("" for stock
+// movements outside a transaction). Units beyond what the batches hold
+// come from stock in no batch.
+func consumeBatches(tx *Tx, itemID, transactionID string, quantity int) error {
+	if quantity <= 0 {
+		return nil
+	}
+	rows, err := tx.Query(`SELECT id, quantity FROM item_batches WHERE item_id = ? AND quantity > 0
+		ORDER BY CASE WHEN expiry_date IS NULL THEN 1 ELSE 0 END, expiry_date, received_at, id`, itemID)
+	if err != nil {
+		return err
+	}
+	type take struct {
+		id       string
+		quantity int
+	}
+	var takes []take
+	for rows.Next() && quantity > 0 {
+		var t take
+		if err := rows.Scan(&t.id, &t.quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		if t.quantity > quantity {
+			t.quantity = quantity
+		}
+		quantity -= t.quantity
+		takes = append(takes, t)
+	}
+	rows.Close()
+	now := time.Now().Format(time.RFC3339)
+	for _, t := range takes {
+		if _, err := tx.Exec(`UPDATE item_batches SET quantity = quantity - ? WHERE id = ?`, t.quantity, t.id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO batch_movements (id,batch_id,transaction_id,quantity,created_at) VALUES (?,?,NULLIF(?, ''),?,?)`, genID(), t.id, transactionID, -t.quantity, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reverseBatchMovements undoes what transactionID did to batches, for a
+// void or delete: sold units go back to their batch and received units
+// leave it.
+func reverseBatchMovements(tx *Tx, transactionID string) error {
+	if _, err := tx.Exec(`UPDATE item_batches SET quantity = CASE WHEN quantity - m.total < 0 THEN 0 ELSE quantity - m.total END
+		FROM (SELECT batch_id, SUM(quantity) AS total FROM batch_movements WHERE transaction_id = ? GROUP BY batch_id) m
+		WHERE item_batches.id = m.batch_id`, transactionID); err != nil {
+		return err
+	}
+	_, err := tx.Exec(`DELETE FROM batch_movements WHERE transaction_id = ?`, transactionID)
+	return err
+}
+
+// unwindBatches undoes quantity units of what transactionID did to
+// itemID's batches, latest batch first, when an edit takes units off a
+// line: units it sold go back to their batch and units it received leave
+// it.
+func unwindBatches(tx *Tx, itemID, transactionID string, quantity int) error {
+	rows, err := tx.Query(`SELECT m.batch_id, SUM(m.quantity) FROM batch_movements m JOIN item_batches b ON b.id = m.batch_id
+		WHERE m.transaction_id = ? AND b.item_id = ? GROUP BY m.batch_id ORDER BY MAX(m.created_at) DESC, m.batch_id DESC`, transactionID, itemID)
+	if err != nil {
+		return err
+	}
+	type undo struct {
+		id     string
+		change int
+	}
+	var undos []undo
+	for rows.Next() && quantity > 0 {
+		var id string
+		var net int
+		if err := rows.Scan(&id, &net); err != nil {
+			rows.Close()
+			return err
+		}
+		take := net
+		if take < 0 {
+			take = -take
+		}
+		if take > quantity {
+			take = quantity
+		}
+		if take == 0 {
+			continue
+		}
+		quantity -= take
+		if net > 0 {
+			take = -take
+		}
+		undos = append(undos, undo{id, take})
+	}
+	rows.Close()
+	now := time.Now().Format(time.RFC3339)
+	for _, u := range undos {
+		if _, err := tx.Exec(`UPDATE item_batches SET quantity = CASE WHEN quantity + ? < 0 THEN 0 ELSE quantity + ? END WHERE id = ?`, u.change, u.change, u.id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO batch_movements (id,batch_id,transaction_id,quantity,created_at) VALUES (?,?,?,?,?)`, genID(), u.id, transactionID, u.change, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unbatchedQuantity returns how many of itemID's units are in no batch.
+func unbatchedQuantity(q queryer, itemID string) (int, error) {
+	var total, batched int
+	err := q.QueryRow(`SELECT quantity, COALESCE((SELECT SUM(b.quantity) FROM item_batches b WHERE b.item_id = inventory_items.id), 0) FROM inventory_items WHERE id = ?`, itemID).Scan(&total, &batched)
+	return total - batched, err
+}
+
+// handleListBatches lists batches with stock left, of one item with
+// item_id; include_empty=1 adds the used-up ones.
+func handleListBatches(c *fiber.Ctx) error {
+	query := `SELECT b.id, b.item_id, COALESCE(i.name, 'Unnamed Item') AS item_name, i.sku, b.lot_number, b.expiry_date, b.received_quantity, b.quantity, b.unit_cost, b.transaction_id, b.received_at
+		FROM item_batches b LEFT JOIN inventory_items i ON i.id = b.item_id WHERE b.organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	if itemID := c.Query("item_id"); itemID != "" {
+		query += ` AND b.item_id = ?`
+		args = append(args, itemID)
+	}
+	if c.Query("include_empty") != "1" {
+		query += ` AND b.quantity > 0`
+	}
+	query += ` ORDER BY CASE WHEN b.expiry_date IS NULL THEN 1 ELSE 0 END, b.expiry_date, b.received_at`
+	rows, err := dbFor(c).Query(query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleExpiringBatches reports the stock left in batches that expire
+// within days (default 30) of today, already expired ones included, with
+// what it cost.
+func handleExpiringBatches(c *fiber.Ctx) error {
+	days := 30
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "days must be a whole number of days"})
+		}
+		days = n
+	}
+	orgID := currentOrgID(c)
+	now := time.Now().In(businessLocation(orgID))
+	today, _ := time.Parse("2006-01-02", now.Format("2006-01-02"))
+	cutoff := today.AddDate(0, 0, days).Format("2006-01-02")
+	rows, err := dbFor(c).Query(`SELECT b.id, b.item_id, COALESCE(i.name, 'Unnamed Item'), COALESCE(i.sku, ''), b.lot_number, b.expiry_date, b.quantity, COALESCE(b.unit_cost, i.cost_price, 0), COALESCE(i.unit_price, 0)
+		FROM item_batches b LEFT JOIN inventory_items i ON i.id = b.item_id
+		WHERE b.organization_id = ? AND b.quantity > 0 AND b.expiry_date IS NOT NULL AND b.expiry_date <= ?
+		ORDER BY b.expiry_date, i.name`, orgID, cutoff)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items := []fiber.Map{}
+	units, expired := 0, 0
+	costValue, retailValue := 0.0, 0.0
+	for rows.Next() {
+		var id, itemID, name, sku, lot, expiry string
+		var qty int
+		var unitCost, unitPrice float64
+		if err := rows.Scan(&id, &itemID, &name, &sku, &lot, &expiry, &qty, &unitCost, &unitPrice); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		expires, _ := time.Parse("2006-01-02", expiry)
+		daysLeft := int(math.Round(expires.Sub(today).Hours() / 24))
+		if daysLeft < 0 {
+			expired += qty
+		}
+		units += qty
+		costValue += float64(qty) * unitCost
+		retailValue += float64(qty) * unitPrice
+		items = append(items, fiber.Map{
+			"id": id, "item_id": itemID, "item_name": name, "sku": sku, "lot_number": lot,
+			"expiry_date": expiry, "days_left": daysLeft, "expired": daysLeft < 0, "quantity": qty,
+			"cost_value": round2(float64(qty) * unitCost), "retail_value": round2(float64(qty) * unitPrice),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{
+		"days": days, "through": cutoff, "items": items,
+		"summary": fiber.Map{"batches": len(items), "units": units, "expired_units": expired, "cost_value": round2(costValue), "retail_value": round2(retailValue)},
+	})
+}
+
+// handleCreateBatch puts stock already on hand but in no batch into one,
+// so an expiry can be recorded for it. Stock levels do not change.
+func handleCreateBatch(c *fiber.Ctx) error {
+	var req struct {
+		ItemID     string   `json:"item_id"`
+		Quantity   int      `json:"quantity"`
+		LotNumber  string   `json:"lot_number"`
+		ExpiryDate string   `json:"expiry_date"`
+		UnitCost   *float64 `json:"unit_cost"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	if !orgOwns(dbFor(c), "inventory_items", req.ItemID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "unknown item"})
+	}
+	if req.Quantity <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "quantity must be more than zero"})
+	}
+	if !validExpiryDate(req.ExpiryDate) {
+		return c.Status(400).JSON(fiber.Map{"error": "expiry_date must be a date (YYYY-MM-DD)"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	free, err := unbatchedQuantity(tx, req.ItemID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Quantity > free {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("only %d units of this item are in no batch", free)})
+	}
+	unitCost := 0.0
+	if req.UnitCost != nil {
+		unitCost = *req.UnitCost
+	} else {
+		if err := tx.QueryRow(`SELECT COALESCE(cost_price, 0) FROM inventory_items WHERE id = ?`, req.ItemID).Scan(&unitCost); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	id, lot, err := receiveBatch(tx, orgID, req.ItemID, "", req.Quantity, unitCost, req.LotNumber, req.ExpiryDate)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(fiber.Map{"id": id, "item_id": req.ItemID, "lot_number": lot, "quantity": req.Quantity})
+}
+
+// handleWriteOffBatch takes spoiled units of a batch (all that is left
+// unless quantity says otherwise) out of stock.
+func handleWriteOffBatch(c *fiber.Ctx) error {
+	var req struct {
+		Quantity int    `json:"quantity"`
+		Notes    string `json:"notes"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	if !orgOwns(dbFor(c), "item_batches", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "batch not found"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var itemID, lot string
+	var left int
+	if err := tx.QueryRow(`SELECT item_id, lot_number, quantity FROM item_batches WHERE id = ?`, id).Scan(&itemID, &lot, &left); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Quantity == 0 {
+		req.Quantity = left
+	}
+	if req.Quantity <= 0 || req.Quantity > left {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("quantity must be between 1 and the %d left in the batch", left)})
+	}
+	notes := "Batch " + lot + " written off"
+	if req.Notes != "" {
+		notes += ": " + req.Notes
+	}
+	if status, err := adjustStock(tx, itemID, -req.Quantity, "write_off", notes); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`UPDATE item_batches SET quantity = quantity - ? WHERE id = ?`, req.Quantity, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`INSERT INTO batch_movements (id,batch_id,quantity,created_at) VALUES (?,?,?,?)`, genID(), id, -req.Quantity, time.Now().Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "inventory_items", "update", itemID)
+	return c.JSON(fiber.Map{"id": id, "item_id": itemID, "written_off": req.Quantity, "quantity": left - req.Quantity})
+}
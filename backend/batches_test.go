@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBatchesFEFO(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('i-1','Milk','MILK',4,60,45,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	// left returns what is left of each batch by lot number
+	left := func() map[string]float64 {
+		t.Helper()
+		_, out := call("GET", "/api/batches?item_id=i-1&include_empty=1", "")
+		lots := map[string]float64{}
+		for _, b := range out["items"].([]interface{}) {
+			b := b.(map[string]interface{})
+			lots[b["lot_number"].(string)] = b["quantity"].(float64)
+		}
+		return lots
+	}
+	day := func(offset int) string {
+		return time.Now().In(businessLocation("org-1")).AddDate(0, 0, offset).Format("2006-01-02")
+	}
+
+	if code, _ := call("POST", "/api/batches", `{"item_id":"i-1","quantity":5,"expiry_date":"`+day(1)+`"}`); code != 409 {
+		t.Errorf("batching more than is on hand: got %d, want 409", code)
+	}
+	if code, _ := call("POST", "/api/batches", `{"item_id":"i-1","quantity":4,"lot_number":"OLD","expiry_date":"`+day(-1)+`"}`); code != 201 {
+		t.Fatalf("batch stock on hand: got %d", code)
+	}
+	purchase := `{"type":"outflow","amount":600,"paid_amount":600,"due_amount":0,"contact_id":"c-1","items":[` +
+		`{"item_id":"i-1","quantity":6,"unit_price":45,"lot_number":"L2","expiry_date":"` + day(20) + `"},` +
+		`{"item_id":"i-1","quantity":5,"unit_price":45,"expiry_date":"` + day(5) + `"}]}`
+	if code, out := call("POST", "/api/collections/transactions/records", purchase); code != 200 {
+		t.Fatalf("purchase: %d %v", code, out)
+	}
+	if code, _ := call("POST", "/api/collections/transactions/records", strings.Replace(purchase, day(5), "soon", 1)); code != 400 {
+		t.Errorf("invalid expiry_date: got %d, want 400", code)
+	}
+	if got := left(); got["OLD"] != 4 || got["L2"] != 6 || got["LOT-00001"] != 5 {
+		t.Fatalf("after purchase: %v", got)
+	}
+
+	// a sale of 7 empties the expired batch first, then the next to expire
+	if code, _ := call("POST", "/api/collections/transactions/records", `{"type":"inflow","amount":420,"paid_amount":420,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":7,"unit_price":60}]}`); code != 200 {
+		t.Fatalf("sale: got %d", code)
+	}
+	if got := left(); got["OLD"] != 0 || got["LOT-00001"] != 2 || got["L2"] != 6 {
+		t.Errorf("after sale: %v", got)
+	}
+
+	_, report := call("GET", "/api/batches/expiring?days=7", "")
+	items := report["items"].([]interface{})
+	if len(items) != 1 || items[0].(map[string]interface{})["lot_number"] != "LOT-00001" || items[0].(map[string]interface{})["days_left"] != 5.0 {
+		t.Errorf("expiring within 7 days: %v", items)
+	}
+	if summary := report["summary"].(map[string]interface{}); summary["units"] != 2.0 || summary["cost_value"] != 90.0 || summary["retail_value"] != 120.0 {
+		t.Errorf("summary: %v", summary)
+	}
+	_, report = call("GET", "/api/batches/expiring?days=30", "")
+	if n := len(report["items"].([]interface{})); n != 2 {
+		t.Errorf("expiring within 30 days: %d batches", n)
+	}
+
+	// voiding the sale puts the units back into the batches they came from
+	_, preview := call("POST", "/api/bulk/transactions", `{"action":"void","filter":"type = 'inflow'"}`)
+	if code, out := call("POST", "/api/bulk/transactions", `{"action":"void","filter":"type = 'inflow'","dry_run":false,"confirm_token":"`+toString(preview["confirm_token"])+`"}`); code != 200 {
+		t.Fatalf("void: %d %v", code, out)
+	}
+	if got := left(); got["OLD"] != 4 || got["LOT-00001"] != 5 {
+		t.Errorf("after void: %v", got)
+	}
+
+	var batchID string
+	_ = db.QueryRow(`SELECT id FROM item_batches WHERE lot_number = 'OLD'`).Scan(&batchID)
+	if code, out := call("POST", "/api/batches/"+batchID+"/write-off", `{"notes":"spoiled"}`); code != 200 || out["written_off"] != 4.0 {
+		t.Fatalf("write off: %d %v", code, out)
+	}
+	var qty int
+	_ = db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = 'i-1'`).Scan(&qty)
+	if qty != 11 || left()["OLD"] != 0 {
+		t.Errorf("after write off: item %d, batches %v", qty, left())
+	}
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Bulk void and delete clean up many records at once, e.g. a day of test
+// data:
+//
+//	POST /api/bulk/transactions
+//	{"action": "void", "filter": "created_at >= \"2024-05-01\" && created_at < \"2024-05-02\""}
+//
+// Requests are dry runs unless they say "dry_run": false. A dry run lists
+// every matching record with the stock, account, consignment and store
+// credit changes that undoing it causes, plus records that will be skipped
+// and why, and returns a confirm_token. The real run must send that token
+// back; if the matching records changed in between it is refused and a new
+// preview is needed.
+//
+// Voiding a transaction keeps it (with voided_at set) and reverses its
+// effects; deleting removes it and its lines. Contacts and items can only
+// be deleted, and only when nothing refers to them.
+
+func registerBulkRoutes(app *fiber.App) {
+	app.Post("/api/bulk/:collection", requireAuth, requireRole("admin"), handleBulkOperation)
+}
+
+type bulkRequest struct {
+	Action       string `json:"action"`
+	Filter       string `json:"filter"`
+	DryRun       *bool  `json:"dry_run"`
+	ConfirmToken string `json:"confirm_token"`
+}
+
+type bulkStockChange struct {
+	ItemID     string `json:"item_id"`
+	Name       string `json:"name"`
+	Change     int    `json:"change"`
+	LocationID string `json:"location_id,omitempty"`
+}
+
+type bulkAccountChange struct {
+	AccountID string  `json:"account_id"`
+	Amount    float64 `json:"amount"`
+}
+
+type bulkConsignedSale struct {
+	ID            string `json:"id"`
+	ConsignmentID string `json:"consignment_id"`
+	Quantity      int    `json:"quantity"`
+}
+
+// bulkCreditRefund is store credit a transaction was paid with, given
+// back to its credit note when the transaction is undone.
+type bulkCreditRefund struct {
+	CreditNoteID string  `json:"credit_note_id"`
+	Amount       float64 `json:"amount"`
+}
+
+// bulkRecord is one matching record and what undoing it changes.
+type bulkRecord struct {
+	ID               string              `json:"id"`
+	Record           fiber.Map           `json:"record"`
+	Skipped          string              `json:"skipped,omitempty"`
+	Stock            []bulkStockChange   `json:"stock,omitempty"`
+	Accounts         []bulkAccountChange `json:"accounts,omitempty"`
+	ConsignmentSales []bulkConsignedSale `json:"consignment_sales,omitempty"`
+	Credits          []bulkCreditRefund  `json:"credit_notes,omitempty"`
+	voided           bool
+	// note goes on the stock movements; "Bulk <action> of transaction <id>" if empty
+	note string
+}
+
+func handleBulkOperation(c *fiber.Ctx) error {
+	collection := c.Params("collection")
+	var req bulkRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	switch {
+	case req.Action == "delete" && (collection == "transactions" || collection == "contacts" || collection == "inventory_items"):
+	case req.Action == "void" && collection == "transactions":
+	case collection != "transactions" && collection != "contacts" && collection != "inventory_items":
+		return c.Status(404).JSON(fiber.Map{"error": "bulk operations support transactions, contacts and inventory_items"})
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "action must be void or delete (only transactions can be voided)"})
+	}
+	if strings.TrimSpace(req.Filter) == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "filter required"})
+	}
+	where, args, err := parseFilter(req.Filter, collection, "")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid filter: " + err.Error()})
+	}
+	orgID := currentOrgID(c)
+	// planned inside the transaction that applies it, so what is applied is
+	// what was checked
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	records, err := planBulk(tx, orgID, collection, req.Action, where, args)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	token := bulkToken(collection, req.Action, records)
+	affected, skipped := 0, 0
+	for _, r := range records {
+		if r.Skipped != "" {
+			skipped++
+		} else {
+			affected++
+		}
+	}
+	response := fiber.Map{"action": req.Action, "collection": collection, "matched": len(records), "affected": affected, "skipped": skipped, "records": records}
+	if req.DryRun == nil || *req.DryRun {
+		response["dry_run"] = true
+		response["confirm_token"] = token
+		return c.JSON(response)
+	}
+	if req.ConfirmToken == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "confirm_token required; preview the operation with a dry run first"})
+	}
+	if req.ConfirmToken != token {
+		return c.Status(409).JSON(fiber.Map{"error": "the matching records changed since the preview; run the dry run again"})
+	}
+	for _, r := range records {
+		if r.Skipped != "" {
+			continue
+		}
+		if status, err := applyBulk(tx, collection, req.Action, r); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": r.ID + ": " + err.Error()})
+		}
+		if req.Action == "void" {
+			if _, err := tx.Exec(`UPDATE transactions SET voided_by = ? WHERE id = ?`, currentUserID(c), r.ID); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	event := "delete"
+	if req.Action == "void" {
+		event = "update"
+		checkVoidSpike(dbFor(c), orgID, currentUserID(c))
+	}
+	for _, r := range records {
+		if r.Skipped == "" {
+			publishRecord(orgID, collection, event, r.ID)
+			for _, ch := range r.Stock {
+				publishRecord(orgID, "inventory_items", "update", ch.ItemID)
+			}
+		}
+	}
+	response["dry_run"] = false
+	return c.JSON(response)
+}
+
+// bulkToken fingerprints the records an operation would change.
+func bulkToken(collection, action string, records []bulkRecord) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", collection, action)
+	for _, r := range records {
+		if r.Skipped == "" {
+			fmt.Fprintln(h, r.ID)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// planBulk loads the records of orgID matching where and works out what
+// undoing each of them changes, reading through tx.
+func planBulk(tx *Tx, orgID, collection, action, where string, args []interface{}) ([]bulkRecord, error) {
+	var query string
+	switch collection {
+	case "transactions":
+		query = `SELECT id,type,amount,paid_amount,due_amount,contact_id,source,voided_at,created_at FROM transactions`
+	case "contacts":
+		query = `SELECT id,name,phone,type FROM contacts`
+	case "inventory_items":
+		query = `SELECT id,name,sku,quantity FROM inventory_items`
+	}
+	rows, err := tx.Query(query+` WHERE organization_id = ? AND (`+where+`) ORDER BY id`, append([]interface{}{orgID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	matched, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	moneyColumns(matched, "amount", "paid_amount", "due_amount")
+	records := make([]bulkRecord, 0, len(matched))
+	// stock after the records planned so far, so a run of purchases cannot
+	// together take an item below zero
+	stock := map[string]int{}
+	for _, m := range matched {
+		r := bulkRecord{ID: toString(m["id"]), Record: m}
+		switch collection {
+		case "transactions":
+			err = planTransactionUndo(tx, orgID, action, &r, stock)
+		case "contacts":
+			r.Skipped, err = referencedBy(tx, r.ID, map[string]string{
+				"transactions": "contact_id", "rentals": "contact_id", "consignments": "contact_id", "opening_balances": "ref_id",
+			})
+		case "inventory_items":
+			r.Skipped, err = referencedBy(tx, r.ID, map[string]string{
+				"transaction_items": "item_id", "rentals": "item_id", "consignments": "item_id", "inventory_items": "parent_id", "item_components": "component_id",
+			})
+		}
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// referencedBy describes the first table whose column holds id, or
+// returns "" when nothing refers to it.
+func referencedBy(q queryer, id string, refs map[string]string) (string, error) {
+	for _, table := range []string{"transactions", "transaction_items", "rentals", "consignments", "opening_balances", "inventory_items", "item_components"} {
+		column, ok := refs[table]
+		if !ok {
+			continue
+		}
+		var n int
+		if err := q.QueryRow(`SELECT COUNT(1) FROM `+table+` WHERE `+column+` = ?`, id).Scan(&n); err != nil {
+			return "", err
+		}
+		if n > 0 {
+			return fmt.Sprintf("used by %d %s", n, strings.ReplaceAll(table, "_", " ")), nil
+		}
+	}
+	return "", nil
+}
+
+func planTransactionUndo(tx *Tx, orgID, action string, r *bulkRecord, stock map[string]int) error {
+	r.voided = toString(r.Record["voided_at"]) != ""
+	if toString(r.Record["source"]) == "opening" {
+		r.Skipped = "opening balance; change it under opening balances"
+		return nil
+	}
+	if r.voided && action == "void" {
+		r.Skipped = "already voided"
+		return nil
+	}
+	if t, err := parseTime(toString(r.Record["created_at"])); err == nil {
+		locked, err := periodLocked(tx, orgID, t)
+		if err != nil {
+			return err
+		}
+		if locked {
+			r.Skipped = "belongs to a closed fiscal year"
+			return nil
+		}
+	}
+	var deposited, allocated, settled int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM deposit_items d JOIN transaction_payments p ON p.id = d.payment_id WHERE p.transaction_id = ?`, r.ID).Scan(&deposited); err != nil {
+		return err
+	}
+	if deposited > 0 {
+		r.Skipped = "a payment has been banked in a deposit"
+		return nil
+	}
+	// part of a contact payment (contact_payments.go): undoing it would
+	// leave the payment's sum spread over a transaction that is gone
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM transaction_payments WHERE transaction_id = ? AND contact_payment_id IS NOT NULL`, r.ID).Scan(&allocated); err != nil {
+		return err
+	}
+	if allocated > 0 {
+		r.Skipped = "a contact payment has been allocated to it"
+		return nil
+	}
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM consignment_sales WHERE transaction_id = ? AND settled_at IS NOT NULL`, r.ID).Scan(&settled); err != nil {
+		return err
+	}
+	if settled > 0 {
+		r.Skipped = "consigned units have been settled"
+		return nil
+	}
+	var returns int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM credit_notes WHERE transaction_id = ?`, r.ID).Scan(&returns); err != nil {
+		return err
+	}
+	if returns > 0 {
+		r.Skipped = "goods have been returned against it"
+		return nil
+	}
+	if r.voided {
+		// a voided transaction's effects are already reversed
+		return nil
+	}
+
+	rows, err := tx.Query(`SELECT ti.item_id, COALESCE(i.name, ''), SUM(ti.quantity), COALESCE(i.quantity, 0), COALESCE(ti.location_id, '') FROM transaction_items ti LEFT JOIN inventory_items i ON i.id = ti.item_id WHERE ti.transaction_id = ? GROUP BY ti.item_id, i.name, i.quantity, ti.location_id`, r.ID)
+	if err != nil {
+		return err
+	}
+	current := map[string]int{}
+	for rows.Next() {
+		var ch bulkStockChange
+		var quantity, onHand int
+		if err := rows.Scan(&ch.ItemID, &ch.Name, &quantity, &onHand, &ch.LocationID); err != nil {
+			rows.Close()
+			return err
+		}
+		// a sale took units out of stock, a purchase put them in
+		ch.Change = quantity
+		if toString(r.Record["type"]) == "outflow" {
+			ch.Change = -quantity
+		}
+		if _, ok := stock[ch.ItemID]; !ok {
+			stock[ch.ItemID] = onHand
+		}
+		current[ch.ItemID] = stock[ch.ItemID]
+		r.Stock = append(r.Stock, ch)
+	}
+	rows.Close()
+	after := map[string]int{}
+	for _, ch := range r.Stock {
+		after[ch.ItemID] += ch.Change
+	}
+	for _, ch := range r.Stock {
+		if current[ch.ItemID]+after[ch.ItemID] < 0 {
+			r.Skipped = fmt.Sprintf("%s has only %d in stock", ch.Name, current[ch.ItemID])
+			r.Stock = nil
+			return nil
+		}
+	}
+	for _, ch := range r.Stock {
+		stock[ch.ItemID] += ch.Change
+	}
+
+	rows, err = tx.Query(`SELECT account_id, SUM(amount) FROM account_movements WHERE ref_type = 'transaction' AND ref_id = ? GROUP BY account_id`, r.ID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var ac bulkAccountChange
+		if err := rows.Scan(&ac.AccountID, &ac.Amount); err != nil {
+			rows.Close()
+			return err
+		}
+		if ac.Amount != 0 {
+			ac.Amount = -ac.Amount
+			r.Accounts = append(r.Accounts, ac)
+		}
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`SELECT credit_note_id, SUM(amount) FROM transaction_payments WHERE transaction_id = ? AND credit_note_id IS NOT NULL GROUP BY credit_note_id`, r.ID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var cr bulkCreditRefund
+		if err := rows.Scan(&cr.CreditNoteID, &cr.Amount); err != nil {
+			rows.Close()
+			return err
+		}
+		r.Credits = append(r.Credits, cr)
+	}
+	rows.Close()
+
+	rows, err = tx.Query(`SELECT id, consignment_id, quantity FROM consignment_sales WHERE transaction_id = ?`, r.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s bulkConsignedSale
+		if err := rows.Scan(&s.ID, &s.ConsignmentID, &s.Quantity); err != nil {
+			return err
+		}
+		r.ConsignmentSales = append(r.ConsignmentSales, s)
+	}
+	return rows.Err()
+}
+
+// applyBulk voids or deletes one planned record inside tx. The returned
+// status is the HTTP code to use when err is non-nil.
+func applyBulk(tx *Tx, collection, action string, r bulkRecord) (int, error) {
+	switch collection {
+	case "contacts":
+		if _, err := tx.Exec(`DELETE FROM contacts WHERE id = ?`, r.ID); err != nil {
+			return 500, err
+		}
+		return 0, nil
+	case "inventory_items":
+		for _, q := range []string{
+			`DELETE FROM inventory_transactions WHERE item_id = ?`,
+			`DELETE FROM price_history WHERE item_id = ?`,
+			`DELETE FROM item_locations WHERE item_id = ?`,
+			`DELETE FROM batch_movements WHERE batch_id IN (SELECT id FROM item_batches WHERE item_id = ?)`,
+			`DELETE FROM item_batches WHERE item_id = ?`,
+			`DELETE FROM item_units WHERE item_id = ?`,
+			`DELETE FROM item_components WHERE bundle_id = ?`,
+			`DELETE FROM item_codes WHERE item_id = ?`,
+			`DELETE FROM price_list_items WHERE item_id = ?`,
+			`DELETE FROM inventory_items WHERE id = ?`,
+		} {
+			if _, err := tx.Exec(q, r.ID); err != nil {
+				return 500, err
+			}
+		}
+		return 0, nil
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	note := r.note
+	if note == "" {
+		note = "Bulk " + action + " of transaction " + r.ID
+	}
+	for _, ch := range r.Stock {
+		if status, err := adjustStock(tx, ch.ItemID, ch.Change, action, note); err != nil {
+			return status, err
+		}
+		if ch.LocationID != "" {
+			var orgID string
+			if err := tx.QueryRow(`SELECT organization_id FROM inventory_items WHERE id = ?`, ch.ItemID).Scan(&orgID); err != nil {
+				return 500, err
+			}
+			if status, err := moveLocationStock(tx, orgID, ch.ItemID, ch.LocationID, ch.Change); err != nil {
+				return status, err
+			}
+		}
+	}
+	if err := reverseBatchMovements(tx, r.ID); err != nil {
+		return 500, err
+	}
+	for _, s := range r.ConsignmentSales {
+		if _, err := tx.Exec(`UPDATE consignments SET sold_quantity = sold_quantity - ?, status = CASE WHEN sold_quantity - ? + returned_quantity < quantity THEN 'open' ELSE status END WHERE id = ?`, s.Quantity, s.Quantity, s.ConsignmentID); err != nil {
+			return 500, err
+		}
+		if _, err := tx.Exec(`DELETE FROM consignment_sales WHERE id = ?`, s.ID); err != nil {
+			return 500, err
+		}
+	}
+	for _, cr := range r.Credits {
+		if _, err := tx.Exec(`UPDATE credit_notes SET credit_remaining = credit_remaining + ? WHERE id = ?`, cr.Amount, cr.CreditNoteID); err != nil {
+			return 500, err
+		}
+	}
+	if action == "void" {
+		for _, ac := range r.Accounts {
+			if err := recordMovement(tx, ac.AccountID, ac.Amount, "void", "transaction", r.ID, "voided"); err != nil {
+				return 500, err
+			}
+		}
+		res, err := tx.Exec(`UPDATE transactions SET voided_at = ? WHERE id = ? AND voided_at IS NULL`, now, r.ID)
+		if err != nil {
+			return 500, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return 409, fiber.NewError(409, "already voided")
+		}
+		return 0, nil
+	}
+	for _, q := range []string{
+		`DELETE FROM account_movements WHERE ref_type = 'transaction' AND ref_id = ?`,
+		`DELETE FROM transaction_payments WHERE transaction_id = ?`,
+		`DELETE FROM transaction_items WHERE transaction_id = ?`,
+		`DELETE FROM transactions WHERE id = ?`,
+	} {
+		if _, err := tx.Exec(q, r.ID); err != nil {
+			return 500, err
+		}
+	}
+	return 0, nil
+}
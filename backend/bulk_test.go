@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A bulk void is previewed, refused without the preview's token, and then
+// puts stock and the till back while keeping the transaction.
+func TestBulkVoidTransactions(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,'org-1')`,
+		`INSERT INTO cash_accounts (id,name,kind,opening_balance,active,organization_id) VALUES ('a-1','Till','cash',0,1,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	app := newApp()
+	token := testToken(t, "admin")
+	post := func(path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	status, out := post("/api/collections/transactions/records", `{"type":"inflow","amount":45,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":3,"unit_price":15}],"payments":[{"method":"cash","amount":45,"account_id":"a-1"}]}`)
+	if status != 200 {
+		t.Fatalf("create sale: status %d, %v", status, out)
+	}
+	day := time.Now().Format("2006-01-02")
+	filter := `created_at >= "` + day + `"`
+
+	status, preview := post("/api/bulk/transactions", `{"action":"void","filter":`+jsonString(filter)+`}`)
+	if status != 200 || preview["dry_run"] != true || preview["affected"] != 1.0 {
+		t.Fatalf("preview: status %d, %v", status, preview)
+	}
+	record := preview["records"].([]interface{})[0].(map[string]interface{})
+	if stock := record["stock"].([]interface{})[0].(map[string]interface{}); stock["change"] != 3.0 {
+		t.Errorf("preview stock change = %v, want 3", stock["change"])
+	}
+	if status, _ := post("/api/bulk/transactions", `{"action":"void","filter":`+jsonString(filter)+`,"dry_run":false,"confirm_token":"stale"}`); status != 409 {
+		t.Errorf("wrong token: status %d, want 409", status)
+	}
+	if status, _ := post("/api/bulk/transactions", `{"action":"void","filter":`+jsonString(filter)+`,"dry_run":false,"confirm_token":"`+preview["confirm_token"].(string)+`"}`); status != 200 {
+		t.Fatalf("void: status %d", status)
+	}
+
+	var quantity, voided int
+	if err := db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = 'i-1'`).Scan(&quantity); err != nil || quantity != 10 {
+		t.Errorf("stock after void = %d (%v), want 10", quantity, err)
+	}
+	if balance, err := accountBalance(db, "org-1", "a-1"); err != nil || balance != 0 {
+		t.Errorf("till after void = %v (%v), want 0", balance, err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(1) FROM transactions WHERE voided_at IS NOT NULL`).Scan(&voided); err != nil || voided != 1 {
+		t.Errorf("voided transactions = %d (%v), want 1", voided, err)
+	}
+	pl, err := profitAndLoss(context.Background(), db, "org-1", time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl["sales"] != 0.0 {
+		t.Errorf("voided sale still in P&L: %v", pl["sales"])
+	}
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
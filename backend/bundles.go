@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A bundle (a gift pack, say) is an inventory item with a bill of
+// materials in item_components. Bundles are assembled ahead with
+// POST /api/inventory_items/:id/assemble, which takes the components out
+// of stock and puts finished bundles in, each side with its own
+// inventory_transactions row of type "assembly". Selling a bundle takes
+// finished ones first; whatever is short is assembled from components on
+// the spot, so selling a bundle nobody assembled deducts its components.
+//
+// Components are plain items: a bundle cannot contain another bundle.
+
+type bundleComponent struct {
+	ItemID   string `json:"item_id"`
+	Quantity int    `json:"quantity"`
+}
+
+func registerBundleRoutes(app *fiber.App) {
+	app.Get("/api/inventory_items/:id/components", requireAuth, handleGetComponents)
+	app.Put("/api/inventory_items/:id/components", requireAuth, requireRole("admin", "manager"), handlePutComponents)
+	app.Post("/api/inventory_items/:id/assemble", requireAuth, requireRole("admin", "manager"), handleAssembleBundle)
+}
+
+// bundleComponents returns the bill of materials of bundleID, empty for an
+// item that is not a bundle.
+func bundleComponents(q *Tx, bundleID string) ([]bundleComponent, error) {
+	rows, err := q.Query(`SELECT component_id, quantity FROM item_components WHERE bundle_id = ? ORDER BY component_id`, bundleID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var components []bundleComponent
+	for rows.Next() {
+		var bc bundleComponent
+		if err := rows.Scan(&bc.ItemID, &bc.Quantity); err != nil {
+			return nil, err
+		}
+		components = append(components, bc)
+	}
+	return components, rows.Err()
+}
+
+// assembleBundle turns components into quantity finished units of
+// bundleID at locationID ("" for the default location). The bundle is
+// received at what its components cost.
+func assembleBundle(tx *Tx, orgID, bundleID string, quantity int, locationID, notes string) (int, error) {
+	components, err := bundleComponents(tx, bundleID)
+	if err != nil {
+		return 500, err
+	}
+	if len(components) == 0 {
+		return 400, fiber.NewError(400, "item is not a bundle")
+	}
+	cost := 0.0
+	for _, bc := range components {
+		need := bc.Quantity * quantity
+		var name string
+		var unitCost float64
+		if err := tx.QueryRow(`SELECT COALESCE(name, ''), COALESCE(cost_price, 0) FROM inventory_items WHERE id = ?`, bc.ItemID).Scan(&name, &unitCost); err != nil {
+			return 500, err
+		}
+		if status, err := adjustStock(tx, bc.ItemID, -need, "assembly", notes); err != nil {
+			if status == 409 {
+				return 409, fiber.NewError(409, fmt.Sprintf("not enough %s to assemble %d", name, quantity))
+			}
+			return status, err
+		}
+		if status, err := moveLocationStock(tx, orgID, bc.ItemID, locationID, -need); err != nil {
+			return status, err
+		}
+		if err := consumeBatches(tx, bc.ItemID, "", need); err != nil {
+			return 500, err
+		}
+		cost += unitCost * float64(bc.Quantity)
+	}
+	cost = math.Round(cost*100) / 100
+	if status, err := receiveStock(tx, bundleID, quantity, cost, "assembly", notes); err != nil {
+		return status, err
+	}
+	if status, err := moveLocationStock(tx, orgID, bundleID, locationID, quantity); err != nil {
+		return status, err
+	}
+	if _, err := tx.Exec(`UPDATE inventory_items SET cost_price = ? WHERE id = ?`, cost, bundleID); err != nil {
+		return 500, err
+	}
+	return 0, nil
+}
+
+// assembleShortfall assembles whatever a sale of quantity units of itemID
+// at locationID needs beyond the finished units there. Items that are not
+// bundles are left alone.
+func assembleShortfall(tx *Tx, orgID, itemID string, quantity int, locationID, notes string) (int, error) {
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM item_components WHERE bundle_id = ?`, itemID).Scan(&n); err != nil || n == 0 {
+		return 500, err
+	}
+	var onHand int
+	var err error
+	if locationID != "" {
+		onHand, err = locationQuantity(tx, orgID, itemID, locationID)
+	} else {
+		onHand, err = Items(tx).Quantity(tx.ctx, itemID)
+	}
+	if err != nil {
+		return 500, err
+	}
+	if onHand < 0 {
+		onHand = 0
+	}
+	if short := quantity - onHand; short > 0 {
+		return assembleBundle(tx, orgID, itemID, short, locationID, notes)
+	}
+	return 0, nil
+}
+
+func handleGetComponents(c *fiber.Ctx) error {
+	id, orgID := c.Params("id"), currentOrgID(c)
+	if !orgOwns(dbFor(c), "inventory_items", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err := dbFor(c).Query(`SELECT ic.component_id, COALESCE(i.name, ''), COALESCE(i.sku, ''), ic.quantity, COALESCE(i.quantity, 0)
+		FROM item_components ic LEFT JOIN inventory_items i ON i.id = ic.component_id WHERE ic.bundle_id = ? ORDER BY i.name`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	components := []fiber.Map{}
+	buildable := -1
+	for rows.Next() {
+		var itemID, name, sku string
+		var quantity, onHand int
+		if err := rows.Scan(&itemID, &name, &sku, &quantity, &onHand); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		components = append(components, fiber.Map{"item_id": itemID, "name": name, "sku": sku, "quantity": quantity, "on_hand": onHand})
+		can := 0
+		if onHand > 0 {
+			can = onHand / quantity
+		}
+		if buildable < 0 || can < buildable {
+			buildable = can
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	// how many more bundles the components on hand make
+	if buildable < 0 {
+		buildable = 0
+	}
+	return c.JSON(fiber.Map{"components": components, "buildable": buildable})
+}
+
+// handlePutComponents replaces the bill of materials of a bundle:
+// {"components": [{"item_id": ..., "quantity": 2}]}. An empty list makes
+// it a plain item again.
+func handlePutComponents(c *fiber.Ctx) error {
+	id, orgID := c.Params("id"), currentOrgID(c)
+	var req struct {
+		Components []bundleComponent `json:"components"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if !orgOwns(dbFor(c), "inventory_items", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	var usedIn int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM item_components WHERE component_id = ?`, id).Scan(&usedIn); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if usedIn > 0 && len(req.Components) > 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "item is a component of another bundle; bundles cannot be nested"})
+	}
+	seen := map[string]bool{}
+	for _, bc := range req.Components {
+		switch {
+		case bc.ItemID == id:
+			return c.Status(400).JSON(fiber.Map{"error": "a bundle cannot contain itself"})
+		case seen[bc.ItemID]:
+			return c.Status(400).JSON(fiber.Map{"error": "item " + bc.ItemID + " is listed twice"})
+		case bc.Quantity <= 0:
+			return c.Status(400).JSON(fiber.Map{"error": "component quantities must be positive"})
+		case !orgOwns(dbFor(c), "inventory_items", bc.ItemID, orgID):
+			return c.Status(400).JSON(fiber.Map{"error": "unknown item " + bc.ItemID})
+		}
+		variants, err := hasVariants(dbFor(c), bc.ItemID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if variants {
+			return c.Status(400).JSON(fiber.Map{"error": "item " + bc.ItemID + " has variants; choose one of them"})
+		}
+		seen[bc.ItemID] = true
+		var nested int
+		if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM item_components WHERE bundle_id = ?`, bc.ItemID).Scan(&nested); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if nested > 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "item " + bc.ItemID + " is a bundle; bundles cannot be nested"})
+		}
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM item_components WHERE bundle_id = ?`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, bc := range req.Components {
+		if _, err := tx.Exec(`INSERT INTO item_components (id,organization_id,bundle_id,component_id,quantity) VALUES (?,?,?,?,?)`, genID(), orgID, id, bc.ItemID, bc.Quantity); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "inventory_items", "update", id)
+	return handleGetComponents(c)
+}
+
+// handleAssembleBundle assembles {"quantity": n, "location_id": ...,
+// "notes": ...} bundles from components in stock.
+func handleAssembleBundle(c *fiber.Ctx) error {
+	id, orgID := c.Params("id"), currentOrgID(c)
+	var req struct {
+		Quantity   int    `json:"quantity"`
+		LocationID string `json:"location_id"`
+		Notes      string `json:"notes"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if !orgOwns(dbFor(c), "inventory_items", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if req.Quantity <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "quantity must be positive"})
+	}
+	if req.LocationID != "" && !orgOwns(dbFor(c), "locations", req.LocationID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "unknown location " + req.LocationID})
+	}
+	if req.Notes == "" {
+		req.Notes = "Assembled"
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if status, err := assembleBundle(tx, orgID, id, req.Quantity, req.LocationID, req.Notes); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	components, err := bundleComponents(tx, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	quantity, err := Items(tx).Quantity(c.UserContext(), id)
+	if err != nil {
+		return recordError(c, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "inventory_items", "update", id)
+	for _, bc := range components {
+		publishRecord(orgID, "inventory_items", "update", bc.ItemID)
+	}
+	return c.JSON(fiber.Map{"id": id, "assembled": req.Quantity, "quantity": quantity})
+}
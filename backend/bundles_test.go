@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBundles(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES
+			('pack','Gift Pack','GIFT',0,500,0,'org-1'),
+			('tea','Tea','TEA',10,100,60,'org-1'),
+			('mug','Mug','MUG',4,150,80,'org-1'),
+			('other','Salt','SALT',5,20,10,'org-2')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	quantity := func(id string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = ?`, id).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	code, out := call("PUT", "/api/inventory_items/pack/components", `{"components":[{"item_id":"tea","quantity":2},{"item_id":"mug","quantity":1}]}`)
+	if code != 200 || len(out["components"].([]interface{})) != 2 || out["buildable"] != 4.0 {
+		t.Fatalf("define components: %d %v", code, out)
+	}
+	for _, body := range []string{
+		`{"components":[{"item_id":"pack","quantity":1}]}`,
+		`{"components":[{"item_id":"tea","quantity":0}]}`,
+		`{"components":[{"item_id":"tea","quantity":1},{"item_id":"tea","quantity":1}]}`,
+		`{"components":[{"item_id":"other","quantity":1}]}`,
+	} {
+		if code, _ := call("PUT", "/api/inventory_items/pack/components", body); code != 400 {
+			t.Errorf("%s: got %d, want 400", body, code)
+		}
+	}
+	// a component cannot become a bundle, nor a bundle a component
+	if code, _ := call("PUT", "/api/inventory_items/tea/components", `{"components":[{"item_id":"mug","quantity":1}]}`); code != 400 {
+		t.Errorf("nesting under a component: got %d, want 400", code)
+	}
+
+	code, out = call("POST", "/api/inventory_items/pack/assemble", `{"quantity":2}`)
+	if code != 200 || out["quantity"] != 2.0 {
+		t.Fatalf("assemble: %d %v", code, out)
+	}
+	if quantity("tea") != 6 || quantity("mug") != 2 {
+		t.Errorf("components after assembly: tea %d mug %d", quantity("tea"), quantity("mug"))
+	}
+	var rows int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM inventory_transactions WHERE transaction_type = 'assembly'`).Scan(&rows); err != nil || rows != 3 {
+		t.Errorf("assembly movements: %d %v", rows, err)
+	}
+	var cost float64
+	if err := db.QueryRow(`SELECT cost_price FROM inventory_items WHERE id = 'pack'`).Scan(&cost); err != nil || cost != 200 {
+		t.Errorf("bundle cost: %v %v", cost, err)
+	}
+	if code, _ := call("POST", "/api/inventory_items/pack/assemble", `{"quantity":5}`); code != 409 {
+		t.Errorf("assembling beyond components: got %d, want 409", code)
+	}
+	if quantity("tea") != 6 {
+		t.Errorf("failed assembly changed stock: tea %d", quantity("tea"))
+	}
+
+	// two finished packs are sold first, the third is assembled on the spot
+	sale := `{"type":"inflow","amount":1500,"paid_amount":1500,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"pack","quantity":3,"unit_price":500}]}`
+	if code, out := call("POST", "/api/collections/transactions/records", sale); code != 200 {
+		t.Fatalf("sale: %d %v", code, out)
+	}
+	if quantity("pack") != 0 || quantity("tea") != 4 || quantity("mug") != 1 {
+		t.Errorf("after sale: pack %d tea %d mug %d", quantity("pack"), quantity("tea"), quantity("mug"))
+	}
+	if code, _ := call("POST", "/api/collections/transactions/records", strings.Replace(sale, `"quantity":3`, `"quantity":2`, 1)); code != 409 {
+		t.Errorf("selling more than components make: got %d, want 409", code)
+	}
+}
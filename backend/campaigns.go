@@ -0,0 +1,536 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A campaign sends one templated message to every contact of a segment,
+// e.g. an Eid offer to all customers or a reminder to everyone with dues.
+// It is written as a draft, previewed, then scheduled; scheduling fixes the
+// recipient list and renders each message. A background sender works
+// through pending recipients of due campaigns at CAMPAIGN_RATE_PER_MINUTE
+// (default 30) so gateways and customers are not flooded, and marks each
+// sent or failed. Gateways report delivery to POST /api/messages/delivery
+// with the X-Webhook-Secret header set to MESSAGING_WEBHOOK_SECRET.
+//
+// Templates may use {name}, {phone}, {due_amount} and {business}.
+
+// campaignSegment selects the contacts a campaign goes to. Filter uses the
+// collection filter syntax over contacts.
+type campaignSegment struct {
+	Type    string `json:"type"`
+	Filter  string `json:"filter"`
+	WithDue bool   `json:"with_due"`
+}
+
+type campaignRecipient struct {
+	ContactID string `json:"contact_id"`
+	Name      string `json:"name"`
+	Address   string `json:"address"`
+	Message   string `json:"message"`
+	DueAmount money  `json:"due_amount"`
+	Skip      string `json:"skip,omitempty"`
+}
+
+func registerCampaignRoutes(app *fiber.App) {
+	app.Post("/api/messages/delivery", handleMessageDelivery)
+
+	r := app.Group("/api/campaigns", requireAuth)
+	r.Get("/", handleListCampaigns)
+	r.Get("/:id", handleGetCampaign)
+	r.Get("/:id/recipients", handleListCampaignRecipients)
+	r.Post("/", requireRole("admin", "manager"), handleCreateCampaign)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchCampaign)
+	r.Post("/preview", requireRole("admin", "manager"), handlePreviewCampaign)
+	r.Post("/:id/schedule", requireRole("admin", "manager"), handleScheduleCampaign)
+	r.Post("/:id/cancel", requireRole("admin", "manager"), handleCancelCampaign)
+}
+
+// campaignRate is how many messages the sender sends per minute.
+func campaignRate() int {
+	if v, err := strconv.Atoi(os.Getenv("CAMPAIGN_RATE_PER_MINUTE")); err == nil {
+		return v
+	}
+	return 30
+}
+
+// startCampaignSender sends pending campaign messages in the background,
+// up to campaignRate a minute. A rate of 0 or less disables it.
+func startCampaignSender() {
+	rate := campaignRate()
+	if rate <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if n := sendCampaignBatch(rate); n > 0 {
+				log.Printf("campaigns: sent %d messages", n)
+			}
+			time.Sleep(time.Minute)
+		}
+	}()
+}
+
+// segmentRecipients lists the contacts of orgID selected by seg, with the
+// message each would get.
+func segmentRecipients(q *DB, orgID, channel, template string, seg campaignSegment) ([]campaignRecipient, error) {
+	query := `SELECT c.id, c.name, c.phone, COALESCE(c.email, ''), c.sms_opt_out, COALESCE((SELECT SUM(t.due_amount) FROM transactions t WHERE t.contact_id = c.id AND t.type = 'inflow' AND t.voided_at IS NULL), 0) FROM contacts c WHERE c.organization_id = ?`
+	args := []interface{}{orgID}
+	if seg.Type != "" {
+		query += ` AND c.type = ?`
+		args = append(args, seg.Type)
+	}
+	if seg.Filter != "" {
+		where, fargs, err := parseFilter(seg.Filter, "contacts", "c.")
+		if err != nil {
+			return nil, fmt.Errorf("invalid segment filter: %w", err)
+		}
+		query += ` AND ` + where
+		args = append(args, fargs...)
+	}
+	rows, err := q.Query(query+` ORDER BY c.name`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var business string
+	if err := q.QueryRow(`SELECT name FROM organizations WHERE id = ?`, orgID).Scan(&business); err != nil {
+		return nil, err
+	}
+	recipients := []campaignRecipient{}
+	for rows.Next() {
+		var r campaignRecipient
+		var phone, email string
+		var optOut bool
+		if err := rows.Scan(&r.ContactID, &r.Name, &phone, &email, &optOut, &r.DueAmount); err != nil {
+			return nil, err
+		}
+		if seg.WithDue && r.DueAmount <= 0 {
+			continue
+		}
+		r.Message = strings.NewReplacer(
+			"{name}", r.Name,
+			"{phone}", phone,
+			"{due_amount}", r.DueAmount.String(),
+			"{business}", business,
+		).Replace(template)
+		switch channel {
+		case "sms", "whatsapp":
+			r.Address = strings.TrimSpace(phone)
+			if r.Address == "" {
+				r.Skip = "no phone number"
+			} else if optOut && channel == "sms" {
+				r.Skip = "opted out of SMS"
+			}
+		case "email":
+			if r.Address = email; r.Address == "" {
+				r.Skip = "no email address"
+			}
+		}
+		recipients = append(recipients, r)
+	}
+	return recipients, rows.Err()
+}
+
+type campaignRequest struct {
+	Name     *string          `json:"name"`
+	Channel  *string          `json:"channel"`
+	Subject  *string          `json:"subject"`
+	Template *string          `json:"template"`
+	Segment  *campaignSegment `json:"segment"`
+}
+
+// validate checks the fields that were sent.
+func (r campaignRequest) validate() string {
+	if r.Name != nil && strings.TrimSpace(*r.Name) == "" {
+		return "name cannot be empty"
+	}
+	if r.Channel != nil && !isMessageChannel(*r.Channel) {
+		return "channel must be one of " + strings.Join(messageChannels, ", ")
+	}
+	if r.Template != nil && strings.TrimSpace(*r.Template) == "" {
+		return "template cannot be empty"
+	}
+	if r.Segment != nil {
+		if t := r.Segment.Type; t != "" && t != "customer" && t != "supplier" {
+			return "segment type must be customer or supplier"
+		}
+		if r.Segment.Filter != "" {
+			if _, _, err := parseFilter(r.Segment.Filter, "contacts", "c."); err != nil {
+				return "invalid segment filter: " + err.Error()
+			}
+		}
+	}
+	return ""
+}
+
+func handleListCampaigns(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT id, name, channel, status, scheduled_at, created_at, completed_at FROM campaigns WHERE organization_id = ? ORDER BY created_at DESC`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// loadCampaign reads a campaign of orgID, with the segment decoded.
+func loadCampaign(q *DB, id, orgID string) (map[string]interface{}, error) {
+	rows, err := q.Query(`SELECT id, name, channel, subject, template, segment, status, scheduled_at, created_by, created_at, completed_at FROM campaigns WHERE id = ? AND organization_id = ?`, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	campaign := items[0]
+	var seg campaignSegment
+	_ = json.Unmarshal([]byte(toString(campaign["segment"])), &seg)
+	campaign["segment"] = seg
+	return campaign, nil
+}
+
+func handleGetCampaign(c *fiber.Ctx) error {
+	campaign, err := loadCampaign(dbFor(c), c.Params("id"), currentOrgID(c))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "campaign not found"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	rows, err := dbFor(c).Query(`SELECT status, COUNT(1) FROM campaign_recipients WHERE campaign_id = ? GROUP BY status`, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	counts := map[string]int{}
+	for rows.Next() {
+		var status string
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		counts[status] = n
+	}
+	campaign["recipients"] = counts
+	return c.JSON(campaign)
+}
+
+func handleListCampaignRecipients(c *fiber.Ctx) error {
+	if !orgOwns(dbFor(c), "campaigns", c.Params("id"), currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "campaign not found"})
+	}
+	query := `SELECT r.id, r.contact_id, c.name AS contact_name, r.address, r.message, r.status, r.error, r.sent_at, r.delivered_at FROM campaign_recipients r LEFT JOIN contacts c ON r.contact_id = c.id WHERE r.campaign_id = ?`
+	args := []interface{}{c.Params("id")}
+	if s := c.Query("status"); s != "" {
+		query += ` AND r.status = ?`
+		args = append(args, s)
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY c.name`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func handleCreateCampaign(c *fiber.Ctx) error {
+	var req campaignRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.Name == nil || req.Channel == nil || req.Template == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "name, channel and template required"})
+	}
+	if msg := req.validate(); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	var subject string
+	if req.Subject != nil {
+		subject = *req.Subject
+	}
+	seg := campaignSegment{}
+	if req.Segment != nil {
+		seg = *req.Segment
+	}
+	segment, _ := json.Marshal(seg)
+	id := genID()
+	if _, err := dbFor(c).Exec(`INSERT INTO campaigns (id,organization_id,name,channel,subject,template,segment,status,created_by,created_at) VALUES (?,?,?,?,?,?,?,'draft',?,?)`,
+		id, currentOrgID(c), strings.TrimSpace(*req.Name), *req.Channel, subject, *req.Template, string(segment), currentUserID(c), time.Now().Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+// handlePatchCampaign edits a draft; once scheduled a campaign is fixed.
+func handlePatchCampaign(c *fiber.Ctx) error {
+	var req campaignRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if msg := req.validate(); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	id := c.Params("id")
+	var status string
+	if err := dbFor(c).QueryRow(`SELECT status FROM campaigns WHERE id = ? AND organization_id = ?`, id, currentOrgID(c)).Scan(&status); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "campaign not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status != "draft" {
+		return c.Status(409).JSON(fiber.Map{"error": "only draft campaigns can be edited"})
+	}
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		updates["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.Channel != nil {
+		updates["channel"] = *req.Channel
+	}
+	if req.Subject != nil {
+		updates["subject"] = *req.Subject
+	}
+	if req.Template != nil {
+		updates["template"] = *req.Template
+	}
+	if req.Segment != nil {
+		segment, _ := json.Marshal(req.Segment)
+		updates["segment"] = string(segment)
+	}
+	for _, field := range []string{"name", "channel", "subject", "template", "segment"} {
+		if v, ok := updates[field]; ok {
+			if _, err := dbFor(c).Exec("UPDATE campaigns SET "+field+" = ? WHERE id = ?", v, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+// handlePreviewCampaign shows who a campaign would reach and what they
+// would get, without saving anything.
+func handlePreviewCampaign(c *fiber.Ctx) error {
+	var req campaignRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.Channel == nil || req.Template == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "channel and template required"})
+	}
+	if msg := req.validate(); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	seg := campaignSegment{}
+	if req.Segment != nil {
+		seg = *req.Segment
+	}
+	recipients, err := segmentRecipients(dbFor(c), currentOrgID(c), *req.Channel, *req.Template, seg)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	skipped := 0
+	for _, r := range recipients {
+		if r.Skip != "" {
+			skipped++
+		}
+	}
+	return c.JSON(fiber.Map{"recipients": recipients, "total": len(recipients), "skipped": skipped})
+}
+
+// handleScheduleCampaign fixes the recipients of a draft and queues it to
+// be sent at send_at (RFC 3339), or straight away.
+func handleScheduleCampaign(c *fiber.Ctx) error {
+	var req struct {
+		SendAt string `json:"send_at"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	sendAt := time.Now().UTC()
+	if req.SendAt != "" {
+		t, err := time.Parse(time.RFC3339, req.SendAt)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "send_at must be an RFC 3339 time"})
+		}
+		sendAt = t.UTC()
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	campaign, err := loadCampaign(dbFor(c), id, orgID)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "campaign not found"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if campaign["status"] != "draft" {
+		return c.Status(409).JSON(fiber.Map{"error": "campaign is already " + toString(campaign["status"])})
+	}
+	recipients, err := segmentRecipients(dbFor(c), orgID, toString(campaign["channel"]), toString(campaign["template"]), campaign["segment"].(campaignSegment))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(recipients) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "the segment selects no contacts"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	for _, r := range recipients {
+		status, reason := "pending", sql.NullString{}
+		if r.Skip != "" {
+			status, reason = "skipped", sql.NullString{String: r.Skip, Valid: true}
+		}
+		if _, err := tx.Exec(`INSERT INTO campaign_recipients (id,campaign_id,contact_id,address,message,status,error) VALUES (?,?,?,?,?,?,?)`,
+			genID(), id, r.ContactID, r.Address, r.Message, status, reason); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if _, err := tx.Exec(`UPDATE campaigns SET status = 'scheduled', scheduled_at = ? WHERE id = ?`, sendAt.Format(time.RFC3339), id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "status": "scheduled", "scheduled_at": sendAt.Format(time.RFC3339), "recipients": len(recipients)})
+}
+
+// handleCancelCampaign stops a campaign; messages already sent stay sent.
+func handleCancelCampaign(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var status string
+	if err := dbFor(c).QueryRow(`SELECT status FROM campaigns WHERE id = ? AND organization_id = ?`, id, currentOrgID(c)).Scan(&status); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "campaign not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status == "completed" || status == "cancelled" {
+		return c.Status(409).JSON(fiber.Map{"error": "campaign is already " + status})
+	}
+	if _, err := dbFor(c).Exec(`UPDATE campaign_recipients SET status = 'cancelled' WHERE campaign_id = ? AND status = 'pending'`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := dbFor(c).Exec(`UPDATE campaigns SET status = 'cancelled', completed_at = ? WHERE id = ?`, time.Now().Format(time.RFC3339), id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "status": "cancelled"})
+}
+
+// sendCampaignBatch sends up to limit pending messages of campaigns that
+// are due, oldest campaign first, and returns how many it sent. Campaigns
+// left with nothing pending are marked completed.
+func sendCampaignBatch(limit int) int {
+	now := time.Now().UTC().Format(time.RFC3339)
+	rows, err := db.Query(`SELECT r.id, r.campaign_id, c.channel, c.subject, r.address, r.message FROM campaign_recipients r JOIN campaigns c ON r.campaign_id = c.id
+		WHERE r.status = 'pending' AND c.status IN ('scheduled','sending') AND c.scheduled_at <= ? ORDER BY c.scheduled_at, r.id LIMIT ?`, now, limit)
+	if err != nil {
+		log.Printf("campaigns: %v", err)
+		return 0
+	}
+	type pending struct{ id, campaignID, channel, subject, address, message string }
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		var subject sql.NullString
+		if err := rows.Scan(&p.id, &p.campaignID, &p.channel, &subject, &p.address, &p.message); err != nil {
+			log.Printf("campaigns: %v", err)
+			continue
+		}
+		p.subject = subject.String
+		batch = append(batch, p)
+	}
+	rows.Close()
+
+	sent := 0
+	for _, p := range batch {
+		if _, err := db.Exec(`UPDATE campaigns SET status = 'sending' WHERE id = ? AND status = 'scheduled'`, p.campaignID); err != nil {
+			log.Printf("campaigns: %s: %v", p.campaignID, err)
+		}
+		providerID, err := sendMessage(p.channel, p.address, p.subject, p.message)
+		at := time.Now().Format(time.RFC3339)
+		if err != nil {
+			if _, err := db.Exec(`UPDATE campaign_recipients SET status = 'failed', error = ?, sent_at = ? WHERE id = ?`, err.Error(), at, p.id); err != nil {
+				log.Printf("campaigns: recipient %s: %v", p.id, err)
+			}
+			continue
+		}
+		sent++
+		// the message is out; a recipient left pending here would be sent it again
+		if _, err := db.Exec(`UPDATE campaign_recipients SET status = 'sent', provider_message_id = ?, sent_at = ? WHERE id = ?`, providerID, at, p.id); err != nil {
+			log.Printf("campaigns: recipient %s was sent %s but not marked sent: %v", p.id, providerID, err)
+		}
+	}
+
+	if _, err := db.Exec(`UPDATE campaigns SET status = 'completed', completed_at = ? WHERE status IN ('scheduled','sending') AND scheduled_at <= ?
+		AND NOT EXISTS (SELECT 1 FROM campaign_recipients r WHERE r.campaign_id = campaigns.id AND r.status = 'pending')`, time.Now().Format(time.RFC3339), now); err != nil {
+		log.Printf("campaigns: %v", err)
+	}
+	return sent
+}
+
+// handleMessageDelivery records a gateway's delivery report:
+// {"id": "<provider message id>", "status": "delivered"|"failed", "error": "..."}.
+func handleMessageDelivery(c *fiber.Ctx) error {
+	secret := os.Getenv("MESSAGING_WEBHOOK_SECRET")
+	if secret == "" || subtle.ConstantTimeCompare([]byte(c.Get("X-Webhook-Secret")), []byte(secret)) != 1 {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid webhook secret"})
+	}
+	var req struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.ID == "" || (req.Status != "delivered" && req.Status != "failed") {
+		return c.Status(400).JSON(fiber.Map{"error": "id and a status of delivered or failed required"})
+	}
+	var res sql.Result
+	var err error
+	if req.Status == "delivered" {
+		res, err = dbFor(c).Exec(`UPDATE campaign_recipients SET status = 'delivered', delivered_at = ? WHERE provider_message_id = ?`, time.Now().Format(time.RFC3339), req.ID)
+	} else {
+		res, err = dbFor(c).Exec(`UPDATE campaign_recipients SET status = 'failed', error = ? WHERE provider_message_id = ?`, req.Error, req.ID)
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// not a campaign's, then perhaps a reminder's; see due_reminders.go
+		found, err := recordDueReminderDelivery(dbFor(c), req.ID, req.Status, req.Error)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if !found {
+			return c.Status(404).JSON(fiber.Map{"error": "unknown message id"})
+		}
+	}
+	return c.JSON(fiber.Map{"ok": true})
+}
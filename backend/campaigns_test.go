@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCampaignSendsToSegment(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Rahim Store','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Karim','01711000001','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-2','Salma','01711000002','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-3','Wholesaler','01711000003','supplier','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-1','inflow',50000,20000,30000,'c-1','org-1','2024-01-01T10:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	var sent []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct{ To, Message string }
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		mu.Lock()
+		sent = append(sent, msg.To+": "+msg.Message)
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"id":"gw-` + msg.To + `"}`))
+	}))
+	defer gateway.Close()
+	t.Setenv("SMS_GATEWAY_URL", gateway.URL)
+	t.Setenv("MESSAGING_WEBHOOK_SECRET", "s3cret")
+
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if role != "" {
+			req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Webhook-Secret", "s3cret")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("cashier", "POST", "/api/campaigns", `{"name":"Eid","channel":"sms","template":"x"}`); code != 403 {
+		t.Errorf("cashier create: got %d, want 403", code)
+	}
+	if code, _ := call("manager", "POST", "/api/campaigns", `{"name":"Eid","channel":"fax","template":"x"}`); code != 400 {
+		t.Errorf("bad channel: got %d, want 400", code)
+	}
+	_, preview := call("manager", "POST", "/api/campaigns/preview", `{"channel":"sms","template":"Hi {name}, you owe {due_amount} to {business}","segment":{"type":"customer","with_due":true}}`)
+	if preview["total"] != float64(1) {
+		t.Fatalf("preview: %v", preview)
+	}
+	if msg := preview["recipients"].([]interface{})[0].(map[string]interface{})["message"]; msg != "Hi Karim, you owe 300.00 to Rahim Store" {
+		t.Errorf("rendered message %q", msg)
+	}
+
+	code, out := call("manager", "POST", "/api/campaigns", `{"name":"Eid offer","channel":"sms","template":"Eid Mubarak {name}!","segment":{"type":"customer"}}`)
+	if code != 200 {
+		t.Fatalf("create: %d %v", code, out)
+	}
+	id := out["id"].(string)
+	if code, out := call("manager", "POST", "/api/campaigns/"+id+"/schedule", `{"send_at":"2099-01-01T00:00:00Z"}`); code != 200 || out["recipients"] != float64(2) {
+		t.Fatalf("schedule: %d %v", code, out)
+	}
+	if code, _ := call("manager", "PATCH", "/api/campaigns/"+id, `{"name":"Renamed"}`); code != 409 {
+		t.Errorf("editing a scheduled campaign: got %d, want 409", code)
+	}
+	if n := sendCampaignBatch(10); n != 0 {
+		t.Fatalf("sent %d messages before the scheduled time", n)
+	}
+	if _, err := db.Exec(`UPDATE campaigns SET scheduled_at = '2000-01-01T00:00:00Z' WHERE id = ?`, id); err != nil {
+		t.Fatal(err)
+	}
+
+	// throttled: one message per batch
+	if n := sendCampaignBatch(1); n != 1 {
+		t.Fatalf("first batch sent %d, want 1", n)
+	}
+	_, got := call("cashier", "GET", "/api/campaigns/"+id, "")
+	if got["status"] != "sending" {
+		t.Errorf("status after first batch %v, want sending", got["status"])
+	}
+	if n := sendCampaignBatch(1); n != 1 {
+		t.Fatalf("second batch sent %d, want 1", n)
+	}
+	sendCampaignBatch(1)
+	_, got = call("cashier", "GET", "/api/campaigns/"+id, "")
+	if got["status"] != "completed" {
+		t.Errorf("status %v, want completed", got["status"])
+	}
+	sort.Strings(sent)
+	if len(sent) != 2 || sent[0] != "01711000001: Eid Mubarak Karim!" || sent[1] != "01711000002: Eid Mubarak Salma!" {
+		t.Errorf("gateway received %v", sent)
+	}
+
+	if code, _ := call("", "POST", "/api/messages/delivery", `{"id":"gw-01711000002","status":"delivered"}`); code != 200 {
+		t.Errorf("delivery report: got %d", code)
+	}
+	if code, _ := call("", "POST", "/api/messages/delivery", `{"id":"nope","status":"delivered"}`); code != 404 {
+		t.Errorf("unknown message: got %d, want 404", code)
+	}
+	_, got = call("cashier", "GET", "/api/campaigns/"+id, "")
+	counts := got["recipients"].(map[string]interface{})
+	if counts["delivered"] != float64(1) || counts["sent"] != float64(1) {
+		t.Errorf("recipient counts %v", counts)
+	}
+
+	t.Setenv("MESSAGING_WEBHOOK_SECRET", "other")
+	if code, _ := call("", "POST", "/api/messages/delivery", `{"id":"gw-01711000001","status":"delivered"}`); code != 401 {
+		t.Errorf("wrong secret: got %d, want 401", code)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Cash accounts are the places money sits: cash drawers, bank accounts and
+// mobile wallets. Every movement of money is a signed row in
+// account_movements (positive = money in), so an account's balance is its
+// opening balance plus the sum of its movements.
+//
+// Payments on transactions hit the account given on the payment line, or
+// the default account of the payment method when none is given.
+
+func registerCashAccountRoutes(app *fiber.App) {
+	r := app.Group("/api/cash-accounts", requireAuth)
+	r.Get("/", handleListCashAccounts)
+	r.Post("/", requireRole("admin", "manager"), handleCreateCashAccount)
+	r.Post("/transfer", requireRole("admin", "manager"), handleCashTransfer)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchCashAccount)
+	r.Get("/:id/movements", handleListAccountMovements)
+	r.Post("/:id/entries", requireRole("admin", "manager"), handleCreateAccountEntry)
+}
+
+// recordMovement writes one signed movement against an account.
+func recordMovement(tx *Tx, accountID string, amount float64, kind, refType, refID, notes string) error {
+	_, err := tx.Exec(`INSERT INTO account_movements (id,account_id,amount,kind,ref_type,ref_id,notes,created_at) VALUES (?,?,?,?,?,?,?,?)`,
+		genID(), accountID, amount, kind, refType, refID, notes, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// accountForPayment resolves the account a payment line lands in.
+func accountForPayment(q queryer, orgID string, line paymentLine) (string, error) {
+	if line.AccountID != "" {
+		return line.AccountID, nil
+	}
+	var accountID sql.NullString
+	err := q.QueryRow(`SELECT account_id FROM payment_methods WHERE organization_id = ? AND code = ?`, orgID, line.Method).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return accountID.String, err
+}
+
+// accountBalance returns the balance of an account of orgID, or
+// sql.ErrNoRows when orgID has no such account.
+func accountBalance(q *DB, orgID, accountID string) (float64, error) {
+	var balance float64
+	err := q.QueryRow(`SELECT a.opening_balance + COALESCE((SELECT SUM(amount) FROM account_movements m WHERE m.account_id = a.id), 0) FROM cash_accounts a WHERE a.id = ? AND a.organization_id = ?`, accountID, orgID).Scan(&balance)
+	return balance, err
+}
+
+func handleListCashAccounts(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT a.id, a.name, a.kind, a.account_no, a.opening_balance, a.active, a.created_at,
+		a.opening_balance + COALESCE((SELECT SUM(amount) FROM account_movements m WHERE m.account_id = a.id), 0) AS balance
+		FROM cash_accounts a WHERE a.organization_id = ? ORDER BY a.name`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	total := 0.0
+	for _, it := range items {
+		if b, ok := it["balance"].(float64); ok {
+			total += b
+		}
+	}
+	return c.JSON(fiber.Map{"items": items, "total": total})
+}
+
+func handleCreateCashAccount(c *fiber.Ctx) error {
+	var req struct {
+		Name           string  `json:"name"`
+		Kind           string  `json:"kind"`
+		AccountNo      string  `json:"account_no"`
+		OpeningBalance float64 `json:"opening_balance"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name required"})
+	}
+	switch req.Kind {
+	case "":
+		req.Kind = "cash"
+	case "cash", "bank", "mobile":
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "kind must be cash, bank or mobile"})
+	}
+	id := genID()
+	_, err := dbFor(c).Exec(`INSERT INTO cash_accounts (id,name,kind,account_no,opening_balance,active,organization_id,created_at) VALUES (?,?,?,?,?,1,?,?)`,
+		id, req.Name, req.Kind, req.AccountNo, req.OpeningBalance, currentOrgID(c), time.Now().Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+func handlePatchCashAccount(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var body map[string]interface{}
+	if err := json.Unmarshal(c.Body(), &body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if !orgOwns(dbFor(c), "cash_accounts", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "account not found"})
+	}
+	for _, field := range []string{"name", "account_no", "active"} {
+		if v, ok := body[field]; ok {
+			if _, err := dbFor(c).Exec("UPDATE cash_accounts SET "+field+" = ? WHERE id = ?", v, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+func handleListAccountMovements(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	balance, err := accountBalance(dbFor(c), currentOrgID(c), c.Params("id"))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "account not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	rows, err := dbFor(c).Query(`SELECT id,amount,kind,ref_type,ref_id,notes,vendor,category,created_at FROM account_movements WHERE account_id = ? AND created_at >= ? AND created_at < ? ORDER BY created_at`,
+		c.Params("id"), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return streamItems(c, rows, nil, fiber.Map{"balance": balance})
+}
+
+// handleCreateAccountEntry records money moving in or out of an account
+// outside of a sale or purchase: expenses, owner deposits and withdrawals.
+// Expenses may name a vendor and category (expenses.go).
+func handleCreateAccountEntry(c *fiber.Ctx) error {
+	var req struct {
+		Kind     string  `json:"kind"`
+		Amount   float64 `json:"amount"`
+		Notes    string  `json:"notes"`
+		Vendor   string  `json:"vendor"`
+		Category string  `json:"category"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.Amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "amount must be positive"})
+	}
+	amount := req.Amount
+	switch req.Kind {
+	case "deposit":
+	case "expense", "withdrawal":
+		amount = -amount
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "kind must be expense, deposit or withdrawal"})
+	}
+	accountID := c.Params("id")
+	orgID := currentOrgID(c)
+	if _, err := accountBalance(dbFor(c), orgID, accountID); err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "account not found"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var category string
+	if req.Kind == "expense" {
+		category, err = recordExpense(tx, orgID, accountID, amount, req.Vendor, req.Category, req.Notes)
+	} else {
+		err = recordMovement(tx, accountID, amount, req.Kind, "", "", req.Notes)
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	balance, _ := accountBalance(dbFor(c), orgID, accountID)
+	out := fiber.Map{"account_id": accountID, "balance": balance}
+	if req.Kind == "expense" {
+		out["category"] = category
+	}
+	return c.JSON(out)
+}
+
+func handleCashTransfer(c *fiber.Ctx) error {
+	var req struct {
+		FromAccountID string  `json:"from_account_id"`
+		ToAccountID   string  `json:"to_account_id"`
+		Amount        float64 `json:"amount"`
+		Notes         string  `json:"notes"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.Amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "amount must be positive"})
+	}
+	if req.FromAccountID == req.ToAccountID {
+		return c.Status(400).JSON(fiber.Map{"error": "cannot transfer to the same account"})
+	}
+	orgID := currentOrgID(c)
+	for _, id := range []string{req.FromAccountID, req.ToAccountID} {
+		if _, err := accountBalance(dbFor(c), orgID, id); err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "account not found: " + id})
+		}
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	transferID := genID()
+	if err := recordMovement(tx, req.FromAccountID, -req.Amount, "transfer_out", "transfer", transferID, req.Notes); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := recordMovement(tx, req.ToAccountID, req.Amount, "transfer_in", "transfer", transferID, req.Notes); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	fromBalance, _ := accountBalance(dbFor(c), orgID, req.FromAccountID)
+	toBalance, _ := accountBalance(dbFor(c), orgID, req.ToAccountID)
+	return c.JSON(fiber.Map{"id": transferID, "from_balance": fromBalance, "to_balance": toBalance})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCashAccountRoles(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	call := func(role, method, path, body string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	for _, r := range []struct{ method, path, body string }{
+		{"POST", "/api/cash-accounts", `{"name":"Till"}`},
+		{"POST", "/api/cash-accounts/transfer", `{"from_account_id":"a","to_account_id":"b","amount":10}`},
+		{"PATCH", "/api/cash-accounts/a", `{"name":"Drawer"}`},
+		{"POST", "/api/cash-accounts/a/entries", `{"amount":10}`},
+	} {
+		if code := call("cashier", r.method, r.path, r.body); code != 403 {
+			t.Errorf("cashier %s %s: got %d, want 403", r.method, r.path, code)
+		}
+	}
+	if code := call("manager", "POST", "/api/cash-accounts", `{"name":"Till"}`); code != 200 {
+		t.Errorf("manager opening an account: got %d", code)
+	}
+	if code := call("cashier", "GET", "/api/cash-accounts", ""); code != 200 {
+		t.Errorf("cashier listing accounts: got %d", code)
+	}
+}
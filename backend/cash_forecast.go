@@ -0,0 +1,357 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The cash flow forecast projects the cash position forward from today's
+// cash (as on the balance sheet) using what is already known to be coming:
+// the unpaid part of sales and purchases on their due dates or installment
+// schedules, and recurring expenses. Unpaid amounts without a due date fall
+// due payment_terms_days after the transaction. Amounts already overdue are
+// reported apart and only counted on the first day with include_overdue.
+
+var forecastHorizons = []int{30, 60, 90}
+
+func registerCashForecastRoutes(app *fiber.App) {
+	app.Get("/api/reports/cash-forecast", requireAuth, cachedReport, handleCashForecast)
+	app.Get("/api/transactions/:id/installments", requireAuth, handleGetInstallments)
+	app.Put("/api/transactions/:id/installments", requireAuth, requireRole("admin", "manager"), handlePutInstallments)
+}
+
+// forecastEvent is one expected cash movement; amount is positive for
+// receipts and negative for payments and expenses.
+type forecastEvent struct {
+	date   string
+	kind   string
+	amount float64
+}
+
+// dueSchedule returns the dated amounts that make up due, the unpaid part
+// of a transaction. Payments are taken to settle installments in date
+// order, so the outstanding ones are the latest installments adding up to
+// due. Without installments the whole amount falls due on fallback.
+func dueSchedule(q *DB, txID string, due float64, fallback string) ([]forecastEvent, error) {
+	rows, err := q.Query(`SELECT due_date, amount FROM transaction_installments WHERE transaction_id = ? ORDER BY due_date DESC`, txID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []forecastEvent
+	left := due
+	for rows.Next() && left > 0.005 {
+		var e forecastEvent
+		if err := rows.Scan(&e.date, &e.amount); err != nil {
+			return nil, err
+		}
+		e.amount = math.Min(e.amount, left)
+		left -= e.amount
+		out = append(out, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(out) == 0 {
+		return []forecastEvent{{date: fallback, amount: due}}, nil
+	}
+	if left > 0.005 {
+		// installments no longer cover the balance; the rest is due with the earliest
+		out[len(out)-1].amount += left
+	}
+	return out, nil
+}
+
+// openDue is the unpaid part of a sale or purchase with the dates it falls
+// due on.
+type openDue struct {
+	id, typ, contactID, createdAt string
+	due                           float64
+	schedule                      []forecastEvent
+}
+
+// openDues lists the unpaid sales and purchases of orgID, optionally only
+// those of one type and contact, oldest first. Unpaid amounts without a due
+// date or installments fall due payment_terms_days after the transaction.
+func openDues(q *DB, orgID, typ, contactID string) ([]openDue, error) {
+	terms, _ := orgSetting(orgID, "payment_terms_days").(float64)
+	query := `SELECT id, type, contact_id, due_amount, COALESCE(due_date, ''), COALESCE(created_at, '') FROM transactions WHERE organization_id = ? AND voided_at IS NULL AND due_amount > 0 AND type IN ('inflow', 'outflow')`
+	args := []interface{}{orgID}
+	if typ != "" {
+		query += ` AND type = ?`
+		args = append(args, typ)
+	}
+	if contactID != "" {
+		query += ` AND contact_id = ?`
+		args = append(args, contactID)
+	}
+	rows, err := q.Query(query+` ORDER BY created_at, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	var open []openDue
+	var dueDates []string
+	for rows.Next() {
+		var d openDue
+		var due money
+		var dueDate string
+		if err := rows.Scan(&d.id, &d.typ, &d.contactID, &due, &dueDate, &d.createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.due = due.float()
+		open = append(open, d)
+		dueDates = append(dueDates, dueDate)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range open {
+		fallback := dueDates[i]
+		if fallback == "" {
+			created, err := parseTime(open[i].createdAt)
+			if err != nil {
+				created = time.Now()
+			}
+			fallback = created.AddDate(0, 0, int(terms)).Format("2006-01-02")
+		}
+		if open[i].schedule, err = dueSchedule(q, open[i].id, open[i].due, fallback); err != nil {
+			return nil, err
+		}
+	}
+	return open, nil
+}
+
+// cashForecastEvents lists the expected receipts, payments and recurring
+// expenses for orgID up to and including until.
+func cashForecastEvents(q *DB, orgID, until string) ([]forecastEvent, error) {
+	open, err := openDues(q, orgID, "", "")
+	if err != nil {
+		return nil, err
+	}
+	var events []forecastEvent
+	for _, t := range open {
+		for _, e := range t.schedule {
+			if e.date > until {
+				continue
+			}
+			if t.typ == "inflow" {
+				e.kind = "receipt"
+			} else {
+				e.kind, e.amount = "payment", -e.amount
+			}
+			events = append(events, e)
+		}
+	}
+
+	rows, err := q.Query(`SELECT amount, frequency, next_date, COALESCE(end_date, '') FROM recurring_expenses WHERE organization_id = ? AND active = 1`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var amount float64
+		var frequency, next, end string
+		if err := rows.Scan(&amount, &frequency, &next, &end); err != nil {
+			return nil, err
+		}
+		d, err := time.Parse("2006-01-02", next)
+		if err != nil {
+			continue
+		}
+		for date := d.Format("2006-01-02"); date <= until && (end == "" || date <= end); date = d.Format("2006-01-02") {
+			events = append(events, forecastEvent{date: date, kind: "expense", amount: -amount})
+			d = nextOccurrence(d, frequency)
+		}
+	}
+	return events, rows.Err()
+}
+
+func handleCashForecast(c *fiber.Ctx) error {
+	days := 90
+	if v := c.Query("days"); v != "" {
+		days, _ = strconv.Atoi(v)
+		if days != 30 && days != 60 && days != 90 {
+			return c.Status(400).JSON(fiber.Map{"error": "days must be 30, 60 or 90"})
+		}
+	}
+	includeOverdue := c.Query("include_overdue") == "true"
+	orgID := currentOrgID(c)
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	today := start.Format("2006-01-02")
+	until := start.AddDate(0, 0, days).Format("2006-01-02")
+
+	sheet, err := balanceSheet(c.UserContext(), dbFor(c), orgID, now)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	opening := sheet["assets"].(fiber.Map)["cash"].(float64)
+	events, err := cashForecastEvents(dbFor(c), orgID, until)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	overdue := map[string]float64{"receipt": 0, "payment": 0, "expense": 0}
+	var upcoming []forecastEvent
+	for _, e := range events {
+		if e.date < today {
+			overdue[e.kind] += math.Abs(e.amount)
+			if !includeOverdue {
+				continue
+			}
+			e.date = today
+		}
+		upcoming = append(upcoming, e)
+	}
+	sort.SliceStable(upcoming, func(i, j int) bool { return upcoming[i].date < upcoming[j].date })
+
+	// walk the days once, closing weeks and horizons as they pass
+	type bucket struct{ receipts, payments, expenses float64 }
+	add := func(b *bucket, e forecastEvent) {
+		switch e.kind {
+		case "receipt":
+			b.receipts += e.amount
+		case "payment":
+			b.payments -= e.amount
+		case "expense":
+			b.expenses -= e.amount
+		}
+	}
+	cash := opening
+	lowest, lowestDate := opening, today
+	var total, week bucket
+	weekStart := today
+	periods := []fiber.Map{}
+	weeks := []fiber.Map{}
+	next := 0
+	for i := 0; i <= days; i++ {
+		date := start.AddDate(0, 0, i).Format("2006-01-02")
+		for next < len(upcoming) && upcoming[next].date == date {
+			e := upcoming[next]
+			cash += e.amount
+			add(&total, e)
+			add(&week, e)
+			next++
+		}
+		if cash < lowest {
+			lowest, lowestDate = cash, date
+		}
+		if i%7 == 6 || i == days {
+			weeks = append(weeks, fiber.Map{"start": weekStart, "end": date, "receipts": week.receipts, "payments": week.payments, "expenses": week.expenses, "closing_cash": cash})
+			week = bucket{}
+			weekStart = start.AddDate(0, 0, i+1).Format("2006-01-02")
+		}
+		for _, h := range forecastHorizons {
+			if h == i && h <= days {
+				periods = append(periods, fiber.Map{"days": h, "until": date, "receipts": total.receipts, "payments": total.payments, "expenses": total.expenses, "closing_cash": cash})
+			}
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"as_of":        today,
+		"days":         days,
+		"opening_cash": opening,
+		"periods":      periods,
+		"weeks":        weeks,
+		"lowest_cash":  fiber.Map{"date": lowestDate, "balance": lowest},
+		"overdue": fiber.Map{
+			"receivables": overdue["receipt"],
+			"payables":    overdue["payment"],
+			"expenses":    overdue["expense"],
+			"included":    includeOverdue,
+		},
+	})
+}
+
+func transactionInstallments(q *DB, txID string) ([]map[string]interface{}, error) {
+	rows, err := q.Query(`SELECT id, due_date, amount FROM transaction_installments WHERE transaction_id = ? ORDER BY due_date`, txID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToMaps(rows)
+}
+
+func handleGetInstallments(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "transactions", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "transaction not found"})
+	}
+	items, err := transactionInstallments(dbFor(c), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handlePutInstallments replaces the installment schedule of a
+// transaction's unpaid amount. The installments must add up to the amount
+// still due; an empty list removes the schedule.
+func handlePutInstallments(c *fiber.Ctx) error {
+	var req struct {
+		Installments []struct {
+			DueDate string  `json:"due_date"`
+			Amount  float64 `json:"amount"`
+		} `json:"installments"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	id := c.Params("id")
+	var due money
+	var voided sql.NullString
+	err := dbFor(c).QueryRow(`SELECT COALESCE(due_amount, 0), voided_at FROM transactions WHERE id = ? AND organization_id = ?`, id, currentOrgID(c)).Scan(&due, &voided)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "transaction not found"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if voided.Valid {
+		return c.Status(409).JSON(fiber.Map{"error": "transaction is voided"})
+	}
+	sum := 0.0
+	for _, in := range req.Installments {
+		if _, err := time.Parse("2006-01-02", in.DueDate); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "due_date must be a date (YYYY-MM-DD)"})
+		}
+		if in.Amount <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "installment amounts must be positive"})
+		}
+		sum += in.Amount
+	}
+	if len(req.Installments) > 0 && moneyOf(sum) != due {
+		return c.Status(400).JSON(fiber.Map{"error": "installments must add up to the amount due", "due_amount": due, "total": sum})
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM transaction_installments WHERE transaction_id = ?`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, in := range req.Installments {
+		if _, err := tx.Exec(`INSERT INTO transaction_installments (id,transaction_id,due_date,amount) VALUES (?,?,?,?)`, genID(), id, in.DueDate, in.Amount); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	items, err := transactionInstallments(dbFor(c), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCashForecast(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	day := func(n int) string { return time.Now().AddDate(0, 0, n).Format("2006-01-02") }
+	now := time.Now().Format(time.RFC3339)
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','017','customer','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-cash','inflow',100000,100000,0,'c-1','org-1','` + now + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,due_date,contact_id,organization_id,created_at) VALUES ('t-sale','inflow',50000,0,50000,'` + day(10) + `','c-1','org-1','` + now + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-buy','outflow',30000,0,30000,'c-1','org-1','` + time.Now().AddDate(0, 0, -20).Format(time.RFC3339) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-plan','inflow',40000,0,40000,'c-1','org-1','` + now + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,voided_at,contact_id,organization_id,created_at) VALUES ('t-void','inflow',90000,0,90000,'` + now + `','c-1','org-1','` + now + `')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("PUT", "/api/transactions/t-plan/installments", `{"installments":[{"due_date":"`+day(-5)+`","amount":100},{"due_date":"`+day(40)+`","amount":250}]}`); code != 400 {
+		t.Errorf("installments short of the due amount: got %d, want 400", code)
+	}
+	if code, out := call("PUT", "/api/transactions/t-plan/installments", `{"installments":[{"due_date":"`+day(-5)+`","amount":100},{"due_date":"`+day(40)+`","amount":300}]}`); code != 200 || len(out["items"].([]interface{})) != 2 {
+		t.Fatalf("installments: %d %v", code, out)
+	}
+	if code, _ := call("POST", "/api/recurring-expenses", `{"name":"Rent","amount":200,"frequency":"fortnightly","next_date":"`+day(1)+`"}`); code != 400 {
+		t.Errorf("bad frequency: got %d, want 400", code)
+	}
+	if code, _ := call("POST", "/api/recurring-expenses", `{"name":"Rent","amount":200,"frequency":"monthly","next_date":"`+day(1)+`"}`); code != 200 {
+		t.Fatalf("recurring expense: got %d", code)
+	}
+	if code, _ := call("GET", "/api/reports/cash-forecast?days=45", ""); code != 400 {
+		t.Errorf("days=45: got %d, want 400", code)
+	}
+
+	// sale +500 on day 10, purchase -300 on day 10 (30-day terms), rent -200
+	// monthly from day 1, the later installment +300 on day 40 and the
+	// earlier one 100 overdue
+	_, out := call("GET", "/api/reports/cash-forecast", "")
+	if out["opening_cash"] != float64(1000) {
+		t.Errorf("opening cash %v, want 1000", out["opening_cash"])
+	}
+	want := []float64{1000, 1100, 900}
+	periods := out["periods"].([]interface{})
+	if len(periods) != 3 {
+		t.Fatalf("%d periods, want 3", len(periods))
+	}
+	for i, p := range periods {
+		if got := p.(map[string]interface{})["closing_cash"]; got != want[i] {
+			t.Errorf("cash after %v days %v, want %v", p.(map[string]interface{})["days"], got, want[i])
+		}
+	}
+	if r := periods[0].(map[string]interface{}); r["receipts"] != float64(500) || r["payments"] != float64(300) || r["expenses"] != float64(200) {
+		t.Errorf("first 30 days %v", r)
+	}
+	if lowest := out["lowest_cash"].(map[string]interface{}); lowest["balance"] != float64(800) || lowest["date"] != day(1) {
+		t.Errorf("lowest cash %v, want 800 on %s", lowest, day(1))
+	}
+	if overdue := out["overdue"].(map[string]interface{}); overdue["receivables"] != float64(100) {
+		t.Errorf("overdue %v", overdue)
+	}
+
+	_, out = call("GET", "/api/reports/cash-forecast?days=30&include_overdue=true", "")
+	periods = out["periods"].([]interface{})
+	if len(periods) != 1 || periods[0].(map[string]interface{})["closing_cash"] != float64(1100) {
+		t.Errorf("30 days with overdue %v", periods)
+	}
+}
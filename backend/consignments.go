@@ -0,0 +1,313 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Consignments track stock that sits somewhere other than its owner:
+//   - inward:  a supplier's goods in our shop. They add to sellable quantity
+//     but are excluded from valuation; sales are owed back to the supplier.
+//   - outward: our goods at another shop. They leave our quantity but stay
+//     in valuation until the consignee reports them sold.
+
+func registerConsignmentRoutes(app *fiber.App) {
+	r := app.Group("/api/consignments", requireAuth)
+	r.Get("/", handleListConsignments)
+	r.Post("/", requireRole("admin", "manager"), handleCreateConsignment)
+	r.Post("/settle", requireRole("admin", "manager"), handleSettleConsignments)
+	r.Post("/:id/sales", requireRole("admin", "manager"), handleConsignmentSale)
+	r.Post("/:id/return", requireRole("admin", "manager"), handleConsignmentReturn)
+
+	app.Get("/api/reports/consignment-settlement", requireAuth, handleConsignmentSettlementReport)
+}
+
+func handleListConsignments(c *fiber.Ctx) error {
+	query := `SELECT cs.id,cs.direction,cs.contact_id,ct.name as contact_name,cs.item_id,COALESCE(i.name, 'Unnamed Item') as item_name,cs.quantity,cs.sold_quantity,cs.returned_quantity,cs.quantity - cs.sold_quantity - cs.returned_quantity as remaining_quantity,cs.unit_cost,cs.status,cs.notes,cs.created_at FROM consignments cs LEFT JOIN contacts ct ON cs.contact_id = ct.id LEFT JOIN inventory_items i ON cs.item_id = i.id WHERE cs.organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	for _, f := range []string{"direction", "contact_id", "item_id", "status"} {
+		if v := c.Query(f); v != "" {
+			query += " AND cs." + f + " = ?"
+			args = append(args, v)
+		}
+	}
+	query += " ORDER BY cs.created_at DESC"
+	rows, err := dbFor(c).Query(query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items, "totalItems": len(items)})
+}
+
+type consignmentRequest struct {
+	Direction string  `json:"direction"`
+	ContactID string  `json:"contact_id"`
+	ItemID    string  `json:"item_id"`
+	Quantity  int     `json:"quantity"`
+	UnitCost  float64 `json:"unit_cost"`
+	Notes     string  `json:"notes"`
+}
+
+func handleCreateConsignment(c *fiber.Ctx) error {
+	var req consignmentRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.Direction != "inward" && req.Direction != "outward" {
+		return c.Status(400).JSON(fiber.Map{"error": "direction must be inward or outward"})
+	}
+	if req.ContactID == "" || req.ItemID == "" || req.Quantity <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "contact_id, item_id and a positive quantity are required"})
+	}
+	orgID := currentOrgID(c)
+	if !orgOwns(dbFor(c), "contacts", req.ContactID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "unknown contact"})
+	}
+	if !orgOwns(dbFor(c), "inventory_items", req.ItemID, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "item not found"})
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+
+	change, txType := req.Quantity, "consignment_in"
+	if req.Direction == "outward" {
+		change, txType = -req.Quantity, "consignment_out"
+	}
+	if status, err := adjustStock(tx, req.ItemID, change, txType, "Consignment "+req.Direction); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	id := genID()
+	_, err = tx.Exec(`INSERT INTO consignments (id,direction,contact_id,item_id,quantity,unit_cost,status,notes,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`, id, req.Direction, req.ContactID, req.ItemID, req.Quantity, req.UnitCost, "open", req.Notes, orgID, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+type consignmentMovementRequest struct {
+	Quantity  int     `json:"quantity"`
+	SalePrice float64 `json:"sale_price"`
+}
+
+// handleConsignmentSale records units of an outward consignment reported
+// sold by the consignee. Inward sales are recorded automatically when a
+// sale transaction includes the item.
+func handleConsignmentSale(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var req consignmentMovementRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	direction, remaining, status, err := loadConsignment(tx, currentOrgID(c), id)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if direction != "outward" {
+		return c.Status(400).JSON(fiber.Map{"error": "inward consignment sales are recorded from sale transactions"})
+	}
+	if req.Quantity <= 0 || req.Quantity > remaining {
+		return c.Status(400).JSON(fiber.Map{"error": "quantity must be between 1 and the remaining quantity", "remaining": remaining})
+	}
+	if _, err := tx.Exec(`INSERT INTO consignment_sales (id,consignment_id,quantity,sale_price,created_at) VALUES (?,?,?,?,?)`, genID(), id, req.Quantity, req.SalePrice, time.Now().Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`UPDATE consignments SET sold_quantity = sold_quantity + ? WHERE id = ?`, req.Quantity, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := closeConsignmentIfDone(tx, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "remaining": remaining - req.Quantity})
+}
+
+// handleConsignmentReturn sends unsold inward goods back to the supplier or
+// takes unsold outward goods back into our own stock.
+func handleConsignmentReturn(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var req consignmentMovementRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	direction, remaining, status, err := loadConsignment(tx, currentOrgID(c), id)
+	if err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Quantity <= 0 || req.Quantity > remaining {
+		return c.Status(400).JSON(fiber.Map{"error": "quantity must be between 1 and the remaining quantity", "remaining": remaining})
+	}
+	var itemID string
+	if err := tx.QueryRow(`SELECT item_id FROM consignments WHERE id = ?`, id).Scan(&itemID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	change := req.Quantity
+	if direction == "inward" {
+		change = -req.Quantity
+	}
+	if status, err := adjustStock(tx, itemID, change, "consignment_return", "Consignment "+direction+" return"); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`UPDATE consignments SET returned_quantity = returned_quantity + ? WHERE id = ?`, req.Quantity, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := closeConsignmentIfDone(tx, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "remaining": remaining - req.Quantity})
+}
+
+// handleConsignmentSettlementReport lists unsettled consignment sales per
+// contact: what we owe suppliers (inward) and what consignees owe us (outward).
+func handleConsignmentSettlementReport(c *fiber.Ctx) error {
+	query := `SELECT cs.direction, cs.contact_id, ct.name as contact_name, COUNT(s.id) as sales, SUM(s.quantity) as quantity, SUM(s.quantity * s.sale_price) as sales_value, SUM(s.quantity * cs.unit_cost) as settlement_amount FROM consignment_sales s JOIN consignments cs ON s.consignment_id = cs.id LEFT JOIN contacts ct ON cs.contact_id = ct.id WHERE cs.organization_id = ? AND s.settled_at IS NULL`
+	args := []interface{}{currentOrgID(c)}
+	if v := c.Query("direction"); v != "" {
+		query += " AND cs.direction = ?"
+		args = append(args, v)
+	}
+	if v := c.Query("contact_id"); v != "" {
+		query += " AND cs.contact_id = ?"
+		args = append(args, v)
+	}
+	query += " GROUP BY cs.direction, cs.contact_id, ct.name ORDER BY cs.direction, contact_name"
+	rows, err := dbFor(c).Query(query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+type consignmentSettleRequest struct {
+	ContactID string `json:"contact_id"`
+	Direction string `json:"direction"`
+}
+
+func handleSettleConsignments(c *fiber.Ctx) error {
+	var req consignmentSettleRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.ContactID == "" || (req.Direction != "inward" && req.Direction != "outward") {
+		return c.Status(400).JSON(fiber.Map{"error": "contact_id and direction are required"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	orgID := currentOrgID(c)
+	var quantity int
+	var amount float64
+	err = tx.QueryRow(`SELECT COALESCE(SUM(s.quantity), 0), COALESCE(SUM(s.quantity * cs.unit_cost), 0) FROM consignment_sales s JOIN consignments cs ON s.consignment_id = cs.id WHERE s.settled_at IS NULL AND cs.organization_id = ? AND cs.contact_id = ? AND cs.direction = ?`, orgID, req.ContactID, req.Direction).Scan(&quantity, &amount)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now().Format(time.RFC3339)
+	_, err = tx.Exec(`UPDATE consignment_sales SET settled_at = ? WHERE settled_at IS NULL AND consignment_id IN (SELECT id FROM consignments WHERE organization_id = ? AND contact_id = ? AND direction = ?)`, now, orgID, req.ContactID, req.Direction)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"contact_id": req.ContactID, "direction": req.Direction, "quantity": quantity, "amount": amount, "settled_at": now})
+}
+
+// recordConsignedSale attributes units sold in a sale transaction of orgID
+// to its open inward consignments for the item, oldest first, inside the
+// sale's tx. Units beyond what is on consignment are treated as our own
+// stock.
+func recordConsignedSale(tx *Tx, orgID, transactionID, itemID string, quantity int, salePrice float64) error {
+	rows, err := tx.Query(`SELECT id, quantity - sold_quantity - returned_quantity FROM consignments WHERE organization_id = ? AND item_id = ? AND direction = 'inward' AND status = 'open' ORDER BY created_at`, orgID, itemID)
+	if err != nil {
+		return err
+	}
+	type open struct {
+		id        string
+		remaining int
+	}
+	var opens []open
+	for rows.Next() {
+		var o open
+		if err := rows.Scan(&o.id, &o.remaining); err != nil {
+			rows.Close()
+			return err
+		}
+		opens = append(opens, o)
+	}
+	rows.Close()
+
+	now := time.Now().Format(time.RFC3339)
+	for _, o := range opens {
+		if quantity <= 0 {
+			break
+		}
+		take := o.remaining
+		if take > quantity {
+			take = quantity
+		}
+		if _, err := tx.Exec(`INSERT INTO consignment_sales (id,consignment_id,transaction_id,quantity,sale_price,created_at) VALUES (?,?,?,?,?,?)`, genID(), o.id, transactionID, take, salePrice, now); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE consignments SET sold_quantity = sold_quantity + ?, status = CASE WHEN sold_quantity + ? + returned_quantity >= quantity THEN 'closed' ELSE status END WHERE id = ?`, take, take, o.id); err != nil {
+			return err
+		}
+		quantity -= take
+	}
+	return nil
+}
+
+func loadConsignment(tx *Tx, orgID, id string) (direction string, remaining int, status int, err error) {
+	var st string
+	err = tx.QueryRow(`SELECT direction, quantity - sold_quantity - returned_quantity, status FROM consignments WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&direction, &remaining, &st)
+	if err == sql.ErrNoRows {
+		return "", 0, 404, fiber.NewError(404, "not found")
+	}
+	if err != nil {
+		return "", 0, 500, err
+	}
+	if st != "open" {
+		return "", 0, 409, fiber.NewError(409, "consignment is closed")
+	}
+	return direction, remaining, 0, nil
+}
+
+func closeConsignmentIfDone(tx *Tx, id string) error {
+	_, err := tx.Exec(`UPDATE consignments SET status = 'closed' WHERE id = ? AND sold_quantity + returned_quantity >= quantity`, id)
+	return err
+}
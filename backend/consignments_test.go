@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConsignedSales(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Supplier','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',0,50,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	consignment := `{"direction":"inward","contact_id":"s-1","item_id":"i-1","quantity":5,"unit_cost":30}`
+	if code, _ := call("cashier", "POST", "/api/consignments", consignment); code != 403 {
+		t.Errorf("cashier taking in a consignment: got %d, want 403", code)
+	}
+	if code, out := call("manager", "POST", "/api/consignments", consignment); code != 200 {
+		t.Fatalf("consignment: %d %v", code, out)
+	}
+	code, sale := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":150,"paid_amount":150,"due_amount":0,"payment_method":"cash","contact_id":"c-1",
+		"items":[{"item_id":"i-1","quantity":3,"unit_price":50}]}`)
+	if code != 200 {
+		t.Fatalf("sale: %d %v", code, sale)
+	}
+	var sold int
+	if err := db.QueryRow(`SELECT COALESCE(SUM(quantity), 0) FROM consignment_sales WHERE transaction_id = ?`, toString(sale["id"])).Scan(&sold); err != nil || sold != 3 {
+		t.Errorf("consigned units sold: %v %d", err, sold)
+	}
+	if code, _ := call("cashier", "POST", "/api/consignments/settle", `{"contact_id":"s-1","direction":"inward"}`); code != 403 {
+		t.Errorf("cashier settling with the supplier: got %d, want 403", code)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Customers often pay several old invoices with one sum, and suppliers
+// are paid the same way. A contact payment is that sum. It is spread over
+// the contact's open sales (or purchases), oldest first unless the parts
+// are given, and each part is a payment line on its transaction that moves
+// the amount from due_amount to paid_amount. It cannot be more than is
+// owed. Supplier payments (suppliers.go) are contact payments too, spread
+// by due date.
+//
+//	GET  /api/payments          ?contact_id=&type=&from=&to=
+//	GET  /api/payments/:id      with the transactions it paid
+//	POST /api/payments          {"contact_id", "amount", "method", "reference", "account_id", "date",
+//	                             "type": "inflow" | "outflow", "order": "oldest" | "due_date",
+//	                             "allocations": [{"transaction_id", "amount"}], "preview": true}
+//
+// type defaults to inflow (received) for customers and outflow (paid) for
+// suppliers. With preview the allocation is worked out but not recorded.
+
+type paymentAllocation struct {
+	TransactionID string `json:"transaction_id"`
+	Amount        money  `json:"amount"`
+	// Due is what is left due on the transaction after the payment
+	Due money `json:"due"`
+}
+
+func registerContactPaymentRoutes(app *fiber.App) {
+	r := app.Group("/api/payments", requireAuth)
+	r.Get("/", handleListContactPayments)
+	r.Get("/:id", handleGetContactPayment)
+	r.Post("/", requireRole("admin", "manager", "cashier"), handleCreateContactPayment)
+}
+
+// allocatePayment works out how much of amount goes to each of the open
+// transactions: the parts asked for, or the transactions in order until
+// it runs out.
+func allocatePayment(open []openDue, amount money, asked []paymentAllocation) ([]paymentAllocation, int, fiber.Map) {
+	var owed money
+	due := map[string]money{}
+	for _, d := range open {
+		due[d.id] = moneyOf(d.due)
+		owed += due[d.id]
+	}
+	if amount > owed {
+		return nil, 400, fiber.Map{"error": "payment is more than is owed", "due": owed}
+	}
+	if len(asked) > 0 {
+		var total money
+		parts := []paymentAllocation{}
+		for _, a := range asked {
+			left, ok := due[a.TransactionID]
+			if !ok {
+				return nil, 404, fiber.Map{"error": "no unpaid transaction " + a.TransactionID + " of this contact"}
+			}
+			if a.Amount <= 0 || a.Amount > left {
+				return nil, 400, fiber.Map{"error": "allocation to " + a.TransactionID + " must be more than 0 and at most what is due", "due": left}
+			}
+			due[a.TransactionID] = left - a.Amount
+			total += a.Amount
+			parts = append(parts, paymentAllocation{a.TransactionID, a.Amount, left - a.Amount})
+		}
+		if total != amount {
+			return nil, 400, fiber.Map{"error": "allocations add up to " + total.String() + " but the payment is " + amount.String()}
+		}
+		return parts, 0, nil
+	}
+	parts := []paymentAllocation{}
+	left := amount
+	for _, d := range open {
+		if left <= 0 {
+			break
+		}
+		part := due[d.id]
+		if part > left {
+			part = left
+		}
+		parts = append(parts, paymentAllocation{d.id, part, due[d.id] - part})
+		left -= part
+	}
+	return parts, 0, nil
+}
+
+// createContactPayment records a contact payment inside tx and returns
+// its id, for the lines of its parts.
+func createContactPayment(tx *Tx, orgID, contactID, typ string, amount money, line paymentLine, userID string) (string, error) {
+	id, now := genID(), time.Now().Format(time.RFC3339)
+	paidAt := line.paidAt
+	if paidAt == "" {
+		paidAt = now
+	}
+	_, err := tx.Exec(`INSERT INTO contact_payments (id,organization_id,contact_id,type,method,amount,reference,account_id,recorded_by,paid_at,created_at) VALUES (?,?,?,?,?,?,?,NULLIF(?, ''),?,?,?)`,
+		id, orgID, contactID, typ, line.Method, amount.float(), line.Reference, line.AccountID, userID, paidAt, now)
+	return id, err
+}
+
+// errDuesChanged is settleDues' answer when a transaction's due amount is
+// no longer the one the payment was allocated against.
+var errDuesChanged = fiber.NewError(409, "what is due changed meanwhile; try again")
+
+// settleDues records the parts of a payment inside tx: a payment line on
+// each transaction, and its paid and due amounts. The parts were worked
+// out before tx began, so a transaction is only settled while its due
+// amount is still the one they were allocated against.
+func settleDues(tx *Tx, orgID, typ string, line paymentLine, parts []paymentAllocation) error {
+	for _, p := range parts {
+		if err := backfillLegacyPayment(tx, p.TransactionID); err != nil {
+			return err
+		}
+		l := line
+		l.Amount = p.Amount.float()
+		if err := recordPayments(tx, orgID, p.TransactionID, typ, []paymentLine{l}); err != nil {
+			return err
+		}
+		var paid money
+		if err := tx.QueryRow(`SELECT paid_amount FROM transactions WHERE id = ?`, p.TransactionID).Scan(&paid); err != nil {
+			return err
+		}
+		res, err := tx.Exec(`UPDATE transactions SET paid_amount = ?, due_amount = ?,
+			payment_method = CASE WHEN COALESCE(payment_method, '') IN ('', ?) THEN ? ELSE 'split' END WHERE id = ? AND due_amount = ?`,
+			paid+p.Amount, p.Due, line.Method, line.Method, p.TransactionID, p.Due+p.Amount)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errDuesChanged
+		}
+	}
+	return nil
+}
+
+func handleCreateContactPayment(c *fiber.Ctx) error {
+	var req struct {
+		ContactID   string              `json:"contact_id"`
+		Type        string              `json:"type"`
+		Amount      money               `json:"amount"`
+		Method      string              `json:"method"`
+		Reference   string              `json:"reference"`
+		AccountID   string              `json:"account_id"`
+		Date        string              `json:"date"`
+		Order       string              `json:"order"`
+		Allocations []paymentAllocation `json:"allocations"`
+		Preview     bool                `json:"preview"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	var contactType string
+	if err := dbFor(c).QueryRow(`SELECT type FROM contacts WHERE id = ? AND organization_id = ?`, req.ContactID, orgID).Scan(&contactType); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "contact not found"})
+	}
+	if req.Type == "" {
+		req.Type = "inflow"
+		if contactType == "supplier" {
+			req.Type = "outflow"
+		}
+	}
+	if req.Type != "inflow" && req.Type != "outflow" {
+		return c.Status(400).JSON(fiber.Map{"error": "type must be inflow or outflow"})
+	}
+	if req.Type == "outflow" && currentRole(c) == "cashier" {
+		return c.Status(403).JSON(fiber.Map{"error": "payments to suppliers are for managers"})
+	}
+	if req.Amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "amount must be positive"})
+	}
+	if req.Method == "" {
+		req.Method = "cash"
+	}
+	line := paymentLine{Method: req.Method, Amount: req.Amount.float(), Reference: req.Reference, AccountID: req.AccountID}
+	var err error
+	if line.paidAt, err = paymentDate(req.Date); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := parsePayments(dbFor(c), orgID, map[string]interface{}{"payments": []paymentLine{line}}); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	open, err := openDues(dbFor(c), orgID, req.Type, req.ContactID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	switch req.Order {
+	case "", "oldest":
+	case "due_date":
+		sortOpenDues(open)
+	default:
+		return c.Status(400).JSON(fiber.Map{"error": "order must be oldest or due_date"})
+	}
+	parts, status, problem := allocatePayment(open, req.Amount, req.Allocations)
+	if status != 0 {
+		return c.Status(status).JSON(problem)
+	}
+	var owed money
+	for _, d := range open {
+		owed += moneyOf(d.due)
+	}
+	out := fiber.Map{"contact_id": req.ContactID, "type": req.Type, "amount": req.Amount, "allocations": parts, "due": owed - req.Amount}
+	if req.Preview {
+		return c.JSON(out)
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if line.contactPaymentID, err = createContactPayment(tx, orgID, req.ContactID, req.Type, req.Amount, line, currentUserID(c)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := settleDues(tx, orgID, req.Type, line, parts); err == errDuesChanged {
+		return c.Status(409).JSON(fiber.Map{"error": errDuesChanged.Message})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, p := range parts {
+		publishRecord(orgID, "transactions", "update", p.TransactionID)
+	}
+	out["id"] = line.contactPaymentID
+	return c.JSON(out)
+}
+
+func handleListContactPayments(c *fiber.Ctx) error {
+	query := `SELECT p.id, p.contact_id, ct.name AS contact_name, p.type, p.method, p.amount, COALESCE(p.reference, '') AS reference, COALESCE(p.account_id, '') AS account_id, p.paid_at, p.created_at,
+		(SELECT COUNT(1) FROM transaction_payments WHERE contact_payment_id = p.id) AS transactions
+		FROM contact_payments p LEFT JOIN contacts ct ON ct.id = p.contact_id WHERE p.organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	for _, f := range []struct{ param, cond string }{{"contact_id", "p.contact_id = ?"}, {"type", "p.type = ?"}, {"from", "p.paid_at >= ?"}, {"to", "p.paid_at < ?"}} {
+		if v := c.Query(f.param); v != "" {
+			query, args = query+" AND "+f.cond, append(args, v)
+		}
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY p.paid_at DESC, p.created_at DESC LIMIT 500`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	moneyColumns(items, "amount")
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func handleGetContactPayment(c *fiber.Ctx) error {
+	id := c.Params("id")
+	rows, err := dbFor(c).Query(`SELECT p.id, p.contact_id, ct.name AS contact_name, p.type, p.method, p.amount, COALESCE(p.reference, '') AS reference, COALESCE(p.account_id, '') AS account_id, COALESCE(p.recorded_by, '') AS recorded_by, p.paid_at, p.created_at
+		FROM contact_payments p LEFT JOIN contacts ct ON ct.id = p.contact_id WHERE p.id = ? AND p.organization_id = ?`, id, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	payment, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(payment) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err = dbFor(c).Query(`SELECT l.transaction_id, l.amount, t.receipt_number, t.amount AS transaction_amount, t.due_amount, t.created_at
+		FROM transaction_payments l JOIN transactions t ON t.id = l.transaction_id WHERE l.contact_payment_id = ? ORDER BY t.created_at`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	parts, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	moneyColumns(payment, "amount")
+	moneyColumns(parts, "amount", "transaction_amount", "due_amount")
+	out := payment[0]
+	out["allocations"] = parts
+	return c.JSON(out)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestContactPayments(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-2','Karim','','customer','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('jan','inflow',10000,0,10000,'c-1','org-1','2024-01-05T00:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,payment_method,organization_id,created_at) VALUES ('feb','inflow',20000,5000,15000,'c-1','cash','org-1','2024-02-05T00:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('mar','inflow',8000,0,8000,'c-1','org-1','2024-03-05T00:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('other','inflow',7000,0,7000,'c-2','org-1','2024-01-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	due := func(id string) float64 {
+		t.Helper()
+		var d money
+		if err := db.QueryRow(`SELECT due_amount FROM transactions WHERE id = ?`, id).Scan(&d); err != nil {
+			t.Fatal(err)
+		}
+		return d.float()
+	}
+
+	if code, out := call("cashier", "POST", "/api/payments", `{"contact_id":"c-1","amount":400}`); code != 400 || out["due"] != 330.0 {
+		t.Errorf("more than owed: %d %v", code, out)
+	}
+	for _, body := range []string{
+		`{"contact_id":"c-1","amount":0}`,
+		`{"contact_id":"c-1","amount":50,"order":"newest"}`,
+		`{"contact_id":"c-1","amount":50,"allocations":[{"transaction_id":"jan","amount":30}]}`,
+		`{"contact_id":"c-1","amount":50,"allocations":[{"transaction_id":"jan","amount":150}]}`,
+	} {
+		if code, _ := call("cashier", "POST", "/api/payments", body); code != 400 {
+			t.Errorf("%s: got %d, want 400", body, code)
+		}
+	}
+	if code, _ := call("cashier", "POST", "/api/payments", `{"contact_id":"c-1","amount":50,"allocations":[{"transaction_id":"other","amount":50}]}`); code != 404 {
+		t.Errorf("another contact's sale: got %d, want 404", code)
+	}
+	if code, _ := call("cashier", "POST", "/api/payments", `{"contact_id":"c-1","type":"outflow","amount":50}`); code != 403 {
+		t.Errorf("cashier paying out: got %d, want 403", code)
+	}
+
+	// a preview records nothing
+	code, out := call("cashier", "POST", "/api/payments", `{"contact_id":"c-1","amount":180,"preview":true}`)
+	if parts, _ := out["allocations"].([]interface{}); code != 200 || len(parts) != 2 || out["id"] != nil || due("jan") != 100 {
+		t.Fatalf("preview: %d %v", code, out)
+	}
+
+	// oldest first, the last one part paid
+	code, out = call("cashier", "POST", "/api/payments", `{"contact_id":"c-1","amount":180,"method":"bkash","reference":"TX9"}`)
+	if code != 200 || out["due"] != 150.0 {
+		t.Fatalf("payment: %d %v", code, out)
+	}
+	parts, _ := out["allocations"].([]interface{})
+	if len(parts) != 2 || parts[0].(map[string]interface{})["amount"] != 100.0 || parts[1].(map[string]interface{})["due"] != 70.0 {
+		t.Errorf("allocations: %v", parts)
+	}
+	if due("jan") != 0 || due("feb") != 70 || due("mar") != 80 {
+		t.Errorf("due after payment: %v %v %v", due("jan"), due("feb"), due("mar"))
+	}
+	id := toString(out["id"])
+	_, sale := call("cashier", "GET", "/api/collections/transactions/records/feb", "")
+	if sale["paid_amount"] != 130.0 || sale["payment_method"] != "split" || len(sale["payments"].([]interface{})) != 2 {
+		t.Errorf("part-paid sale: %v", sale)
+	}
+
+	// allocations given by hand
+	code, out = call("manager", "POST", "/api/payments", `{"contact_id":"c-1","amount":90,"date":"2024-04-01",
+		"allocations":[{"transaction_id":"mar","amount":80},{"transaction_id":"feb","amount":10}]}`)
+	if code != 200 || due("mar") != 0 || due("feb") != 60 {
+		t.Fatalf("allocated payment: %d %v", code, out)
+	}
+
+	_, list := call("viewer", "GET", "/api/payments?contact_id=c-1", "")
+	if items, _ := list["items"].([]interface{}); len(items) != 2 || items[0].(map[string]interface{})["amount"] != 180.0 || items[1].(map[string]interface{})["transactions"] != 2.0 {
+		t.Errorf("list: %v", list)
+	}
+	code, got := call("viewer", "GET", "/api/payments/"+id, "")
+	if lines, _ := got["allocations"].([]interface{}); code != 200 || got["contact_name"] != "Rahim" || got["reference"] != "TX9" || len(lines) != 2 {
+		t.Errorf("payment detail: %d %v", code, got)
+	}
+	if code, _ := call("viewer", "GET", "/api/payments/nope", ""); code != 404 {
+		t.Errorf("unknown payment: got %d, want 404", code)
+	}
+
+	// a sale a contact payment went to cannot be voided from under it
+	if code, out := call("manager", "POST", "/api/transactions/jan/void", `{}`); code != 409 || out["error"] != "cannot void: a contact payment has been allocated to it" {
+		t.Errorf("voiding an allocated sale: %d %v", code, out)
+	}
+	if due("jan") != 0 {
+		t.Errorf("jan due after refused void = %v", due("jan"))
+	}
+	if code, _ := call("manager", "POST", "/api/transactions/other/void", `{}`); code != 200 {
+		t.Errorf("voiding an unallocated sale: got %d", code)
+	}
+
+	// a payment allocated against feb's old due is not settled over one
+	// recorded meanwhile
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE transactions SET paid_amount = paid_amount + 2000, due_amount = due_amount - 2000 WHERE id = 'feb'`); err != nil {
+		t.Fatal(err)
+	}
+	stale := []paymentAllocation{{TransactionID: "feb", Amount: moneyOf(50), Due: moneyOf(10)}}
+	if err := settleDues(tx, "org-1", "inflow", paymentLine{Method: "cash"}, stale); err != errDuesChanged {
+		t.Errorf("settling against a stale due: %v", err)
+	}
+}
@@ -0,0 +1,834 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Goods coming back on a sale (or going back to the supplier on a
+// purchase) are returned against the transaction: a credit note, numbered
+// from the credit_note sequence, lists the returned units at what they
+// were sold for, with their share of the line's tax. The units go back
+// into stock (or out of it, for a purchase) at the location and in the
+// batches the transaction moved them through.
+//
+// The credit note is settled one of two ways:
+//
+//   - refund: the money goes back at once, out of (or, for a purchase,
+//     into) the account of the method given;
+//   - credit: it stays with the contact as credit, which pays for later
+//     transactions of the same type with them through apply-credit, or is
+//     refunded later, in whole or in part.
+//
+// Every refund is recorded with its method and reference, so refunds show
+// in the daily closing and take cash off the balance sheet.
+//
+// A transaction with returns can no longer be edited or voided. Voiding a
+// transaction paid with credit gives the credit back to its credit notes.
+//
+// Every return gives its reason as one of returnReasons, with any detail
+// in reason_note, so the returns report can show how often each item, and
+// the goods of each supplier, come back and why.
+//
+//	POST /api/transactions/:id/returns        {"items": [{"item_id", "quantity", "location_id"}], "settlement": "credit" | "refund", "method", "reference", "account_id", "reason", "reason_note"}
+//	GET  /api/transactions/:id/returns        the credit notes of a transaction
+//	POST /api/transactions/:id/apply-credit   {"amount"}: pay what is due from the contact's credit, oldest first
+//	GET  /api/contacts/:id/credit             the contact's open credit notes and balances
+//	GET  /api/credit-notes                    ?contact_id=, ?transaction_id=
+//	GET  /api/credit-notes/:id                with its refunds
+//	POST /api/credit-notes/:id/refund         {"amount", "method", "reference", "account_id"}: pay out credit
+//	GET  /api/refunds                         ?from=, ?to=, ?method=, ?contact_id=
+//	GET  /api/reports/returns                 ?from=, ?to=, ?type=inflow | outflow: return rates and reasons per item and supplier
+
+func registerCreditNoteRoutes(app *fiber.App) {
+	app.Post("/api/transactions/:id/returns", requireAuth, requireRole("admin", "manager", "cashier"), handleCreateReturn)
+	app.Get("/api/transactions/:id/returns", requireAuth, handleListTransactionReturns)
+	app.Post("/api/transactions/:id/apply-credit", requireAuth, requireRole("admin", "manager", "cashier"), handleApplyCredit)
+	app.Get("/api/contacts/:id/credit", requireAuth, handleContactCredit)
+	app.Get("/api/credit-notes", requireAuth, handleListCreditNotes)
+	app.Get("/api/credit-notes/:id", requireAuth, handleGetCreditNote)
+	app.Post("/api/credit-notes/:id/refund", requireAuth, requireRole("admin", "manager"), handleRefundCreditNote)
+	app.Get("/api/refunds", requireAuth, handleListRefunds)
+	app.Get("/api/reports/returns", requireAuth, cachedReport, handleReturnsReport)
+}
+
+// returnReasons are the reasons a return can give.
+var returnReasons = []string{"damaged", "defective", "wrong_item", "expired", "not_as_described", "unwanted", "other"}
+
+func isReturnReason(reason string) bool {
+	for _, r := range returnReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// returnedLine is a line of a credit note.
+type returnedLine struct {
+	ItemID     string `json:"item_id"`
+	Quantity   int    `json:"quantity"`
+	LocationID string `json:"location_id"`
+	unitPrice  money
+	total, tax money
+}
+
+// soldLine is what a transaction moved of an item at a location, and how
+// much of it has come back already.
+type soldLine struct {
+	quantity, returned int
+	total, tax         money
+}
+
+func handleCreateReturn(c *fiber.Ctx) error {
+	var req struct {
+		Items      []returnedLine `json:"items"`
+		Settlement string         `json:"settlement"`
+		Method     string         `json:"method"`
+		Reference  string         `json:"reference"`
+		AccountID  string         `json:"account_id"`
+		Reason     string         `json:"reason"`
+		ReasonNote string         `json:"reason_note"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	req.ReasonNote = strings.TrimSpace(req.ReasonNote)
+	if !isReturnReason(req.Reason) {
+		return c.Status(400).JSON(fiber.Map{"error": "reason must be one of " + strings.Join(returnReasons, ", ")})
+	}
+	if req.Settlement == "" {
+		req.Settlement = "credit"
+	}
+	if req.Settlement != "credit" && req.Settlement != "refund" {
+		return c.Status(400).JSON(fiber.Map{"error": "settlement must be credit or refund"})
+	}
+	if len(req.Items) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "items required"})
+	}
+	orgID, id := currentOrgID(c), c.Params("id")
+
+	var typ, contactID, source string
+	var voidedAt sql.NullString
+	err := dbFor(c).QueryRow(`SELECT type, COALESCE(contact_id, ''), COALESCE(source, ''), voided_at FROM transactions WHERE id = ? AND organization_id = ?`, id, orgID).
+		Scan(&typ, &contactID, &source, &voidedAt)
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	switch {
+	case voidedAt.Valid:
+		return c.Status(409).JSON(fiber.Map{"error": "transaction is voided"})
+	case source == "opening":
+		return c.Status(409).JSON(fiber.Map{"error": "opening balances cannot be returned"})
+	case typ == "outflow" && currentRole(c) == "cashier":
+		return c.Status(403).JSON(fiber.Map{"error": "returns to suppliers are for managers"})
+	}
+	if req.Settlement == "credit" && contactID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "the transaction has no contact to hold the credit; refund it instead"})
+	}
+	var refund paymentLine
+	if req.Settlement == "refund" {
+		if req.Method == "" {
+			req.Method = "cash"
+		}
+		refund = paymentLine{Method: req.Method, Amount: 1, Reference: strings.TrimSpace(req.Reference), AccountID: req.AccountID}
+		if _, err := parsePayments(dbFor(c), orgID, map[string]interface{}{"payments": []paymentLine{refund}}); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	sold, err := returnableLines(dbFor(c), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	included, _ := orgSetting(orgID, "prices_include_tax").(bool)
+	var amount, taxAmount money
+	for i := range req.Items {
+		l := &req.Items[i]
+		if l.Quantity <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "quantity must be a whole number above 0"})
+		}
+		key := editKey{l.ItemID, l.LocationID}
+		if l.LocationID == "" {
+			// a line without a location is the item wherever it was sold,
+			// as long as that was one place
+			var found []editKey
+			for k := range sold {
+				if k.itemID == l.ItemID {
+					found = append(found, k)
+				}
+			}
+			if len(found) > 1 {
+				return c.Status(400).JSON(fiber.Map{"error": "item " + l.ItemID + " moved at several locations; give location_id"})
+			}
+			if len(found) == 1 {
+				key = found[0]
+			}
+		}
+		s, ok := sold[key]
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "item " + l.ItemID + " is not on this transaction"})
+		}
+		if left := s.quantity - s.returned; l.Quantity > left {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("only %d of item %s can still be returned", left, l.ItemID)})
+		}
+		s.returned += l.Quantity
+		l.LocationID = key.locationID
+		share := float64(l.Quantity) / float64(s.quantity)
+		l.total, l.tax = moneyOf(s.total.float()*share), moneyOf(s.tax.float()*share)
+		l.unitPrice = moneyOf(s.total.float() / float64(s.quantity))
+		amount += l.total
+		if !included {
+			amount += l.tax
+		}
+		taxAmount += l.tax
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	number, err := nextSequenceNumber(tx, orgID, "credit_note")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	noteID, now := genID(), time.Now().Format(time.RFC3339)
+	var remaining money
+	if req.Settlement == "credit" {
+		remaining = amount
+	}
+	if _, err := tx.Exec(`INSERT INTO credit_notes (id,organization_id,number,transaction_id,contact_id,type,amount,tax_amount,settlement,credit_remaining,reason,reason_note,created_by,created_at) VALUES (?,?,?,?,NULLIF(?, ''),?,?,?,?,?,?,NULLIF(?, ''),?,?)`,
+		noteID, orgID, number, id, contactID, typ, amount.float(), taxAmount.float(), req.Settlement, remaining.float(), req.Reason, req.ReasonNote, currentUserID(c), now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	notes := "Credit note " + number
+	for _, l := range req.Items {
+		if _, err := tx.Exec(`INSERT INTO credit_note_items (id,credit_note_id,item_id,quantity,unit_price,total_price,tax_amount,location_id) VALUES (?,?,?,?,?,?,?,NULLIF(?, ''))`,
+			genID(), noteID, l.ItemID, l.Quantity, l.unitPrice.float(), l.total.float(), l.tax.float(), l.LocationID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		// returned sales come back into stock, returned purchases leave it
+		change := l.Quantity
+		if typ == "outflow" {
+			change = -change
+		}
+		if status, err := adjustStock(tx, l.ItemID, change, "return", notes); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		if status, err := moveLocationStock(tx, orgID, l.ItemID, l.LocationID, change); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := unwindBatches(tx, l.ItemID, id, l.Quantity); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if req.Settlement == "refund" {
+		refund.Amount = amount.float()
+		if err := recordRefund(tx, orgID, noteID, id, contactID, typ, currentUserID(c), refund); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "transactions", "update", id)
+	for _, l := range req.Items {
+		publishRecord(orgID, "inventory_items", "update", l.ItemID)
+	}
+	note, err := loadCreditNote(dbFor(c), orgID, noteID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(note)
+}
+
+// returnableLines reads what transaction id moved, per item and location,
+// and how much of it earlier credit notes returned.
+func returnableLines(q *DB, id string) (map[editKey]*soldLine, error) {
+	rows, err := q.Query(`SELECT item_id, COALESCE(location_id, ''), SUM(quantity), SUM(total_price), SUM(COALESCE(tax_amount, 0)) FROM transaction_items WHERE transaction_id = ? GROUP BY item_id, COALESCE(location_id, '')`, id)
+	if err != nil {
+		return nil, err
+	}
+	sold := map[editKey]*soldLine{}
+	for rows.Next() {
+		var k editKey
+		var s soldLine
+		if err := rows.Scan(&k.itemID, &k.locationID, &s.quantity, &s.total, &s.tax); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		sold[k] = &s
+	}
+	rows.Close()
+	rows, err = q.Query(`SELECT ci.item_id, COALESCE(ci.location_id, ''), SUM(ci.quantity) FROM credit_note_items ci JOIN credit_notes n ON n.id = ci.credit_note_id WHERE n.transaction_id = ? GROUP BY ci.item_id, COALESCE(ci.location_id, '')`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k editKey
+		var returned int
+		if err := rows.Scan(&k.itemID, &k.locationID, &returned); err != nil {
+			return nil, err
+		}
+		if s, ok := sold[k]; ok {
+			s.returned = returned
+		}
+	}
+	return sold, rows.Err()
+}
+
+// recordRefund records line as money paid back on credit note noteID
+// inside tx and moves it out of (for a returned purchase, into) the
+// account line lands in.
+func recordRefund(tx *Tx, orgID, noteID, transactionID, contactID, typ, userID string, line paymentLine) error {
+	accountID, err := accountForPayment(tx, orgID, line)
+	if err != nil {
+		return err
+	}
+	refundID := genID()
+	if _, err := tx.Exec(`INSERT INTO refunds (id,organization_id,credit_note_id,transaction_id,contact_id,type,method,reference,account_id,amount,created_by,created_at) VALUES (?,?,?,?,NULLIF(?, ''),?,?,NULLIF(?, ''),NULLIF(?, ''),?,?,?)`,
+		refundID, orgID, noteID, transactionID, contactID, typ, line.Method, line.Reference, accountID, line.Amount, userID, time.Now().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	if accountID == "" {
+		return nil
+	}
+	amount := -line.Amount
+	if typ == "outflow" {
+		amount = line.Amount
+	}
+	return recordMovement(tx, accountID, amount, "refund", "credit_note", noteID, line.Method)
+}
+
+// handleRefundCreditNote pays out credit a contact holds on a credit note,
+// all that is left of it or amount.
+func handleRefundCreditNote(c *fiber.Ctx) error {
+	var req struct {
+		Amount    float64 `json:"amount"`
+		Method    string  `json:"method"`
+		Reference string  `json:"reference"`
+		AccountID string  `json:"account_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	if req.Amount < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "amount must be positive"})
+	}
+	if req.Method == "" {
+		req.Method = "cash"
+	}
+	orgID, noteID := currentOrgID(c), c.Params("id")
+	line := paymentLine{Method: req.Method, Amount: 1, Reference: strings.TrimSpace(req.Reference), AccountID: req.AccountID}
+	if _, err := parsePayments(dbFor(c), orgID, map[string]interface{}{"payments": []paymentLine{line}}); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var transactionID, contactID, typ string
+	var remaining float64
+	err = tx.QueryRow(`SELECT transaction_id, COALESCE(contact_id, ''), type, credit_remaining FROM credit_notes WHERE id = ? AND organization_id = ?`, noteID, orgID).
+		Scan(&transactionID, &contactID, &typ, &remaining)
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	amount := moneyOf(remaining)
+	if amount <= 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "no credit is left on this credit note"})
+	}
+	if req.Amount > 0 {
+		if moneyOf(req.Amount) > amount {
+			return c.Status(400).JSON(fiber.Map{"error": "refund is more than the credit left", "credit_remaining": amount})
+		}
+		amount = moneyOf(req.Amount)
+	}
+	// credit_remaining is matched so credit spent meanwhile is not paid out too
+	res, err := tx.Exec(`UPDATE credit_notes SET credit_remaining = ? WHERE id = ? AND credit_remaining = ?`, (moneyOf(remaining) - amount).float(), noteID, remaining)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "the credit changed meanwhile; try again"})
+	}
+	line.Amount = amount.float()
+	if err := recordRefund(tx, orgID, noteID, transactionID, contactID, typ, currentUserID(c), line); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	note, err := loadCreditNote(dbFor(c), orgID, noteID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(note)
+}
+
+const refundSQL = `SELECT r.id, r.credit_note_id, COALESCE(n.number, '') AS credit_note_number, r.transaction_id, COALESCE(r.contact_id, '') AS contact_id, COALESCE(ct.name, '') AS contact_name,
+	r.type, r.method, COALESCE(pm.name, r.method) AS method_name, COALESCE(r.reference, '') AS reference, COALESCE(r.account_id, '') AS account_id, r.amount, COALESCE(r.created_by, '') AS created_by, r.created_at
+	FROM refunds r LEFT JOIN credit_notes n ON n.id = r.credit_note_id LEFT JOIN contacts ct ON ct.id = r.contact_id
+	LEFT JOIN payment_methods pm ON pm.code = r.method AND pm.organization_id = r.organization_id
+	WHERE r.organization_id = ?`
+
+// listRefunds reads orgID's refunds matching where, oldest first.
+func listRefunds(q *DB, orgID, where string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := q.Query(refundSQL+where+` ORDER BY r.created_at, r.id`, append([]interface{}{orgID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	refunds, err := rowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+	moneyColumns(refunds, "amount")
+	return refunds, nil
+}
+
+func handleListRefunds(c *fiber.Ctx) error {
+	var where string
+	var args []interface{}
+	for _, f := range []struct{ param, cond string }{
+		{"method", ` AND r.method = ?`},
+		{"contact_id", ` AND r.contact_id = ?`},
+		{"transaction_id", ` AND r.transaction_id = ?`},
+	} {
+		if v := c.Query(f.param); v != "" {
+			where += f.cond
+			args = append(args, v)
+		}
+	}
+	for _, f := range []struct{ param, cond string }{
+		{"from", ` AND r.created_at >= ?`},
+		{"to", ` AND r.created_at < ?`},
+	} {
+		if v := c.Query(f.param); v != "" {
+			t, err := parseTime(v)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": f.param + " must be a date (YYYY-MM-DD)"})
+			}
+			where += f.cond
+			args = append(args, t.Format(time.RFC3339))
+		}
+	}
+	refunds, err := listRefunds(dbFor(c), currentOrgID(c), where, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": refunds})
+}
+
+const creditNoteSQL = `SELECT n.id, n.number, n.transaction_id, COALESCE(n.contact_id, '') AS contact_id, COALESCE(ct.name, '') AS contact_name, n.type, n.amount, n.tax_amount,
+	n.settlement, n.credit_remaining, COALESCE(n.reason, '') AS reason, COALESCE(n.reason_note, '') AS reason_note, COALESCE(n.created_by, '') AS created_by, n.created_at
+	FROM credit_notes n LEFT JOIN contacts ct ON ct.id = n.contact_id WHERE n.organization_id = ?`
+
+// listCreditNotes reads orgID's credit notes matching where, oldest first.
+func listCreditNotes(q *DB, orgID, where string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := q.Query(creditNoteSQL+where+` ORDER BY n.created_at, n.number`, append([]interface{}{orgID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	notes, err := rowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+	moneyColumns(notes, "amount", "tax_amount", "credit_remaining")
+	return notes, nil
+}
+
+// loadCreditNote reads one credit note with its lines.
+func loadCreditNote(q *DB, orgID, id string) (map[string]interface{}, error) {
+	notes, err := listCreditNotes(q, orgID, ` AND n.id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(notes) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	rows, err := q.Query(`SELECT ci.item_id, COALESCE(i.name, '') AS name, ci.quantity, ci.unit_price, ci.total_price, ci.tax_amount, COALESCE(ci.location_id, '') AS location_id
+		FROM credit_note_items ci LEFT JOIN inventory_items i ON i.id = ci.item_id WHERE ci.credit_note_id = ? ORDER BY name, ci.item_id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+	moneyColumns(items, "unit_price", "total_price", "tax_amount")
+	notes[0]["items"] = items
+	refunds, err := listRefunds(q, orgID, ` AND r.credit_note_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	notes[0]["refunds"] = refunds
+	return notes[0], nil
+}
+
+func handleListTransactionReturns(c *fiber.Ctx) error {
+	orgID, id := currentOrgID(c), c.Params("id")
+	if !orgOwns(dbFor(c), "transactions", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	notes, err := listCreditNotes(dbFor(c), orgID, ` AND n.transaction_id = ?`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, n := range notes {
+		full, err := loadCreditNote(dbFor(c), orgID, toString(n["id"]))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		n["items"] = full["items"]
+	}
+	return c.JSON(fiber.Map{"items": notes})
+}
+
+func handleListCreditNotes(c *fiber.Ctx) error {
+	var where string
+	var args []interface{}
+	if v := c.Query("contact_id"); v != "" {
+		where += ` AND n.contact_id = ?`
+		args = append(args, v)
+	}
+	if v := c.Query("transaction_id"); v != "" {
+		where += ` AND n.transaction_id = ?`
+		args = append(args, v)
+	}
+	notes, err := listCreditNotes(dbFor(c), currentOrgID(c), where, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": notes})
+}
+
+func handleGetCreditNote(c *fiber.Ctx) error {
+	note, err := loadCreditNote(dbFor(c), currentOrgID(c), c.Params("id"))
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	return c.JSON(note)
+}
+
+// handleContactCredit answers the credit a contact holds: sales_credit
+// pays for their purchases from us, purchase_credit for ours from them.
+func handleContactCredit(c *fiber.Ctx) error {
+	orgID, id := currentOrgID(c), c.Params("id")
+	if !orgOwns(dbFor(c), "contacts", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	notes, err := listCreditNotes(dbFor(c), orgID, ` AND n.contact_id = ? AND n.credit_remaining > 0`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var sales, purchases money
+	for _, n := range notes {
+		remaining, _ := n["credit_remaining"].(money)
+		if toString(n["type"]) == "inflow" {
+			sales += remaining
+		} else {
+			purchases += remaining
+		}
+	}
+	return c.JSON(fiber.Map{"contact_id": id, "sales_credit": sales, "purchase_credit": purchases, "notes": notes})
+}
+
+// handleApplyCredit pays what is due on a transaction from the credit its
+// contact holds from returns of the same type, drawing on the oldest
+// credit notes first. Each credit note drawn on becomes a payment line
+// with method credit_note; no money moves.
+func handleApplyCredit(c *fiber.Ctx) error {
+	var req struct {
+		Amount float64 `json:"amount"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	if req.Amount < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "amount must be positive"})
+	}
+	orgID, id := currentOrgID(c), c.Params("id")
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var typ, contactID string
+	var paid, due money
+	var voidedAt sql.NullString
+	err = tx.QueryRow(`SELECT type, COALESCE(contact_id, ''), paid_amount, due_amount, voided_at FROM transactions WHERE id = ? AND organization_id = ?`, id, orgID).
+		Scan(&typ, &contactID, &paid, &due, &voidedAt)
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	switch {
+	case voidedAt.Valid:
+		return c.Status(409).JSON(fiber.Map{"error": "transaction is voided"})
+	case typ == "outflow" && currentRole(c) == "cashier":
+		return c.Status(403).JSON(fiber.Map{"error": "payments to suppliers are for managers"})
+	case contactID == "":
+		return c.Status(400).JSON(fiber.Map{"error": "transaction has no contact"})
+	case due <= 0:
+		return c.Status(409).JSON(fiber.Map{"error": "nothing is due"})
+	}
+	want := due
+	if req.Amount > 0 {
+		if moneyOf(req.Amount) > want {
+			return c.Status(400).JSON(fiber.Map{"error": "credit is more than is due", "due": want})
+		}
+		want = moneyOf(req.Amount)
+	}
+
+	rows, err := tx.Query(`SELECT id, number, credit_remaining FROM credit_notes WHERE organization_id = ? AND contact_id = ? AND type = ? AND credit_remaining > 0 ORDER BY created_at, number`, orgID, contactID, typ)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var lines []paymentLine
+	var applied money
+	for rows.Next() && applied < want {
+		var noteID, number string
+		var remaining float64
+		if err := rows.Scan(&noteID, &number, &remaining); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		take := moneyOf(remaining)
+		if take > want-applied {
+			take = want - applied
+		}
+		applied += take
+		lines = append(lines, paymentLine{Method: "credit_note", Amount: take.float(), Reference: number, creditNoteID: noteID})
+	}
+	rows.Close()
+	if applied == 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "the contact has no credit to apply"})
+	}
+	for _, l := range lines {
+		// credit_remaining is matched so credit spent meanwhile is not spent twice
+		res, err := tx.Exec(`UPDATE credit_notes SET credit_remaining = credit_remaining - ? WHERE id = ? AND credit_remaining >= ?`, l.Amount, l.creditNoteID, l.Amount)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return c.Status(409).JSON(fiber.Map{"error": "the credit changed meanwhile; try again"})
+		}
+	}
+	if err := backfillLegacyPayment(tx, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := recordPayments(tx, orgID, id, typ, lines); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	res, err := tx.Exec(`UPDATE transactions SET paid_amount = ?, due_amount = ?,
+		payment_method = CASE WHEN COALESCE(payment_method, '') IN ('', 'credit_note') THEN 'credit_note' ELSE 'split' END WHERE id = ? AND due_amount = ?`,
+		paid+applied, due-applied, id, due)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "the transaction changed meanwhile; try again"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "transactions", "update", id)
+	t, err := Transactions(dbFor(c)).Get(c.UserContext(), orgID, id)
+	if err != nil {
+		return recordError(c, err)
+	}
+	return c.JSON(fiber.Map{"transaction": t, "applied": applied})
+}
+
+// returnRate is how much of an item, or of a supplier's goods, came back.
+type returnRate struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Moved    int           `json:"units_moved"`
+	Returned int           `json:"units_returned"`
+	Rate     *float64      `json:"return_rate"`
+	Reasons  []reasonCount `json:"top_reasons"`
+	byReason map[string]*reasonCount
+}
+
+// reasonCount is how many returns gave a reason and the units they took back.
+type reasonCount struct {
+	Reason  string `json:"reason"`
+	Returns int    `json:"returns"`
+	Units   int    `json:"units"`
+}
+
+// handleReturnsReport shows how often goods come back and why, to flag
+// quality problems: per item and per supplier, the units returned in the
+// period against the units that went out in it, with the reasons given
+// most. type is inflow (customers returning sales, the default) or outflow
+// (goods sent back to suppliers). The supplier of a sold item is its
+// supplier_id; of a purchase, the contact it was bought from. The rate is
+// a percentage, null when nothing went out in the period.
+func handleReturnsReport(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	typ := c.Query("type", "inflow")
+	if typ != "inflow" && typ != "outflow" {
+		return c.Status(400).JSON(fiber.Map{"error": "type must be inflow or outflow"})
+	}
+	orgID, q := currentOrgID(c), dbFor(c)
+	movedSupplier, returnedSupplier := "COALESCE(i.supplier_id, '')", "COALESCE(i.supplier_id, '')"
+	if typ == "outflow" {
+		movedSupplier, returnedSupplier = "COALESCE(t.contact_id, '')", "COALESCE(n.contact_id, '')"
+	}
+	period := []interface{}{orgID, typ, from.Format(time.RFC3339), to.Format(time.RFC3339)}
+
+	items, suppliers := map[string]*returnRate{}, map[string]*returnRate{}
+	rate := func(m map[string]*returnRate, id, name string) *returnRate {
+		r, ok := m[id]
+		if !ok {
+			r = &returnRate{ID: id, Name: name, byReason: map[string]*reasonCount{}}
+			m[id] = r
+		}
+		return r
+	}
+	totals := map[string]*reasonCount{}
+	rows, err := q.Query(`SELECT ci.item_id, COALESCE(i.name, ''), `+returnedSupplier+`, COALESCE(s.name, ''), n.reason, COUNT(DISTINCT n.id), SUM(ci.quantity)
+		FROM credit_note_items ci JOIN credit_notes n ON n.id = ci.credit_note_id LEFT JOIN inventory_items i ON i.id = ci.item_id LEFT JOIN contacts s ON s.id = `+returnedSupplier+`
+		WHERE n.organization_id = ? AND n.type = ? AND n.created_at >= ? AND n.created_at < ?
+		GROUP BY ci.item_id, i.name, `+returnedSupplier+`, s.name, n.reason`, period...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		var itemID, itemName, supplierID, supplierName string
+		var rr reasonCount
+		if err := rows.Scan(&itemID, &itemName, &supplierID, &supplierName, &rr.Reason, &rr.Returns, &rr.Units); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		for _, r := range []*returnRate{rate(items, itemID, itemName), rate(suppliers, supplierID, supplierName)} {
+			r.Returned += rr.Units
+			if r.byReason[rr.Reason] == nil {
+				r.byReason[rr.Reason] = &reasonCount{Reason: rr.Reason}
+			}
+			r.byReason[rr.Reason].Returns += rr.Returns
+			r.byReason[rr.Reason].Units += rr.Units
+		}
+		if totals[rr.Reason] == nil {
+			totals[rr.Reason] = &reasonCount{Reason: rr.Reason}
+		}
+		totals[rr.Reason].Units += rr.Units
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	// a credit note counts once per reason in the totals, however many lines it has
+	rows, err = q.Query(`SELECT reason, COUNT(1) FROM credit_notes n WHERE organization_id = ? AND type = ? AND created_at >= ? AND created_at < ? GROUP BY reason`, period...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		var code string
+		var n int
+		if err := rows.Scan(&code, &n); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if totals[code] != nil {
+			totals[code].Returns = n
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	rows, err = q.Query(`SELECT ti.item_id, `+movedSupplier+`, SUM(ti.quantity)
+		FROM transaction_items ti JOIN transactions t ON t.id = ti.transaction_id LEFT JOIN inventory_items i ON i.id = ti.item_id
+		WHERE t.organization_id = ? AND t.type = ? AND t.created_at >= ? AND t.created_at < ? AND t.voided_at IS NULL
+		GROUP BY ti.item_id, `+movedSupplier, period...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		var itemID, supplierID string
+		var moved int
+		if err := rows.Scan(&itemID, &supplierID, &moved); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		// only what came back is reported
+		if r, ok := items[itemID]; ok {
+			r.Moved += moved
+		}
+		if r, ok := suppliers[supplierID]; ok {
+			r.Moved += moved
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{
+		"from": from.Format(time.RFC3339), "to": to.Format(time.RFC3339), "type": typ,
+		"reasons": sortedReasons(totals, 0), "items": sortedRates(items), "suppliers": sortedRates(suppliers),
+	})
+}
+
+// sortedRates lists rates most returned first, each with its three most
+// given reasons.
+func sortedRates(m map[string]*returnRate) []*returnRate {
+	list := make([]*returnRate, 0, len(m))
+	for _, r := range m {
+		if r.Moved > 0 {
+			pct := round2(float64(r.Returned) / float64(r.Moved) * 100)
+			r.Rate = &pct
+		}
+		r.Reasons = sortedReasons(r.byReason, 3)
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Returned != list[j].Returned {
+			return list[i].Returned > list[j].Returned
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// sortedReasons lists reasons by units returned, the first n of them or
+// all for 0.
+func sortedReasons(m map[string]*reasonCount, n int) []reasonCount {
+	list := make([]reasonCount, 0, len(m))
+	for _, r := range m {
+		list = append(list, *r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Units != list[j].Units {
+			return list[i].Units > list[j].Units
+		}
+		return list[i].Reason < list[j].Reason
+	})
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
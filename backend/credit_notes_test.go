@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReturnsAndCreditNotes(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Pens Ltd','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,supplier_id,organization_id) VALUES ('i-1','Pen','PEN',10,15,9,'s-1','org-1')`,
+		`INSERT INTO cash_accounts (id,name,kind,opening_balance,active,organization_id) VALUES ('a-1','Till','cash',100,1,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	stock := func() int {
+		t.Helper()
+		var quantity int
+		if err := db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = 'i-1'`).Scan(&quantity); err != nil {
+			t.Fatal(err)
+		}
+		return quantity
+	}
+
+	code, sale := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":60,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":4,"unit_price":15}],"payments":[{"method":"cash","amount":60,"account_id":"a-1"}]}`)
+	if code != 200 {
+		t.Fatalf("sale: %d %v", code, sale)
+	}
+	returns := "/api/transactions/" + toString(sale["id"]) + "/returns"
+	if code, _ := call("cashier", "POST", returns, `{"items":[{"item_id":"i-1","quantity":5}],"reason":"damaged"}`); code != 400 {
+		t.Errorf("returning more than was sold: got %d, want 400", code)
+	}
+	if code, _ := call("cashier", "POST", returns, `{"items":[{"item_id":"i-1","quantity":1}],"reason":"broken"}`); code != 400 {
+		t.Errorf("return without a reason code: got %d, want 400", code)
+	}
+	code, note := call("cashier", "POST", returns, `{"items":[{"item_id":"i-1","quantity":1}],"settlement":"refund","method":"cash","account_id":"a-1","reference":"slip 12","reason":"damaged","reason_note":"broken"}`)
+	if code != 201 || note["number"] != "CN-00001" || note["amount"] != 15.0 || note["credit_remaining"] != 0.0 || note["reason"] != "damaged" || note["reason_note"] != "broken" {
+		t.Fatalf("refund: %d %v", code, note)
+	}
+	if stock() != 7 {
+		t.Errorf("stock after return = %d, want 7", stock())
+	}
+	if balance, err := accountBalance(db, "org-1", "a-1"); err != nil || balance != 145 {
+		t.Errorf("till = %v (%v), want 145", balance, err)
+	}
+	if refunds, _ := note["refunds"].([]interface{}); len(refunds) != 1 || refunds[0].(map[string]interface{})["reference"] != "slip 12" {
+		t.Errorf("refund record: %v", note["refunds"])
+	}
+	code, note = call("manager", "POST", returns, `{"items":[{"item_id":"i-1","quantity":2}],"reason":"defective"}`)
+	if code != 201 || note["settlement"] != "credit" || note["credit_remaining"] != 30.0 {
+		t.Fatalf("credit: %d %v", code, note)
+	}
+	if code, _ := call("manager", "POST", returns, `{"items":[{"item_id":"i-1","quantity":2}],"reason":"defective"}`); code != 400 {
+		t.Errorf("returning past what is left: got %d, want 400", code)
+	}
+	_, credit := call("viewer", "GET", "/api/contacts/c-1/credit", "")
+	if credit["sales_credit"] != 30.0 {
+		t.Errorf("contact credit: %v", credit)
+	}
+
+	// the credit pays for the customer's next purchase
+	code, next := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":45,"paid_amount":0,"due_amount":45,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":3,"unit_price":15}]}`)
+	if code != 200 {
+		t.Fatalf("next sale: %d %v", code, next)
+	}
+	code, out := call("cashier", "POST", "/api/transactions/"+toString(next["id"])+"/apply-credit", "")
+	if code != 200 || out["applied"] != 30.0 {
+		t.Fatalf("apply credit: %d %v", code, out)
+	}
+	if tr := out["transaction"].(map[string]interface{}); tr["due_amount"] != 15.0 || tr["payment_method"] != "credit_note" {
+		t.Errorf("after credit: %v", tr)
+	}
+	if code, _ := call("cashier", "POST", "/api/transactions/"+toString(next["id"])+"/apply-credit", ""); code != 409 {
+		t.Errorf("credit spent twice: got %d, want 409", code)
+	}
+
+	_, listed := call("viewer", "GET", returns, "")
+	if notes, _ := listed["items"].([]interface{}); len(notes) != 2 || len(notes[0].(map[string]interface{})["items"].([]interface{})) != 1 {
+		t.Errorf("returns: %v", listed)
+	}
+	// credit left over can be paid out later
+	code, note = call("manager", "POST", returns, `{"items":[{"item_id":"i-1","quantity":1}],"reason":"damaged"}`)
+	if code != 201 {
+		t.Fatalf("credit: %d %v", code, note)
+	}
+	refund := "/api/credit-notes/" + toString(note["id"]) + "/refund"
+	if code, _ := call("manager", "POST", refund, `{"amount":20,"method":"bkash"}`); code != 400 {
+		t.Errorf("refunding more than the credit: got %d, want 400", code)
+	}
+	if code, out := call("manager", "POST", refund, `{"method":"bkash","reference":"TX99"}`); code != 200 || out["credit_remaining"] != 0.0 {
+		t.Errorf("refund of credit: %d %v", code, out)
+	}
+	_, closing := call("viewer", "GET", "/api/reports/daily-closing", "")
+	refunded := map[string]float64{}
+	for _, r := range closing["refunded"].([]interface{}) {
+		line := r.(map[string]interface{})
+		refunded[toString(line["method"])] = line["amount"].(float64)
+	}
+	if refunded["cash"] != 15 || refunded["bkash"] != 15 {
+		t.Errorf("refunded: %v", closing["refunded"])
+	}
+	_, listed = call("viewer", "GET", "/api/refunds?method=bkash", "")
+	if refunds, _ := listed["items"].([]interface{}); len(refunds) != 1 || refunds[0].(map[string]interface{})["credit_note_number"] != "CN-00003" {
+		t.Errorf("refunds: %v", listed)
+	}
+
+	// 4 of the 7 pens sold came back, 2 damaged and 2 defective
+	_, report := call("viewer", "GET", "/api/reports/returns?to="+time.Now().AddDate(0, 0, 1).Format("2006-01-02"), "")
+	items, _ := report["items"].([]interface{})
+	if len(items) != 1 {
+		t.Fatalf("returns report: %v", report)
+	}
+	pen := items[0].(map[string]interface{})
+	top, _ := pen["top_reasons"].([]interface{})
+	if pen["units_moved"] != 7.0 || pen["units_returned"] != 4.0 || pen["return_rate"] != 57.14 || len(top) != 2 {
+		t.Errorf("pen: %v", pen)
+	}
+	if reasons := report["reasons"].([]interface{}); len(reasons) != 2 || reasons[0].(map[string]interface{})["reason"] != "damaged" || reasons[0].(map[string]interface{})["returns"] != 2.0 {
+		t.Errorf("reasons: %v", report["reasons"])
+	}
+	if suppliers := report["suppliers"].([]interface{}); len(suppliers) != 1 || suppliers[0].(map[string]interface{})["id"] != "s-1" || suppliers[0].(map[string]interface{})["units_returned"] != 4.0 {
+		t.Errorf("suppliers: %v", report["suppliers"])
+	}
+
+	if code, _ := call("manager", "POST", "/api/transactions/"+toString(sale["id"])+"/void", ""); code != 409 {
+		t.Errorf("voiding a transaction with returns: got %d, want 409", code)
+	}
+	if code, _ := call("manager", "PATCH", "/api/collections/transactions/records/"+toString(sale["id"]), `{"amount":60}`); code != 409 {
+		t.Errorf("editing a transaction with returns: got %d, want 409", code)
+	}
+
+	// voiding the sale paid with credit gives the credit back
+	if code, out := call("manager", "POST", "/api/transactions/"+toString(next["id"])+"/void", ""); code != 200 {
+		t.Fatalf("void of a sale paid with credit: %d %v", code, out)
+	}
+	if _, credit := call("viewer", "GET", "/api/contacts/c-1/credit", ""); credit["sales_credit"] != 30.0 {
+		t.Errorf("contact credit after void: %v", credit)
+	}
+}
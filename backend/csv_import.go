@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Spreadsheet migrations come in through
+// POST /api/collections/:collection/import as a multipart CSV upload
+// (field "file"). Columns are matched to fields by header name, or by an
+// explicit "mapping" form field: {"CSV header": "field", ...}; unmapped
+// columns are ignored. Every row is validated first and errors are
+// reported per row; rows are only created when the whole file is valid,
+// in one database transaction. With dry_run=true nothing is written and
+// the parsed records are returned for review.
+
+// importField describes one importable field of a collection.
+type importField struct {
+	kind     string // "text", "int" or "number"
+	required bool
+}
+
+var importFields = map[string]map[string]importField{
+	"contacts": {
+		"name":  {kind: "text", required: true},
+		"phone": {kind: "text"},
+		"nid":   {kind: "text"},
+		"type":  {kind: "text"},
+	},
+	"inventory_items": {
+		"name":          {kind: "text", required: true},
+		"sku":           {kind: "text", required: true},
+		"quantity":      {kind: "int"},
+		"unit_price":    {kind: "number"},
+		"cost_price":    {kind: "number"},
+		"reorder_level": {kind: "int"},
+		"category":      {kind: "text"},
+		"description":   {kind: "text"},
+	},
+}
+
+type importRowError struct {
+	Row   int    `json:"row"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+func handleImport(c *fiber.Ctx) error {
+	collection := c.Params("collection")
+	fields, ok := importFields[collection]
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "import is not supported for " + collection})
+	}
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "file required"})
+	}
+	mapping := map[string]string{}
+	if m := c.FormValue("mapping"); m != "" {
+		if err := json.Unmarshal([]byte(m), &mapping); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "mapping must be a JSON object of column to field"})
+		}
+	}
+	dryRun := c.FormValue("dry_run", c.Query("dry_run")) == "true"
+
+	f, err := file.Open()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer f.Close()
+	orgID := currentOrgID(c)
+	records, rowErrors, err := parseImportCSV(f, collection, fields, mapping)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	rowErrors = append(rowErrors, validateImport(dbFor(c), orgID, collection, records)...)
+	sort.SliceStable(rowErrors, func(i, j int) bool { return rowErrors[i].Row < rowErrors[j].Row })
+
+	summary := fiber.Map{"rows": len(records), "errors": rowErrors, "dry_run": dryRun, "created": 0}
+	if dryRun {
+		summary["records"] = records
+	}
+	if dryRun || len(rowErrors) > 0 {
+		return c.JSON(summary)
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	ids := make([]string, len(records))
+	now := time.Now().Format(time.RFC3339)
+	for i, r := range records {
+		ids[i] = genID()
+		if err := insertImported(tx, orgID, collection, ids[i], r, now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": fmt.Sprintf("row %d: %v", r["row"], err)})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, id := range ids {
+		publishRecord(orgID, collection, "create", id)
+	}
+	summary["created"] = len(ids)
+	return c.JSON(summary)
+}
+
+// parseImportCSV turns the rows of a CSV file into records of typed field
+// values. Row numbers in errors count the header as row 1, as spreadsheets
+// do.
+func parseImportCSV(r io.Reader, collection string, fields map[string]importField, mapping map[string]string) ([]map[string]interface{}, []importRowError, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("csv header row is required")
+	}
+	col := map[string]int{} // field -> column
+	for i, h := range header {
+		h = strings.TrimSpace(strings.TrimPrefix(h, "\ufeff"))
+		field, ok := mapping[h]
+		if !ok {
+			field = strings.ReplaceAll(strings.ToLower(h), " ", "_")
+		}
+		if _, known := fields[field]; known {
+			col[field] = i
+		} else if ok {
+			return nil, nil, fmt.Errorf("mapping targets unknown field %s of %s", field, collection)
+		}
+	}
+	for name, f := range fields {
+		if _, ok := col[name]; f.required && !ok {
+			return nil, nil, fmt.Errorf("csv has no column for required field %s", name)
+		}
+	}
+
+	records := []map[string]interface{}{}
+	rowErrors := []importRowError{}
+	for row := 2; ; row++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if strings.TrimSpace(strings.Join(rec, "")) == "" {
+			continue // blank lines at the end of exported sheets
+		}
+		record := map[string]interface{}{}
+		for name, i := range col {
+			v := ""
+			if i < len(rec) {
+				v = strings.TrimSpace(rec[i])
+			}
+			f := fields[name]
+			if v == "" {
+				if f.required {
+					rowErrors = append(rowErrors, importRowError{Row: row, Field: name, Error: "is required"})
+				}
+				continue
+			}
+			switch f.kind {
+			case "int":
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					rowErrors = append(rowErrors, importRowError{Row: row, Field: name, Error: "must be a whole number of at least 0"})
+					continue
+				}
+				record[name] = n
+			case "number":
+				n, err := strconv.ParseFloat(strings.ReplaceAll(v, ",", ""), 64)
+				if err != nil || n < 0 {
+					rowErrors = append(rowErrors, importRowError{Row: row, Field: name, Error: "must be a number of at least 0"})
+					continue
+				}
+				record[name] = n
+			default:
+				record[name] = v
+			}
+		}
+		record["row"] = row
+		records = append(records, record)
+	}
+	return records, rowErrors, nil
+}
+
+// validateImport checks rules that span rows or need the database.
+func validateImport(q *DB, orgID, collection string, records []map[string]interface{}) []importRowError {
+	var rowErrors []importRowError
+	switch collection {
+	case "contacts":
+		for _, r := range records {
+			if t, ok := r["type"]; ok && t != "customer" && t != "supplier" {
+				rowErrors = append(rowErrors, importRowError{Row: r["row"].(int), Field: "type", Error: "must be customer or supplier"})
+			}
+		}
+	case "inventory_items":
+		seen := map[string]int{}
+		for _, r := range records {
+			sku, ok := r["sku"].(string)
+			if !ok {
+				continue
+			}
+			row := r["row"].(int)
+			if first, dup := seen[strings.ToLower(sku)]; dup {
+				rowErrors = append(rowErrors, importRowError{Row: row, Field: "sku", Error: fmt.Sprintf("repeats row %d", first)})
+				continue
+			}
+			seen[strings.ToLower(sku)] = row
+			// a SKU another item already has, or scans as (item_codes.go)
+			if owner, err := codeOwner(q, orgID, "", sku); err == nil && owner != "" {
+				rowErrors = append(rowErrors, importRowError{Row: row, Field: "sku", Error: "already exists"})
+			}
+		}
+	}
+	return rowErrors
+}
+
+func insertImported(tx *Tx, orgID, collection, id string, r map[string]interface{}, now string) error {
+	switch collection {
+	case "contacts":
+		contactType := r["type"]
+		if contactType == nil {
+			contactType = "customer"
+		}
+		_, err := tx.Exec(`INSERT INTO contacts (id,name,phone,nid,type,organization_id,created_at) VALUES (?,?,?,?,?,?,?)`, id, r["name"], r["phone"], r["nid"], contactType, orgID, now)
+		return err
+	case "inventory_items":
+		qty, _ := r["quantity"].(int)
+		price, _ := r["unit_price"].(float64)
+		cost, _ := r["cost_price"].(float64)
+		reorder, _ := r["reorder_level"].(int)
+		if _, err := tx.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,reorder_level,category,description,organization_id,updated_at,created_at) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)`,
+			id, r["name"], r["sku"], qty, price, cost, reorder, r["category"], r["description"], orgID, now, now); err != nil {
+			return err
+		}
+		if qty > 0 {
+			_, err := tx.Exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,?)`, genID(), id, qty, 0, qty, "initial", "CSV import", orgID, now)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSVImportDryRunThenCommit(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,'org-1')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	token := testToken(t, "admin")
+	post := func(csv string, fields map[string]string) (int, map[string]interface{}) {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "items.csv")
+		fw.Write([]byte(csv))
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		mw.Close()
+		req := httptest.NewRequest("POST", "/api/collections/inventory_items/import", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	count := func() int {
+		var n int
+		_ = db.QueryRow(`SELECT COUNT(1) FROM inventory_items`).Scan(&n)
+		return n
+	}
+
+	bad := "Item Name,Code,Qty,Price\nNotebook,NB,5,40\nPencil,PEN,x,10\nEraser,NB,1,5\n"
+	mapping := map[string]string{"mapping": `{"Item Name":"name","Code":"sku","Qty":"quantity","Price":"unit_price"}`}
+	code, out := post(bad, mapping)
+	if code != 200 {
+		t.Fatalf("import: %d %v", code, out)
+	}
+	errs, _ := out["errors"].([]interface{})
+	// row 3: bad quantity and existing sku; row 4: repeats row 2
+	if len(errs) != 3 || out["created"].(float64) != 0 || count() != 1 {
+		t.Fatalf("invalid file: %v, %d items", out, count())
+	}
+
+	good := "Item Name,Code,Qty,Price\nNotebook,NB,5,40\nEraser,ER,,5\n"
+	mapping["dry_run"] = "true"
+	if _, out = post(good, mapping); len(out["records"].([]interface{})) != 2 || count() != 1 {
+		t.Fatalf("dry run wrote rows or returned no preview: %v", out)
+	}
+	delete(mapping, "dry_run")
+	if _, out = post(good, mapping); out["created"].(float64) != 2 || count() != 3 {
+		t.Fatalf("commit: %v, %d items", out, count())
+	}
+	var moves int
+	_ = db.QueryRow(`SELECT COUNT(1) FROM inventory_transactions WHERE notes = 'CSV import'`).Scan(&moves)
+	if moves != 1 {
+		t.Errorf("opening stock movements = %d, want 1", moves)
+	}
+
+	if code, _ = post("name\nNo SKU\n", nil); code != 400 {
+		t.Errorf("missing required column: got %d, want 400", code)
+	}
+}
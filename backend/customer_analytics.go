@@ -0,0 +1,215 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Customer analytics look at each contact's sales history: when they first
+// and last bought, how often they come back and what they have spent over
+// time (lifetime value). Opening balances and voided sales are left out.
+
+type customerHistory struct {
+	contactID, name, phone string
+	purchases              []time.Time
+	lifetimeValue          float64
+}
+
+// averageDaysBetween is the mean gap between consecutive purchases, or -1
+// with fewer than two purchases.
+func (h *customerHistory) averageDaysBetween() float64 {
+	n := len(h.purchases)
+	if n < 2 {
+		return -1
+	}
+	return h.purchases[n-1].Sub(h.purchases[0]).Hours() / 24 / float64(n-1)
+}
+
+func (h *customerHistory) summary(now time.Time) fiber.Map {
+	last := h.purchases[len(h.purchases)-1]
+	out := fiber.Map{
+		"contact_id":           h.contactID,
+		"name":                 h.name,
+		"phone":                h.phone,
+		"purchases":            len(h.purchases),
+		"first_purchase_at":    h.purchases[0].Format(time.RFC3339),
+		"last_purchase_at":     last.Format(time.RFC3339),
+		"days_since_last":      int(now.Sub(last).Hours() / 24),
+		"lifetime_value":       h.lifetimeValue,
+		"average_days_between": nil,
+	}
+	if avg := h.averageDaysBetween(); avg >= 0 {
+		out["average_days_between"] = round2(avg)
+	}
+	return out
+}
+
+// customerHistories returns the sales history of every contact of orgID
+// that has bought something, purchases in date order.
+func customerHistories(q *DB, orgID string) ([]*customerHistory, error) {
+	rows, err := q.Query(`SELECT t.contact_id, COALESCE(c.name, ''), COALESCE(c.phone, ''), t.amount, t.created_at
+		FROM transactions t LEFT JOIN contacts c ON c.id = t.contact_id
+		WHERE t.organization_id = ? AND t.type = 'inflow' AND COALESCE(t.source, '') <> 'opening' AND t.voided_at IS NULL AND COALESCE(t.contact_id, '') <> ''`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	byContact := map[string]*customerHistory{}
+	var out []*customerHistory
+	for rows.Next() {
+		var contactID, name, phone, createdAt string
+		var amount money
+		if err := rows.Scan(&contactID, &name, &phone, &amount, &createdAt); err != nil {
+			return nil, err
+		}
+		t, err := parseTime(createdAt)
+		if err != nil {
+			continue
+		}
+		h, ok := byContact[contactID]
+		if !ok {
+			h = &customerHistory{contactID: contactID, name: name, phone: phone}
+			byContact[contactID] = h
+			out = append(out, h)
+		}
+		h.purchases = append(h.purchases, t)
+		h.lifetimeValue += amount.float()
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, h := range out {
+		sort.Slice(h.purchases, func(i, j int) bool { return h.purchases[i].Before(h.purchases[j]) })
+	}
+	return out, nil
+}
+
+// handleNewVsReturning counts, per day, week or month, the customers
+// buying for the first time and those who had bought before.
+func handleNewVsReturning(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	bucket, step, layout, ok := trendInterval(c.Query("interval", "month"))
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "interval must be day, week or month"})
+	}
+	series := chartSeries{}
+	index := map[string]int{}
+	for t := bucket(from.Local()); t.Before(to); t = step(t) {
+		index[t.Format(layout)] = len(series.Labels)
+		series.Labels = append(series.Labels, t.Format(layout))
+		if len(series.Labels) > 1000 {
+			return c.Status(400).JSON(fiber.Map{"error": "date range too large for interval"})
+		}
+	}
+	newCustomers := make([]float64, len(series.Labels))
+	returning := make([]float64, len(series.Labels))
+
+	histories, err := customerHistories(dbFor(c), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, h := range histories {
+		first := bucket(h.purchases[0].Local()).Format(layout)
+		seen := map[string]bool{}
+		for _, p := range h.purchases {
+			if p.Before(from) || !p.Before(to) {
+				continue
+			}
+			label := bucket(p.Local()).Format(layout)
+			i, ok := index[label]
+			if !ok || seen[label] {
+				continue
+			}
+			seen[label] = true
+			if label == first {
+				newCustomers[i]++
+			} else {
+				returning[i]++
+			}
+		}
+	}
+	series.Datasets = []chartDataset{{Label: "New customers", Data: newCustomers}, {Label: "Returning customers", Data: returning}}
+	return c.JSON(series)
+}
+
+// handlePurchaseIntervals lists how often each customer buys, the most
+// frequent buyers first, with the average gap across repeat customers.
+func handlePurchaseIntervals(c *fiber.Ctx) error {
+	histories, err := customerHistories(dbFor(c), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	sort.SliceStable(histories, func(i, j int) bool {
+		if len(histories[i].purchases) != len(histories[j].purchases) {
+			return len(histories[i].purchases) > len(histories[j].purchases)
+		}
+		return histories[i].lifetimeValue > histories[j].lifetimeValue
+	})
+	now := time.Now()
+	items := make([]fiber.Map, 0, len(histories))
+	repeat, gapSum := 0, 0.0
+	for _, h := range histories {
+		items = append(items, h.summary(now))
+		if avg := h.averageDaysBetween(); avg >= 0 {
+			repeat++
+			gapSum += avg
+		}
+	}
+	var overall interface{}
+	if repeat > 0 {
+		overall = round2(gapSum / float64(repeat))
+	}
+	return c.JSON(fiber.Map{
+		"items":                items,
+		"customers":            len(histories),
+		"repeat_customers":     repeat,
+		"average_days_between": overall,
+	})
+}
+
+// handleLapsedCustomers lists customers who have not bought in the last
+// days (default 90), the most valuable first, for win-back outreach.
+// min_purchases leaves out one-off buyers.
+func handleLapsedCustomers(c *fiber.Ctx) error {
+	days, minPurchases := 90, 1
+	if v := c.Query("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.Status(400).JSON(fiber.Map{"error": "days must be a positive number"})
+		}
+		days = n
+	}
+	if v := c.Query("min_purchases"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return c.Status(400).JSON(fiber.Map{"error": "min_purchases must be a positive number"})
+		}
+		minPurchases = n
+	}
+	histories, err := customerHistories(dbFor(c), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -days)
+	var lapsed []*customerHistory
+	total := 0.0
+	for _, h := range histories {
+		if len(h.purchases) >= minPurchases && h.purchases[len(h.purchases)-1].Before(cutoff) {
+			lapsed = append(lapsed, h)
+			total += h.lifetimeValue
+		}
+	}
+	sort.SliceStable(lapsed, func(i, j int) bool { return lapsed[i].lifetimeValue > lapsed[j].lifetimeValue })
+	items := make([]fiber.Map, 0, len(lapsed))
+	for _, h := range lapsed {
+		items = append(items, h.summary(now))
+	}
+	return c.JSON(fiber.Map{"days": days, "items": items, "lifetime_value": total})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCustomerAnalytics(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	ago := func(days int) string { return time.Now().AddDate(0, 0, -days).Format(time.RFC3339) }
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-a','Anis','011','customer','org-1'),('c-b','Bina','012','customer','org-1'),('c-c','Chand','013','customer','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES
+			('t-1','inflow',10000,10000,0,'c-a','org-1','` + ago(200) + `'),
+			('t-2','inflow',10000,10000,0,'c-a','org-1','` + ago(150) + `'),
+			('t-3','inflow',10000,10000,0,'c-a','org-1','` + ago(100) + `'),
+			('t-4','inflow',50000,50000,0,'c-b','org-1','` + ago(10) + `'),
+			('t-5','inflow',4000,4000,0,'c-c','org-1','` + ago(400) + `'),
+			('t-6','inflow',6000,6000,0,'c-c','org-1','` + ago(5) + `'),
+			('t-7','outflow',90000,90000,0,'c-b','org-1','` + ago(3) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,source,organization_id,created_at) VALUES ('t-8','inflow',7000,0,7000,'c-b','opening','org-1','` + ago(500) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,voided_at,organization_id,created_at) VALUES ('t-9','inflow',8000,8000,0,'c-a','` + ago(1) + `','org-1','` + ago(2) + `')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	get := func(path string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	_, out := get("/api/analytics/lapsed-customers")
+	items := out["items"].([]interface{})
+	if len(items) != 1 || out["lifetime_value"] != float64(300) {
+		t.Fatalf("lapsed %v", out)
+	}
+	if a := items[0].(map[string]interface{}); a["name"] != "Anis" || a["average_days_between"] != float64(50) || a["days_since_last"] != float64(100) {
+		t.Errorf("lapsed customer %v", a)
+	}
+	if _, out := get("/api/analytics/lapsed-customers?days=90&min_purchases=4"); len(out["items"].([]interface{})) != 0 {
+		t.Errorf("min_purchases=4 kept %v", out["items"])
+	}
+	if code, _ := get("/api/analytics/lapsed-customers?days=0"); code != 400 {
+		t.Errorf("days=0: got %d, want 400", code)
+	}
+
+	_, out = get("/api/analytics/purchase-intervals")
+	if out["customers"] != float64(3) || out["repeat_customers"] != float64(2) || out["average_days_between"] != 222.5 {
+		t.Errorf("intervals %v", out)
+	}
+	if first := out["items"].([]interface{})[0].(map[string]interface{}); first["contact_id"] != "c-a" {
+		t.Errorf("most frequent buyer %v, want c-a", first["contact_id"])
+	}
+
+	// Bina's first sale falls in the period, Chand has bought before
+	_, out = get("/api/analytics/new-vs-returning?interval=day&from=" + url.QueryEscape(ago(12)) + "&to=" + url.QueryEscape(time.Now().AddDate(0, 0, 1).Format(time.RFC3339)))
+	sum := func(i int) float64 {
+		total := 0.0
+		for _, v := range out["datasets"].([]interface{})[i].(map[string]interface{})["data"].([]interface{}) {
+			total += v.(float64)
+		}
+		return total
+	}
+	if sum(0) != 1 || sum(1) != 1 {
+		t.Errorf("%v new and %v returning customers, want 1 and 1", sum(0), sum(1))
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		dialect    dialect
+		query, out string
+	}{
+		{sqliteDialect, `SELECT * FROM t WHERE a = ? AND b = ?`, `SELECT * FROM t WHERE a = ? AND b = ?`},
+		{postgresDialect, `SELECT * FROM t WHERE a = ? AND b = ?`, `SELECT * FROM t WHERE a = $1 AND b = $2`},
+		{postgresDialect, `SELECT 'what?' FROM t WHERE a = ?`, `SELECT 'what?' FROM t WHERE a = $1`},
+		{postgresDialect, `SELECT "odd?col" FROM t WHERE a = ?`, `SELECT "odd?col" FROM t WHERE a = $1`},
+		{postgresDialect, "-- why? don't ask\nUPDATE t SET a = ?", "-- why? don't ask\nUPDATE t SET a = $1"},
+		{postgresDialect, `SELECT 1`, `SELECT 1`},
+	}
+	for _, tt := range tests {
+		if got := tt.dialect.rebind(tt.query); got != tt.out {
+			t.Errorf("%s rebind(%q) = %q, want %q", tt.dialect, tt.query, got, tt.out)
+		}
+	}
+}
+
+func TestMigrationSQLForDialect(t *testing.T) {
+	m := migration{up: "CREATE TABLE t (rate REAL NOT NULL, realm TEXT)", down: "DROP TABLE t", pgDown: "DROP TABLE t CASCADE"}
+	if got := m.sql(sqliteDialect, true); got != m.up {
+		t.Errorf("sqlite up = %q", got)
+	}
+	if got, want := m.sql(postgresDialect, true), "CREATE TABLE t (rate DOUBLE PRECISION NOT NULL, realm TEXT)"; got != want {
+		t.Errorf("postgres up = %q, want %q", got, want)
+	}
+	if got := m.sql(postgresDialect, false); got != m.pgDown {
+		t.Errorf("postgres down = %q, want the .postgres variant", got)
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		if m.version == 18 && (m.pgUp == "" || m.pgDown == "") {
+			t.Error("0018 postgres variants not loaded")
+		}
+	}
+}
+
+func TestPreparedStatementCache(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(`CREATE TABLE t (id INTEGER, v TEXT)`); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := tx.PreparedExec(`INSERT INTO t (id, v) VALUES (?, ?)`, i, "x"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tx.bound) != 1 {
+		t.Errorf("transaction bound %d statements, want 1", len(tx.bound))
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	for i := 0; i < 3; i++ {
+		if err := db.PreparedQueryRow(`SELECT COUNT(1) FROM t WHERE v = ?`, "x").Scan(&n); err != nil || n != 3 {
+			t.Fatalf("count = %d, %v", n, err)
+		}
+	}
+	if len(db.stmts.stmts) != 2 {
+		t.Errorf("cached %d statements, want 2", len(db.stmts.stmts))
+	}
+
+	// past the cap queries still run, unprepared
+	for i := 0; i < stmtCacheMax; i++ {
+		db.PreparedQueryRow(fmt.Sprintf(`SELECT %d`, i)).Scan(&n)
+	}
+	if err := db.PreparedQueryRow(`SELECT COUNT(1) FROM t WHERE id >= ?`, 1).Scan(&n); err != nil || n != 2 {
+		t.Errorf("uncached query: %d, %v", n, err)
+	}
+	if len(db.stmts.stmts) != stmtCacheMax {
+		t.Errorf("cache grew to %d", len(db.stmts.stmts))
+	}
+	// a broken query reports its error instead of being cached
+	if err := db.PreparedQueryRow(`SELECT nope FROM t`).Scan(&n); err == nil {
+		t.Error("expected an error for an unknown column")
+	}
+}
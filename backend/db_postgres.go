@@ -0,0 +1,6 @@
+//go:build postgres
+
+package main
+
+// Registers the "postgres" database/sql driver. Build with -tags postgres.
+import _ "github.com/lib/pq"
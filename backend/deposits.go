@@ -0,0 +1,296 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A deposit batches cash and cheques received into one trip to the bank.
+// Creating one moves the total out of the cash account the money was
+// received into and into the bank account, and marks the included
+// payments as deposited so they are not banked twice.
+
+func registerDepositRoutes(app *fiber.App) {
+	r := app.Group("/api/deposits", requireAuth)
+	r.Get("/", handleListDeposits)
+	r.Get("/undeposited", handleUndepositedPayments)
+	r.Post("/", requireRole("admin", "manager"), handleCreateDeposit)
+	r.Get("/:id", handleGetDeposit)
+	r.Get("/:id/slip", handleDepositSlip)
+}
+
+func handleUndepositedPayments(c *fiber.Ctx) error {
+	accountID := c.Query("account_id")
+	if accountID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "account_id required"})
+	}
+	orgID := currentOrgID(c)
+	if _, err := accountBalance(dbFor(c), orgID, accountID); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "account not found"})
+	}
+	rows, err := dbFor(c).Query(`SELECT p.id, p.method, p.amount, p.reference, p.transaction_id, t.contact_id, c.name AS contact_name, p.created_at
+		FROM transaction_payments p JOIN transactions t ON t.id = p.transaction_id LEFT JOIN contacts c ON c.id = t.contact_id
+		WHERE p.account_id = ? AND t.organization_id = ? AND p.method IN ('cash','cheque') AND t.type = 'inflow' AND t.voided_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM deposit_items d WHERE d.payment_id = p.id)
+		ORDER BY p.created_at`, accountID, orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	total := 0.0
+	for _, it := range items {
+		if a, ok := it["amount"].(float64); ok {
+			total += a
+		}
+	}
+	return c.JSON(fiber.Map{"items": items, "total": total})
+}
+
+type depositRequest struct {
+	FromAccountID string   `json:"from_account_id"`
+	ToAccountID   string   `json:"to_account_id"`
+	PaymentIDs    []string `json:"payment_ids"`
+	// Cash lists loose cash not tied to a recorded payment, e.g. float.
+	Cash []struct {
+		Amount float64 `json:"amount"`
+		Notes  string  `json:"notes"`
+	} `json:"cash"`
+	Reference string `json:"reference"`
+	Date      string `json:"date"`
+}
+
+func handleCreateDeposit(c *fiber.Ctx) error {
+	var req depositRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.FromAccountID == "" || req.ToAccountID == "" || req.FromAccountID == req.ToAccountID {
+		return c.Status(400).JSON(fiber.Map{"error": "from_account_id and to_account_id must be two different accounts"})
+	}
+	orgID := currentOrgID(c)
+	var toKind string
+	if err := dbFor(c).QueryRow(`SELECT kind FROM cash_accounts WHERE id = ? AND organization_id = ?`, req.ToAccountID, orgID).Scan(&toKind); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "bank account not found"})
+	}
+	if toKind != "bank" {
+		return c.Status(400).JSON(fiber.Map{"error": "deposits must go to a bank account"})
+	}
+	if _, err := accountBalance(dbFor(c), orgID, req.FromAccountID); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "source account not found"})
+	}
+	depositedAt := time.Now()
+	if req.Date != "" {
+		t, err := parseTime(req.Date)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid date"})
+		}
+		depositedAt = t
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	depositID := genID()
+	now := time.Now().Format(time.RFC3339)
+	if _, err := tx.Exec(`INSERT INTO deposits (id,from_account_id,to_account_id,total,reference,deposited_at,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?)`,
+		depositID, req.FromAccountID, req.ToAccountID, 0.0, req.Reference, depositedAt.Format(time.RFC3339), orgID, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	cashTotal, chequeTotal := 0.0, 0.0
+	for _, pid := range req.PaymentIDs {
+		var method, accountID string
+		var reference sql.NullString
+		var amount float64
+		err := tx.QueryRow(`SELECT p.method, p.amount, p.reference, COALESCE(p.account_id, '') FROM transaction_payments p JOIN transactions t ON t.id = p.transaction_id
+			WHERE p.id = ? AND t.organization_id = ? AND t.voided_at IS NULL`, pid, orgID).Scan(&method, &amount, &reference, &accountID)
+		if err == sql.ErrNoRows {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown payment " + pid})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if accountID != req.FromAccountID {
+			return c.Status(400).JSON(fiber.Map{"error": "payment " + pid + " was not received into the source account"})
+		}
+		if method != "cash" && method != "cheque" {
+			return c.Status(400).JSON(fiber.Map{"error": "payment " + pid + " is not cash or a cheque"})
+		}
+		var already int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM deposit_items WHERE payment_id = ?`, pid).Scan(&already); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if already > 0 {
+			return c.Status(409).JSON(fiber.Map{"error": "payment " + pid + " has already been deposited"})
+		}
+		if _, err := tx.Exec(`INSERT INTO deposit_items (id,deposit_id,payment_id,kind,amount,reference,created_at) VALUES (?,?,?,?,?,?,?)`,
+			genID(), depositID, pid, method, amount, reference.String, now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if method == "cheque" {
+			chequeTotal += amount
+		} else {
+			cashTotal += amount
+		}
+	}
+	for _, cash := range req.Cash {
+		if cash.Amount <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "cash amounts must be positive"})
+		}
+		if _, err := tx.Exec(`INSERT INTO deposit_items (id,deposit_id,kind,amount,reference,created_at) VALUES (?,?,?,?,?,?)`,
+			genID(), depositID, "cash", cash.Amount, cash.Notes, now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		cashTotal += cash.Amount
+	}
+	total := math.Round((cashTotal+chequeTotal)*100) / 100
+	if total <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "nothing to deposit"})
+	}
+	if _, err := tx.Exec(`UPDATE deposits SET cash_total = ?, cheque_total = ?, total = ? WHERE id = ?`, cashTotal, chequeTotal, total, depositID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := recordMovement(tx, req.FromAccountID, -total, "deposit_out", "deposit", depositID, req.Reference); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := recordMovement(tx, req.ToAccountID, total, "deposit_in", "deposit", depositID, req.Reference); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": depositID, "cash_total": cashTotal, "cheque_total": chequeTotal, "total": total})
+}
+
+func handleListDeposits(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	rows, err := dbFor(c).Query(`SELECT d.id, d.from_account_id, f.name AS from_account_name, d.to_account_id, b.name AS to_account_name,
+		d.cash_total, d.cheque_total, d.total, d.reference, d.deposited_at, d.created_at
+		FROM deposits d LEFT JOIN cash_accounts f ON f.id = d.from_account_id LEFT JOIN cash_accounts b ON b.id = d.to_account_id
+		WHERE d.organization_id = ? AND d.deposited_at >= ? AND d.deposited_at < ? ORDER BY d.deposited_at DESC`, currentOrgID(c), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// loadDeposit returns a deposit of orgID with its accounts and items, or
+// nil when it does not exist.
+func loadDeposit(q *DB, orgID, id string) (map[string]interface{}, error) {
+	rows, err := q.Query(`SELECT d.id, d.from_account_id, f.name AS from_account_name, d.to_account_id, b.name AS to_account_name, b.account_no AS to_account_no,
+		d.cash_total, d.cheque_total, d.total, d.reference, d.deposited_at, d.created_at
+		FROM deposits d LEFT JOIN cash_accounts f ON f.id = d.from_account_id LEFT JOIN cash_accounts b ON b.id = d.to_account_id
+		WHERE d.id = ? AND d.organization_id = ?`, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	deposits, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil || len(deposits) == 0 {
+		return nil, err
+	}
+	rows, err = q.Query(`SELECT i.id, i.payment_id, i.kind, i.amount, i.reference, c.name AS contact_name
+		FROM deposit_items i LEFT JOIN transaction_payments p ON p.id = i.payment_id
+		LEFT JOIN transactions t ON t.id = p.transaction_id LEFT JOIN contacts c ON c.id = t.contact_id
+		WHERE i.deposit_id = ? ORDER BY i.kind, i.created_at`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+	deposits[0]["items"] = items
+	return deposits[0], nil
+}
+
+func handleGetDeposit(c *fiber.Ctx) error {
+	d, err := loadDeposit(dbFor(c), currentOrgID(c), c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if d == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(d)
+}
+
+var depositSlipTemplate = template.Must(template.New("slip").Funcs(template.FuncMap{
+	"money": func(v interface{}) string {
+		f, _ := v.(float64)
+		return strconv.FormatFloat(f, 'f', 2, 64)
+	},
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Deposit slip {{.Deposit.reference}}</title>
+<style>
+body { font-family: sans-serif; max-width: 720px; margin: 24px auto; }
+table { width: 100%; border-collapse: collapse; margin-top: 12px; }
+th, td { border: 1px solid #999; padding: 4px 8px; text-align: left; }
+td.num, th.num { text-align: right; }
+@media print { button { display: none; } }
+</style></head><body>
+<h2>{{.Org.name}}</h2>
+<h3>Bank deposit slip</h3>
+<p>Bank account: {{.Deposit.to_account_name}} {{.Deposit.to_account_no}}<br>
+Date: {{.Date}}<br>
+Reference: {{.Deposit.reference}}</p>
+{{if .Cheques}}<table><tr><th>Cheque no.</th><th>Drawer</th><th class="num">Amount</th></tr>
+{{range .Cheques}}<tr><td>{{.reference}}</td><td>{{.contact_name}}</td><td class="num">{{money .amount}}</td></tr>
+{{end}}<tr><th colspan="2">Cheques total</th><th class="num">{{money .Deposit.cheque_total}}</th></tr></table>{{end}}
+<table><tr><th>Cash</th><th class="num">{{money .Deposit.cash_total}}</th></tr>
+<tr><th>Total deposit</th><th class="num">{{money .Deposit.total}}</th></tr></table>
+<p style="margin-top:48px">Deposited by: ____________________ &nbsp; Bank stamp: ____________________</p>
+<button onclick="window.print()">Print</button>
+</body></html>`))
+
+// handleDepositSlip renders a printable HTML deposit slip.
+func handleDepositSlip(c *fiber.Ctx) error {
+	d, err := loadDeposit(dbFor(c), currentOrgID(c), c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if d == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	org, err := organizationProfile(dbFor(c), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var cheques []map[string]interface{}
+	for _, it := range d["items"].([]map[string]interface{}) {
+		if it["kind"] == "cheque" {
+			cheques = append(cheques, it)
+		}
+	}
+	date := toString(d["deposited_at"])
+	if t, err := parseTime(date); err == nil {
+		date = t.Format("02 Jan 2006")
+	}
+	var b strings.Builder
+	if err := depositSlipTemplate.Execute(&b, fiber.Map{"Deposit": d, "Org": org, "Cheques": cheques, "Date": date}); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set("Content-Type", "text/html; charset=utf-8")
+	return c.SendString(b.String())
+}
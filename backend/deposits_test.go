@@ -0,0 +1,41 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDepositRoles(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO cash_accounts (id,name,kind,opening_balance,active,organization_id,created_at) VALUES ('till','Till','cash',500,1,'org-1','2024-01-01T00:00:00Z')`,
+		`INSERT INTO cash_accounts (id,name,kind,opening_balance,active,organization_id,created_at) VALUES ('bank','Bank','bank',0,1,'org-1','2024-01-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role string) int {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/deposits", strings.NewReader(`{"from_account_id":"till","to_account_id":"bank","payment_ids":[]}`))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	if code := call("cashier"); code != 403 {
+		t.Errorf("cashier banking the till: got %d, want 403", code)
+	}
+	if code := call("manager"); code == 403 {
+		t.Errorf("manager banking the till: got %d", code)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Customers who have owed for a while are texted a reminder. Once a sale
+// has been due for the due_reminder_days setting (0, the default, sends
+// none), a background job checking every DUE_REMINDER_CHECK_MINUTES
+// (default 60; 0 turns it off) texts the customer, within business_hours,
+// what they owe on such sales, and again every due_reminder_repeat_days
+// while it stays unpaid; a failed text is tried again the next day.
+// Contacts with sms_opt_out set, or without a phone number, are left
+// alone. The text is the due_reminder_template setting, which may use
+// {name}, {due_amount}, {days} (how long the oldest sale has been due),
+// {since} (its date) and {business}. Texts go out through the SMS provider
+// of messaging.go and are logged; gateways' delivery reports update the
+// log.
+//
+//	GET /api/due-reminders           the texts sent, newest first; ?contact_id= filters
+//	GET /api/due-reminders/preview   who would be texted now, and what
+
+const defaultDueReminderTemplate = "Dear {name}, {due_amount} has been due to {business} since {since}. Please pay at your earliest convenience."
+
+// dueReminderRetry is how long a failed reminder waits to be tried again.
+const dueReminderRetry = 24 * time.Hour
+
+type dueReminder struct {
+	ContactID string `json:"contact_id"`
+	Name      string `json:"name"`
+	Phone     string `json:"phone"`
+	Amount    money  `json:"amount"`
+	Since     string `json:"since"`
+	Days      int    `json:"days"`
+	Message   string `json:"message"`
+	Skip      string `json:"skip,omitempty"`
+}
+
+func registerDueReminderRoutes(app *fiber.App) {
+	r := app.Group("/api/due-reminders", requireAuth, requireRole("admin", "manager"))
+	r.Get("/", handleListDueReminders)
+	r.Get("/preview", handlePreviewDueReminders)
+}
+
+func startDueReminders() {
+	minutes := 60
+	if v, err := strconv.Atoi(os.Getenv("DUE_REMINDER_CHECK_MINUTES")); err == nil {
+		minutes = v
+	}
+	if minutes <= 0 {
+		return
+	}
+	go func() {
+		for {
+			for _, orgID := range organizationIDs() {
+				if n, err := sendDueReminders(orgID, time.Now()); err != nil {
+					log.Printf("due reminders for %s: %v", orgID, err)
+				} else if n > 0 {
+					log.Printf("due reminders for %s: sent %d", orgID, n)
+				}
+			}
+			time.Sleep(time.Duration(minutes) * time.Minute)
+		}
+	}()
+}
+
+// overdueCustomers lists orgID's customers owing on sales due for at
+// least the due_reminder_days setting as of now, with the reminder each
+// would get; Skip tells why one would not be texted.
+func overdueCustomers(q *DB, orgID string, now time.Time) ([]dueReminder, error) {
+	days, _ := orgSetting(orgID, "due_reminder_days").(float64)
+	reminders := []dueReminder{}
+	if days <= 0 {
+		return reminders, nil
+	}
+	repeat, _ := orgSetting(orgID, "due_reminder_repeat_days").(float64)
+	template, _ := orgSetting(orgID, "due_reminder_template").(string)
+	symbol, _ := orgSetting(orgID, "currency_symbol").(string)
+	profile, err := organizationProfile(q, orgID)
+	if err != nil {
+		return nil, err
+	}
+	loc := businessLocation(orgID)
+	rows, err := q.Query(`SELECT c.id, c.name, COALESCE(c.phone, ''), c.sms_opt_out, SUM(t.due_amount), MIN(t.created_at)
+		FROM transactions t JOIN contacts c ON c.id = t.contact_id
+		WHERE t.organization_id = ? AND t.type = 'inflow' AND t.voided_at IS NULL AND t.due_amount > 0 AND t.created_at <= ?
+		GROUP BY c.id, c.name, c.phone, c.sms_opt_out ORDER BY c.name`, orgID, now.AddDate(0, 0, -int(days)).Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	var optedOut []bool
+	for rows.Next() {
+		var r dueReminder
+		var optOut bool
+		if err := rows.Scan(&r.ContactID, &r.Name, &r.Phone, &optOut, &r.Amount, &r.Since); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reminders = append(reminders, r)
+		optedOut = append(optedOut, optOut)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range reminders {
+		r := &reminders[i]
+		if since, err := parseTime(r.Since); err == nil {
+			r.Days = int(now.Sub(since).Hours() / 24)
+			r.Since = since.In(loc).Format("2 Jan 2006")
+		}
+		r.Message = strings.NewReplacer(
+			"{name}", r.Name,
+			"{due_amount}", symbol+r.Amount.String(),
+			"{days}", strconv.Itoa(r.Days),
+			"{since}", r.Since,
+			"{business}", toString(profile["name"]),
+		).Replace(template)
+		var lastSent, lastTried string
+		if err := q.QueryRow(`SELECT COALESCE(MAX(CASE WHEN status <> 'failed' THEN created_at END), ''), COALESCE(MAX(created_at), '') FROM due_reminders WHERE organization_id = ? AND contact_id = ?`, orgID, r.ContactID).
+			Scan(&lastSent, &lastTried); err != nil {
+			return nil, err
+		}
+		switch {
+		case optedOut[i]:
+			r.Skip = "opted out of SMS"
+		case strings.TrimSpace(r.Phone) == "":
+			r.Skip = "no phone number"
+		case lastSent != "" && lastSent > now.AddDate(0, 0, -int(repeat)).Format(time.RFC3339):
+			r.Skip = "reminded " + lastSent
+		case lastTried != "" && lastTried > now.Add(-dueReminderRetry).Format(time.RFC3339):
+			r.Skip = "reminder failed " + lastTried
+		}
+	}
+	return reminders, nil
+}
+
+// sendDueReminders texts orgID's overdue customers who are due a
+// reminder and returns how many were sent.
+func sendDueReminders(orgID string, now time.Time) (int, error) {
+	if outsideBusinessHours(orgID, now) {
+		return 0, nil
+	}
+	reminders, err := overdueCustomers(db, orgID, now)
+	if err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, r := range reminders {
+		if r.Skip != "" {
+			continue
+		}
+		providerID, sendErr := sendMessage("sms", strings.TrimSpace(r.Phone), "", r.Message)
+		status, problem := "sent", ""
+		if sendErr != nil {
+			status, problem = "failed", sendErr.Error()
+		} else {
+			sent++
+		}
+		if _, err := db.Exec(`INSERT INTO due_reminders (id,organization_id,contact_id,phone,amount,message,status,error,provider_message_id,created_at) VALUES (?,?,?,?,?,?,?,NULLIF(?, ''),NULLIF(?, ''),?)`,
+			genID(), orgID, r.ContactID, strings.TrimSpace(r.Phone), r.Amount.float(), r.Message, status, problem, providerID, now.Format(time.RFC3339)); err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+func handlePreviewDueReminders(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	reminders, err := overdueCustomers(dbFor(c), orgID, time.Now())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"days": orgSetting(orgID, "due_reminder_days"), "items": reminders})
+}
+
+func handleListDueReminders(c *fiber.Ctx) error {
+	query := `SELECT r.id, r.contact_id, COALESCE(c.name, '') AS name, r.phone, r.amount, r.message, r.status, COALESCE(r.error, '') AS error, COALESCE(r.delivered_at, '') AS delivered_at, r.created_at
+		FROM due_reminders r LEFT JOIN contacts c ON c.id = r.contact_id WHERE r.organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	if v := c.Query("contact_id"); v != "" {
+		query += ` AND r.contact_id = ?`
+		args = append(args, v)
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY r.created_at DESC`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	items, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	moneyColumns(items, "amount")
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// recordDueReminderDelivery applies a gateway's delivery report to the
+// reminder sent as providerID, returning whether there was one.
+func recordDueReminderDelivery(q *DB, providerID, status, problem string) (bool, error) {
+	var res sql.Result
+	var err error
+	if status == "delivered" {
+		res, err = q.Exec(`UPDATE due_reminders SET status = 'delivered', delivered_at = ? WHERE provider_message_id = ?`, time.Now().Format(time.RFC3339), providerID)
+	} else {
+		res, err = q.Exec(`UPDATE due_reminders SET status = 'failed', error = ? WHERE provider_message_id = ?`, problem, providerID)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
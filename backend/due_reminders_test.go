@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDueReminders(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	ago := func(days int) string { return now.AddDate(0, 0, -days).Format(time.RFC3339) }
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO settings (scope,scope_id,key,value) VALUES ('organization','org-1','timezone','"UTC"')`,
+		`INSERT INTO settings (scope,scope_id,key,value) VALUES ('organization','org-1','business_hours','{}')`,
+		`INSERT INTO settings (scope,scope_id,key,value) VALUES ('organization','org-1','due_reminder_days','30')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','01711000000','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,sms_opt_out,organization_id) VALUES ('c-2','Karim','01711000001','customer',1,'org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-3','Salam','','customer','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-1','inflow',10000,5000,5000,'c-1','org-1','` + ago(40) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-2','inflow',2000,0,2000,'c-1','org-1','` + ago(5) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-3','inflow',3000,0,3000,'c-2','org-1','` + ago(40) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-4','inflow',3000,0,3000,'c-3','org-1','` + ago(40) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at,voided_at) VALUES ('t-5','inflow',9000,0,9000,'c-1','org-1','` + ago(40) + `','` + ago(39) + `')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := make(chan map[string]string, 4)
+	count := 0
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		sent <- msg
+		count++
+		fmt.Fprintf(w, `{"id":"m-%d"}`, count)
+	}))
+	defer gateway.Close()
+	t.Setenv("SMS_GATEWAY_URL", gateway.URL)
+	t.Setenv("MESSAGING_WEBHOOK_SECRET", "s3cret")
+
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Webhook-Secret", "s3cret")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("manager", "PUT", "/api/settings/organization", `{"due_reminder_repeat_days":0}`); code != 400 {
+		t.Errorf("repeating every 0 days: got %d, want 400", code)
+	}
+	if code, _ := call("cashier", "GET", "/api/due-reminders/preview", ""); code != 403 {
+		t.Errorf("cashier previewing: got %d, want 403", code)
+	}
+	_, preview := call("manager", "GET", "/api/due-reminders/preview", "")
+	items, _ := preview["items"].([]interface{})
+	if len(items) != 3 {
+		t.Fatalf("preview: %v", preview)
+	}
+	skips := map[string]string{}
+	for _, it := range items {
+		r := it.(map[string]interface{})
+		skips[r["contact_id"].(string)], _ = r["skip"].(string)
+		if r["contact_id"] == "c-1" && (r["amount"] != 50.0 || r["days"] != 40.0 || !strings.Contains(r["message"].(string), "Dear Rahim, ৳50.00 has been due to Mine")) {
+			t.Errorf("reminder: %v", r)
+		}
+	}
+	if skips["c-1"] != "" || skips["c-2"] != "opted out of SMS" || skips["c-3"] != "no phone number" {
+		t.Errorf("skips: %v", skips)
+	}
+
+	if n, err := sendDueReminders("org-1", now); err != nil || n != 1 {
+		t.Fatalf("sent %d (%v), want 1", n, err)
+	}
+	if msg := <-sent; msg["to"] != "01711000000" {
+		t.Errorf("texted %v", msg)
+	}
+	if n, _ := sendDueReminders("org-1", now.Add(time.Hour)); n != 0 {
+		t.Errorf("reminded again within the week")
+	}
+	if n, _ := sendDueReminders("org-1", now.AddDate(0, 0, 8)); n != 1 {
+		t.Errorf("not reminded a week on")
+	}
+	<-sent
+
+	if code, _ := call("", "POST", "/api/messages/delivery", `{"id":"m-1","status":"delivered"}`); code != 200 {
+		t.Errorf("delivery report: got %d", code)
+	}
+	_, history := call("manager", "GET", "/api/due-reminders?contact_id=c-1", "")
+	entries, _ := history["items"].([]interface{})
+	if len(entries) != 2 {
+		t.Fatalf("history: %v", history)
+	}
+	if e := entries[1].(map[string]interface{}); e["status"] != "delivered" || e["amount"] != 50.0 || e["name"] != "Rahim" {
+		t.Errorf("first reminder: %v", e)
+	}
+
+	// opting out stops them
+	if code, _ := call("manager", "PATCH", "/api/collections/contacts/records/c-1", `{"sms_opt_out":true}`); code != 200 {
+		t.Fatalf("opt out: %d", code)
+	}
+	if n, _ := sendDueReminders("org-1", now.AddDate(0, 0, 16)); n != 0 {
+		t.Errorf("texted a contact who opted out")
+	}
+}
+
+func TestTwilioSMS(t *testing.T) {
+	var form map[string]string
+	var user, pass string
+	twilio := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ = r.BasicAuth()
+		_ = r.ParseForm()
+		form = map[string]string{"path": r.URL.Path, "To": r.PostForm.Get("To"), "From": r.PostForm.Get("From"), "Body": r.PostForm.Get("Body")}
+		w.WriteHeader(201)
+		w.Write([]byte(`{"sid":"SM1"}`))
+	}))
+	defer twilio.Close()
+	defer func(api string) { twilioAPI = api }(twilioAPI)
+	twilioAPI = twilio.URL
+	t.Setenv("SMS_PROVIDER", "twilio")
+	t.Setenv("TWILIO_ACCOUNT_SID", "AC1")
+	t.Setenv("TWILIO_AUTH_TOKEN", "token")
+	t.Setenv("TWILIO_FROM", "+15550000000")
+
+	id, err := sendMessage("sms", "+8801711000000", "", "Hello")
+	if err != nil || id != "SM1" {
+		t.Fatalf("sent %q: %v", id, err)
+	}
+	if user != "AC1" || pass != "token" || form["path"] != "/2010-04-01/Accounts/AC1/Messages.json" || form["To"] != "+8801711000000" || form["From"] != "+15550000000" || form["Body"] != "Hello" {
+		t.Errorf("request: %s:%s %v", user, pass, form)
+	}
+	t.Setenv("SMS_PROVIDER", "pigeon")
+	if _, err := sendMessage("sms", "+8801711000000", "", "Hello"); err == nil {
+		t.Errorf("unknown provider accepted")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Double submits from flaky connections show up as two identical
+// transactions a few seconds apart. Before a transaction is created we
+// look for one with the same type, contact, amount and items in the
+// recent past. The organization settings duplicate_transaction_mode
+// ("warn", "block" or "off") and duplicate_window_minutes control what
+// happens; a client can still create the transaction after a block by
+// sending confirm_duplicate: true.
+
+// duplicateCheckSettings returns the configured mode and time window.
+func duplicateCheckSettings(orgID string) (string, time.Duration) {
+	mode, _ := orgSetting(orgID, "duplicate_transaction_mode").(string)
+	if mode != "block" && mode != "off" {
+		mode = "warn"
+	}
+	minutes, _ := orgSetting(orgID, "duplicate_window_minutes").(float64)
+	if minutes <= 0 {
+		minutes = 5
+	}
+	return mode, time.Duration(minutes * float64(time.Minute))
+}
+
+// itemSignature reduces transaction lines to a comparable string.
+func itemSignature(lines []string) string {
+	sort.Strings(lines)
+	return strings.Join(lines, "|")
+}
+
+func bodyItemSignature(body map[string]interface{}) string {
+	var lines []string
+	items, _ := body["items"].([]interface{})
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		quantity, _ := m["quantity"].(float64)
+		unitPrice, _ := m["unit_price"].(float64)
+		lines = append(lines, fmt.Sprintf("%s:%d:%.2f", toString(m["item_id"]), int(quantity), unitPrice))
+	}
+	return itemSignature(lines)
+}
+
+// findDuplicateTransaction returns the id of a recent transaction that
+// looks identical to body, or "" if there is none.
+func findDuplicateTransaction(q *DB, orgID string, body map[string]interface{}, window time.Duration) (string, error) {
+	amount := moneyValue(body["amount"])
+	since := time.Now().Add(-window).Format(time.RFC3339)
+	rows, err := q.Query(`SELECT id FROM transactions WHERE organization_id = ? AND type = ? AND contact_id = ? AND amount = ? AND created_at >= ? AND voided_at IS NULL ORDER BY created_at DESC`,
+		orgID, toString(body["type"]), toString(body["contact_id"]), amount, since)
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return "", err
+		}
+		candidates = append(candidates, id)
+	}
+	rows.Close()
+
+	want := bodyItemSignature(body)
+	for _, id := range candidates {
+		itemRows, err := q.Query(`SELECT item_id, quantity, unit_price FROM transaction_items WHERE transaction_id = ?`, id)
+		if err != nil {
+			return "", err
+		}
+		var lines []string
+		for itemRows.Next() {
+			var itemID string
+			var quantity int
+			var unitPrice money
+			if err := itemRows.Scan(&itemID, &quantity, &unitPrice); err != nil {
+				itemRows.Close()
+				return "", err
+			}
+			lines = append(lines, fmt.Sprintf("%s:%d:%s", itemID, quantity, unitPrice))
+		}
+		itemRows.Close()
+		if itemSignature(lines) == want {
+			return id, nil
+		}
+	}
+	return "", nil
+}
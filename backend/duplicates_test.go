@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A sale resent within the window is flagged, or refused in block mode,
+// and only when contact, amount and items all match.
+func TestDuplicateTransactions(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1'),('c-2','Bina','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',100,15,'org-1'),('i-2','Pad','PAD',100,30,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	sale := func(contact, items string, amount int, extra string) (int, map[string]interface{}) {
+		t.Helper()
+		return call("POST", "/api/collections/transactions/records", `{"type":"inflow","contact_id":"`+contact+`","amount":`+toString(amount)+`,"items":[`+items+`]`+extra+`}`)
+	}
+	pens := `{"item_id":"i-1","quantity":2,"unit_price":15}`
+	pad := `{"item_id":"i-2","quantity":1,"unit_price":30}`
+
+	code, first := sale("c-1", pens+","+pad, 60, "")
+	if code != 200 || first["warning"] != nil {
+		t.Fatalf("first sale: %d %v", code, first)
+	}
+	// the same lines in another order are the same sale
+	code, again := sale("c-1", pad+","+pens, 60, "")
+	if code != 200 || again["duplicate_of"] != first["id"] {
+		t.Errorf("resent sale: %d %v", code, again)
+	}
+	for name, resent := range map[string][]interface{}{
+		"other contact":  {"c-2", pens + "," + pad, 60},
+		"other quantity": {"c-1", `{"item_id":"i-1","quantity":3,"unit_price":15},` + pad, 75},
+		"fewer items":    {"c-1", pens, 60},
+	} {
+		if code, out := sale(resent[0].(string), resent[1].(string), resent[2].(int), ""); code != 200 || out["warning"] != nil {
+			t.Errorf("%s: %d %v", name, code, out)
+		}
+	}
+
+	if code, _ := call("PUT", "/api/settings/organization", `{"duplicate_transaction_mode":"block","duplicate_window_minutes":2}`); code != 200 {
+		t.Fatalf("settings: got %d", code)
+	}
+	if code, out := sale("c-2", pens+","+pad, 60, ""); code != 409 || out["duplicate_of"] == nil {
+		t.Errorf("blocked duplicate: %d %v", code, out)
+	}
+	if code, out := sale("c-2", pens+","+pad, 60, `,"confirm_duplicate":true`); code != 200 {
+		t.Errorf("confirmed duplicate: %d %v", code, out)
+	}
+	// sales outside the window and voided sales are not matched
+	if _, err := db.Exec(`UPDATE transactions SET created_at = ? WHERE contact_id = 'c-2'`, time.Now().Add(-3*time.Minute).Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	if code, out := sale("c-2", pens+","+pad, 60, ""); code != 200 {
+		t.Errorf("after the window: %d %v", code, out)
+	}
+	if _, err := db.Exec(`UPDATE transactions SET voided_at = ? WHERE contact_id = 'c-1'`, time.Now().Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	if code, out := sale("c-1", pens+","+pad, 60, ""); code != 200 {
+		t.Errorf("after a void: %d %v", code, out)
+	}
+
+	if code, _ := call("PUT", "/api/settings/organization", `{"duplicate_transaction_mode":"off"}`); code != 200 {
+		t.Fatalf("settings: got %d", code)
+	}
+	if code, out := sale("c-1", pens+","+pad, 60, ""); code != 200 || out["warning"] != nil {
+		t.Errorf("checks off: %d %v", code, out)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Suppliers can email their invoices to the organization's inbox address,
+// bills-<key>@INBOUND_EMAIL_DOMAIN. Mailgun receives the mail and forwards
+// it to POST /api/inbox/mailgun (a route with "forward" to that URL),
+// signed with MAILGUN_SIGNING_KEY. The PDF and CSV attachments are kept
+// and the mail waits in a review queue; it is converted into a purchase
+// the way a purchase import is (purchase_import.go), with the lines of a
+// CSV attachment or the lines entered for a PDF, and the attachments are
+// linked to the purchase. A mail from a sender whose last mail became a
+// purchase suggests the same supplier.
+//
+//	POST /api/inbox/mailgun            Mailgun's inbound webhook
+//	GET  /api/inbox/address            the organization's inbox address
+//	POST /api/inbox/address/rotate     a new address; mail to the old one is refused
+//	GET  /api/inbox                    ?status=pending|converted|dismissed
+//	GET  /api/inbox/:id                one mail with its attachments
+//	POST /api/inbox/:id/convert        as POST /api/purchases/import; lines default to the CSV attachment
+//	POST /api/inbox/:id/dismiss
+
+// inboxAddressPrefix starts the local part of every inbox address.
+const inboxAddressPrefix = "bills-"
+
+// mailgunMaxAge is how old a signed webhook may be before it is refused as
+// a replay.
+const mailgunMaxAge = 15 * time.Minute
+
+var errInboxReviewed = fiber.NewError(409, "this mail was reviewed meanwhile")
+
+func registerEmailInboxRoutes(app *fiber.App) {
+	app.Post("/api/inbox/mailgun", handleMailgunInbound)
+	r := app.Group("/api/inbox", requireAuth)
+	r.Get("/address", handleInboxAddress)
+	r.Post("/address/rotate", requireRole("admin"), handleRotateInboxAddress)
+	r.Get("/", handleListInbox)
+	r.Get("/:id", handleGetInboxDocument)
+	r.Post("/:id/convert", requireRole("admin", "manager"), handleConvertInboxDocument)
+	r.Post("/:id/dismiss", requireRole("admin", "manager"), handleDismissInboxDocument)
+}
+
+func inboxAddress(key string) string {
+	domain := os.Getenv("INBOUND_EMAIL_DOMAIN")
+	if domain == "" {
+		domain = "localhost"
+	}
+	return inboxAddressPrefix + key + "@" + domain
+}
+
+func newInboxKey() (string, error) {
+	raw := make([]byte, 10)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(raw), nil
+}
+
+// inboxOrganization finds the organization a recipient address is the
+// inbox of, or "".
+func inboxOrganization(q queryer, recipient string) (string, error) {
+	if _, addr, ok := strings.Cut(recipient, "<"); ok {
+		recipient = strings.TrimSuffix(addr, ">")
+	}
+	local, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(recipient)), "@")
+	key := strings.TrimPrefix(local, inboxAddressPrefix)
+	if key == local || key == "" {
+		return "", nil
+	}
+	var orgID string
+	err := q.QueryRow(`SELECT organization_id FROM email_inboxes WHERE address_key = ?`, key).Scan(&orgID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return orgID, err
+}
+
+// mailgunSigned checks Mailgun's signature of a webhook: the HMAC-SHA256
+// of timestamp and token under the signing key.
+func mailgunSigned(key, timestamp, token, signature string) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > mailgunMaxAge {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + token))
+	want := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(strings.ToLower(signature)))
+}
+
+// invoiceAttachmentType is the type an attachment is kept as, or "" for
+// attachments that are not invoices.
+func invoiceAttachmentType(filename, contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch ext := strings.ToLower(filepath.Ext(filename)); {
+	case mediaType == "application/pdf" || ext == ".pdf":
+		return "application/pdf"
+	case mediaType == "text/csv" || ext == ".csv":
+		return "text/csv"
+	}
+	return ""
+}
+
+// handleMailgunInbound keeps a mail to an inbox address. Mailgun retries
+// on errors, so a mail that will never be kept is answered 406, which it
+// takes as a refusal; one without invoices is answered 200 and dropped.
+func handleMailgunInbound(c *fiber.Ctx) error {
+	key := os.Getenv("MAILGUN_SIGNING_KEY")
+	if key == "" || !mailgunSigned(key, c.FormValue("timestamp"), c.FormValue("token"), c.FormValue("signature")) {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid webhook signature"})
+	}
+	orgID, err := inboxOrganization(dbFor(c), c.FormValue("recipient"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if orgID == "" {
+		return c.Status(406).JSON(fiber.Map{"error": "unknown inbox"})
+	}
+	form, err := c.MultipartForm()
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "multipart form expected"})
+	}
+	type attachment struct {
+		filename, contentType string
+		data                  []byte
+	}
+	var attachments []attachment
+	for _, files := range form.File {
+		for _, f := range files {
+			typ := invoiceAttachmentType(f.Filename, f.Header.Get("Content-Type"))
+			if typ == "" || f.Size > maxUploadBytes() {
+				continue
+			}
+			data, err := readUpload(f)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			attachments = append(attachments, attachment{filepath.Base(f.Filename), typ, data})
+		}
+	}
+	if len(attachments) == 0 {
+		return c.JSON(fiber.Map{"kept": false, "reason": "no PDF or CSV attachments"})
+	}
+	messageID := strings.TrimSpace(c.FormValue("Message-Id"))
+	if messageID != "" {
+		var n int
+		if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM inbox_documents WHERE organization_id = ? AND message_id = ?`, orgID, messageID).Scan(&n); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if n > 0 {
+			return c.JSON(fiber.Map{"kept": false, "reason": "already received"})
+		}
+	}
+	sender := c.FormValue("sender")
+	if sender == "" {
+		sender = c.FormValue("from")
+	}
+	sender = strings.ToLower(strings.TrimSpace(sender))
+
+	id := genID()
+	dir := filepath.Join("uploads", "inbox_documents", id)
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	// the supplier of the sender's last mail that became a purchase
+	var contactID sql.NullString
+	err = tx.QueryRow(`SELECT contact_id FROM inbox_documents WHERE organization_id = ? AND sender = ? AND status = 'converted' ORDER BY reviewed_at DESC LIMIT 1`, orgID, sender).Scan(&contactID)
+	if err != nil && err != sql.ErrNoRows {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`INSERT INTO inbox_documents (id,organization_id,message_id,sender,subject,body,contact_id,status,received_at) VALUES (?,?,NULLIF(?, ''),?,?,?,?,'pending',?)`,
+		id, orgID, messageID, sender, c.FormValue("subject"), c.FormValue("stripped-text", c.FormValue("body-plain")), contactID, time.Now().Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	kept := 0
+	for _, a := range attachments {
+		if err := scanUpload(dbFor(c), orgID, filepath.ToSlash(dir), a.filename, a.data); err != nil {
+			var rejected *errUploadRejected
+			if errors.As(err, &rejected) {
+				continue
+			}
+			return uploadErrorResponse(c, err)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := os.WriteFile(filepath.Join(dir, a.filename), a.data, 0o644); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		url := fmt.Sprintf("/api/files/inbox_documents/%s/%s", id, a.filename)
+		if _, err := tx.Exec(`INSERT INTO inbox_attachments (id,document_id,filename,content_type,size,url) VALUES (?,?,?,?,?,?)`,
+			genID(), id, a.filename, a.contentType, len(a.data), url); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		kept++
+	}
+	if kept == 0 {
+		return c.JSON(fiber.Map{"kept": false, "reason": "attachments rejected by virus scanner"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "inbox_documents", "create", id)
+	return c.JSON(fiber.Map{"kept": true, "id": id, "attachments": kept})
+}
+
+func handleInboxAddress(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	var key string
+	err := dbFor(c).QueryRow(`SELECT address_key FROM email_inboxes WHERE organization_id = ?`, orgID).Scan(&key)
+	if err == sql.ErrNoRows {
+		if key, err = newInboxKey(); err == nil {
+			_, err = dbFor(c).Exec(`INSERT INTO email_inboxes (organization_id,address_key,created_at) VALUES (?,?,?)`, orgID, key, time.Now().Format(time.RFC3339))
+		}
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"address": inboxAddress(key)})
+}
+
+func handleRotateInboxAddress(c *fiber.Ctx) error {
+	key, err := newInboxKey()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	_, err = dbFor(c).Exec(`INSERT INTO email_inboxes (organization_id,address_key,created_at) VALUES (?,?,?)
+		ON CONFLICT(organization_id) DO UPDATE SET address_key = excluded.address_key, created_at = excluded.created_at`, currentOrgID(c), key, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"address": inboxAddress(key)})
+}
+
+const inboxDocumentColumns = `d.id, d.sender, COALESCE(d.subject, '') AS subject, COALESCE(d.contact_id, '') AS contact_id, COALESCE(ct.name, '') AS contact_name,
+	d.status, COALESCE(d.transaction_id, '') AS transaction_id, COALESCE(d.reviewed_by, '') AS reviewed_by, COALESCE(d.reviewed_at, '') AS reviewed_at, d.received_at`
+
+// inboxAttachments reads the attachments of the documents, by document.
+func inboxAttachments(q *DB, ids []interface{}) (map[string][]map[string]interface{}, error) {
+	out := map[string][]map[string]interface{}{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	rows, err := q.Query(`SELECT id, document_id, filename, content_type, size, url FROM inbox_attachments
+		WHERE document_id IN (?`+strings.Repeat(",?", len(ids)-1)+`) ORDER BY filename`, ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found, err := rowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range found {
+		doc := toString(a["document_id"])
+		delete(a, "document_id")
+		out[doc] = append(out[doc], a)
+	}
+	return out, nil
+}
+
+func handleListInbox(c *fiber.Ctx) error {
+	status := c.Query("status", "pending")
+	rows, err := dbFor(c).Query(`SELECT `+inboxDocumentColumns+` FROM inbox_documents d LEFT JOIN contacts ct ON ct.id = d.contact_id
+		WHERE d.organization_id = ? AND d.status = ? ORDER BY d.received_at DESC LIMIT 200`, currentOrgID(c), status)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	items, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	ids := make([]interface{}, len(items))
+	for i, d := range items {
+		ids[i] = d["id"]
+	}
+	attachments, err := inboxAttachments(dbFor(c), ids)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, d := range items {
+		d["attachments"] = attachments[toString(d["id"])]
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// loadInboxDocument reads one mail of the caller's organization with its
+// body and attachments.
+func loadInboxDocument(c *fiber.Ctx, id string) (map[string]interface{}, error) {
+	rows, err := dbFor(c).Query(`SELECT `+inboxDocumentColumns+`, COALESCE(d.body, '') AS body FROM inbox_documents d LEFT JOIN contacts ct ON ct.id = d.contact_id
+		WHERE d.id = ? AND d.organization_id = ?`, id, currentOrgID(c))
+	if err != nil {
+		return nil, err
+	}
+	found, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errNotFound
+	}
+	attachments, err := inboxAttachments(dbFor(c), []interface{}{id})
+	if err != nil {
+		return nil, err
+	}
+	found[0]["attachments"] = attachments[id]
+	return found[0], nil
+}
+
+func handleGetInboxDocument(c *fiber.Ctx) error {
+	doc, err := loadInboxDocument(c, c.Params("id"))
+	if err != nil {
+		return recordError(c, err)
+	}
+	return c.JSON(doc)
+}
+
+// handleConvertInboxDocument previews or creates the purchase of a mail.
+// Without lines in the request, they are read from its CSV attachments.
+func handleConvertInboxDocument(c *fiber.Ctx) error {
+	id := c.Params("id")
+	doc, err := loadInboxDocument(c, id)
+	if err != nil {
+		return recordError(c, err)
+	}
+	if doc["status"] != "pending" {
+		return c.Status(409).JSON(fiber.Map{"error": "this mail is " + toString(doc["status"])})
+	}
+	var req purchaseImportRequest
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	if req.SupplierID == "" {
+		req.SupplierID = toString(doc["contact_id"])
+	}
+	if req.SupplierID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "supplier_id is required"})
+	}
+	attachments, _ := doc["attachments"].([]map[string]interface{})
+	if len(req.Lines) == 0 {
+		for _, a := range attachments {
+			if a["content_type"] != "text/csv" {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join("uploads", "inbox_documents", id, toString(a["filename"])))
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			lines, err := parsePurchaseCSV(bytes.NewReader(data))
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": toString(a["filename"]) + ": " + err.Error()})
+			}
+			req.Lines = append(req.Lines, lines...)
+		}
+	}
+	if len(req.Lines) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "no invoice lines; enter the lines of the PDF invoice"})
+	}
+	req.source = "email"
+	// the purchase shows the invoice PDF if there is one
+	var shown map[string]interface{}
+	for _, a := range attachments {
+		if shown == nil || (a["content_type"] == "application/pdf" && shown["content_type"] != "application/pdf") {
+			shown = a
+		}
+	}
+	userID := currentUserID(c)
+	return importPurchase(c, req, func(tx *Tx, transactionID string) error {
+		now := time.Now().Format(time.RFC3339)
+		res, err := tx.Exec(`UPDATE inbox_documents SET status = 'converted', transaction_id = ?, contact_id = ?, reviewed_by = ?, reviewed_at = ? WHERE id = ? AND status = 'pending'`,
+			transactionID, req.SupplierID, userID, now, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errInboxReviewed
+		}
+		if _, err := tx.Exec(`UPDATE inbox_attachments SET transaction_id = ? WHERE document_id = ?`, transactionID, id); err != nil {
+			return err
+		}
+		if shown == nil {
+			return nil
+		}
+		_, err = tx.Exec(`UPDATE transactions SET image_filename = ?, image_url = ? WHERE id = ?`, shown["filename"], shown["url"], transactionID)
+		return err
+	})
+}
+
+func handleDismissInboxDocument(c *fiber.Ctx) error {
+	id, orgID := c.Params("id"), currentOrgID(c)
+	if !orgOwns(dbFor(c), "inbox_documents", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	res, err := dbFor(c).Exec(`UPDATE inbox_documents SET status = 'dismissed', reviewed_by = ?, reviewed_at = ? WHERE id = ? AND status = 'pending'`,
+		currentUserID(c), time.Now().Format(time.RFC3339), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "this mail was already reviewed"})
+	}
+	publishRecord(orgID, "inbox_documents", "update", id)
+	return c.JSON(fiber.Map{"id": id, "status": "dismissed"})
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmailInbox(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Pran Foods','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Juice','JUICE',5,30,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	t.Setenv("MAILGUN_SIGNING_KEY", "mg-key")
+	t.Setenv("INBOUND_EMAIL_DOMAIN", "in.example.com")
+
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	// mail sends a mail as Mailgun forwards it, with files named to content
+	mail := func(key, recipient, messageID string, files map[string]string) (int, map[string]interface{}) {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		timestamp, token := strconv.FormatInt(time.Now().Unix(), 10), "tok-"+messageID
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(timestamp + token))
+		for k, v := range map[string]string{"timestamp": timestamp, "token": token, "signature": hex.EncodeToString(mac.Sum(nil)),
+			"recipient": recipient, "sender": "Billing@Pran.example", "subject": "Invoice 881", "Message-Id": messageID, "body-plain": "Please find the invoice attached"} {
+			mw.WriteField(k, v)
+		}
+		i := 0
+		for name, content := range files {
+			i++
+			h := textproto.MIMEHeader{}
+			h.Set("Content-Disposition", `form-data; name="attachment-`+strconv.Itoa(i)+`"; filename="`+name+`"`)
+			h.Set("Content-Type", "application/octet-stream")
+			fw, _ := mw.CreatePart(h)
+			fw.Write([]byte(content))
+		}
+		mw.Close()
+		req := httptest.NewRequest("POST", "/api/inbox/mailgun", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		var out map[string]interface{}
+		_ = json.Unmarshal(raw, &out)
+		return resp.StatusCode, out
+	}
+
+	_, out := call("viewer", "GET", "/api/inbox/address", "")
+	address := toString(out["address"])
+	if !strings.HasPrefix(address, "bills-") || !strings.HasSuffix(address, "@in.example.com") {
+		t.Fatalf("address: %v", out)
+	}
+	if _, again := call("viewer", "GET", "/api/inbox/address", ""); again["address"] != address {
+		t.Errorf("address changed: %v", again)
+	}
+
+	invoice := map[string]string{"invoice.pdf": "%PDF-1.4 invoice", "lines.csv": "sku,name,quantity,unit_cost\nJUICE,Juice,24,20\nCHIPS,Chips,10,12.5\n", "logo.png": "png"}
+	if code, _ := mail("wrong-key", address, "<m1@pran>", invoice); code != 401 {
+		t.Errorf("unsigned mail: got %d, want 401", code)
+	}
+	if code, _ := mail("mg-key", "bills-nope@in.example.com", "<m1@pran>", invoice); code != 406 {
+		t.Errorf("mail to an unknown inbox: got %d, want 406", code)
+	}
+	if code, out := mail("mg-key", address, "<m0@pran>", map[string]string{"photo.jpg": "jpg"}); code != 200 || out["kept"] != false {
+		t.Errorf("mail without invoices: %d %v", code, out)
+	}
+	code, out := mail("mg-key", "Bills <"+address+">", "<m1@pran>", invoice)
+	if code != 200 || out["kept"] != true || out["attachments"] != 2.0 {
+		t.Fatalf("mail: %d %v", code, out)
+	}
+	id := toString(out["id"])
+	if _, out := mail("mg-key", address, "<m1@pran>", invoice); out["kept"] != false {
+		t.Errorf("mail received twice: %v", out)
+	}
+	if _, err := os.Stat(filepath.Join("uploads", "inbox_documents", id, "invoice.pdf")); err != nil {
+		t.Errorf("attachment not stored: %v", err)
+	}
+
+	_, list := call("viewer", "GET", "/api/inbox", "")
+	items, _ := list["items"].([]interface{})
+	if len(items) != 1 || len(items[0].(map[string]interface{})["attachments"].([]interface{})) != 2 {
+		t.Fatalf("queue: %v", list)
+	}
+	if code, _ := call("cashier", "POST", "/api/inbox/"+id+"/convert", `{"supplier_id":"s-1"}`); code != 403 {
+		t.Errorf("cashier converting: got %d, want 403", code)
+	}
+	if code, _ := call("manager", "POST", "/api/inbox/"+id+"/convert", `{}`); code != 400 {
+		t.Errorf("convert without a supplier: got %d, want 400", code)
+	}
+	code, preview := call("manager", "POST", "/api/inbox/"+id+"/convert", `{"supplier_id":"s-1"}`)
+	if code != 200 || preview["confirmed"] != false || preview["total"] != 605.0 || preview["new_items"] != 1.0 {
+		t.Fatalf("preview: %d %v", code, preview)
+	}
+	code, done := call("manager", "POST", "/api/inbox/"+id+"/convert", `{"supplier_id":"s-1","confirm":true}`)
+	if code != 200 || done["confirmed"] != true {
+		t.Fatalf("convert: %d %v", code, done)
+	}
+	purchase := toString(done["transaction_id"])
+	var source, imageURL string
+	var qty int
+	if err := db.QueryRow(`SELECT source, image_url FROM transactions WHERE id = ?`, purchase).Scan(&source, &imageURL); err != nil || source != "email" || !strings.HasSuffix(imageURL, "/invoice.pdf") {
+		t.Errorf("purchase: %q %q %v", source, imageURL, err)
+	}
+	if err := db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = 'i-1'`).Scan(&qty); err != nil || qty != 29 {
+		t.Errorf("restocked: %d %v", qty, err)
+	}
+	_, doc := call("viewer", "GET", "/api/inbox/"+id, "")
+	if doc["status"] != "converted" || doc["transaction_id"] != purchase || doc["contact_name"] != "Pran Foods" {
+		t.Errorf("converted mail: %v", doc)
+	}
+	if code, _ := call("manager", "POST", "/api/inbox/"+id+"/convert", `{"supplier_id":"s-1","confirm":true}`); code != 409 {
+		t.Errorf("converted twice: got %d, want 409", code)
+	}
+
+	// the next mail from the sender suggests the supplier; a PDF alone
+	// needs its lines entered
+	_, out = mail("mg-key", address, "<m2@pran>", map[string]string{"invoice-882.pdf": "%PDF-1.4"})
+	next := toString(out["id"])
+	if _, doc := call("viewer", "GET", "/api/inbox/"+next, ""); doc["contact_id"] != "s-1" {
+		t.Errorf("suggested supplier: %v", doc)
+	}
+	if code, _ := call("manager", "POST", "/api/inbox/"+next+"/convert", `{"confirm":true}`); code != 400 {
+		t.Errorf("PDF without lines: got %d, want 400", code)
+	}
+	if code, _ := call("manager", "POST", "/api/inbox/"+next+"/dismiss", ""); code != 200 {
+		t.Errorf("dismiss: got %d", code)
+	}
+	if _, list := call("viewer", "GET", "/api/inbox?status=dismissed", ""); len(list["items"].([]interface{})) != 1 {
+		t.Errorf("dismissed: %v", list)
+	}
+
+	_, out = call("admin", "POST", "/api/inbox/address/rotate", "")
+	if out["address"] == address {
+		t.Errorf("rotated address: %v", out)
+	}
+	if code, _ := mail("mg-key", address, "<m3@pran>", invoice); code != 406 {
+		t.Errorf("mail to the old address: got %d, want 406", code)
+	}
+}
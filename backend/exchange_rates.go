@@ -0,0 +1,333 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Exchange rates are stored as the price of one unit of a foreign currency
+// in the base currency (e.g. 1 USD = 110.5 BDT). A background fetcher pulls
+// the day's rates from a provider into a set shared by all organizations
+// (organization_id ''); an organization's manual rate for the same day
+// always wins over the fetched one.
+//
+// A transaction may be in a foreign currency: it sends currency, and
+// optionally exchange_rate, with its amounts in that currency. Its amounts
+// are stored in the base currency, which is what every report adds up,
+// and in its own currency as currency_amount, currency_paid_amount,
+// currency_due_amount and, per line, currency_total_price.
+//
+// Configuration:
+//
+//	EXCHANGE_RATE_BASE            base currency (default BDT)
+//	EXCHANGE_RATE_URL             provider URL, {base} is substituted; the
+//	                              response must have a "rates" object
+//	                              (default https://open.er-api.com/v6/latest/{base})
+//	EXCHANGE_RATE_INTERVAL_HOURS  how often to fetch (default 24, 0 disables)
+
+func baseCurrency() string {
+	if v := os.Getenv("EXCHANGE_RATE_BASE"); v != "" {
+		return strings.ToUpper(v)
+	}
+	return "BDT"
+}
+
+func registerExchangeRateRoutes(app *fiber.App) {
+	r := app.Group("/api/exchange-rates", requireAuth)
+	r.Get("/", handleListExchangeRates)
+	r.Post("/fetch", requireRole("admin"), handleFetchExchangeRates)
+	r.Get("/:currency", handleGetExchangeRate)
+	r.Put("/:currency", requireRole("admin", "manager"), handlePutExchangeRate)
+	r.Delete("/:currency/:date", requireRole("admin", "manager"), handleDeleteExchangeRate)
+}
+
+// startExchangeRateFetcher fetches rates once at startup and then on the
+// configured interval.
+func startExchangeRateFetcher() {
+	hours := 24
+	if v, err := strconv.Atoi(os.Getenv("EXCHANGE_RATE_INTERVAL_HOURS")); err == nil {
+		hours = v
+	}
+	if hours <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if n, err := fetchExchangeRates(); err != nil {
+				log.Printf("exchange rates: %v", err)
+			} else {
+				log.Printf("exchange rates: stored %d rates", n)
+			}
+			time.Sleep(time.Duration(hours) * time.Hour)
+		}
+	}()
+}
+
+func fetchExchangeRates() (int, error) {
+	base := baseCurrency()
+	url := os.Getenv("EXCHANGE_RATE_URL")
+	if url == "" {
+		url = "https://open.er-api.com/v6/latest/{base}"
+	}
+	url = strings.ReplaceAll(url, "{base}", base)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return 0, fmt.Errorf("provider returned %s", resp.Status)
+	}
+	var payload struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		return 0, fmt.Errorf("decode provider response: %w", err)
+	}
+	if len(payload.Rates) == 0 {
+		return 0, fmt.Errorf("provider returned no rates")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	day := time.Now().Format("2006-01-02")
+	now := time.Now().Format(time.RFC3339)
+	n := 0
+	for currency, perBase := range payload.Rates {
+		currency = strings.ToUpper(currency)
+		if currency == base || perBase <= 0 {
+			continue
+		}
+		// providers quote units of currency per one base unit; invert it
+		if err := upsertExchangeRate(tx, "", currency, day, 1/perBase, "provider", now); err != nil {
+			return 0, err
+		}
+		n++
+	}
+	return n, tx.Commit()
+}
+
+// upsertExchangeRate stores a rate for orgID, or a shared rate when orgID
+// is "".
+func upsertExchangeRate(tx *Tx, orgID, currency, day string, rate float64, source, now string) error {
+	_, err := tx.Exec(`INSERT INTO exchange_rates (id,organization_id,base,currency,rate,rate_date,source,created_at) VALUES (?,?,?,?,?,?,?,?)
+		ON CONFLICT(organization_id,base,currency,rate_date,source) DO UPDATE SET rate = excluded.rate, created_at = excluded.created_at`,
+		genID(), orgID, baseCurrency(), currency, rate, day, source, now)
+	return err
+}
+
+// exchangeRate returns the rate for currency effective on the given day
+// for orgID: the most recent rate on or before it, preferring the
+// organization's manual override.
+func exchangeRate(q *DB, orgID, currency string, on time.Time) (float64, string, error) {
+	currency = strings.ToUpper(currency)
+	if currency == baseCurrency() {
+		return 1, "base", nil
+	}
+	var rate float64
+	var source string
+	err := q.QueryRow(`SELECT rate, source FROM exchange_rates WHERE organization_id IN ('', ?) AND base = ? AND currency = ? AND rate_date <= ?
+		ORDER BY rate_date DESC, CASE source WHEN 'manual' THEN 0 ELSE 1 END LIMIT 1`,
+		orgID, baseCurrency(), currency, on.Format("2006-01-02")).Scan(&rate, &source)
+	return rate, source, err
+}
+
+// transactionRate returns the rate a transaction body in a foreign
+// currency is taken at: its own exchange_rate, e.g. the one on a
+// supplier's invoice, else the rate of the day. A body in the base
+// currency, or with none, returns 0.
+func transactionRate(q *DB, orgID string, body map[string]interface{}) (string, float64, error) {
+	currency := strings.ToUpper(strings.TrimSpace(toString(body["currency"])))
+	if currency == "" || currency == baseCurrency() {
+		return "", 0, nil
+	}
+	if len(currency) != 3 || strings.Trim(currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return "", 0, fiber.NewError(400, "currency must be a three letter code such as USD")
+	}
+	if v, ok := body["exchange_rate"]; ok && v != nil {
+		rate, isNum := v.(float64)
+		if !isNum || rate <= 0 {
+			return "", 0, fiber.NewError(400, "exchange_rate must be positive")
+		}
+		return currency, rate, nil
+	}
+	rate, _, err := exchangeRate(q, orgID, currency, time.Now())
+	if err == sql.ErrNoRows {
+		return "", 0, fiber.NewError(400, "no exchange rate for "+currency+"; set one or send exchange_rate")
+	}
+	return currency, rate, err
+}
+
+// convertToBase rewrites the money of a transaction body sent in a
+// foreign currency to the base currency at rate, so prices, tax, payments
+// and reports all work in the base currency. What was sent is kept under
+// currency_* keys for fromBase.
+func convertToBase(body map[string]interface{}, rate float64) {
+	for _, key := range []string{"amount", "paid_amount", "due_amount"} {
+		if v, ok := body[key].(float64); ok {
+			body["currency_"+key] = v
+			body[key] = round2(v * rate)
+		}
+	}
+	items, _ := body["items"].([]interface{})
+	for _, item := range items {
+		if l, ok := item.(map[string]interface{}); ok {
+			if v, ok := l["total_price"].(float64); ok {
+				l["currency_total_price"] = v
+				l["total_price"] = round2(v * rate)
+			}
+			if v, ok := l["unit_price"].(float64); ok {
+				l["unit_price"] = v * rate
+			}
+		}
+	}
+	payments, _ := body["payments"].([]interface{})
+	for _, p := range payments {
+		if l, ok := p.(map[string]interface{}); ok {
+			if v, ok := l["amount"].(float64); ok {
+				l["amount"] = round2(v * rate)
+			}
+		}
+	}
+}
+
+// fromBase turns a base currency amount back into the transaction's
+// currency: the amount as sent when the server left it alone, else the
+// amount at rate.
+func fromBase(amount, sent interface{}, rate float64) float64 {
+	base, _ := amount.(float64)
+	if v, ok := sent.(float64); ok && round2(v*rate) == round2(base) {
+		return v
+	}
+	return round2(base / rate)
+}
+
+func handleListExchangeRates(c *fiber.Ctx) error {
+	day := time.Now()
+	if v := c.Query("date"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid date"})
+		}
+		day = t
+	}
+	orgID := currentOrgID(c)
+	rows, err := dbFor(c).Query(`SELECT DISTINCT currency FROM exchange_rates WHERE organization_id IN ('', ?) AND base = ? ORDER BY currency`, orgID, baseCurrency())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var currencies []string
+	for rows.Next() {
+		var cur string
+		if err := rows.Scan(&cur); err == nil {
+			currencies = append(currencies, cur)
+		}
+	}
+	rows.Close()
+
+	items := []fiber.Map{}
+	for _, cur := range currencies {
+		rate, source, err := exchangeRate(dbFor(c), orgID, cur, day)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		items = append(items, fiber.Map{"currency": cur, "rate": rate, "source": source})
+	}
+	return c.JSON(fiber.Map{"base": baseCurrency(), "date": day.Format("2006-01-02"), "items": items})
+}
+
+func handleGetExchangeRate(c *fiber.Ctx) error {
+	day := time.Now()
+	if v := c.Query("date"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid date"})
+		}
+		day = t
+	}
+	rate, source, err := exchangeRate(dbFor(c), currentOrgID(c), c.Params("currency"), day)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "no rate for " + strings.ToUpper(c.Params("currency"))})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"base": baseCurrency(), "currency": strings.ToUpper(c.Params("currency")), "date": day.Format("2006-01-02"), "rate": rate, "source": source})
+}
+
+// handlePutExchangeRate stores a manual override for a day (default today).
+func handlePutExchangeRate(c *fiber.Ctx) error {
+	var req struct {
+		Rate float64 `json:"rate"`
+		Date string  `json:"date"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.Rate <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "rate must be positive"})
+	}
+	day := time.Now()
+	if req.Date != "" {
+		t, err := parseTime(req.Date)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid date"})
+		}
+		day = t
+	}
+	currency := strings.ToUpper(c.Params("currency"))
+	if currency == baseCurrency() {
+		return c.Status(400).JSON(fiber.Map{"error": "cannot set a rate for the base currency"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if err := upsertExchangeRate(tx, currentOrgID(c), currency, day.Format("2006-01-02"), req.Rate, "manual", time.Now().Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"base": baseCurrency(), "currency": currency, "date": day.Format("2006-01-02"), "rate": req.Rate, "source": "manual"})
+}
+
+// handleDeleteExchangeRate removes a manual override, falling back to the
+// fetched rate for that day.
+func handleDeleteExchangeRate(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`DELETE FROM exchange_rates WHERE organization_id = ? AND base = ? AND currency = ? AND rate_date = ? AND source = 'manual'`,
+		currentOrgID(c), baseCurrency(), strings.ToUpper(c.Params("currency")), c.Params("date"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "no manual rate for that day"})
+	}
+	return c.JSON(fiber.Map{"ok": true})
+}
+
+func handleFetchExchangeRates(c *fiber.Ctx) error {
+	n, err := fetchExchangeRates()
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"stored": n})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestForeignCurrencyTransactions(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Shenzhen Parts','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('chip','Chip','CHIP',0,1500,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("manager", "POST", "/api/collections/transactions/records", `{"type":"outflow","currency":"EUR","amount":100,"paid_amount":100,"due_amount":0,"contact_id":"s-1"}`); code != 400 {
+		t.Errorf("currency without a rate: got %d, want 400", code)
+	}
+	if code, out := call("manager", "PUT", "/api/exchange-rates/USD", `{"rate":110}`); code != 200 {
+		t.Fatalf("rate: %d %v", code, out)
+	}
+	code, out := call("manager", "POST", "/api/collections/transactions/records", `{"type":"outflow","currency":"usd","amount":100,"paid_amount":40,"due_amount":60,"contact_id":"s-1","items":[{"item_id":"chip","quantity":10,"unit_price":10,"total_price":100}]}`)
+	if code != 200 || out["currency"] != "USD" || out["currency_amount"] != 100.0 {
+		t.Fatalf("purchase in USD: %d %v", code, out)
+	}
+	var amount, paid, due money
+	var inUSD, rate float64
+	if err := db.QueryRow(`SELECT amount, paid_amount, due_amount, currency_amount, exchange_rate FROM transactions WHERE id = ?`, out["id"]).Scan(&amount, &paid, &due, &inUSD, &rate); err != nil {
+		t.Fatal(err)
+	}
+	if amount != moneyOf(11000) || paid != moneyOf(4400) || due != moneyOf(6600) || inUSD != 100 || rate != 110 {
+		t.Errorf("amounts %v/%v/%v, %v USD at %v", amount, paid, due, inUSD, rate)
+	}
+	var unitPrice money
+	var lineUSD float64
+	if err := db.QueryRow(`SELECT unit_price, currency_total_price FROM transaction_items WHERE transaction_id = ?`, out["id"]).Scan(&unitPrice, &lineUSD); err != nil || unitPrice != moneyOf(1100) || lineUSD != 100 {
+		t.Errorf("line %v, %v USD %v", unitPrice, lineUSD, err)
+	}
+
+	// the rate on the supplier's invoice wins over the day's
+	code, out = call("manager", "POST", "/api/collections/transactions/records", `{"type":"outflow","currency":"USD","exchange_rate":112.5,"amount":20,"paid_amount":20,"due_amount":0,"contact_id":"s-1"}`)
+	if code != 200 {
+		t.Fatalf("purchase at the invoice's rate: %d %v", code, out)
+	}
+	if err := db.QueryRow(`SELECT amount, currency_amount FROM transactions WHERE id = ?`, out["id"]).Scan(&amount, &inUSD); err != nil || amount != moneyOf(2250) || inUSD != 20 {
+		t.Errorf("at 112.5: %v, %v USD %v", amount, inUSD, err)
+	}
+	if code, got := call("viewer", "GET", "/api/collections/transactions/records/"+toString(out["id"]), ""); code != 200 || got["currency"] != "USD" || got["exchange_rate"] != 112.5 {
+		t.Errorf("get: %d %v", code, got)
+	}
+}
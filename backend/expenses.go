@@ -0,0 +1,330 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Expenses are account movements of kind "expense" (cash_accounts.go).
+// Each may name a vendor and carry a category. An expense entered without
+// a category gets one from the organization's expense rules:
+//
+//   - a vendor rule matches when the expense's vendor equals its pattern;
+//   - a keyword rule matches when its pattern appears in the vendor or
+//     notes. The longest matching keyword wins.
+//
+// Vendor rules win over keyword rules, and between equally good matches a
+// rule a user entered wins over a learned one. Correcting an expense's
+// category teaches a vendor rule, so the next expense from that vendor is
+// filed the same way. Imported or OCR'd expenses can be run through
+// POST /api/expenses/categorize before they are saved, and
+// POST /api/expenses/recategorize files the expenses still lacking a
+// category.
+
+type expenseRule struct {
+	ID       string
+	Kind     string
+	Pattern  string
+	Category string
+	Source   string
+}
+
+func registerExpenseRoutes(app *fiber.App) {
+	r := app.Group("/api/expenses", requireAuth)
+	r.Get("/", handleListExpenses)
+	r.Post("/categorize", handleSuggestExpenseCategories)
+	r.Post("/recategorize", requireRole("admin", "manager"), handleRecategorizeExpenses)
+	r.Patch("/:id", requireRole("admin", "manager"), handleCorrectExpenseCategory)
+
+	rules := app.Group("/api/expense-rules", requireAuth)
+	rules.Get("/", handleListExpenseRules)
+	rules.Post("/", requireRole("admin", "manager"), handleCreateExpenseRule)
+	rules.Delete("/:id", requireRole("admin", "manager"), handleDeleteExpenseRule)
+}
+
+// normalizeExpenseText lower-cases s and collapses its whitespace so
+// "ABC  Traders " and "abc traders" match.
+func normalizeExpenseText(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+func loadExpenseRules(q queryer, orgID string) ([]expenseRule, error) {
+	rows, err := q.Query(`SELECT id, kind, pattern, category, source FROM expense_rules WHERE organization_id = ?`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []expenseRule
+	for rows.Next() {
+		var r expenseRule
+		if err := rows.Scan(&r.ID, &r.Kind, &r.Pattern, &r.Category, &r.Source); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// matchExpenseRule picks the rule that files an expense, or nil.
+func matchExpenseRule(rules []expenseRule, vendor, notes string) *expenseRule {
+	vendor = normalizeExpenseText(vendor)
+	text := normalizeExpenseText(vendor + " " + notes)
+	var best *expenseRule
+	rank := func(r *expenseRule) int {
+		n := 2 * len(r.Pattern)
+		if r.Kind == "vendor" {
+			n += 1 << 20
+		}
+		if r.Source == "manual" {
+			n++
+		}
+		return n
+	}
+	for i := range rules {
+		r := &rules[i]
+		matched := false
+		switch r.Kind {
+		case "vendor":
+			matched = vendor != "" && vendor == r.Pattern
+		case "keyword":
+			matched = strings.Contains(text, r.Pattern)
+		}
+		if matched && (best == nil || rank(r) > rank(best)) {
+			best = r
+		}
+	}
+	return best
+}
+
+// categorizeExpense returns the category the rules of orgID give an
+// expense and the id of the rule used, both "" when none matches.
+func categorizeExpense(q queryer, orgID, vendor, notes string) (string, string) {
+	rules, err := loadExpenseRules(q, orgID)
+	if err != nil {
+		return "", ""
+	}
+	if r := matchExpenseRule(rules, vendor, notes); r != nil {
+		return r.Category, r.ID
+	}
+	return "", ""
+}
+
+// recordExpense writes an expense movement inside tx, filing it by the
+// expense rules when no category is given. It returns the category used.
+func recordExpense(tx *Tx, orgID, accountID string, amount float64, vendor, category, notes string) (string, error) {
+	category = strings.TrimSpace(category)
+	if category == "" {
+		var ruleID string
+		if category, ruleID = categorizeExpense(tx, orgID, vendor, notes); ruleID != "" {
+			if _, err := tx.Exec(`UPDATE expense_rules SET hits = hits + 1 WHERE id = ?`, ruleID); err != nil {
+				return "", err
+			}
+		}
+	}
+	_, err := tx.Exec(`INSERT INTO account_movements (id,account_id,amount,kind,ref_type,ref_id,notes,vendor,category,created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`,
+		genID(), accountID, amount, "expense", "", "", notes, strings.TrimSpace(vendor), category, time.Now().Format(time.RFC3339))
+	return category, err
+}
+
+// learnExpenseRule remembers that expenses from vendor belong in category.
+func learnExpenseRule(q *DB, orgID, vendor, category string) error {
+	now := time.Now().Format(time.RFC3339)
+	_, err := q.Exec(`INSERT INTO expense_rules (id,organization_id,kind,pattern,category,source,hits,created_at,updated_at) VALUES (?,?,'vendor',?,?,'learned',0,?,?)
+		ON CONFLICT(organization_id,kind,pattern) DO UPDATE SET category = excluded.category, source = excluded.source, updated_at = excluded.updated_at`,
+		genID(), orgID, normalizeExpenseText(vendor), category, now, now)
+	return err
+}
+
+// expenseSQL selects the expenses of an organization.
+const expenseSQL = `SELECT m.id, m.account_id, a.name AS account_name, -m.amount AS amount, m.vendor, m.category, m.notes, m.created_at FROM account_movements m JOIN cash_accounts a ON m.account_id = a.id WHERE a.organization_id = ? AND m.kind = 'expense'`
+
+func handleListExpenses(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	query := expenseSQL + ` AND m.created_at >= ? AND m.created_at < ?`
+	args := []interface{}{currentOrgID(c), from.Format(time.RFC3339), to.Format(time.RFC3339)}
+	if v := c.Query("category"); v != "" {
+		query += ` AND m.category = ?`
+		args = append(args, v)
+	}
+	if c.Query("uncategorized") == "true" {
+		query += ` AND COALESCE(m.category, '') = ''`
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY m.created_at`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return streamItems(c, rows, nil, nil)
+}
+
+// handleCorrectExpenseCategory sets an expense's category and, unless
+// learn is false, files later expenses from the same vendor likewise.
+func handleCorrectExpenseCategory(c *fiber.Ctx) error {
+	var req struct {
+		Category string `json:"category"`
+		Learn    *bool  `json:"learn"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	req.Category = strings.TrimSpace(req.Category)
+	if req.Category == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "category required"})
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	var vendor sql.NullString
+	if err := dbFor(c).QueryRow(`SELECT m.vendor FROM account_movements m JOIN cash_accounts a ON m.account_id = a.id WHERE m.id = ? AND a.organization_id = ? AND m.kind = 'expense'`, id, orgID).Scan(&vendor); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "expense not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := dbFor(c).Exec(`UPDATE account_movements SET category = ? WHERE id = ?`, req.Category, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	learned := false
+	if (req.Learn == nil || *req.Learn) && normalizeExpenseText(vendor.String) != "" {
+		if err := learnExpenseRule(dbFor(c), orgID, vendor.String, req.Category); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		learned = true
+	}
+	return c.JSON(fiber.Map{"id": id, "category": req.Category, "learned": learned})
+}
+
+// handleSuggestExpenseCategories files expenses that have not been saved
+// yet, e.g. lines read from a receipt, without changing anything.
+func handleSuggestExpenseCategories(c *fiber.Ctx) error {
+	var req struct {
+		Items []struct {
+			Vendor string `json:"vendor"`
+			Notes  string `json:"notes"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	rules, err := loadExpenseRules(dbFor(c), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	items := []fiber.Map{}
+	for _, it := range req.Items {
+		entry := fiber.Map{"vendor": it.Vendor, "notes": it.Notes, "category": nil, "rule_id": nil}
+		if r := matchExpenseRule(rules, it.Vendor, it.Notes); r != nil {
+			entry["category"], entry["rule_id"] = r.Category, r.ID
+		}
+		items = append(items, entry)
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleRecategorizeExpenses applies the rules to every expense that has
+// no category yet.
+func handleRecategorizeExpenses(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	rules, err := loadExpenseRules(dbFor(c), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	rows, err := dbFor(c).Query(`SELECT m.id, COALESCE(m.vendor, ''), COALESCE(m.notes, '') FROM account_movements m JOIN cash_accounts a ON m.account_id = a.id WHERE a.organization_id = ? AND m.kind = 'expense' AND COALESCE(m.category, '') = ''`, orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	type filing struct{ id, category, ruleID string }
+	var filings []filing
+	pending := 0
+	for rows.Next() {
+		var id, vendor, notes string
+		if err := rows.Scan(&id, &vendor, &notes); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		pending++
+		if r := matchExpenseRule(rules, vendor, notes); r != nil {
+			filings = append(filings, filing{id, r.Category, r.ID})
+		}
+	}
+	rows.Close()
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	for _, f := range filings {
+		if _, err := tx.Exec(`UPDATE account_movements SET category = ? WHERE id = ?`, f.category, f.id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := tx.Exec(`UPDATE expense_rules SET hits = hits + 1 WHERE id = ?`, f.ruleID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"categorized": len(filings), "uncategorized": pending - len(filings)})
+}
+
+func handleListExpenseRules(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT id, kind, pattern, category, source, hits, created_at, updated_at FROM expense_rules WHERE organization_id = ? ORDER BY kind DESC, pattern`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func handleCreateExpenseRule(c *fiber.Ctx) error {
+	var req struct {
+		Kind     string `json:"kind"`
+		Pattern  string `json:"pattern"`
+		Category string `json:"category"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.Kind != "vendor" && req.Kind != "keyword" {
+		return c.Status(400).JSON(fiber.Map{"error": "kind must be vendor or keyword"})
+	}
+	pattern, category := normalizeExpenseText(req.Pattern), strings.TrimSpace(req.Category)
+	if pattern == "" || category == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "pattern and category required"})
+	}
+	orgID := currentOrgID(c)
+	var n int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM expense_rules WHERE organization_id = ? AND kind = ? AND pattern = ?`, orgID, req.Kind, pattern).Scan(&n); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "a " + req.Kind + " rule for " + pattern + " already exists"})
+	}
+	id := genID()
+	now := time.Now().Format(time.RFC3339)
+	if _, err := dbFor(c).Exec(`INSERT INTO expense_rules (id,organization_id,kind,pattern,category,source,hits,created_at,updated_at) VALUES (?,?,?,?,?,'manual',0,?,?)`,
+		id, orgID, req.Kind, pattern, category, now, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+func handleDeleteExpenseRule(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`DELETE FROM expense_rules WHERE id = ? AND organization_id = ?`, c.Params("id"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "rule not found"})
+	}
+	return c.JSON(fiber.Map{"ok": true})
+}
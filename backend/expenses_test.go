@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatchExpenseRule(t *testing.T) {
+	rules := []expenseRule{
+		{ID: "k1", Kind: "keyword", Pattern: "electric", Category: "Utilities", Source: "manual"},
+		{ID: "k2", Kind: "keyword", Pattern: "electric bill", Category: "Power", Source: "learned"},
+		{ID: "v1", Kind: "vendor", Pattern: "desco", Category: "Electricity", Source: "learned"},
+		{ID: "k3", Kind: "keyword", Pattern: "rent", Category: "Rent", Source: "learned"},
+		{ID: "k4", Kind: "keyword", Pattern: "rent", Category: "Shop rent", Source: "manual"},
+	}
+	cases := []struct{ vendor, notes, want string }{
+		{"DESCO ", "electric bill", "v1"},
+		{"", "Electric  bill for May", "k2"},
+		{"", "electricity", "k1"},
+		{"Landlord", "monthly rent", "k4"},
+		{"", "tea", ""},
+	}
+	for _, c := range cases {
+		got := ""
+		if r := matchExpenseRule(rules, c.vendor, c.notes); r != nil {
+			got = r.ID
+		}
+		if got != c.want {
+			t.Errorf("%q/%q matched %q, want %q", c.vendor, c.notes, got, c.want)
+		}
+	}
+}
+
+func TestExpenseCategoryLearnedFromCorrection(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO cash_accounts (id,name,kind,opening_balance,organization_id) VALUES ('a-1','Drawer','cash',1000,'org-1')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("manager", "POST", "/api/expense-rules", `{"kind":"keyword","pattern":"Tea","category":"Refreshments"}`); code != 200 {
+		t.Fatalf("create rule: got %d", code)
+	}
+	if code, _ := call("manager", "POST", "/api/expense-rules", `{"kind":"keyword","pattern":"tea","category":"Other"}`); code != 409 {
+		t.Errorf("duplicate rule: got %d, want 409", code)
+	}
+	if _, out := call("manager", "POST", "/api/cash-accounts/a-1/entries", `{"kind":"expense","amount":50,"notes":"tea for staff"}`); out["category"] != "Refreshments" {
+		t.Errorf("keyword rule gave %v", out["category"])
+	}
+	if _, out := call("manager", "POST", "/api/cash-accounts/a-1/entries", `{"kind":"expense","amount":900,"vendor":"Karim Transport","notes":"delivery"}`); out["category"] != "" {
+		t.Errorf("unmatched expense got %v", out["category"])
+	}
+	_, list := call("manager", "GET", "/api/expenses?uncategorized=true&from=2000-01-01&to=2100-01-01", "")
+	items := list["items"].([]interface{})
+	if len(items) != 1 {
+		t.Fatalf("%d uncategorized expenses, want 1", len(items))
+	}
+	id := items[0].(map[string]interface{})["id"].(string)
+	if code, out := call("manager", "PATCH", "/api/expenses/"+id, `{"category":"Freight"}`); code != 200 || out["learned"] != true {
+		t.Fatalf("correction: %d %v", code, out)
+	}
+
+	// the next expense from the vendor is filed by the learned rule
+	if _, out := call("manager", "POST", "/api/cash-accounts/a-1/entries", `{"kind":"expense","amount":400,"vendor":"karim  transport"}`); out["category"] != "Freight" {
+		t.Errorf("learned rule gave %v", out["category"])
+	}
+	_, out := call("cashier", "POST", "/api/expenses/categorize", `{"items":[{"vendor":"Karim Transport"},{"notes":"TEA"},{"notes":"fuel"}]}`)
+	got := []interface{}{}
+	for _, it := range out["items"].([]interface{}) {
+		got = append(got, it.(map[string]interface{})["category"])
+	}
+	if len(got) != 3 || got[0] != "Freight" || got[1] != "Refreshments" || got[2] != nil {
+		t.Errorf("suggestions %v", got)
+	}
+
+	if _, err := db.Exec(`INSERT INTO account_movements (id,account_id,amount,kind,notes,created_at) VALUES ('m-old','a-1',-20,'expense','tea','2024-01-01T00:00:00Z')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, out := call("manager", "POST", "/api/expenses/recategorize", ""); out["categorized"] != float64(1) || out["uncategorized"] != float64(0) {
+		t.Errorf("recategorize: %v", out)
+	}
+	var hits int
+	_ = db.QueryRow(`SELECT hits FROM expense_rules WHERE pattern = 'tea'`).Scan(&hits)
+	if hits != 2 {
+		t.Errorf("tea rule used %d times, want 2", hits)
+	}
+}
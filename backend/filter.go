@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The list endpoint's filter parameter is a small PocketBase-style
+// expression language compiled into a parameterized WHERE clause:
+//
+//	type = "inflow" && (amount > 100 || contact_id = "abc")
+//
+// Operators: = != > >= < <= ~ (contains, LIKE) !~ (not contains), joined
+// with && and || and grouped with parentheses. Values are quoted strings,
+// numbers, true/false or null. Only fields in the collection's allowlist
+// may be referenced, and values are always bound as arguments.
+
+// collectionFields lists the columns of each collection that clients may
+// filter and sort on.
+var collectionFields = map[string][]string{
+	"contacts":               {"id", "name", "phone", "email", "nid", "type", "price_list_id", "sms_opt_out", "organization_id"},
+	"inventory_items":        {"id", "name", "sku", "quantity", "unit", "unit_price", "reorder_level", "category", "description", "cost_price", "min_sale_price", "tax_rate_id", "supplier_id", "rental_stock", "parent_id", "updated_at", "created_at"},
+	"inventory_transactions": {"id", "item_id", "quantity_change", "previous_quantity", "new_quantity", "transaction_type", "notes", "created_at"},
+	"transactions":           {"id", "type", "amount", "paid_amount", "due_amount", "contact_id", "contact_name", "payment_method", "receipt_number", "tax_amount", "currency", "currency_amount", "order_status", "order_token", "order_table", "source", "voided_at", "due_date", "created_at"},
+	"payment_methods":        {"id", "code", "name", "active", "account_id", "fee_percent", "fee_fixed", "settlement_days", "created_at"},
+}
+
+// moneyFields are the filterable columns stored in minor units (see
+// money.go); a number compared with one is taken in major units.
+var moneyFields = map[string][]string{
+	"transactions": {"amount", "paid_amount", "due_amount"},
+}
+
+func allowedField(collection, field string) bool {
+	for _, f := range collectionFields[collection] {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+type filterToken struct {
+	kind  string // ident, string, number, op, lparen, rparen, and, or
+	value string
+}
+
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	i := 0
+	for i < len(s) {
+		ch := rune(s[i])
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case ch == '(':
+			tokens = append(tokens, filterToken{kind: "lparen"})
+			i++
+		case ch == ')':
+			tokens = append(tokens, filterToken{kind: "rparen"})
+			i++
+		case strings.HasPrefix(s[i:], "&&"):
+			tokens = append(tokens, filterToken{kind: "and"})
+			i += 2
+		case strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, filterToken{kind: "or"})
+			i += 2
+		case ch == '"' || ch == '\'':
+			quote := s[i]
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != quote; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, filterToken{kind: "string", value: b.String()})
+			i = j + 1
+		case strings.ContainsRune("=!<>~", ch):
+			op := string(ch)
+			if i+1 < len(s) && (s[i+1] == '=' || s[i+1] == '~') && ch != '=' && ch != '~' {
+				op += string(s[i+1])
+			}
+			switch op {
+			case "=", "!=", ">", ">=", "<", "<=", "~", "!~":
+			default:
+				return nil, fmt.Errorf("unknown operator %q at %d", op, i)
+			}
+			tokens = append(tokens, filterToken{kind: "op", value: op})
+			i += len(op)
+		case ch == '-' || ch == '.' || unicode.IsDigit(ch):
+			j := i + 1
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: "number", value: s[i:j]})
+			i = j
+		case ch == '_' || unicode.IsLetter(ch):
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j]))) {
+				j++
+			}
+			tokens = append(tokens, filterToken{kind: "ident", value: s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q at %d", ch, i)
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens     []filterToken
+	pos        int
+	collection string
+	qualifier  string
+	args       []interface{}
+}
+
+// parseFilter compiles a filter expression for collection into SQL and its
+// bind arguments. qualifier (e.g. "t.") is prefixed to column names when
+// the list query uses a table alias.
+func parseFilter(expr, collection, qualifier string) (string, []interface{}, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return "", nil, err
+	}
+	p := &filterParser{tokens: tokens, collection: collection, qualifier: qualifier}
+	sqlExpr, err := p.parseOr()
+	if err != nil {
+		return "", nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return "", nil, fmt.Errorf("unexpected token after expression")
+	}
+	return sqlExpr, p.args, nil
+}
+
+func (p *filterParser) peek() *filterToken {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *filterParser) parseOr() (string, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for t := p.peek(); t != nil && t.kind == "or"; t = p.peek() {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " OR " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (string, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return "", err
+	}
+	for t := p.peek(); t != nil && t.kind == "and"; t = p.peek() {
+		p.pos++
+		right, err := p.parsePrimary()
+		if err != nil {
+			return "", err
+		}
+		left = "(" + left + " AND " + right + ")"
+	}
+	return left, nil
+}
+
+func (p *filterParser) parsePrimary() (string, error) {
+	t := p.peek()
+	if t == nil {
+		return "", fmt.Errorf("unexpected end of filter")
+	}
+	if t.kind == "lparen" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if t := p.peek(); t == nil || t.kind != "rparen" {
+			return "", fmt.Errorf("missing closing parenthesis")
+		}
+		p.pos++
+		return inner, nil
+	}
+	if t.kind != "ident" {
+		return "", fmt.Errorf("expected field name")
+	}
+	field := t.value
+	if !allowedField(p.collection, field) {
+		return "", fmt.Errorf("cannot filter on field %q", field)
+	}
+	p.pos++
+	opTok := p.peek()
+	if opTok == nil || opTok.kind != "op" {
+		return "", fmt.Errorf("expected operator after %s", field)
+	}
+	p.pos++
+	valTok := p.peek()
+	if valTok == nil {
+		return "", fmt.Errorf("expected value after %s %s", field, opTok.value)
+	}
+	p.pos++
+	column := p.qualifier + field
+
+	var value interface{}
+	switch {
+	case valTok.kind == "string":
+		value = valTok.value
+	case valTok.kind == "number":
+		n, err := strconv.ParseFloat(valTok.value, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid number %q", valTok.value)
+		}
+		value = n
+		for _, f := range moneyFields[p.collection] {
+			if f == field {
+				value = moneyOf(n)
+			}
+		}
+	case valTok.kind == "ident" && valTok.value == "null":
+		switch opTok.value {
+		case "=":
+			return column + " IS NULL", nil
+		case "!=":
+			return column + " IS NOT NULL", nil
+		}
+		return "", fmt.Errorf("null only supports = and !=")
+	case valTok.kind == "ident" && (valTok.value == "true" || valTok.value == "false"):
+		if valTok.value == "true" {
+			value = 1
+		} else {
+			value = 0
+		}
+	default:
+		return "", fmt.Errorf("expected value after %s %s", field, opTok.value)
+	}
+
+	switch opTok.value {
+	case "~", "!~":
+		s := fmt.Sprint(value)
+		if !strings.Contains(s, "%") {
+			s = "%" + s + "%"
+		}
+		p.args = append(p.args, s)
+		if opTok.value == "~" {
+			return column + " LIKE ?", nil
+		}
+		return column + " NOT LIKE ?", nil
+	default:
+		p.args = append(p.args, value)
+		return column + " " + opTok.value + " ?", nil
+	}
+}
+
+// parseSort turns "-created_at,name" into an ORDER BY list, rejecting
+// fields outside the collection's allowlist.
+func parseSort(sort, collection, qualifier string) (string, error) {
+	var parts []string
+	for _, f := range strings.Split(sort, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		dir := " ASC"
+		if strings.HasPrefix(f, "-") {
+			f, dir = f[1:], " DESC"
+		} else {
+			f = strings.TrimPrefix(f, "+")
+		}
+		if !allowedField(collection, f) {
+			return "", fmt.Errorf("cannot sort on field %q", f)
+		}
+		parts = append(parts, qualifier+f+dir)
+	}
+	return strings.Join(parts, ", "), nil
+}
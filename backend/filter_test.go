@@ -0,0 +1,101 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		expr      string
+		qualifier string
+		wantSQL   string
+		wantArgs  []interface{}
+	}{
+		{"equality", `type = "inflow"`, "", "type = ?", []interface{}{"inflow"}},
+		{"single quotes", `type = 'inflow'`, "", "type = ?", []interface{}{"inflow"}},
+		{"escaped quote", `type = "in\"flow"`, "", "type = ?", []interface{}{`in"flow`}},
+		{"number", `amount >= 100.5`, "", "amount >= ?", []interface{}{money(10050)}},
+		{"negative number", `due_amount < -1`, "", "due_amount < ?", []interface{}{money(-100)}},
+		{"qualifier", `amount != 0`, "t.", "t.amount != ?", []interface{}{money(0)}},
+		{"contains", `source ~ "imp"`, "", "source LIKE ?", []interface{}{"%imp%"}},
+		{"not contains keeps wildcard", `source !~ "im%"`, "", "source NOT LIKE ?", []interface{}{"im%"}},
+		{"null", `contact_id = null`, "", "contact_id IS NULL", nil},
+		{"not null", `contact_id != null`, "", "contact_id IS NOT NULL", nil},
+		{"and binds tighter than or", `type = "inflow" || type = "outflow" && amount > 5`, "",
+			"(type = ? OR (type = ? AND amount > ?))", []interface{}{"inflow", "outflow", money(500)}},
+		{"parentheses override precedence", `(type = "inflow" || type = "outflow") && amount > 5`, "",
+			"((type = ? OR type = ?) AND amount > ?)", []interface{}{"inflow", "outflow", money(500)}},
+		{"injection stays a bound value", `type = "x' OR 1=1 --"`, "", "type = ?", []interface{}{"x' OR 1=1 --"}},
+		{"semicolon in value", `type = "a; DROP TABLE transactions"`, "", "type = ?", []interface{}{"a; DROP TABLE transactions"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotSQL, gotArgs, err := parseFilter(tt.expr, "transactions", tt.qualifier)
+			if err != nil {
+				t.Fatalf("parseFilter(%q): %v", tt.expr, err)
+			}
+			if gotSQL != tt.wantSQL {
+				t.Errorf("sql = %q, want %q", gotSQL, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("args = %#v, want %#v", gotArgs, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestParseFilterRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"field not in allowlist", `password = "x"`},
+		{"column from another collection", `sku = "A1"`},
+		{"organization is not filterable", `organization_id = "other"`},
+		{"sql in field position", `1=1 OR type = "inflow"`},
+		{"subquery as field", `(SELECT id FROM users) = "x"`},
+		{"comment", `type = "inflow" -- x`},
+		{"statement separator", `type = "inflow"; DROP TABLE transactions`},
+		{"bare identifier value", `type = inflow`},
+		{"unterminated string", `type = "inflow`},
+		{"unknown operator", `amount >~ 5`},
+		{"missing value", `amount >`},
+		{"missing closing parenthesis", `(type = "inflow"`},
+		{"dangling operator", `type = "inflow" &&`},
+		{"null with comparison", `amount > null`},
+		{"trailing tokens", `type = "inflow" "outflow"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sql, args, err := parseFilter(tt.expr, "transactions", ""); err == nil {
+				t.Errorf("parseFilter(%q) = %q %v, want error", tt.expr, sql, args)
+			}
+		})
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		sort    string
+		want    string
+		wantErr bool
+	}{
+		{"-created_at", "t.created_at DESC", false},
+		{"amount, -created_at", "t.amount ASC, t.created_at DESC", false},
+		{"+amount", "t.amount ASC", false},
+		{"password", "", true},
+		{"created_at; DROP TABLE transactions", "", true},
+	}
+	for _, tt := range tests {
+		got, err := parseSort(tt.sort, "transactions", "t.")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSort(%q) error = %v, wantErr %v", tt.sort, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseSort(%q) = %q, want %q", tt.sort, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Closing a fiscal year locks it against backdated edits, records each
+// contact's net balance, each item's owned stock and the cash at year end
+// in year_end_balances, and archives the year-end report bundle (P&L,
+// balance sheet, stock valuation) as JSON on the fiscal_years row. The
+// figures are computed in the same transaction that writes the close, and
+// fiscal years of an organization never overlap.
+//
+// The same balances open the next period as opening_balances entries
+// dated the day after the year ends and tied to the year by
+// fiscal_year_id. Unlike wizard entries (opening.go) they generate no
+// baseline records: the year's open transactions and stock movements
+// already carry them.
+
+func registerFiscalYearRoutes(app *fiber.App) {
+	r := app.Group("/api/fiscal-years", requireAuth)
+	r.Get("/", handleListFiscalYears)
+	r.Post("/close", requireRole("admin"), handleCloseFiscalYear)
+	r.Get("/:id/report", handleFiscalYearReport)
+}
+
+func handleListFiscalYears(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT id,name,start_date,end_date,status,closed_at FROM fiscal_years WHERE organization_id = ? ORDER BY start_date DESC`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+type closeFiscalYearRequest struct {
+	Name      string `json:"name"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+func handleCloseFiscalYear(c *fiber.Ctx) error {
+	var req closeFiscalYearRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	start, err1 := time.ParseInLocation("2006-01-02", req.StartDate, time.Local)
+	end, err2 := time.ParseInLocation("2006-01-02", req.EndDate, time.Local)
+	if err1 != nil || err2 != nil || !end.After(start) {
+		return c.Status(400).JSON(fiber.Map{"error": "start_date and end_date must be YYYY-MM-DD with end after start"})
+	}
+	// the year covers whole days, so everything before the day after end_date
+	periodEnd := end.AddDate(0, 0, 1)
+	if periodEnd.After(time.Now()) {
+		return c.Status(400).JSON(fiber.Map{"error": "cannot close a year that has not ended"})
+	}
+	if req.Name == "" {
+		req.Name = "FY " + req.StartDate + " - " + req.EndDate
+	}
+	orgID := currentOrgID(c)
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var overlapping int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM fiscal_years WHERE organization_id = ? AND start_date <= ? AND end_date >= ?`, orgID, req.EndDate, req.StartDate).Scan(&overlapping); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if overlapping > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "period overlaps another fiscal year"})
+	}
+
+	asOf := periodEnd.Add(-time.Second)
+	pl, err := profitAndLoss(c.UserContext(), tx, orgID, start, periodEnd)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	bs, err := balanceSheet(c.UserContext(), tx, orgID, asOf)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	stockItems, stockTotal, err := stockValuation(c.UserContext(), tx, orgID, asOf)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	report, err := json.Marshal(fiber.Map{
+		"profit_loss":     pl,
+		"balance_sheet":   bs,
+		"stock_valuation": fiber.Map{"items": stockItems, "total_value": stockTotal},
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	id := genID()
+	now := time.Now().Format(time.RFC3339)
+	if _, err := tx.Exec(`INSERT INTO fiscal_years (id,name,start_date,end_date,status,report,organization_id,closed_at) VALUES (?,?,?,?,?,?,?,?)`, id, req.Name, req.StartDate, req.EndDate, "closed", string(report), orgID, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	// carry forward net contact balances: receivable (+) minus payable (-)
+	rows, err := tx.Query(`SELECT contact_id, SUM(CASE WHEN type = 'inflow' THEN due_amount ELSE -due_amount END) AS amount
+		FROM transactions WHERE organization_id = ? AND created_at < ? AND voided_at IS NULL GROUP BY contact_id HAVING SUM(CASE WHEN type = 'inflow' THEN due_amount ELSE -due_amount END) <> 0`, orgID, periodEnd.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	contactBalances, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	moneyColumns(contactBalances, "amount")
+	opens := periodEnd.Format("2006-01-02")
+	carry := func(kind string, refID, quantity interface{}, unitCost, amount float64) error {
+		if _, err := tx.Exec(`INSERT INTO year_end_balances (id,fiscal_year_id,kind,ref_id,quantity,amount) VALUES (?,?,?,?,?,?)`, genID(), id, kind, refID, quantity, amount); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO opening_balances (id,kind,ref_id,quantity,unit_cost,amount,cutover_date,fiscal_year_id,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`, genID(), kind, refID, quantity, unitCost, amount, opens, id, orgID, now)
+		return err
+	}
+	for _, b := range contactBalances {
+		amount, _ := b["amount"].(money)
+		if err := carry("contact", b["contact_id"], nil, 0, amount.float()); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	for _, it := range stockItems {
+		if err := carry("stock", it["item_id"], it["owned_quantity"], it["unit_value"].(float64), it["value"].(float64)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	cash, _ := bs["assets"].(fiber.Map)["cash"].(float64)
+	if err := carry("cash", "", nil, 0, cash); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "name": req.Name, "status": "closed"})
+}
+
+func handleFiscalYearReport(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var name, start, end, status string
+	var report sql.NullString
+	err := dbFor(c).QueryRow(`SELECT name,start_date,end_date,status,report FROM fiscal_years WHERE id = ? AND organization_id = ?`, id, currentOrgID(c)).Scan(&name, &start, &end, &status, &report)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var bundle map[string]interface{}
+	if report.Valid {
+		if err := json.Unmarshal([]byte(report.String), &bundle); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "archived report is unreadable: " + err.Error()})
+		}
+	}
+	rows, err := dbFor(c).Query(`SELECT kind,ref_id,quantity,amount FROM year_end_balances WHERE fiscal_year_id = ? ORDER BY kind`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	balances, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "name": name, "start_date": start, "end_date": end, "status": status, "report": bundle, "carried_forward": balances})
+}
+
+// periodLocked reports whether t falls inside a closed fiscal year of orgID.
+func periodLocked(q queryer, orgID string, t time.Time) (bool, error) {
+	var n int
+	day := t.Format("2006-01-02")
+	err := q.QueryRow(`SELECT COUNT(1) FROM fiscal_years WHERE organization_id = ? AND status = 'closed' AND start_date <= ? AND end_date >= ?`, orgID, day, day).Scan(&n)
+	return n > 0, err
+}
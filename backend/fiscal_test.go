@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Closing a year archives its reports, opens the next period with what it
+// carried forward and locks the year's records against change.
+func TestCloseFiscalYear(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Karim','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,5,'org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-1','inflow',10000,4000,6000,'c-1','org-1','2024-03-01T10:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('p-1','outflow',5000,5000,0,'s-1','org-1','2024-02-01T10:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, out := call("admin", "POST", "/api/opening-balances/cash", `{"cutover_date":"2024-01-01","amount":100}`); code != 200 {
+		t.Fatalf("opening cash: %d %v", code, out)
+	}
+	year := `{"start_date":"2024-01-01","end_date":"2024-12-31"}`
+	if code, _ := call("manager", "POST", "/api/fiscal-years/close", year); code != 403 {
+		t.Errorf("manager closing: got %d, want 403", code)
+	}
+	if code, _ := call("admin", "POST", "/api/fiscal-years/close", `{"start_date":"2026-01-01","end_date":"2999-12-31"}`); code != 400 {
+		t.Errorf("closing a year not yet ended: got %d, want 400", code)
+	}
+	code, closed := call("admin", "POST", "/api/fiscal-years/close", year)
+	if code != 200 || closed["status"] != "closed" {
+		t.Fatalf("close: %d %v", code, closed)
+	}
+	id := toString(closed["id"])
+	if code, _ := call("admin", "POST", "/api/fiscal-years/close", `{"start_date":"2024-12-01","end_date":"2025-01-31"}`); code != 409 {
+		t.Errorf("overlapping year: got %d, want 409", code)
+	}
+
+	// the next period opens with the year-end figures, without baseline records
+	opening := map[string]float64{}
+	rows, err := db.Query(`SELECT kind, ref_id, cutover_date, amount, record_id IS NULL FROM opening_balances WHERE fiscal_year_id = ?`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var kind, ref, cutover string
+		var amount float64
+		var noRecord bool
+		if err := rows.Scan(&kind, &ref, &cutover, &amount, &noRecord); err != nil {
+			t.Fatal(err)
+		}
+		if cutover != "2025-01-01" || !noRecord {
+			t.Errorf("%s %s opens on %s (no record: %v)", kind, ref, cutover, noRecord)
+		}
+		opening[kind+" "+ref] = amount
+	}
+	rows.Close()
+	if len(opening) != 3 || opening["contact c-1"] != 60 || opening["stock i-1"] != 50 || opening["cash "] != 90 {
+		t.Errorf("carried forward: %v", opening)
+	}
+	sheet, err := balanceSheet(context.Background(), db, "org-1", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cash := sheet["assets"].(fiber.Map)["cash"]; cash != 90.0 {
+		t.Errorf("cash after close = %v, want 90", cash)
+	}
+
+	code, report := call("cashier", "GET", "/api/fiscal-years/"+id+"/report", "")
+	if code != 200 {
+		t.Fatalf("report: %d %v", code, report)
+	}
+	bundle, _ := report["report"].(map[string]interface{})
+	pl, _ := bundle["profit_loss"].(map[string]interface{})
+	if pl["sales"] != 100.0 || pl["purchases"] != 50.0 {
+		t.Errorf("archived P&L: %v", pl)
+	}
+	if carried, _ := report["carried_forward"].([]interface{}); len(carried) != 3 {
+		t.Errorf("report carried forward: %v", report["carried_forward"])
+	}
+	if code, _ := call("cashier", "GET", "/api/fiscal-years/nope/report", ""); code != 404 {
+		t.Errorf("unknown year: got %d, want 404", code)
+	}
+
+	// nothing in the closed year changes
+	if code, _ := call("admin", "PATCH", "/api/collections/transactions/records/t-1", `{"due_date":"2025-02-01"}`); code != 409 {
+		t.Errorf("editing a closed year's sale: got %d, want 409", code)
+	}
+	code, preview := call("admin", "POST", "/api/bulk/transactions", `{"action":"void","filter":`+jsonString(`created_at < "2025-01-01"`)+`}`)
+	if code != 200 || preview["affected"] != 0.0 {
+		t.Fatalf("bulk void preview: %d %v", code, preview)
+	}
+	for _, r := range preview["records"].([]interface{}) {
+		if skipped := r.(map[string]interface{})["skipped"]; skipped != "belongs to a closed fiscal year" {
+			t.Errorf("bulk void of a closed year's record: skipped %v", skipped)
+		}
+	}
+	for path, body := range map[string]string{
+		"/api/opening-balances/stock":    `{"cutover_date":"2024-06-01","items":[{"item_id":"i-1","quantity":3,"unit_cost":5}]}`,
+		"/api/opening-balances/contacts": `{"cutover_date":"2024-06-01","balances":[{"contact_id":"c-1","amount":10}]}`,
+		"/api/opening-balances/cash":     `{"cutover_date":"2024-06-01","amount":10}`,
+	} {
+		if code, _ := call("admin", "POST", path, body); code != 409 {
+			t.Errorf("%s in a closed year: got %d, want 409", path, code)
+		}
+	}
+	// re-posting opening cash replaces the wizard's entry, not the carried one
+	if code, _ := call("admin", "POST", "/api/opening-balances/cash", `{"cutover_date":"2025-01-01","amount":100}`); code != 200 {
+		t.Errorf("opening cash after the year: got %d", code)
+	}
+	var carriedCash int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM opening_balances WHERE kind = 'cash' AND fiscal_year_id = ?`, id).Scan(&carriedCash); err != nil || carriedCash != 1 {
+		t.Errorf("carried cash entries = %d (%v), want 1", carriedCash, err)
+	}
+
+	if _, err := db.Exec(`UPDATE fiscal_years SET report = '{"profit_loss":' WHERE id = ?`, id); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := call("cashier", "GET", "/api/fiscal-years/"+id+"/report", ""); code != 500 {
+		t.Errorf("corrupt archive: got %d, want 500", code)
+	}
+}
@@ -5,6 +5,7 @@ go 1.20
 require (
 	github.com/gofiber/fiber/v2 v2.45.0
 	github.com/google/uuid v1.3.0
+	github.com/lib/pq v1.10.9
 	modernc.org/sqlite v1.26.0
 )
 
@@ -0,0 +1,17 @@
+package main
+
+import "github.com/gofiber/fiber/v2"
+
+// currentUserID and currentOrgID identify who a request is acting for.
+// They come only from the verified token (see requireAuth), never from
+// client-supplied headers, and are empty on routes without requireAuth.
+
+func currentUserID(c *fiber.Ctx) string {
+	id, _ := c.Locals("userID").(string)
+	return id
+}
+
+func currentOrgID(c *fiber.Ctx) string {
+	id, _ := c.Locals("orgID").(string)
+	return id
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Phone photos carry EXIF metadata, including GPS position, and often
+// store the picture sideways with an orientation tag that browsers showing
+// it through <img> may ignore. Uploaded JPEGs are therefore re-encoded
+// upright, and PNGs re-encoded, which drops all metadata. Other files are
+// stored as sent.
+
+// saveUpload writes an uploaded file of orgID to path once it has passed
+// the virus scanner, cleaning up images first.
+func saveUpload(q *DB, orgID string, file *multipart.FileHeader, path string) error {
+	data, err := readUpload(file)
+	if err != nil {
+		return err
+	}
+	if err := scanUpload(q, orgID, filepath.ToSlash(filepath.Dir(path)), filepath.Base(path), data); err != nil {
+		return err
+	}
+	return os.WriteFile(path, sanitizeImage(data), 0o644)
+}
+
+// sanitizeImage returns data without metadata and, for JPEGs, with the
+// EXIF orientation applied. Data that does not decode is returned as is.
+func sanitizeImage(data []byte) []byte {
+	var out bytes.Buffer
+	switch http.DetectContentType(data) {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return data
+		}
+		if err := jpeg.Encode(&out, orient(img, jpegOrientation(data)), &jpeg.Options{Quality: 90}); err != nil {
+			return data
+		}
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return data
+		}
+		if err := png.Encode(&out, img); err != nil {
+			return data
+		}
+	default:
+		return data
+	}
+	return out.Bytes()
+}
+
+// jpegOrientation reads the EXIF orientation tag (1-8) of a JPEG, or
+// returns 1 when there is none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // image data starts, no EXIF
+			return 1
+		}
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + size
+		if size < 2 || end > len(data) {
+			return 1
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[i+4:end], []byte("Exif\x00\x00")) {
+			return exifOrientation(data[i+10 : end])
+		}
+		i = end
+	}
+	return 1
+}
+
+// exifOrientation finds the orientation tag in IFD0 of a TIFF block.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		p := ifd + 2 + e*12
+		if p+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[p:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[p+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient turns img as stored into img as meant to be seen, for an EXIF
+// orientation value.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // flipped
+				sx, sy = x, h-1-y
+			case 5: // transposed
+				sx, sy = y, x
+			case 6: // turned left, rotate right
+				sx, sy = y, h-1-x
+			case 7: // transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // turned right, rotate left
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// withExif inserts an APP1 segment holding a big-endian IFD0 with an
+// orientation tag and a GPS pointer right after the JPEG's SOI marker.
+func withExif(jpg []byte, orientation uint16) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 2,
+		0x01, 0x12, 0, 3, 0, 0, 0, 1, byte(orientation >> 8), byte(orientation), 0, 0,
+		0x88, 0x25, 0, 4, 0, 0, 0, 1, 0, 0, 0, 0,
+		0, 0, 0, 0}
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	size := len(payload) + 2
+	segment := append([]byte{0xFF, 0xE1, byte(size >> 8), byte(size)}, payload...)
+	return append(append(append([]byte{}, jpg[:2]...), segment...), jpg[2:]...)
+}
+
+func TestSanitizeImageRotatesAndStripsExif(t *testing.T) {
+	// 4x2 image, left half black, right half white
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			if x >= 2 {
+				img.Set(x, y, color.White)
+			} else {
+				img.Set(x, y, color.Black)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	photo := withExif(buf.Bytes(), 6)
+	if got := jpegOrientation(photo); got != 6 {
+		t.Fatalf("orientation = %d, want 6", got)
+	}
+
+	clean := sanitizeImage(photo)
+	if bytes.Contains(clean, []byte("Exif")) {
+		t.Error("EXIF segment survived")
+	}
+	out, err := jpeg.Decode(bytes.NewReader(clean))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := out.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Fatalf("size %dx%d after rotation, want 2x4", b.Dx(), b.Dy())
+	}
+	// rotated right, the white half ends up at the bottom
+	top, _, _, _ := out.At(0, 0).RGBA()
+	bottom, _, _, _ := out.At(0, 3).RGBA()
+	if top > 0x4000 || bottom < 0xc000 {
+		t.Errorf("top %x bottom %x, want dark over light", top, bottom)
+	}
+
+	text := []byte("not an image")
+	if got := sanitizeImage(text); !bytes.Equal(got, text) {
+		t.Error("non-image data changed")
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The inventory aging report dates the stock on hand by the receipts it
+// came from. Stock leaves first in, first out, so what is left of an item
+// is its latest receipts (stock movements with a positive quantity_change).
+// Stock no receipt accounts for, such as the quantity an item was created
+// with, is dated when the item was created.
+
+var inventoryAgeBuckets = []struct {
+	key     string
+	maxDays int
+}{
+	{"0_30", 30},
+	{"31_90", 90},
+	{"91_180", 180},
+	{"over_180", -1},
+}
+
+func inventoryAgeBucket(days int) string {
+	for _, b := range inventoryAgeBuckets {
+		if b.maxDays < 0 || days <= b.maxDays {
+			return b.key
+		}
+	}
+	return ""
+}
+
+// stockLayer is part of an item's stock on hand received on one date, at
+// one unit cost.
+type stockLayer struct {
+	quantity   int
+	receivedAt time.Time
+	unitCost   float64
+}
+
+// fifoLayers splits quantity into the receipts it is made of, newest first.
+// receipts are an item's receipts newest first; what they do not cover is
+// a layer like fallback.
+func fifoLayers(quantity int, receipts []stockLayer, fallback stockLayer) []stockLayer {
+	var layers []stockLayer
+	for _, r := range receipts {
+		if quantity <= 0 {
+			break
+		}
+		if r.quantity > quantity {
+			r.quantity = quantity
+		}
+		layers = append(layers, r)
+		quantity -= r.quantity
+	}
+	if quantity > 0 {
+		fallback.quantity = quantity
+		layers = append(layers, fallback)
+	}
+	return layers
+}
+
+// handleInventoryAging reports, per item and in total, the quantity and
+// value of stock on hand by age: 0-30, 31-90, 91-180 and over 180 days.
+// Items can be narrowed to a category.
+func handleInventoryAging(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	now := time.Now()
+
+	receipts := map[string][]stockLayer{}
+	rows, err := dbFor(c).Query(`SELECT item_id, quantity_change, COALESCE(created_at, '') FROM inventory_transactions WHERE organization_id = ? AND quantity_change > 0 ORDER BY created_at DESC`, orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		var itemID, createdAt string
+		var quantity int
+		if err := rows.Scan(&itemID, &quantity, &createdAt); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		at, err := parseTime(createdAt)
+		if err != nil {
+			at = now
+		}
+		receipts[itemID] = append(receipts[itemID], stockLayer{quantity: quantity, receivedAt: at})
+	}
+	rows.Close()
+
+	query := `SELECT id, COALESCE(name, 'Unnamed Item'), COALESCE(sku, ''), quantity, unit_price, cost_price, COALESCE(created_at, '') FROM inventory_items WHERE organization_id = ? AND quantity > 0`
+	args := []interface{}{orgID}
+	if category := c.Query("category"); category != "" {
+		query += ` AND category = ?`
+		args = append(args, category)
+	}
+	rows, err = dbFor(c).Query(query+` ORDER BY name`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+
+	newBuckets := func() fiber.Map {
+		m := fiber.Map{}
+		for _, b := range inventoryAgeBuckets {
+			m[b.key] = fiber.Map{"quantity": 0, "value": 0.0}
+		}
+		return m
+	}
+	addTo := func(m fiber.Map, key string, quantity int, value float64) {
+		b := m[key].(fiber.Map)
+		b["quantity"] = b["quantity"].(int) + quantity
+		b["value"] = b["value"].(float64) + value
+	}
+	totals := newBuckets()
+	totalValue := 0.0
+	items := []fiber.Map{}
+	for rows.Next() {
+		var id, name, sku, createdAt string
+		var quantity int
+		var unitPrice, costPrice float64
+		if err := rows.Scan(&id, &name, &sku, &quantity, &unitPrice, &costPrice, &createdAt); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		unitValue := costPrice
+		if unitValue == 0 {
+			unitValue = unitPrice
+		}
+		created, err := parseTime(createdAt)
+		if err != nil {
+			created = now
+		}
+		buckets := newBuckets()
+		oldest := now
+		unitDays := 0.0
+		for _, l := range fifoLayers(quantity, receipts[id], stockLayer{receivedAt: created}) {
+			days := int(now.Sub(l.receivedAt).Hours() / 24)
+			if days < 0 {
+				days = 0
+			}
+			value := float64(l.quantity) * unitValue
+			key := inventoryAgeBucket(days)
+			addTo(buckets, key, l.quantity, value)
+			addTo(totals, key, l.quantity, value)
+			if l.receivedAt.Before(oldest) {
+				oldest = l.receivedAt
+			}
+			unitDays += float64(l.quantity * days)
+		}
+		value := float64(quantity) * unitValue
+		totalValue += value
+		items = append(items, fiber.Map{
+			"item_id":          id,
+			"name":             name,
+			"sku":              sku,
+			"quantity":         quantity,
+			"unit_value":       unitValue,
+			"value":            value,
+			"oldest_stock_at":  oldest.Format(time.RFC3339),
+			"average_age_days": round2(unitDays / float64(quantity)),
+			"buckets":          buckets,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"as_of": now.Format(time.RFC3339), "items": items, "totals": totals, "total_value": totalValue})
+}
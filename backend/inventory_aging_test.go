@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInventoryAging(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	ago := func(days int) string { return time.Now().AddDate(0, 0, -days).Format(time.RFC3339) }
+	for _, q := range []string{
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,category,organization_id,created_at) VALUES ('i-1','Blue pen','PEN',25,5,2,'stationery','org-1','` + ago(400) + `')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,category,organization_id,created_at) VALUES ('i-2','Stapler','STP',4,50,'office','org-1','` + ago(100) + `')`,
+		`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,organization_id,created_at) VALUES
+			('m-1','i-1',10,10,20,'purchase','org-1','` + ago(200) + `'),
+			('m-2','i-1',-8,20,12,'sale','org-1','` + ago(100) + `'),
+			('m-3','i-1',10,12,22,'purchase','org-1','` + ago(60) + `'),
+			('m-4','i-1',-2,22,20,'sale','org-1','` + ago(20) + `'),
+			('m-5','i-1',5,20,25,'purchase','org-1','` + ago(10) + `')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	get := func(path string) map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return out
+	}
+
+	// 25 pens on hand are the latest receipts: 5 from 10 days ago, 10 from
+	// 60 and 10 of the 10 from 200 days ago
+	out := get("/api/reports/inventory-aging")
+	totals := out["totals"].(map[string]interface{})
+	want := map[string][2]float64{"0_30": {5, 10}, "31_90": {10, 20}, "91_180": {4, 200}, "over_180": {10, 20}}
+	for key, w := range want {
+		b := totals[key].(map[string]interface{})
+		if b["quantity"] != w[0] || b["value"] != w[1] {
+			t.Errorf("%s: %v units worth %v, want %v worth %v", key, b["quantity"], b["value"], w[0], w[1])
+		}
+	}
+	if out["total_value"] != float64(250) {
+		t.Errorf("total value %v, want 250", out["total_value"])
+	}
+	pen := out["items"].([]interface{})[0].(map[string]interface{})
+	if pen["average_age_days"] != float64(106) {
+		t.Errorf("average pen age %v, want 106", pen["average_age_days"])
+	}
+
+	out = get("/api/reports/inventory-aging?category=office")
+	if items := out["items"].([]interface{}); len(items) != 1 || items[0].(map[string]interface{})["sku"] != "STP" {
+		t.Errorf("office items %v", items)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Inventory valuation costs the stock owned at a date from what receipts
+// cost (inventory_transactions.unit_cost, recorded for purchases and
+// opening stock). With fifo the units left are the latest receipts at their
+// own cost; with weighted_average every receipt moves the item's average
+// cost and units leave at that average. Stock no costed receipt accounts
+// for is valued at the item's cost price (else its sale price), as in the
+// stock valuation report.
+
+// stockMovement is an inventory_transactions row for valuation; unitCost
+// is invalid for movements that are not costed receipts.
+type stockMovement struct {
+	change     int
+	unitCost   sql.NullFloat64
+	receivedAt time.Time
+}
+
+// stockMovements returns orgID's stock movements up to asOf per item,
+// oldest first.
+func stockMovements(q *DB, orgID string, asOf time.Time) (map[string][]stockMovement, error) {
+	rows, err := q.Query(`SELECT item_id, quantity_change, unit_cost, COALESCE(created_at, '') FROM inventory_transactions WHERE organization_id = ? AND created_at <= ? ORDER BY created_at, `+q.dialect.insertionOrder(), orgID, asOf.Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string][]stockMovement{}
+	for rows.Next() {
+		var itemID, createdAt string
+		var m stockMovement
+		if err := rows.Scan(&itemID, &m.change, &m.unitCost, &createdAt); err != nil {
+			return nil, err
+		}
+		if m.receivedAt, err = parseTime(createdAt); err != nil {
+			m.receivedAt = asOf
+		}
+		out[itemID] = append(out[itemID], m)
+	}
+	return out, rows.Err()
+}
+
+// weightedAverageCost replays an item's movements from its quantity before
+// the first one, valued at fallback, and returns the average unit cost
+// after the last.
+func weightedAverageCost(quantity int, movements []stockMovement, fallback float64) float64 {
+	for _, m := range movements {
+		quantity -= m.change
+	}
+	average := fallback
+	for _, m := range movements {
+		if m.change > 0 && m.unitCost.Valid {
+			held := quantity
+			if held < 0 {
+				held = 0
+			}
+			average = (float64(held)*average + float64(m.change)*m.unitCost.Float64) / float64(held+m.change)
+		}
+		quantity += m.change
+	}
+	return average
+}
+
+// fifoCostLayers returns the costed layers making up quantity, newest first.
+func fifoCostLayers(quantity int, movements []stockMovement, fallback float64) []stockLayer {
+	var receipts []stockLayer
+	for i := len(movements) - 1; i >= 0; i-- {
+		m := movements[i]
+		if m.change <= 0 {
+			continue
+		}
+		cost := fallback
+		if m.unitCost.Valid {
+			cost = m.unitCost.Float64
+		}
+		receipts = append(receipts, stockLayer{quantity: m.change, receivedAt: m.receivedAt, unitCost: cost})
+	}
+	return fifoLayers(quantity, receipts, stockLayer{unitCost: fallback})
+}
+
+// handleInventoryValuation values each item's owned stock at as_of
+// (default now) by method, fifo (default) or weighted_average.
+func handleInventoryValuation(c *fiber.Ctx) error {
+	method := c.Query("method", "fifo")
+	if method != "fifo" && method != "weighted_average" {
+		return c.Status(400).JSON(fiber.Map{"error": "method must be fifo or weighted_average"})
+	}
+	asOf := time.Now()
+	if v := c.Query("as_of"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid as_of"})
+		}
+		asOf = t
+	}
+	orgID := currentOrgID(c)
+	stock, _, err := stockValuation(c.UserContext(), dbFor(c), orgID, asOf)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	movements, err := stockMovements(dbFor(c), orgID, asOf)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	items := []fiber.Map{}
+	total := 0.0
+	for _, s := range stock {
+		id := s["item_id"].(string)
+		owned := s["owned_quantity"].(int)
+		fallback := s["unit_value"].(float64)
+		item := fiber.Map{"item_id": id, "name": s["name"], "sku": s["sku"], "quantity": owned}
+		value := 0.0
+		if method == "fifo" {
+			layers := []fiber.Map{}
+			if owned > 0 {
+				for _, l := range fifoCostLayers(owned, movements[id], fallback) {
+					value += float64(l.quantity) * l.unitCost
+					layer := fiber.Map{"quantity": l.quantity, "unit_cost": l.unitCost, "received_at": nil}
+					if !l.receivedAt.IsZero() {
+						layer["received_at"] = l.receivedAt.Format(time.RFC3339)
+					}
+					layers = append(layers, layer)
+				}
+			}
+			item["layers"] = layers
+		} else {
+			value = float64(owned) * weightedAverageCost(s["quantity"].(int), movements[id], fallback)
+		}
+		value = round2(value)
+		item["value"] = value
+		item["unit_cost"] = 0.0
+		if owned > 0 {
+			item["unit_cost"] = round2(value / float64(owned))
+		}
+		total += value
+		items = append(items, item)
+	}
+	return c.JSON(fiber.Map{"as_of": asOf.Format(time.RFC3339), "method": method, "items": items, "total_value": round2(total)})
+}
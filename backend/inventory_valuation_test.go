@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInventoryValuationMethods(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Karim Traders','018','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id,created_at) VALUES ('i-1','Blue pen','PEN',8,20,16,'org-1','2023-12-01T00:00:00Z')`,
+		`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,unit_cost,organization_id,created_at) VALUES
+			('m-1','i-1',10,0,10,'outflow',10,'org-1','2024-01-01T00:00:00Z'),
+			('m-2','i-1',10,10,20,'outflow',16,'org-1','2024-02-01T00:00:00Z'),
+			('m-3','i-1',-12,20,8,'inflow',NULL,'org-1','2024-03-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	// 8 pens left: under FIFO all from the 16 receipt, on average 13 each
+	cases := []struct {
+		query string
+		value float64
+	}{
+		{"method=fifo", 128},
+		{"method=weighted_average", 104},
+		{"method=fifo&as_of=2024-01-15T00:00:00Z", 100},
+		{"method=weighted_average&as_of=2024-02-15T00:00:00Z", 260},
+	}
+	for _, tc := range cases {
+		_, out := call("GET", "/api/reports/inventory-valuation?"+tc.query, "")
+		if out["total_value"] != tc.value {
+			t.Errorf("%s: value %v, want %v", tc.query, out["total_value"], tc.value)
+		}
+	}
+	if code, _ := call("GET", "/api/reports/inventory-valuation?method=lifo", ""); code != 400 {
+		t.Errorf("method=lifo: got %d, want 400", code)
+	}
+
+	// a purchase records what the units cost
+	code, out := call("POST", "/api/collections/transactions/records", `{"type":"outflow","amount":50,"paid_amount":50,"due_amount":0,"contact_id":"s-1","items":[{"item_id":"i-1","quantity":2,"unit_price":25}]}`)
+	if code != 200 {
+		t.Fatalf("purchase: %d %v", code, out)
+	}
+	var cost float64
+	if err := db.QueryRow(`SELECT unit_cost FROM inventory_transactions WHERE item_id = 'i-1' AND quantity_change = 2`).Scan(&cost); err != nil || cost != 25 {
+		t.Errorf("purchase movement cost %v (%v), want 25", cost, err)
+	}
+	_, out = call("GET", "/api/reports/inventory-valuation", "")
+	if out["total_value"] != float64(178) {
+		t.Errorf("value after purchase %v, want 178", out["total_value"])
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Admins add staff by invitation rather than by creating accounts and
+// handing out passwords. An invite names the role the new member gets and
+// is sent by email, SMS or WhatsApp as a link with a single-use token; the
+// person opening it picks their own password (or, with an existing
+// account, confirms theirs) and joins the organization.
+//
+// Configuration:
+//
+//	APP_URL             where the app is served; invite links are
+//	                    APP_URL/invite/<token> (default: this server)
+//	INVITE_EXPIRY_DAYS  how long an invite can be accepted (default 7)
+
+func inviteTTL() time.Duration { return envDuration("INVITE_EXPIRY_DAYS", 7, 24*time.Hour) }
+
+func registerInviteRoutes(app *fiber.App) {
+	r := app.Group("/api/organizations/:id/invites", requireAuth, requireRole("admin"), requireCurrentOrganization)
+	r.Get("/", handleListInvites)
+	r.Post("/", handleCreateInvite)
+	r.Delete("/:inviteId", handleRevokeInvite)
+
+	// the token is the credential here
+	app.Get("/api/invites/:token", handleGetInvite)
+	app.Post("/api/invites/:token/accept", handleAcceptInvite)
+}
+
+// requireCurrentOrganization admits requests for /api/organizations/:id
+// only when :id is the organization the token is for.
+func requireCurrentOrganization(c *fiber.Ctx) error {
+	if c.Params("id") != currentOrgID(c) {
+		return c.Status(403).JSON(fiber.Map{"error": "switch to this organization first"})
+	}
+	return c.Next()
+}
+
+// appURL is where the app is served, for links sent out of it.
+func appURL(c *fiber.Ctx) string {
+	base := strings.TrimRight(os.Getenv("APP_URL"), "/")
+	if base == "" {
+		base = c.BaseURL()
+	}
+	return base
+}
+
+func inviteLink(c *fiber.Ctx, token string) string {
+	return appURL(c) + "/invite/" + token
+}
+
+func handleListInvites(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT id,email,phone,channel,role,invited_by,created_at,expires_at,sent_at,delivery_error,accepted_at,accepted_by,revoked_at FROM invites WHERE organization_id = ? ORDER BY created_at DESC`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleCreateInvite creates an invite for email or phone and sends the
+// link over channel (email when an email is given, else sms). The link is
+// returned too, so it can be passed on by hand when sending fails.
+func handleCreateInvite(c *fiber.Ctx) error {
+	var req struct {
+		Email   string `json:"email"`
+		Phone   string `json:"phone"`
+		Channel string `json:"channel"`
+		Role    string `json:"role"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	req.Email = strings.ToLower(strings.TrimSpace(req.Email))
+	req.Phone = strings.TrimSpace(req.Phone)
+	if !isValidRole(req.Role) {
+		return c.Status(400).JSON(fiber.Map{"error": "role must be one of " + strings.Join(validRoles, ", ")})
+	}
+	if req.Email != "" && !strings.Contains(req.Email, "@") {
+		return c.Status(400).JSON(fiber.Map{"error": "valid email required"})
+	}
+	if req.Channel == "" {
+		req.Channel = "sms"
+		if req.Email != "" {
+			req.Channel = "email"
+		}
+	}
+	if !isMessageChannel(req.Channel) {
+		return c.Status(400).JSON(fiber.Map{"error": "channel must be one of " + strings.Join(messageChannels, ", ")})
+	}
+	to := req.Phone
+	if req.Channel == "email" {
+		to = req.Email
+	}
+	if to == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "an email or phone to send the invite to is required"})
+	}
+	orgID := currentOrgID(c)
+	if req.Email != "" {
+		var n int
+		if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM organization_members m JOIN users u ON u.id = m.user_id WHERE m.organization_id = ? AND u.email = ?`, orgID, req.Email).Scan(&n); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if n > 0 {
+			return c.Status(409).JSON(fiber.Map{"error": "already a member"})
+		}
+	}
+
+	profile, err := organizationProfile(dbFor(c), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	orgName, _ := profile["name"].(string)
+
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	id := genID()
+	now := time.Now()
+	expires := now.Add(inviteTTL())
+	if _, err := dbFor(c).Exec(`INSERT INTO invites (id,organization_id,token_hash,email,phone,channel,role,invited_by,created_at,expires_at) VALUES (?,?,?,NULLIF(?, ''),NULLIF(?, ''),?,?,?,?,?)`,
+		id, orgID, hashRefreshToken(token), req.Email, req.Phone, req.Channel, req.Role, currentUserID(c), now.Format(time.RFC3339), expires.Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	link := inviteLink(c, token)
+	body := fmt.Sprintf("You have been invited to join %s as %s. Accept by %s: %s", orgName, req.Role, expires.Format("2 Jan 2006"), link)
+	out := fiber.Map{"id": id, "link": link, "channel": req.Channel, "role": req.Role, "expires_at": expires.Format(time.RFC3339), "sent": true}
+	if _, err := sendMessage(req.Channel, to, "Invitation to join "+orgName, body); err != nil {
+		if _, dbErr := dbFor(c).Exec(`UPDATE invites SET delivery_error = ? WHERE id = ?`, err.Error(), id); dbErr != nil {
+			log.Printf("invites: %s: %v", id, dbErr)
+		}
+		out["sent"] = false
+		out["delivery_error"] = err.Error()
+	} else {
+		if _, err := dbFor(c).Exec(`UPDATE invites SET sent_at = ? WHERE id = ?`, time.Now().Format(time.RFC3339), id); err != nil {
+			log.Printf("invites: %s: %v", id, err)
+		}
+	}
+	return c.Status(201).JSON(out)
+}
+
+func handleRevokeInvite(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`UPDATE invites SET revoked_at = ? WHERE id = ? AND organization_id = ? AND accepted_at IS NULL AND revoked_at IS NULL`,
+		time.Now().Format(time.RFC3339), c.Params("inviteId"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "no pending invite"})
+	}
+	return c.SendStatus(204)
+}
+
+type pendingInvite struct {
+	id, orgID, email, role string
+}
+
+// findInvite looks up the invite token is for; it fails with a status
+// and message fit for the response when the invite cannot be used.
+func findInvite(q *DB, token string) (pendingInvite, int, string) {
+	var inv pendingInvite
+	var expiresAt string
+	var acceptedAt, revokedAt sql.NullString
+	err := q.QueryRow(`SELECT id, organization_id, COALESCE(email, ''), role, expires_at, accepted_at, revoked_at FROM invites WHERE token_hash = ?`, hashRefreshToken(token)).
+		Scan(&inv.id, &inv.orgID, &inv.email, &inv.role, &expiresAt, &acceptedAt, &revokedAt)
+	if err == sql.ErrNoRows {
+		return inv, 404, "invite not found"
+	}
+	if err != nil {
+		return inv, 500, err.Error()
+	}
+	if acceptedAt.Valid {
+		return inv, 410, "invite already accepted"
+	}
+	if exp, _ := time.Parse(time.RFC3339, expiresAt); revokedAt.Valid || time.Now().After(exp) {
+		return inv, 410, "invite expired"
+	}
+	return inv, 0, ""
+}
+
+// handleGetInvite shows what an invite is for, so the accept page can
+// tell the person where they are joining and whether to sign in.
+func handleGetInvite(c *fiber.Ctx) error {
+	inv, status, msg := findInvite(dbFor(c), c.Params("token"))
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	profile, err := organizationProfile(dbFor(c), inv.orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	out := fiber.Map{"organization_name": profile["name"], "role": inv.role, "email": nil, "has_account": false}
+	if inv.email != "" {
+		var n int
+		if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM users WHERE email = ?`, inv.email).Scan(&n); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		out["email"], out["has_account"] = inv.email, n > 0
+	}
+	return c.JSON(out)
+}
+
+// handleAcceptInvite joins the invite's organization. Without an account
+// for the email one is created with the given name and password, and the
+// organization becomes its home; with one, the password must match it.
+// Either way the response is a token pair for the organization.
+func handleAcceptInvite(c *fiber.Ctx) error {
+	var req credentials
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	inv, status, msg := findInvite(dbFor(c), c.Params("token"))
+	if status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	email := inv.email
+	if email == "" {
+		email = strings.ToLower(strings.TrimSpace(req.Email))
+	}
+	if email == "" || !strings.Contains(email, "@") {
+		return c.Status(400).JSON(fiber.Map{"error": "valid email required"})
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	now := time.Now().Format(time.RFC3339)
+	var userID, hash, name string
+	err = tx.QueryRow(`SELECT id, password_hash, COALESCE(name,'') FROM users WHERE email = ?`, email).Scan(&userID, &hash, &name)
+	status = 200
+	switch {
+	case err == sql.ErrNoRows:
+		if len(req.Password) < 8 {
+			return c.Status(400).JSON(fiber.Map{"error": "password must be at least 8 characters"})
+		}
+		if hash, err = hashPassword(req.Password); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		userID, name, status = genID(), req.Name, 201
+		if _, err := tx.Exec(`INSERT INTO users (id,email,password_hash,name,organization_id,role,created_at) VALUES (?,?,?,?,?,?,?)`,
+			userID, email, hash, name, inv.orgID, inv.role, now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	case !checkPassword(hash, req.Password):
+		return c.Status(401).JSON(fiber.Map{"error": "invalid email or password"})
+	default:
+		var n int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM organization_members WHERE user_id = ? AND organization_id = ?`, userID, inv.orgID).Scan(&n); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if n > 0 {
+			return c.Status(409).JSON(fiber.Map{"error": "already a member"})
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO organization_members (user_id,organization_id,role,created_at) VALUES (?,?,?,?)`, userID, inv.orgID, inv.role, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	// claiming the invite last makes a second accept of the same link fail
+	res, err := tx.Exec(`UPDATE invites SET accepted_at = ?, accepted_by = ? WHERE id = ? AND accepted_at IS NULL AND revoked_at IS NULL`, now, userID, inv.id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(410).JSON(fiber.Map{"error": "invite already used"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if status, msg := checkSessionAccess(c, userID, inv.orgID, inv.role); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	tokens, err := issueTokens(dbFor(c), userID, inv.orgID, inv.role)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	tokens["user"] = fiber.Map{"id": userID, "email": email, "name": name, "organization_id": inv.orgID, "role": inv.role}
+	return c.Status(status).JSON(tokens)
+}
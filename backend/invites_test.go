@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInvites(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if jwtSecret == nil {
+		jwtSecret = []byte("test-secret")
+	}
+	var smsTo, smsBody string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		smsTo, smsBody = msg["to"], msg["message"]
+		_, _ = w.Write([]byte(`{"id":"sms-1"}`))
+	}))
+	defer gateway.Close()
+	t.Setenv("SMS_GATEWAY_URL", gateway.URL)
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("APP_URL", "https://shop.example.com/")
+
+	app := newApp()
+	call := func(method, path, token, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	tokenOf := func(link interface{}) string {
+		s, _ := link.(string)
+		if !strings.HasPrefix(s, "https://shop.example.com/invite/") {
+			t.Fatalf("invite link = %q", s)
+		}
+		return strings.TrimPrefix(s, "https://shop.example.com/invite/")
+	}
+
+	_, owner := call("POST", "/api/auth/register", "", `{"email":"owner@example.com","password":"password1","name":"Owner","organization_name":"Corner Shop"}`)
+	ownerToken := owner["token"].(string)
+	orgID := owner["user"].(map[string]interface{})["organization_id"].(string)
+	invites := "/api/organizations/" + orgID + "/invites"
+
+	if code, _ := call("POST", "/api/organizations/other/invites", ownerToken, `{"email":"x@example.com","role":"cashier"}`); code != 403 {
+		t.Errorf("invite into another organization: got %d, want 403", code)
+	}
+	if code, _ := call("POST", invites, ownerToken, `{"email":"x@example.com","role":"boss"}`); code != 400 {
+		t.Errorf("bad role: got %d, want 400", code)
+	}
+	if code, _ := call("POST", invites, ownerToken, `{"email":"owner@example.com","role":"cashier"}`); code != 409 {
+		t.Errorf("inviting a member: got %d, want 409", code)
+	}
+
+	// email is not configured: the invite is kept and its link returned
+	code, inv := call("POST", invites, ownerToken, `{"email":"Cashier@Example.com","role":"cashier"}`)
+	if code != 201 || inv["sent"] != false || inv["delivery_error"] == nil {
+		t.Fatalf("email invite: got %d %v", code, inv)
+	}
+	token := tokenOf(inv["link"])
+	if _, info := call("GET", "/api/invites/"+token, "", ""); info["organization_name"] != "Corner Shop" || info["role"] != "cashier" || info["email"] != "cashier@example.com" || info["has_account"] != false {
+		t.Errorf("invite info = %v", info)
+	}
+	if code, _ := call("POST", "/api/invites/"+token+"/accept", "", `{"name":"Cashier","password":"short"}`); code != 400 {
+		t.Errorf("short password: got %d, want 400", code)
+	}
+	code, joined := call("POST", "/api/invites/"+token+"/accept", "", `{"name":"Cashier","password":"password1"}`)
+	if code != 201 {
+		t.Fatalf("accept: got %d %v", code, joined)
+	}
+	if _, me := call("GET", "/api/auth/me", joined["token"].(string), ""); me["organization_id"] != orgID || me["role"] != "cashier" || me["email"] != "cashier@example.com" {
+		t.Errorf("new member = %v", me)
+	}
+	if code, _ := call("POST", "/api/invites/"+token+"/accept", "", `{"name":"Again","password":"password1"}`); code != 410 {
+		t.Errorf("accept twice: got %d, want 410", code)
+	}
+	if _, login := call("POST", "/api/auth/login", "", `{"email":"cashier@example.com","password":"password1"}`); login["token"] == nil {
+		t.Errorf("new member cannot log in: %v", login)
+	}
+
+	// an existing account joins by SMS invite with its own password
+	call("POST", "/api/auth/register", "", `{"email":"acct@example.com","password":"password1","name":"Accountant","organization_name":"Books"}`)
+	code, inv = call("POST", invites, ownerToken, `{"phone":"01700000000","role":"manager"}`)
+	if code != 201 || inv["sent"] != true || inv["channel"] != "sms" {
+		t.Fatalf("sms invite: got %d %v", code, inv)
+	}
+	token = tokenOf(inv["link"])
+	if smsTo != "01700000000" || !strings.Contains(smsBody, inv["link"].(string)) || !strings.Contains(smsBody, "Corner Shop") {
+		t.Errorf("sms to %q: %q", smsTo, smsBody)
+	}
+	if code, _ := call("POST", "/api/invites/"+token+"/accept", "", `{"password":"password1"}`); code != 400 {
+		t.Errorf("accept without email: got %d, want 400", code)
+	}
+	if code, _ := call("POST", "/api/invites/"+token+"/accept", "", `{"email":"acct@example.com","password":"wrong-password"}`); code != 401 {
+		t.Errorf("wrong password: got %d, want 401", code)
+	}
+	code, joined = call("POST", "/api/invites/"+token+"/accept", "", `{"email":"acct@example.com","password":"password1"}`)
+	if code != 200 || joined["user"].(map[string]interface{})["role"] != "manager" {
+		t.Fatalf("accept with account: got %d %v", code, joined)
+	}
+	if _, out := call("GET", "/api/auth/organizations", joined["token"].(string), ""); len(out["items"].([]interface{})) != 2 {
+		t.Errorf("accountant's organizations = %v, want Books and Corner Shop", out["items"])
+	}
+
+	code, inv = call("POST", invites, ownerToken, `{"email":"late@example.com","role":"cashier"}`)
+	if code != 201 {
+		t.Fatalf("invite: got %d", code)
+	}
+	if code, _ := call("DELETE", invites+"/"+inv["id"].(string), ownerToken, ""); code != 204 {
+		t.Fatalf("revoke: got %d", code)
+	}
+	if code, _ := call("GET", "/api/invites/"+tokenOf(inv["link"]), "", ""); code != 410 {
+		t.Errorf("revoked invite: got %d, want 410", code)
+	}
+	if code, _ := call("GET", "/api/invites/bogus", "", ""); code != 404 {
+		t.Errorf("unknown invite: got %d, want 404", code)
+	}
+	if _, out := call("GET", invites, ownerToken, ""); len(out["items"].([]interface{})) != 3 {
+		t.Errorf("invites = %v, want 3", out["items"])
+	}
+}
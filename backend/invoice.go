@@ -0,0 +1,294 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GET /api/transactions/:id/invoice.pdf renders a printable invoice (for
+// sales) or bill (for purchases) with the business details from the
+// organization profile, the contact, the line items and what was paid.
+
+func registerInvoiceRoutes(app *fiber.App) {
+	app.Get("/api/transactions/:id/invoice.pdf", requireAuth, handleInvoicePDF)
+}
+
+type invoiceLine struct {
+	name      string
+	sku       string
+	quantity  int
+	unit      string
+	unitPrice float64
+	total     float64
+}
+
+type invoiceData struct {
+	org       map[string]interface{}
+	id        string
+	typ       string
+	createdAt string
+	voidedAt  string
+	amount    float64
+	tax       float64
+	paid      float64
+	due       float64
+	contact   struct{ name, phone, email string }
+	lines     []invoiceLine
+	payments  []TransactionPayment
+
+	// a transaction in a foreign currency prints its total in it too
+	currency       string
+	currencyAmount float64
+	exchangeRate   float64
+
+	// ref is the document number: a sale's receipt_number, or a quote's
+	ref string
+
+	// quotes (quotations.go) share the layout, with their own expiry and
+	// without payment details
+	quote      bool
+	validUntil string
+}
+
+func loadInvoice(q *DB, orgID, id string) (*invoiceData, error) {
+	inv := &invoiceData{id: id}
+	var contactID, createdAt, voidedAt, receiptNumber sql.NullString
+	var amount, paid, due money
+	err := q.QueryRow(`SELECT type, amount, tax_amount, paid_amount, due_amount, contact_id, created_at, voided_at, receipt_number, COALESCE(currency, ''), COALESCE(currency_amount, 0), COALESCE(exchange_rate, 0) FROM transactions WHERE id = ? AND organization_id = ?`, id, orgID).
+		Scan(&inv.typ, &amount, &inv.tax, &paid, &due, &contactID, &createdAt, &voidedAt, &receiptNumber, &inv.currency, &inv.currencyAmount, &inv.exchangeRate)
+	if err != nil {
+		return nil, err
+	}
+	inv.amount, inv.paid, inv.due = amount.float(), paid.float(), due.float()
+	inv.createdAt, inv.voidedAt, inv.ref = createdAt.String, voidedAt.String, receiptNumber.String
+	if contactID.Valid {
+		err := q.QueryRow(`SELECT name, phone, COALESCE(email, '') FROM contacts WHERE id = ?`, contactID.String).Scan(&inv.contact.name, &inv.contact.phone, &inv.contact.email)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+	// lines entered in another unit are printed as entered
+	rows, err := q.Query(`SELECT COALESCE(i.name, 'Unnamed Item'), COALESCE(i.sku, ''), COALESCE(ti.unit_quantity, ti.quantity), COALESCE(ti.unit_quantity, 0), ti.unit_price, ti.total_price, COALESCE(ti.unit, '')
+		FROM transaction_items ti LEFT JOIN inventory_items i ON i.id = ti.item_id
+		WHERE ti.transaction_id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l invoiceLine
+		var unitQuantity int
+		var unitPrice, total money
+		if err := rows.Scan(&l.name, &l.sku, &l.quantity, &unitQuantity, &unitPrice, &total, &l.unit); err != nil {
+			return nil, err
+		}
+		l.unitPrice, l.total = unitPrice.float(), total.float()
+		if unitQuantity > 0 {
+			l.unitPrice = l.total / float64(unitQuantity)
+		}
+		inv.lines = append(inv.lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if inv.payments, err = Transactions(q).Payments(q.context(), id); err != nil {
+		return nil, err
+	}
+	if inv.org, err = organizationProfile(q, orgID); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+func handleInvoicePDF(c *fiber.Ctx) error {
+	inv, err := loadInvoice(dbFor(c), currentOrgID(c), c.Params("id"))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set("Content-Type", "application/pdf")
+	c.Set("Content-Disposition", fmt.Sprintf(`inline; filename="invoice-%s.pdf"`, inv.number()))
+	return c.Send(renderInvoice(inv))
+}
+
+// number is the short reference printed on the invoice.
+func (inv *invoiceData) number() string {
+	if inv.ref != "" {
+		return inv.ref
+	}
+	if len(inv.id) > 8 {
+		return strings.ToUpper(inv.id[:8])
+	}
+	return strings.ToUpper(inv.id)
+}
+
+func invoiceMoney(f float64) string {
+	return moneyOf(f).String()
+}
+
+func renderInvoice(inv *invoiceData) []byte {
+	const left, right, bottom = 50.0, pdfPageWidth - 50, pdfPageHeight - 60
+	d := newPDF()
+	y := 60.0
+
+	d.text(left, y, 18, true, toString(inv.org["name"]))
+	title := "INVOICE"
+	if inv.quote {
+		title = "QUOTATION"
+	} else if inv.typ == "outflow" {
+		title = "BILL"
+	}
+	d.textRight(right, y, 18, true, title)
+	y += 16
+	var details []string
+	for _, line := range strings.Split(toString(inv.org["address"]), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			details = append(details, line)
+		}
+	}
+	for _, f := range []struct{ label, key string }{{"Phone", "phone"}, {"Email", "email"}, {"Tax reg. no.", "tax_registration_no"}, {"VAT reg. no.", "vat_registration_no"}} {
+		if v := toString(inv.org[f.key]); v != "" {
+			details = append(details, f.label+": "+v)
+		}
+	}
+	date := inv.createdAt
+	if t, err := parseTime(date); err == nil {
+		date = t.Format("02 Jan 2006")
+	}
+	meta := []string{"No. " + inv.number(), "Date: " + date}
+	if inv.voidedAt != "" {
+		meta = append(meta, "VOID")
+	}
+	if t, err := parseTime(inv.validUntil); err == nil {
+		meta = append(meta, "Valid until: "+t.Format("02 Jan 2006"))
+	}
+	for i := 0; i < len(details) || i < len(meta); i++ {
+		if i < len(details) {
+			d.text(left, y, 9, false, details[i])
+		}
+		if i < len(meta) {
+			d.textRight(right, y, 10, meta[i] == "VOID", meta[i])
+		}
+		y += 12
+	}
+
+	y += 14
+	billTo := "Bill to"
+	if inv.quote {
+		billTo = "Quote for"
+	} else if inv.typ == "outflow" {
+		billTo = "Supplier"
+	}
+	d.text(left, y, 9, true, billTo)
+	y += 13
+	if inv.contact.name != "" {
+		d.text(left, y, 10, false, inv.contact.name)
+		y += 12
+	}
+	if inv.contact.phone != "" {
+		d.text(left, y, 10, false, inv.contact.phone)
+		y += 12
+	}
+
+	// item, sku, quantity, unit price and total columns; the last three
+	// are right aligned at these x positions
+	colSKU, colQty, colPrice, colTotal := 300.0, 390.0, 470.0, right
+	header := func() {
+		y += 16
+		d.text(left, y, 9, true, "Item")
+		d.text(colSKU, y, 9, true, "SKU")
+		d.textRight(colQty, y, 9, true, "Qty")
+		d.textRight(colPrice, y, 9, true, "Unit price")
+		d.textRight(colTotal, y, 9, true, "Total")
+		y += 5
+		d.line(left, y, right, y)
+		y += 13
+	}
+	header()
+	subtotal := 0.0
+	for _, l := range inv.lines {
+		if y > bottom {
+			d.addPage()
+			y = 50
+			header()
+		}
+		d.text(left, y, 9, false, pdfFit(l.name, 9, colSKU-left-10))
+		d.text(colSKU, y, 9, false, pdfFit(l.sku, 9, colQty-colSKU-40))
+		qty := strconv.Itoa(l.quantity)
+		if l.unit != "" {
+			qty += " " + l.unit
+		}
+		d.textRight(colQty, y, 9, false, qty)
+		d.textRight(colPrice, y, 9, false, invoiceMoney(l.unitPrice))
+		d.textRight(colTotal, y, 9, false, invoiceMoney(l.total))
+		subtotal += l.total
+		y += 14
+	}
+	d.line(left, y-8, right, y-8)
+
+	if y+110 > bottom {
+		d.addPage()
+		y = 50
+	}
+	y += 8
+	totals := [][2]string{}
+	if len(inv.lines) > 0 && invoiceMoney(subtotal) != invoiceMoney(inv.amount) {
+		totals = append(totals, [2]string{"Subtotal", invoiceMoney(subtotal)})
+	}
+	// tax on top of the lines, or already in them (see tax.go)
+	if inv.tax > 0 && invoiceMoney(subtotal) != invoiceMoney(inv.amount) {
+		totals = append(totals, [2]string{"Tax", invoiceMoney(inv.tax)})
+	} else if inv.tax > 0 {
+		totals = append(totals, [2]string{"Tax included", invoiceMoney(inv.tax)})
+	}
+	currency := baseCurrency()
+	totals = append(totals, [2]string{"Total (" + currency + ")", invoiceMoney(inv.amount)})
+	if inv.currency != "" {
+		totals = append(totals, [2]string{fmt.Sprintf("Total (%s at %g)", inv.currency, inv.exchangeRate), invoiceMoney(inv.currencyAmount)})
+	}
+	if !inv.quote {
+		totals = append(totals, [2]string{"Paid", invoiceMoney(inv.paid)}, [2]string{"Due", invoiceMoney(inv.due)})
+	}
+	for _, t := range totals {
+		bold := t[0] == "Due" || strings.HasPrefix(t[0], "Total")
+		d.textRight(colPrice, y, 10, bold, t[0])
+		d.textRight(colTotal, y, 10, bold, t[1])
+		y += 14
+	}
+	if len(inv.payments) > 0 {
+		y += 6
+		d.text(left, y, 9, true, "Payments")
+		y += 12
+		for _, p := range inv.payments {
+			label := p.Method
+			if p.Reference != "" {
+				label += " (" + p.Reference + ")"
+			}
+			d.text(left, y, 9, false, label)
+			d.textRight(colSKU+80, y, 9, false, p.Amount.String())
+			y += 12
+		}
+	}
+
+	for _, block := range []string{toString(inv.org["invoice_terms"]), toString(inv.org["invoice_footer"])} {
+		if strings.TrimSpace(block) == "" {
+			continue
+		}
+		y += 12
+		for _, line := range pdfWrap(block, 8, right-left) {
+			if y > bottom {
+				d.addPage()
+				y = 50
+			}
+			d.text(left, y, 8, false, line)
+			y += 10
+		}
+	}
+	return d.bytes()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A transaction's invoice (invoice.go) can be emailed to its contact as a
+// PDF attachment over the SMTP server configured for messaging.go. The
+// request may name another address and add a message above the standard
+// text; every attempt is recorded with whether the mail server took it.
+//
+//	POST /api/transactions/:id/send         {"email": "...", "message": "..."}, both optional
+//	GET  /api/transactions/:id/deliveries   the attempts, newest first
+
+func registerInvoiceEmailRoutes(app *fiber.App) {
+	app.Post("/api/transactions/:id/send", requireAuth, requireRole("admin", "manager", "cashier"), handleSendInvoice)
+	app.Get("/api/transactions/:id/deliveries", requireAuth, handleListInvoiceDeliveries)
+}
+
+const invoiceDeliverySQL = `SELECT id, transaction_id, email, status, COALESCE(error, '') AS error, COALESCE(provider_message_id, '') AS provider_message_id, COALESCE(sent_by, '') AS sent_by, created_at FROM invoice_deliveries`
+
+func handleSendInvoice(c *fiber.Ctx) error {
+	orgID, id := currentOrgID(c), c.Params("id")
+	var req struct {
+		Email   string `json:"email"`
+		Message string `json:"message"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	inv, err := loadInvoice(dbFor(c), orgID, id)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if inv.voidedAt != "" {
+		return c.Status(409).JSON(fiber.Map{"error": "the transaction is void"})
+	}
+	to := strings.TrimSpace(req.Email)
+	if to == "" {
+		to = inv.contact.email
+	}
+	if to == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "the contact has no email address; send one as email"})
+	}
+	if !strings.Contains(to, "@") {
+		return c.Status(400).JSON(fiber.Map{"error": "email must be an email address"})
+	}
+
+	subject, body := invoiceEmail(orgID, inv, strings.TrimSpace(req.Message))
+	attachment := emailAttachment{name: "invoice-" + inv.number() + ".pdf", contentType: "application/pdf", data: renderInvoice(inv)}
+	providerID, sendErr := sendEmail(to, subject, body, attachment)
+	status, problem := "sent", ""
+	if sendErr != nil {
+		status, problem = "failed", sendErr.Error()
+	}
+	delivery := fiber.Map{"id": genID(), "transaction_id": id, "email": to, "status": status, "error": problem, "provider_message_id": providerID, "sent_by": currentUserID(c), "created_at": time.Now().Format(time.RFC3339)}
+	if _, err := dbFor(c).Exec(`INSERT INTO invoice_deliveries (id,organization_id,transaction_id,email,status,error,provider_message_id,sent_by,created_at) VALUES (?,?,?,?,?,NULLIF(?, ''),NULLIF(?, ''),?,?)`,
+		delivery["id"], orgID, id, to, status, problem, providerID, delivery["sent_by"], delivery["created_at"]); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if sendErr != nil {
+		return c.Status(502).JSON(fiber.Map{"error": sendErr.Error(), "delivery": delivery})
+	}
+	return c.JSON(delivery)
+}
+
+// invoiceEmail is the subject and text of the email carrying inv, with
+// message, if any, at the top.
+func invoiceEmail(orgID string, inv *invoiceData, message string) (string, string) {
+	kind := "Invoice"
+	if inv.typ == "outflow" {
+		kind = "Bill"
+	}
+	business := toString(inv.org["name"])
+	symbol, _ := orgSetting(orgID, "currency_symbol").(string)
+	var b strings.Builder
+	if message != "" {
+		b.WriteString(message + "\n\n")
+	}
+	if inv.contact.name != "" {
+		fmt.Fprintf(&b, "Dear %s,\n\n", inv.contact.name)
+	}
+	fmt.Fprintf(&b, "Please find attached %s %s for %s%s.\n", strings.ToLower(kind), inv.number(), symbol, invoiceMoney(inv.amount))
+	if inv.due > 0 {
+		fmt.Fprintf(&b, "%s%s of it is still due.\n", symbol, invoiceMoney(inv.due))
+	}
+	fmt.Fprintf(&b, "\nThank you,\n%s\n", business)
+	return fmt.Sprintf("%s %s from %s", kind, inv.number(), business), b.String()
+}
+
+func handleListInvoiceDeliveries(c *fiber.Ctx) error {
+	orgID, id := currentOrgID(c), c.Params("id")
+	if !orgOwns(dbFor(c), "transactions", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err := dbFor(c).Query(invoiceDeliverySQL+` WHERE organization_id = ? AND transaction_id = ? ORDER BY created_at DESC`, orgID, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	items, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
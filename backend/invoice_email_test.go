@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP answers just enough SMTP for net/smtp to send through it and
+// passes on each message it is given.
+func fakeSMTP(t *testing.T) <-chan string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	host, port, _ := net.SplitHostPort(ln.Addr().String())
+	t.Setenv("SMTP_HOST", host)
+	t.Setenv("SMTP_PORT", port)
+	t.Setenv("SMTP_FROM", "shop@example.com")
+	mails := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+				reply("220 fake")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+					case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+						reply("250 fake")
+					case cmd == "DATA":
+						reply("354 go on")
+						var msg strings.Builder
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if l == ".\r\n" {
+								break
+							}
+							msg.WriteString(l)
+						}
+						mails <- msg.String()
+						reply("250 queued")
+					case cmd == "QUIT":
+						reply("221 bye")
+						return
+					default:
+						reply("250 ok")
+					}
+				}
+			}(conn)
+		}
+	}()
+	return mails
+}
+
+func TestSendInvoice(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,email,type,organization_id) VALUES ('c-1','Rahim','','rahim@example.com','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-2','Karim','','customer','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,receipt_number,organization_id,created_at) VALUES ('t-1','inflow',10000,6000,4000,'c-1','INV-00001','org-1','2024-03-10T10:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-2','inflow',5000,5000,0,'c-2','org-1','2024-03-10T10:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mails := fakeSMTP(t)
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, 10000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("viewer", "POST", "/api/transactions/t-1/send", ""); code != 403 {
+		t.Errorf("viewer sending: got %d, want 403", code)
+	}
+	code, out := call("cashier", "POST", "/api/transactions/t-1/send", `{"message":"Thanks for shopping with us."}`)
+	if code != 200 || out["status"] != "sent" || out["email"] != "rahim@example.com" {
+		t.Fatalf("send: %d %v", code, out)
+	}
+	select {
+	case mail := <-mails:
+		for _, want := range []string{"To: rahim@example.com", "Subject: Invoice INV-00001 from Mine", "Thanks for shopping with us.", "40.00 of it is still due", `filename="invoice-INV-00001.pdf"`, "Content-Type: application/pdf"} {
+			if !strings.Contains(mail, want) {
+				t.Errorf("mail lacks %q:\n%s", want, mail)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail was sent")
+	}
+
+	// a contact without an email needs one given
+	if code, _ := call("cashier", "POST", "/api/transactions/t-2/send", ""); code != 400 {
+		t.Errorf("no address: got %d, want 400", code)
+	}
+	if code, _ := call("manager", "PATCH", "/api/collections/contacts/records/c-2", `{"email":"karim"}`); code != 400 {
+		t.Errorf("bad email: got %d, want 400", code)
+	}
+	if code, _ := call("manager", "PATCH", "/api/collections/contacts/records/c-2", `{"email":"karim@example.com"}`); code != 200 {
+		t.Fatalf("set email: got %d", code)
+	}
+	if _, ct := call("viewer", "GET", "/api/collections/contacts/records/c-2", ""); ct["email"] != "karim@example.com" {
+		t.Errorf("contact: %v", ct)
+	}
+	if code, out := call("cashier", "POST", "/api/transactions/t-2/send", ""); code != 200 || out["email"] != "karim@example.com" {
+		t.Errorf("send to new address: %d %v", code, out)
+	}
+	<-mails
+
+	// a mail server that cannot be reached is recorded as a failure
+	t.Setenv("SMTP_PORT", "1")
+	if code, out := call("cashier", "POST", "/api/transactions/t-1/send", ""); code != 502 || out["delivery"].(map[string]interface{})["status"] != "failed" {
+		t.Errorf("failed send: %d %v", code, out)
+	}
+	_, list := call("viewer", "GET", "/api/transactions/t-1/deliveries", "")
+	items, _ := list["items"].([]interface{})
+	statuses := map[interface{}]bool{}
+	for _, it := range items {
+		statuses[it.(map[string]interface{})["status"]] = true
+	}
+	if len(items) != 2 || !statuses["sent"] || !statuses["failed"] {
+		t.Errorf("deliveries: %v", list)
+	}
+	if code, _ := call("viewer", "GET", "/api/transactions/nope/deliveries", ""); code != 404 {
+		t.Errorf("unknown transaction: got %d, want 404", code)
+	}
+}
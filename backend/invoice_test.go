@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestInvoicePDF(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status,address) VALUES ('org-1','Corner (Shop)','','active','12 Road, Dhaka')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','017','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Blue pen','PEN',10,15,'org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-1','inflow',4500,3000,1500,'c-1','org-1','2026-03-01T10:00:00Z')`,
+		`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES ('ti-1','t-1','i-1',3,1500,4500)`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-2','inflow',1000,1000,0,'c-1','org-2','2026-03-01T10:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	get := func(id string) (int, []byte) {
+		req := httptest.NewRequest("GET", "/api/transactions/"+id+"/invoice.pdf", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "cashier"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, body
+	}
+
+	code, pdf := get("t-1")
+	if code != 200 || !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatalf("got %d, %.40q", code, pdf)
+	}
+	for _, want := range []string{`(Corner \(Shop\))`, "(Blue pen)", "(Rahim)", "(45.00)", "(Due)", "(15.00)"} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("invoice lacks %s", want)
+		}
+	}
+	// every cross-reference entry must point at its object
+	xref := bytes.LastIndex(pdf, []byte("startxref\n"))
+	start, _ := strconv.Atoi(string(bytes.Fields(pdf[xref+10:])[0]))
+	entries := strings.Split(string(pdf[start:]), "\n")[3:]
+	for n := 1; n < len(entries) && strings.HasSuffix(entries[n-1], " n "); n++ {
+		off, _ := strconv.Atoi(entries[n-1][:10])
+		if want := fmt.Sprintf("%d 0 obj", n); !bytes.HasPrefix(pdf[off:], []byte(want)) {
+			t.Errorf("xref entry %d points at %.10q", n, pdf[off:])
+		}
+	}
+	if code, _ := get("t-2"); code != 404 {
+		t.Errorf("other organization's transaction: got %d, want 404", code)
+	}
+}
+
+func TestPDFWrapAndFit(t *testing.T) {
+	lines := pdfWrap("Goods once sold are not returnable after seven days", 10, 120)
+	if len(lines) < 2 {
+		t.Fatalf("expected wrapping, got %q", lines)
+	}
+	for _, l := range lines {
+		if pdfTextWidth(l, 10) > 120 {
+			t.Errorf("line %q is wider than 120pt", l)
+		}
+	}
+	if s := pdfFit("A very long product name indeed", 10, 60); pdfTextWidth(s, 10) > 60 {
+		t.Errorf("fit %q is wider than 60pt", s)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Besides its SKU an item can have other codes: the barcode a manufacturer
+// used before changing it, the barcode on a carton, a supplier's own
+// number. Each code names one item; a code may also stand for one of the
+// item's units (see units.go), so scanning a carton finds the item in
+// cartons. Scans (GET /api/inventory_items/lookup) and purchase and CSV
+// imports resolve a code the same way as a SKU.
+//
+//	GET /api/inventory_items/:id/codes
+//	PUT /api/inventory_items/:id/codes   {"codes": [{"code", "unit", "note"}]}
+
+type ItemCode struct {
+	Code string `json:"code"`
+	Unit string `json:"unit,omitempty"`
+	Note string `json:"note,omitempty"`
+}
+
+func registerItemCodeRoutes(app *fiber.App) {
+	app.Get("/api/inventory_items/:id/codes", requireAuth, handleGetItemCodes)
+	app.Put("/api/inventory_items/:id/codes", requireAuth, requireRole("admin", "manager"), handlePutItemCodes)
+}
+
+// itemCodes returns the other codes of itemID.
+func itemCodes(q *DB, itemID string) ([]ItemCode, error) {
+	rows, err := q.Query(`SELECT code, COALESCE(unit, ''), COALESCE(note, '') FROM item_codes WHERE item_id = ? ORDER BY created_at, code`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	codes := []ItemCode{}
+	for rows.Next() {
+		var ic ItemCode
+		if err := rows.Scan(&ic.Code, &ic.Unit, &ic.Note); err != nil {
+			return nil, err
+		}
+		codes = append(codes, ic)
+	}
+	return codes, rows.Err()
+}
+
+// codeOwner returns the item other than itemID that uses code as its SKU
+// or as one of its codes, or "".
+func codeOwner(q queryer, orgID, itemID, code string) (string, error) {
+	var owner string
+	err := q.QueryRow(`SELECT id FROM inventory_items WHERE organization_id = ? AND sku = ? AND id <> ?
+		UNION SELECT item_id FROM item_codes WHERE organization_id = ? AND code = ? AND item_id <> ?`, orgID, code, itemID, orgID, code, itemID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return owner, err
+}
+
+func handleGetItemCodes(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "inventory_items", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	codes, err := itemCodes(dbFor(c), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"codes": codes})
+}
+
+// handlePutItemCodes replaces the other codes of an item. A code already
+// used by another item, as its SKU or one of its codes, is refused.
+func handlePutItemCodes(c *fiber.Ctx) error {
+	id := c.Params("id")
+	orgID := currentOrgID(c)
+	var req struct {
+		Codes []ItemCode `json:"codes"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	var sku, base string
+	if err := dbFor(c).QueryRow(`SELECT COALESCE(sku, ''), unit FROM inventory_items WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&sku, &base); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	seen := map[string]bool{sku: true}
+	for i := range req.Codes {
+		ic := &req.Codes[i]
+		ic.Code, ic.Unit, ic.Note = strings.TrimSpace(ic.Code), strings.TrimSpace(ic.Unit), strings.TrimSpace(ic.Note)
+		if ic.Code == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "every code needs a code"})
+		}
+		if seen[ic.Code] {
+			return c.Status(400).JSON(fiber.Map{"error": "code " + ic.Code + " is listed twice or is the item's SKU"})
+		}
+		seen[ic.Code] = true
+		if ic.Unit == base {
+			ic.Unit = ""
+		}
+		if ic.Unit != "" {
+			if _, err := unitFactor(dbFor(c), id, ic.Unit); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		owner, err := codeOwner(dbFor(c), orgID, id, ic.Code)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if owner != "" {
+			return c.Status(409).JSON(fiber.Map{"error": "code " + ic.Code + " belongs to another item", "item_id": owner})
+		}
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM item_codes WHERE item_id = ?`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now().Format(time.RFC3339)
+	for _, ic := range req.Codes {
+		if _, err := tx.Exec(`INSERT INTO item_codes (id,organization_id,item_id,code,unit,note,created_at) VALUES (?,?,?,?,NULLIF(?, ''),NULLIF(?, ''),?)`, genID(), orgID, id, ic.Code, ic.Unit, ic.Note, now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "inventory_items", "update", id)
+	if req.Codes == nil {
+		req.Codes = []ItemCode{}
+	}
+	return c.JSON(fiber.Map{"codes": req.Codes})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestItemCodes(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Supplier','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit,unit_price,cost_price,organization_id) VALUES ('soap','Soap','8901',10,'pcs',40,30,'org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit,unit_price,organization_id) VALUES ('salt','Salt','8902',10,'pcs',20,'org-1')`,
+		`INSERT INTO item_units (id,organization_id,item_id,name,factor) VALUES ('u-1','org-1','soap','carton',24)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, out := call("manager", "PUT", "/api/inventory_items/soap/codes", `{"codes":[{"code":"5550001","note":"old barcode"},{"code":"5559999","unit":"carton"}]}`); code != 200 || len(out["codes"].([]interface{})) != 2 {
+		t.Fatalf("set codes: %d %v", code, out)
+	}
+	if code, _ := call("manager", "PUT", "/api/inventory_items/salt/codes", `{"codes":[{"code":"5550001"}]}`); code != 409 {
+		t.Errorf("another item's code: got %d, want 409", code)
+	}
+	if code, _ := call("manager", "PUT", "/api/inventory_items/salt/codes", `{"codes":[{"code":"8901"}]}`); code != 409 {
+		t.Errorf("another item's SKU: got %d, want 409", code)
+	}
+	if code, _ := call("manager", "PUT", "/api/inventory_items/salt/codes", `{"codes":[{"code":"x","unit":"crate"}]}`); code != 400 {
+		t.Errorf("unknown unit: got %d, want 400", code)
+	}
+
+	code, out := call("cashier", "GET", "/api/inventory_items/lookup?code=5550001", "")
+	if code != 200 || out["id"] != "soap" || out["scanned_unit"] != nil {
+		t.Errorf("old barcode: %d %v", code, out)
+	}
+	code, out = call("cashier", "GET", "/api/inventory_items/lookup?code=5559999", "")
+	if code != 200 || out["id"] != "soap" || out["scanned_unit"] != "carton" {
+		t.Errorf("carton barcode: %d %v", code, out)
+	}
+
+	// a supplier invoice with the carton barcode restocks pieces
+	code, out = call("manager", "POST", "/api/purchases/import", `{"supplier_id":"s-1","confirm":true,"lines":[{"sku":"5559999","quantity":2,"unit_cost":720}]}`)
+	if code != 200 || out["new_items"] != 0.0 {
+		t.Fatalf("import: %d %v", code, out)
+	}
+	var quantity int
+	if err := db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = 'soap'`).Scan(&quantity); err != nil || quantity != 58 {
+		t.Errorf("soap stock %d %v, want 58", quantity, err)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The kitchen order ticket (KOT) of an order tells the kitchen what to
+// make: the token and table large, then the items quantity first. It is
+// printed as ESC/POS for a receipt printer, ?width characters wide (32 on
+// 58 mm paper, 42 or 48 on 80 mm), or as plain text to show on screen.
+// Only orders still to be delivered are printed; a second print says
+// REPRINT so the kitchen does not make the order twice.
+//
+//	POST /api/orders/:id/kot     ?width=32&format=escpos|text
+
+const (
+	kotDefaultWidth = 32
+	kotMinWidth     = 24
+	kotMaxWidth     = 64
+)
+
+func registerKitchenTicketRoutes(app *fiber.App) {
+	app.Post("/api/orders/:id/kot", requireAuth, requireRole("admin", "manager", "cashier"), handleKitchenTicket)
+}
+
+type kitchenTicket struct {
+	token, table, receipt, contact string
+	takenAt                        time.Time
+	reprint                        bool
+	lines                          []transactionItemLine
+}
+
+// ticketLine is one printed line; big lines are twice as wide and high.
+type ticketLine struct {
+	text              string
+	big, bold, centre bool
+}
+
+// layout lays the ticket out in lines of at most width characters.
+func (k kitchenTicket) layout(width int) []ticketLine {
+	rule := ticketLine{text: strings.Repeat("-", width)}
+	out := []ticketLine{{text: "KITCHEN ORDER", bold: true, centre: true}}
+	if k.reprint {
+		out = append(out, ticketLine{text: "** REPRINT **", bold: true, centre: true})
+	}
+	out = append(out, ticketLine{text: "TOKEN " + k.token, big: true, centre: true})
+	if k.table != "" {
+		out = append(out, ticketLine{text: "TABLE " + k.table, big: true, centre: true})
+	}
+	out = append(out, ticketLine{text: spread(k.takenAt.Format("02 Jan 15:04"), k.receipt, width)}, rule)
+	for _, l := range k.lines {
+		qty := strconv.Itoa(l.Quantity)
+		if l.Unit != "" && l.UnitQuantity > 0 {
+			qty = strconv.Itoa(l.UnitQuantity) + " " + l.Unit
+		}
+		name := l.ItemName
+		if name == "" {
+			name = l.Name
+		}
+		indent := len(qty) + 2
+		for i, part := range wrapText(name, width-indent) {
+			lead := strings.Repeat(" ", indent)
+			if i == 0 {
+				lead = qty + "  "
+			}
+			out = append(out, ticketLine{text: lead + part, bold: i == 0})
+		}
+	}
+	out = append(out, rule)
+	if k.contact != "" {
+		out = append(out, ticketLine{text: "For " + k.contact})
+	}
+	return out
+}
+
+// spread puts left and right at either end of a line, or on two lines
+// when they do not fit.
+func spread(left, right string, width int) string {
+	if gap := width - len(left) - len(right); gap > 0 {
+		return left + strings.Repeat(" ", gap) + right
+	}
+	return strings.TrimSpace(left + "\n" + right)
+}
+
+// wrapText breaks s into lines of at most width characters, at spaces
+// where it can.
+func wrapText(s string, width int) []string {
+	var lines []string
+	var line []rune
+	for _, field := range strings.Fields(s) {
+		word := []rune(field)
+		for len(word) > width {
+			if len(line) > 0 {
+				lines, line = append(lines, string(line)), nil
+			}
+			lines, word = append(lines, string(word[:width])), word[width:]
+		}
+		if len(line) > 0 && len(line)+1+len(word) > width {
+			lines, line = append(lines, string(line)), nil
+		}
+		if len(line) > 0 {
+			line = append(line, ' ')
+		}
+		line = append(line, word...)
+	}
+	if len(line) > 0 || len(lines) == 0 {
+		lines = append(lines, string(line))
+	}
+	return lines
+}
+
+// asciiOnly replaces what a receipt printer's default code page cannot
+// print.
+func asciiOnly(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || (r >= 32 && r < 127) {
+			return r
+		}
+		return '?'
+	}, s)
+}
+
+func ticketText(lines []ticketLine, width int) []byte {
+	var b bytes.Buffer
+	for _, l := range lines {
+		for _, text := range strings.Split(l.text, "\n") {
+			if l.centre && len(text) < width {
+				text = strings.Repeat(" ", (width-len(text))/2) + text
+			}
+			b.WriteString(text + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+// ticketESCPOS renders the lines as ESC/POS commands: initialise, then
+// each line with its alignment, size and emphasis, then feed and cut.
+func ticketESCPOS(lines []ticketLine) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0x1b, '@'})
+	for _, l := range lines {
+		align, size, bold := byte(0), byte(0), byte(0)
+		if l.centre {
+			align = 1
+		}
+		if l.big {
+			size = 0x11
+		}
+		if l.bold {
+			bold = 1
+		}
+		b.Write([]byte{0x1b, 'a', align, 0x1d, '!', size, 0x1b, 'E', bold})
+		b.WriteString(asciiOnly(l.text) + "\n")
+	}
+	b.Write([]byte{0x1b, 'd', 4, 0x1d, 'V', 'B', 0})
+	return b.Bytes()
+}
+
+func handleKitchenTicket(c *fiber.Ctx) error {
+	width := kotDefaultWidth
+	if v := c.Query("width"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < kotMinWidth || n > kotMaxWidth {
+			return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("width must be %d to %d characters", kotMinWidth, kotMaxWidth)})
+		}
+		width = n
+	}
+	format := c.Query("format", "escpos")
+	if format != "escpos" && format != "text" {
+		return c.Status(400).JSON(fiber.Map{"error": "format must be escpos or text"})
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	var status, token, table, receipt, contact, voidedAt, createdAt, summary sql.NullString
+	var prints int
+	err := dbFor(c).QueryRow(`SELECT order_status, order_token, order_table, receipt_number, contact_name, voided_at, created_at, items_summary, kot_prints
+		FROM transactions WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&status, &token, &table, &receipt, &contact, &voidedAt, &createdAt, &summary, &prints)
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	switch {
+	case voidedAt.Valid:
+		return c.Status(409).JSON(fiber.Map{"error": "order is voided"})
+	case status.String == "":
+		return c.Status(409).JSON(fiber.Map{"error": "not an order; put it on the board first"})
+	case status.String == "delivered":
+		return c.Status(409).JSON(fiber.Map{"error": "order is delivered"})
+	}
+	now := time.Now().Format(time.RFC3339)
+	if _, err := dbFor(c).Exec(`UPDATE transactions SET kot_prints = kot_prints + 1, kot_printed_at = ? WHERE id = ?`, now, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	ticket := kitchenTicket{token: token.String, table: table.String, receipt: receipt.String, contact: contact.String, reprint: prints > 0, lines: itemsSummary(summary.String)}
+	if t, err := parseTime(createdAt.String); err == nil {
+		ticket.takenAt = t.Local()
+	}
+	lines := ticket.layout(width)
+	if format == "text" {
+		c.Set("Content-Type", "text/plain; charset=utf-8")
+		return c.Send(ticketText(lines, width))
+	}
+	c.Set("Content-Type", "application/octet-stream")
+	c.Set("Content-Disposition", fmt.Sprintf(`attachment; filename="kot-%s.bin"`, token.String))
+	return c.Send(ticketESCPOS(lines))
+}
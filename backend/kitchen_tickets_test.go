@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKitchenTickets(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('biryani','Kacchi Biryani with extra mutton and an egg','BIR',50,250,'org-1')`,
+		// yesterday's tokens went up to 41
+		`INSERT INTO number_sequences (organization_id,key,prefix,padding,next_number,reset,period,updated_at) VALUES ('org-1','order_token','',0,42,'daily','2024-01-01','2024-01-01T23:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, out
+	}
+	create := func(body string) map[string]interface{} {
+		t.Helper()
+		code, out := call("cashier", "POST", "/api/collections/transactions/records", body)
+		var created map[string]interface{}
+		_ = json.Unmarshal(out, &created)
+		if code != 200 {
+			t.Fatalf("sale: %d %s", code, out)
+		}
+		return created
+	}
+
+	sale := `{"type":"inflow","amount":500,"paid_amount":500,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"biryani","quantity":2,"unit_price":250}]`
+	if code, _ := call("cashier", "POST", "/api/collections/transactions/records", sale+`,"order_table":"4"}`); code != 400 {
+		t.Errorf("table without an order: got %d, want 400", code)
+	}
+	first := create(sale + `,"order_status":"new","order_table":"4"}`)
+	if first["order_token"] != "1" {
+		t.Errorf("first token of the day: %v", first)
+	}
+	plain := create(sale + `}`)
+	kot := "/api/orders/" + toString(first["id"]) + "/kot"
+
+	code, out := call("cashier", "POST", kot+"?format=text", "")
+	text := string(out)
+	if code != 200 || !strings.Contains(text, "TOKEN 1") || !strings.Contains(text, "TABLE 4") || strings.Contains(text, "REPRINT") {
+		t.Fatalf("ticket: %d\n%s", code, text)
+	}
+	if !strings.Contains(text, "2  Kacchi Biryani with extra\n   mutton and an egg\n") || !strings.Contains(text, "For Rahim") {
+		t.Errorf("ticket lines:\n%s", text)
+	}
+	code, out = call("cashier", "POST", kot+"?width=48", "")
+	if code != 200 || !bytes.HasPrefix(out, []byte{0x1b, '@'}) || !bytes.HasSuffix(out, []byte{0x1d, 'V', 'B', 0}) || !bytes.Contains(out, []byte("** REPRINT **")) {
+		t.Errorf("ESC/POS reprint: %d %q", code, out)
+	}
+	if code, _ := call("cashier", "POST", kot+"?width=200", ""); code != 400 {
+		t.Errorf("width 200: got %d, want 400", code)
+	}
+	if code, _ := call("cashier", "POST", "/api/orders/"+toString(plain["id"])+"/kot", ""); code != 409 {
+		t.Errorf("ticket for a plain sale: got %d, want 409", code)
+	}
+
+	// a sale put on the board later gets the next token
+	code, out = call("cashier", "POST", "/api/orders/"+toString(plain["id"])+"/status", `{"status":"new","table":"7"}`)
+	if code != 200 || !strings.Contains(string(out), `"order_token":"2"`) {
+		t.Errorf("token for a sale put on the board: %d %s", code, out)
+	}
+	_, out = call("viewer", "GET", "/api/orders/board", "")
+	if !strings.Contains(string(out), `"order_table":"7","order_token":"2"`) {
+		t.Errorf("board: %s", out)
+	}
+	for _, to := range []string{"ready", "delivered"} {
+		call("cashier", "POST", "/api/orders/"+toString(first["id"])+"/status", `{"status":"`+to+`"}`)
+	}
+	if code, _ := call("cashier", "POST", kot, ""); code != 409 {
+		t.Errorf("ticket for a delivered order: got %d, want 409", code)
+	}
+
+	// tokens start again each day
+	s := numberSequence{NextNumber: 3, Reset: "daily", Period: time.Now().Format("2006-01-02")}
+	if n, _ := s.numberFor(time.Now()); n != 3 {
+		t.Errorf("same day: %d", n)
+	}
+	if n, period := s.numberFor(time.Now().AddDate(0, 0, 1)); n != 1 || period == s.Period {
+		t.Errorf("next day: %d %s", n, period)
+	}
+}
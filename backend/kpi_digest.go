@@ -0,0 +1,371 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Owners and managers can have the day's (or week's) figures sent to them
+// instead of opening the reports: a digest of sales against the same day
+// (or week) a week before, cash on hand, the customers owing the most and
+// the items at or below their reorder level. Each user sets it up for the
+// organization they are signed in to, choosing daily or weekly, the
+// channel it goes over (email, sms or whatsapp; see messaging.go), the
+// address, and the business hour it is sent at; weekly digests go out on
+// weekday (0 Sunday .. 6 Saturday, the default) and cover the seven days
+// before it. A background job checks every DIGEST_CHECK_MINUTES (default
+// 15; 0 turns it off) for digests due.
+//
+//	GET    /api/digest            the caller's digest settings
+//	PUT    /api/digest            {"frequency": "daily" | "weekly", "channel", "address", "send_hour", "weekday"}
+//	DELETE /api/digest            stop it
+//	GET    /api/digest/preview    the digest as it would be sent now
+//	POST   /api/digest/send       send it now
+
+const (
+	digestTopDues     = 5
+	digestReorderRows = 10
+)
+
+func registerDigestRoutes(app *fiber.App) {
+	d := app.Group("/api/digest", requireAuth, requireRole("admin", "manager"))
+	d.Get("/", handleGetDigest)
+	d.Put("/", handlePutDigest)
+	d.Delete("/", handleDeleteDigest)
+	d.Get("/preview", handlePreviewDigest)
+	d.Post("/send", handleSendDigest)
+}
+
+func startDigestSender() {
+	minutes := 15
+	if v, err := strconv.Atoi(os.Getenv("DIGEST_CHECK_MINUTES")); err == nil {
+		minutes = v
+	}
+	if minutes <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if sent, err := sendDueDigests(time.Now()); err != nil {
+				log.Printf("digests: %v", err)
+			} else if sent > 0 {
+				log.Printf("digests: sent %d", sent)
+			}
+			time.Sleep(time.Duration(minutes) * time.Minute)
+		}
+	}()
+}
+
+// digestSubscription is a row of kpi_digests.
+type digestSubscription struct {
+	ID        string `json:"id"`
+	OrgID     string `json:"-"`
+	UserID    string `json:"-"`
+	Frequency string `json:"frequency"`
+	Channel   string `json:"channel"`
+	Address   string `json:"address"`
+	SendHour  int    `json:"send_hour"`
+	Weekday   int    `json:"weekday"`
+	LastSent  string `json:"last_sent_date"`
+}
+
+const digestSQL = `SELECT id, organization_id, user_id, frequency, channel, address, send_hour, weekday, COALESCE(last_sent_date, '') FROM kpi_digests`
+
+func scanDigest(s interface{ Scan(...interface{}) error }) (digestSubscription, error) {
+	var d digestSubscription
+	err := s.Scan(&d.ID, &d.OrgID, &d.UserID, &d.Frequency, &d.Channel, &d.Address, &d.SendHour, &d.Weekday, &d.LastSent)
+	return d, err
+}
+
+// due reports whether d is to be sent at now, business time: past its
+// hour, on its weekday if weekly, and not sent yet that day.
+func (d digestSubscription) due(now time.Time) bool {
+	if now.Hour() < d.SendHour || d.LastSent == now.Format("2006-01-02") {
+		return false
+	}
+	return d.Frequency == "daily" || int(now.Weekday()) == d.Weekday
+}
+
+// sendDueDigests sends the digests due at now and returns how many went
+// out. A digest that fails to send is logged and tried again on the next
+// check.
+func sendDueDigests(now time.Time) (int, error) {
+	rows, err := db.Query(digestSQL + ` ORDER BY organization_id`)
+	if err != nil {
+		return 0, err
+	}
+	var subs []digestSubscription
+	for rows.Next() {
+		d, err := scanDigest(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		subs = append(subs, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	sent := 0
+	for _, d := range subs {
+		local := now.In(businessLocation(d.OrgID))
+		if !d.due(local) {
+			continue
+		}
+		if err := sendDigest(db, d, local); err != nil {
+			log.Printf("digests: %s to %s: %v", d.Channel, d.Address, err)
+			continue
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// sendDigest builds d's digest as of now and sends it.
+func sendDigest(q *DB, d digestSubscription, now time.Time) error {
+	digest, err := kpiDigest(q, d.OrgID, d.Frequency, now)
+	if err != nil {
+		return err
+	}
+	if _, err := sendMessage(d.Channel, d.Address, toString(digest["subject"]), toString(digest["text"])); err != nil {
+		return err
+	}
+	_, err = q.Exec(`UPDATE kpi_digests SET last_sent_date = ? WHERE id = ?`, now.Format("2006-01-02"), d.ID)
+	return err
+}
+
+// kpiDigest assembles orgID's digest for the day (or, weekly, the seven
+// days) before now, in business time, with the same period a week
+// earlier to compare with.
+func kpiDigest(q *DB, orgID, frequency string, now time.Time) (fiber.Map, error) {
+	days := 1
+	if frequency == "weekly" {
+		days = 7
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := midnight.AddDate(0, 0, -days)
+	current, err := profitAndLoss(q.context(), q, orgID, from, midnight)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := profitAndLoss(q.context(), q, orgID, from.AddDate(0, 0, -7), midnight.AddDate(0, 0, -7))
+	if err != nil {
+		return nil, err
+	}
+	sheet, err := balanceSheet(q.context(), q, orgID, now)
+	if err != nil {
+		return nil, err
+	}
+	sales, before := moneyValue(current["net_sales"]), moneyValue(previous["net_sales"])
+	cash := moneyValue(sheet["assets"].(fiber.Map)["cash"])
+
+	rows, err := q.Query(`SELECT COALESCE(c.name, ''), SUM(t.due_amount) FROM transactions t LEFT JOIN contacts c ON c.id = t.contact_id
+		WHERE t.organization_id = ? AND t.type = 'inflow' AND t.due_amount > 0 AND t.voided_at IS NULL
+		GROUP BY t.contact_id, c.name ORDER BY 2 DESC LIMIT ?`, orgID, digestTopDues)
+	if err != nil {
+		return nil, err
+	}
+	dues := []fiber.Map{}
+	for rows.Next() {
+		var name string
+		var due money
+		if err := rows.Scan(&name, &due); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dues = append(dues, fiber.Map{"name": name, "due": due})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.Query(`SELECT id, COALESCE(name, ''), quantity, reorder_level FROM inventory_items
+		WHERE organization_id = ? AND reorder_level > 0 AND quantity <= reorder_level ORDER BY quantity - reorder_level, name LIMIT ?`, orgID, digestReorderRows)
+	if err != nil {
+		return nil, err
+	}
+	reorder := []fiber.Map{}
+	for rows.Next() {
+		var id, name string
+		var quantity, level int
+		if err := rows.Scan(&id, &name, &quantity, &level); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reorder = append(reorder, fiber.Map{"item_id": id, "name": name, "quantity": quantity, "reorder_level": level})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	profile, err := organizationProfile(q, orgID)
+	if err != nil {
+		return nil, err
+	}
+	orgName, _ := profile["name"].(string)
+	symbol, _ := orgSetting(orgID, "currency_symbol").(string)
+	label, compared := from.Format("Mon 2 Jan"), "last "+from.Format("Monday")
+	if days == 7 {
+		label, compared = from.Format("2 Jan")+" to "+midnight.AddDate(0, 0, -1).Format("2 Jan"), "the week before"
+	}
+	subject := fmt.Sprintf("%s: %s digest for %s", orgName, frequency, label)
+	var b strings.Builder
+	fmt.Fprintf(&b, "Sales: %s%s (%v sales) against %s%s %s", symbol, sales, current["sales_count"], symbol, before, compared)
+	if before != 0 {
+		fmt.Fprintf(&b, ", %+.0f%%", (sales-before).float()*100/before.float())
+	}
+	fmt.Fprintf(&b, "\nCash: %s%s\n", symbol, cash)
+	if len(dues) > 0 {
+		parts := make([]string, len(dues))
+		for i, d := range dues {
+			parts[i] = fmt.Sprintf("%s %s%s", d["name"], symbol, d["due"])
+		}
+		b.WriteString("Owing most: " + strings.Join(parts, "; ") + "\n")
+	}
+	if len(reorder) > 0 {
+		parts := make([]string, len(reorder))
+		for i, it := range reorder {
+			parts[i] = fmt.Sprintf("%s (%d left)", it["name"], it["quantity"])
+		}
+		b.WriteString("To reorder: " + strings.Join(parts, "; ") + "\n")
+	}
+	return fiber.Map{
+		"subject":        subject,
+		"from":           from.Format("2006-01-02"),
+		"to":             midnight.AddDate(0, 0, -1).Format("2006-01-02"),
+		"sales":          sales,
+		"sales_count":    current["sales_count"],
+		"previous_sales": before,
+		"cash":           cash,
+		"top_dues":       dues,
+		"reorder":        reorder,
+		"text":           b.String(),
+	}, nil
+}
+
+// callerDigest reads the caller's digest settings, or sql.ErrNoRows.
+func callerDigest(c *fiber.Ctx) (digestSubscription, error) {
+	return scanDigest(dbFor(c).QueryRow(digestSQL+` WHERE organization_id = ? AND user_id = ?`, currentOrgID(c), currentUserID(c)))
+}
+
+func handleGetDigest(c *fiber.Ctx) error {
+	d, err := callerDigest(c)
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	return c.JSON(d)
+}
+
+func handlePutDigest(c *fiber.Ctx) error {
+	var req struct {
+		Frequency string `json:"frequency"`
+		Channel   string `json:"channel"`
+		Address   string `json:"address"`
+		SendHour  *int   `json:"send_hour"`
+		Weekday   *int   `json:"weekday"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	d, err := callerDigest(c)
+	if err == sql.ErrNoRows {
+		// a new digest goes by email to the address the user signs in with
+		d = digestSubscription{Frequency: "daily", Channel: "email", SendHour: 8, Weekday: 6}
+		if err := dbFor(c).QueryRow(`SELECT email FROM users WHERE id = ?`, currentUserID(c)).Scan(&d.Address); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Frequency != "" {
+		d.Frequency = req.Frequency
+	}
+	if req.Channel != "" {
+		d.Channel = req.Channel
+	}
+	if a := strings.TrimSpace(req.Address); a != "" {
+		d.Address = a
+	}
+	if req.SendHour != nil {
+		d.SendHour = *req.SendHour
+	}
+	if req.Weekday != nil {
+		d.Weekday = *req.Weekday
+	}
+	switch {
+	case d.Frequency != "daily" && d.Frequency != "weekly":
+		return c.Status(400).JSON(fiber.Map{"error": "frequency must be daily or weekly"})
+	case !isMessageChannel(d.Channel):
+		return c.Status(400).JSON(fiber.Map{"error": "channel must be one of " + strings.Join(messageChannels, ", ")})
+	case d.Address == "":
+		return c.Status(400).JSON(fiber.Map{"error": "address required"})
+	case d.Channel == "email" && !strings.Contains(d.Address, "@"):
+		return c.Status(400).JSON(fiber.Map{"error": "address must be an email address"})
+	case d.SendHour < 0 || d.SendHour > 23:
+		return c.Status(400).JSON(fiber.Map{"error": "send_hour must be 0 to 23"})
+	case d.Weekday < 0 || d.Weekday > 6:
+		return c.Status(400).JSON(fiber.Map{"error": "weekday must be 0 (Sunday) to 6 (Saturday)"})
+	}
+	if d.ID == "" {
+		d.ID = genID()
+	}
+	_, err = dbFor(c).Exec(`INSERT INTO kpi_digests (id,organization_id,user_id,frequency,channel,address,send_hour,weekday,created_at) VALUES (?,?,?,?,?,?,?,?,?)
+		ON CONFLICT(organization_id,user_id) DO UPDATE SET frequency = excluded.frequency, channel = excluded.channel, address = excluded.address, send_hour = excluded.send_hour, weekday = excluded.weekday`,
+		d.ID, currentOrgID(c), currentUserID(c), d.Frequency, d.Channel, d.Address, d.SendHour, d.Weekday, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(d)
+}
+
+func handleDeleteDigest(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`DELETE FROM kpi_digests WHERE organization_id = ? AND user_id = ?`, currentOrgID(c), currentUserID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
+
+func handlePreviewDigest(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	frequency := c.Query("frequency")
+	if frequency == "" {
+		frequency = "daily"
+		if d, err := callerDigest(c); err == nil {
+			frequency = d.Frequency
+		}
+	}
+	if frequency != "daily" && frequency != "weekly" {
+		return c.Status(400).JSON(fiber.Map{"error": "frequency must be daily or weekly"})
+	}
+	digest, err := kpiDigest(dbFor(c), orgID, frequency, time.Now().In(businessLocation(orgID)))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(digest)
+}
+
+func handleSendDigest(c *fiber.Ctx) error {
+	d, err := callerDigest(c)
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	if err := sendDigest(dbFor(c), d, time.Now().In(businessLocation(d.OrgID))); err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"sent": true, "channel": d.Channel, "address": d.Address})
+}
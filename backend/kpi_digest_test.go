@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKPIDigest(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	noon := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, time.UTC)
+	yesterday := noon.AddDate(0, 0, -1).Format(time.RFC3339)
+	weekBefore := noon.AddDate(0, 0, -8).Format(time.RFC3339)
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO settings (scope,scope_id,key,value) VALUES ('organization','org-1','timezone','"UTC"')`,
+		`INSERT INTO users (id,email,password_hash,name,organization_id,role,created_at) VALUES ('user-manager','manager@example.com','x','Manager','org-1','manager','2024-01-01T00:00:00Z')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,reorder_level,organization_id) VALUES ('i-1','Pen','PEN',2,15,8,'org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-1','inflow',10000,5000,5000,'c-1','org-1','` + yesterday + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-2','inflow',8000,8000,0,'c-1','org-1','` + weekBefore + `')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := make(chan map[string]string, 4)
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		sent <- msg
+		w.Write([]byte(`{"id":"m-1"}`))
+	}))
+	defer gateway.Close()
+	t.Setenv("SMS_GATEWAY_URL", gateway.URL)
+
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("cashier", "PUT", "/api/digest", `{}`); code != 403 {
+		t.Errorf("cashier subscribing: got %d, want 403", code)
+	}
+	if code, d := call("manager", "PUT", "/api/digest", `{}`); code != 200 || d["address"] != "manager@example.com" || d["frequency"] != "daily" {
+		t.Errorf("default digest: %d %v", code, d)
+	}
+	if code, _ := call("manager", "PUT", "/api/digest", `{"channel":"pigeon"}`); code != 400 {
+		t.Errorf("unknown channel: got %d, want 400", code)
+	}
+	if code, d := call("manager", "PUT", "/api/digest", `{"channel":"sms","address":"01711000000","send_hour":0}`); code != 200 || d["channel"] != "sms" {
+		t.Fatalf("sms digest: %d %v", code, d)
+	}
+
+	code, preview := call("manager", "GET", "/api/digest/preview", "")
+	if code != 200 || preview["sales"] != 100.0 || preview["previous_sales"] != 80.0 || preview["cash"] != 130.0 {
+		t.Errorf("preview: %d %v", code, preview)
+	}
+
+	if n, err := sendDueDigests(now); err != nil || n != 1 {
+		t.Fatalf("sent %d (%v), want 1", n, err)
+	}
+	select {
+	case msg := <-sent:
+		text := msg["message"]
+		for _, want := range []string{"Sales: ৳100.00 (1 sales) against ৳80.00", "+25%", "Cash: ৳130.00", "Rahim ৳50.00", "Pen (2 left)"} {
+			if msg["to"] != "01711000000" || !strings.Contains(text, want) {
+				t.Errorf("digest %q to %s lacks %q", text, msg["to"], want)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the digest was not sent")
+	}
+	if n, err := sendDueDigests(now); err != nil || n != 0 {
+		t.Errorf("sent again the same day: %d %v", n, err)
+	}
+
+	// weekly digests wait for their weekday
+	other := (int(now.Weekday()) + 1) % 7
+	body, _ := json.Marshal(map[string]interface{}{"frequency": "weekly", "weekday": other})
+	if code, _ := call("manager", "PUT", "/api/digest", string(body)); code != 200 {
+		t.Fatalf("weekly: %d", code)
+	}
+	if n, _ := sendDueDigests(now.AddDate(0, 0, 2)); n != 0 {
+		t.Errorf("weekly digest sent on the wrong day")
+	}
+	if n, _ := sendDueDigests(now.AddDate(0, 0, 1)); n != 1 {
+		t.Errorf("weekly digest not sent on its day")
+	}
+	<-sent
+	if code, _ := call("manager", "DELETE", "/api/digest", ""); code != 204 {
+		t.Errorf("stop: got %d", code)
+	}
+}
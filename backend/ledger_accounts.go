@@ -0,0 +1,202 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The chart of accounts lists the ledger accounts of an organization, each
+// of one of the five types below. Every organization starts with the
+// default accounts, which carry a system_key naming their role so postings
+// can find them whatever they have been renamed to; these cannot be
+// deactivated. Other accounts can be added, renamed, renumbered and
+// deactivated. An account's type never changes once created.
+//
+// Ledger accounts are separate from cash accounts (cash_accounts.go), which
+// track where money physically sits.
+
+var ledgerAccountTypes = []string{"asset", "liability", "equity", "income", "expense"}
+
+var defaultLedgerAccounts = []struct{ code, name, typ, key string }{
+	{"1000", "Cash", "asset", "cash"},
+	{"1100", "Accounts Receivable", "asset", "accounts_receivable"},
+	{"1200", "Inventory", "asset", "inventory"},
+	{"2000", "Accounts Payable", "liability", "accounts_payable"},
+	{"3000", "Owner's Equity", "equity", "owner_equity"},
+	{"4000", "Sales", "income", "sales"},
+	{"5000", "Cost of Goods Sold", "expense", "cogs"},
+}
+
+// seedLedgerAccounts adds any missing default accounts to an organization.
+func seedLedgerAccounts(q *DB, orgID string) error {
+	now := time.Now().Format(time.RFC3339)
+	for _, a := range defaultLedgerAccounts {
+		var n int
+		if err := q.QueryRow(`SELECT COUNT(1) FROM ledger_accounts WHERE organization_id = ? AND system_key = ?`, orgID, a.key).Scan(&n); err != nil {
+			return err
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := q.Exec(`INSERT INTO ledger_accounts (id,organization_id,code,name,type,system_key,active,created_at) VALUES (?,?,?,?,?,?,1,?) ON CONFLICT DO NOTHING`, genID(), orgID, a.code, a.name, a.typ, a.key, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedAllLedgerAccounts runs seedLedgerAccounts for every organization.
+func seedAllLedgerAccounts() {
+	for _, id := range organizationIDs() {
+		if err := seedLedgerAccounts(db, id); err != nil {
+			log.Printf("seed ledger accounts for %s: %v", id, err)
+		}
+	}
+}
+
+func registerLedgerAccountRoutes(app *fiber.App) {
+	r := app.Group("/api/accounts", requireAuth)
+	r.Get("/", handleListLedgerAccounts)
+	r.Post("/", requireRole("admin", "manager"), handleCreateLedgerAccount)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchLedgerAccount)
+}
+
+func isLedgerAccountType(t string) bool {
+	for _, v := range ledgerAccountTypes {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ledgerCodeTaken reports whether another account of orgID uses code.
+func ledgerCodeTaken(q queryer, orgID, code, exceptID string) (bool, error) {
+	var n int
+	err := q.QueryRow(`SELECT COUNT(1) FROM ledger_accounts WHERE organization_id = ? AND code = ? AND id <> ?`, orgID, code, exceptID).Scan(&n)
+	return n > 0, err
+}
+
+func handleListLedgerAccounts(c *fiber.Ctx) error {
+	query := `SELECT id, code, name, type, system_key, active, created_at FROM ledger_accounts WHERE organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	if t := c.Query("type"); t != "" {
+		if !isLedgerAccountType(t) {
+			return c.Status(400).JSON(fiber.Map{"error": "type must be one of " + strings.Join(ledgerAccountTypes, ", ")})
+		}
+		query += ` AND type = ?`
+		args = append(args, t)
+	}
+	if c.Query("include_inactive") != "true" {
+		query += ` AND active = 1`
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY code`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func handleCreateLedgerAccount(c *fiber.Ctx) error {
+	var req struct {
+		Code string `json:"code"`
+		Name string `json:"name"`
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	req.Code, req.Name = strings.TrimSpace(req.Code), strings.TrimSpace(req.Name)
+	if req.Code == "" || req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "code and name required"})
+	}
+	if !isLedgerAccountType(req.Type) {
+		return c.Status(400).JSON(fiber.Map{"error": "type must be one of " + strings.Join(ledgerAccountTypes, ", ")})
+	}
+	orgID := currentOrgID(c)
+	taken, err := ledgerCodeTaken(dbFor(c), orgID, req.Code, "")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if taken {
+		return c.Status(409).JSON(fiber.Map{"error": "account code " + req.Code + " is already used"})
+	}
+	id := genID()
+	if _, err := dbFor(c).Exec(`INSERT INTO ledger_accounts (id,organization_id,code,name,type,active,created_at) VALUES (?,?,?,?,?,1,?)`,
+		id, orgID, req.Code, req.Name, req.Type, time.Now().Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+func handlePatchLedgerAccount(c *fiber.Ctx) error {
+	var req struct {
+		Code   *string `json:"code"`
+		Name   *string `json:"name"`
+		Active *bool   `json:"active"`
+		Type   *string `json:"type"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	var systemKey sql.NullString
+	if err := dbFor(c).QueryRow(`SELECT system_key FROM ledger_accounts WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&systemKey); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "account not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.Type != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "an account's type cannot be changed; create a new account instead"})
+	}
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name cannot be empty"})
+		}
+		updates["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.Code != nil {
+		code := strings.TrimSpace(*req.Code)
+		if code == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "code cannot be empty"})
+		}
+		taken, err := ledgerCodeTaken(dbFor(c), orgID, code, id)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if taken {
+			return c.Status(409).JSON(fiber.Map{"error": "account code " + code + " is already used"})
+		}
+		updates["code"] = code
+	}
+	if req.Active != nil {
+		if !*req.Active && systemKey.Valid {
+			return c.Status(409).JSON(fiber.Map{"error": "this account is posted to automatically and cannot be deactivated"})
+		}
+		active := 0
+		if *req.Active {
+			active = 1
+		}
+		updates["active"] = active
+	}
+	for _, field := range []string{"name", "code", "active"} {
+		if v, ok := updates[field]; ok {
+			if _, err := dbFor(c).Exec("UPDATE ledger_accounts SET "+field+" = ? WHERE id = ?", v, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChartOfAccounts(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`); err != nil {
+		t.Fatal(err)
+	}
+	seedAllLedgerAccounts()
+	seedAllLedgerAccounts() // seeding twice adds nothing
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	count := func(query string) int {
+		_, out := call("cashier", "GET", "/api/accounts"+query, "")
+		items, _ := out["items"].([]interface{})
+		return len(items)
+	}
+
+	if n := count(""); n != len(defaultLedgerAccounts) {
+		t.Fatalf("seeded %d accounts, want %d", n, len(defaultLedgerAccounts))
+	}
+	if code, _ := call("cashier", "POST", "/api/accounts", `{"code":"6100","name":"Rent","type":"expense"}`); code != 403 {
+		t.Errorf("cashier create: got %d, want 403", code)
+	}
+	if code, _ := call("manager", "POST", "/api/accounts", `{"code":"6100","name":"Rent","type":"cost"}`); code != 400 {
+		t.Errorf("bad type: got %d, want 400", code)
+	}
+	code, out := call("manager", "POST", "/api/accounts", `{"code":"6100","name":"Rent","type":"expense"}`)
+	if code != 200 {
+		t.Fatalf("create: %d %v", code, out)
+	}
+	rent := out["id"].(string)
+	if code, _ := call("manager", "POST", "/api/accounts", `{"code":"6100","name":"Utilities","type":"expense"}`); code != 409 {
+		t.Errorf("duplicate code: got %d, want 409", code)
+	}
+	if code, _ := call("manager", "PATCH", "/api/accounts/"+rent, `{"name":"Shop rent"}`); code != 200 {
+		t.Errorf("rename: got %d", code)
+	}
+	if code, _ := call("manager", "PATCH", "/api/accounts/"+rent, `{"type":"asset"}`); code != 400 {
+		t.Errorf("type change: got %d, want 400", code)
+	}
+	if code, _ := call("manager", "PATCH", "/api/accounts/"+rent, `{"active":false}`); code != 200 {
+		t.Errorf("deactivate: got %d", code)
+	}
+	if n, all := count("?type=expense"), count("?type=expense&include_inactive=true"); n != 1 || all != 2 {
+		t.Errorf("expense accounts: %d active, %d in all, want 1 and 2", n, all)
+	}
+
+	var sales string
+	if err := db.QueryRow(`SELECT id FROM ledger_accounts WHERE system_key = 'sales'`).Scan(&sales); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := call("admin", "PATCH", "/api/accounts/"+sales, `{"active":false}`); code != 409 {
+		t.Errorf("deactivating a system account: got %d, want 409", code)
+	}
+	if code, _ := call("admin", "PATCH", "/api/accounts/"+sales, `{"name":"Revenue","code":"4100"}`); code != 200 {
+		t.Errorf("renaming a system account: got %d", code)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Stock can be kept in several locations, a shop and a godown say. An
+// item's quantity stays its total across locations; item_locations holds
+// what is at each location except the organization's default one, and
+// whatever is not at another location is at the default. So everything
+// that moves stock without naming a location (and every organization
+// without locations) keeps working on the default location unchanged.
+//
+// Sales and purchases name a location_id, for the whole transaction or per
+// line; stock moves between locations with POST /api/locations/transfer.
+// Item list and get responses carry a per-location breakdown once the
+// organization has locations.
+
+func registerLocationRoutes(app *fiber.App) {
+	r := app.Group("/api/locations", requireAuth)
+	r.Get("/", handleListLocations)
+	r.Get("/:id/stock", handleLocationStock)
+	r.Post("/", requireRole("admin", "manager"), handleCreateLocation)
+	r.Post("/transfer", requireRole("admin", "manager"), handleTransferStock)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchLocation)
+	r.Delete("/:id", requireRole("admin", "manager"), handleDeleteLocation)
+}
+
+// defaultLocationID returns orgID's default location, or "" when it has
+// no locations.
+func defaultLocationID(q queryer, orgID string) (string, error) {
+	var id string
+	err := q.QueryRow(`SELECT id FROM locations WHERE organization_id = ? AND is_default = 1`, orgID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// locationQuantity returns how many of itemID are at locationID.
+func locationQuantity(tx *Tx, orgID, itemID, locationID string) (int, error) {
+	defaultID, err := defaultLocationID(tx, orgID)
+	if err != nil {
+		return 0, err
+	}
+	if locationID == defaultID {
+		var total, elsewhere int
+		err := tx.QueryRow(`SELECT quantity, COALESCE((SELECT SUM(quantity) FROM item_locations WHERE item_id = inventory_items.id), 0) FROM inventory_items WHERE id = ?`, itemID).Scan(&total, &elsewhere)
+		return total - elsewhere, err
+	}
+	var qty int
+	err = tx.QueryRow(`SELECT quantity FROM item_locations WHERE item_id = ? AND location_id = ?`, itemID, locationID).Scan(&qty)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return qty, err
+}
+
+// moveLocationStock records that change units of itemID went into (or,
+// negative, out of) locationID. The item's total is the caller's to
+// update. Stock at the default location follows from the total, so only
+// other locations are recorded, and they cannot go below zero.
+func moveLocationStock(tx *Tx, orgID, itemID, locationID string, change int) (int, error) {
+	if locationID == "" || change == 0 {
+		return 0, nil
+	}
+	defaultID, err := defaultLocationID(tx, orgID)
+	if err != nil {
+		return 500, err
+	}
+	if locationID == defaultID {
+		return 0, nil
+	}
+	if _, err := tx.Exec(`INSERT INTO item_locations (item_id,location_id,quantity) VALUES (?,?,?) ON CONFLICT(item_id,location_id) DO UPDATE SET quantity = item_locations.quantity + excluded.quantity`, itemID, locationID, change); err != nil {
+		return 500, err
+	}
+	var qty int
+	if err := tx.QueryRow(`SELECT quantity FROM item_locations WHERE item_id = ? AND location_id = ?`, itemID, locationID).Scan(&qty); err != nil {
+		return 500, err
+	}
+	if qty < 0 {
+		var name string
+		if err := tx.QueryRow(`SELECT name FROM locations WHERE id = ?`, locationID).Scan(&name); err != nil {
+			return 500, err
+		}
+		return 409, fiber.NewError(409, fmt.Sprintf("not enough stock at %s (%d short)", name, -qty))
+	}
+	return 0, nil
+}
+
+// itemLocationBreakdown returns, per item, its quantity at each of orgID's
+// locations; totals gives each item's total quantity. It returns nil when
+// the organization has no locations.
+func itemLocationBreakdown(q *DB, orgID string, totals map[string]int) (map[string][]fiber.Map, error) {
+	type location struct {
+		id, name  string
+		isDefault bool
+	}
+	rows, err := q.Query(`SELECT id, name, is_default FROM locations WHERE organization_id = ? ORDER BY is_default DESC, name`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	var locations []location
+	for rows.Next() {
+		var l location
+		if err := rows.Scan(&l.id, &l.name, &l.isDefault); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		locations = append(locations, l)
+	}
+	rows.Close()
+	if len(locations) == 0 || len(totals) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(totals))
+	args := []interface{}{}
+	for id := range totals {
+		ids = append(ids, "?")
+		args = append(args, id)
+	}
+	rows, err = q.Query(`SELECT item_id, location_id, quantity FROM item_locations WHERE item_id IN (`+strings.Join(ids, ",")+`)`, args...)
+	if err != nil {
+		return nil, err
+	}
+	held := map[string]map[string]int{}
+	for rows.Next() {
+		var itemID, locationID string
+		var qty int
+		if err := rows.Scan(&itemID, &locationID, &qty); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if held[itemID] == nil {
+			held[itemID] = map[string]int{}
+		}
+		held[itemID][locationID] = qty
+	}
+	rows.Close()
+
+	out := map[string][]fiber.Map{}
+	for itemID, total := range totals {
+		atDefault := total
+		for _, qty := range held[itemID] {
+			atDefault -= qty
+		}
+		lines := []fiber.Map{}
+		for _, l := range locations {
+			qty := held[itemID][l.id]
+			if l.isDefault {
+				qty = atDefault
+			}
+			lines = append(lines, fiber.Map{"location_id": l.id, "name": l.name, "quantity": qty})
+		}
+		out[itemID] = lines
+	}
+	return out, nil
+}
+
+// attachLocationBreakdown adds a locations list to each item record.
+func attachLocationBreakdown(q *DB, orgID string, items []map[string]interface{}) error {
+	totals := map[string]int{}
+	for _, it := range items {
+		q, _ := strconv.ParseFloat(toString(it["quantity"]), 64)
+		totals[toString(it["id"])] = int(q)
+	}
+	breakdown, err := itemLocationBreakdown(q, orgID, totals)
+	if err != nil || breakdown == nil {
+		return err
+	}
+	for _, it := range items {
+		it["locations"] = breakdown[toString(it["id"])]
+	}
+	return nil
+}
+
+// handleListLocations lists the locations with the units held at each.
+func handleListLocations(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	rows, err := dbFor(c).Query(`SELECT l.id, l.name, COALESCE(l.code, ''), l.is_default, l.created_at, COALESCE((SELECT SUM(il.quantity) FROM item_locations il WHERE il.location_id = l.id), 0)
+		FROM locations l WHERE l.organization_id = ? ORDER BY l.is_default DESC, l.name`, orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items := []fiber.Map{}
+	var atDefault fiber.Map
+	elsewhere := 0
+	for rows.Next() {
+		var id, name, code, createdAt string
+		var isDefault bool
+		var units int
+		if err := rows.Scan(&id, &name, &code, &isDefault, &createdAt, &units); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		item := fiber.Map{"id": id, "name": name, "code": code, "is_default": isDefault, "created_at": createdAt, "units": units}
+		if isDefault {
+			atDefault = item
+		} else {
+			elsewhere += units
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if atDefault != nil {
+		var total int
+		if err := dbFor(c).QueryRow(`SELECT COALESCE(SUM(quantity), 0) FROM inventory_items WHERE organization_id = ?`, orgID).Scan(&total); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		atDefault["units"] = total - elsewhere
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleLocationStock lists the items held at a location.
+func handleLocationStock(c *fiber.Ctx) error {
+	id, orgID := c.Params("id"), currentOrgID(c)
+	if !orgOwns(dbFor(c), "locations", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "location not found"})
+	}
+	defaultID, err := defaultLocationID(dbFor(c), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	query := `SELECT i.id, COALESCE(i.name, 'Unnamed Item') AS name, i.sku, l.quantity FROM item_locations l JOIN inventory_items i ON i.id = l.item_id WHERE l.location_id = ? AND l.quantity <> 0 ORDER BY i.name`
+	if id == defaultID {
+		query = `SELECT i.id, COALESCE(i.name, 'Unnamed Item') AS name, i.sku, i.quantity - COALESCE((SELECT SUM(l.quantity) FROM item_locations l WHERE l.item_id = i.id), 0) AS quantity
+			FROM inventory_items i WHERE i.organization_id = ? AND i.quantity - COALESCE((SELECT SUM(l.quantity) FROM item_locations l WHERE l.item_id = i.id), 0) <> 0 ORDER BY i.name`
+		id = orgID
+	}
+	rows, err := dbFor(c).Query(query, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleCreateLocation adds a location. An organization's first location
+// is its default and starts out holding all its stock.
+func handleCreateLocation(c *fiber.Ctx) error {
+	var req struct {
+		Name string `json:"name"`
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name required"})
+	}
+	orgID := currentOrgID(c)
+	defaultID, err := defaultLocationID(dbFor(c), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	isDefault := 0
+	if defaultID == "" {
+		isDefault = 1
+	}
+	id := genID()
+	if _, err := dbFor(c).Exec(`INSERT INTO locations (id,organization_id,name,code,is_default,created_at) VALUES (?,?,?,NULLIF(?, ''),?,?)`,
+		id, orgID, req.Name, strings.TrimSpace(req.Code), isDefault, time.Now().Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(fiber.Map{"id": id, "name": req.Name, "is_default": isDefault == 1})
+}
+
+// handlePatchLocation renames a location or makes it the default. Stock
+// stays where it is when the default changes.
+func handlePatchLocation(c *fiber.Ctx) error {
+	var req struct {
+		Name      *string `json:"name"`
+		Code      *string `json:"code"`
+		IsDefault *bool   `json:"is_default"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	if !orgOwns(dbFor(c), "locations", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "location not found"})
+	}
+	if req.IsDefault != nil && !*req.IsDefault {
+		return c.Status(400).JSON(fiber.Map{"error": "make another location the default instead"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name cannot be empty"})
+		}
+		if _, err := tx.Exec(`UPDATE locations SET name = ? WHERE id = ?`, strings.TrimSpace(*req.Name), id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if req.Code != nil {
+		if _, err := tx.Exec(`UPDATE locations SET code = NULLIF(?, '') WHERE id = ?`, strings.TrimSpace(*req.Code), id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	old, err := defaultLocationID(tx, orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.IsDefault != nil && old != id {
+		// the old default's stock gets rows of its own and the new
+		// default's rows fold into what follows from the totals
+		if _, err := tx.Exec(`INSERT INTO item_locations (item_id,location_id,quantity)
+			SELECT i.id, ?, i.quantity - COALESCE((SELECT SUM(l.quantity) FROM item_locations l WHERE l.item_id = i.id), 0)
+			FROM inventory_items i WHERE i.organization_id = ?`, old, orgID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := tx.Exec(`DELETE FROM item_locations WHERE location_id = ?`, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := tx.Exec(`UPDATE locations SET is_default = CASE WHEN id = ? THEN 1 ELSE 0 END WHERE organization_id = ?`, id, orgID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+// handleDeleteLocation removes an empty location other than the default.
+func handleDeleteLocation(c *fiber.Ctx) error {
+	id, orgID := c.Params("id"), currentOrgID(c)
+	if !orgOwns(dbFor(c), "locations", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "location not found"})
+	}
+	defaultID, err := defaultLocationID(dbFor(c), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if id == defaultID {
+		return c.Status(409).JSON(fiber.Map{"error": "the default location cannot be deleted; make another location the default first"})
+	}
+	var units int
+	if err := dbFor(c).QueryRow(`SELECT COALESCE(SUM(quantity), 0) FROM item_locations WHERE location_id = ?`, id).Scan(&units); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if units != 0 {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("%d units are still at this location; transfer them first", units)})
+	}
+	for _, q := range []string{`DELETE FROM item_locations WHERE location_id = ?`, `DELETE FROM locations WHERE id = ?`} {
+		if _, err := dbFor(c).Exec(q, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	return c.SendStatus(204)
+}
+
+// handleTransferStock moves units of an item from one location to
+// another, recording a transfer movement at each end.
+func handleTransferStock(c *fiber.Ctx) error {
+	var req struct {
+		ItemID   string `json:"item_id"`
+		From     string `json:"from_location_id"`
+		To       string `json:"to_location_id"`
+		Quantity int    `json:"quantity"`
+		Notes    string `json:"notes"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	if req.Quantity <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "quantity must be more than zero"})
+	}
+	if req.From == req.To {
+		return c.Status(400).JSON(fiber.Map{"error": "from and to locations must differ"})
+	}
+	if !orgOwns(dbFor(c), "inventory_items", req.ItemID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "unknown item"})
+	}
+	if !orgOwns(dbFor(c), "locations", req.From, orgID) || !orgOwns(dbFor(c), "locations", req.To, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "unknown location"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	available, err := locationQuantity(tx, orgID, req.ItemID, req.From)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if available < req.Quantity {
+		return c.Status(409).JSON(fiber.Map{"error": fmt.Sprintf("only %d at the source location", available)})
+	}
+	var total int
+	if err := tx.QueryRow(`SELECT quantity FROM inventory_items WHERE id = ?`, req.ItemID).Scan(&total); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now().Format(time.RFC3339)
+	for _, leg := range []struct {
+		location string
+		change   int
+	}{{req.From, -req.Quantity}, {req.To, req.Quantity}} {
+		if status, err := moveLocationStock(tx, orgID, req.ItemID, leg.location, leg.change); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := tx.Exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,location_id,organization_id,created_by,created_at) VALUES (?,?,?,?,?,'transfer',?,?,?,?,?)`,
+			genID(), req.ItemID, leg.change, total, total, req.Notes, leg.location, orgID, currentUserID(c), now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "inventory_items", "update", req.ItemID)
+	return c.JSON(fiber.Map{"item_id": req.ItemID, "from_location_id": req.From, "to_location_id": req.To, "quantity": req.Quantity})
+}
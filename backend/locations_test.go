@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestStockLocations(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	// breakdown returns the item's quantity per location name and its total
+	breakdown := func() (map[string]float64, float64) {
+		t.Helper()
+		_, item := call("GET", "/api/collections/inventory_items/records/i-1", "")
+		at := map[string]float64{}
+		for _, l := range item["locations"].([]interface{}) {
+			l := l.(map[string]interface{})
+			at[l["name"].(string)] = l["quantity"].(float64)
+		}
+		return at, item["quantity"].(float64)
+	}
+	check := func(step string, shop, godown, total float64) {
+		t.Helper()
+		at, got := breakdown()
+		if at["Shop"] != shop || at["Godown"] != godown || got != total {
+			t.Errorf("%s: shop %v godown %v total %v, want %v %v %v", step, at["Shop"], at["Godown"], got, shop, godown, total)
+		}
+	}
+
+	if _, item := call("GET", "/api/collections/inventory_items/records/i-1", ""); item["locations"] != nil {
+		t.Errorf("locations without any set up: %v", item["locations"])
+	}
+	_, shop := call("POST", "/api/locations", `{"name":"Shop"}`)
+	_, godown := call("POST", "/api/locations", `{"name":"Godown","code":"GD"}`)
+	if shop["is_default"] != true || godown["is_default"] != false {
+		t.Fatalf("first location should be the default: %v %v", shop, godown)
+	}
+	shopID, godownID := shop["id"].(string), godown["id"].(string)
+	check("existing stock", 10, 0, 10)
+
+	sale := func(location string, quantity int) int {
+		t.Helper()
+		body := `{"type":"inflow","amount":30,"paid_amount":30,"due_amount":0,"contact_id":"c-1","location_id":"` + location + `","items":[{"item_id":"i-1","quantity":` + strconv.Itoa(quantity) + `,"unit_price":15}]}`
+		code, _ := call("POST", "/api/collections/transactions/records", body)
+		return code
+	}
+	if code, _ := call("POST", "/api/collections/transactions/records", `{"type":"outflow","amount":50,"paid_amount":50,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":5,"unit_price":10,"location_id":"`+godownID+`"}]}`); code != 200 {
+		t.Fatalf("purchase into the godown: got %d", code)
+	}
+	check("purchase", 10, 5, 15)
+	if code := sale(godownID, 7); code != 409 {
+		t.Errorf("selling more than the godown holds: got %d, want 409", code)
+	}
+	check("refused sale", 10, 5, 15)
+	if code := sale("nowhere", 1); code != 400 {
+		t.Errorf("unknown location: got %d, want 400", code)
+	}
+
+	if code, _ := call("POST", "/api/locations/transfer", `{"item_id":"i-1","from_location_id":"`+shopID+`","to_location_id":"`+godownID+`","quantity":11}`); code != 409 {
+		t.Errorf("transferring more than the shop holds: got %d, want 409", code)
+	}
+	if code, _ := call("POST", "/api/locations/transfer", `{"item_id":"i-1","from_location_id":"`+shopID+`","to_location_id":"`+godownID+`","quantity":3}`); code != 200 {
+		t.Fatalf("transfer: got %d", code)
+	}
+	check("transfer", 7, 8, 15)
+	if code := sale(godownID, 2); code != 200 {
+		t.Fatalf("sale from the godown: got %d", code)
+	}
+	check("sale", 7, 6, 13)
+
+	_, list := call("GET", "/api/locations", "")
+	units := map[string]float64{}
+	for _, l := range list["items"].([]interface{}) {
+		l := l.(map[string]interface{})
+		units[l["name"].(string)] = l["units"].(float64)
+	}
+	if units["Shop"] != 7 || units["Godown"] != 6 {
+		t.Errorf("location units: %v", units)
+	}
+	_, items := call("GET", "/api/collections/inventory_items/records", "")
+	if locs := items["items"].([]interface{})[0].(map[string]interface{})["locations"].([]interface{}); len(locs) != 2 {
+		t.Errorf("item list breakdown: %v", locs)
+	}
+
+	// stock stays put when the default changes
+	if code, _ := call("PATCH", "/api/locations/"+godownID, `{"is_default":true}`); code != 200 {
+		t.Fatalf("change default: got %d", code)
+	}
+	check("new default", 7, 6, 13)
+	if code, _ := call("DELETE", "/api/locations/"+godownID, ""); code != 409 {
+		t.Errorf("deleting the default: got %d, want 409", code)
+	}
+	if code, _ := call("DELETE", "/api/locations/"+shopID, ""); code != 409 {
+		t.Errorf("deleting a location holding stock: got %d, want 409", code)
+	}
+
+	// voiding the sale puts the units back where they came from
+	_, preview := call("POST", "/api/bulk/transactions", `{"action":"void","filter":"type = 'inflow'"}`)
+	if code, out := call("POST", "/api/bulk/transactions", `{"action":"void","filter":"type = 'inflow'","dry_run":false,"confirm_token":"`+toString(preview["confirm_token"])+`"}`); code != 200 {
+		t.Fatalf("void: %d %v", code, out)
+	}
+	check("void", 7, 8, 15)
+}
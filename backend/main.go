@@ -4,10 +4,10 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,7 +18,7 @@ import (
 	_ "modernc.org/sqlite"
 )
 
-var db *sql.DB
+var db *DB
 
 func must(err error) {
 	if err != nil {
@@ -26,21 +26,18 @@ func must(err error) {
 	}
 }
 
-func initDB(path string) *sql.DB {
-	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-		log.Fatal(err)
-	}
-	db, err := sql.Open("sqlite", path)
-	must(err)
-	// run migrations
-	migration, err := os.ReadFile("migrate.sql")
-	must(err)
-	_, err = db.Exec(string(migration))
-	must(err)
+func initDB(d dialect, dsn string) *DB {
+	db := openDB(d, dsn)
+	must(migrateUp(db))
 	return db
 }
 
+// seedIfEmpty loads a sample customer, item and sale into an empty
+// database when SEED_DEMO_DATA is true; otherwise a new business starts
+// empty and is walked through /api/setup (setup.go). Either way it tidies
+// items left by old versions.
 func seedIfEmpty() {
+	demo := os.Getenv("SEED_DEMO_DATA") == "true"
 	// check contacts
 	var cnt int
 	err := db.QueryRow(`SELECT COUNT(1) FROM contacts`).Scan(&cnt)
@@ -48,43 +45,56 @@ func seedIfEmpty() {
 		// assume table missing or empty; ignore
 		return
 	}
+	exec := func(query string, args ...interface{}) {
+		if _, err := db.Exec(query, args...); err != nil {
+			log.Printf("seed: %v", err)
+		}
+	}
+	scan := func(query string, dest interface{}) {
+		if err := db.QueryRow(query).Scan(dest); err != nil {
+			log.Printf("seed: %v", err)
+		}
+	}
 
 	var idContact string
-	if cnt == 0 {
+	if cnt == 0 && demo {
 		idContact = genID()
-		_, _ = db.Exec(`INSERT INTO contacts (id,name,phone,type) VALUES (?,?,?,?)`, idContact, "Test Customer", "+1234567890", "customer")
-	} else {
+		exec(`INSERT INTO contacts (id,name,phone,type) VALUES (?,?,?,?)`, idContact, "Test Customer", "+1234567890", "customer")
+	} else if cnt > 0 {
 		// get existing contact
-		_ = db.QueryRow(`SELECT id FROM contacts LIMIT 1`).Scan(&idContact)
+		scan(`SELECT id FROM contacts LIMIT 1`, &idContact)
 	}
 
 	// check inventory_items
 	var itemCnt int
-	_ = db.QueryRow(`SELECT COUNT(1) FROM inventory_items`).Scan(&itemCnt)
+	scan(`SELECT COUNT(1) FROM inventory_items`, &itemCnt)
 
 	var idItem string
-	if itemCnt == 0 {
+	if itemCnt == 0 && demo {
 		idItem = genID()
 		now := time.Now().Format(time.RFC3339)
-		_, _ = db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,reorder_level,category,description,updated_at,created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`, idItem, "Sample Item", "SAMPLE1", 10, 9.99, 2, "General", "Seeded item", now, now)
-		_, _ = db.Exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,created_at) VALUES (?,?,?,?,?,?,?,?)`, genID(), idItem, 10, 0, 10, "initial", "Seeded", time.Now().Format(time.RFC3339))
-	} else {
+		exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,reorder_level,category,description,updated_at,created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`, idItem, "Sample Item", "SAMPLE1", 10, 9.99, 2, "General", "Seeded item", now, now)
+		exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,created_at) VALUES (?,?,?,?,?,?,?,?)`, genID(), idItem, 10, 0, 10, "initial", "Seeded", time.Now().Format(time.RFC3339))
+	} else if itemCnt > 0 {
 		// get existing item
-		_ = db.QueryRow(`SELECT id FROM inventory_items LIMIT 1`).Scan(&idItem)
+		scan(`SELECT id FROM inventory_items LIMIT 1`, &idItem)
 		// Fix any items with blank names
-		_, _ = db.Exec(`UPDATE inventory_items SET name = 'Unnamed Item' WHERE name IS NULL OR name = ''`)
+		exec(`UPDATE inventory_items SET name = 'Unnamed Item' WHERE name IS NULL OR name = ''`)
 		// Backfill updated_at for existing items
-		_, _ = db.Exec(`UPDATE inventory_items SET updated_at = created_at WHERE updated_at IS NULL OR updated_at = ''`)
+		exec(`UPDATE inventory_items SET updated_at = created_at WHERE updated_at IS NULL OR updated_at = ''`)
 	}
 
 	// check transactions
 	var transCnt int
-	_ = db.QueryRow(`SELECT COUNT(1) FROM transactions`).Scan(&transCnt)
+	scan(`SELECT COUNT(1) FROM transactions`, &transCnt)
 
-	if transCnt == 0 {
+	if transCnt == 0 && demo {
 		idTransaction := genID()
-		_, _ = db.Exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,created_at) VALUES (?,?,?,?,?,?,?)`, idTransaction, "inflow", 100.0, 100.0, 0.0, idContact, time.Now().Format(time.RFC3339))
-		_, _ = db.Exec(`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES (?,?,?,?,?,?)`, genID(), idTransaction, idItem, 10, 9.99, 99.9)
+		exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,created_at) VALUES (?,?,?,?,?,?,?)`, idTransaction, "inflow", moneyOf(100), moneyOf(100), money(0), idContact, time.Now().Format(time.RFC3339))
+		exec(`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES (?,?,?,?,?,?)`, genID(), idTransaction, idItem, 10, moneyOf(9.99), moneyOf(99.9))
+		if err := refreshItemReadModel(idItem); err != nil {
+			log.Printf("seed: %v", err)
+		}
 	}
 }
 
@@ -92,20 +102,129 @@ func genID() string {
 	return uuid.New().String()
 }
 
+// parseTime accepts either an RFC3339 timestamp or a plain YYYY-MM-DD date.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// toString renders a scanned column value as text; NULL becomes "".
+func toString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// rowsToMaps scans every row into a column-name keyed map, converting
+// []byte values to strings so they serialize as JSON text.
+func rowsToMaps(rows *sql.Rows) ([]map[string]interface{}, error) {
+	scan, err := rowMapScanner(rows)
+	if err != nil {
+		return nil, err
+	}
+	out := []map[string]interface{}{}
+	for rows.Next() {
+		m, err := scan()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// rowMapScanner returns a function scanning the current row of rows into
+// a map the way rowsToMaps does.
+func rowMapScanner(rows *sql.Rows) (func() (map[string]interface{}, error), error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	return func() (map[string]interface{}, error) {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		m := map[string]interface{}{}
+		for i, col := range cols {
+			if b, ok := vals[i].([]byte); ok {
+				m[col] = string(b)
+				// PostgreSQL returns SUM and AVG over integers as NUMERIC
+				// text; a whole number stays one, like SQLite's sums
+				if types[i].DatabaseTypeName() == "NUMERIC" {
+					if n, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+						m[col] = n
+					} else if f, err := strconv.ParseFloat(string(b), 64); err == nil {
+						m[col] = f
+					}
+				}
+			} else {
+				m[col] = vals[i]
+			}
+		}
+		return m, nil
+	}, nil
+}
+
 func main() {
-	db = initDB("./data/db.sqlite")
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrateCommand(os.Args[2:])
+		return
+	}
+	db = initDB(dbConfig())
 	seedIfEmpty()
+	assignOrphanRecords()
+	seedAllPaymentMethods()
+	seedAllLedgerAccounts()
+	initAuth()
+	startExchangeRateFetcher()
+	startStorageGC()
+	startCampaignSender()
+	startLowStockMonitor()
+	startStockSnapshotter()
+	startDigestSender()
+	startDueReminders()
 	defer db.Close()
 
-	app := fiber.New()
+	app := newApp()
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3000"
+	}
+	log.Printf("Starting server on :%s\n", port)
+	must(app.Listen(fmt.Sprintf(":%s", port)))
+}
+
+// newApp builds the HTTP app with every route. Apart from /api/auth and
+// /api/health, every /api route requires a login.
+func newApp() *fiber.App {
+	// behind a reverse proxy, PROXY_IP_HEADER (e.g. X-Forwarded-For) names
+	// the header with the client's address, for ip_allowlist
+	app := fiber.New(fiber.Config{ProxyHeader: os.Getenv("PROXY_IP_HEADER")})
 	app.Use(cors.New())
 	app.Use(logger.New())
-
-	// serve uploaded files
-	app.Static("/api/files", "./uploads")
+	app.Use(requestDeadline)
+	app.Use(serializeWrites())
+	app.Use(trackWrites)
+	app.Use(maskHiddenFields)
 
 	// collection style endpoints to match adapter paths
-	api := app.Group("/api/collections")
+	api := app.Group("/api/collections", requireAuth, authorizeCollection)
 
 	api.Get("/:collection/records", handleList)
 	api.Get("/:collection/records/:id", handleGet)
@@ -115,16 +234,82 @@ func main() {
 
 	// file upload to record
 	api.Post("/:collection/records/:id/files/:field", handleUploadFile)
+	api.Post("/:collection/import", handleImport)
+
+	registerAuthRoutes(app)
+	registerUserRoutes(app)
+	registerMembershipRoutes(app)
+	registerInviteRoutes(app)
+	registerDeviceRoutes(app)
+	registerRentalRoutes(app)
+	registerConsignmentRoutes(app)
+	registerOpeningBalanceRoutes(app)
+	registerFiscalYearRoutes(app)
+	registerReportRoutes(app)
+	registerReportCacheRoutes(app)
+	registerAnalyticsRoutes(app)
+	registerPricingRoutes(app)
+	registerPurchaseImportRoutes(app)
+	registerVCardRoutes(app)
+	registerSettingsRoutes(app)
+	registerOrganizationRoutes(app)
+	registerSequenceRoutes(app)
+	registerExchangeRateRoutes(app)
+	registerPaymentRoutes(app)
+	registerCashAccountRoutes(app)
+	registerDepositRoutes(app)
+	registerBulkRoutes(app)
+	registerOpenAPIRoutes(app)
+	registerStorageRoutes(app)
+	registerRealtimeRoutes(app)
+	registerUploadRoutes(app)
+	registerInvoiceRoutes(app)
+	registerLedgerAccountRoutes(app)
+	registerQueryPlanRoutes(app)
+	registerCampaignRoutes(app)
+	registerPurchaseOrderRoutes(app)
+	registerExpenseRoutes(app)
+	registerQuotationRoutes(app)
+	registerRecurringExpenseRoutes(app)
+	registerCashForecastRoutes(app)
+	registerSupplierRoutes(app)
+	registerToolRoutes(app)
+	registerSettlementRoutes(app)
+	registerAlertRoutes(app)
+	registerStocktakeRoutes(app)
+	registerLocationRoutes(app)
+	registerBatchRoutes(app)
+	registerUnitRoutes(app)
+	registerStockFeedRoutes(app)
+	registerBundleRoutes(app)
+	registerBarcodeRoutes(app)
+	registerPromotionRoutes(app)
+	registerMinPriceRoutes(app)
+	registerPriceListRoutes(app)
+	registerReceiptBlockRoutes(app)
+	registerTaxRoutes(app)
+	registerItemCodeRoutes(app)
+	registerOrderRoutes(app)
+	registerContactPaymentRoutes(app)
+	registerKitchenTicketRoutes(app)
+	registerEmailInboxRoutes(app)
+	registerVoidRoutes(app)
+	registerTransactionEditRoutes(app)
+	registerRestHookRoutes(app)
+	registerAccountantShareRoutes(app)
+	registerCreditNoteRoutes(app)
+	registerTallyRoutes(app)
+	registerStockSnapshotRoutes(app)
+	registerDigestRoutes(app)
+	registerInvoiceEmailRoutes(app)
+	registerSetupRoutes(app)
+	registerDueReminderRoutes(app)
+	registerMobileMoneyRoutes(app)
+	registerPaymentLinkRoutes(app)
 
 	// simple listing endpoints for compatibility
 	app.Get("/api/health", func(c *fiber.Ctx) error { return c.JSON(fiber.Map{"ok": true}) })
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
-	}
-	log.Printf("Starting server on :%s\n", port)
-	must(app.Listen(fmt.Sprintf(":%s", port)))
+	return app
 }
 
 // ---------- Handlers ----------
@@ -134,106 +319,114 @@ func handleList(c *fiber.Ctx) error {
 	// support query params: perPage, filter (very basic), sort, expand
 	queryFilter := c.Query("filter")
 	expand := c.Query("expand")
+	if f := hiddenFieldIn(queryFilter+" "+c.Query("sort"), hiddenFields(currentOrgID(c), currentRole(c))); f != "" {
+		return c.Status(403).JSON(fiber.Map{"error": "your role cannot see " + f})
+	}
 	sqlQuery := ""
+	// column qualifier for queries that join other tables
+	qualifier := ""
 	switch collection {
 	case "contacts":
-		sqlQuery = "SELECT id,name,phone,nid,type,organization_id FROM contacts"
+		sqlQuery = "SELECT id,name,phone,email,nid,type,price_list_id,sms_opt_out,organization_id FROM contacts"
 	case "inventory_items":
-		sqlQuery = "SELECT id,COALESCE(name, 'Unnamed Item') as name,sku,quantity,unit_price,reorder_level,category,description,image_filename,image_url,updated_at,created_at FROM inventory_items"
+		sqlQuery = "SELECT id,COALESCE(name, 'Unnamed Item') as name,sku,quantity,unit,unit_price,reorder_level,category,description,image_filename,image_url,cost_price,min_sale_price,tax_rate_id,supplier_id,rental_stock,rental_rate,late_fee_rate,parent_id,variant_options,updated_at,created_at FROM inventory_items"
 	case "inventory_transactions":
 		sqlQuery = "SELECT id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,created_at FROM inventory_transactions"
 	case "transactions":
-		if strings.Contains(expand, "contact") {
-			sqlQuery = "SELECT t.id,t.type,t.amount,t.paid_amount,t.due_amount,t.contact_id,t.image_filename,t.image_url,t.created_at, c.id as contact__id, c.name as contact__name, c.phone as contact__phone, c.nid as contact__nid, c.type as contact__type, c.organization_id as contact__organization_id FROM transactions t LEFT JOIN contacts c ON t.contact_id = c.id"
-		} else {
-			sqlQuery = "SELECT id,type,amount,paid_amount,due_amount,contact_id,image_filename,image_url,created_at FROM transactions"
+		// contact_name and items_summary are kept on the row; see read_model.go
+		sqlQuery = "SELECT id,type,amount,paid_amount,due_amount,contact_id,contact_name,payment_method,receipt_number,receipt_number AS invoice_no,tax_amount,currency,currency_amount,order_status,order_token,order_table,image_filename,image_url,voided_at,created_at FROM transactions"
+		if strings.Contains(expand, "items") {
+			sqlQuery = "SELECT id,type,amount,paid_amount,due_amount,contact_id,contact_name,payment_method,receipt_number,receipt_number AS invoice_no,tax_amount,currency,currency_amount,order_status,order_token,order_table,image_filename,image_url,voided_at,created_at,items_summary FROM transactions"
 		}
+	case "payment_methods":
+		sqlQuery = "SELECT id,code,name,active,account_id,fee_percent,fee_fixed,settlement_days,created_at FROM payment_methods"
 	default:
 		return c.Status(404).JSON(fiber.Map{"error": "unknown collection"})
 	}
-	if queryFilter != "" {
-		// very naive: assume simple form like field="value" or field = "value"
-		// we will remove any double quotes and use LIKE
-		sqlQuery = sqlQuery + " WHERE " + queryFilter
+	var conditions []string
+	var args []interface{}
+	if isTenantTable(collection) {
+		conditions = append(conditions, qualifier+"organization_id = ?")
+		args = append(args, currentOrgID(c))
 	}
-	sort := c.Query("sort")
-	if sort != "" {
-		if strings.HasPrefix(sort, "-") {
-			sort = strings.TrimPrefix(sort, "-") + " DESC"
-		} else {
-			sort = sort + " ASC"
+	// one row per product, variants under their parent; see variants.go
+	groupVariants := collection == "inventory_items" && c.Query("group") == "variants"
+	if groupVariants {
+		conditions = append(conditions, "parent_id IS NULL")
+	}
+	if queryFilter != "" {
+		where, filterArgs, err := parseFilter(queryFilter, collection, qualifier)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid filter: " + err.Error()})
 		}
-		sqlQuery = sqlQuery + " ORDER BY " + sort
+		conditions = append(conditions, "("+where+")")
+		args = append(args, filterArgs...)
+	}
+	if len(conditions) > 0 {
+		sqlQuery = sqlQuery + " WHERE " + strings.Join(conditions, " AND ")
 	}
-	rows, err := db.Query(sqlQuery)
+	page, perPage, err := listPaging(c, collection)
 	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	var totalItems int
+	if err := dbFor(c).PreparedQueryRow("SELECT COUNT(1) FROM ("+sqlQuery+") AS page", args...).Scan(&totalItems); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	defer rows.Close()
-	items := []map[string]interface{}{}
-	cols, _ := rows.Columns()
-	for rows.Next() {
-		vals := make([]interface{}, len(cols))
-		ptrs := make([]interface{}, len(cols))
-		for i := range vals {
-			ptrs[i] = &vals[i]
+	if sort := c.Query("sort"); sort != "" {
+		orderBy, err := parseSort(sort, collection, qualifier)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid sort: " + err.Error()})
 		}
-		_ = rows.Scan(ptrs...)
-		m := map[string]interface{}{}
-		for i, col := range cols {
-			v := vals[i]
-			b, ok := v.([]byte)
-			if ok {
-				m[col] = string(b)
-			} else {
-				m[col] = v
-			}
-		}
-		// handle expand
-		if collection == "transactions" && strings.Contains(expand, "contact") {
-			m["contact"] = map[string]interface{}{
-				"id":              m["contact__id"],
-				"name":            m["contact__name"],
-				"phone":           m["contact__phone"],
-				"nid":             m["contact__nid"],
-				"type":            m["contact__type"],
-				"organization_id": m["contact__organization_id"],
-			}
-			// remove the prefixed fields
-			delete(m, "contact__id")
-			delete(m, "contact__name")
-			delete(m, "contact__phone")
-			delete(m, "contact__nid")
-			delete(m, "contact__type")
-			delete(m, "contact__organization_id")
-		}
-		if collection == "transactions" && strings.Contains(expand, "items") {
-			transactionId := m["id"]
-			itemRows, err := db.Query(`SELECT ti.quantity, ti.unit_price, ti.total_price, i.id as item_id, COALESCE(i.name, 'Unnamed Item') as item_name, i.sku as item_sku FROM transaction_items ti LEFT JOIN inventory_items i ON ti.item_id = i.id WHERE ti.transaction_id = ?`, transactionId)
-			if err == nil {
-				var items []map[string]interface{}
-				for itemRows.Next() {
-					var quantity int
-					var unitPrice, totalPrice float64
-					var itemId, itemName, itemSku string
-					_ = itemRows.Scan(&quantity, &unitPrice, &totalPrice, &itemId, &itemName, &itemSku)
-					items = append(items, map[string]interface{}{
-						"item_id":     itemId,
-						"item_name":   itemName,
-						"name":        itemName,
-						"sku":         itemSku,
-						"quantity":    quantity,
-						"unit_price":  unitPrice,
-						"total_price": totalPrice,
-					})
+		if orderBy != "" {
+			sqlQuery = sqlQuery + " ORDER BY " + orderBy
+		}
+	}
+	sqlQuery = sqlQuery + " LIMIT ? OFFSET ?"
+	args = append(args, perPage, (page-1)*perPage)
+	rows, err := dbFor(c).PreparedQuery(sqlQuery, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	orgID := currentOrgID(c)
+	// related records are attached a streamed chunk at a time, after the
+	// handler has returned, so through the request's database taken now
+	q := dbFor(c)
+	prepare := func(items []map[string]interface{}) error {
+		switch collection {
+		case "transactions":
+			moneyColumns(items, "amount", "paid_amount", "due_amount", "tax_amount", "currency_amount")
+			if strings.Contains(expand, "items") {
+				for _, m := range items {
+					m["items"] = itemsSummary(m["items_summary"])
+					delete(m, "items_summary")
 				}
-				itemRows.Close()
-				m["items"] = items
 			}
+			if strings.Contains(expand, "payments") {
+				if err := attachTransactionPayments(q, items); err != nil {
+					return err
+				}
+			}
+			if strings.Contains(expand, "contact") {
+				return attachTransactionContacts(q, items)
+			}
+		case "contacts":
+			for _, m := range items {
+				m["sms_opt_out"] = toString(m["sms_opt_out"]) == "1"
+			}
+		case "inventory_items":
+			decodeVariantOptions(items)
+			if groupVariants {
+				if err := attachVariants(q, items); err != nil {
+					return err
+				}
+			}
+			return attachLocationBreakdown(q, orgID, items)
 		}
-		items = append(items, m)
+		return nil
 	}
-	return c.JSON(fiber.Map{"items": items, "page": 1, "perPage": len(items), "totalItems": len(items)})
+	totalPages := (totalItems + perPage - 1) / perPage
+	return streamItems(c, rows, prepare, fiber.Map{"page": page, "perPage": perPage, "totalItems": totalItems, "totalPages": totalPages})
 }
 
 func handleGet(c *fiber.Ctx) error {
@@ -242,37 +435,40 @@ func handleGet(c *fiber.Ctx) error {
 	// handle GET by id for supported collections
 	switch collection {
 	case "contacts":
-		var idVal, name, phone, nid, typ, org sql.NullString
-		if err := db.QueryRow("SELECT id,name,phone,nid,type,organization_id FROM contacts WHERE id = ?", id).Scan(&idVal, &name, &phone, &nid, &typ, &org); err != nil {
-			if err == sql.ErrNoRows {
-				return c.Status(404).JSON(fiber.Map{"error": "not found"})
-			}
-			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		ct, err := Contacts(dbFor(c)).Get(c.UserContext(), currentOrgID(c), id)
+		if err != nil {
+			return recordError(c, err)
 		}
-		return c.JSON(fiber.Map{"id": idVal.String, "name": name.String, "phone": phone.String, "nid": nid.String, "type": typ.String, "organization_id": org.String})
+		return c.JSON(ct)
 	case "inventory_items":
-		var idVal, name, sku, category, description, imageFilename, imageUrl sql.NullString
-		var quantity, reorderLevel sql.NullInt32
-		var unitPrice sql.NullFloat64
-		err := db.QueryRow(`SELECT id,name,sku,quantity,unit_price,reorder_level,category,description,image_filename,image_url FROM inventory_items WHERE id = ?`, id).Scan(&idVal, &name, &sku, &quantity, &unitPrice, &reorderLevel, &category, &description, &imageFilename, &imageUrl)
+		it, err := Items(dbFor(c)).Get(c.UserContext(), currentOrgID(c), id)
+		if err != nil {
+			return recordError(c, err)
+		}
+		breakdown, err := itemLocationBreakdown(dbFor(c), currentOrgID(c), map[string]int{it.ID: it.Quantity})
 		if err != nil {
-			if err == sql.ErrNoRows {
-				return c.Status(404).JSON(fiber.Map{"error": "not found"})
-			}
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.JSON(fiber.Map{"id": idVal.String, "name": name.String, "sku": sku.String, "quantity": quantity.Int32, "unit_price": unitPrice.Float64, "reorder_level": reorderLevel.Int32, "category": category.String, "description": description.String, "image_filename": imageFilename.String, "image_url": imageUrl.String})
-	case "transactions":
-		var idVal, typ, contactId, imageFilename, imageUrl sql.NullString
-		var amount, paidAmount, dueAmount sql.NullFloat64
-		err := db.QueryRow(`SELECT id,type,amount,paid_amount,due_amount,contact_id,image_filename,image_url FROM transactions WHERE id = ?`, id).Scan(&idVal, &typ, &amount, &paidAmount, &dueAmount, &contactId, &imageFilename, &imageUrl)
+		units, err := itemUnits(dbFor(c), it.ID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		variants, err := Items(dbFor(c)).Variants(c.UserContext(), it.ID)
 		if err != nil {
-			if err == sql.ErrNoRows {
-				return c.Status(404).JSON(fiber.Map{"error": "not found"})
-			}
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
-		return c.JSON(fiber.Map{"id": idVal.String, "type": typ.String, "amount": amount.Float64, "paid_amount": paidAmount.Float64, "due_amount": dueAmount.Float64, "contact_id": contactId.String, "image_filename": imageFilename.String, "image_url": imageUrl.String})
+		return c.JSON(struct {
+			Item
+			Units     []ItemUnit  `json:"units"`
+			Variants  []Item      `json:"variants,omitempty"`
+			Locations []fiber.Map `json:"locations,omitempty"`
+		}{it, units, variants, breakdown[it.ID]})
+	case "transactions":
+		t, err := Transactions(dbFor(c)).Get(c.UserContext(), currentOrgID(c), id)
+		if err != nil {
+			return recordError(c, err)
+		}
+		return c.JSON(t)
 	default:
 		return c.Status(501).JSON(fiber.Map{"error": "not implemented for GET record by id"})
 	}
@@ -285,61 +481,379 @@ func handleCreate(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
 	}
 	id := genID()
+	orgID := currentOrgID(c)
 	switch collection {
 	case "contacts":
-		_, err := db.Exec(`INSERT INTO contacts (id,name,phone,nid,type,organization_id) VALUES (?,?,?,?,?,?)`, id, body["name"], body["phone"], body["nid"], body["type"], body["organization_id"])
-		if err != nil {
+		ct := Contact{ID: id, OrganizationID: orgID}
+		if err := json.Unmarshal(c.Body(), &ct); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+		ct.ID, ct.OrganizationID = id, orgID
+		if strings.TrimSpace(ct.Name) == "" || ct.Type == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "name and type required"})
+		}
+		if ct.Email = strings.TrimSpace(ct.Email); ct.Email != "" && !strings.Contains(ct.Email, "@") {
+			return c.Status(400).JSON(fiber.Map{"error": "email must be an email address"})
+		}
+		if ct.PriceListID != "" && !orgOwns(dbFor(c), "price_lists", ct.PriceListID, orgID) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown price list " + ct.PriceListID})
+		}
+		if err := Contacts(dbFor(c)).Create(c.UserContext(), &ct); err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		publishRecord(orgID, collection, "create", id)
 		return c.JSON(fiber.Map{"id": id})
 	case "inventory_items":
-		now := time.Now().Format(time.RFC3339)
-		_, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,reorder_level,category,description,updated_at,created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`, id, body["name"], body["sku"], body["quantity"], body["unit_price"], body["reorder_level"], body["category"], body["description"], now, now)
+		var in NewItem
+		if err := json.Unmarshal(c.Body(), &in); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+		if in.ParentID != nil {
+			if err := checkVariantParent(dbFor(c), orgID, *in.ParentID); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+			}
+			if strings.TrimSpace(in.SKU) == "" || len(in.Options) == 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "a variant needs its own sku and options"})
+			}
+			// variants go by their parent's name
+			if err := dbFor(c).QueryRow(`SELECT name FROM inventory_items WHERE id = ?`, *in.ParentID).Scan(&in.Name); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		} else if len(in.Options) > 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "options are for variants; set parent_id"})
+		}
+		id, err := Items(dbFor(c)).Create(c.UserContext(), orgID, in)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		publishRecord(orgID, collection, "create", id)
 		return c.JSON(fiber.Map{"id": id})
 	case "transactions":
-		_, err := db.Exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,created_at) VALUES (?,?,?,?,?,?,?)`, id, body["type"], body["amount"], body["paid_amount"], body["due_amount"], body["contact_id"], time.Now().Format(time.RFC3339))
-		if err != nil {
+		if !orgOwns(dbFor(c), "contacts", toString(body["contact_id"]), orgID) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown contact"})
+		}
+		if loc := toString(body["location_id"]); loc != "" && !orgOwns(dbFor(c), "locations", loc, orgID) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown location " + loc})
+		}
+		// amounts in a foreign currency are taken in the base currency; see exchange_rates.go
+		currency, rate, err := transactionRate(dbFor(c), orgID, body)
+		if fe, ok := err.(*fiber.Error); ok {
+			return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+		} else if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
-		// handle items
+		if rate > 0 {
+			convertToBase(body, rate)
+		}
+		// amounts are kept to the paisa; see money.go
+		roundAmounts(body)
+		// a customer on a price list is sold at its prices; see price_lists.go
+		priceList, repriced := "", 0.0
+		if body["type"] == "inflow" {
+			if priceList, err = contactPriceList(dbFor(c), toString(body["contact_id"])); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
 		if items, ok := body["items"].([]interface{}); ok {
 			for _, item := range items {
 				itemMap, ok := item.(map[string]interface{})
-				if !ok {
-					continue
+				if ok && !orgOwns(dbFor(c), "inventory_items", toString(itemMap["item_id"]), orgID) {
+					return c.Status(400).JSON(fiber.Map{"error": "unknown item " + toString(itemMap["item_id"])})
 				}
-				itemId, _ := itemMap["item_id"].(string)
-				quantity, _ := itemMap["quantity"].(float64)
-				unitPrice, _ := itemMap["unit_price"].(float64)
-				totalPrice := quantity * unitPrice
-				_, _ = db.Exec(`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES (?,?,?,?,?,?)`, genID(), id, itemId, int(quantity), unitPrice, totalPrice)
-				// update inventory
-				var currentQty int
-				_ = db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = ?`, itemId).Scan(&currentQty)
-				var newQty int
-				if body["type"] == "inflow" {
-					newQty = currentQty - int(quantity)
-				} else {
-					newQty = currentQty + int(quantity)
+				if ok {
+					variants, err := hasVariants(dbFor(c), toString(itemMap["item_id"]))
+					if err != nil {
+						return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+					}
+					if variants {
+						return c.Status(400).JSON(fiber.Map{"error": "item " + toString(itemMap["item_id"]) + " has variants; choose one of them"})
+					}
 				}
-				_, _ = db.Exec(`UPDATE inventory_items SET quantity = ?, updated_at = ? WHERE id = ?`, newQty, time.Now().Format(time.RFC3339), itemId)
-				// create inventory_transaction
-				quantityChange := int(quantity)
-				if body["type"] == "inflow" {
-					quantityChange = -quantityChange
+				if quantity, _ := itemMap["quantity"].(float64); ok && (quantity <= 0 || quantity != float64(int(quantity))) {
+					return c.Status(400).JSON(fiber.Map{"error": "quantity must be a whole number above 0"})
+				}
+				if loc := toString(itemMap["location_id"]); ok && loc != "" && !orgOwns(dbFor(c), "locations", loc, orgID) {
+					return c.Status(400).JSON(fiber.Map{"error": "unknown location " + loc})
+				}
+				// lines in cartons, dozens, ... move stock in base units
+				if ok {
+					if err := convertLineUnit(dbFor(c), itemMap); err != nil {
+						return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+					}
+				}
+				if ok && priceList != "" {
+					change, err := applyPriceList(dbFor(c), priceList, itemMap)
+					if err != nil {
+						return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+					}
+					repriced += change
+				} else if ok && body["type"] == "inflow" {
+					if status, problem := checkMinimumPrice(c, orgID, itemMap); status != 0 {
+						return c.Status(status).JSON(problem)
+					}
 				}
-				_, _ = db.Exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,created_at) VALUES (?,?,?,?,?,?,?,?)`, genID(), itemId, quantityChange, currentQty, newQty, body["type"], "From transaction", time.Now().Format(time.RFC3339))
 			}
 		}
-		return c.JSON(fiber.Map{"id": id})
+		response := fiber.Map{"id": id}
+		if repriced != 0 {
+			discountSale(body, -repriced)
+			response["amount"] = body["amount"]
+		}
+		// promotions running today come off the sale lines and its amount
+		if items, ok := body["items"].([]interface{}); ok && body["type"] == "inflow" {
+			discount, err := applyPromotions(dbFor(c), orgID, items)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if discount > 0 {
+				discountSale(body, discount)
+				response["discount"] = discount
+				response["amount"] = body["amount"]
+			}
+		}
+		// then the lines are taxed; tax not in the prices is added to the amount
+		taxAmount := 0.0
+		if items, ok := body["items"].([]interface{}); ok {
+			tax, added, err := applyTax(dbFor(c), orgID, items)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if added > 0 {
+				discountSale(body, -added)
+				response["amount"] = body["amount"]
+			}
+			if taxAmount = tax; tax > 0 {
+				response["tax_amount"] = tax
+			}
+		}
+		if mode, window := duplicateCheckSettings(orgID); mode != "off" && body["confirm_duplicate"] != true {
+			dupID, err := findDuplicateTransaction(dbFor(c), orgID, body, window)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if dupID != "" && mode == "block" {
+				return c.Status(409).JSON(fiber.Map{"error": "looks like a duplicate of a recent transaction; resend with confirm_duplicate to create it anyway", "duplicate_of": dupID})
+			}
+			if dupID != "" {
+				response["warning"] = "looks like a duplicate of a recent transaction"
+				response["duplicate_of"] = dupID
+			}
+		}
+		if v, ok := body["due_date"]; ok && v != nil {
+			if _, err := time.Parse("2006-01-02", toString(v)); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "due_date must be a date (YYYY-MM-DD)"})
+			}
+		}
+		// sales made offline carry a number from the till's block; see receipt_blocks.go
+		receiptNumber, receiptBlock := strings.TrimSpace(toString(body["receipt_number"])), ""
+		if receiptNumber != "" {
+			if body["type"] != "inflow" {
+				return c.Status(400).JSON(fiber.Map{"error": "receipt_number is for sales"})
+			}
+			var status int
+			var problem fiber.Map
+			if receiptBlock, status, problem = checkReceiptNumber(c, orgID, receiptNumber); status != 0 {
+				return c.Status(status).JSON(problem)
+			}
+		}
+		// a sale may be taken as an order to make; see orders.go
+		if problem := checkOrderStatus(body); problem != "" {
+			return c.Status(400).JSON(fiber.Map{"error": problem})
+		}
+		payments, err := parsePayments(dbFor(c), orgID, body)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := applyPayments(body, payments); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if method, ok := body["payment_method"].(string); ok && method != "" && len(payments) == 0 {
+			if paid, _ := body["paid_amount"].(float64); paid > 0 {
+				payments = []paymentLine{{Method: method, Amount: paid}}
+			}
+		}
+		// the currency, its rate and the amounts in it; NULL in the base currency
+		var inCurrency [5]interface{}
+		if rate > 0 {
+			inCurrency = [5]interface{}{currency, rate, fromBase(body["amount"], body["currency_amount"], rate), fromBase(body["paid_amount"], body["currency_paid_amount"], rate), fromBase(body["due_amount"], body["currency_due_amount"], rate)}
+			response["currency"], response["currency_amount"] = currency, inCurrency[2]
+		}
+		tx, err := dbFor(c).Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer tx.Rollback()
+		// sales made online take the next invoice number
+		if body["type"] == "inflow" && receiptNumber == "" {
+			if receiptNumber, err = nextSequenceNumber(tx, orgID, "invoice"); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		if receiptNumber != "" {
+			response["invoice_no"] = receiptNumber
+		}
+		_, err = tx.PreparedExec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,payment_method,due_date,receipt_number,receipt_block_id,tax_amount,currency,exchange_rate,currency_amount,currency_paid_amount,currency_due_amount,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,NULLIF(?, ''),NULLIF(?, ''),?,?,?,?,?,?,?,?)`, id, body["type"], moneyValue(body["amount"]), moneyValue(body["paid_amount"]), moneyValue(body["due_amount"]), body["contact_id"], body["payment_method"], body["due_date"], receiptNumber, receiptBlock, taxAmount, inCurrency[0], inCurrency[1], inCurrency[2], inCurrency[3], inCurrency[4], orgID, time.Now().Format(time.RFC3339))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := recordPayments(tx, orgID, id, toString(body["type"]), payments); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if strings.TrimSpace(toString(body["order_status"])) != "" {
+			token, err := openOrder(tx, orgID, id, strings.TrimSpace(toString(body["order_table"])), currentUserID(c))
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			response["order_status"], response["order_token"] = "new", token
+		}
+		// handle items
+		items, _ := body["items"].([]interface{})
+		for _, item := range items {
+			itemMap, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			itemId, _ := itemMap["item_id"].(string)
+			quantity, _ := itemMap["quantity"].(float64)
+			unitPrice, _ := itemMap["unit_price"].(float64)
+			totalPrice, _ := itemMap["total_price"].(float64)
+			discount, _ := itemMap["discount"].(float64)
+			taxRate, _ := itemMap["tax_rate"].(float64)
+			taxable, _ := itemMap["taxable_amount"].(float64)
+			lineTax, _ := itemMap["tax_amount"].(float64)
+			var lineInCurrency interface{}
+			if rate > 0 {
+				lineInCurrency = fromBase(itemMap["total_price"], itemMap["currency_total_price"], rate)
+			}
+			// stock moves at the line's location, else the transaction's
+			location := toString(itemMap["location_id"])
+			if location == "" {
+				location = toString(body["location_id"])
+			}
+			if !validExpiryDate(toString(itemMap["expiry_date"])) {
+				return c.Status(400).JSON(fiber.Map{"error": "expiry_date must be a date (YYYY-MM-DD)"})
+			}
+			if _, err := tx.PreparedExec(`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price,location_id,unit,unit_quantity,discount,promotion_id,price_approved_by,price_list_id,tax_rate,taxable_amount,tax_amount,currency_total_price) VALUES (?,?,?,?,?,?,NULLIF(?, ''),?,?,?,?,?,?,?,?,?,?)`, genID(), id, itemId, int(quantity), moneyOf(unitPrice), moneyOf(totalPrice), location, itemMap["unit"], itemMap["unit_quantity"], discount, itemMap["promotion_id"], itemMap["price_approved_by"], itemMap["price_list_id"], taxRate, taxable, lineTax, lineInCurrency); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if overrideID := toString(itemMap["price_override_id"]); overrideID != "" {
+				if err := usePriceOverride(tx, overrideID, id); err != nil {
+					return c.Status(409).JSON(fiber.Map{"error": err.Error()})
+				}
+			}
+			// a bundle short of finished units is assembled first
+			if body["type"] == "inflow" {
+				if status, err := assembleShortfall(tx, orgID, itemId, int(quantity), location, "For sale"); err != nil {
+					return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+				}
+			}
+			// update inventory
+			currentQty, err := Items(tx).Quantity(c.UserContext(), itemId)
+			if err != nil {
+				return recordError(c, err)
+			}
+			var newQty int
+			if body["type"] == "inflow" {
+				newQty = currentQty - int(quantity)
+			} else {
+				newQty = currentQty + int(quantity)
+			}
+			if _, err := tx.PreparedExec(`UPDATE inventory_items SET quantity = ?, updated_at = ? WHERE id = ?`, newQty, time.Now().Format(time.RFC3339), itemId); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			stockChanged(tx, itemId)
+			// create inventory_transaction
+			quantityChange := int(quantity)
+			if body["type"] == "inflow" {
+				quantityChange = -quantityChange
+			}
+			// a purchase line's price is what the units received cost
+			var unitCost interface{}
+			if body["type"] == "outflow" {
+				unitCost = unitPrice
+			}
+			if _, err := tx.PreparedExec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,unit_cost,location_id,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,NULLIF(?, ''),?,?)`, genID(), itemId, quantityChange, currentQty, newQty, body["type"], "From transaction", unitCost, location, orgID, time.Now().Format(time.RFC3339)); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if status, err := moveLocationStock(tx, orgID, itemId, location, quantityChange); err != nil {
+				return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+			}
+			// purchases fill a new batch, sales empty the one expiring first
+			if body["type"] == "outflow" && quantity > 0 {
+				if _, _, err := receiveBatch(tx, orgID, itemId, id, int(quantity), unitPrice, toString(itemMap["lot_number"]), toString(itemMap["expiry_date"])); err != nil {
+					return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+				}
+			} else if body["type"] == "inflow" {
+				if err := consumeBatches(tx, itemId, id, int(quantity)); err != nil {
+					return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+				}
+			}
+		}
+		if body["type"] == "inflow" {
+			for _, item := range items {
+				if itemMap, ok := item.(map[string]interface{}); ok {
+					quantity, _ := itemMap["quantity"].(float64)
+					unitPrice, _ := itemMap["unit_price"].(float64)
+					if err := recordConsignedSale(tx, orgID, id, toString(itemMap["item_id"]), int(quantity), unitPrice); err != nil {
+						return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+					}
+				}
+			}
+		}
+		if err := refreshTransactionReadModel(tx, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		publishRecord(orgID, collection, "create", id)
+		for _, item := range items {
+			if itemMap, ok := item.(map[string]interface{}); ok {
+				publishRecord(orgID, "inventory_items", "update", toString(itemMap["item_id"]))
+			}
+		}
+		return c.JSON(response)
 	case "inventory_transactions":
-		_, err := db.Exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,created_at) VALUES (?,?,?,?,?,?,?,?)`, id, body["item_id"], body["quantity_change"], body["previous_quantity"], body["new_quantity"], body["transaction_type"], body["notes"], time.Now().Format(time.RFC3339))
+		if !orgOwns(dbFor(c), "inventory_items", toString(body["item_id"]), orgID) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown item"})
+		}
+		// the client sets the item's new total itself; a movement at a
+		// location other than the default also moves the stock held there
+		location := toString(body["location_id"])
+		if location != "" && !orgOwns(dbFor(c), "locations", location, orgID) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown location"})
+		}
+		tx, err := dbFor(c).Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,location_id,organization_id,created_by,created_at) VALUES (?,?,?,?,?,?,?,NULLIF(?, ''),?,?,?)`, id, body["item_id"], body["quantity_change"], body["previous_quantity"], body["new_quantity"], body["transaction_type"], body["notes"], location, orgID, currentUserID(c), time.Now().Format(time.RFC3339)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		change, _ := body["quantity_change"].(float64)
+		if status, err := moveLocationStock(tx, orgID, toString(body["item_id"]), location, int(change)); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		publishRecord(orgID, collection, "create", id)
+		if txType := toString(body["transaction_type"]); isAdjustmentType(txType) {
+			checkAfterHoursAdjustment(dbFor(c), orgID, currentUserID(c), id, fmt.Sprintf("a stock %s of %v", txType, body["quantity_change"]))
+		}
+		return c.JSON(fiber.Map{"id": id})
+	case "payment_methods":
+		code, _ := body["code"].(string)
+		name, _ := body["name"].(string)
+		if code == "" || name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "code and name required"})
+		}
+		_, err := dbFor(c).Exec(`INSERT INTO payment_methods (id,code,name,active,organization_id,created_at) VALUES (?,?,?,1,?,?)`, id, code, name, orgID, time.Now().Format(time.RFC3339))
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 		}
+		publishRecord(orgID, collection, "create", id)
 		return c.JSON(fiber.Map{"id": id})
 	default:
 		return c.Status(404).JSON(fiber.Map{"error": "unknown collection"})
@@ -353,27 +867,164 @@ func handlePatch(c *fiber.Ctx) error {
 	if err := json.Unmarshal(c.Body(), &body); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
 	}
+	if isTenantTable(collection) && !orgOwns(dbFor(c), collection, id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
 	switch collection {
 	case "inventory_items":
-		// simple update of provided fields
-		updated := false
-		if name, ok := body["name"]; ok {
-			_, _ = db.Exec("UPDATE inventory_items SET name = ? WHERE id = ?", name, id)
-			updated = true
+		patch, err := parseItemPatch(body)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		if patch.Unit != nil {
+			if factor, err := unitFactor(dbFor(c), id, *patch.Unit); err == nil && factor > 1 {
+				return c.Status(400).JSON(fiber.Map{"error": "the item already has a unit " + *patch.Unit})
+			}
 		}
-		if q, ok := body["quantity"]; ok {
-			_, _ = db.Exec("UPDATE inventory_items SET quantity = ? WHERE id = ?", q, id)
-			updated = true
+		var parentID string
+		if err := dbFor(c).QueryRow(`SELECT COALESCE(parent_id, '') FROM inventory_items WHERE id = ?`, id).Scan(&parentID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if parentID != "" && patch.Name != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "a variant takes its parent's name; rename the parent"})
 		}
-		if updated {
-			_, _ = db.Exec("UPDATE inventory_items SET updated_at = ? WHERE id = ?", time.Now().Format(time.RFC3339), id)
+		if parentID == "" && patch.Options != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "options are for variants"})
 		}
+		if r := patch.TaxRateID; r != nil && r.Valid && !orgOwns(dbFor(c), "tax_rates", r.String, currentOrgID(c)) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown tax rate " + r.String})
+		}
+		tx, err := dbFor(c).Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer tx.Rollback()
+		if _, err := Items(tx).Update(c.UserContext(), id, patch); err != nil {
+			return recordError(c, err)
+		}
+		if patch.Quantity != nil {
+			stockChanged(tx, id)
+		}
+		if patch.Name != nil {
+			if err := refreshItemTransactions(tx, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if err := renameVariants(tx, id, *patch.Name); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		publishRecord(currentOrgID(c), collection, "update", id)
 		return c.JSON(fiber.Map{"id": id})
 	case "transactions":
+		repo := Transactions(dbFor(c))
+		createdAt, err := repo.CreatedAt(c.UserContext(), id)
+		if err != nil {
+			return recordError(c, err)
+		}
+		if t, err := parseTime(createdAt); err == nil {
+			locked, err := periodLocked(dbFor(c), currentOrgID(c), t)
+			if err != nil {
+				// a lock that cannot be checked holds the edit back like a closed year
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if locked {
+				return c.Status(409).JSON(fiber.Map{"error": "transaction belongs to a closed fiscal year"})
+			}
+		}
+		var dueDate *sql.NullString
+		if v, ok := body["due_date"]; ok {
+			dueDate = &sql.NullString{String: toString(v), Valid: v != nil}
+			if _, err := time.Parse("2006-01-02", dueDate.String); dueDate.Valid && err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "due_date must be a date (YYYY-MM-DD)"})
+			}
+		}
+		// lines and amount are corrected in one go; see transaction_edits.go
+		response := fiber.Map{"id": id}
+		_, items := body["items"]
+		if _, amount := body["amount"]; items || amount {
+			edited, status, problem := editTransaction(c, id, body)
+			if status != 0 {
+				return c.Status(status).JSON(problem)
+			}
+			for k, v := range edited {
+				response[k] = v
+			}
+		}
 		// update image_url if provided
-		if imageUrl, ok := body["image_url"]; ok {
-			_, _ = db.Exec("UPDATE transactions SET image_url = ? WHERE id = ?", imageUrl, id)
+		if v, ok := body["image_url"]; ok {
+			if err := repo.SetImageURL(c.UserContext(), id, sql.NullString{String: toString(v), Valid: v != nil}); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		if dueDate != nil {
+			if err := repo.SetDueDate(c.UserContext(), id, *dueDate); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		publishRecord(currentOrgID(c), collection, "update", id)
+		return c.JSON(response)
+	case "contacts":
+		// the name is copied onto the contact's transactions, so only the
+		// details used to reach them, and whether they may be texted,
+		// change here
+		if v, ok := body["email"]; ok && v != nil {
+			if email, isText := v.(string); !isText || (strings.TrimSpace(email) != "" && !strings.Contains(email, "@")) {
+				return c.Status(400).JSON(fiber.Map{"error": "email must be an email address"})
+			}
+		}
+		if v, ok := body["sms_opt_out"]; ok {
+			optOut, isBool := v.(bool)
+			if !isBool {
+				return c.Status(400).JSON(fiber.Map{"error": "sms_opt_out must be true or false"})
+			}
+			value := 0
+			if optOut {
+				value = 1
+			}
+			if _, err := dbFor(c).Exec(`UPDATE contacts SET sms_opt_out = ? WHERE id = ?`, value, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		for _, field := range []string{"phone", "email", "nid"} {
+			if v, ok := body[field]; ok {
+				var value interface{} = strings.TrimSpace(toString(v))
+				if value == "" && field != "phone" {
+					value = nil
+				}
+				if _, err := dbFor(c).Exec("UPDATE contacts SET "+field+" = ? WHERE id = ?", value, id); err != nil {
+					return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+				}
+			}
 		}
+		publishRecord(currentOrgID(c), collection, "update", id)
+		return c.JSON(fiber.Map{"id": id})
+	case "payment_methods":
+		if v, ok := body["account_id"]; ok && v != nil && !orgOwns(dbFor(c), "cash_accounts", toString(v), currentOrgID(c)) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown account"})
+		}
+		for _, field := range []string{"fee_percent", "fee_fixed"} {
+			if v, ok := body[field]; ok {
+				if f, isNum := v.(float64); !isNum || f < 0 {
+					return c.Status(400).JSON(fiber.Map{"error": field + " must be a non-negative number"})
+				}
+			}
+		}
+		if v, ok := body["settlement_days"]; ok && v != nil {
+			if f, isNum := v.(float64); !isNum || f < 0 || f != float64(int(f)) {
+				return c.Status(400).JSON(fiber.Map{"error": "settlement_days must be a whole number of days or null"})
+			}
+		}
+		for _, field := range []string{"name", "active", "account_id", "fee_percent", "fee_fixed", "settlement_days"} {
+			if v, ok := body[field]; ok {
+				if _, err := dbFor(c).Exec("UPDATE payment_methods SET "+field+" = ? WHERE id = ?", v, id); err != nil {
+					return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+				}
+			}
+		}
+		publishRecord(currentOrgID(c), collection, "update", id)
 		return c.JSON(fiber.Map{"id": id})
 	default:
 		return c.Status(404).JSON(fiber.Map{"error": "unknown collection for patch"})
@@ -384,33 +1035,22 @@ func handleUploadFile(c *fiber.Ctx) error {
 	collection := c.Params("collection")
 	id := c.Params("id")
 	_ = c.Params("field")
+	if !isTenantTable(collection) || !orgOwns(dbFor(c), collection, id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
 	file, err := c.FormFile("file")
 	if err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "file required"})
 	}
-	in, err := file.Open()
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	defer in.Close()
-	uploadsDir := filepath.Join("uploads", collection, id)
-	_ = os.MkdirAll(uploadsDir, 0o755)
-	outPath := filepath.Join(uploadsDir, file.Filename)
-	out, err := os.Create(outPath)
+	data, err := readUpload(file)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
 	}
-	defer out.Close()
-	_, err = io.Copy(out, in)
+	filename := filepath.Base(file.Filename)
+	url, err := attachRecordFile(dbFor(c), currentOrgID(c), collection, id, filename, data)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
-	}
-	url := fmt.Sprintf("/api/files/%s/%s/%s", collection, id, file.Filename)
-	// update record to store file info
-	if collection == "inventory_items" {
-		_, _ = db.Exec("UPDATE inventory_items SET image_filename = ?, image_url = ? WHERE id = ?", file.Filename, url, id)
-	} else if collection == "transactions" {
-		_, _ = db.Exec("UPDATE transactions SET image_filename = ?, image_url = ? WHERE id = ?", file.Filename, url, id)
+		return uploadErrorResponse(c, err)
 	}
-	return c.JSON(fiber.Map{"filename": file.Filename, "url": url})
+	publishRecord(currentOrgID(c), collection, "update", id)
+	return c.JSON(fiber.Map{"filename": filename, "url": url})
 }
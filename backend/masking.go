@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Field masking keeps fields a role should not see out of every JSON
+// response, whichever handler produced it: maskHiddenFields removes them
+// from response bodies on the way out, and realtime events are masked for
+// each subscriber the same way. Fields are matched by name at any depth,
+// so cost_price is hidden in item lists, reports and expanded records
+// alike. The organization setting hidden_fields maps roles to the fields
+// they cannot see; roles it leaves out keep defaultHiddenFields. Admins
+// see everything.
+
+var defaultHiddenFields = map[string]interface{}{
+	"cashier": []interface{}{"cost_price", "unit_cost", "landed_cost"},
+	"viewer":  []interface{}{"cost_price", "unit_cost", "landed_cost", "nid"},
+}
+
+// hiddenFields returns the fields role cannot see in orgID.
+func hiddenFields(orgID, role string) map[string]bool {
+	if role == "" || role == "admin" {
+		return nil
+	}
+	list, ok := defaultHiddenFields[role]
+	if org, _ := orgSetting(orgID, "hidden_fields").(map[string]interface{}); org != nil {
+		if v, set := org[role]; set {
+			list, ok = v, true
+		}
+	}
+	if !ok {
+		return nil
+	}
+	fields := map[string]bool{}
+	for _, f := range toSlice(list) {
+		if s, _ := f.(string); s != "" {
+			fields[s] = true
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+func toSlice(v interface{}) []interface{} {
+	switch s := v.(type) {
+	case []interface{}:
+		return s
+	case []string:
+		out := make([]interface{}, len(s))
+		for i, x := range s {
+			out[i] = x
+		}
+		return out
+	}
+	return nil
+}
+
+// validateHiddenFields checks a hidden_fields setting: an object from
+// roles other than admin to lists of field names.
+func validateHiddenFields(v interface{}) string {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return "hidden_fields must map roles to lists of field names"
+	}
+	for role, fields := range m {
+		if !isValidRole(role) || role == "admin" {
+			return "hidden_fields: " + role + " is not a role whose fields can be hidden"
+		}
+		list, ok := fields.([]interface{})
+		if !ok {
+			return "hidden_fields: " + role + " must be a list of field names"
+		}
+		for _, f := range list {
+			if s, ok := f.(string); !ok || s == "" {
+				return "hidden_fields: " + role + " must be a list of field names"
+			}
+		}
+	}
+	return ""
+}
+
+// stripFields removes the hidden keys from v at any depth and reports
+// whether it removed any.
+func stripFields(v interface{}, hidden map[string]bool) bool {
+	changed := false
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, child := range x {
+			if hidden[k] {
+				delete(x, k)
+				changed = true
+			} else if stripFields(child, hidden) {
+				changed = true
+			}
+		}
+	case []interface{}:
+		for _, child := range x {
+			if stripFields(child, hidden) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// maskJSON returns data, a JSON document, without the hidden fields.
+func maskJSON(data []byte, hidden map[string]bool) []byte {
+	if len(hidden) == 0 {
+		return data
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if dec.Decode(&v) != nil || !stripFields(v, hidden) {
+		return data
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// maskHiddenFields strips the caller's hidden fields from JSON responses.
+// It runs around every route, so the role is known once the route's
+// requireAuth has run.
+func maskHiddenFields(c *fiber.Ctx) error {
+	err := c.Next()
+	hidden := hiddenFields(currentOrgID(c), currentRole(c))
+	// streamed lists strip the fields themselves, see streaming.go
+	if len(hidden) == 0 || c.Response().IsBodyStream() || !strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMEApplicationJSON) {
+		return err
+	}
+	c.Response().SetBodyRaw(maskJSON(c.Response().Body(), hidden))
+	return err
+}
+
+// hiddenFieldIn returns a hidden field named in a filter or sort
+// expression, so records cannot be searched by what the caller cannot see.
+func hiddenFieldIn(expr string, hidden map[string]bool) string {
+	words := strings.FieldsFunc(expr, func(r rune) bool {
+		return !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	})
+	for _, w := range words {
+		if hidden[w] {
+			return w
+		}
+	}
+	return ""
+}
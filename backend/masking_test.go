@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHiddenFieldsPerRole(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,nid,type,organization_id) VALUES ('c-1','Rahim','017','1990123456','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,9,'org-1')`,
+		`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,unit_cost,organization_id,created_at) VALUES ('m-1','i-1',10,0,10,'outflow',9,'org-1','2024-01-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(out)
+	}
+
+	items := "/api/collections/inventory_items/records"
+	if _, body := call("manager", "GET", items, ""); !strings.Contains(body, `"cost_price"`) {
+		t.Errorf("manager should see cost_price: %s", body)
+	}
+	code, body := call("cashier", "GET", items, "")
+	if code != 200 || strings.Contains(body, "cost_price") || !strings.Contains(body, `"unit_price":15`) {
+		t.Errorf("cashier items: %d %s", code, body)
+	}
+	// nested fields in reports are hidden too
+	if _, body := call("cashier", "GET", "/api/reports/inventory-valuation", ""); strings.Contains(body, "unit_cost") || !strings.Contains(body, `"total_value"`) {
+		t.Errorf("cashier inventory valuation: %s", body)
+	}
+	if code, _ := call("cashier", "GET", items+"?filter="+url.QueryEscape("cost_price > 5"), ""); code != 403 {
+		t.Errorf("cashier filtering on cost_price: got %d, want 403", code)
+	}
+	if code, _ := call("cashier", "GET", items+"?sort=-cost_price", ""); code != 403 {
+		t.Errorf("cashier sorting on cost_price: got %d, want 403", code)
+	}
+
+	contacts := "/api/collections/contacts/records"
+	if _, body := call("cashier", "GET", contacts, ""); !strings.Contains(body, "1990123456") {
+		t.Errorf("cashier should see nid: %s", body)
+	}
+	if code, body := call("viewer", "GET", contacts, ""); code != 200 || strings.Contains(body, "nid") || !strings.Contains(body, "Rahim") {
+		t.Errorf("viewer contacts: %d %s", code, body)
+	}
+	if code, _ := call("viewer", "POST", contacts, `{"name":"Karim","phone":"018","type":"customer"}`); code != 403 {
+		t.Errorf("viewer creating a contact: got %d, want 403", code)
+	}
+
+	// the organization decides what each role sees
+	if code, _ := call("manager", "PUT", "/api/settings/organization", `{"hidden_fields":{"admin":["nid"]}}`); code != 400 {
+		t.Errorf("hiding admin fields: got %d, want 400", code)
+	}
+	if code, _ := call("manager", "PUT", "/api/settings/user", `{"hidden_fields":{"cashier":[]}}`); code != 400 {
+		t.Errorf("hidden_fields as a user setting: got %d, want 400", code)
+	}
+	if code, _ := call("manager", "PUT", "/api/settings/organization", `{"hidden_fields":{"cashier":["unit_price"]}}`); code != 200 {
+		t.Fatalf("set hidden_fields: got %d", code)
+	}
+	if _, body := call("cashier", "GET", items, ""); !strings.Contains(body, `"cost_price":9`) || strings.Contains(body, "unit_price") {
+		t.Errorf("cashier items after the setting: %s", body)
+	}
+	if _, body := call("viewer", "GET", contacts, ""); strings.Contains(body, "nid") {
+		t.Errorf("viewer keeps the default hidden fields: %s", body)
+	}
+}
+
+func TestRealtimeEventsMaskedPerRole(t *testing.T) {
+	cashier, manager := realtime.connect(), realtime.connect()
+	defer realtime.disconnect(cashier)
+	defer realtime.disconnect(manager)
+	realtime.subscribe(cashier.id, "org-x", "cashier", []string{"inventory_items"})
+	realtime.subscribe(manager.id, "org-x", "manager", []string{"inventory_items"})
+	realtime.publish("org-x", "inventory_items", "update", "i-1", func() map[string]interface{} {
+		return map[string]interface{}{"id": "i-1", "unit_price": 15, "cost_price": 9}
+	})
+	record := func(cl *realtimeClient) map[string]interface{} {
+		t.Helper()
+		select {
+		case ev := <-cl.events:
+			var data struct {
+				Record map[string]interface{} `json:"record"`
+			}
+			if err := json.Unmarshal(ev.data, &data); err != nil {
+				t.Fatal(err)
+			}
+			return data.Record
+		default:
+			t.Fatal("no event")
+		}
+		return nil
+	}
+	if r := record(cashier); r["cost_price"] != nil || r["unit_price"] != 15.0 {
+		t.Errorf("cashier got %v", r)
+	}
+	if r := record(manager); r["cost_price"] != 9.0 {
+		t.Errorf("manager got %v", r)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A user can belong to several organizations (an accountant keeping the
+// books of a few shops, a franchise owner running several), with a role in
+// each kept in organization_members. Tokens are for one organization at a
+// time: login starts in the user's home organization (users.organization_id)
+// and POST /api/auth/switch issues tokens for another one the user belongs
+// to. Refresh tokens stay in the organization they were issued for.
+
+func registerMembershipRoutes(app *fiber.App) {
+	app.Get("/api/auth/organizations", requireAuth, handleListMemberships)
+	app.Post("/api/auth/switch", requireAuth, handleSwitchOrganization)
+	app.Post("/api/organizations", requireAuth, handleCreateOrganization)
+}
+
+// memberRole returns userID's role in orgID, or sql.ErrNoRows when the
+// user does not belong to it.
+func memberRole(q *DB, userID, orgID string) (string, error) {
+	var role string
+	err := q.QueryRow(`SELECT role FROM organization_members WHERE user_id = ? AND organization_id = ?`, userID, orgID).Scan(&role)
+	return role, err
+}
+
+func addMember(q *DB, userID, orgID, role string) error {
+	_, err := q.Exec(`INSERT INTO organization_members (user_id,organization_id,role,created_at) VALUES (?,?,?,?)`, userID, orgID, role, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// loginMembership picks the organization a login starts in: home when the
+// user still belongs to it, else the organization the user joined first.
+func loginMembership(q *DB, userID, home string) (orgID, role string, err error) {
+	if role, err = memberRole(q, userID, home); err == nil {
+		return home, role, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", "", err
+	}
+	err = q.QueryRow(`SELECT organization_id, role FROM organization_members WHERE user_id = ? ORDER BY created_at LIMIT 1`, userID).Scan(&orgID, &role)
+	return orgID, role, err
+}
+
+func userMemberships(q *DB, userID string) ([]map[string]interface{}, error) {
+	rows, err := q.Query(`SELECT m.organization_id, COALESCE(o.name, '') AS name, m.role, m.created_at AS joined_at FROM organization_members m LEFT JOIN organizations o ON o.id = m.organization_id WHERE m.user_id = ? ORDER BY m.created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return rowsToMaps(rows)
+}
+
+// handleListMemberships lists the organizations the caller belongs to,
+// marking the one the token is for.
+func handleListMemberships(c *fiber.Ctx) error {
+	items, err := userMemberships(dbFor(c), currentUserID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, it := range items {
+		it["current"] = it["organization_id"] == currentOrgID(c)
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleSwitchOrganization issues a token pair for another organization
+// the caller belongs to, with the caller's role there.
+func handleSwitchOrganization(c *fiber.Ctx) error {
+	var req struct {
+		OrganizationID string `json:"organization_id"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.OrganizationID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "organization_id is required"})
+	}
+	role, err := memberRole(dbFor(c), currentUserID(c), req.OrganizationID)
+	if err == sql.ErrNoRows {
+		return c.Status(403).JSON(fiber.Map{"error": "you are not a member of this organization"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status, msg := checkSessionAccess(c, currentUserID(c), req.OrganizationID, role); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	tokens, err := issueTokens(dbFor(c), currentUserID(c), req.OrganizationID, role)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	tokens["organization_id"] = req.OrganizationID
+	tokens["role"] = role
+	return c.JSON(tokens)
+}
+
+// handleCreateOrganization starts another organization administered by
+// the caller. The caller's token stays where it is; switch to use it.
+func handleCreateOrganization(c *fiber.Ctx) error {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name is required"})
+	}
+	id, err := createOrganization(dbFor(c), req.Name, currentUserID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := addMember(dbFor(c), currentUserID(c), id, "admin"); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(fiber.Map{"id": id, "name": req.Name, "role": "admin"})
+}
+
+// handleAddMember gives an existing account a role in the caller's
+// organization, found by email.
+func handleAddMember(c *fiber.Ctx) error {
+	var req struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if !isValidRole(req.Role) {
+		return c.Status(400).JSON(fiber.Map{"error": "role must be one of " + strings.Join(validRoles, ", ")})
+	}
+	var userID string
+	err := dbFor(c).QueryRow(`SELECT id FROM users WHERE email = ?`, strings.ToLower(strings.TrimSpace(req.Email))).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "no account with this email"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	orgID := currentOrgID(c)
+	if _, err := memberRole(dbFor(c), userID, orgID); err == nil {
+		return c.Status(409).JSON(fiber.Map{"error": "already a member"})
+	}
+	if err := addMember(dbFor(c), userID, orgID, req.Role); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(fiber.Map{"id": userID, "organization_id": orgID, "role": req.Role})
+}
+
+// handleRemoveMember takes a user out of the caller's organization. The
+// account itself stays, as do its other memberships; tokens already issued
+// for this organization work until they expire but cannot be refreshed.
+func handleRemoveMember(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if id == currentUserID(c) {
+		return c.Status(400).JSON(fiber.Map{"error": "you cannot remove yourself"})
+	}
+	res, err := dbFor(c).Exec(`DELETE FROM organization_members WHERE user_id = ? AND organization_id = ?`, id, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendStatus(204)
+}
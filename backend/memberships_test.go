@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOrganizationMembershipAndSwitching(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if jwtSecret == nil {
+		jwtSecret = []byte("test-secret")
+	}
+	app := newApp()
+	call := func(method, path, token, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	_, alice := call("POST", "/api/auth/register", "", `{"email":"alice@example.com","password":"password1","name":"Alice","organization_name":"Alice Books"}`)
+	_, bob := call("POST", "/api/auth/register", "", `{"email":"bob@example.com","password":"password1","name":"Bob","organization_name":"Bob Mart"}`)
+	aliceToken := alice["token"].(string)
+	bobToken := bob["token"].(string)
+	aliceHome := alice["user"].(map[string]interface{})["organization_id"].(string)
+	bobOrg := bob["user"].(map[string]interface{})["organization_id"].(string)
+	aliceID := alice["user"].(map[string]interface{})["id"].(string)
+
+	code, branch := call("POST", "/api/organizations", aliceToken, `{"name":"Alice Books Branch"}`)
+	if code != 201 || branch["role"] != "admin" {
+		t.Fatalf("create organization: got %d %v", code, branch)
+	}
+	if code, _ := call("POST", "/api/users", bobToken, `{"email":"alice@example.com","role":"owner"}`); code != 400 {
+		t.Errorf("add member with bad role: got %d, want 400", code)
+	}
+	if code, _ := call("POST", "/api/users", bobToken, `{"email":"nobody@example.com","role":"manager"}`); code != 404 {
+		t.Errorf("add unknown account: got %d, want 404", code)
+	}
+	if code, _ := call("POST", "/api/users", bobToken, `{"email":"alice@example.com","role":"manager"}`); code != 201 {
+		t.Fatalf("add member: got %d", code)
+	}
+	if code, _ := call("POST", "/api/users", bobToken, `{"email":"alice@example.com","role":"manager"}`); code != 409 {
+		t.Errorf("add member twice: got %d, want 409", code)
+	}
+	if _, out := call("GET", "/api/users", bobToken, ""); len(out["items"].([]interface{})) != 2 {
+		t.Errorf("members of Bob Mart = %v, want Bob and Alice", out["items"])
+	}
+
+	_, out := call("GET", "/api/auth/organizations", aliceToken, "")
+	memberships := out["items"].([]interface{})
+	if len(memberships) != 3 {
+		t.Fatalf("Alice's organizations = %v, want 3", memberships)
+	}
+	for _, m := range memberships {
+		m := m.(map[string]interface{})
+		if (m["organization_id"] == aliceHome) != (m["current"] == true) {
+			t.Errorf("membership %v: current is wrong", m)
+		}
+	}
+
+	if code, _ := call("POST", "/api/auth/switch", bobToken, `{"organization_id":"`+aliceHome+`"}`); code != 403 {
+		t.Errorf("switch to an organization Bob is not in: got %d, want 403", code)
+	}
+	code, switched := call("POST", "/api/auth/switch", aliceToken, `{"organization_id":"`+bobOrg+`"}`)
+	if code != 200 || switched["role"] != "manager" {
+		t.Fatalf("switch: got %d %v", code, switched)
+	}
+	inBob := switched["token"].(string)
+	if _, me := call("GET", "/api/auth/me", inBob, ""); me["organization_id"] != bobOrg || me["role"] != "manager" {
+		t.Errorf("me after switching = %v", me)
+	}
+	if code, _ := call("GET", "/api/users", inBob, ""); code != 403 {
+		t.Errorf("manager listing users: got %d, want 403", code)
+	}
+
+	// refreshing keeps the organization and picks up role changes
+	if code, _ := call("PUT", "/api/users/"+aliceID+"/role", bobToken, `{"role":"cashier"}`); code != 200 {
+		t.Fatalf("set role: got %d", code)
+	}
+	code, refreshed := call("POST", "/api/auth/refresh", "", `{"refresh_token":"`+switched["refresh_token"].(string)+`"}`)
+	if code != 200 {
+		t.Fatalf("refresh: got %d", code)
+	}
+	if _, me := call("GET", "/api/auth/me", refreshed["token"].(string), ""); me["organization_id"] != bobOrg || me["role"] != "cashier" {
+		t.Errorf("me after refresh = %v", me)
+	}
+
+	// removal ends refreshing there, but not Alice's own organizations
+	if code, _ := call("DELETE", "/api/users/"+aliceID, bobToken, ""); code != 204 {
+		t.Fatalf("remove member: got %d", code)
+	}
+	if code, _ := call("POST", "/api/auth/refresh", "", `{"refresh_token":"`+refreshed["refresh_token"].(string)+`"}`); code != 401 {
+		t.Errorf("refresh after removal: got %d, want 401", code)
+	}
+	if code, _ := call("POST", "/api/auth/switch", aliceToken, `{"organization_id":"`+bobOrg+`"}`); code != 403 {
+		t.Errorf("switch after removal: got %d, want 403", code)
+	}
+	_, login := call("POST", "/api/auth/login", "", `{"email":"alice@example.com","password":"password1"}`)
+	if u := login["user"].(map[string]interface{}); u["organization_id"] != aliceHome || u["role"] != "admin" {
+		t.Errorf("login user = %v", u)
+	}
+	if code, _ := call("DELETE", "/api/users/"+aliceID, login["token"].(string), ""); code != 400 {
+		t.Errorf("removing yourself: got %d, want 400", code)
+	}
+}
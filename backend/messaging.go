@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Messages to contacts go out over SMS, WhatsApp or email. WhatsApp, and
+// SMS unless another provider is chosen, are handed to an HTTP gateway
+// (such as a local SMS aggregator's), which is POSTed
+// {"to": "...", "message": "..."} and answers {"id": "..."} with its own
+// message id; delivery reports come back later through
+// POST /api/messages/delivery (campaigns.go). SMS_PROVIDER=twilio sends
+// SMS through Twilio instead. Email is sent over SMTP.
+//
+// Configuration:
+//
+//	SMS_PROVIDER             "gateway" (the default) or "twilio"
+//	SMS_GATEWAY_URL          gateway for SMS
+//	WHATSAPP_GATEWAY_URL     gateway for WhatsApp
+//	MESSAGING_GATEWAY_TOKEN  sent to the gateways as a bearer token
+//	TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN, TWILIO_FROM
+//	                         Twilio account and the number texts come from
+//	SMTP_HOST, SMTP_PORT     mail server (port defaults to 587)
+//	SMTP_USER, SMTP_PASSWORD login, if the server needs one
+//	SMTP_FROM                sender address
+
+var messageChannels = []string{"sms", "whatsapp", "email"}
+
+func isMessageChannel(ch string) bool {
+	for _, v := range messageChannels {
+		if v == ch {
+			return true
+		}
+	}
+	return false
+}
+
+// sendMessage sends body to the address to over channel and returns the
+// provider's id for the message. subject is used by email only.
+func sendMessage(channel, to, subject, body string) (string, error) {
+	switch channel {
+	case "sms":
+		provider, err := configuredSMSProvider()
+		if err != nil {
+			return "", err
+		}
+		return provider.sendSMS(to, body)
+	case "whatsapp":
+		return gatewaySend(os.Getenv("WHATSAPP_GATEWAY_URL"), "WHATSAPP_GATEWAY_URL", to, body)
+	case "email":
+		return sendEmail(to, subject, body)
+	}
+	return "", fmt.Errorf("unknown channel %q", channel)
+}
+
+// smsProvider sends a text message and returns the provider's id for it.
+type smsProvider interface {
+	sendSMS(to, message string) (string, error)
+}
+
+// configuredSMSProvider is the provider SMS_PROVIDER names.
+func configuredSMSProvider() (smsProvider, error) {
+	switch name := os.Getenv("SMS_PROVIDER"); name {
+	case "", "gateway":
+		return smsGateway{url: os.Getenv("SMS_GATEWAY_URL")}, nil
+	case "twilio":
+		return twilioSMS{accountSID: os.Getenv("TWILIO_ACCOUNT_SID"), authToken: os.Getenv("TWILIO_AUTH_TOKEN"), from: os.Getenv("TWILIO_FROM")}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", name)
+	}
+}
+
+type smsGateway struct{ url string }
+
+func (g smsGateway) sendSMS(to, message string) (string, error) {
+	return gatewaySend(g.url, "SMS_GATEWAY_URL", to, message)
+}
+
+// twilioAPI is where Twilio's REST API is reached.
+var twilioAPI = "https://api.twilio.com"
+
+type twilioSMS struct{ accountSID, authToken, from string }
+
+func (t twilioSMS) sendSMS(to, message string) (string, error) {
+	if t.accountSID == "" || t.authToken == "" || t.from == "" {
+		return "", fmt.Errorf("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM must be set")
+	}
+	form := url.Values{"To": {to}, "From": {t.from}, "Body": {message}}
+	req, err := http.NewRequest("POST", twilioAPI+"/2010-04-01/Accounts/"+url.PathEscape(t.accountSID)+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(t.accountSID, t.authToken)
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		SID     string `json:"sid"`
+		Message string `json:"message"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if out.Message != "" {
+			return "", fmt.Errorf("twilio returned %s: %s", resp.Status, out.Message)
+		}
+		return "", fmt.Errorf("twilio returned %s", resp.Status)
+	}
+	return out.SID, nil
+}
+
+func gatewaySend(url, setting, to, message string) (string, error) {
+	if url == "" {
+		return "", fmt.Errorf("%s is not set", setting)
+	}
+	payload, _ := json.Marshal(map[string]string{"to": to, "message": message})
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv("MESSAGING_GATEWAY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("gateway returned %s", resp.Status)
+	}
+	var out struct {
+		ID string `json:"id"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	return out.ID, nil
+}
+
+// emailAttachment is a file sent along with an email.
+type emailAttachment struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+func sendEmail(to, subject, body string, attachments ...emailAttachment) (string, error) {
+	host, from := os.Getenv("SMTP_HOST"), os.Getenv("SMTP_FROM")
+	if host == "" || from == "" {
+		return "", fmt.Errorf("SMTP_HOST and SMTP_FROM must be set")
+	}
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	id := genID()
+	domain := from[strings.LastIndex(from, "@")+1:]
+	msg := "From: " + from + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + strings.NewReplacer("\r", "", "\n", " ").Replace(subject) + "\r\n" +
+		"Message-ID: <" + id + "@" + domain + ">\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n"
+	text := strings.ReplaceAll(body, "\n", "\r\n")
+	if len(attachments) == 0 {
+		msg += "Content-Type: text/plain; charset=utf-8\r\n\r\n" + text
+	} else {
+		boundary := "bizcalc-" + id
+		msg += "Content-Type: multipart/mixed; boundary=\"" + boundary + "\"\r\n\r\n" +
+			"--" + boundary + "\r\n" +
+			"Content-Type: text/plain; charset=utf-8\r\n\r\n" + text + "\r\n"
+		for _, a := range attachments {
+			msg += "--" + boundary + "\r\n" +
+				"Content-Type: " + a.contentType + "; name=\"" + a.name + "\"\r\n" +
+				"Content-Disposition: attachment; filename=\"" + a.name + "\"\r\n" +
+				"Content-Transfer-Encoding: base64\r\n\r\n" + base64Lines(a.data)
+		}
+		msg += "--" + boundary + "--\r\n"
+	}
+	if err := smtp.SendMail(host+":"+port, auth, from, []string{to}, []byte(msg)); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// base64Lines encodes data in the 76 character lines mail expects.
+func base64Lines(data []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	var b strings.Builder
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	return b.String()
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema changes live in migrations/ as numbered pairs of files:
+//
+//	0002_add_widgets.up.sql
+//	0002_add_widgets.down.sql
+//
+// They are embedded in the binary and applied in order at startup; the
+// versions applied so far are recorded in schema_migrations. Each migration
+// runs in its own transaction. Never edit a migration that has shipped —
+// add a new one instead.
+//
+// A migration that needs different SQL on PostgreSQL adds
+// 0002_add_widgets.postgres.up.sql (and .postgres.down.sql) next to the
+// shared files; see database.go.
+//
+// 0001_initial is the schema from before versioned migrations and has no
+// down file: reverting it would drop every table, so migrateDown stops
+// there.
+//
+// The server binary also accepts:
+//
+//	bizcalc-server migrate status
+//	bizcalc-server migrate up
+//	bizcalc-server migrate down [steps]
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+	pgUp    string
+	pgDown  string
+}
+
+// sql returns the up or down SQL of m for dialect d.
+func (m migration) sql(d dialect, up bool) string {
+	body := m.down
+	if up {
+		body = m.up
+	}
+	if d == postgresDialect {
+		if up && m.pgUp != "" {
+			body = m.pgUp
+		} else if !up && m.pgDown != "" {
+			body = m.pgDown
+		}
+	}
+	return d.translateDDL(body)
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*migration{}
+	for _, e := range entries {
+		file := e.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(file, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(file, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+		base := strings.TrimSuffix(strings.TrimSuffix(file, ".sql"), "."+direction)
+		postgres := strings.HasSuffix(base, ".postgres")
+		base = strings.TrimSuffix(base, ".postgres")
+		parts := strings.SplitN(base, "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration %s: name must look like 0001_name.up.sql", file)
+		}
+		body, err := migrationFiles.ReadFile("migrations/" + file)
+		if err != nil {
+			return nil, err
+		}
+		m := byVersion[version]
+		if m == nil {
+			m = &migration{version: version, name: parts[1]}
+			byVersion[version] = m
+		}
+		switch {
+		case postgres && direction == "up":
+			m.pgUp = string(body)
+		case postgres:
+			m.pgDown = string(body)
+		case direction == "up":
+			m.up = string(body)
+		default:
+			m.down = string(body)
+		}
+	}
+	var out []migration
+	for _, m := range byVersion {
+		if m.up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up file", m.version, m.name)
+		}
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].version < out[j].version })
+	return out, nil
+}
+
+func appliedMigrations(db *DB) (map[int]bool, error) {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY, name TEXT NOT NULL, applied_at TEXT NOT NULL)`); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// migrateUp applies every migration that has not been applied yet.
+func migrateUp(db *DB) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.sql(db.dialect, true)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %04d_%s: %w", m.version, m.name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version,name,applied_at) VALUES (?,?,?)`, m.version, m.name, time.Now().Format(time.RFC3339)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("applied migration %04d_%s", m.version, m.name)
+	}
+	return nil
+}
+
+// migrateDown reverts the most recently applied migrations.
+func migrateDown(db *DB, steps int) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if !applied[m.version] {
+			continue
+		}
+		if m.version == 1 {
+			return fmt.Errorf("migration %04d_%s cannot be reverted", m.version, m.name)
+		}
+		if m.down == "" {
+			return fmt.Errorf("migration %04d_%s has no down file", m.version, m.name)
+		}
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(m.sql(db.dialect, false)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("revert %04d_%s: %w", m.version, m.name, err)
+		}
+		if _, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("reverted migration %04d_%s", m.version, m.name)
+		steps--
+	}
+	return nil
+}
+
+func runMigrateCommand(args []string) {
+	db := openDB(dbConfig())
+	defer db.Close()
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	switch cmd {
+	case "up":
+		must(migrateUp(db))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				log.Fatalf("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		must(migrateDown(db, steps))
+	case "status":
+		migrations, err := loadMigrations()
+		must(err)
+		applied, err := appliedMigrations(db)
+		must(err)
+		for _, m := range migrations {
+			state := "pending"
+			if applied[m.version] {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.version, m.name, state)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "usage: migrate [up | down [steps] | status]")
+		os.Exit(2)
+	}
+}
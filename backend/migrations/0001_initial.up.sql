@@ -0,0 +1,72 @@
+-- The schema as it was before versioned migrations, when migrate.sql was
+-- run on every start. Existing databases already have these tables, so
+-- CREATE TABLE IF NOT EXISTS leaves them untouched. This migration cannot
+-- be reverted.
+
+CREATE TABLE IF NOT EXISTS organizations (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  owner_id TEXT NOT NULL,
+  status TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS contacts (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  phone TEXT NOT NULL,
+  nid TEXT,
+  type TEXT NOT NULL,
+  organization_id TEXT,
+  FOREIGN KEY (organization_id) REFERENCES organizations(id)
+);
+
+CREATE TABLE IF NOT EXISTS inventory_items (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  sku TEXT NOT NULL,
+  quantity INTEGER NOT NULL DEFAULT 0,
+  unit_price REAL NOT NULL DEFAULT 0,
+  reorder_level INTEGER NOT NULL DEFAULT 0,
+  category TEXT,
+  description TEXT,
+  image_filename TEXT,
+  image_url TEXT,
+  updated_at TEXT,
+  created_at TEXT
+);
+
+CREATE TABLE IF NOT EXISTS inventory_transactions (
+  id TEXT PRIMARY KEY,
+  item_id TEXT NOT NULL,
+  quantity_change INTEGER NOT NULL,
+  previous_quantity INTEGER NOT NULL,
+  new_quantity INTEGER NOT NULL,
+  transaction_type TEXT NOT NULL,
+  notes TEXT,
+  created_at TEXT,
+  FOREIGN KEY (item_id) REFERENCES inventory_items(id)
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+  id TEXT PRIMARY KEY,
+  type TEXT NOT NULL,
+  amount REAL NOT NULL,
+  paid_amount REAL NOT NULL,
+  due_amount REAL NOT NULL,
+  contact_id TEXT NOT NULL,
+  image_filename TEXT,
+  image_url TEXT,
+  created_at TEXT,
+  FOREIGN KEY (contact_id) REFERENCES contacts(id)
+);
+
+CREATE TABLE IF NOT EXISTS transaction_items (
+  id TEXT PRIMARY KEY,
+  transaction_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  quantity INTEGER NOT NULL,
+  unit_price REAL NOT NULL,
+  total_price REAL NOT NULL,
+  FOREIGN KEY (transaction_id) REFERENCES transactions(id),
+  FOREIGN KEY (item_id) REFERENCES inventory_items(id)
+);
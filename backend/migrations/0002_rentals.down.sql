@@ -0,0 +1,4 @@
+ALTER TABLE inventory_items DROP COLUMN late_fee_rate;
+ALTER TABLE inventory_items DROP COLUMN rental_rate;
+ALTER TABLE inventory_items DROP COLUMN rental_stock;
+DROP TABLE rentals;
@@ -0,0 +1,21 @@
+CREATE TABLE rentals (
+  id TEXT PRIMARY KEY,
+  item_id TEXT NOT NULL,
+  contact_id TEXT,
+  quantity INTEGER NOT NULL,
+  rate REAL NOT NULL DEFAULT 0,
+  checkout_at TEXT NOT NULL,
+  due_at TEXT NOT NULL,
+  returned_at TEXT,
+  rental_amount REAL NOT NULL DEFAULT 0,
+  late_fee REAL NOT NULL DEFAULT 0,
+  status TEXT NOT NULL,
+  notes TEXT,
+  created_at TEXT,
+  FOREIGN KEY (item_id) REFERENCES inventory_items(id),
+  FOREIGN KEY (contact_id) REFERENCES contacts(id)
+);
+
+ALTER TABLE inventory_items ADD COLUMN rental_stock INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE inventory_items ADD COLUMN rental_rate REAL NOT NULL DEFAULT 0;
+ALTER TABLE inventory_items ADD COLUMN late_fee_rate REAL NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP TABLE consignment_sales;
+DROP TABLE consignments;
@@ -0,0 +1,26 @@
+CREATE TABLE consignments (
+  id TEXT PRIMARY KEY,
+  direction TEXT NOT NULL,
+  contact_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  quantity INTEGER NOT NULL,
+  sold_quantity INTEGER NOT NULL DEFAULT 0,
+  returned_quantity INTEGER NOT NULL DEFAULT 0,
+  unit_cost REAL NOT NULL DEFAULT 0,
+  status TEXT NOT NULL,
+  notes TEXT,
+  created_at TEXT,
+  FOREIGN KEY (contact_id) REFERENCES contacts(id),
+  FOREIGN KEY (item_id) REFERENCES inventory_items(id)
+);
+
+CREATE TABLE consignment_sales (
+  id TEXT PRIMARY KEY,
+  consignment_id TEXT NOT NULL,
+  transaction_id TEXT,
+  quantity INTEGER NOT NULL,
+  sale_price REAL NOT NULL DEFAULT 0,
+  settled_at TEXT,
+  created_at TEXT,
+  FOREIGN KEY (consignment_id) REFERENCES consignments(id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE transactions DROP COLUMN source;
+ALTER TABLE inventory_items DROP COLUMN cost_price;
+DROP TABLE opening_balances;
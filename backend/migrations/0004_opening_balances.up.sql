@@ -0,0 +1,14 @@
+CREATE TABLE opening_balances (
+  id TEXT PRIMARY KEY,
+  kind TEXT NOT NULL,
+  ref_id TEXT,
+  quantity INTEGER,
+  unit_cost REAL,
+  amount REAL NOT NULL DEFAULT 0,
+  cutover_date TEXT NOT NULL,
+  record_id TEXT,
+  created_at TEXT
+);
+
+ALTER TABLE inventory_items ADD COLUMN cost_price REAL NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN source TEXT;
@@ -0,0 +1,2 @@
+DROP TABLE year_end_balances;
+DROP TABLE fiscal_years;
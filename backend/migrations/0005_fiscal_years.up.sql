@@ -0,0 +1,19 @@
+CREATE TABLE fiscal_years (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  start_date TEXT NOT NULL,
+  end_date TEXT NOT NULL,
+  status TEXT NOT NULL,
+  report TEXT,
+  closed_at TEXT
+);
+
+CREATE TABLE year_end_balances (
+  id TEXT PRIMARY KEY,
+  fiscal_year_id TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  ref_id TEXT NOT NULL,
+  quantity INTEGER,
+  amount REAL NOT NULL DEFAULT 0,
+  FOREIGN KEY (fiscal_year_id) REFERENCES fiscal_years(id)
+);
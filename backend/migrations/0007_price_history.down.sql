@@ -0,0 +1,2 @@
+ALTER TABLE inventory_items DROP COLUMN supplier_id;
+DROP TABLE price_history;
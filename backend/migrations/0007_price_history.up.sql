@@ -0,0 +1,12 @@
+CREATE TABLE price_history (
+  id TEXT PRIMARY KEY,
+  item_id TEXT NOT NULL,
+  old_price REAL NOT NULL,
+  new_price REAL NOT NULL,
+  reason TEXT,
+  created_at TEXT,
+  FOREIGN KEY (item_id) REFERENCES inventory_items(id)
+);
+
+-- contacts.id; no REFERENCES clause so the column can be dropped again
+ALTER TABLE inventory_items ADD COLUMN supplier_id TEXT;
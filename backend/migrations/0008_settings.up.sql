@@ -0,0 +1,8 @@
+CREATE TABLE settings (
+  scope TEXT NOT NULL,
+  scope_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  value TEXT NOT NULL,
+  updated_at TEXT,
+  PRIMARY KEY (scope, scope_id, key)
+);
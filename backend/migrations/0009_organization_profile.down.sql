@@ -0,0 +1,9 @@
+ALTER TABLE organizations DROP COLUMN logo_url;
+ALTER TABLE organizations DROP COLUMN logo_filename;
+ALTER TABLE organizations DROP COLUMN invoice_terms;
+ALTER TABLE organizations DROP COLUMN invoice_footer;
+ALTER TABLE organizations DROP COLUMN vat_registration_no;
+ALTER TABLE organizations DROP COLUMN tax_registration_no;
+ALTER TABLE organizations DROP COLUMN email;
+ALTER TABLE organizations DROP COLUMN phone;
+ALTER TABLE organizations DROP COLUMN address;
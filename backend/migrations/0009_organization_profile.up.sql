@@ -0,0 +1,9 @@
+ALTER TABLE organizations ADD COLUMN address TEXT;
+ALTER TABLE organizations ADD COLUMN phone TEXT;
+ALTER TABLE organizations ADD COLUMN email TEXT;
+ALTER TABLE organizations ADD COLUMN tax_registration_no TEXT;
+ALTER TABLE organizations ADD COLUMN vat_registration_no TEXT;
+ALTER TABLE organizations ADD COLUMN invoice_footer TEXT;
+ALTER TABLE organizations ADD COLUMN invoice_terms TEXT;
+ALTER TABLE organizations ADD COLUMN logo_filename TEXT;
+ALTER TABLE organizations ADD COLUMN logo_url TEXT;
@@ -0,0 +1,11 @@
+CREATE TABLE number_sequences (
+  organization_id TEXT NOT NULL,
+  key TEXT NOT NULL,
+  prefix TEXT NOT NULL DEFAULT '',
+  padding INTEGER NOT NULL DEFAULT 5,
+  next_number INTEGER NOT NULL DEFAULT 1,
+  reset TEXT NOT NULL DEFAULT 'never',
+  period TEXT,
+  updated_at TEXT,
+  PRIMARY KEY (organization_id, key)
+);
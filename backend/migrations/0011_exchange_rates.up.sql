@@ -0,0 +1,10 @@
+CREATE TABLE exchange_rates (
+  id TEXT PRIMARY KEY,
+  base TEXT NOT NULL,
+  currency TEXT NOT NULL,
+  rate REAL NOT NULL,
+  rate_date TEXT NOT NULL,
+  source TEXT NOT NULL,
+  created_at TEXT,
+  UNIQUE (base, currency, rate_date, source)
+);
@@ -0,0 +1,2 @@
+DROP TABLE transaction_payments;
+DROP TABLE payment_methods;
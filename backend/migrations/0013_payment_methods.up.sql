@@ -0,0 +1,17 @@
+CREATE TABLE payment_methods (
+  id TEXT PRIMARY KEY,
+  code TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  active INTEGER NOT NULL DEFAULT 1,
+  created_at TEXT
+);
+
+CREATE TABLE transaction_payments (
+  id TEXT PRIMARY KEY,
+  transaction_id TEXT NOT NULL,
+  method TEXT NOT NULL,
+  amount REAL NOT NULL,
+  reference TEXT,
+  created_at TEXT,
+  FOREIGN KEY (transaction_id) REFERENCES transactions(id)
+);
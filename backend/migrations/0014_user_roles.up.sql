@@ -0,0 +1,3 @@
+-- users created before roles keep full access
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'cashier';
+UPDATE users SET role = 'admin';
@@ -0,0 +1,4 @@
+ALTER TABLE transaction_payments DROP COLUMN account_id;
+ALTER TABLE payment_methods DROP COLUMN account_id;
+DROP TABLE account_movements;
+DROP TABLE cash_accounts;
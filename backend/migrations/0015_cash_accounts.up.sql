@@ -0,0 +1,25 @@
+CREATE TABLE cash_accounts (
+  id TEXT PRIMARY KEY,
+  name TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  account_no TEXT,
+  opening_balance REAL NOT NULL DEFAULT 0,
+  active INTEGER NOT NULL DEFAULT 1,
+  created_at TEXT
+);
+
+CREATE TABLE account_movements (
+  id TEXT PRIMARY KEY,
+  account_id TEXT NOT NULL,
+  amount REAL NOT NULL,
+  kind TEXT NOT NULL,
+  ref_type TEXT,
+  ref_id TEXT,
+  notes TEXT,
+  created_at TEXT,
+  FOREIGN KEY (account_id) REFERENCES cash_accounts(id)
+);
+
+-- cash_accounts.id; no REFERENCES clause so the columns can be dropped again
+ALTER TABLE payment_methods ADD COLUMN account_id TEXT;
+ALTER TABLE transaction_payments ADD COLUMN account_id TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE transactions DROP COLUMN organization_id;
+ALTER TABLE inventory_transactions DROP COLUMN organization_id;
+ALTER TABLE inventory_items DROP COLUMN organization_id;
@@ -0,0 +1,4 @@
+-- organizations.id; no REFERENCES clause so the columns can be dropped again
+ALTER TABLE inventory_items ADD COLUMN organization_id TEXT;
+ALTER TABLE inventory_transactions ADD COLUMN organization_id TEXT;
+ALTER TABLE transactions ADD COLUMN organization_id TEXT;
@@ -0,0 +1,2 @@
+DROP TABLE deposit_items;
+DROP TABLE deposits;
@@ -0,0 +1,25 @@
+CREATE TABLE deposits (
+  id TEXT PRIMARY KEY,
+  from_account_id TEXT NOT NULL,
+  to_account_id TEXT NOT NULL,
+  cash_total REAL NOT NULL DEFAULT 0,
+  cheque_total REAL NOT NULL DEFAULT 0,
+  total REAL NOT NULL,
+  reference TEXT,
+  deposited_at TEXT NOT NULL,
+  created_at TEXT,
+  FOREIGN KEY (from_account_id) REFERENCES cash_accounts(id),
+  FOREIGN KEY (to_account_id) REFERENCES cash_accounts(id)
+);
+
+CREATE TABLE deposit_items (
+  id TEXT PRIMARY KEY,
+  deposit_id TEXT NOT NULL,
+  payment_id TEXT UNIQUE,
+  kind TEXT NOT NULL,
+  amount REAL NOT NULL,
+  reference TEXT,
+  created_at TEXT,
+  FOREIGN KEY (deposit_id) REFERENCES deposits(id),
+  FOREIGN KEY (payment_id) REFERENCES transaction_payments(id)
+);
@@ -0,0 +1,37 @@
+-- going back to one global set keeps the first row of each code / rate
+CREATE TABLE exchange_rates_old (
+  id TEXT PRIMARY KEY,
+  base TEXT NOT NULL,
+  currency TEXT NOT NULL,
+  rate REAL NOT NULL,
+  rate_date TEXT NOT NULL,
+  source TEXT NOT NULL,
+  created_at TEXT,
+  UNIQUE (base, currency, rate_date, source)
+);
+INSERT INTO exchange_rates_old (id,base,currency,rate,rate_date,source,created_at)
+  SELECT id,base,currency,rate,rate_date,source,created_at FROM exchange_rates ORDER BY created_at, id
+  ON CONFLICT DO NOTHING;
+DROP TABLE exchange_rates;
+ALTER TABLE exchange_rates_old RENAME TO exchange_rates;
+
+CREATE TABLE payment_methods_old (
+  id TEXT PRIMARY KEY,
+  code TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  active INTEGER NOT NULL DEFAULT 1,
+  created_at TEXT,
+  account_id TEXT
+);
+INSERT INTO payment_methods_old (id,code,name,active,created_at,account_id)
+  SELECT id,code,name,active,created_at,account_id FROM payment_methods ORDER BY created_at, id
+  ON CONFLICT DO NOTHING;
+DROP TABLE payment_methods;
+ALTER TABLE payment_methods_old RENAME TO payment_methods;
+
+ALTER TABLE deposits DROP COLUMN organization_id;
+ALTER TABLE cash_accounts DROP COLUMN organization_id;
+ALTER TABLE fiscal_years DROP COLUMN organization_id;
+ALTER TABLE opening_balances DROP COLUMN organization_id;
+ALTER TABLE consignments DROP COLUMN organization_id;
+ALTER TABLE rentals DROP COLUMN organization_id;
@@ -0,0 +1,45 @@
+-- organizations.id; no REFERENCES clause so the columns can be dropped again
+ALTER TABLE rentals ADD COLUMN organization_id TEXT;
+ALTER TABLE consignments ADD COLUMN organization_id TEXT;
+ALTER TABLE opening_balances ADD COLUMN organization_id TEXT;
+ALTER TABLE fiscal_years ADD COLUMN organization_id TEXT;
+ALTER TABLE cash_accounts ADD COLUMN organization_id TEXT;
+ALTER TABLE deposits ADD COLUMN organization_id TEXT;
+
+-- payment method codes become unique per organization, which needs the
+-- table rebuilt to drop the old UNIQUE (code)
+CREATE TABLE payment_methods_new (
+  id TEXT PRIMARY KEY,
+  code TEXT NOT NULL,
+  name TEXT NOT NULL,
+  active INTEGER NOT NULL DEFAULT 1,
+  created_at TEXT,
+  account_id TEXT,
+  organization_id TEXT,
+  UNIQUE (organization_id, code)
+);
+INSERT INTO payment_methods_new (id,code,name,active,created_at,account_id)
+  SELECT id,code,name,active,created_at,account_id FROM payment_methods;
+DROP TABLE payment_methods;
+ALTER TABLE payment_methods_new RENAME TO payment_methods;
+
+-- fetched rates stay shared (organization_id = ''); manual overrides
+-- belong to the organization that set them
+CREATE TABLE exchange_rates_new (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL DEFAULT '',
+  base TEXT NOT NULL,
+  currency TEXT NOT NULL,
+  rate REAL NOT NULL,
+  rate_date TEXT NOT NULL,
+  source TEXT NOT NULL,
+  created_at TEXT,
+  UNIQUE (organization_id, base, currency, rate_date, source)
+);
+INSERT INTO exchange_rates_new (id,organization_id,base,currency,rate,rate_date,source,created_at)
+  SELECT id,
+    CASE source WHEN 'manual' THEN COALESCE((SELECT id FROM organizations ORDER BY id LIMIT 1), '') ELSE '' END,
+    base,currency,rate,rate_date,source,created_at
+  FROM exchange_rates;
+DROP TABLE exchange_rates;
+ALTER TABLE exchange_rates_new RENAME TO exchange_rates;
@@ -0,0 +1,3 @@
+-- lets firstOrganizationID find the oldest organization without SQLite's
+-- rowid; organizations from before this column keep NULL
+ALTER TABLE organizations ADD COLUMN created_at TEXT;
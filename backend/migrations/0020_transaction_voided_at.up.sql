@@ -0,0 +1,3 @@
+-- set when a transaction is voided; voided transactions stay for audit
+-- but are left out of reports
+ALTER TABLE transactions ADD COLUMN voided_at TEXT;
@@ -0,0 +1,15 @@
+-- chunked uploads in progress; received is how many bytes have arrived
+CREATE TABLE upload_sessions (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  user_id TEXT,
+  collection TEXT NOT NULL,
+  record_id TEXT NOT NULL,
+  filename TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  received INTEGER NOT NULL DEFAULT 0,
+  status TEXT NOT NULL,
+  url TEXT,
+  created_at TEXT,
+  updated_at TEXT
+);
@@ -0,0 +1,10 @@
+-- uploads the scanner rejected; the file itself is kept in data/quarantine/<id>
+CREATE TABLE quarantined_uploads (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  location TEXT NOT NULL,
+  filename TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  signature TEXT,
+  created_at TEXT
+);
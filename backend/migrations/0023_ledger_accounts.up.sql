@@ -0,0 +1,13 @@
+-- chart of accounts; system_key marks the accounts postings are made to
+-- (cash, accounts_receivable, inventory, sales, cogs, ...)
+CREATE TABLE ledger_accounts (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  code TEXT NOT NULL,
+  name TEXT NOT NULL,
+  type TEXT NOT NULL,
+  system_key TEXT,
+  active INTEGER NOT NULL DEFAULT 1,
+  created_at TEXT,
+  UNIQUE (organization_id, code)
+);
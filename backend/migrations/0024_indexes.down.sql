@@ -0,0 +1,15 @@
+DROP INDEX idx_transactions_org_created;
+DROP INDEX idx_transactions_contact;
+DROP INDEX idx_transaction_items_transaction;
+DROP INDEX idx_transaction_items_item;
+DROP INDEX idx_transaction_payments_transaction;
+DROP INDEX idx_inventory_transactions_item;
+DROP INDEX idx_inventory_transactions_org_created;
+DROP INDEX idx_inventory_items_org_sku;
+DROP INDEX idx_contacts_org;
+DROP INDEX idx_account_movements_account;
+DROP INDEX idx_consignment_sales_consignment;
+DROP INDEX idx_consignment_sales_transaction;
+DROP INDEX idx_price_history_item;
+DROP INDEX idx_rentals_item;
+DROP INDEX idx_deposit_items_deposit;
@@ -0,0 +1,16 @@
+-- foreign keys and the date ranges reports filter on
+CREATE INDEX idx_transactions_org_created ON transactions (organization_id, created_at);
+CREATE INDEX idx_transactions_contact ON transactions (contact_id);
+CREATE INDEX idx_transaction_items_transaction ON transaction_items (transaction_id);
+CREATE INDEX idx_transaction_items_item ON transaction_items (item_id);
+CREATE INDEX idx_transaction_payments_transaction ON transaction_payments (transaction_id);
+CREATE INDEX idx_inventory_transactions_item ON inventory_transactions (item_id, created_at);
+CREATE INDEX idx_inventory_transactions_org_created ON inventory_transactions (organization_id, created_at);
+CREATE INDEX idx_inventory_items_org_sku ON inventory_items (organization_id, sku);
+CREATE INDEX idx_contacts_org ON contacts (organization_id);
+CREATE INDEX idx_account_movements_account ON account_movements (account_id, created_at);
+CREATE INDEX idx_consignment_sales_consignment ON consignment_sales (consignment_id);
+CREATE INDEX idx_consignment_sales_transaction ON consignment_sales (transaction_id);
+CREATE INDEX idx_price_history_item ON price_history (item_id, created_at);
+CREATE INDEX idx_rentals_item ON rentals (item_id);
+CREATE INDEX idx_deposit_items_deposit ON deposit_items (deposit_id);
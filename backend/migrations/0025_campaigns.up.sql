@@ -0,0 +1,34 @@
+-- messaging campaigns; segment is the JSON contact selection
+CREATE TABLE campaigns (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  channel TEXT NOT NULL,
+  subject TEXT,
+  template TEXT NOT NULL,
+  segment TEXT,
+  status TEXT NOT NULL DEFAULT 'draft',
+  scheduled_at TEXT,
+  created_by TEXT,
+  created_at TEXT,
+  completed_at TEXT
+);
+
+-- one row per contact a campaign goes to, snapshotted when it is scheduled
+CREATE TABLE campaign_recipients (
+  id TEXT PRIMARY KEY,
+  campaign_id TEXT NOT NULL,
+  contact_id TEXT NOT NULL,
+  address TEXT,
+  message TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'pending',
+  error TEXT,
+  provider_message_id TEXT,
+  sent_at TEXT,
+  delivered_at TEXT,
+  FOREIGN KEY (campaign_id) REFERENCES campaigns(id)
+);
+
+CREATE INDEX idx_campaigns_organization ON campaigns(organization_id);
+CREATE INDEX idx_campaign_recipients_campaign ON campaign_recipients(campaign_id, status);
+CREATE INDEX idx_campaign_recipients_provider ON campaign_recipients(provider_message_id);
@@ -0,0 +1,4 @@
+DROP INDEX idx_transactions_purchase_order;
+ALTER TABLE transactions DROP COLUMN purchase_order_id;
+DROP TABLE purchase_order_items;
+DROP TABLE purchase_orders;
@@ -0,0 +1,33 @@
+-- purchase orders to suppliers; stock arrives through transactions that
+-- reference the order, possibly over several deliveries
+CREATE TABLE purchase_orders (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  number TEXT NOT NULL,
+  supplier_id TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'draft',
+  expected_at TEXT,
+  notes TEXT,
+  total REAL NOT NULL DEFAULT 0,
+  created_by TEXT,
+  created_at TEXT,
+  updated_at TEXT,
+  FOREIGN KEY (supplier_id) REFERENCES contacts(id)
+);
+
+CREATE TABLE purchase_order_items (
+  id TEXT PRIMARY KEY,
+  purchase_order_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  quantity INTEGER NOT NULL,
+  unit_cost REAL NOT NULL,
+  received_quantity INTEGER NOT NULL DEFAULT 0,
+  FOREIGN KEY (purchase_order_id) REFERENCES purchase_orders(id),
+  FOREIGN KEY (item_id) REFERENCES inventory_items(id)
+);
+
+ALTER TABLE transactions ADD COLUMN purchase_order_id TEXT;
+
+CREATE INDEX idx_purchase_orders_organization ON purchase_orders(organization_id, status);
+CREATE INDEX idx_purchase_order_items_order ON purchase_order_items(purchase_order_id);
+CREATE INDEX idx_transactions_purchase_order ON transactions(purchase_order_id);
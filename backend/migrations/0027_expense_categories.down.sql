@@ -0,0 +1,3 @@
+DROP TABLE expense_rules;
+ALTER TABLE account_movements DROP COLUMN category;
+ALTER TABLE account_movements DROP COLUMN vendor;
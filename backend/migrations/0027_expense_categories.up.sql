@@ -0,0 +1,21 @@
+-- expenses are account_movements of kind 'expense'; vendor and category
+-- let them be grouped, and expense_rules assign categories automatically
+ALTER TABLE account_movements ADD COLUMN vendor TEXT;
+ALTER TABLE account_movements ADD COLUMN category TEXT;
+
+-- kind is 'vendor' (pattern equals the vendor) or 'keyword' (pattern
+-- appears in the vendor or notes); patterns are stored lower-cased.
+-- source is 'manual' for rules entered by a user and 'learned' for rules
+-- taken from a correction
+CREATE TABLE expense_rules (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  pattern TEXT NOT NULL,
+  category TEXT NOT NULL,
+  source TEXT NOT NULL DEFAULT 'manual',
+  hits INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT,
+  updated_at TEXT,
+  UNIQUE (organization_id, kind, pattern)
+);
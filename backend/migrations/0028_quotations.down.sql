@@ -0,0 +1,2 @@
+DROP TABLE quotation_items;
+DROP TABLE quotations;
@@ -0,0 +1,31 @@
+-- price quotes to customers; transaction_id is the sale an accepted quote
+-- was converted into
+CREATE TABLE quotations (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  number TEXT NOT NULL,
+  contact_id TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'draft',
+  valid_until TEXT,
+  notes TEXT,
+  total REAL NOT NULL DEFAULT 0,
+  transaction_id TEXT,
+  created_by TEXT,
+  created_at TEXT,
+  updated_at TEXT,
+  FOREIGN KEY (contact_id) REFERENCES contacts(id)
+);
+
+CREATE TABLE quotation_items (
+  id TEXT PRIMARY KEY,
+  quotation_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  quantity INTEGER NOT NULL,
+  unit_price REAL NOT NULL,
+  total_price REAL NOT NULL,
+  FOREIGN KEY (quotation_id) REFERENCES quotations(id),
+  FOREIGN KEY (item_id) REFERENCES inventory_items(id)
+);
+
+CREATE INDEX idx_quotations_organization ON quotations(organization_id, status);
+CREATE INDEX idx_quotation_items_quotation ON quotation_items(quotation_id);
@@ -0,0 +1,3 @@
+DROP TABLE recurring_expenses;
+DROP TABLE transaction_installments;
+ALTER TABLE transactions DROP COLUMN due_date;
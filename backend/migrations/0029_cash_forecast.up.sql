@@ -0,0 +1,30 @@
+-- when the unpaid part of a transaction falls due (YYYY-MM-DD); when NULL
+-- it falls due payment_terms_days after the transaction
+ALTER TABLE transactions ADD COLUMN due_date TEXT;
+
+-- the unpaid part of a transaction split into dated installments
+CREATE TABLE transaction_installments (
+  id TEXT PRIMARY KEY,
+  transaction_id TEXT NOT NULL,
+  due_date TEXT NOT NULL,
+  amount REAL NOT NULL,
+  FOREIGN KEY (transaction_id) REFERENCES transactions(id)
+);
+
+-- expenses that repeat (rent, salaries, utilities); frequency is weekly,
+-- monthly or yearly and next_date the next time one is paid
+CREATE TABLE recurring_expenses (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  amount REAL NOT NULL,
+  category TEXT,
+  frequency TEXT NOT NULL,
+  next_date TEXT NOT NULL,
+  end_date TEXT,
+  active INTEGER NOT NULL DEFAULT 1,
+  created_at TEXT
+);
+
+CREATE INDEX idx_transaction_installments_transaction ON transaction_installments(transaction_id);
+CREATE INDEX idx_recurring_expenses_organization ON recurring_expenses(organization_id);
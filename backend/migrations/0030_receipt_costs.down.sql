@@ -0,0 +1 @@
+ALTER TABLE inventory_transactions DROP COLUMN unit_cost;
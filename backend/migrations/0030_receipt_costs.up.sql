@@ -0,0 +1,6 @@
+-- what a unit received by a stock movement cost, for FIFO and weighted
+-- average valuation; NULL for movements that are not purchases
+ALTER TABLE inventory_transactions ADD COLUMN unit_cost REAL;
+
+UPDATE inventory_transactions SET unit_cost = (SELECT unit_cost FROM opening_balances WHERE record_id = inventory_transactions.id)
+WHERE transaction_type = 'opening';
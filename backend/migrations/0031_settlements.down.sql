@@ -0,0 +1,4 @@
+DROP TABLE settlement_credits;
+ALTER TABLE payment_methods DROP COLUMN settlement_days;
+ALTER TABLE payment_methods DROP COLUMN fee_fixed;
+ALTER TABLE payment_methods DROP COLUMN fee_percent;
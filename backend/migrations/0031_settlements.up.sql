@@ -0,0 +1,21 @@
+-- what a payment provider (card acquirer, bKash, Nagad) keeps per payment
+-- and how many days after the payment it pays the rest into the bank;
+-- settlement_days is NULL for methods nobody settles, such as cash
+ALTER TABLE payment_methods ADD COLUMN fee_percent REAL NOT NULL DEFAULT 0;
+ALTER TABLE payment_methods ADD COLUMN fee_fixed REAL NOT NULL DEFAULT 0;
+ALTER TABLE payment_methods ADD COLUMN settlement_days INTEGER;
+
+-- money a provider actually paid in, as imported from bank statements
+CREATE TABLE settlement_credits (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  method TEXT NOT NULL,
+  credited_on TEXT NOT NULL,
+  amount REAL NOT NULL,
+  reference TEXT NOT NULL DEFAULT '',
+  description TEXT,
+  created_at TEXT,
+  UNIQUE (organization_id, method, credited_on, amount, reference)
+);
+
+CREATE INDEX idx_settlement_credits_organization ON settlement_credits(organization_id, credited_on);
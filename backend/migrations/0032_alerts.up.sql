@@ -0,0 +1,22 @@
+-- conditions someone should act on, raised by background checks; kind
+-- says what was detected (low_stock) and ref_id what about (an item).
+-- details holds JSON with the figures behind the alert. An alert is open
+-- until acknowledged, and resolved once the condition clears.
+CREATE TABLE alerts (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  ref_id TEXT NOT NULL,
+  message TEXT NOT NULL,
+  details TEXT,
+  status TEXT NOT NULL DEFAULT 'open',
+  created_at TEXT,
+  acknowledged_at TEXT,
+  acknowledged_by TEXT,
+  resolved_at TEXT,
+  notified_at TEXT
+);
+
+-- one unresolved alert per condition
+CREATE UNIQUE INDEX idx_alerts_unresolved ON alerts(organization_id, kind, ref_id) WHERE status <> 'resolved';
+CREATE INDEX idx_alerts_organization ON alerts(organization_id, status);
@@ -0,0 +1,2 @@
+ALTER TABLE refresh_tokens DROP COLUMN organization_id;
+DROP TABLE organization_members;
@@ -0,0 +1,20 @@
+-- which organizations a user belongs to and with what role. users keeps
+-- organization_id and role for the organization a login starts in.
+CREATE TABLE organization_members (
+  user_id TEXT NOT NULL,
+  organization_id TEXT NOT NULL,
+  role TEXT NOT NULL,
+  created_at TEXT,
+  PRIMARY KEY (user_id, organization_id),
+  FOREIGN KEY (user_id) REFERENCES users(id),
+  FOREIGN KEY (organization_id) REFERENCES organizations(id)
+);
+
+CREATE INDEX idx_organization_members_organization ON organization_members(organization_id);
+
+INSERT INTO organization_members (user_id, organization_id, role, created_at)
+SELECT id, organization_id, role, created_at FROM users WHERE organization_id IS NOT NULL AND organization_id <> '';
+
+-- the organization a refresh token's access tokens are for
+ALTER TABLE refresh_tokens ADD COLUMN organization_id TEXT;
+UPDATE refresh_tokens SET organization_id = (SELECT organization_id FROM users WHERE users.id = refresh_tokens.user_id);
@@ -0,0 +1,22 @@
+-- invitations to join an organization with a preassigned role; the link
+-- sent out carries a random token, stored here only as its SHA-256
+CREATE TABLE invites (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  email TEXT,
+  phone TEXT,
+  channel TEXT NOT NULL,
+  role TEXT NOT NULL,
+  invited_by TEXT,
+  created_at TEXT,
+  expires_at TEXT NOT NULL,
+  sent_at TEXT,
+  delivery_error TEXT,
+  accepted_at TEXT,
+  accepted_by TEXT,
+  revoked_at TEXT,
+  FOREIGN KEY (organization_id) REFERENCES organizations(id)
+);
+
+CREATE INDEX idx_invites_organization ON invites(organization_id, created_at);
@@ -0,0 +1,18 @@
+-- devices users of restricted roles have signed in from, identified by the
+-- client's X-Device-ID; with device binding on only approved ones may be used
+CREATE TABLE devices (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  device_key TEXT NOT NULL,
+  name TEXT,
+  first_ip TEXT,
+  last_ip TEXT,
+  created_at TEXT,
+  last_seen_at TEXT,
+  approved_at TEXT,
+  approved_by TEXT,
+  revoked_at TEXT,
+  UNIQUE (organization_id, user_id, device_key),
+  FOREIGN KEY (user_id) REFERENCES users(id)
+);
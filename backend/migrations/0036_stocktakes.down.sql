@@ -0,0 +1,2 @@
+DROP TABLE stocktake_lines;
+DROP TABLE stocktakes;
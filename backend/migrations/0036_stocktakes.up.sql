@@ -0,0 +1,30 @@
+-- physical stock counts; a line per item in scope holds the system
+-- quantity when the item was last counted (or when the count started) and
+-- the quantity counted. Posting adjusts stock by the difference.
+CREATE TABLE stocktakes (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  number TEXT NOT NULL,
+  status TEXT NOT NULL DEFAULT 'open',
+  category TEXT,
+  notes TEXT,
+  created_by TEXT,
+  created_at TEXT,
+  posted_at TEXT,
+  posted_by TEXT
+);
+
+CREATE TABLE stocktake_lines (
+  id TEXT PRIMARY KEY,
+  stocktake_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  system_quantity INTEGER NOT NULL,
+  counted_quantity INTEGER,
+  counted_at TEXT,
+  counted_by TEXT,
+  UNIQUE (stocktake_id, item_id),
+  FOREIGN KEY (stocktake_id) REFERENCES stocktakes(id),
+  FOREIGN KEY (item_id) REFERENCES inventory_items(id)
+);
+
+CREATE INDEX idx_stocktakes_organization ON stocktakes(organization_id, status);
@@ -0,0 +1,3 @@
+ALTER TABLE inventory_transactions DROP COLUMN created_by;
+ALTER TABLE transactions DROP COLUMN voided_by;
+DROP TABLE login_failures;
@@ -0,0 +1,15 @@
+-- failed sign-ins, kept to spot password guessing; user_id is set when
+-- the email belongs to an account
+CREATE TABLE login_failures (
+  id TEXT PRIMARY KEY,
+  email TEXT NOT NULL,
+  user_id TEXT,
+  ip TEXT,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_login_failures_user ON login_failures(user_id, created_at);
+
+-- who voided a transaction and who recorded a stock movement, for the
+-- void spike and after-hours adjustment alerts
+ALTER TABLE transactions ADD COLUMN voided_by TEXT;
+ALTER TABLE inventory_transactions ADD COLUMN created_by TEXT;
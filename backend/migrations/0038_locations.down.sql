@@ -0,0 +1,4 @@
+ALTER TABLE inventory_transactions DROP COLUMN location_id;
+ALTER TABLE transaction_items DROP COLUMN location_id;
+DROP TABLE item_locations;
+DROP TABLE locations;
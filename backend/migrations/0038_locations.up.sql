@@ -0,0 +1,25 @@
+-- places stock is kept, such as a shop and a godown. An item's quantity in
+-- inventory_items stays its total; item_locations holds what is at each
+-- location other than the organization's default, and the rest is at the
+-- default location.
+CREATE TABLE locations (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  code TEXT,
+  is_default INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT
+);
+CREATE INDEX idx_locations_organization ON locations(organization_id);
+
+CREATE TABLE item_locations (
+  item_id TEXT NOT NULL,
+  location_id TEXT NOT NULL,
+  quantity INTEGER NOT NULL DEFAULT 0,
+  PRIMARY KEY (item_id, location_id)
+);
+CREATE INDEX idx_item_locations_location ON item_locations(location_id);
+
+-- where a sale or purchase line and a stock movement took stock from or to
+ALTER TABLE transaction_items ADD COLUMN location_id TEXT;
+ALTER TABLE inventory_transactions ADD COLUMN location_id TEXT;
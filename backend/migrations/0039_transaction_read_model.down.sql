@@ -0,0 +1,2 @@
+ALTER TABLE transactions DROP COLUMN items_summary;
+ALTER TABLE transactions DROP COLUMN contact_name;
@@ -0,0 +1,19 @@
+-- copies kept on each transaction so the transactions list can show the
+-- contact and the items without joining contacts and reading
+-- transaction_items per row; kept up to date on write (see read_model.go).
+-- items_summary is a JSON array of the transaction's item lines.
+ALTER TABLE transactions ADD COLUMN contact_name TEXT;
+ALTER TABLE transactions ADD COLUMN items_summary TEXT;
+
+UPDATE transactions SET contact_name = (SELECT name FROM contacts WHERE contacts.id = transactions.contact_id);
+UPDATE transactions SET items_summary = (
+  SELECT json_group_array(json_object(
+    'item_id', ti.item_id,
+    'item_name', COALESCE(i.name, 'Unnamed Item'),
+    'name', COALESCE(i.name, 'Unnamed Item'),
+    'sku', COALESCE(i.sku, ''),
+    'quantity', ti.quantity,
+    'unit_price', ti.unit_price,
+    'total_price', ti.total_price))
+  FROM transaction_items ti LEFT JOIN inventory_items i ON i.id = ti.item_id
+  WHERE ti.transaction_id = transactions.id);
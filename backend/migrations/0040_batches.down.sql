@@ -0,0 +1,2 @@
+DROP TABLE batch_movements;
+DROP TABLE item_batches;
@@ -0,0 +1,29 @@
+-- lots of an item received together, with what is left of each. Sales
+-- take from the batch expiring first; stock received before batches
+-- existed is simply not in any batch.
+CREATE TABLE item_batches (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  lot_number TEXT NOT NULL,
+  expiry_date TEXT,
+  received_quantity INTEGER NOT NULL DEFAULT 0,
+  quantity INTEGER NOT NULL DEFAULT 0,
+  unit_cost REAL,
+  transaction_id TEXT,
+  received_at TEXT
+);
+CREATE INDEX idx_item_batches_item ON item_batches(item_id);
+CREATE INDEX idx_item_batches_expiry ON item_batches(organization_id, expiry_date);
+
+-- what each transaction put into or took out of a batch, so voiding it
+-- can put the units back
+CREATE TABLE batch_movements (
+  id TEXT PRIMARY KEY,
+  batch_id TEXT NOT NULL,
+  transaction_id TEXT,
+  quantity INTEGER NOT NULL,
+  created_at TEXT
+);
+CREATE INDEX idx_batch_movements_batch ON batch_movements(batch_id);
+CREATE INDEX idx_batch_movements_transaction ON batch_movements(transaction_id);
@@ -0,0 +1,6 @@
+ALTER TABLE purchase_order_items DROP COLUMN unit_quantity;
+ALTER TABLE purchase_order_items DROP COLUMN unit;
+ALTER TABLE transaction_items DROP COLUMN unit_quantity;
+ALTER TABLE transaction_items DROP COLUMN unit;
+DROP TABLE item_units;
+ALTER TABLE inventory_items DROP COLUMN unit;
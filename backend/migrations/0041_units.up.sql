@@ -0,0 +1,20 @@
+-- stock is counted in each item's base unit (pcs, kg, ...); item_units
+-- lists the other units it is bought or sold in and how many base units
+-- one of them holds, e.g. carton = 24 pcs
+ALTER TABLE inventory_items ADD COLUMN unit TEXT NOT NULL DEFAULT 'pcs';
+
+CREATE TABLE item_units (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  factor INTEGER NOT NULL,
+  UNIQUE (item_id, name)
+);
+
+-- a line entered in another unit keeps what was entered; quantity and
+-- unit_price stay in base units
+ALTER TABLE transaction_items ADD COLUMN unit TEXT;
+ALTER TABLE transaction_items ADD COLUMN unit_quantity INTEGER;
+ALTER TABLE purchase_order_items ADD COLUMN unit TEXT;
+ALTER TABLE purchase_order_items ADD COLUMN unit_quantity INTEGER;
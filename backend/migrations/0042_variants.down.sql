@@ -0,0 +1,3 @@
+DROP INDEX idx_inventory_items_parent;
+ALTER TABLE inventory_items DROP COLUMN variant_options;
+ALTER TABLE inventory_items DROP COLUMN parent_id;
@@ -0,0 +1,5 @@
+-- a variant (size, colour, ...) is an item of its own, with its own SKU,
+-- price and stock, under a parent item whose name it shares
+ALTER TABLE inventory_items ADD COLUMN parent_id TEXT;
+ALTER TABLE inventory_items ADD COLUMN variant_options TEXT;
+CREATE INDEX idx_inventory_items_parent ON inventory_items(parent_id);
@@ -0,0 +1,11 @@
+-- the bill of materials of a bundle: how many of each component item go
+-- into one unit of it
+CREATE TABLE item_components (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  bundle_id TEXT NOT NULL,
+  component_id TEXT NOT NULL,
+  quantity INTEGER NOT NULL,
+  UNIQUE (bundle_id, component_id)
+);
+CREATE INDEX idx_item_components_component ON item_components(component_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_transaction_items_promotion;
+ALTER TABLE transaction_items DROP COLUMN promotion_id;
+ALTER TABLE transaction_items DROP COLUMN discount;
+DROP TABLE promotions;
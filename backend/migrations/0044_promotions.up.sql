@@ -0,0 +1,26 @@
+-- kind is 'percent_off' (percent off an item, or off every item of a
+-- category) or 'buy_get' (of each buy_quantity + free_quantity units of an
+-- item, free_quantity are free). A promotion runs from start_date through
+-- end_date.
+CREATE TABLE promotions (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  kind TEXT NOT NULL,
+  item_id TEXT,
+  category TEXT,
+  percent REAL NOT NULL DEFAULT 0,
+  buy_quantity INTEGER NOT NULL DEFAULT 0,
+  free_quantity INTEGER NOT NULL DEFAULT 0,
+  start_date TEXT NOT NULL,
+  end_date TEXT NOT NULL,
+  active INTEGER NOT NULL DEFAULT 1,
+  created_at TEXT,
+  updated_at TEXT
+);
+CREATE INDEX idx_promotions_org_dates ON promotions(organization_id, start_date, end_date);
+
+-- what each promotion took off a sale line; total_price is after discount
+ALTER TABLE transaction_items ADD COLUMN discount REAL NOT NULL DEFAULT 0;
+ALTER TABLE transaction_items ADD COLUMN promotion_id TEXT;
+CREATE INDEX idx_transaction_items_promotion ON transaction_items(promotion_id);
@@ -0,0 +1,3 @@
+ALTER TABLE transaction_items DROP COLUMN price_approved_by;
+DROP TABLE price_overrides;
+ALTER TABLE inventory_items DROP COLUMN min_sale_price;
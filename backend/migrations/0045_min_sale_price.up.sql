@@ -0,0 +1,22 @@
+-- the lowest unit price an item may be sold at without a manager; NULL
+-- sets no floor
+ALTER TABLE inventory_items ADD COLUMN min_sale_price REAL;
+
+-- a manager's one-off approval for selling item_id as low as unit_price,
+-- handed to the cashier as a short code
+CREATE TABLE price_overrides (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  unit_price REAL NOT NULL,
+  code TEXT NOT NULL,
+  approved_by TEXT NOT NULL,
+  expires_at TEXT NOT NULL,
+  used_at TEXT,
+  transaction_id TEXT,
+  created_at TEXT
+);
+CREATE INDEX idx_price_overrides_org_code ON price_overrides(organization_id, code);
+
+-- who let a sale line go below the item's minimum
+ALTER TABLE transaction_items ADD COLUMN price_approved_by TEXT;
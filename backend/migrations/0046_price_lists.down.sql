@@ -0,0 +1,4 @@
+ALTER TABLE transaction_items DROP COLUMN price_list_id;
+ALTER TABLE contacts DROP COLUMN price_list_id;
+DROP TABLE price_list_items;
+DROP TABLE price_lists;
@@ -0,0 +1,24 @@
+-- price lists (retail, wholesale, dealer, ...) give items other prices;
+-- a contact on a price list is sold at its prices
+CREATE TABLE price_lists (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  created_at TEXT,
+  updated_at TEXT,
+  UNIQUE (organization_id, name)
+);
+
+CREATE TABLE price_list_items (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  price_list_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  unit_price REAL NOT NULL,
+  UNIQUE (price_list_id, item_id)
+);
+CREATE INDEX idx_price_list_items_item ON price_list_items(item_id);
+
+ALTER TABLE contacts ADD COLUMN price_list_id TEXT;
+-- the price list a sale line was priced from
+ALTER TABLE transaction_items ADD COLUMN price_list_id TEXT;
@@ -0,0 +1,4 @@
+DROP INDEX idx_transactions_receipt_number;
+ALTER TABLE transactions DROP COLUMN receipt_block_id;
+ALTER TABLE transactions DROP COLUMN receipt_number;
+DROP TABLE receipt_blocks;
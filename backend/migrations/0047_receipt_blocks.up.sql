@@ -0,0 +1,19 @@
+-- blocks of invoice numbers reserved for a device, so a till selling
+-- offline numbers its sales from its own block
+CREATE TABLE receipt_blocks (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  device_key TEXT NOT NULL,
+  reserved_by TEXT,
+  prefix TEXT NOT NULL,
+  padding INTEGER NOT NULL,
+  first_number INTEGER NOT NULL,
+  last_number INTEGER NOT NULL,
+  created_at TEXT,
+  closed_at TEXT
+);
+CREATE INDEX idx_receipt_blocks_device ON receipt_blocks(organization_id, device_key);
+
+ALTER TABLE transactions ADD COLUMN receipt_number TEXT;
+ALTER TABLE transactions ADD COLUMN receipt_block_id TEXT;
+CREATE UNIQUE INDEX idx_transactions_receipt_number ON transactions(organization_id, receipt_number);
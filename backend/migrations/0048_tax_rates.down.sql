@@ -0,0 +1,6 @@
+ALTER TABLE transactions DROP COLUMN tax_amount;
+ALTER TABLE transaction_items DROP COLUMN tax_amount;
+ALTER TABLE transaction_items DROP COLUMN taxable_amount;
+ALTER TABLE transaction_items DROP COLUMN tax_rate;
+ALTER TABLE inventory_items DROP COLUMN tax_rate_id;
+DROP TABLE tax_rates;
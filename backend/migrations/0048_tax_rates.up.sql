@@ -0,0 +1,17 @@
+-- tax rates (standard, reduced, exempt, ...) are the tax categories items
+-- are put in; lines keep the rate they were taxed at and the tax
+CREATE TABLE tax_rates (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  name TEXT NOT NULL,
+  rate REAL NOT NULL,
+  created_at TEXT,
+  updated_at TEXT,
+  UNIQUE (organization_id, name)
+);
+
+ALTER TABLE inventory_items ADD COLUMN tax_rate_id TEXT;
+ALTER TABLE transaction_items ADD COLUMN tax_rate REAL NOT NULL DEFAULT 0;
+ALTER TABLE transaction_items ADD COLUMN taxable_amount REAL NOT NULL DEFAULT 0;
+ALTER TABLE transaction_items ADD COLUMN tax_amount REAL NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN tax_amount REAL NOT NULL DEFAULT 0;
@@ -0,0 +1,13 @@
+-- other barcodes and aliases of an item: an old manufacturer barcode, the
+-- barcode of a carton, ...; a code may stand for one of the item's units
+CREATE TABLE item_codes (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  code TEXT NOT NULL,
+  unit TEXT,
+  note TEXT,
+  created_at TEXT,
+  UNIQUE (organization_id, code)
+);
+CREATE INDEX idx_item_codes_item ON item_codes(item_id);
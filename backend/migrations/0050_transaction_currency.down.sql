@@ -0,0 +1,6 @@
+ALTER TABLE transaction_items DROP COLUMN currency_total_price;
+ALTER TABLE transactions DROP COLUMN currency_due_amount;
+ALTER TABLE transactions DROP COLUMN currency_paid_amount;
+ALTER TABLE transactions DROP COLUMN currency_amount;
+ALTER TABLE transactions DROP COLUMN exchange_rate;
+ALTER TABLE transactions DROP COLUMN currency;
@@ -0,0 +1,8 @@
+-- transactions in a foreign currency keep their amounts in it next to the
+-- base currency amounts, and the rate between them
+ALTER TABLE transactions ADD COLUMN currency TEXT;
+ALTER TABLE transactions ADD COLUMN exchange_rate REAL;
+ALTER TABLE transactions ADD COLUMN currency_amount REAL;
+ALTER TABLE transactions ADD COLUMN currency_paid_amount REAL;
+ALTER TABLE transactions ADD COLUMN currency_due_amount REAL;
+ALTER TABLE transaction_items ADD COLUMN currency_total_price REAL;
@@ -0,0 +1,19 @@
+-- back to REAL amounts in major units
+ALTER TABLE transaction_items ADD COLUMN unit_price_real REAL NOT NULL DEFAULT 0;
+ALTER TABLE transaction_items ADD COLUMN total_price_real REAL NOT NULL DEFAULT 0;
+UPDATE transaction_items SET unit_price_real = unit_price / 100.0, total_price_real = total_price / 100.0;
+ALTER TABLE transaction_items DROP COLUMN unit_price;
+ALTER TABLE transaction_items DROP COLUMN total_price;
+ALTER TABLE transaction_items RENAME COLUMN unit_price_real TO unit_price;
+ALTER TABLE transaction_items RENAME COLUMN total_price_real TO total_price;
+
+ALTER TABLE transactions ADD COLUMN amount_real REAL NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN paid_amount_real REAL NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN due_amount_real REAL NOT NULL DEFAULT 0;
+UPDATE transactions SET amount_real = amount / 100.0, paid_amount_real = paid_amount / 100.0, due_amount_real = due_amount / 100.0;
+ALTER TABLE transactions DROP COLUMN amount;
+ALTER TABLE transactions DROP COLUMN paid_amount;
+ALTER TABLE transactions DROP COLUMN due_amount;
+ALTER TABLE transactions RENAME COLUMN amount_real TO amount;
+ALTER TABLE transactions RENAME COLUMN paid_amount_real TO paid_amount;
+ALTER TABLE transactions RENAME COLUMN due_amount_real TO due_amount;
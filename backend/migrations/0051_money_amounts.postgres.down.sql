@@ -0,0 +1,8 @@
+-- back to REAL amounts in major units
+ALTER TABLE transaction_items
+  ALTER COLUMN unit_price TYPE REAL USING unit_price / 100.0,
+  ALTER COLUMN total_price TYPE REAL USING total_price / 100.0;
+ALTER TABLE transactions
+  ALTER COLUMN amount TYPE REAL USING amount / 100.0,
+  ALTER COLUMN paid_amount TYPE REAL USING paid_amount / 100.0,
+  ALTER COLUMN due_amount TYPE REAL USING due_amount / 100.0;
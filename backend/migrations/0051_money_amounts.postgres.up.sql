@@ -0,0 +1,9 @@
+-- the amounts of transactions and their lines are kept as whole paisa
+-- (see money.go), so line totals and sums come out exact
+ALTER TABLE transactions
+  ALTER COLUMN amount TYPE BIGINT USING ROUND(amount::numeric * 100)::bigint,
+  ALTER COLUMN paid_amount TYPE BIGINT USING ROUND(paid_amount::numeric * 100)::bigint,
+  ALTER COLUMN due_amount TYPE BIGINT USING ROUND(due_amount::numeric * 100)::bigint;
+ALTER TABLE transaction_items
+  ALTER COLUMN unit_price TYPE BIGINT USING ROUND(unit_price::numeric * 100)::bigint,
+  ALTER COLUMN total_price TYPE BIGINT USING ROUND(total_price::numeric * 100)::bigint;
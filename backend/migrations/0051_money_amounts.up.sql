@@ -0,0 +1,28 @@
+-- the amounts of transactions and their lines are kept as whole paisa
+-- (see money.go), so line totals and sums come out exact: 3 x 33.30 is
+-- 9990, not 99.89999999999999. SQLite cannot change a column's type, so
+-- each amount is copied, rounded, into an INTEGER column that takes its
+-- place.
+ALTER TABLE transactions ADD COLUMN amount_paisa INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN paid_amount_paisa INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN due_amount_paisa INTEGER NOT NULL DEFAULT 0;
+UPDATE transactions SET
+  amount_paisa = CAST(ROUND(amount * 100) AS INTEGER),
+  paid_amount_paisa = CAST(ROUND(paid_amount * 100) AS INTEGER),
+  due_amount_paisa = CAST(ROUND(due_amount * 100) AS INTEGER);
+ALTER TABLE transactions DROP COLUMN amount;
+ALTER TABLE transactions DROP COLUMN paid_amount;
+ALTER TABLE transactions DROP COLUMN due_amount;
+ALTER TABLE transactions RENAME COLUMN amount_paisa TO amount;
+ALTER TABLE transactions RENAME COLUMN paid_amount_paisa TO paid_amount;
+ALTER TABLE transactions RENAME COLUMN due_amount_paisa TO due_amount;
+
+ALTER TABLE transaction_items ADD COLUMN unit_price_paisa INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transaction_items ADD COLUMN total_price_paisa INTEGER NOT NULL DEFAULT 0;
+UPDATE transaction_items SET
+  unit_price_paisa = CAST(ROUND(unit_price * 100) AS INTEGER),
+  total_price_paisa = CAST(ROUND(total_price * 100) AS INTEGER);
+ALTER TABLE transaction_items DROP COLUMN unit_price;
+ALTER TABLE transaction_items DROP COLUMN total_price;
+ALTER TABLE transaction_items RENAME COLUMN unit_price_paisa TO unit_price;
+ALTER TABLE transaction_items RENAME COLUMN total_price_paisa TO total_price;
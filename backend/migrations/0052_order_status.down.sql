@@ -0,0 +1,4 @@
+DROP TABLE order_status_changes;
+DROP INDEX idx_transactions_order_status;
+ALTER TABLE transactions DROP COLUMN order_status_at;
+ALTER TABLE transactions DROP COLUMN order_status;
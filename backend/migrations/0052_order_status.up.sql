@@ -0,0 +1,15 @@
+-- sales taken as orders (food, repairs, ...) move through new, preparing,
+-- ready and delivered; each move is kept (see orders.go)
+ALTER TABLE transactions ADD COLUMN order_status TEXT;
+ALTER TABLE transactions ADD COLUMN order_status_at TEXT;
+CREATE INDEX idx_transactions_order_status ON transactions(organization_id, order_status);
+
+CREATE TABLE order_status_changes (
+  id TEXT PRIMARY KEY,
+  transaction_id TEXT NOT NULL,
+  from_status TEXT,
+  to_status TEXT NOT NULL,
+  changed_by TEXT,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_order_status_changes_transaction ON order_status_changes(transaction_id);
@@ -0,0 +1,3 @@
+DROP INDEX idx_transaction_payments_contact_payment;
+ALTER TABLE transaction_payments DROP COLUMN contact_payment_id;
+DROP TABLE contact_payments;
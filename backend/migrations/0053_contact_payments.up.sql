@@ -0,0 +1,20 @@
+-- a sum received from a customer or paid to a supplier at once, spread
+-- over their open transactions; each part is a transaction_payments line
+-- pointing back at it (see contact_payments.go)
+CREATE TABLE contact_payments (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  contact_id TEXT NOT NULL,
+  type TEXT NOT NULL,
+  method TEXT NOT NULL,
+  amount REAL NOT NULL,
+  reference TEXT,
+  account_id TEXT,
+  recorded_by TEXT,
+  paid_at TEXT NOT NULL,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_contact_payments_contact ON contact_payments(organization_id, contact_id);
+
+ALTER TABLE transaction_payments ADD COLUMN contact_payment_id TEXT;
+CREATE INDEX idx_transaction_payments_contact_payment ON transaction_payments(contact_payment_id);
@@ -0,0 +1,4 @@
+ALTER TABLE transactions DROP COLUMN kot_printed_at;
+ALTER TABLE transactions DROP COLUMN kot_prints;
+ALTER TABLE transactions DROP COLUMN order_table;
+ALTER TABLE transactions DROP COLUMN order_token;
@@ -0,0 +1,6 @@
+-- an order gets a token for the day when it is taken, and may be for a
+-- table; its kitchen ticket counts how often it was printed (see kitchen_tickets.go)
+ALTER TABLE transactions ADD COLUMN order_token TEXT;
+ALTER TABLE transactions ADD COLUMN order_table TEXT;
+ALTER TABLE transactions ADD COLUMN kot_prints INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE transactions ADD COLUMN kot_printed_at TEXT;
@@ -0,0 +1,3 @@
+DROP TABLE inbox_attachments;
+DROP TABLE inbox_documents;
+DROP TABLE email_inboxes;
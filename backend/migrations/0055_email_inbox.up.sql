@@ -0,0 +1,35 @@
+-- supplier invoices mailed to an organization's inbox address wait for
+-- review before they become purchases (see email_inbox.go)
+CREATE TABLE email_inboxes (
+  organization_id TEXT PRIMARY KEY,
+  address_key TEXT NOT NULL UNIQUE,
+  created_at TEXT NOT NULL
+);
+
+CREATE TABLE inbox_documents (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  message_id TEXT,
+  sender TEXT NOT NULL,
+  subject TEXT,
+  body TEXT,
+  contact_id TEXT,
+  status TEXT NOT NULL DEFAULT 'pending',
+  transaction_id TEXT,
+  reviewed_by TEXT,
+  reviewed_at TEXT,
+  received_at TEXT NOT NULL
+);
+CREATE INDEX idx_inbox_documents_status ON inbox_documents(organization_id, status);
+CREATE UNIQUE INDEX idx_inbox_documents_message ON inbox_documents(organization_id, message_id);
+
+CREATE TABLE inbox_attachments (
+  id TEXT PRIMARY KEY,
+  document_id TEXT NOT NULL,
+  filename TEXT NOT NULL,
+  content_type TEXT NOT NULL,
+  size INTEGER NOT NULL,
+  url TEXT NOT NULL,
+  transaction_id TEXT
+);
+CREATE INDEX idx_inbox_attachments_document ON inbox_attachments(document_id);
@@ -0,0 +1,2 @@
+-- why a transaction was voided (see voids.go)
+ALTER TABLE transactions ADD COLUMN void_reason TEXT;
@@ -0,0 +1,2 @@
+DROP INDEX idx_transaction_edits_transaction;
+DROP TABLE transaction_edits;
@@ -0,0 +1,12 @@
+-- the lines and amount a transaction had before each edit (see
+-- transaction_edits.go)
+CREATE TABLE transaction_edits (
+  id TEXT PRIMARY KEY,
+  transaction_id TEXT NOT NULL,
+  old_amount REAL NOT NULL,
+  new_amount REAL NOT NULL,
+  old_items TEXT NOT NULL,
+  edited_by TEXT,
+  edited_at TEXT NOT NULL
+);
+CREATE INDEX idx_transaction_edits_transaction ON transaction_edits(transaction_id);
@@ -0,0 +1,3 @@
+ALTER TABLE contacts DROP COLUMN created_at;
+DROP INDEX idx_rest_hooks_event;
+DROP TABLE rest_hooks;
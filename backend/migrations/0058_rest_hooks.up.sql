@@ -0,0 +1,15 @@
+-- URLs that no-code tools (Zapier, Make) subscribed to an event; see
+-- rest_hooks.go
+CREATE TABLE rest_hooks (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  event TEXT NOT NULL,
+  target_url TEXT NOT NULL,
+  role TEXT NOT NULL,
+  created_by TEXT,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_rest_hooks_event ON rest_hooks(organization_id, event);
+
+-- new contacts are polled for newest first
+ALTER TABLE contacts ADD COLUMN created_at TEXT;
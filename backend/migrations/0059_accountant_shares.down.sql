@@ -0,0 +1,3 @@
+DROP INDEX idx_accountant_share_log_share;
+DROP TABLE accountant_share_log;
+DROP TABLE accountant_shares;
@@ -0,0 +1,27 @@
+-- read-only links to one period's books for an outside accountant, and
+-- what was looked at through them (see accountant_shares.go)
+CREATE TABLE accountant_shares (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,
+  name TEXT NOT NULL,
+  email TEXT,
+  fiscal_year_id TEXT,
+  start_date TEXT NOT NULL,
+  end_date TEXT NOT NULL,
+  created_by TEXT,
+  created_at TEXT NOT NULL,
+  expires_at TEXT NOT NULL,
+  revoked_at TEXT
+);
+
+CREATE TABLE accountant_share_log (
+  id TEXT PRIMARY KEY,
+  share_id TEXT NOT NULL,
+  resource TEXT NOT NULL,
+  action TEXT NOT NULL,
+  ip TEXT,
+  user_agent TEXT,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_accountant_share_log_share ON accountant_share_log(share_id, created_at);
@@ -0,0 +1,6 @@
+ALTER TABLE transaction_payments DROP COLUMN credit_note_id;
+DROP INDEX idx_credit_note_items_note;
+DROP TABLE credit_note_items;
+DROP INDEX idx_credit_notes_contact;
+DROP INDEX idx_credit_notes_transaction;
+DROP TABLE credit_notes;
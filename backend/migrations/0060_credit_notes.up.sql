@@ -0,0 +1,35 @@
+-- goods returned on a sale or purchase (see credit_notes.go): the credit
+-- note is refunded at once or kept as credit for the contact's next
+-- transactions of the same type
+CREATE TABLE credit_notes (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  number TEXT NOT NULL,
+  transaction_id TEXT NOT NULL,
+  contact_id TEXT,
+  type TEXT NOT NULL,
+  amount REAL NOT NULL,
+  tax_amount REAL NOT NULL DEFAULT 0,
+  settlement TEXT NOT NULL,
+  credit_remaining REAL NOT NULL DEFAULT 0,
+  reason TEXT,
+  created_by TEXT,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_credit_notes_transaction ON credit_notes(transaction_id);
+CREATE INDEX idx_credit_notes_contact ON credit_notes(organization_id, contact_id);
+
+CREATE TABLE credit_note_items (
+  id TEXT PRIMARY KEY,
+  credit_note_id TEXT NOT NULL,
+  item_id TEXT NOT NULL,
+  quantity INTEGER NOT NULL,
+  unit_price REAL NOT NULL,
+  total_price REAL NOT NULL,
+  tax_amount REAL NOT NULL DEFAULT 0,
+  location_id TEXT
+);
+CREATE INDEX idx_credit_note_items_note ON credit_note_items(credit_note_id);
+
+-- the credit note a payment made from credit drew on
+ALTER TABLE transaction_payments ADD COLUMN credit_note_id TEXT;
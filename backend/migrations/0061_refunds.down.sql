@@ -0,0 +1,3 @@
+DROP INDEX idx_refunds_credit_note;
+DROP INDEX idx_refunds_org_created;
+DROP TABLE refunds;
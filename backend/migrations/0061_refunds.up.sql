@@ -0,0 +1,28 @@
+-- money paid back against a credit note, by method (see credit_notes.go):
+-- type is the returned transaction's, so an inflow refund left the till
+-- and an outflow refund came back from a supplier
+CREATE TABLE refunds (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  credit_note_id TEXT NOT NULL,
+  transaction_id TEXT NOT NULL,
+  contact_id TEXT,
+  type TEXT NOT NULL,
+  method TEXT NOT NULL,
+  reference TEXT,
+  account_id TEXT,
+  amount REAL NOT NULL,
+  created_by TEXT,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_refunds_org_created ON refunds(organization_id, created_at);
+CREATE INDEX idx_refunds_credit_note ON refunds(credit_note_id);
+
+-- credit notes refunded before refunds were recorded kept their method
+-- on the account movement only
+INSERT INTO refunds (id,organization_id,credit_note_id,transaction_id,contact_id,type,method,account_id,amount,created_by,created_at)
+SELECT n.id, n.organization_id, n.id, n.transaction_id, n.contact_id, n.type,
+  COALESCE((SELECT m.notes FROM account_movements m WHERE m.ref_type = 'credit_note' AND m.ref_id = n.id), 'cash'),
+  (SELECT m.account_id FROM account_movements m WHERE m.ref_type = 'credit_note' AND m.ref_id = n.id),
+  n.amount, n.created_by, n.created_at
+FROM credit_notes n WHERE n.settlement = 'refund';
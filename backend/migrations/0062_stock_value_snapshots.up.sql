@@ -0,0 +1,16 @@
+-- what the stock was worth at the end of a day, taken as it happens
+-- because it cannot be worked out reliably afterwards (see
+-- stock_snapshots.go); items holds each item's quantity and value as JSON,
+-- and closing is 1 once the day was over when it was taken
+CREATE TABLE stock_value_snapshots (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  snapshot_date TEXT NOT NULL,
+  total_value REAL NOT NULL,
+  total_quantity INTEGER NOT NULL,
+  item_count INTEGER NOT NULL,
+  items TEXT NOT NULL,
+  closing INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT NOT NULL,
+  UNIQUE (organization_id, snapshot_date)
+);
@@ -0,0 +1 @@
+DROP TABLE kpi_digests;
@@ -0,0 +1,15 @@
+-- a user's daily or weekly digest of an organization's figures, and where
+-- it goes (see kpi_digest.go); weekday is 0 for Sunday
+CREATE TABLE kpi_digests (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  user_id TEXT NOT NULL,
+  frequency TEXT NOT NULL,
+  channel TEXT NOT NULL,
+  address TEXT NOT NULL,
+  send_hour INTEGER NOT NULL DEFAULT 8,
+  weekday INTEGER NOT NULL DEFAULT 6,
+  last_sent_date TEXT,
+  created_at TEXT NOT NULL,
+  UNIQUE (organization_id, user_id)
+);
@@ -0,0 +1,3 @@
+DROP INDEX idx_invoice_deliveries_transaction;
+DROP TABLE invoice_deliveries;
+ALTER TABLE contacts DROP COLUMN email;
@@ -0,0 +1,16 @@
+-- where a contact's invoices are emailed; see invoice_email.go
+ALTER TABLE contacts ADD COLUMN email TEXT;
+
+-- every attempt to email a transaction's invoice; status is sent or failed
+CREATE TABLE invoice_deliveries (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  transaction_id TEXT NOT NULL,
+  email TEXT NOT NULL,
+  status TEXT NOT NULL,
+  error TEXT,
+  provider_message_id TEXT,
+  sent_by TEXT,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_invoice_deliveries_transaction ON invoice_deliveries(transaction_id, created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE organizations DROP COLUMN setup_completed_at;
+DROP TABLE setup_steps;
@@ -0,0 +1,20 @@
+-- how far each organization has got through the setup steps; see setup.go
+CREATE TABLE setup_steps (
+  organization_id TEXT NOT NULL,
+  step TEXT NOT NULL,
+  status TEXT NOT NULL,
+  completed_by TEXT,
+  completed_at TEXT NOT NULL,
+  PRIMARY KEY (organization_id, step)
+);
+
+ALTER TABLE organizations ADD COLUMN setup_completed_at TEXT;
+
+-- businesses already trading have set themselves up
+UPDATE organizations SET setup_completed_at = COALESCE(created_at, '1970-01-01T00:00:00Z')
+WHERE EXISTS (SELECT 1 FROM transactions t WHERE t.organization_id = organizations.id)
+   OR EXISTS (SELECT 1 FROM inventory_items i WHERE i.organization_id = organizations.id);
+INSERT INTO setup_steps (organization_id, step, status, completed_at)
+SELECT o.id, s.step, 'done', o.setup_completed_at FROM organizations o,
+  (SELECT 'profile' AS step UNION ALL SELECT 'currency_tax' UNION ALL SELECT 'opening_balances' UNION ALL SELECT 'import') s
+WHERE o.setup_completed_at IS NOT NULL;
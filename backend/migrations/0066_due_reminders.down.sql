@@ -0,0 +1,4 @@
+DROP INDEX idx_due_reminders_provider;
+DROP INDEX idx_due_reminders_contact;
+DROP TABLE due_reminders;
+ALTER TABLE contacts DROP COLUMN sms_opt_out;
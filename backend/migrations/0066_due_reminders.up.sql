@@ -0,0 +1,19 @@
+-- contacts who asked not to be texted; see due_reminders.go
+ALTER TABLE contacts ADD COLUMN sms_opt_out INTEGER NOT NULL DEFAULT 0;
+
+-- every reminder texted to a customer about an overdue balance
+CREATE TABLE due_reminders (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  contact_id TEXT NOT NULL,
+  phone TEXT NOT NULL,
+  amount REAL NOT NULL,
+  message TEXT NOT NULL,
+  status TEXT NOT NULL,
+  error TEXT,
+  provider_message_id TEXT,
+  delivered_at TEXT,
+  created_at TEXT NOT NULL
+);
+CREATE INDEX idx_due_reminders_contact ON due_reminders(organization_id, contact_id, created_at);
+CREATE INDEX idx_due_reminders_provider ON due_reminders(provider_message_id);
@@ -0,0 +1,3 @@
+DROP INDEX idx_mobile_payments_provider;
+DROP INDEX idx_mobile_payments_transaction;
+DROP TABLE mobile_payments;
@@ -0,0 +1,18 @@
+-- payments asked of customers' mobile wallets; see mobile_money.go
+CREATE TABLE mobile_payments (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  transaction_id TEXT NOT NULL,
+  provider TEXT NOT NULL,
+  provider_payment_id TEXT NOT NULL,
+  amount REAL NOT NULL,
+  payment_url TEXT NOT NULL,
+  status TEXT NOT NULL,
+  provider_trx_id TEXT,
+  error TEXT,
+  created_by TEXT,
+  created_at TEXT NOT NULL,
+  completed_at TEXT
+);
+CREATE INDEX idx_mobile_payments_transaction ON mobile_payments(transaction_id, created_at);
+CREATE UNIQUE INDEX idx_mobile_payments_provider ON mobile_payments(provider, provider_payment_id);
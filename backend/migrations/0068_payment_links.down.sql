@@ -0,0 +1,3 @@
+DROP INDEX idx_payment_links_session;
+DROP INDEX idx_payment_links_transaction;
+DROP TABLE payment_links;
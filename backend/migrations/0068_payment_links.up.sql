@@ -0,0 +1,19 @@
+-- Stripe Checkout links sent to customers for what they owe; see payment_links.go
+CREATE TABLE payment_links (
+  id TEXT PRIMARY KEY,
+  organization_id TEXT NOT NULL,
+  transaction_id TEXT NOT NULL,
+  session_id TEXT NOT NULL,
+  url TEXT NOT NULL,
+  amount REAL NOT NULL,
+  currency TEXT NOT NULL,
+  currency_amount REAL NOT NULL,
+  status TEXT NOT NULL,
+  payment_intent TEXT,
+  error TEXT,
+  created_by TEXT,
+  created_at TEXT NOT NULL,
+  completed_at TEXT
+);
+CREATE INDEX idx_payment_links_transaction ON payment_links(transaction_id, created_at);
+CREATE UNIQUE INDEX idx_payment_links_session ON payment_links(session_id);
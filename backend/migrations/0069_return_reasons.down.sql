@@ -0,0 +1,4 @@
+DROP INDEX idx_credit_notes_period;
+UPDATE credit_notes SET reason = reason || ': ' || reason_note WHERE reason <> 'other' AND reason_note IS NOT NULL;
+UPDATE credit_notes SET reason = reason_note WHERE reason = 'other';
+ALTER TABLE credit_notes DROP COLUMN reason_note;
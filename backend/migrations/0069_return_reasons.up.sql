@@ -0,0 +1,8 @@
+-- a return gives its reason as one of the codes in credit_notes.go, with
+-- any free text beside it; reasons written before the codes are kept as
+-- the note of an "other"
+ALTER TABLE credit_notes ADD COLUMN reason_note TEXT;
+UPDATE credit_notes SET reason_note = reason, reason = 'other'
+  WHERE reason IS NULL OR reason NOT IN ('damaged', 'defective', 'wrong_item', 'expired', 'not_as_described', 'unwanted', 'other');
+-- the returns report reads a period of one type
+CREATE INDEX idx_credit_notes_period ON credit_notes(organization_id, type, created_at);
@@ -0,0 +1,2 @@
+DELETE FROM opening_balances WHERE fiscal_year_id IS NOT NULL;
+ALTER TABLE opening_balances DROP COLUMN fiscal_year_id;
@@ -0,0 +1,3 @@
+-- closing a fiscal year opens the next period with the balances it
+-- carried forward; those entries point at the year they came from
+ALTER TABLE opening_balances ADD COLUMN fiscal_year_id TEXT;
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	db := openDB(sqliteDialect, filepath.Join(t.TempDir(), "db.sqlite"))
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func hasColumn(t *testing.T, db *DB, table, column string) bool {
+	t.Helper()
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if name == column {
+			return true
+		}
+	}
+	return false
+}
+
+func hasTable(t *testing.T, db *DB, table string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func appliedCount(t *testing.T, db *DB) int {
+	t.Helper()
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(applied)
+}
+
+func TestMigrateUpDownUp(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Fatalf("migration versions must be consecutive, found %04d_%s at position %d", m.version, m.name, i+1)
+		}
+		if m.version > 1 && m.down == "" {
+			t.Errorf("migration %04d_%s has no down file", m.version, m.name)
+		}
+	}
+
+	db := openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	if got := appliedCount(t, db); got != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", got, len(migrations))
+	}
+	if !hasTable(t, db, "deposits") || !hasColumn(t, db, "inventory_items", "rental_stock") {
+		t.Fatal("schema incomplete after up")
+	}
+	// running up again is a no-op
+	if err := migrateUp(db); err != nil {
+		t.Fatalf("second up: %v", err)
+	}
+
+	if err := migrateDown(db, len(migrations)-1); err != nil {
+		t.Fatalf("down: %v", err)
+	}
+	if got := appliedCount(t, db); got != 1 {
+		t.Fatalf("%d migrations applied after down, want 1", got)
+	}
+	if hasTable(t, db, "deposits") || hasTable(t, db, "rentals") || hasColumn(t, db, "inventory_items", "rental_stock") {
+		t.Fatal("feature schema left behind after down")
+	}
+	if !hasTable(t, db, "transactions") {
+		t.Fatal("initial schema dropped by down")
+	}
+	if err := migrateDown(db, 1); err == nil {
+		t.Fatal("reverting the initial migration should fail")
+	}
+	if !hasTable(t, db, "transactions") {
+		t.Fatal("initial schema dropped")
+	}
+
+	if err := migrateUp(db); err != nil {
+		t.Fatalf("up after down: %v", err)
+	}
+	if got := appliedCount(t, db); got != len(migrations) {
+		t.Fatalf("applied %d migrations after re-up, want %d", got, len(migrations))
+	}
+}
+
+// A database created by the old migrate.sql (run on every start, no
+// schema_migrations table) upgrades in place and keeps its data.
+func TestMigrateLegacyDatabase(t *testing.T) {
+	legacy, err := os.ReadFile("testdata/legacy_migrate.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := openTestDB(t)
+	if _, err := db.Exec(string(legacy)); err != nil {
+		t.Fatalf("legacy schema: %v", err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Shop','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','017','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price) VALUES ('i-1','Pen','PEN',10,15)`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,created_at) VALUES ('t-1','inflow',30,30,0,'c-1','2024-01-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrateUp(db); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+	var name string
+	var quantity int
+	var cost float64
+	if err := db.QueryRow(`SELECT name, quantity, cost_price FROM inventory_items WHERE id = 'i-1'`).Scan(&name, &quantity, &cost); err != nil {
+		t.Fatal(err)
+	}
+	if name != "Pen" || quantity != 10 || cost != 0 {
+		t.Errorf("item = %s %d %v after upgrade", name, quantity, cost)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM transactions WHERE id = 't-1' AND source IS NULL AND organization_id IS NULL`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("legacy transaction not upgraded: n=%d err=%v", n, err)
+	}
+	for _, col := range []struct{ table, column string }{
+		{"organizations", "logo_url"},
+		{"transactions", "payment_method"},
+		{"inventory_items", "supplier_id"},
+	} {
+		if !hasColumn(t, db, col.table, col.column) {
+			t.Errorf("%s.%s missing after upgrade", col.table, col.column)
+		}
+	}
+}
+
+// Amounts written as REAL major units before 0051 come back as whole
+// paisa, and go back to major units when it is rolled back.
+func TestMigrateMoneyAmounts(t *testing.T) {
+	db := openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := 0
+	for _, m := range migrations {
+		if m.version >= 51 {
+			steps++
+		}
+	}
+	if err := migrateDown(db, steps); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,created_at) VALUES ('t-1','inflow',99.899999,33.3,66.6,'c-1','2024-01-01T00:00:00Z')`,
+		`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES ('ti-1','t-1','i-1',3,33.3,99.9)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	var amount, paid, due, unitPrice, total int64
+	if err := db.QueryRow(`SELECT t.amount, t.paid_amount, t.due_amount, ti.unit_price, ti.total_price FROM transactions t JOIN transaction_items ti ON ti.transaction_id = t.id`).Scan(&amount, &paid, &due, &unitPrice, &total); err != nil {
+		t.Fatal(err)
+	}
+	if amount != 9990 || paid != 3330 || due != 6660 || unitPrice != 3330 || total != 9990 {
+		t.Errorf("in paisa: %d %d %d, line %d %d", amount, paid, due, unitPrice, total)
+	}
+
+	if err := migrateDown(db, steps); err != nil {
+		t.Fatal(err)
+	}
+	var major float64
+	if err := db.QueryRow(`SELECT amount FROM transactions WHERE id = 't-1'`).Scan(&major); err != nil || major != 99.9 {
+		t.Errorf("rolled back: %v %v", major, err)
+	}
+}
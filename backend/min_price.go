@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// An item may carry a min_sale_price, the lowest unit price it is sold at.
+// A sale line priced below it (before promotions, which managers set up
+// themselves) goes through only with a manager's approval:
+//
+//   - an admin or manager recording the sale approves it by doing so;
+//   - a cashier sends the line with an override_code, which a manager
+//     issues for that item and price with POST /api/price-overrides. A
+//     code is good for one sale within priceOverrideTTL.
+//
+// With the below_minimum_price setting at "reject" no one sells below the
+// minimum. The line keeps who approved it in price_approved_by.
+
+const priceOverrideTTL = 15 * time.Minute
+
+func registerMinPriceRoutes(app *fiber.App) {
+	app.Post("/api/price-overrides", requireAuth, requireRole("admin", "manager"), handleCreatePriceOverride)
+}
+
+// checkMinimumPrice lets a sale line (in base units) through or explains
+// why not. An approved line below the minimum gets price_approved_by and,
+// when a code approved it, price_override_id.
+func checkMinimumPrice(c *fiber.Ctx, orgID string, line map[string]interface{}) (int, fiber.Map) {
+	itemID := toString(line["item_id"])
+	var name string
+	var minPrice sql.NullFloat64
+	if err := dbFor(c).QueryRow(`SELECT COALESCE(name, ''), min_sale_price FROM inventory_items WHERE id = ?`, itemID).Scan(&name, &minPrice); err != nil {
+		return 500, fiber.Map{"error": err.Error()}
+	}
+	unitPrice, _ := line["unit_price"].(float64)
+	if !minPrice.Valid || unitPrice >= minPrice.Float64-0.005 {
+		return 0, nil
+	}
+	below := fiber.Map{"error": fmt.Sprintf("%s is priced below its minimum of %.2f", name, minPrice.Float64), "item_id": itemID, "min_sale_price": minPrice.Float64}
+	if orgSetting(orgID, "below_minimum_price") == "reject" {
+		return 409, below
+	}
+	switch role := currentRole(c); {
+	case role == "admin" || role == "manager":
+		line["price_approved_by"] = currentUserID(c)
+		return 0, nil
+	case toString(line["override_code"]) == "":
+		below["error"] = below["error"].(string) + "; a manager must approve it"
+		return 409, below
+	}
+	var overrideID, approvedBy string
+	err := dbFor(c).QueryRow(`SELECT id, approved_by FROM price_overrides WHERE organization_id = ? AND code = ? AND item_id = ? AND used_at IS NULL AND expires_at > ? AND unit_price <= ?`,
+		orgID, toString(line["override_code"]), itemID, time.Now().Format(time.RFC3339), unitPrice+0.005).Scan(&overrideID, &approvedBy)
+	if err == sql.ErrNoRows {
+		below["error"] = "override_code is not valid for " + name + " at this price"
+		return 409, below
+	}
+	if err != nil {
+		return 500, fiber.Map{"error": err.Error()}
+	}
+	line["price_approved_by"] = approvedBy
+	line["price_override_id"] = overrideID
+	return 0, nil
+}
+
+// usePriceOverride spends the override a sale line was approved with.
+func usePriceOverride(tx *Tx, overrideID, transactionID string) error {
+	res, err := tx.Exec(`UPDATE price_overrides SET used_at = ?, transaction_id = ? WHERE id = ? AND used_at IS NULL`, time.Now().Format(time.RFC3339), transactionID, overrideID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fiber.NewError(409, "override_code was already used")
+	}
+	return nil
+}
+
+// handleCreatePriceOverride issues a code approving {"item_id": ...,
+// "unit_price": ...} once.
+func handleCreatePriceOverride(c *fiber.Ctx) error {
+	var req struct {
+		ItemID    string  `json:"item_id"`
+		UnitPrice float64 `json:"unit_price"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	if !orgOwns(dbFor(c), "inventory_items", req.ItemID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "unknown item " + req.ItemID})
+	}
+	if req.UnitPrice < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "unit_price must not be negative"})
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	id, code, now := genID(), fmt.Sprintf("%06d", n.Int64()), time.Now()
+	expires := now.Add(priceOverrideTTL).Format(time.RFC3339)
+	if _, err := dbFor(c).Exec(`INSERT INTO price_overrides (id,organization_id,item_id,unit_price,code,approved_by,expires_at,created_at) VALUES (?,?,?,?,?,?,?,?)`,
+		id, orgID, req.ItemID, req.UnitPrice, code, currentUserID(c), expires, now.Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "code": code, "item_id": req.ItemID, "unit_price": req.UnitPrice, "expires_at": expires})
+}
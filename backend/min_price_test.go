@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMinimumSalePrice(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('oil','Oil','OIL',50,200,150,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	sale := func(role, price, code string) (int, map[string]interface{}) {
+		return call(role, "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":`+price+`,"paid_amount":`+price+`,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"oil","quantity":1,"unit_price":`+price+`,"override_code":"`+code+`"}]}`)
+	}
+
+	if code, out := call("manager", "PATCH", "/api/collections/inventory_items/records/oil", `{"min_sale_price":180}`); code != 200 {
+		t.Fatalf("set minimum: %d %v", code, out)
+	}
+	if code, out := sale("cashier", "185", ""); code != 200 {
+		t.Errorf("sale above the minimum: %d %v", code, out)
+	}
+	code, out := sale("cashier", "160", "")
+	if code != 409 || out["min_sale_price"] != 180.0 {
+		t.Errorf("cashier below the minimum: %d %v", code, out)
+	}
+	if code, _ := call("cashier", "POST", "/api/price-overrides", `{"item_id":"oil","unit_price":160}`); code != 403 {
+		t.Errorf("cashier issuing an override: got %d, want 403", code)
+	}
+	_, override := call("manager", "POST", "/api/price-overrides", `{"item_id":"oil","unit_price":160}`)
+	otp := toString(override["code"])
+	if len(otp) != 6 {
+		t.Fatalf("override: %v", override)
+	}
+	if code, _ := sale("cashier", "150", otp); code != 409 {
+		t.Errorf("code used below the approved price: got %d, want 409", code)
+	}
+	code, out = sale("cashier", "160", otp)
+	if code != 200 {
+		t.Fatalf("sale with the code: %d %v", code, out)
+	}
+	var approvedBy string
+	if err := db.QueryRow(`SELECT COALESCE(price_approved_by, '') FROM transaction_items WHERE transaction_id = ?`, out["id"]).Scan(&approvedBy); err != nil || approvedBy == "" {
+		t.Errorf("approval not recorded: %q %v", approvedBy, err)
+	}
+	if code, _ := sale("cashier", "160", otp); code != 409 {
+		t.Errorf("code used twice: got %d, want 409", code)
+	}
+	if code, out := sale("manager", "120", ""); code != 200 {
+		t.Errorf("manager below the minimum: %d %v", code, out)
+	}
+
+	if _, err := db.Exec(`INSERT INTO settings (scope,scope_id,key,value) VALUES ('organization','org-1','below_minimum_price','"reject"')`); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := sale("admin", "120", ""); code != 409 {
+		t.Errorf("below the minimum with reject: got %d, want 409", code)
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Customers can pay what is due on a sale from their mobile wallet. A
+// payment request is made with the wallet's provider for the due amount,
+// or part of it, and the customer pays on the provider's page at
+// payment_url. The provider then sends the customer back through its
+// callback, where the payment is confirmed with the provider and recorded
+// against the sale as a payment in the provider's method (payments.go),
+// taking it off what is due. bKash's tokenized checkout is the provider
+// implemented; another wallet is added as a mobileMoneyProvider. A
+// wallet is only offered when the books are kept in its currency
+// (EXCHANGE_RATE_BASE), as what is due is asked for as it stands.
+//
+// A request is pending until the callback, confirming while the payment
+// is confirmed with the provider, and then completed, cancelled or
+// failed, as the provider says it is. One paid after the sale stopped
+// owing as much (or was voided) is unapplied, with the reason in error,
+// for the payment to be sorted out by hand.
+//
+//	POST /api/transactions/:id/mobile-payments   {"provider": "bkash", "amount"}; amount defaults to what is due
+//	GET  /api/transactions/:id/mobile-payments   the requests, newest first
+//	GET  /api/mobile-payments/:provider/callback where the provider sends the customer back
+//
+// Configuration:
+//
+//	BKASH_BASE_URL             tokenized checkout API (defaults to bKash's sandbox)
+//	BKASH_APP_KEY, BKASH_APP_SECRET, BKASH_USERNAME, BKASH_PASSWORD
+//	                           the merchant's credentials
+//	MOBILE_PAYMENT_RETURN_URL  where the customer ends up, with ?status=;
+//	                           without it they are shown a line of text
+
+func registerMobileMoneyRoutes(app *fiber.App) {
+	app.Get("/api/mobile-payments/:provider/callback", handleMobilePaymentCallback)
+	app.Post("/api/transactions/:id/mobile-payments", requireAuth, requireRole("admin", "manager", "cashier"), handleCreateMobilePayment)
+	app.Get("/api/transactions/:id/mobile-payments", requireAuth, handleListMobilePayments)
+}
+
+// mobilePaymentRequest is what a customer is asked to pay.
+type mobilePaymentRequest struct {
+	amount      money
+	invoice     string
+	payer       string
+	callbackURL string
+}
+
+// mobileMoneyProvider takes payments from a mobile wallet.
+type mobileMoneyProvider interface {
+	// createPayment asks for a payment and returns the provider's id for
+	// it and the page the customer pays on.
+	createPayment(p mobilePaymentRequest) (string, string, error)
+	// confirmPayment completes the payment once the customer has approved
+	// it and returns the provider's transaction id and the amount paid.
+	confirmPayment(paymentID string) (string, money, error)
+	// paymentStatus is where the provider has the payment: pending,
+	// completed, cancelled or failed.
+	paymentStatus(paymentID string) (string, error)
+	// currency is the one currency the provider takes payments in.
+	currency() string
+}
+
+// mobileMoneyProviderNamed is the provider a request names; its name is
+// also the payment method its payments are recorded in.
+func mobileMoneyProviderNamed(name string) (mobileMoneyProvider, error) {
+	switch name {
+	case "bkash":
+		base := os.Getenv("BKASH_BASE_URL")
+		if base == "" {
+			base = "https://tokenized.sandbox.bka.sh/v1.2.0-beta"
+		}
+		return bkashCheckout{
+			baseURL:   strings.TrimRight(base, "/"),
+			appKey:    os.Getenv("BKASH_APP_KEY"),
+			appSecret: os.Getenv("BKASH_APP_SECRET"),
+			username:  os.Getenv("BKASH_USERNAME"),
+			password:  os.Getenv("BKASH_PASSWORD"),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", name)
+}
+
+type bkashCheckout struct{ baseURL, appKey, appSecret, username, password string }
+
+func (bkashCheckout) currency() string { return "BDT" }
+
+// call POSTs body to one of bKash's checkout endpoints and decodes the
+// answer into out. bKash answers 200 to most failures, so its status
+// code is checked as well.
+func (b bkashCheckout) call(path, token string, body, out interface{}) error {
+	payload, _ := json.Marshal(body)
+	req, err := http.NewRequest("POST", b.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if token == "" {
+		req.Header.Set("username", b.username)
+		req.Header.Set("password", b.password)
+	} else {
+		req.Header.Set("Authorization", token)
+		req.Header.Set("X-APP-Key", b.appKey)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var status struct {
+		StatusCode    string `json:"statusCode"`
+		StatusMessage string `json:"statusMessage"`
+		ErrorMessage  string `json:"errorMessage"`
+	}
+	_ = json.Unmarshal(raw, &status)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 || (status.StatusCode != "" && status.StatusCode != "0000") || status.ErrorMessage != "" {
+		msg := status.StatusMessage
+		if status.ErrorMessage != "" {
+			msg = status.ErrorMessage
+		}
+		if msg == "" {
+			msg = resp.Status
+		}
+		return fmt.Errorf("bkash: %s", msg)
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// token grants the id token the other calls are made with.
+func (b bkashCheckout) token() (string, error) {
+	if b.appKey == "" || b.appSecret == "" || b.username == "" || b.password == "" {
+		return "", fmt.Errorf("BKASH_APP_KEY, BKASH_APP_SECRET, BKASH_USERNAME and BKASH_PASSWORD must be set")
+	}
+	var out struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := b.call("/tokenized/checkout/token/grant", "", map[string]string{"app_key": b.appKey, "app_secret": b.appSecret}, &out); err != nil {
+		return "", err
+	}
+	return out.IDToken, nil
+}
+
+func (b bkashCheckout) createPayment(p mobilePaymentRequest) (string, string, error) {
+	token, err := b.token()
+	if err != nil {
+		return "", "", err
+	}
+	payer := p.payer
+	if payer == "" {
+		payer = p.invoice
+	}
+	var out struct {
+		PaymentID string `json:"paymentID"`
+		BkashURL  string `json:"bkashURL"`
+	}
+	err = b.call("/tokenized/checkout/create", token, map[string]string{
+		"mode":                  "0011",
+		"payerReference":        payer,
+		"callbackURL":           p.callbackURL,
+		"amount":                p.amount.String(),
+		"currency":              b.currency(),
+		"intent":                "sale",
+		"merchantInvoiceNumber": p.invoice,
+	}, &out)
+	if err != nil {
+		return "", "", err
+	}
+	return out.PaymentID, out.BkashURL, nil
+}
+
+func (b bkashCheckout) confirmPayment(paymentID string) (string, money, error) {
+	token, err := b.token()
+	if err != nil {
+		return "", 0, err
+	}
+	var out struct {
+		TrxID             string `json:"trxID"`
+		TransactionStatus string `json:"transactionStatus"`
+		Amount            money  `json:"amount"`
+	}
+	if err := b.call("/tokenized/checkout/execute", token, map[string]string{"paymentID": paymentID}, &out); err != nil {
+		return "", 0, err
+	}
+	if out.TransactionStatus != "Completed" {
+		return "", 0, fmt.Errorf("bkash: payment is %s", strings.ToLower(out.TransactionStatus))
+	}
+	return out.TrxID, out.Amount, nil
+}
+
+func (b bkashCheckout) paymentStatus(paymentID string) (string, error) {
+	token, err := b.token()
+	if err != nil {
+		return "", err
+	}
+	var out struct {
+		TransactionStatus string `json:"transactionStatus"`
+	}
+	if err := b.call("/tokenized/checkout/payment/status", token, map[string]string{"paymentID": paymentID}, &out); err != nil {
+		return "", err
+	}
+	switch out.TransactionStatus {
+	case "Initiated", "Authorized":
+		return "pending", nil
+	case "Completed":
+		return "completed", nil
+	case "Cancelled":
+		return "cancelled", nil
+	}
+	return "failed", nil
+}
+
+const mobilePaymentSQL = `SELECT id, transaction_id, provider, provider_payment_id, amount, payment_url, status, COALESCE(provider_trx_id, '') AS provider_trx_id, COALESCE(error, '') AS error, COALESCE(created_by, '') AS created_by, created_at, COALESCE(completed_at, '') AS completed_at FROM mobile_payments`
+
+func handleCreateMobilePayment(c *fiber.Ctx) error {
+	orgID, id := currentOrgID(c), c.Params("id")
+	var req struct {
+		Provider string  `json:"provider"`
+		Amount   float64 `json:"amount"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	provider, err := mobileMoneyProviderNamed(req.Provider)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	// what is due is in the base currency, so it is what the wallet is asked for
+	if provider.currency() != baseCurrency() {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("%s takes payments in %s and the books are kept in %s", req.Provider, provider.currency(), baseCurrency())})
+	}
+	inv, err := loadInvoice(dbFor(c), orgID, id)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if inv.voidedAt != "" {
+		return c.Status(409).JSON(fiber.Map{"error": "the transaction is void"})
+	}
+	if inv.typ != "inflow" {
+		return c.Status(400).JSON(fiber.Map{"error": "only sales are paid by mobile wallet"})
+	}
+	due := moneyOf(inv.due)
+	amount := moneyOf(req.Amount)
+	if amount == 0 {
+		amount = due
+	}
+	if due <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "nothing is due"})
+	}
+	if amount < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "amount must be positive"})
+	}
+	if amount > due {
+		return c.Status(400).JSON(fiber.Map{"error": "payment is more than is due", "due": due})
+	}
+
+	paymentID, payURL, err := provider.createPayment(mobilePaymentRequest{
+		amount:      amount,
+		invoice:     inv.number(),
+		payer:       inv.contact.phone,
+		callbackURL: appURL(c) + "/api/mobile-payments/" + url.PathEscape(req.Provider) + "/callback",
+	})
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	payment := fiber.Map{"id": genID(), "transaction_id": id, "provider": req.Provider, "provider_payment_id": paymentID, "amount": amount, "payment_url": payURL, "status": "pending", "created_by": currentUserID(c), "created_at": time.Now().Format(time.RFC3339)}
+	if _, err := dbFor(c).Exec(`INSERT INTO mobile_payments (id,organization_id,transaction_id,provider,provider_payment_id,amount,payment_url,status,created_by,created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`,
+		payment["id"], orgID, id, req.Provider, paymentID, amount.float(), payURL, "pending", payment["created_by"], payment["created_at"]); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(payment)
+}
+
+func handleListMobilePayments(c *fiber.Ctx) error {
+	orgID, id := currentOrgID(c), c.Params("id")
+	if !orgOwns(dbFor(c), "transactions", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err := dbFor(c).Query(mobilePaymentSQL+` WHERE organization_id = ? AND transaction_id = ? ORDER BY created_at DESC`, orgID, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	items, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	moneyColumns(items, "amount")
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleMobilePaymentCallback is where the provider sends the customer
+// once they have paid, cancelled or failed to. The request is only
+// trusted as far as naming the payment: a payment is recorded only after
+// the provider confirms it, and only once, however often the customer
+// comes back, and it is only marked cancelled or failed when the
+// provider says so. The visit that moves the request from pending to
+// confirming is the only one that confirms it with the provider; if the
+// provider cannot be reached it goes back to pending to be checked again.
+func handleMobilePaymentCallback(c *fiber.Ctx) error {
+	name := c.Params("provider")
+	provider, err := mobileMoneyProviderNamed(name)
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+	q := dbFor(c)
+	paymentID := c.Query("paymentID")
+	var id, orgID, transactionID, status string
+	err = q.QueryRow(`SELECT id, organization_id, transaction_id, status FROM mobile_payments WHERE provider = ? AND provider_payment_id = ?`, name, paymentID).
+		Scan(&id, &orgID, &transactionID, &status)
+	if err == sql.ErrNoRows || paymentID == "" {
+		return c.Status(404).JSON(fiber.Map{"error": "unknown payment"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status != "pending" {
+		return mobilePaymentDone(c, status)
+	}
+	now := time.Now().Format(time.RFC3339)
+	if c.Query("status") != "success" {
+		status, err := provider.paymentStatus(paymentID)
+		if err != nil {
+			return c.Status(502).SendString("The payment could not be checked; try again shortly.")
+		}
+		if status != "cancelled" && status != "failed" {
+			// still open with the provider, so left for the customer to pay
+			return mobilePaymentDone(c, "failed")
+		}
+		if _, err := q.Exec(`UPDATE mobile_payments SET status = ?, completed_at = ? WHERE id = ? AND status = 'pending'`, status, now, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return mobilePaymentDone(c, status)
+	}
+
+	res, err := q.Exec(`UPDATE mobile_payments SET status = 'confirming' WHERE id = ? AND status = 'pending'`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// the customer came back twice at once and the other visit has it
+		return mobilePaymentDone(c, "confirming")
+	}
+	trxID, amount, err := provider.confirmPayment(paymentID)
+	if err != nil {
+		// only the provider's word ends the request; otherwise it is
+		// confirmed again on the customer's next visit
+		if status, serr := provider.paymentStatus(paymentID); serr == nil && (status == "cancelled" || status == "failed") {
+			if _, err := q.Exec(`UPDATE mobile_payments SET status = ?, error = ?, completed_at = ? WHERE id = ? AND status = 'confirming'`, status, err.Error(), now, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			return mobilePaymentDone(c, status)
+		}
+		if _, err := q.Exec(`UPDATE mobile_payments SET status = 'pending', error = ? WHERE id = ? AND status = 'confirming'`, err.Error(), id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.Status(502).SendString("The payment could not be confirmed; try again shortly.")
+	}
+	tx, err := q.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`UPDATE mobile_payments SET status = 'completed', provider_trx_id = ?, amount = ?, error = NULL, completed_at = ? WHERE id = ? AND status = 'confirming'`, trxID, amount.float(), now, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, code, problem := takePayment(tx, orgID, transactionID, paymentLine{Method: name, Amount: amount.float(), Reference: trxID}); code != 0 {
+		tx.Rollback()
+		if _, err := q.Exec(`UPDATE mobile_payments SET status = 'unapplied', provider_trx_id = ?, amount = ?, error = ?, completed_at = ? WHERE id = ? AND status = 'confirming'`,
+			trxID, amount.float(), toString(problem["error"]), now, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return mobilePaymentDone(c, "completed")
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "transactions", "update", transactionID)
+	return mobilePaymentDone(c, "completed")
+}
+
+// mobilePaymentDone sends the customer on to MOBILE_PAYMENT_RETURN_URL,
+// or tells them how the payment went. An unapplied payment was still
+// taken, so they are told it was completed.
+func mobilePaymentDone(c *fiber.Ctx, status string) error {
+	if status == "unapplied" {
+		status = "completed"
+	}
+	if to := os.Getenv("MOBILE_PAYMENT_RETURN_URL"); to != "" {
+		sep := "?"
+		if strings.Contains(to, "?") {
+			sep = "&"
+		}
+		return c.Redirect(to+sep+"status="+status, fiber.StatusSeeOther)
+	}
+	switch status {
+	case "completed":
+		return c.SendString("Payment received. Thank you.")
+	case "cancelled":
+		return c.SendString("The payment was cancelled.")
+	case "confirming":
+		return c.Status(202).SendString("The payment is being confirmed; check back shortly.")
+	}
+	return c.Status(402).SendString("The payment did not go through.")
+}
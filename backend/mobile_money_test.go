@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBkashPayments(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','01711000000','customer','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,payment_method,organization_id,created_at) VALUES ('t-1','inflow',10000,4000,6000,'c-1','cash','org-1','2024-01-01T00:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('p-1','outflow',3000,0,3000,'c-1','org-1','2024-01-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+
+	created, executed := 0, 0
+	// bKash's execute endpoint is down while set
+	down := false
+	// what bKash has for each payment; the rest are still open
+	bkashStatus := map[string]string{"PAY1": "Cancelled"}
+	bkash := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		switch r.URL.Path {
+		case "/tokenized/checkout/token/grant":
+			if r.Header.Get("username") != "merchant" || body["app_key"] != "key" {
+				fmt.Fprint(w, `{"statusCode":"2001","statusMessage":"Invalid App Key"}`)
+				return
+			}
+			fmt.Fprint(w, `{"statusCode":"0000","id_token":"tok"}`)
+		case "/tokenized/checkout/create":
+			if r.Header.Get("Authorization") != "tok" || body["currency"] != "BDT" {
+				t.Errorf("create: %v %v", r.Header, body)
+			}
+			created++
+			fmt.Fprintf(w, `{"statusCode":"0000","paymentID":"PAY%d","bkashURL":"https://pay.example/%d","amount":%q}`, created, created, body["amount"])
+		case "/tokenized/checkout/execute":
+			if down {
+				w.WriteHeader(503)
+				return
+			}
+			executed++
+			fmt.Fprintf(w, `{"statusCode":"0000","paymentID":%q,"trxID":"TRX%d","transactionStatus":"Completed","amount":"25.00"}`, body["paymentID"], executed)
+		case "/tokenized/checkout/payment/status":
+			status := bkashStatus[body["paymentID"]]
+			if status == "" {
+				status = "Initiated"
+			}
+			fmt.Fprintf(w, `{"statusCode":"0000","paymentID":%q,"transactionStatus":%q}`, body["paymentID"], status)
+		}
+	}))
+	defer bkash.Close()
+	t.Setenv("BKASH_BASE_URL", bkash.URL)
+	t.Setenv("BKASH_APP_KEY", "key")
+	t.Setenv("BKASH_APP_SECRET", "secret")
+	t.Setenv("BKASH_USERNAME", "merchant")
+	t.Setenv("BKASH_PASSWORD", "pass")
+
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if role != "" {
+			req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	due := func() float64 {
+		var d money
+		if err := db.QueryRow(`SELECT due_amount FROM transactions WHERE id = 't-1'`).Scan(&d); err != nil {
+			t.Fatal(err)
+		}
+		return d.float()
+	}
+
+	for body, want := range map[string]int{
+		`{"provider":"rocket"}`:            400,
+		`{"provider":"bkash","amount":61}`: 400,
+		`{"provider":"bkash","amount":-5}`: 400,
+	} {
+		if code, out := call("cashier", "POST", "/api/transactions/t-1/mobile-payments", body); code != want {
+			t.Errorf("%s: got %d %v, want %d", body, code, out, want)
+		}
+	}
+	if code, _ := call("cashier", "POST", "/api/transactions/p-1/mobile-payments", `{"provider":"bkash"}`); code != 400 {
+		t.Errorf("asking a supplier's bill: got %d, want 400", code)
+	}
+	t.Setenv("EXCHANGE_RATE_BASE", "USD")
+	if code, _ := call("cashier", "POST", "/api/transactions/t-1/mobile-payments", `{"provider":"bkash"}`); code != 400 {
+		t.Errorf("bKash for books kept in dollars: got %d, want 400", code)
+	}
+	t.Setenv("EXCHANGE_RATE_BASE", "BDT")
+
+	code, full := call("cashier", "POST", "/api/transactions/t-1/mobile-payments", `{"provider":"bkash"}`)
+	if code != 200 || full["amount"] != 60.0 || full["payment_url"] != "https://pay.example/1" || full["status"] != "pending" {
+		t.Fatalf("request for what is due: %d %v", code, full)
+	}
+	// the customer walks away from the first and pays part with the second
+	if code, _ := call("", "GET", "/api/mobile-payments/bkash/callback?paymentID=PAY1&status=cancel", ""); code != 200 {
+		t.Errorf("cancelled: got %d", code)
+	}
+	if code, out := call("cashier", "POST", "/api/transactions/t-1/mobile-payments", `{"provider":"bkash","amount":25}`); code != 200 || out["amount"] != 25.0 {
+		t.Fatalf("request for part: %d %v", code, out)
+	}
+	if code, _ := call("", "GET", "/api/mobile-payments/bkash/callback?paymentID=NOPE&status=success", ""); code != 404 {
+		t.Errorf("unknown payment: got %d, want 404", code)
+	}
+	// anyone can claim a payment was cancelled; bKash says it is still open
+	call("", "GET", "/api/mobile-payments/bkash/callback?paymentID=PAY2&status=cancel", "")
+	var pending string
+	if err := db.QueryRow(`SELECT status FROM mobile_payments WHERE provider_payment_id = 'PAY2'`).Scan(&pending); err != nil || pending != "pending" {
+		t.Errorf("forged cancel: %v %s", err, pending)
+	}
+	// bKash cannot be reached: the request is left to be confirmed again
+	down = true
+	if code, _ := call("", "GET", "/api/mobile-payments/bkash/callback?paymentID=PAY2&status=success", ""); code != 502 {
+		t.Errorf("bKash down: got %d, want 502", code)
+	}
+	if err := db.QueryRow(`SELECT status FROM mobile_payments WHERE provider_payment_id = 'PAY2'`).Scan(&pending); err != nil || pending != "pending" {
+		t.Errorf("after bKash was down: %v %s", err, pending)
+	}
+	down = false
+	for i := 0; i < 2; i++ {
+		if code, _ := call("", "GET", "/api/mobile-payments/bkash/callback?paymentID=PAY2&status=success", ""); code != 200 {
+			t.Errorf("paid, visit %d: got %d", i+1, code)
+		}
+	}
+	if executed != 1 {
+		t.Errorf("payment executed %d times, want once", executed)
+	}
+	if d := due(); d != 35 {
+		t.Errorf("due after paying 25 of 60: %v", d)
+	}
+	var method, reference string
+	if err := db.QueryRow(`SELECT method, reference FROM transaction_payments WHERE transaction_id = 't-1' AND method = 'bkash'`).Scan(&method, &reference); err != nil || reference != "TRX1" {
+		t.Errorf("payment line: %v %q", err, reference)
+	}
+
+	_, list := call("manager", "GET", "/api/transactions/t-1/mobile-payments", "")
+	items, _ := list["items"].([]interface{})
+	statuses := map[string]string{}
+	for _, it := range items {
+		m := it.(map[string]interface{})
+		statuses[toString(m["provider_payment_id"])] = toString(m["status"]) + " " + toString(m["provider_trx_id"])
+	}
+	if statuses["PAY1"] != "cancelled " || statuses["PAY2"] != "completed TRX1" {
+		t.Errorf("requests: %v", statuses)
+	}
+
+	// paid after the sale was settled another way: taken, but not applied
+	if code, _ := call("cashier", "POST", "/api/transactions/t-1/mobile-payments", `{"provider":"bkash","amount":25}`); code != 200 {
+		t.Fatal("third request")
+	}
+	if _, err := db.Exec(`UPDATE transactions SET paid_amount = 10000, due_amount = 0 WHERE id = 't-1'`); err != nil {
+		t.Fatal(err)
+	}
+	call("", "GET", "/api/mobile-payments/bkash/callback?paymentID=PAY3&status=success", "")
+	var status, problem string
+	if err := db.QueryRow(`SELECT status, COALESCE(error, '') FROM mobile_payments WHERE provider_payment_id = 'PAY3'`).Scan(&status, &problem); err != nil || status != "unapplied" || problem == "" {
+		t.Errorf("paid when nothing was due: %v %s %q", err, status, problem)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amounts of money are worked out in minor units (paisa, cents) so line
+// totals and sums come out exact: 3 x 33.30 is 99.90, not
+// 99.89999999999999. A float from a JSON body or a database row becomes a
+// money by rounding to the nearest minor unit, halves away from zero, and
+// a money is written out with two decimals. The amounts of transactions
+// and their lines (amount, paid_amount, due_amount, unit_price and
+// total_price) are stored as whole minor units, INTEGER on SQLite and
+// BIGINT on PostgreSQL (see migrations/0051_money_amounts), and are only
+// turned into decimals at the JSON boundary. Other amount columns are
+// still REAL in major units and are written with float().
+
+type money int64
+
+const minorUnits = 100
+
+// moneyOf rounds v to the nearest minor unit. v is rounded as it is
+// written in decimal, so 1.005 is 1.01 although the float nearest 1.005
+// is a little below it.
+func moneyOf(v float64) money {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	m, _ := parseMoney(strconv.FormatFloat(v, 'f', -1, 64))
+	return m
+}
+
+// moneyValue is moneyOf for a value from a decoded JSON body; anything
+// but a number is zero.
+func moneyValue(v interface{}) money {
+	f, _ := v.(float64)
+	return moneyOf(f)
+}
+
+// parseMoney reads a decimal amount such as "-12.345", rounding it to
+// minor units.
+func parseMoney(s string) (money, error) {
+	s = strings.TrimSpace(s)
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not an amount", s)
+		}
+		return moneyOf(f), nil
+	}
+	negative := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	whole, fraction, _ := strings.Cut(digits, ".")
+	if whole == "" {
+		whole = "0"
+	}
+	for _, part := range []string{whole, fraction} {
+		if strings.Trim(part, "0123456789") != "" {
+			return 0, fmt.Errorf("%q is not an amount", s)
+		}
+	}
+	if len(whole) > 16 {
+		return 0, fmt.Errorf("%q is too large an amount", s)
+	}
+	cents := (fraction + "000")[:3]
+	n, _ := strconv.ParseInt(whole+cents[:2], 10, 64)
+	if cents[2] >= '5' {
+		n++
+	}
+	if negative {
+		n = -n
+	}
+	return money(n), nil
+}
+
+// float is m in major units, for arithmetic that is not on amounts
+// (percentages, averages) and for columns still stored as REAL.
+func (m money) float() float64 {
+	return float64(m) / minorUnits
+}
+
+// percent is rate percent of m, rounded to minor units.
+func (m money) percent(rate float64) money {
+	return moneyOf(float64(m) * rate / 100 / minorUnits)
+}
+
+func (m money) String() string {
+	sign, n := "", int64(m)
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, n/minorUnits, n%minorUnits)
+}
+
+func (m money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *money) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	v, err := parseMoney(strings.Trim(string(b), `"`))
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Scan reads an amount. Whole numbers are minor units: an amount column
+// of transactions or their lines, or a sum of one, which PostgreSQL gives
+// as NUMERIC text. A float is in major units, from a REAL column.
+func (m *money) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*m = 0
+	case float64:
+		*m = moneyOf(v)
+	case int64:
+		*m = money(v)
+	case []byte:
+		return m.Scan(string(v))
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an amount", v)
+		}
+		*m = money(math.Round(f))
+	default:
+		return fmt.Errorf("cannot read %T as an amount", src)
+	}
+	return nil
+}
+
+// Value stores m as whole minor units, for the amount columns of
+// transactions and their lines.
+func (m money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+// roundAmounts rounds the amounts of a transaction body to minor units
+// before it is priced and stored: the transaction's amounts, its payment
+// lines and the prices of its item lines.
+func roundAmounts(body map[string]interface{}) {
+	round := func(m map[string]interface{}, keys ...string) {
+		for _, key := range keys {
+			if v, ok := m[key].(float64); ok {
+				m[key] = moneyOf(v).float()
+			}
+		}
+	}
+	round(body, "amount", "paid_amount", "due_amount")
+	for _, key := range []string{"payments", "items"} {
+		lines, _ := body[key].([]interface{})
+		for _, line := range lines {
+			if l, ok := line.(map[string]interface{}); ok {
+				round(l, "amount", "unit_price", "total_price")
+			}
+		}
+	}
+}
+
+// moneyColumns turns the amount columns of rows read with rowsToMaps into
+// money, so they are written out with two decimals. As with Scan, whole
+// numbers are minor units and floats major units.
+func moneyColumns(rows []map[string]interface{}, columns ...string) {
+	for _, row := range rows {
+		for _, col := range columns {
+			if v, ok := row[col].(float64); ok {
+				row[col] = moneyOf(v)
+			} else if v, ok := row[col].(int64); ok {
+				row[col] = money(v)
+			}
+		}
+	}
+}
+
+// lineTotal prices quantity units at unitPrice.
+func lineTotal(quantity int, unitPrice float64) money {
+	return moneyOf(unitPrice) * money(quantity)
+}
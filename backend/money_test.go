@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMoney(t *testing.T) {
+	for _, tc := range []struct {
+		in   float64
+		want money
+	}{
+		{99.9, 9990},
+		{3 * 33.3, 9990},
+		{0.1 + 0.2, 30},
+		{1.005, 101},
+		{2.675, 268},
+		{-2.675, -268},
+		{-0.004, 0},
+		{1e3, 100000},
+	} {
+		if got := moneyOf(tc.in); got != tc.want {
+			t.Errorf("moneyOf(%v) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+	for _, tc := range []struct {
+		in   string
+		want money
+	}{
+		{"12.345", 1235}, {"-12.344", -1234}, {".5", 50}, {"7", 700}, {"1.5e2", 15000},
+	} {
+		if got, err := parseMoney(tc.in); err != nil || got != tc.want {
+			t.Errorf("parseMoney(%q) = %d, %v, want %d", tc.in, got, err, tc.want)
+		}
+	}
+	for _, bad := range []string{"abc", "1.2.3", "--1", "12345678901234567"} {
+		if _, err := parseMoney(bad); err == nil {
+			t.Errorf("parseMoney(%q): want an error", bad)
+		}
+	}
+	out, _ := json.Marshal(map[string]money{"a": 9990, "b": -5, "c": 0})
+	if string(out) != `{"a":99.90,"b":-0.05,"c":0.00}` {
+		t.Errorf("marshal: %s", out)
+	}
+	var in struct{ Amount money }
+	if err := json.Unmarshal([]byte(`{"Amount": 33.335}`), &in); err != nil || in.Amount != 3334 {
+		t.Errorf("unmarshal: %d %v", in.Amount, err)
+	}
+	if tax := money(100000).percent(15); tax != 15000 {
+		t.Errorf("15%% of 1000.00: %s", tax)
+	}
+	// whole numbers from the database are paisa, floats are taka
+	for _, tc := range []struct {
+		src  interface{}
+		want money
+	}{
+		{int64(9990), 9990}, {"9990", 9990}, {[]byte("-5"), -5}, {99.9, 9990}, {nil, 0},
+	} {
+		var m money
+		if err := m.Scan(tc.src); err != nil || m != tc.want {
+			t.Errorf("Scan(%#v) = %d, %v, want %d", tc.src, m, err, tc.want)
+		}
+	}
+	if v, err := money(9990).Value(); err != nil || v != int64(9990) {
+		t.Errorf("Value() = %#v, %v", v, err)
+	}
+}
+
+func TestMoneyAmounts(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('oil','Oil','OIL',10,33.3,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	app := newApp()
+	call := func(method, path, body string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "cashier"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(out)
+	}
+
+	// the line is priced 3 x 33.30 in paisa, and paid in three parts
+	code, out := call("POST", "/api/collections/transactions/records", `{"type":"inflow","amount":99.9,"paid_amount":99.9,"contact_id":"c-1",
+		"payments":[{"method":"cash","amount":33.3},{"method":"cash","amount":33.3},{"method":"cash","amount":33.3}],
+		"items":[{"item_id":"oil","quantity":3,"unit_price":33.3}]}`)
+	if code != 200 {
+		t.Fatalf("sale: %d %s", code, out)
+	}
+	var created struct{ ID string }
+	_ = json.Unmarshal([]byte(out), &created)
+	// stored as whole paisa
+	var total, due int64
+	if err := db.QueryRow(`SELECT ti.total_price, t.due_amount FROM transaction_items ti JOIN transactions t ON t.id = ti.transaction_id WHERE t.id = ?`, created.ID).Scan(&total, &due); err != nil || total != 9990 || due != 0 {
+		t.Errorf("stored: total_price %v, due_amount %v, %v", total, due, err)
+	}
+
+	if _, out := call("GET", "/api/collections/transactions/records/"+created.ID, ""); !strings.Contains(out, `"amount":99.90,"paid_amount":99.90,"due_amount":0.00`) {
+		t.Errorf("record: %s", out)
+	}
+	_, out = call("GET", "/api/collections/transactions/records?expand=items", "")
+	if !strings.Contains(out, `"amount":99.90`) || !strings.Contains(out, `"unit_price":33.30,"total_price":99.90`) {
+		t.Errorf("list: %s", out)
+	}
+}
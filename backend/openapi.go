@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The OpenAPI document for the collection API is generated from
+// collectionFields, so a column added to a collection's allowlist shows up
+// in the spec (and in its filter and sort docs) without further work. It
+// is served at /api/openapi.json, with Swagger UI at /api/docs.
+
+func registerOpenAPIRoutes(app *fiber.App) {
+	spec, err := json.Marshal(openAPISpec())
+	must(err)
+	app.Get("/api/openapi.json", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(spec)
+	})
+	app.Get("/api/docs", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMETextHTMLCharsetUTF8)
+		return c.SendString(swaggerUIPage)
+	})
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>BizCalc API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/api/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// collectionOperations lists what the collection API supports per
+// collection, mirroring the switches in handleGet, handleCreate,
+// handlePatch and handleUploadFile.
+var collectionOperations = map[string][]string{
+	"contacts":               {"list", "get", "create", "upload"},
+	"inventory_items":        {"list", "get", "create", "patch", "upload"},
+	"inventory_transactions": {"list", "create"},
+	"transactions":           {"list", "get", "create", "patch", "upload"},
+	"payment_methods":        {"list", "create", "patch"},
+}
+
+// fieldTypes gives the JSON type of collection fields that are not strings.
+var fieldTypes = map[string]string{
+	"quantity": "integer", "reorder_level": "integer", "rental_stock": "integer",
+	"quantity_change": "integer", "previous_quantity": "integer", "new_quantity": "integer",
+	"unit_price": "number", "cost_price": "number", "rental_rate": "number", "late_fee_rate": "number",
+	"amount": "number", "paid_amount": "number", "due_amount": "number", "tax_amount": "number", "currency_amount": "number",
+	"active": "integer",
+}
+
+// readOnlyFields are set by the server and ignored on create and patch.
+var readOnlyFields = map[string]bool{"id": true, "organization_id": true, "created_at": true, "updated_at": true, "source": true, "voided_at": true}
+
+func schemaRef(name string) fiber.Map {
+	return fiber.Map{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonBody(schema fiber.Map) fiber.Map {
+	return fiber.Map{"required": true, "content": fiber.Map{"application/json": fiber.Map{"schema": schema}}}
+}
+
+func jsonResponse(description string, schema fiber.Map) fiber.Map {
+	return fiber.Map{"description": description, "content": fiber.Map{"application/json": fiber.Map{"schema": schema}}}
+}
+
+// recordSchema describes a collection record; with writable set, only the
+// fields a client may send.
+func recordSchema(collection string, writable bool) fiber.Map {
+	props := fiber.Map{}
+	for _, f := range collectionFields[collection] {
+		if writable && readOnlyFields[f] {
+			continue
+		}
+		typ := fieldTypes[f]
+		if typ == "" {
+			typ = "string"
+		}
+		props[f] = fiber.Map{"type": typ}
+	}
+	return fiber.Map{"type": "object", "properties": props}
+}
+
+func openAPISpec() fiber.Map {
+	errors := fiber.Map{
+		"400": jsonResponse("Invalid request", schemaRef("Error")),
+		"401": jsonResponse("Missing or invalid token", schemaRef("Error")),
+		"403": jsonResponse("Role not allowed", schemaRef("Error")),
+	}
+	withErrors := func(responses fiber.Map) fiber.Map {
+		for code, r := range errors {
+			responses[code] = r
+		}
+		return responses
+	}
+	idParam := fiber.Map{"name": "id", "in": "path", "required": true, "schema": fiber.Map{"type": "string"}}
+
+	schemas := fiber.Map{
+		"Error": fiber.Map{"type": "object", "properties": fiber.Map{"error": fiber.Map{"type": "string"}}},
+		"Created": fiber.Map{"type": "object", "properties": fiber.Map{
+			"id":           fiber.Map{"type": "string"},
+			"warning":      fiber.Map{"type": "string", "description": "transactions only: set when the record looks like a duplicate"},
+			"duplicate_of": fiber.Map{"type": "string"},
+		}},
+		"PaymentLine": fiber.Map{"type": "object", "required": []string{"method", "amount"}, "properties": fiber.Map{
+			"method":     fiber.Map{"type": "string", "description": "payment method code, e.g. cash or bkash"},
+			"amount":     fiber.Map{"type": "number"},
+			"reference":  fiber.Map{"type": "string"},
+			"account_id": fiber.Map{"type": "string", "description": "cash account the money lands in; defaults to the method's account"},
+		}},
+		"TransactionLine": fiber.Map{"type": "object", "properties": fiber.Map{
+			"item_id":     fiber.Map{"type": "string"},
+			"item_name":   fiber.Map{"type": "string"},
+			"sku":         fiber.Map{"type": "string"},
+			"quantity":    fiber.Map{"type": "integer"},
+			"unit_price":  fiber.Map{"type": "number"},
+			"total_price": fiber.Map{"type": "number"},
+		}},
+		"Tokens": fiber.Map{"type": "object", "properties": fiber.Map{
+			"token":              fiber.Map{"type": "string"},
+			"expires_at":         fiber.Map{"type": "string", "format": "date-time"},
+			"refresh_token":      fiber.Map{"type": "string"},
+			"refresh_expires_at": fiber.Map{"type": "string", "format": "date-time"},
+			"user":               fiber.Map{"type": "object"},
+		}},
+	}
+
+	paths := fiber.Map{
+		"/api/auth/login": fiber.Map{"post": fiber.Map{
+			"tags":        []string{"auth"},
+			"summary":     "Log in and get an access token",
+			"security":    []fiber.Map{},
+			"requestBody": jsonBody(fiber.Map{"type": "object", "properties": fiber.Map{"email": fiber.Map{"type": "string"}, "password": fiber.Map{"type": "string"}}}),
+			"responses":   fiber.Map{"200": jsonResponse("Token pair", schemaRef("Tokens")), "401": jsonResponse("Wrong email or password", schemaRef("Error"))},
+		}},
+		"/api/auth/refresh": fiber.Map{"post": fiber.Map{
+			"tags":        []string{"auth"},
+			"summary":     "Exchange a refresh token for a new token pair",
+			"security":    []fiber.Map{},
+			"requestBody": jsonBody(fiber.Map{"type": "object", "properties": fiber.Map{"refresh_token": fiber.Map{"type": "string"}}}),
+			"responses":   fiber.Map{"200": jsonResponse("Token pair", schemaRef("Tokens")), "401": jsonResponse("Invalid refresh token", schemaRef("Error"))},
+		}},
+	}
+
+	collections := make([]string, 0, len(collectionOperations))
+	for name := range collectionOperations {
+		collections = append(collections, name)
+	}
+	sort.Strings(collections)
+	for _, name := range collections {
+		schemaName := collectionSchemaName(name)
+		record := recordSchema(name, false)
+		if name == "transactions" {
+			props := record["properties"].(fiber.Map)
+			props["contact"] = fiber.Map{"allOf": []fiber.Map{schemaRef("Contact")}, "description": "with expand=contact"}
+			props["items"] = fiber.Map{"type": "array", "items": schemaRef("TransactionLine"), "description": "with expand=items"}
+			props["payments"] = fiber.Map{"type": "array", "items": schemaRef("PaymentLine"), "description": "on GET by id, and in lists with expand=payments"}
+		}
+		schemas[schemaName] = record
+		schemas[schemaName+"List"] = fiber.Map{"type": "object", "properties": fiber.Map{
+			"items":      fiber.Map{"type": "array", "items": schemaRef(schemaName)},
+			"page":       fiber.Map{"type": "integer"},
+			"perPage":    fiber.Map{"type": "integer"},
+			"totalItems": fiber.Map{"type": "integer"},
+			"totalPages": fiber.Map{"type": "integer"},
+		}}
+		input := recordSchema(name, true)
+		if name == "transactions" {
+			props := input["properties"].(fiber.Map)
+			props["items"] = fiber.Map{"type": "array", "items": schemaRef("TransactionLine")}
+			props["payments"] = fiber.Map{"type": "array", "items": schemaRef("PaymentLine"), "description": "split payments; paid_amount and payment_method are derived from them"}
+			props["confirm_duplicate"] = fiber.Map{"type": "boolean", "description": "create even if it looks like a recent duplicate"}
+		}
+		schemas[schemaName+"Input"] = input
+
+		fields := strings.Join(collectionFields[name], ", ")
+		base := "/api/collections/" + name + "/records"
+		listOps, itemOps := fiber.Map{}, fiber.Map{}
+		for _, op := range collectionOperations[name] {
+			tags := []string{name}
+			switch op {
+			case "list":
+				limits := collectionPageLimits(name)
+				params := []fiber.Map{
+					{"name": "page", "in": "query", "schema": fiber.Map{"type": "integer", "minimum": 1, "default": 1}},
+					{"name": "perPage", "in": "query", "schema": fiber.Map{"type": "integer", "minimum": 1, "maximum": limits.Max, "default": limits.Default}, "description": "larger values are capped at the maximum"},
+					{"name": "filter", "in": "query", "schema": fiber.Map{"type": "string"},
+						"description": "Expression over " + fields + `. Operators = != > >= < <= ~ (contains) !~ (not contains), joined with && and || and grouped with parentheses; values are quoted strings, numbers, true/false or null. Example: type = "inflow" && amount > 100`},
+					{"name": "sort", "in": "query", "schema": fiber.Map{"type": "string"},
+						"description": "Comma separated fields from " + fields + ", prefixed with - for descending. Example: -created_at,name"},
+				}
+				if name == "transactions" {
+					params = append(params, fiber.Map{"name": "expand", "in": "query", "schema": fiber.Map{"type": "string"},
+						"description": "contact, items and/or payments, comma separated"})
+				}
+				listOps["get"] = fiber.Map{"tags": tags, "summary": "List " + name, "parameters": params,
+					"responses": withErrors(fiber.Map{"200": jsonResponse("One page of records", schemaRef(schemaName+"List"))})}
+			case "create":
+				listOps["post"] = fiber.Map{"tags": tags, "summary": "Create a record", "requestBody": jsonBody(schemaRef(schemaName + "Input")),
+					"responses": withErrors(fiber.Map{"200": jsonResponse("Created", schemaRef("Created"))})}
+			case "get":
+				itemOps["get"] = fiber.Map{"tags": tags, "summary": "Get a record", "parameters": []fiber.Map{idParam},
+					"responses": withErrors(fiber.Map{"200": jsonResponse("The record", schemaRef(schemaName)), "404": jsonResponse("Not found", schemaRef("Error"))})}
+			case "patch":
+				itemOps["patch"] = fiber.Map{"tags": tags, "summary": "Update fields of a record", "parameters": []fiber.Map{idParam}, "requestBody": jsonBody(schemaRef(schemaName + "Input")),
+					"responses": withErrors(fiber.Map{"200": jsonResponse("Updated", schemaRef("Created")), "404": jsonResponse("Not found", schemaRef("Error"))})}
+			case "upload":
+				paths[base+"/{id}/files/{field}"] = fiber.Map{"post": fiber.Map{"tags": tags, "summary": "Attach an image to a record",
+					"parameters": []fiber.Map{idParam, {"name": "field", "in": "path", "required": true, "schema": fiber.Map{"type": "string"}}},
+					"requestBody": fiber.Map{"required": true, "content": fiber.Map{"multipart/form-data": fiber.Map{"schema": fiber.Map{"type": "object",
+						"properties": fiber.Map{"file": fiber.Map{"type": "string", "format": "binary"}}}}}},
+					"responses": withErrors(fiber.Map{"200": jsonResponse("Stored file", fiber.Map{"type": "object", "properties": fiber.Map{
+						"filename": fiber.Map{"type": "string"}, "url": fiber.Map{"type": "string"}}}), "404": jsonResponse("Not found", schemaRef("Error"))})}}
+			}
+		}
+		if len(listOps) > 0 {
+			paths[base] = listOps
+		}
+		if len(itemOps) > 0 {
+			paths[base+"/{id}"] = itemOps
+		}
+	}
+
+	return fiber.Map{
+		"openapi": "3.0.3",
+		"info": fiber.Map{
+			"title":       "BizCalc API",
+			"version":     "1.0",
+			"description": "Every request other than login and refresh needs an access token in the Authorization header. Records are always those of the caller's organization. Errors are returned as {\"error\": \"...\"}.",
+		},
+		"security": []fiber.Map{{"bearerAuth": []string{}}},
+		"paths":    paths,
+		"components": fiber.Map{
+			"securitySchemes": fiber.Map{"bearerAuth": fiber.Map{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"}},
+			"schemas":         schemas,
+		},
+	}
+}
+
+// collectionSchemaName turns inventory_items into InventoryItem.
+func collectionSchemaName(collection string) string {
+	name := strings.TrimSuffix(collection, "s")
+	var b strings.Builder
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAPISpecCoversCollections(t *testing.T) {
+	app := newApp()
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/openapi.json", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	var spec struct {
+		OpenAPI string                            `json:"openapi"`
+		Paths   map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI == "" {
+		t.Fatal("no openapi version")
+	}
+	for collection := range collectionFields {
+		if _, ok := collectionOperations[collection]; !ok {
+			t.Errorf("%s has no collectionOperations entry", collection)
+		}
+		if _, ok := spec.Paths["/api/collections/"+collection+"/records"]["get"]; !ok {
+			t.Errorf("%s list endpoint missing from the spec", collection)
+		}
+	}
+}
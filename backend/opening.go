@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Opening balances capture the state of the business at the cutover date
+// so reports computed from transactions start from the right figures.
+// Every entry is kept in opening_balances and also produces the baseline
+// record it stands for (an "opening" inventory movement or a transaction
+// with source = 'opening'). Re-submitting an entry replaces the previous one.
+
+func registerOpeningBalanceRoutes(app *fiber.App) {
+	r := app.Group("/api/opening-balances", requireAuth)
+	r.Get("/", handleListOpeningBalances)
+	r.Post("/stock", requireRole("admin", "manager"), handleOpeningStock)
+	r.Post("/contacts", requireRole("admin", "manager"), handleOpeningContacts)
+	r.Post("/cash", requireRole("admin", "manager"), handleOpeningCash)
+}
+
+func handleListOpeningBalances(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT id,kind,ref_id,quantity,unit_cost,amount,cutover_date,record_id,fiscal_year_id,created_at FROM opening_balances WHERE organization_id = ? ORDER BY kind, created_at`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	steps := fiber.Map{"stock": false, "contacts": false, "cash": false}
+	cutover := ""
+	for _, m := range items {
+		if m["fiscal_year_id"] != nil {
+			continue
+		}
+		// steps are named after the endpoints: the contact kind is "contacts"
+		step := toString(m["kind"])
+		if step == "contact" {
+			step = "contacts"
+		}
+		steps[step] = true
+		cutover = toString(m["cutover_date"])
+	}
+	return c.JSON(fiber.Map{"cutover_date": cutover, "steps": steps, "items": items})
+}
+
+type openingStockRequest struct {
+	CutoverDate string `json:"cutover_date"`
+	Items       []struct {
+		ItemID   string  `json:"item_id"`
+		SKU      string  `json:"sku"`
+		Quantity int     `json:"quantity"`
+		UnitCost float64 `json:"unit_cost"`
+	} `json:"items"`
+}
+
+func handleOpeningStock(c *fiber.Ctx) error {
+	var req openingStockRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	cutover, err := parseTime(req.CutoverDate)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "cutover_date is required"})
+	}
+	orgID := currentOrgID(c)
+	if locked, err := periodLocked(dbFor(c), orgID, cutover); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if locked {
+		return c.Status(409).JSON(fiber.Map{"error": "cutover_date falls in a closed fiscal year"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+
+	applied := []fiber.Map{}
+	for i, line := range req.Items {
+		itemID := line.ItemID
+		var current int
+		if itemID == "" {
+			err = tx.QueryRow(`SELECT id, quantity FROM inventory_items WHERE sku = ? AND organization_id = ?`, line.SKU, orgID).Scan(&itemID, &current)
+		} else {
+			err = tx.QueryRow(`SELECT quantity FROM inventory_items WHERE id = ? AND organization_id = ?`, itemID, orgID).Scan(&current)
+		}
+		if err == sql.ErrNoRows {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown item", "line": i})
+		}
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if line.Quantity < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "quantity cannot be negative", "line": i})
+		}
+		if err := clearOpening(tx, orgID, "stock", itemID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		// a replaced entry's movement is undone, so measure from what is left
+		if err := tx.QueryRow(`SELECT quantity FROM inventory_items WHERE id = ?`, itemID).Scan(&current); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		now := time.Now().Format(time.RFC3339)
+		movementID := genID()
+		if _, err := tx.Exec(`UPDATE inventory_items SET quantity = ?, cost_price = ?, updated_at = ? WHERE id = ?`, line.Quantity, line.UnitCost, now, itemID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		stockChanged(tx, itemID)
+		if _, err := tx.Exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,unit_cost,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,`+itemOrgSQL+`,?)`, movementID, itemID, line.Quantity-current, current, line.Quantity, "opening", "Opening stock", line.UnitCost, itemID, cutover.Format(time.RFC3339)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := tx.Exec(`INSERT INTO opening_balances (id,kind,ref_id,quantity,unit_cost,amount,cutover_date,record_id,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,?,?)`, genID(), "stock", itemID, line.Quantity, line.UnitCost, float64(line.Quantity)*line.UnitCost, cutover.Format("2006-01-02"), movementID, orgID, now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		applied = append(applied, fiber.Map{"item_id": itemID, "quantity": line.Quantity, "unit_cost": line.UnitCost})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": applied})
+}
+
+type openingContactsRequest struct {
+	CutoverDate string `json:"cutover_date"`
+	Balances    []struct {
+		ContactID string  `json:"contact_id"`
+		Amount    float64 `json:"amount"`
+	} `json:"balances"`
+}
+
+// handleOpeningContacts records what customers owe us and what we owe
+// suppliers. Each balance becomes an unpaid transaction dated at cutover:
+// inflow for customers (receivable), outflow for suppliers (payable).
+func handleOpeningContacts(c *fiber.Ctx) error {
+	var req openingContactsRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	cutover, err := parseTime(req.CutoverDate)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "cutover_date is required"})
+	}
+	orgID := currentOrgID(c)
+	if locked, err := periodLocked(dbFor(c), orgID, cutover); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if locked {
+		return c.Status(409).JSON(fiber.Map{"error": "cutover_date falls in a closed fiscal year"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+
+	applied := []fiber.Map{}
+	for i, b := range req.Balances {
+		var contactType string
+		if err := tx.QueryRow(`SELECT type FROM contacts WHERE id = ? AND organization_id = ?`, b.ContactID, orgID).Scan(&contactType); err != nil {
+			if err == sql.ErrNoRows {
+				return c.Status(400).JSON(fiber.Map{"error": "unknown contact", "line": i})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := clearOpening(tx, orgID, "contact", b.ContactID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		txType := "inflow"
+		if contactType == "supplier" {
+			txType = "outflow"
+		}
+		transactionID := genID()
+		if _, err := tx.Exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,source,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,?)`, transactionID, txType, moneyOf(b.Amount), money(0), moneyOf(b.Amount), b.ContactID, "opening", orgID, cutover.Format(time.RFC3339)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := tx.Exec(`INSERT INTO opening_balances (id,kind,ref_id,amount,cutover_date,record_id,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?)`, genID(), "contact", b.ContactID, b.Amount, cutover.Format("2006-01-02"), transactionID, orgID, time.Now().Format(time.RFC3339)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := refreshTransactionReadModel(tx, transactionID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		applied = append(applied, fiber.Map{"contact_id": b.ContactID, "type": txType, "amount": b.Amount, "transaction_id": transactionID})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": applied})
+}
+
+type openingCashRequest struct {
+	CutoverDate string  `json:"cutover_date"`
+	Amount      float64 `json:"amount"`
+}
+
+func handleOpeningCash(c *fiber.Ctx) error {
+	var req openingCashRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	cutover, err := parseTime(req.CutoverDate)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "cutover_date is required"})
+	}
+	orgID := currentOrgID(c)
+	if locked, err := periodLocked(dbFor(c), orgID, cutover); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	} else if locked {
+		return c.Status(409).JSON(fiber.Map{"error": "cutover_date falls in a closed fiscal year"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if err := clearOpening(tx, orgID, "cash", ""); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	id := genID()
+	if _, err := tx.Exec(`INSERT INTO opening_balances (id,kind,ref_id,amount,cutover_date,organization_id,created_at) VALUES (?,?,?,?,?,?,?)`, id, "cash", "", req.Amount, cutover.Format("2006-01-02"), orgID, time.Now().Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "amount": req.Amount})
+}
+
+// clearOpening removes a previously entered opening balance of orgID and
+// the baseline record it generated, taking an opening stock movement back
+// out of the item's quantity, so entries can be corrected by re-posting.
+// Balances carried forward by a fiscal year close are left alone.
+func clearOpening(tx *Tx, orgID, kind, refID string) error {
+	var recordID sql.NullString
+	err := tx.QueryRow(`SELECT record_id FROM opening_balances WHERE organization_id = ? AND kind = ? AND ref_id = ? AND fiscal_year_id IS NULL`, orgID, kind, refID).Scan(&recordID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case "stock":
+		_, err = tx.Exec(`UPDATE inventory_items SET quantity = quantity - COALESCE((SELECT quantity_change FROM inventory_transactions WHERE id = ?), 0) WHERE id = ?`, recordID.String, refID)
+		if err == nil {
+			_, err = tx.Exec(`DELETE FROM inventory_transactions WHERE id = ?`, recordID.String)
+		}
+	case "contact":
+		_, err = tx.Exec(`DELETE FROM transactions WHERE id = ? AND source = 'opening'`, recordID.String)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM opening_balances WHERE organization_id = ? AND kind = ? AND ref_id = ? AND fiscal_year_id IS NULL`, orgID, kind, refID)
+	return err
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Opening entries produce their baseline records, and posting an entry
+// again replaces the earlier one instead of adding to it.
+func TestOpeningBalances(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Karim','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',2,15,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	stock := func() (quantity int, cost float64, movements int) {
+		t.Helper()
+		if err := db.QueryRow(`SELECT quantity, cost_price FROM inventory_items WHERE id = 'i-1'`).Scan(&quantity, &cost); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.QueryRow(`SELECT COUNT(1) FROM inventory_transactions WHERE item_id = 'i-1' AND transaction_type = 'opening'`).Scan(&movements); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	if code, _ := call("cashier", "POST", "/api/opening-balances/cash", `{"cutover_date":"2024-01-01","amount":100}`); code != 403 {
+		t.Errorf("cashier: got %d, want 403", code)
+	}
+	for path, body := range map[string]string{
+		"/api/opening-balances/stock":    `{"items":[{"item_id":"i-1","quantity":5}]}`,
+		"/api/opening-balances/contacts": `{"cutover_date":"2024-01-01","balances":[{"contact_id":"nope","amount":10}]}`,
+	} {
+		if code, _ := call("manager", "POST", path, body); code != 400 {
+			t.Errorf("%s %s: got %d, want 400", path, body, code)
+		}
+	}
+	if code, _ := call("manager", "POST", "/api/opening-balances/stock", `{"cutover_date":"2024-01-01","items":[{"sku":"PEN","quantity":-1}]}`); code != 400 {
+		t.Errorf("negative stock: got %d, want 400", code)
+	}
+
+	if code, out := call("manager", "POST", "/api/opening-balances/stock", `{"cutover_date":"2024-01-01","items":[{"sku":"PEN","quantity":20,"unit_cost":4}]}`); code != 200 {
+		t.Fatalf("opening stock: %d %v", code, out)
+	}
+	if q, cost, n := stock(); q != 20 || cost != 4 || n != 1 {
+		t.Errorf("after opening stock: quantity %d, cost %v, movements %d", q, cost, n)
+	}
+	// a corrected count replaces the first entry and its movement
+	if code, _ := call("manager", "POST", "/api/opening-balances/stock", `{"cutover_date":"2024-01-01","items":[{"item_id":"i-1","quantity":12,"unit_cost":5}]}`); code != 200 {
+		t.Fatal("corrected opening stock")
+	}
+	if q, cost, n := stock(); q != 12 || cost != 5 || n != 1 {
+		t.Errorf("after correction: quantity %d, cost %v, movements %d", q, cost, n)
+	}
+	var change int
+	if err := db.QueryRow(`SELECT quantity_change FROM inventory_transactions WHERE item_id = 'i-1' AND transaction_type = 'opening'`).Scan(&change); err != nil || change != 10 {
+		t.Errorf("opening movement = %d (%v), want 10", change, err)
+	}
+
+	body := `{"cutover_date":"2024-01-01","balances":[{"contact_id":"c-1","amount":60},{"contact_id":"s-1","amount":25}]}`
+	if code, out := call("manager", "POST", "/api/opening-balances/contacts", body); code != 200 {
+		t.Fatalf("opening contacts: %d %v", code, out)
+	}
+	if code, _ := call("manager", "POST", "/api/opening-balances/contacts", `{"cutover_date":"2024-01-01","balances":[{"contact_id":"c-1","amount":40}]}`); code != 200 {
+		t.Fatal("corrected opening contact")
+	}
+	dues := map[string]string{}
+	rows, err := db.Query(`SELECT contact_id, type, due_amount FROM transactions WHERE source = 'opening'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var contact, kind string
+		var due money
+		if err := rows.Scan(&contact, &kind, &due); err != nil {
+			t.Fatal(err)
+		}
+		dues[contact] += kind + " " + toString(due.float())
+	}
+	rows.Close()
+	if len(dues) != 2 || dues["c-1"] != "inflow 40" || dues["s-1"] != "outflow 25" {
+		t.Errorf("opening dues: %v", dues)
+	}
+
+	for _, amount := range []string{"100", "80"} {
+		if code, _ := call("manager", "POST", "/api/opening-balances/cash", `{"cutover_date":"2024-01-01","amount":`+amount+`}`); code != 200 {
+			t.Fatalf("opening cash %s: %d", amount, code)
+		}
+	}
+	code, list := call("cashier", "GET", "/api/opening-balances/", "")
+	if code != 200 || list["cutover_date"] != "2024-01-01" {
+		t.Fatalf("list: %d %v", code, list)
+	}
+	steps, _ := list["steps"].(map[string]interface{})
+	if steps["stock"] != true || steps["contacts"] != true || steps["cash"] != true {
+		t.Errorf("steps: %v", steps)
+	}
+	cash := 0.0
+	for _, it := range list["items"].([]interface{}) {
+		if m := it.(map[string]interface{}); m["kind"] == "cash" {
+			cash += m["amount"].(float64)
+		}
+	}
+	if cash != 80 {
+		t.Errorf("opening cash = %v, want 80", cash)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Restaurants, tailors and repair shops take a sale as an order and then
+// make it. A sale created with "order_status": "new" is on the orders
+// board until it is delivered; it moves new -> preparing -> ready ->
+// delivered, or back a step when it was moved too soon. The sale itself,
+// its stock and its payments are recorded when it is taken, as for any
+// other sale. Every move is kept with who made it. An order gets the next
+// token of the day when it is taken ("order_token"), and may be for a
+// table ("order_table"); the kitchen ticket is printed from it (see
+// kitchen_tickets.go).
+//
+//	GET  /api/orders/board             orders by status; delivered ones from today (?delivered_since=)
+//	POST /api/orders/:id/status        {"status": "preparing"}; {"status": "new", "table": "4"} puts a sale on the board
+//	GET  /api/orders/:id/history       the moves of one order
+
+var orderStatuses = []string{"new", "preparing", "ready", "delivered"}
+
+// orderTransitions lists where an order may move from each status; ""
+// is a sale not on the board yet.
+var orderTransitions = map[string][]string{
+	"":          {"new"},
+	"new":       {"preparing", "ready"},
+	"preparing": {"ready", "new"},
+	"ready":     {"delivered", "preparing"},
+	"delivered": {},
+}
+
+func registerOrderRoutes(app *fiber.App) {
+	r := app.Group("/api/orders", requireAuth)
+	r.Get("/board", handleOrderBoard)
+	r.Post("/:id/status", handleOrderStatus)
+	r.Get("/:id/history", handleOrderHistory)
+}
+
+// errOrderMoved is returned by moveOrder when the order is no longer in
+// the status it is moved from, e.g. another screen moved it first.
+var errOrderMoved = fiber.NewError(409, "the order was moved meanwhile; reload the board")
+
+// moveOrder moves transaction id from one order status to another inside
+// tx and keeps the move.
+func moveOrder(tx *Tx, id, from, to, userID string) (string, error) {
+	now := time.Now().Format(time.RFC3339)
+	res, err := tx.Exec(`UPDATE transactions SET order_status = ?, order_status_at = ? WHERE id = ? AND COALESCE(order_status, '') = ?`, to, now, id, from)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", errOrderMoved
+	}
+	_, err = tx.Exec(`INSERT INTO order_status_changes (id,transaction_id,from_status,to_status,changed_by,created_at) VALUES (?,?,NULLIF(?, ''),?,?,?)`,
+		genID(), id, from, to, userID, now)
+	return now, err
+}
+
+// openOrder puts sale id on the board as a new order with the next token
+// of the day, for table if it is eaten in, and returns the token.
+func openOrder(tx *Tx, orgID, id, table, userID string) (string, error) {
+	if _, err := moveOrder(tx, id, "", "new", userID); err != nil {
+		return "", err
+	}
+	token, err := nextSequenceNumber(tx, orgID, "order_token")
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`UPDATE transactions SET order_token = ?, order_table = NULLIF(?, '') WHERE id = ?`, token, table, id)
+	return token, err
+}
+
+const orderColumns = `id, receipt_number, contact_id, contact_name, amount, due_amount, order_status, order_status_at, COALESCE(order_token, '') AS order_token, COALESCE(order_table, '') AS order_table, kot_prints, items_summary, created_at`
+
+// orderRecords reads orders for the board, with their items and how long
+// they have been in their status.
+func orderRecords(rows *sql.Rows) ([]map[string]interface{}, error) {
+	orders, err := rowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+	moneyColumns(orders, "amount", "due_amount")
+	now := time.Now()
+	for _, o := range orders {
+		o["items"] = itemsSummary(o["items_summary"])
+		delete(o, "items_summary")
+		if since, err := parseTime(toString(o["order_status_at"])); err == nil {
+			o["minutes_in_status"] = int(now.Sub(since).Minutes())
+		}
+	}
+	return orders, nil
+}
+
+// handleOrderBoard returns the open orders grouped by status, oldest
+// first, and those delivered since the start of the day.
+func handleOrderBoard(c *fiber.Ctx) error {
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if v := c.Query("delivered_since"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid delivered_since"})
+		}
+		since = t
+	}
+	rows, err := dbFor(c).Query(`SELECT `+orderColumns+` FROM transactions
+		WHERE organization_id = ? AND voided_at IS NULL AND (order_status IN ('new', 'preparing', 'ready') OR (order_status = 'delivered' AND order_status_at >= ?))
+		ORDER BY created_at, id`, currentOrgID(c), since.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	orders, err := orderRecords(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	columns := []fiber.Map{}
+	for _, status := range orderStatuses {
+		in := []map[string]interface{}{}
+		for _, o := range orders {
+			if o["order_status"] == status {
+				in = append(in, o)
+			}
+		}
+		columns = append(columns, fiber.Map{"status": status, "count": len(in), "orders": in})
+	}
+	return c.JSON(fiber.Map{"columns": columns, "delivered_since": since.Format(time.RFC3339)})
+}
+
+// handleOrderStatus moves an order on the board, or puts a sale on it.
+func handleOrderStatus(c *fiber.Ctx) error {
+	var req struct {
+		Status string `json:"status"`
+		Table  string `json:"table"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var typ string
+	var status, voidedAt sql.NullString
+	err = tx.QueryRow(`SELECT type, order_status, voided_at FROM transactions WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&typ, &status, &voidedAt)
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	if typ != "inflow" {
+		return c.Status(400).JSON(fiber.Map{"error": "only sales are orders"})
+	}
+	if voidedAt.Valid {
+		return c.Status(409).JSON(fiber.Map{"error": "order is voided"})
+	}
+	allowed := orderTransitions[status.String]
+	ok := false
+	for _, s := range allowed {
+		ok = ok || s == req.Status
+	}
+	if !ok {
+		from := status.String
+		if from == "" {
+			from = "not an order"
+		}
+		return c.Status(409).JSON(fiber.Map{"error": "an order cannot move from " + from + " to " + req.Status, "status": status.String, "allowed": allowed})
+	}
+	var at, token string
+	if status.String == "" {
+		token, err = openOrder(tx, orgID, id, strings.TrimSpace(req.Table), currentUserID(c))
+		at = time.Now().Format(time.RFC3339)
+	} else {
+		at, err = moveOrder(tx, id, status.String, req.Status, currentUserID(c))
+	}
+	if fe, ok := err.(*fiber.Error); ok {
+		return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "transactions", "update", id)
+	out := fiber.Map{"id": id, "order_status": req.Status, "previous_status": status.String, "order_status_at": at}
+	if token != "" {
+		out["order_token"] = token
+	}
+	return c.JSON(out)
+}
+
+func handleOrderHistory(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "transactions", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err := dbFor(c).Query(`SELECT COALESCE(from_status, '') AS from_status, to_status, COALESCE(changed_by, '') AS changed_by, created_at
+		FROM order_status_changes WHERE transaction_id = ? ORDER BY `+db.dialect.insertionOrder(), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	changes, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "changes": changes})
+}
+
+// checkOrderStatus vets the order_status and order_table of a transaction
+// being created: a sale may be taken as a new order, for a table.
+func checkOrderStatus(body map[string]interface{}) string {
+	status := strings.TrimSpace(toString(body["order_status"]))
+	if status == "" {
+		if strings.TrimSpace(toString(body["order_table"])) != "" {
+			return "order_table is for orders"
+		}
+		return ""
+	}
+	if body["type"] != "inflow" {
+		return "order_status is for sales"
+	}
+	if status != "new" {
+		return `a sale is taken as an order with order_status "new"`
+	}
+	return ""
+}
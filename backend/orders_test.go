@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOrderBoard(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Table 4','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Supplier','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('biryani','Biryani','BIR',50,250,'org-1')`,
+		// delivered yesterday, so off today's board
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,order_status,order_status_at,organization_id,created_at) VALUES ('old','inflow',25000,25000,0,'c-1','delivered','2024-01-01T12:00:00Z','org-1','2024-01-01T11:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	column := func(status string) []interface{} {
+		t.Helper()
+		_, board := call("viewer", "GET", "/api/orders/board", "")
+		columns, _ := board["columns"].([]interface{})
+		for _, col := range columns {
+			if c := col.(map[string]interface{}); c["status"] == status {
+				orders, _ := c["orders"].([]interface{})
+				return orders
+			}
+		}
+		t.Fatalf("board: %v", board)
+		return nil
+	}
+
+	sale := `{"type":"inflow","amount":500,"paid_amount":500,"due_amount":0,"contact_id":"c-1","order_status":"new","items":[{"item_id":"biryani","quantity":2,"unit_price":250}]}`
+	if code, _ := call("cashier", "POST", "/api/collections/transactions/records", strings.Replace(sale, `"new"`, `"ready"`, 1)); code != 400 {
+		t.Errorf("taken as ready: got %d, want 400", code)
+	}
+	code, out := call("cashier", "POST", "/api/collections/transactions/records", sale)
+	if code != 200 || out["order_status"] != "new" {
+		t.Fatalf("order: %d %v", code, out)
+	}
+	id := toString(out["id"])
+	code, out = call("cashier", "POST", "/api/collections/transactions/records", strings.Replace(sale, `,"order_status":"new"`, "", 1))
+	if code != 200 {
+		t.Fatalf("plain sale: %d %v", code, out)
+	}
+	plain := toString(out["id"])
+
+	orders := column("new")
+	if len(orders) != 1 || orders[0].(map[string]interface{})["id"] != id || len(column("delivered")) != 0 {
+		t.Fatalf("new orders: %v", orders)
+	}
+	if o := orders[0].(map[string]interface{}); o["contact_name"] != "Table 4" || len(o["items"].([]interface{})) != 1 {
+		t.Errorf("order on the board: %v", o)
+	}
+
+	status := "/api/orders/" + id + "/status"
+	if code, out := call("cashier", "POST", status, `{"status":"delivered"}`); code != 409 || len(out["allowed"].([]interface{})) != 2 {
+		t.Errorf("new to delivered: %d %v", code, out)
+	}
+	if code, _ := call("viewer", "POST", status, `{"status":"preparing"}`); code != 403 {
+		t.Errorf("viewer moving an order: got %d, want 403", code)
+	}
+	for _, to := range []string{"preparing", "new", "preparing", "ready", "delivered"} {
+		if code, out := call("cashier", "POST", status, `{"status":"`+to+`"}`); code != 200 || out["order_status"] != to {
+			t.Fatalf("move to %s: %d %v", to, code, out)
+		}
+	}
+	if len(column("new")) != 0 || len(column("delivered")) != 1 {
+		t.Errorf("delivered order not in today's column")
+	}
+	if code, _ := call("cashier", "POST", status, `{"status":"ready"}`); code != 409 {
+		t.Errorf("move after delivery: got %d, want 409", code)
+	}
+	_, history := call("viewer", "GET", "/api/orders/"+id+"/history", "")
+	if changes, _ := history["changes"].([]interface{}); len(changes) != 6 || changes[0].(map[string]interface{})["to_status"] != "new" || changes[5].(map[string]interface{})["from_status"] != "ready" {
+		t.Errorf("history: %v", history)
+	}
+
+	// a sale taken as a plain sale can still be put on the board
+	if code, out := call("cashier", "POST", "/api/orders/"+plain+"/status", `{"status":"preparing"}`); code != 409 {
+		t.Errorf("plain sale to preparing: %d %v", code, out)
+	}
+	if code, _ := call("cashier", "POST", "/api/orders/"+plain+"/status", `{"status":"new"}`); code != 200 || len(column("new")) != 1 {
+		t.Errorf("plain sale onto the board: got %d", code)
+	}
+	if code, _ := call("manager", "POST", "/api/orders/nope/status", `{"status":"new"}`); code != 404 {
+		t.Errorf("unknown order: got %d, want 404", code)
+	}
+	if _, list := call("viewer", "GET", `/api/collections/transactions/records?filter=order_status="new"`, ""); list["totalItems"] != 1.0 {
+		t.Errorf("filter by order_status: %v", list)
+	}
+	if _, board := call("viewer", "GET", "/api/orders/board?delivered_since=2024-01-01", ""); len(board["columns"].([]interface{})[3].(map[string]interface{})["orders"].([]interface{})) != 2 {
+		t.Errorf("delivered since: %v", board)
+	}
+}
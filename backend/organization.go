@@ -0,0 +1,99 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The organization profile holds the business details printed on every
+// document (invoices, receipts, statements): name, address, contact
+// details, logo, tax registration numbers and invoice footer/terms.
+
+var organizationProfileFields = []string{"name", "address", "phone", "email", "tax_registration_no", "vat_registration_no", "invoice_footer", "invoice_terms"}
+
+func registerOrganizationRoutes(app *fiber.App) {
+	r := app.Group("/api/organization", requireAuth)
+	r.Get("/", handleGetOrganization)
+	r.Put("/", requireRole("admin", "manager"), handlePutOrganization)
+	r.Post("/logo", requireRole("admin", "manager"), handleUploadOrganizationLogo)
+}
+
+// organizationProfile loads the profile used when rendering documents.
+// Missing organizations yield an empty profile rather than an error so
+// documents still render.
+func organizationProfile(q *DB, orgID string) (map[string]interface{}, error) {
+	rows, err := q.Query(`SELECT id,name,address,phone,email,tax_registration_no,vat_registration_no,invoice_footer,invoice_terms,logo_filename,logo_url FROM organizations WHERE id = ?`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return map[string]interface{}{"id": orgID, "name": ""}, nil
+	}
+	return items[0], nil
+}
+
+func handleGetOrganization(c *fiber.Ctx) error {
+	profile, err := organizationProfile(dbFor(c), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(profile)
+}
+
+func handlePutOrganization(c *fiber.Ctx) error {
+	var body map[string]interface{}
+	if err := json.Unmarshal(c.Body(), &body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	for _, field := range organizationProfileFields {
+		if v, ok := body[field]; ok {
+			if _, err := dbFor(c).Exec("UPDATE organizations SET "+field+" = ? WHERE id = ?", v, orgID); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+	}
+	profile, err := organizationProfile(dbFor(c), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(profile)
+}
+
+func handleUploadOrganizationLogo(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "file required"})
+	}
+	var previous sql.NullString
+	if err := dbFor(c).QueryRow(`SELECT logo_filename FROM organizations WHERE id = ?`, orgID).Scan(&previous); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	uploadsDir := filepath.Join("uploads", "organizations", orgID)
+	if err := os.MkdirAll(uploadsDir, 0o755); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	filename := filepath.Base(file.Filename)
+	if err := saveUpload(dbFor(c), orgID, file, filepath.Join(uploadsDir, filename)); err != nil {
+		return uploadErrorResponse(c, err)
+	}
+	if previous.Valid && previous.String != "" && previous.String != filename {
+		_ = os.Remove(filepath.Join(uploadsDir, previous.String))
+	}
+	url := fmt.Sprintf("/api/files/organizations/%s/%s", orgID, filename)
+	if _, err := dbFor(c).Exec(`UPDATE organizations SET logo_filename = ?, logo_url = ? WHERE id = ?`, filename, url, orgID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"filename": filename, "url": url})
+}
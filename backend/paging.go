@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxPerPage caps how many records a single list request may return. It
+// is configurable with LIST_MAX_PER_PAGE and is also the page size used
+// when the client does not send perPage, so existing clients that expect
+// the whole list keep working for ordinary data sizes.
+func maxPerPage() int {
+	if v, err := strconv.Atoi(os.Getenv("LIST_MAX_PER_PAGE")); err == nil && v > 0 {
+		return v
+	}
+	return 500
+}
+
+// pageLimits are the page size used when a list request sends no perPage
+// and the most it may ask for.
+type pageLimits struct {
+	Default int `json:"default"`
+	Max     int `json:"max"`
+}
+
+// defaultPageLimits tighten the limits for collections that grow without
+// bound and cost the most per row (transactions may expand their items).
+// Other collections use maxPerPage for both.
+var defaultPageLimits = map[string]pageLimits{
+	"transactions":           {Default: 100, Max: 200},
+	"inventory_transactions": {Default: 100, Max: 200},
+}
+
+// collectionPageLimits returns the limits of a collection. LIST_PAGE_LIMITS
+// overrides them per collection as "collection=default/max,...", e.g.
+// "transactions=50/100,contacts=200/1000". No limit exceeds maxPerPage.
+func collectionPageLimits(collection string) pageLimits {
+	global := maxPerPage()
+	limits, ok := defaultPageLimits[collection]
+	if !ok {
+		limits = pageLimits{Default: global, Max: global}
+	}
+	for _, entry := range strings.Split(os.Getenv("LIST_PAGE_LIMITS"), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(entry), "=")
+		if !found || name != collection {
+			continue
+		}
+		var def, max int
+		if _, err := fmt.Sscanf(value, "%d/%d", &def, &max); err != nil || def < 1 || max < def {
+			log.Printf("LIST_PAGE_LIMITS: ignoring %q, want collection=default/max", entry)
+			continue
+		}
+		limits = pageLimits{Default: def, Max: max}
+	}
+	if limits.Max > global {
+		limits.Max = global
+	}
+	if limits.Default > limits.Max {
+		limits.Default = limits.Max
+	}
+	return limits
+}
+
+// listPaging reads the 1-based page and perPage query params of a list of
+// collection, holding perPage to the collection's cap.
+func listPaging(c *fiber.Ctx, collection string) (int, int, error) {
+	limits := collectionPageLimits(collection)
+	page, perPage := 1, limits.Default
+	if v := c.Query("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fiber.NewError(400, "page must be a positive integer")
+		}
+		page = n
+	}
+	if v := c.Query("perPage"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return 0, 0, fiber.NewError(400, "perPage must be a positive integer")
+		}
+		perPage = n
+		if perPage > limits.Max {
+			perPage = limits.Max
+		}
+	}
+	return page, perPage, nil
+}
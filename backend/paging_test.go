@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCollectionPageLimits(t *testing.T) {
+	t.Setenv("LIST_MAX_PER_PAGE", "300")
+	t.Setenv("LIST_PAGE_LIMITS", "contacts=20/50, transactions=bad, inventory_items=100/1000")
+	for collection, want := range map[string]pageLimits{
+		"contacts":               {20, 50},
+		"transactions":           {100, 200}, // malformed override ignored
+		"inventory_items":        {100, 300}, // held to LIST_MAX_PER_PAGE
+		"inventory_transactions": {100, 200},
+		"rentals":                {300, 300},
+	} {
+		if got := collectionPageLimits(collection); got != want {
+			t.Errorf("%s: %+v, want %+v", collection, got, want)
+		}
+	}
+}
+
+func TestListHonoursCollectionCap(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err := db.Exec(`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES (?,?,?,?,?)`, fmt.Sprint("c-", i), "C", "0", "customer", "org-1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("LIST_PAGE_LIMITS", "contacts=2/3")
+	app := newApp()
+	for query, want := range map[string]int{"": 2, "?perPage=1": 1, "?perPage=100": 3} {
+		req := httptest.NewRequest("GET", "/api/collections/contacts/records"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out struct {
+			Items      []interface{} `json:"items"`
+			PerPage    int           `json:"perPage"`
+			TotalPages int           `json:"totalPages"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		if len(out.Items) != want || out.PerPage != want {
+			t.Errorf("%q: %d items, perPage %d, want %d", query, len(out.Items), out.PerPage, want)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Customers abroad can pay what is due on a sale by card through a Stripe
+// Checkout link. The link asks for the whole due amount, in the sale's own
+// currency if it has one (exchange_rates.go) and the base currency
+// otherwise. Stripe reports the payment to the webhook, signed with
+// STRIPE_WEBHOOK_SECRET, and the due amount the link was made for is then
+// recorded against the sale as a card payment (payments.go) referencing
+// Stripe's payment intent. A link paid after the sale stopped owing as
+// much (or was voided) is unapplied, with the reason in error, for the
+// payment to be sorted out by hand; one Stripe lets lapse is expired.
+//
+//	POST /api/transactions/:id/payment-link    a new link for what is due
+//	GET  /api/transactions/:id/payment-links   the links, newest first
+//	POST /api/payment-links/stripe/webhook     Stripe's webhook; send it checkout.session.* events
+//
+// Configuration:
+//
+//	STRIPE_SECRET_KEY      the account's secret API key
+//	STRIPE_WEBHOOK_SECRET  the webhook endpoint's signing secret
+//	STRIPE_SUCCESS_URL     where the customer ends up after paying (defaults to APP_URL)
+
+// stripeAPI is where Stripe's REST API is reached.
+var stripeAPI = "https://api.stripe.com"
+
+// stripeSignatureMaxAge is how old a signed webhook may be before it is
+// refused as a replay.
+const stripeSignatureMaxAge = 5 * time.Minute
+
+func registerPaymentLinkRoutes(app *fiber.App) {
+	app.Post("/api/payment-links/stripe/webhook", handleStripeWebhook)
+	app.Post("/api/transactions/:id/payment-link", requireAuth, requireRole("admin", "manager", "cashier"), handleCreatePaymentLink)
+	app.Get("/api/transactions/:id/payment-links", requireAuth, handleListPaymentLinks)
+}
+
+const paymentLinkSQL = `SELECT id, transaction_id, session_id, url, amount, currency, currency_amount, status, COALESCE(payment_intent, '') AS payment_intent, COALESCE(error, '') AS error, COALESCE(created_by, '') AS created_by, created_at, COALESCE(completed_at, '') AS completed_at FROM payment_links`
+
+// stripeSession is a Checkout session as far as it is used here.
+type stripeSession struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	PaymentStatus string `json:"payment_status"`
+	PaymentIntent string `json:"payment_intent"`
+}
+
+// createStripeSession asks Stripe for a Checkout session charging amount
+// of currency for invoice.
+func createStripeSession(amount money, currency, invoice, transactionID, successURL string) (stripeSession, error) {
+	key := os.Getenv("STRIPE_SECRET_KEY")
+	if key == "" {
+		return stripeSession{}, fmt.Errorf("STRIPE_SECRET_KEY is not set")
+	}
+	form := url.Values{
+		"mode":                                          {"payment"},
+		"success_url":                                   {successURL},
+		"client_reference_id":                           {transactionID},
+		"line_items[0][quantity]":                       {"1"},
+		"line_items[0][price_data][currency]":           {strings.ToLower(currency)},
+		"line_items[0][price_data][unit_amount]":        {strconv.FormatInt(int64(amount), 10)},
+		"line_items[0][price_data][product_data][name]": {"Invoice " + invoice},
+		"metadata[transaction_id]":                      {transactionID},
+	}
+	req, err := http.NewRequest("POST", stripeAPI+"/v1/checkout/sessions", strings.NewReader(form.Encode()))
+	if err != nil {
+		return stripeSession{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(key, "")
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return stripeSession{}, err
+	}
+	defer resp.Body.Close()
+	var out struct {
+		stripeSession
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if out.Error.Message != "" {
+			return stripeSession{}, fmt.Errorf("stripe returned %s: %s", resp.Status, out.Error.Message)
+		}
+		return stripeSession{}, fmt.Errorf("stripe returned %s", resp.Status)
+	}
+	return out.stripeSession, nil
+}
+
+// stripeSigned checks the Stripe-Signature header of a webhook: the
+// HMAC-SHA256 of the timestamp, a dot and the payload under the secret,
+// among its v1 signatures.
+func stripeSigned(secret, header string, payload []byte) bool {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(ts, 0)).Abs() > stripeSignatureMaxAge {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	want := hex.EncodeToString(mac.Sum(nil))
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(want), []byte(sig)) {
+			return true
+		}
+	}
+	return false
+}
+
+func handleCreatePaymentLink(c *fiber.Ctx) error {
+	orgID, id := currentOrgID(c), c.Params("id")
+	var typ, currency string
+	var due money
+	var rate float64
+	var voidedAt, receiptNumber sql.NullString
+	err := dbFor(c).QueryRow(`SELECT type, due_amount, COALESCE(currency, ''), COALESCE(exchange_rate, 0), voided_at, receipt_number FROM transactions WHERE id = ? AND organization_id = ?`, id, orgID).
+		Scan(&typ, &due, &currency, &rate, &voidedAt, &receiptNumber)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if voidedAt.Valid {
+		return c.Status(409).JSON(fiber.Map{"error": "the transaction is void"})
+	}
+	if typ != "inflow" {
+		return c.Status(400).JSON(fiber.Map{"error": "only sales are paid by link"})
+	}
+	amount := due
+	if amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "nothing is due"})
+	}
+	// the customer is charged in the sale's currency at its rate
+	charge := amount
+	if currency != "" && rate > 0 {
+		charge = moneyOf(amount.float() / rate)
+	} else {
+		currency = baseCurrency()
+	}
+	invoice := (&invoiceData{id: id, ref: receiptNumber.String}).number()
+	successURL := os.Getenv("STRIPE_SUCCESS_URL")
+	if successURL == "" {
+		successURL = appURL(c)
+	}
+	session, err := createStripeSession(charge, currency, invoice, id, successURL)
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": err.Error()})
+	}
+	link := fiber.Map{"id": genID(), "transaction_id": id, "session_id": session.ID, "url": session.URL, "amount": amount, "currency": currency, "currency_amount": charge, "status": "pending", "created_by": currentUserID(c), "created_at": time.Now().Format(time.RFC3339)}
+	if _, err := dbFor(c).Exec(`INSERT INTO payment_links (id,organization_id,transaction_id,session_id,url,amount,currency,currency_amount,status,created_by,created_at) VALUES (?,?,?,?,?,?,?,?,?,?,?)`,
+		link["id"], orgID, id, session.ID, session.URL, amount.float(), currency, charge.float(), "pending", link["created_by"], link["created_at"]); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(link)
+}
+
+func handleListPaymentLinks(c *fiber.Ctx) error {
+	orgID, id := currentOrgID(c), c.Params("id")
+	if !orgOwns(dbFor(c), "transactions", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err := dbFor(c).Query(paymentLinkSQL+` WHERE organization_id = ? AND transaction_id = ? ORDER BY created_at DESC`, orgID, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	items, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	moneyColumns(items, "amount", "currency_amount")
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleStripeWebhook records the payment of a link once Stripe reports
+// its session paid, and only once however often Stripe repeats itself.
+// Stripe retries on errors, so events that are not about a link's
+// session, or that need nothing done, are answered 200.
+func handleStripeWebhook(c *fiber.Ctx) error {
+	secret := os.Getenv("STRIPE_WEBHOOK_SECRET")
+	if secret == "" || !stripeSigned(secret, c.Get("Stripe-Signature"), c.Body()) {
+		return c.Status(401).JSON(fiber.Map{"error": "invalid webhook signature"})
+	}
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object stripeSession `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(c.Body(), &event); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	session := event.Data.Object
+	q := dbFor(c)
+	var id, orgID, transactionID string
+	var amount money
+	err := q.QueryRow(`SELECT id, organization_id, transaction_id, amount FROM payment_links WHERE session_id = ? AND status = 'pending'`, session.ID).
+		Scan(&id, &orgID, &transactionID, &amount)
+	if err == sql.ErrNoRows {
+		return c.JSON(fiber.Map{"ok": true})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now().Format(time.RFC3339)
+	switch event.Type {
+	case "checkout.session.expired", "checkout.session.async_payment_failed":
+		status := "expired"
+		if event.Type == "checkout.session.async_payment_failed" {
+			status = "failed"
+		}
+		if _, err := q.Exec(`UPDATE payment_links SET status = ?, completed_at = ? WHERE id = ? AND status = 'pending'`, status, now, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"ok": true})
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+		if session.PaymentStatus != "paid" {
+			// completed, but the bank transfer behind it is still to come
+			return c.JSON(fiber.Map{"ok": true})
+		}
+	default:
+		return c.JSON(fiber.Map{"ok": true})
+	}
+
+	tx, err := q.Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`UPDATE payment_links SET status = 'completed', payment_intent = NULLIF(?, ''), completed_at = ? WHERE id = ? AND status = 'pending'`, session.PaymentIntent, now, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.JSON(fiber.Map{"ok": true})
+	}
+	if _, code, problem := takePayment(tx, orgID, transactionID, paymentLine{Method: "card", Amount: amount.float(), Reference: session.PaymentIntent}); code != 0 {
+		tx.Rollback()
+		if _, err := q.Exec(`UPDATE payment_links SET status = 'unapplied', payment_intent = NULLIF(?, ''), error = ?, completed_at = ? WHERE id = ? AND status = 'pending'`,
+			session.PaymentIntent, toString(problem["error"]), now, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"ok": true})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "transactions", "update", transactionID)
+	return c.JSON(fiber.Map{"ok": true})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStripePaymentLinks(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Acme GmbH','','customer','org-1')`,
+		// 1100 BDT due on a sale in euros at 110
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,receipt_number,currency,exchange_rate,organization_id,created_at) VALUES ('t-1','inflow',220000,110000,110000,'c-1','INV-7','EUR',110,'org-1','2024-01-01T00:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-2','inflow',5000,5000,0,'c-1','org-1','2024-01-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessions := 0
+	stripe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, _, _ := r.BasicAuth()
+		_ = r.ParseForm()
+		if key != "sk_test" || r.Form.Get("line_items[0][price_data][currency]") != "eur" || r.Form.Get("line_items[0][price_data][unit_amount]") != "1000" || r.Form.Get("client_reference_id") != "t-1" {
+			w.WriteHeader(400)
+			fmt.Fprintf(w, `{"error":{"message":"unexpected %v"}}`, r.Form)
+			return
+		}
+		sessions++
+		fmt.Fprintf(w, `{"id":"cs_%d","url":"https://checkout.example/cs_%d"}`, sessions, sessions)
+	}))
+	defer stripe.Close()
+	defer func(api string) { stripeAPI = api }(stripeAPI)
+	stripeAPI = stripe.URL
+	t.Setenv("STRIPE_SECRET_KEY", "sk_test")
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "whsec")
+
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	webhook := func(secret, event string) int {
+		t.Helper()
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(ts + "." + event))
+		req := httptest.NewRequest("POST", "/api/payment-links/stripe/webhook", strings.NewReader(event))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Stripe-Signature", "t="+ts+",v1="+hex.EncodeToString(mac.Sum(nil)))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	paid := func(session, intent string) string {
+		return `{"type":"checkout.session.completed","data":{"object":{"id":"` + session + `","payment_status":"paid","payment_intent":"` + intent + `"}}}`
+	}
+
+	if code, _ := call("cashier", "POST", "/api/transactions/t-2/payment-link", ""); code != 400 {
+		t.Errorf("link for a paid sale: got %d, want 400", code)
+	}
+	code, link := call("cashier", "POST", "/api/transactions/t-1/payment-link", "")
+	if code != 200 || link["url"] != "https://checkout.example/cs_1" || link["amount"] != 1100.0 || link["currency_amount"] != 10.0 || link["currency"] != "EUR" {
+		t.Fatalf("link: %d %v", code, link)
+	}
+	if code := webhook("wrong", paid("cs_1", "pi_1")); code != 401 {
+		t.Errorf("forged webhook: got %d, want 401", code)
+	}
+	if code := webhook("whsec", paid("cs_other", "pi_x")); code != 200 {
+		t.Errorf("someone else's session: got %d, want 200", code)
+	}
+	for i := 0; i < 2; i++ {
+		if code := webhook("whsec", paid("cs_1", "pi_1")); code != 200 {
+			t.Errorf("paid, delivery %d: got %d", i+1, code)
+		}
+	}
+	var due float64
+	var reference string
+	if err := db.QueryRow(`SELECT due_amount FROM transactions WHERE id = 't-1'`).Scan(&due); err != nil || due != 0 {
+		t.Errorf("due after paying the link: %v %v", err, due)
+	}
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(1), MAX(reference) FROM transaction_payments WHERE transaction_id = 't-1' AND method = 'card'`).Scan(&n, &reference); err != nil || n != 1 || reference != "pi_1" {
+		t.Errorf("card payments: %v %d %q", err, n, reference)
+	}
+
+	// a second link for the same amount, paid once the sale was settled
+	if _, err := db.Exec(`UPDATE transactions SET due_amount = 110000 WHERE id = 't-1'`); err != nil {
+		t.Fatal(err)
+	}
+	call("cashier", "POST", "/api/transactions/t-1/payment-link", "")
+	call("cashier", "POST", "/api/transactions/t-1/payment-link", "")
+	if _, err := db.Exec(`UPDATE transactions SET due_amount = 0 WHERE id = 't-1'`); err != nil {
+		t.Fatal(err)
+	}
+	webhook("whsec", paid("cs_2", "pi_2"))
+	webhook("whsec", `{"type":"checkout.session.expired","data":{"object":{"id":"cs_3"}}}`)
+	_, list := call("manager", "GET", "/api/transactions/t-1/payment-links", "")
+	items, _ := list["items"].([]interface{})
+	statuses := map[string]string{}
+	for _, it := range items {
+		m := it.(map[string]interface{})
+		statuses[toString(m["session_id"])] = toString(m["status"])
+	}
+	if len(items) != 3 || statuses["cs_1"] != "completed" || statuses["cs_2"] != "unapplied" || statuses["cs_3"] != "expired" {
+		t.Errorf("links: %v", statuses)
+	}
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A transaction's paid amount may be split across several payment methods
+// (e.g. part cash, part bKash). Each slice is a row in transaction_payments;
+// transactions.payment_method holds the single method used, or "split".
+// Transactions recorded before split payments existed have no rows there
+// and are reported from payment_method / paid_amount instead.
+//
+// What is still due can be paid later, in parts:
+//
+//	POST /api/transactions/:id/payments   {"amount", "method", "date", "reference", "account_id"}
+//
+// adds a payment line and moves the amount from due_amount to paid_amount.
+// The transactions list returns each transaction's payment lines with
+// expand=payments.
+
+var defaultPaymentMethods = []struct{ code, name string }{
+	{"cash", "Cash"},
+	{"card", "Card"},
+	{"bkash", "bKash"},
+	{"nagad", "Nagad"},
+	{"bank_transfer", "Bank transfer"},
+	{"cheque", "Cheque"},
+}
+
+// seedPaymentMethods adds any missing default payment methods to an
+// organization.
+func seedPaymentMethods(q *DB, orgID string) error {
+	now := time.Now().Format(time.RFC3339)
+	for _, m := range defaultPaymentMethods {
+		if _, err := q.Exec(`INSERT INTO payment_methods (id,code,name,active,organization_id,created_at) VALUES (?,?,?,1,?,?) ON CONFLICT DO NOTHING`, genID(), m.code, m.name, orgID, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedAllPaymentMethods runs seedPaymentMethods for every organization.
+func seedAllPaymentMethods() {
+	for _, id := range organizationIDs() {
+		if err := seedPaymentMethods(db, id); err != nil {
+			log.Printf("seed payment methods for %s: %v", id, err)
+		}
+	}
+}
+
+func registerPaymentRoutes(app *fiber.App) {
+	app.Get("/api/reports/daily-closing", requireAuth, cachedReport, handleDailyClosing)
+	app.Post("/api/transactions/:id/payments", requireAuth, requireRole("admin", "manager", "cashier"), handleRecordPayment)
+}
+
+type paymentLine struct {
+	Method    string  `json:"method"`
+	Amount    float64 `json:"amount"`
+	Reference string  `json:"reference"`
+	AccountID string  `json:"account_id"`
+	// paidAt dates a payment made before it is recorded; empty is now
+	paidAt string
+	// contactPaymentID is the lump sum the line is part of; see
+	// contact_payments.go
+	contactPaymentID string
+	// creditNoteID is the credit note a line paid from credit drew on;
+	// see credit_notes.go
+	creditNoteID string
+}
+
+// parsePayments reads the optional payments array of a transaction body
+// and checks every method is a known, active payment method of orgID and
+// every account belongs to it.
+func parsePayments(q *DB, orgID string, body map[string]interface{}) ([]paymentLine, error) {
+	raw, ok := body["payments"]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	b, _ := json.Marshal(raw)
+	var lines []paymentLine
+	if err := json.Unmarshal(b, &lines); err != nil {
+		return nil, fmt.Errorf("payments must be a list of {method, amount}")
+	}
+	for _, l := range lines {
+		if l.Amount <= 0 {
+			return nil, fmt.Errorf("payment amounts must be positive")
+		}
+		var active int
+		if err := q.QueryRow(`SELECT active FROM payment_methods WHERE organization_id = ? AND code = ?`, orgID, l.Method).Scan(&active); err != nil || active == 0 {
+			return nil, fmt.Errorf("unknown payment method %q", l.Method)
+		}
+		if l.AccountID != "" && !orgOwns(q, "cash_accounts", l.AccountID, orgID) {
+			return nil, fmt.Errorf("unknown account %q", l.AccountID)
+		}
+	}
+	return lines, nil
+}
+
+// applyPayments reconciles the payments array with paid_amount, due_amount
+// and payment_method on a transaction body before it is inserted.
+func applyPayments(body map[string]interface{}, lines []paymentLine) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	var total money
+	for _, l := range lines {
+		total += moneyOf(l.Amount)
+	}
+	if paid, ok := body["paid_amount"].(float64); ok && moneyOf(paid) != total {
+		return fmt.Errorf("payments add up to %s but paid_amount is %.2f", total, paid)
+	}
+	body["paid_amount"] = total.float()
+	if _, ok := body["due_amount"]; !ok {
+		if amount, ok := body["amount"].(float64); ok {
+			body["due_amount"] = (moneyOf(amount) - total).float()
+		}
+	}
+	if len(lines) == 1 {
+		body["payment_method"] = lines[0].Method
+	} else {
+		body["payment_method"] = "split"
+	}
+	return nil
+}
+
+// recordPayments stores the payment lines of a transaction inside tx and
+// moves the money in (sales) or out (purchases) of the account each line
+// hits.
+func recordPayments(tx *Tx, orgID, transactionID, txType string, lines []paymentLine) error {
+	now := time.Now().Format(time.RFC3339)
+	for _, l := range lines {
+		accountID, err := accountForPayment(tx, orgID, l)
+		if err != nil {
+			return err
+		}
+		paidAt := now
+		if l.paidAt != "" {
+			paidAt = l.paidAt
+		}
+		if _, err := tx.PreparedExec(`INSERT INTO transaction_payments (id,transaction_id,method,amount,reference,account_id,contact_payment_id,credit_note_id,created_at) VALUES (?,?,?,?,?,?,NULLIF(?, ''),NULLIF(?, ''),?)`,
+			genID(), transactionID, l.Method, l.Amount, l.Reference, accountID, l.contactPaymentID, l.creditNoteID, paidAt); err != nil {
+			return err
+		}
+		if accountID == "" {
+			continue
+		}
+		amount := l.Amount
+		if txType == "outflow" {
+			amount = -amount
+		}
+		if err := recordMovement(tx, accountID, amount, "payment", "transaction", transactionID, l.Method); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// paymentDate reads the date a payment was made for paymentLine.paidAt:
+// "" for today.
+func paymentDate(date string) (string, error) {
+	if date == "" {
+		return "", nil
+	}
+	paidAt, err := parseTime(date)
+	if err != nil {
+		return "", fmt.Errorf("date must be a date (YYYY-MM-DD)")
+	}
+	if paidAt.After(time.Now()) {
+		return "", fmt.Errorf("date cannot be in the future")
+	}
+	if paidAt.Format("2006-01-02") == time.Now().Format("2006-01-02") {
+		return "", nil
+	}
+	return paidAt.Format(time.RFC3339), nil
+}
+
+// handleRecordPayment records a payment against what is due on a sale or
+// purchase. It cannot be more than is due; payments to suppliers are for
+// managers.
+func handleRecordPayment(c *fiber.Ctx) error {
+	var req struct {
+		Amount    float64 `json:"amount"`
+		Method    string  `json:"method"`
+		Date      string  `json:"date"`
+		Reference string  `json:"reference"`
+		AccountID string  `json:"account_id"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID, id := currentOrgID(c), c.Params("id")
+	amount := moneyOf(req.Amount)
+	if amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "amount must be positive"})
+	}
+	if req.Method == "" {
+		req.Method = "cash"
+	}
+	line := paymentLine{Method: req.Method, Amount: amount.float(), Reference: req.Reference, AccountID: req.AccountID}
+	var err error
+	if line.paidAt, err = paymentDate(req.Date); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := parsePayments(dbFor(c), orgID, map[string]interface{}{"payments": []paymentLine{line}}); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	typ, status, problem := takePayment(tx, orgID, id, line)
+	if status != 0 {
+		return c.Status(status).JSON(problem)
+	}
+	if typ == "outflow" && currentRole(c) == "cashier" {
+		return c.Status(403).JSON(fiber.Map{"error": "payments to suppliers are for managers"})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "transactions", "update", id)
+	t, err := Transactions(dbFor(c)).Get(c.UserContext(), orgID, id)
+	if err != nil {
+		return recordError(c, err)
+	}
+	return c.JSON(t)
+}
+
+// takePayment records line against orgID's transaction id inside tx and
+// takes it off what is due, returning the transaction's type. When the
+// payment cannot be taken it returns the status and body to answer with.
+func takePayment(tx *Tx, orgID, id string, line paymentLine) (string, int, fiber.Map) {
+	var typ string
+	var paid, due money
+	var voidedAt sql.NullString
+	err := tx.QueryRow(`SELECT type, paid_amount, due_amount, voided_at FROM transactions WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&typ, &paid, &due, &voidedAt)
+	if err == sql.ErrNoRows {
+		return "", 404, fiber.Map{"error": "not found"}
+	}
+	if err != nil {
+		return "", 500, fiber.Map{"error": err.Error()}
+	}
+	if voidedAt.Valid {
+		return "", 409, fiber.Map{"error": "transaction is voided"}
+	}
+	amount := moneyOf(line.Amount)
+	if amount > due {
+		return "", 400, fiber.Map{"error": "payment is more than is due", "due": due}
+	}
+	if err := backfillLegacyPayment(tx, id); err != nil {
+		return "", 500, fiber.Map{"error": err.Error()}
+	}
+	if err := recordPayments(tx, orgID, id, typ, []paymentLine{line}); err != nil {
+		return "", 500, fiber.Map{"error": err.Error()}
+	}
+	// due_amount is matched so a payment recorded meanwhile is not lost
+	res, err := tx.Exec(`UPDATE transactions SET paid_amount = ?, due_amount = ?,
+		payment_method = CASE WHEN COALESCE(payment_method, '') IN ('', ?) THEN ? ELSE 'split' END WHERE id = ? AND due_amount = ?`,
+		paid+amount, due-amount, line.Method, line.Method, id, due)
+	if err != nil {
+		return "", 500, fiber.Map{"error": err.Error()}
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return "", 409, fiber.Map{"error": "the transaction changed meanwhile; try again"}
+	}
+	return typ, 0, nil
+}
+
+// attachTransactionPayments adds the payment lines of each transaction
+// record, read for the whole page at once. A transaction paid before
+// split payments existed gets one line for what was paid.
+func attachTransactionPayments(q *DB, items []map[string]interface{}) error {
+	if len(items) == 0 {
+		return nil
+	}
+	var marks []string
+	var args []interface{}
+	for _, m := range items {
+		marks = append(marks, "?")
+		args = append(args, toString(m["id"]))
+	}
+	rows, err := q.Query(`SELECT transaction_id, id, method, amount, COALESCE(reference, ''), COALESCE(account_id, ''), COALESCE(created_at, '')
+		FROM transaction_payments WHERE transaction_id IN (`+strings.Join(marks, ",")+`) ORDER BY created_at`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	byTransaction := map[string][]TransactionPayment{}
+	for rows.Next() {
+		var transactionID string
+		var p TransactionPayment
+		if err := rows.Scan(&transactionID, &p.ID, &p.Method, &p.Amount, &p.Reference, &p.AccountID, &p.CreatedAt); err != nil {
+			return err
+		}
+		byTransaction[transactionID] = append(byTransaction[transactionID], p)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, m := range items {
+		payments, ok := byTransaction[toString(m["id"])]
+		if !ok {
+			payments = []TransactionPayment{}
+			if paid, _ := m["paid_amount"].(money); paid > 0 {
+				method := toString(m["payment_method"])
+				if method == "" {
+					method = "unspecified"
+				}
+				payments = append(payments, TransactionPayment{Method: method, Amount: paid, CreatedAt: toString(m["created_at"])})
+			}
+		}
+		m["payments"] = payments
+	}
+	return nil
+}
+
+// paymentLinesSQL yields one row per payment slice (transaction_id, type,
+// method, amount, created_at, source, organization_id), including legacy
+// single-method payments, in major units like transaction_payments. A
+// slice is dated when it was paid, which for a supplier payment is later
+// than the purchase. Voided transactions are left out.
+const paymentLinesSQL = `SELECT p.transaction_id, t.type, p.method, p.amount, COALESCE(p.created_at, t.created_at) AS created_at, t.source, t.organization_id
+	FROM transaction_payments p JOIN transactions t ON t.id = p.transaction_id
+	WHERE t.voided_at IS NULL
+	UNION ALL
+	SELECT t.id, t.type, COALESCE(NULLIF(t.payment_method, ''), 'unspecified'), t.paid_amount / 100.0, t.created_at, t.source, t.organization_id
+	FROM transactions t
+	WHERE t.paid_amount > 0 AND t.voided_at IS NULL AND NOT EXISTS (SELECT 1 FROM transaction_payments p WHERE p.transaction_id = t.id)`
+
+// handleDailyClosing summarises one day's sales and purchases with totals
+// per payment method, for reconciling the till at closing time. Refunds
+// are totalled per method apart: refunded went back to customers,
+// refunds_received came back from suppliers.
+func handleDailyClosing(c *fiber.Ctx) error {
+	day := time.Now()
+	if v := c.Query("date"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid date"})
+		}
+		day = t
+	}
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	to := from.AddDate(0, 0, 1)
+	fromS, toS := from.Format(time.RFC3339), to.Format(time.RFC3339)
+	orgID := currentOrgID(c)
+
+	totals := fiber.Map{}
+	for _, typ := range []string{"inflow", "outflow"} {
+		var count int
+		var amount, paid, due money
+		err := dbFor(c).QueryRow(`SELECT COUNT(1), COALESCE(SUM(amount),0), COALESCE(SUM(paid_amount),0), COALESCE(SUM(due_amount),0)
+			FROM transactions WHERE organization_id = ? AND type = ? AND created_at >= ? AND created_at < ? AND COALESCE(source, '') <> 'opening' AND voided_at IS NULL`,
+			orgID, typ, fromS, toS).Scan(&count, &amount, &paid, &due)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		totals[typ] = fiber.Map{"count": count, "amount": amount, "paid": paid, "due": due}
+	}
+
+	rows, err := dbFor(c).Query(`SELECT l.type, l.method, COALESCE(pm.name, l.method), COUNT(1), SUM(l.amount)
+		FROM (`+paymentLinesSQL+`) l LEFT JOIN payment_methods pm ON pm.code = l.method AND pm.organization_id = l.organization_id
+		WHERE l.organization_id = ? AND l.created_at >= ? AND l.created_at < ? AND COALESCE(l.source, '') <> 'opening'
+		GROUP BY l.type, l.method, pm.name ORDER BY l.type, 5 DESC`, orgID, fromS, toS)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	received, paidOut := []fiber.Map{}, []fiber.Map{}
+	for rows.Next() {
+		var typ, method, name string
+		var count int
+		var amount float64
+		if err := rows.Scan(&typ, &method, &name, &count, &amount); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		line := fiber.Map{"method": method, "name": name, "count": count, "amount": amount}
+		if typ == "inflow" {
+			received = append(received, line)
+		} else {
+			paidOut = append(paidOut, line)
+		}
+	}
+	rows.Close()
+
+	rows, err = dbFor(c).Query(`SELECT r.type, r.method, COALESCE(pm.name, r.method), COUNT(1), SUM(r.amount)
+		FROM refunds r LEFT JOIN payment_methods pm ON pm.code = r.method AND pm.organization_id = r.organization_id
+		WHERE r.organization_id = ? AND r.created_at >= ? AND r.created_at < ?
+		GROUP BY r.type, r.method, pm.name ORDER BY r.type, 5 DESC`, orgID, fromS, toS)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	refunded, refundsReceived := []fiber.Map{}, []fiber.Map{}
+	for rows.Next() {
+		var typ, method, name string
+		var count int
+		var amount float64
+		if err := rows.Scan(&typ, &method, &name, &count, &amount); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		line := fiber.Map{"method": method, "name": name, "count": count, "amount": amount}
+		if typ == "inflow" {
+			refunded = append(refunded, line)
+		} else {
+			refundsReceived = append(refundsReceived, line)
+		}
+	}
+	return c.JSON(fiber.Map{
+		"date":             from.Format("2006-01-02"),
+		"sales":            totals["inflow"],
+		"purchases":        totals["outflow"],
+		"received":         received,
+		"paid_out":         paidOut,
+		"refunded":         refunded,
+		"refunds_received": refundsReceived,
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecordPayment(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Supplier','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,50,'org-1')`,
+		// a purchase from before payment lines, paid 10 of 30
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,payment_method,organization_id,created_at) VALUES ('p-1','outflow',3000,1000,2000,'s-1','cash','org-1','2024-01-01T00:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	code, sale := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":100,"paid_amount":40,"due_amount":60,"payment_method":"cash","contact_id":"c-1",
+		"items":[{"item_id":"i-1","quantity":2,"unit_price":50}]}`)
+	if code != 200 {
+		t.Fatalf("sale: %d %v", code, sale)
+	}
+	path := "/api/transactions/" + toString(sale["id"]) + "/payments"
+
+	code, out := call("cashier", "POST", path, `{"amount":25,"method":"bkash","reference":"TX1"}`)
+	if code != 200 || out["paid_amount"] != 65.0 || out["due_amount"] != 35.0 || out["payment_method"] != "split" {
+		t.Fatalf("payment: %d %v", code, out)
+	}
+	if payments, _ := out["payments"].([]interface{}); len(payments) != 2 || payments[1].(map[string]interface{})["reference"] != "TX1" {
+		t.Errorf("payment history: %v", out["payments"])
+	}
+	if code, out := call("cashier", "POST", path, `{"amount":50}`); code != 400 || out["due"] != 35.0 {
+		t.Errorf("more than due: %d %v", code, out)
+	}
+	for _, body := range []string{`{"amount":0}`, `{"amount":5,"date":"2999-01-01"}`, `{"amount":5,"date":"soon"}`, `{"amount":5,"method":"barter"}`} {
+		if code, _ := call("cashier", "POST", path, body); code != 400 {
+			t.Errorf("%s: got %d, want 400", body, code)
+		}
+	}
+	code, out = call("cashier", "POST", path, `{"amount":35,"date":"2024-03-01"}`)
+	if code != 200 || out["due_amount"] != 0.0 || out["paid_amount"] != 100.0 {
+		t.Fatalf("paid off: %d %v", code, out)
+	}
+	if payments, _ := out["payments"].([]interface{}); len(payments) != 3 || !strings.HasPrefix(toString(payments[0].(map[string]interface{})["created_at"]), "2024-03-01") {
+		t.Errorf("back-dated payment: %v", out["payments"])
+	}
+	if code, _ := call("cashier", "POST", path, `{"amount":1}`); code != 400 {
+		t.Errorf("nothing due: got %d, want 400", code)
+	}
+
+	// purchases are paid by managers; what was paid before payment lines is kept
+	if code, _ := call("cashier", "POST", "/api/transactions/p-1/payments", `{"amount":5}`); code != 403 {
+		t.Errorf("cashier paying a supplier: got %d, want 403", code)
+	}
+	code, out = call("manager", "POST", "/api/transactions/p-1/payments", `{"amount":5}`)
+	if code != 200 || out["paid_amount"] != 15.0 || out["due_amount"] != 15.0 {
+		t.Fatalf("purchase payment: %d %v", code, out)
+	}
+	if payments, _ := out["payments"].([]interface{}); len(payments) != 2 || payments[0].(map[string]interface{})["amount"] != 10.0 {
+		t.Errorf("legacy payment kept: %v", out["payments"])
+	}
+	if code, _ := call("manager", "POST", "/api/transactions/nope/payments", `{"amount":5}`); code != 404 {
+		t.Errorf("unknown transaction: got %d, want 404", code)
+	}
+
+	_, list := call("cashier", "GET", "/api/collections/transactions/records?expand=payments&sort=created_at", "")
+	items, _ := list["items"].([]interface{})
+	if len(items) != 2 {
+		t.Fatalf("list: %v", list)
+	}
+	for i, want := range []int{2, 3} {
+		if payments, _ := items[i].(map[string]interface{})["payments"].([]interface{}); len(payments) != want {
+			t.Errorf("item %d payments: %v, want %d", i, payments, want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A price list (retail, wholesale, dealer, ...) holds its own unit price
+// for some items. A contact can be put on one, and a sale to that contact
+// is then priced by the server rather than by the till: each line takes
+// the item's price on the list (a variant not on it, its parent's), or the
+// item's own unit_price when the list does not name it, and the sale's
+// amount follows the lines. Prices on a list are per base unit, like
+// unit_price. The prices were set by a manager, so a list price below an
+// item's min_sale_price needs no further approval. Sales to contacts on no
+// list are priced as sent.
+//
+//	GET    /api/price-lists                 lists with their item counts
+//	POST   /api/price-lists                 {"name": ...}
+//	PATCH  /api/price-lists/:id             {"name": ...}
+//	DELETE /api/price-lists/:id             only when no contact is on it
+//	GET    /api/price-lists/:id/items
+//	PUT    /api/price-lists/:id/items       {"items": [{"item_id", "unit_price"}]}
+//	PUT    /api/contacts/:id/price-list     {"price_list_id": ... or null}
+
+type priceListItem struct {
+	ItemID    string  `json:"item_id"`
+	UnitPrice float64 `json:"unit_price"`
+}
+
+func registerPriceListRoutes(app *fiber.App) {
+	r := app.Group("/api/price-lists", requireAuth)
+	r.Get("/", handleListPriceLists)
+	r.Post("/", requireRole("admin", "manager"), handleCreatePriceList)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchPriceList)
+	r.Delete("/:id", requireRole("admin", "manager"), handleDeletePriceList)
+	r.Get("/:id/items", handleGetPriceListItems)
+	r.Put("/:id/items", requireRole("admin", "manager"), handlePutPriceListItems)
+	app.Put("/api/contacts/:id/price-list", requireAuth, requireRole("admin", "manager"), handleSetContactPriceList)
+}
+
+// contactPriceList returns the price list contactID buys on, or "".
+func contactPriceList(q queryer, contactID string) (string, error) {
+	var listID string
+	err := q.QueryRow(`SELECT COALESCE(price_list_id, '') FROM contacts WHERE id = ?`, contactID).Scan(&listID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return listID, err
+}
+
+// applyPriceList prices a sale line (in base units) from listID, setting
+// its unit_price, total_price and price_list_id, and returns how much the
+// line's total went up.
+func applyPriceList(q queryer, listID string, line map[string]interface{}) (float64, error) {
+	var price float64
+	err := q.QueryRow(`SELECT COALESCE(
+			(SELECT unit_price FROM price_list_items WHERE price_list_id = ? AND item_id = i.id),
+			(SELECT unit_price FROM price_list_items WHERE price_list_id = ? AND item_id = i.parent_id),
+			i.unit_price)
+		FROM inventory_items i WHERE i.id = ?`, listID, listID, toString(line["item_id"])).Scan(&price)
+	if err != nil {
+		return 0, err
+	}
+	quantity, _ := line["quantity"].(float64)
+	total := moneyOf(price) * money(quantity)
+	sent := moneyValue(line["total_price"])
+	line["unit_price"] = moneyOf(price).float()
+	line["total_price"] = total.float()
+	line["price_list_id"] = listID
+	return (total - sent).float(), nil
+}
+
+func handleListPriceLists(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT p.id, p.name, (SELECT COUNT(1) FROM price_list_items WHERE price_list_id = p.id) AS items,
+		(SELECT COUNT(1) FROM contacts WHERE price_list_id = p.id) AS contacts, p.created_at
+		FROM price_lists p WHERE p.organization_id = ? ORDER BY p.name`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	lists, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": lists})
+}
+
+// priceListName reads and checks the name in a price list body.
+func priceListName(c *fiber.Ctx) (string, error) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return "", fiber.NewError(400, "invalid json")
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return "", fiber.NewError(400, "name is required")
+	}
+	var taken int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM price_lists WHERE organization_id = ? AND name = ? AND id <> ?`, currentOrgID(c), name, c.Params("id")).Scan(&taken); err != nil {
+		return "", err
+	}
+	if taken > 0 {
+		return "", fiber.NewError(409, "there is already a price list named "+name)
+	}
+	return name, nil
+}
+
+func priceListError(c *fiber.Ctx, err error) error {
+	if fe, ok := err.(*fiber.Error); ok {
+		return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+func handleCreatePriceList(c *fiber.Ctx) error {
+	name, err := priceListName(c)
+	if err != nil {
+		return priceListError(c, err)
+	}
+	id, now := genID(), time.Now().Format(time.RFC3339)
+	if _, err := dbFor(c).Exec(`INSERT INTO price_lists (id,organization_id,name,created_at,updated_at) VALUES (?,?,?,?,?)`, id, currentOrgID(c), name, now, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "name": name})
+}
+
+func handlePatchPriceList(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "price_lists", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	name, err := priceListName(c)
+	if err != nil {
+		return priceListError(c, err)
+	}
+	if _, err := dbFor(c).Exec(`UPDATE price_lists SET name = ?, updated_at = ? WHERE id = ?`, name, time.Now().Format(time.RFC3339), id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "name": name})
+}
+
+func handleDeletePriceList(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "price_lists", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	var contacts int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM contacts WHERE price_list_id = ?`, id).Scan(&contacts); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if contacts > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "contacts are still on this price list"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	for _, q := range []string{`DELETE FROM price_list_items WHERE price_list_id = ?`, `DELETE FROM price_lists WHERE id = ?`} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"deleted": true})
+}
+
+func handleGetPriceListItems(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "price_lists", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err := dbFor(c).Query(`SELECT pi.item_id, COALESCE(i.name, '') AS name, COALESCE(i.sku, '') AS sku, i.unit_price AS standard_price, pi.unit_price
+		FROM price_list_items pi LEFT JOIN inventory_items i ON i.id = pi.item_id WHERE pi.price_list_id = ? ORDER BY i.name`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handlePutPriceListItems replaces the prices on a list.
+func handlePutPriceListItems(c *fiber.Ctx) error {
+	id, orgID := c.Params("id"), currentOrgID(c)
+	var req struct {
+		Items []priceListItem `json:"items"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if !orgOwns(dbFor(c), "price_lists", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	seen := map[string]bool{}
+	for _, it := range req.Items {
+		switch {
+		case !orgOwns(dbFor(c), "inventory_items", it.ItemID, orgID):
+			return c.Status(400).JSON(fiber.Map{"error": "unknown item " + it.ItemID})
+		case seen[it.ItemID]:
+			return c.Status(400).JSON(fiber.Map{"error": "item " + it.ItemID + " is listed twice"})
+		case it.UnitPrice < 0:
+			return c.Status(400).JSON(fiber.Map{"error": "prices must not be negative"})
+		}
+		seen[it.ItemID] = true
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM price_list_items WHERE price_list_id = ?`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, it := range req.Items {
+		if _, err := tx.Exec(`INSERT INTO price_list_items (id,organization_id,price_list_id,item_id,unit_price) VALUES (?,?,?,?,?)`, genID(), orgID, id, it.ItemID, it.UnitPrice); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if _, err := tx.Exec(`UPDATE price_lists SET updated_at = ? WHERE id = ?`, time.Now().Format(time.RFC3339), id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return handleGetPriceListItems(c)
+}
+
+// handleSetContactPriceList puts a contact on a price list, or takes it
+// off with null.
+func handleSetContactPriceList(c *fiber.Ctx) error {
+	id, orgID := c.Params("id"), currentOrgID(c)
+	var req struct {
+		PriceListID *string `json:"price_list_id"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if !orgOwns(dbFor(c), "contacts", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	listID := sql.NullString{}
+	if req.PriceListID != nil && *req.PriceListID != "" {
+		if !orgOwns(dbFor(c), "price_lists", *req.PriceListID, orgID) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown price list " + *req.PriceListID})
+		}
+		listID = sql.NullString{String: *req.PriceListID, Valid: true}
+	}
+	if _, err := dbFor(c).Exec(`UPDATE contacts SET price_list_id = ? WHERE id = ?`, listID, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "contacts", "update", id)
+	return c.JSON(fiber.Map{"id": id, "price_list_id": listID.String})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPriceLists(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-2','Rahim Traders','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,min_sale_price,organization_id) VALUES ('rice','Rice','RICE',100,80,60,75,'org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('soap','Soap','SOAP',100,40,30,'org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,parent_id,organization_id) VALUES ('soap-l','Soap, large','SOAP-L',100,60,45,'soap','org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("cashier", "POST", "/api/price-lists", `{"name":"Wholesale"}`); code != 403 {
+		t.Errorf("cashier creating a list: got %d, want 403", code)
+	}
+	code, list := call("manager", "POST", "/api/price-lists", `{"name":"Wholesale"}`)
+	if code != 200 {
+		t.Fatalf("create: %d %v", code, list)
+	}
+	listID := toString(list["id"])
+	if code, _ := call("manager", "POST", "/api/price-lists", `{"name":"Wholesale"}`); code != 409 {
+		t.Errorf("duplicate name: got %d, want 409", code)
+	}
+	if code, out := call("manager", "PUT", "/api/price-lists/"+listID+"/items", `{"items":[{"item_id":"rice","unit_price":70},{"item_id":"soap","unit_price":35}]}`); code != 200 || len(out["items"].([]interface{})) != 2 {
+		t.Fatalf("set prices: %d %v", code, out)
+	}
+	if code, _ := call("manager", "PUT", "/api/price-lists/"+listID+"/items", `{"items":[{"item_id":"nope","unit_price":1}]}`); code != 400 {
+		t.Errorf("unknown item: got %d, want 400", code)
+	}
+	if code, out := call("manager", "PUT", "/api/contacts/c-2/price-list", `{"price_list_id":"`+listID+`"}`); code != 200 {
+		t.Fatalf("assign: %d %v", code, out)
+	}
+
+	// the till sends retail prices; the server sells at wholesale, the
+	// variant at its parent's list price, and lowers what is due
+	code, out := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":420,"paid_amount":300,"due_amount":120,"contact_id":"c-2","items":[
+		{"item_id":"rice","quantity":3,"unit_price":80,"total_price":240},
+		{"item_id":"soap-l","quantity":3,"unit_price":60,"total_price":180}]}`)
+	if code != 200 || out["amount"] != 315.0 {
+		t.Fatalf("wholesale sale: %d %v", code, out)
+	}
+	var amount, paid, due money
+	if err := db.QueryRow(`SELECT amount, paid_amount, due_amount FROM transactions WHERE id = ?`, out["id"]).Scan(&amount, &paid, &due); err != nil {
+		t.Fatal(err)
+	}
+	if amount != moneyOf(315) || paid != moneyOf(300) || due != moneyOf(15) {
+		t.Errorf("amounts: %v paid %v due %v, want 315, 300, 15", amount, paid, due)
+	}
+	var price money
+	var priceList string
+	if err := db.QueryRow(`SELECT unit_price, COALESCE(price_list_id, '') FROM transaction_items WHERE transaction_id = ? AND item_id = 'soap-l'`, out["id"]).Scan(&price, &priceList); err != nil || price != moneyOf(35) || priceList != listID {
+		t.Errorf("variant line: %v %q %v", price, priceList, err)
+	}
+
+	// a walk-in customer is sold at the prices sent
+	code, out = call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":80,"paid_amount":80,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"rice","quantity":1,"unit_price":80,"total_price":80}]}`)
+	if code != 200 || out["amount"] != nil {
+		t.Errorf("walk-in sale: %d %v", code, out)
+	}
+
+	if code, _ := call("manager", "DELETE", "/api/price-lists/"+listID, ""); code != 409 {
+		t.Errorf("deleting a list in use: got %d, want 409", code)
+	}
+	call("manager", "PUT", "/api/contacts/c-2/price-list", `{"price_list_id":null}`)
+	if code, out := call("manager", "DELETE", "/api/price-lists/"+listID, ""); code != 200 {
+		t.Errorf("delete: %d %v", code, out)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func registerPricingRoutes(app *fiber.App) {
+	// repricing the catalogue is a manager decision, like editing items
+	app.Post("/api/inventory_items/bulk-price", requireAuth, requireRole("admin", "manager"), handleBulkPriceUpdate)
+	app.Get("/api/inventory_items/:id/price-history", requireAuth, handlePriceHistory)
+}
+
+type priceOperation struct {
+	Op    string  `json:"op"`
+	Value float64 `json:"value"`
+}
+
+type bulkPriceRequest struct {
+	Filter struct {
+		Category   string `json:"category"`
+		SupplierID string `json:"supplier_id"`
+	} `json:"filter"`
+	Operations []priceOperation `json:"operations"`
+	DryRun     bool             `json:"dry_run"`
+}
+
+// applyPriceOperations runs the operations in order on price. set_margin
+// needs a cost; an empty reason means the price could be computed.
+func applyPriceOperations(price, cost float64, ops []priceOperation) (float64, string) {
+	for _, op := range ops {
+		switch op.Op {
+		case "increase_percent":
+			price = price * (1 + op.Value/100)
+		case "decrease_percent":
+			price = price * (1 - op.Value/100)
+		case "set_margin":
+			if cost <= 0 {
+				return price, "item has no cost_price"
+			}
+			price = cost / (1 - op.Value/100)
+		case "round_ending":
+			// charm pricing: round up to the next price ending in value, e.g. .99
+			price = math.Ceil(price-op.Value) + op.Value
+		}
+	}
+	return math.Round(price*100) / 100, ""
+}
+
+// handleBulkPriceUpdate reprices every item matching the filter. With
+// dry_run the computed prices are returned without being saved; otherwise
+// all changes and their price_history rows are written in one transaction.
+func handleBulkPriceUpdate(c *fiber.Ctx) error {
+	var req bulkPriceRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if len(req.Operations) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "at least one operation is required"})
+	}
+	for _, op := range req.Operations {
+		switch op.Op {
+		case "increase_percent", "decrease_percent", "round_ending":
+		case "set_margin":
+			if op.Value >= 100 || op.Value < 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "set_margin must be between 0 and 100"})
+			}
+		default:
+			return c.Status(400).JSON(fiber.Map{"error": "unknown operation " + op.Op})
+		}
+	}
+	if req.Filter.Category == "" && req.Filter.SupplierID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "filter by category or supplier_id is required"})
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, COALESCE(name, 'Unnamed Item'), unit_price, cost_price FROM inventory_items WHERE organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	if req.Filter.Category != "" {
+		query += " AND category = ?"
+		args = append(args, req.Filter.Category)
+	}
+	if req.Filter.SupplierID != "" {
+		query += " AND supplier_id = ?"
+		args = append(args, req.Filter.SupplierID)
+	}
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	type change struct {
+		id, name           string
+		oldPrice, newPrice float64
+		skipped            string
+	}
+	var changes []change
+	for rows.Next() {
+		var ch change
+		var cost float64
+		if err := rows.Scan(&ch.id, &ch.name, &ch.oldPrice, &cost); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		ch.newPrice, ch.skipped = applyPriceOperations(ch.oldPrice, cost, req.Operations)
+		changes = append(changes, ch)
+	}
+	rows.Close()
+
+	now := time.Now().Format(time.RFC3339)
+	items := []fiber.Map{}
+	updated := 0
+	for _, ch := range changes {
+		entry := fiber.Map{"item_id": ch.id, "name": ch.name, "old_price": ch.oldPrice, "new_price": ch.newPrice}
+		if ch.skipped != "" {
+			entry["new_price"] = ch.oldPrice
+			entry["skipped"] = ch.skipped
+			items = append(items, entry)
+			continue
+		}
+		items = append(items, entry)
+		if ch.newPrice == ch.oldPrice {
+			continue
+		}
+		updated++
+		if req.DryRun {
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE inventory_items SET unit_price = ?, updated_at = ? WHERE id = ?`, ch.newPrice, now, ch.id); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := tx.Exec(`INSERT INTO price_history (id,item_id,old_price,new_price,reason,created_at) VALUES (?,?,?,?,?,?)`, genID(), ch.id, ch.oldPrice, ch.newPrice, "bulk update", now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if !req.DryRun {
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	return c.JSON(fiber.Map{"dry_run": req.DryRun, "matched": len(changes), "updated": updated, "items": items})
+}
+
+func handlePriceHistory(c *fiber.Ctx) error {
+	if !orgOwns(dbFor(c), "inventory_items", c.Params("id"), currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err := dbFor(c).Query(`SELECT id,old_price,new_price,reason,created_at FROM price_history WHERE item_id = ? ORDER BY created_at DESC`, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return streamItems(c, rows, nil, nil)
+}
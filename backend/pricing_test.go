@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// testToken returns an access token for a user of org-1 with role.
+func testToken(t *testing.T, role string) string {
+	t.Helper()
+	if jwtSecret == nil {
+		jwtSecret = []byte("test-secret")
+	}
+	now := time.Now()
+	token, err := signToken(authClaims{Subject: "user-" + role, OrgID: "org-1", Role: role, Issued: now.Unix(), Expires: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestBulkPriceRequiresManager(t *testing.T) {
+	app := fiber.New()
+	registerPricingRoutes(app)
+	body := `{"operations":[{"op":"increase_percent","value":10}],"dry_run":true}`
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+	}{
+		{"no token", "", 401},
+		{"cashier", testToken(t, "cashier"), 403},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/inventory_items/bulk-price", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.status)
+		}
+	}
+}
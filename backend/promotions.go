@@ -0,0 +1,499 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Promotions are offers that run between two dates: a percentage off an
+// item or off every item of a category ("10% off tea this week"), or buy
+// X get Y free on an item ("buy 2 get 1"). They are worked out on the
+// server when a sale is recorded: each line takes the promotion giving it
+// the largest discount, the line's total_price drops by that discount and
+// the line keeps the discount and the promotion it came from. Lines are
+// priced at list price; the sale's amount is reduced by the discounts, and
+// with it what is due or, when nothing is, what was paid. With payment
+// lines the payments stand and what is due is worked out from them.
+//
+// A promotion on a product with variants applies to each variant, and a
+// variant without a category of its own takes its parent's.
+//
+// POST /api/promotions/evaluate prices lines the same way without selling
+// anything, so a till can show the discounts before taking payment.
+// GET /api/reports/promotions reports, per promotion, how often it was
+// used and what it did to the margin.
+
+var promotionKinds = []string{"percent_off", "buy_get"}
+
+type promotion struct {
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Kind         string  `json:"kind"`
+	ItemID       string  `json:"item_id"`
+	Category     string  `json:"category"`
+	Percent      float64 `json:"percent"`
+	BuyQuantity  int     `json:"buy_quantity"`
+	FreeQuantity int     `json:"free_quantity"`
+	StartDate    string  `json:"start_date"`
+	EndDate      string  `json:"end_date"`
+	Active       bool    `json:"active"`
+	Status       string  `json:"status"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+type promotionRequest struct {
+	Name         *string  `json:"name"`
+	Kind         *string  `json:"kind"`
+	ItemID       *string  `json:"item_id"`
+	Category     *string  `json:"category"`
+	Percent      *float64 `json:"percent"`
+	BuyQuantity  *int     `json:"buy_quantity"`
+	FreeQuantity *int     `json:"free_quantity"`
+	StartDate    *string  `json:"start_date"`
+	EndDate      *string  `json:"end_date"`
+	Active       *bool    `json:"active"`
+}
+
+func registerPromotionRoutes(app *fiber.App) {
+	r := app.Group("/api/promotions", requireAuth)
+	r.Get("/", handleListPromotions)
+	r.Post("/evaluate", handleEvaluatePromotions)
+	r.Get("/:id", handleGetPromotion)
+	r.Post("/", requireRole("admin", "manager"), handleCreatePromotion)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchPromotion)
+	r.Delete("/:id", requireRole("admin", "manager"), handleDeletePromotion)
+	app.Get("/api/reports/promotions", requireAuth, cachedReport, handlePromotionReport)
+}
+
+const promotionColumns = `id, name, kind, COALESCE(item_id, ''), COALESCE(category, ''), percent, buy_quantity, free_quantity, start_date, end_date, active, COALESCE(created_at, '')`
+
+func scanPromotion(row interface{ Scan(...interface{}) error }) (promotion, error) {
+	var p promotion
+	err := row.Scan(&p.ID, &p.Name, &p.Kind, &p.ItemID, &p.Category, &p.Percent, &p.BuyQuantity, &p.FreeQuantity, &p.StartDate, &p.EndDate, &p.Active, &p.CreatedAt)
+	day := today()
+	switch {
+	case !p.Active:
+		p.Status = "inactive"
+	case day < p.StartDate:
+		p.Status = "scheduled"
+	case day > p.EndDate:
+		p.Status = "ended"
+	default:
+		p.Status = "running"
+	}
+	return p, err
+}
+
+// check reports what is wrong with p for orgID, or "".
+func (p promotion) check(q *DB, orgID string) string {
+	switch {
+	case strings.TrimSpace(p.Name) == "":
+		return "name is required"
+	case !validExpiryDate(p.StartDate) || p.StartDate == "" || !validExpiryDate(p.EndDate) || p.EndDate == "":
+		return "start_date and end_date must be dates (YYYY-MM-DD)"
+	case p.EndDate < p.StartDate:
+		return "end_date is before start_date"
+	case p.ItemID != "" && !orgOwns(q, "inventory_items", p.ItemID, orgID):
+		return "unknown item " + p.ItemID
+	}
+	switch p.Kind {
+	case "percent_off":
+		if (p.ItemID == "") == (p.Category == "") {
+			return "a percent_off promotion needs either item_id or category"
+		}
+		if p.Percent <= 0 || p.Percent > 100 {
+			return "percent must be above 0 and at most 100"
+		}
+	case "buy_get":
+		if p.ItemID == "" || p.Category != "" {
+			return "a buy_get promotion needs item_id"
+		}
+		if p.BuyQuantity < 1 || p.FreeQuantity < 1 {
+			return "buy_quantity and free_quantity must be at least 1"
+		}
+	default:
+		return "kind must be one of " + strings.Join(promotionKinds, ", ")
+	}
+	return ""
+}
+
+// discount is what p takes off quantity units at unitPrice.
+func (p promotion) discount(quantity int, unitPrice float64) float64 {
+	switch p.Kind {
+	case "percent_off":
+		return round2(float64(quantity) * unitPrice * p.Percent / 100)
+	case "buy_get":
+		free := quantity / (p.BuyQuantity + p.FreeQuantity) * p.FreeQuantity
+		return round2(float64(free) * unitPrice)
+	}
+	return 0
+}
+
+// runningPromotions returns the active promotions of orgID on day.
+func runningPromotions(q *DB, orgID, day string) ([]promotion, error) {
+	rows, err := q.Query(`SELECT `+promotionColumns+` FROM promotions WHERE organization_id = ? AND active = 1 AND start_date <= ? AND end_date >= ?`, orgID, day, day)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var running []promotion
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		running = append(running, p)
+	}
+	return running, rows.Err()
+}
+
+// applyPromotions takes the promotions of orgID running today off the
+// lines of a sale, which must already be in base units. Each discounted
+// line gets discount, promotion_id and promotion (its name) and a lower
+// total_price. It returns the total discount.
+func applyPromotions(q *DB, orgID string, lines []interface{}) (float64, error) {
+	running, err := runningPromotions(q, orgID, today())
+	if err != nil || len(running) == 0 {
+		return 0, err
+	}
+	total := 0.0
+	for _, line := range lines {
+		l, ok := line.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		itemID := toString(l["item_id"])
+		var parentID, category string
+		err := q.QueryRow(`SELECT COALESCE(i.parent_id, ''), COALESCE(NULLIF(i.category, ''), p.category, '') FROM inventory_items i LEFT JOIN inventory_items p ON p.id = i.parent_id WHERE i.id = ?`, itemID).Scan(&parentID, &category)
+		if err != nil {
+			return 0, err
+		}
+		quantity, _ := l["quantity"].(float64)
+		unitPrice, _ := l["unit_price"].(float64)
+		lineTotal, _ := l["total_price"].(float64)
+		var best *promotion
+		bestDiscount := 0.0
+		for i, p := range running {
+			applies := p.ItemID != "" && (p.ItemID == itemID || p.ItemID == parentID) ||
+				p.Category != "" && strings.EqualFold(p.Category, category)
+			if !applies {
+				continue
+			}
+			if d := p.discount(int(quantity), unitPrice); d > bestDiscount {
+				best, bestDiscount = &running[i], d
+			}
+		}
+		if best == nil {
+			continue
+		}
+		if bestDiscount > lineTotal {
+			bestDiscount = lineTotal
+		}
+		l["discount"] = bestDiscount
+		l["promotion_id"] = best.ID
+		l["promotion"] = best.Name
+		l["total_price"] = round2(lineTotal - bestDiscount)
+		total += bestDiscount
+	}
+	return round2(total), nil
+}
+
+// discountSale lowers the amounts of a sale by discount: what is due
+// first, then what was paid. With payment lines only what is due moves;
+// applyPayments works it out again from the lowered amount. A negative
+// discount (a sale repriced upwards) adds to what is due.
+func discountSale(body map[string]interface{}, discount float64) {
+	amount, ok := body["amount"].(float64)
+	if !ok || discount == 0 {
+		return
+	}
+	body["amount"] = round2(amount - discount)
+	if raw, split := body["payments"]; split && raw != nil {
+		delete(body, "due_amount")
+		return
+	}
+	due, ok := body["due_amount"].(float64)
+	if !ok {
+		return
+	}
+	if due >= discount {
+		body["due_amount"] = round2(due - discount)
+		return
+	}
+	body["due_amount"] = 0.0
+	if paid, ok := body["paid_amount"].(float64); ok {
+		body["paid_amount"] = round2(paid - (discount - due))
+	}
+}
+
+func handleListPromotions(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT `+promotionColumns+` FROM promotions WHERE organization_id = ? ORDER BY start_date DESC, name`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items := []promotion{}
+	status := c.Query("status")
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if status == "" || p.Status == status {
+			items = append(items, p)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func loadPromotion(q *DB, orgID, id string) (promotion, error) {
+	return scanPromotion(q.QueryRow(`SELECT `+promotionColumns+` FROM promotions WHERE id = ? AND organization_id = ?`, id, orgID))
+}
+
+func handleGetPromotion(c *fiber.Ctx) error {
+	p, err := loadPromotion(dbFor(c), currentOrgID(c), c.Params("id"))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(p)
+}
+
+// merge copies the fields set in req onto p.
+func (req promotionRequest) merge(p *promotion) {
+	if req.Name != nil {
+		p.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Kind != nil {
+		p.Kind = *req.Kind
+	}
+	if req.ItemID != nil {
+		p.ItemID = *req.ItemID
+	}
+	if req.Category != nil {
+		p.Category = strings.TrimSpace(*req.Category)
+	}
+	if req.Percent != nil {
+		p.Percent = *req.Percent
+	}
+	if req.BuyQuantity != nil {
+		p.BuyQuantity = *req.BuyQuantity
+	}
+	if req.FreeQuantity != nil {
+		p.FreeQuantity = *req.FreeQuantity
+	}
+	if req.StartDate != nil {
+		p.StartDate = *req.StartDate
+	}
+	if req.EndDate != nil {
+		p.EndDate = *req.EndDate
+	}
+	if req.Active != nil {
+		p.Active = *req.Active
+	}
+}
+
+func handleCreatePromotion(c *fiber.Ctx) error {
+	var req promotionRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	p := promotion{ID: genID(), Active: true}
+	req.merge(&p)
+	if msg := p.check(dbFor(c), orgID); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	now := time.Now().Format(time.RFC3339)
+	if _, err := dbFor(c).Exec(`INSERT INTO promotions (id,organization_id,name,kind,item_id,category,percent,buy_quantity,free_quantity,start_date,end_date,active,created_at,updated_at) VALUES (?,?,?,?,NULLIF(?, ''),NULLIF(?, ''),?,?,?,?,?,?,?,?)`,
+		p.ID, orgID, p.Name, p.Kind, p.ItemID, p.Category, p.Percent, p.BuyQuantity, p.FreeQuantity, p.StartDate, p.EndDate, p.Active, now, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": p.ID})
+}
+
+func handlePatchPromotion(c *fiber.Ctx) error {
+	var req promotionRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	p, err := loadPromotion(dbFor(c), orgID, c.Params("id"))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	req.merge(&p)
+	if msg := p.check(dbFor(c), orgID); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	if _, err := dbFor(c).Exec(`UPDATE promotions SET name = ?, kind = ?, item_id = NULLIF(?, ''), category = NULLIF(?, ''), percent = ?, buy_quantity = ?, free_quantity = ?, start_date = ?, end_date = ?, active = ?, updated_at = ? WHERE id = ?`,
+		p.Name, p.Kind, p.ItemID, p.Category, p.Percent, p.BuyQuantity, p.FreeQuantity, p.StartDate, p.EndDate, p.Active, time.Now().Format(time.RFC3339), p.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return handleGetPromotion(c)
+}
+
+// handleDeletePromotion deletes a promotion no sale used; one that was
+// used stays for the report and can be switched off with active.
+func handleDeletePromotion(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "promotions", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	var used int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM transaction_items WHERE promotion_id = ?`, id).Scan(&used); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if used > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "promotion was used on sales; set active to false instead"})
+	}
+	if _, err := dbFor(c).Exec(`DELETE FROM promotions WHERE id = ?`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"deleted": true})
+}
+
+// handleEvaluatePromotions prices {"items": [...]} sale lines with the
+// promotions running today.
+func handleEvaluatePromotions(c *fiber.Ctx) error {
+	var req struct {
+		Items []interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	for _, item := range req.Items {
+		l, ok := item.(map[string]interface{})
+		if !ok || !orgOwns(dbFor(c), "inventory_items", toString(l["item_id"]), orgID) {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown item " + toString(l["item_id"])})
+		}
+		if err := convertLineUnit(dbFor(c), l); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	discount, err := applyPromotions(dbFor(c), orgID, req.Items)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	total := 0.0
+	for _, item := range req.Items {
+		total += item.(map[string]interface{})["total_price"].(float64)
+	}
+	if req.Items == nil {
+		req.Items = []interface{}{}
+	}
+	return c.JSON(fiber.Map{"items": req.Items, "discount": discount, "total": round2(total)})
+}
+
+// handlePromotionReport reports, per promotion running in the period or
+// used on a sale in it: the sales and units it discounted, the discount
+// given, and the margin on those lines with and without it (at item
+// cost_price).
+func handlePromotionReport(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	orgID := currentOrgID(c)
+	q := dbFor(c)
+	type uptake struct {
+		promotion
+		Sales          int     `json:"sales"`
+		Units          int     `json:"units"`
+		Discount       float64 `json:"discount"`
+		Revenue        float64 `json:"revenue"`
+		Cost           float64 `json:"cost"`
+		Margin         float64 `json:"margin"`
+		MarginWithout  float64 `json:"margin_without_promotion"`
+		MarginPercent  float64 `json:"margin_percent"`
+		PercentWithout float64 `json:"margin_percent_without_promotion"`
+	}
+	byID := map[string]*uptake{}
+	rows, err := q.Query(`SELECT `+promotionColumns+` FROM promotions WHERE organization_id = ? AND start_date <= ? AND end_date >= ?`, orgID, to.Format("2006-01-02"), from.Format("2006-01-02"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		p, err := scanPromotion(rows)
+		if err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		byID[p.ID] = &uptake{promotion: p}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	rows, err = q.Query(`SELECT ti.promotion_id, COUNT(DISTINCT t.id), SUM(ti.quantity), SUM(ti.discount), SUM(ti.total_price), SUM(ti.quantity * COALESCE(i.cost_price, 0))
+		FROM transaction_items ti JOIN transactions t ON ti.transaction_id = t.id LEFT JOIN inventory_items i ON i.id = ti.item_id
+		WHERE t.organization_id = ? AND t.type = 'inflow' AND t.created_at >= ? AND t.created_at < ? AND t.voided_at IS NULL AND ti.promotion_id IS NOT NULL
+		GROUP BY ti.promotion_id`, orgID, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var u uptake
+		var revenue money
+		if err := rows.Scan(&id, &u.Sales, &u.Units, &u.Discount, &revenue, &u.Cost); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		u.Revenue = revenue.float()
+		if p, ok := byID[id]; ok {
+			u.promotion = p.promotion
+		} else if u.promotion, err = loadPromotion(q, orgID, id); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		u.ID = id
+		byID[id] = &u
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	report := []*uptake{}
+	var totals struct {
+		Discount float64 `json:"discount"`
+		Revenue  float64 `json:"revenue"`
+		Margin   float64 `json:"margin"`
+	}
+	for _, u := range byID {
+		u.Discount, u.Revenue, u.Cost = round2(u.Discount), round2(u.Revenue), round2(u.Cost)
+		u.Margin = round2(u.Revenue - u.Cost)
+		u.MarginWithout = round2(u.Revenue + u.Discount - u.Cost)
+		if u.Revenue > 0 {
+			u.MarginPercent = round2(u.Margin / u.Revenue * 100)
+		}
+		if gross := u.Revenue + u.Discount; gross > 0 {
+			u.PercentWithout = round2(u.MarginWithout / gross * 100)
+		}
+		totals.Discount += u.Discount
+		totals.Revenue += u.Revenue
+		totals.Margin += u.Margin
+		report = append(report, u)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Discount != report[j].Discount {
+			return report[i].Discount > report[j].Discount
+		}
+		return report[i].Name < report[j].Name
+	})
+	totals.Discount, totals.Revenue, totals.Margin = round2(totals.Discount), round2(totals.Revenue), round2(totals.Margin)
+	return c.JSON(fiber.Map{"from": from.Format(time.RFC3339), "to": to.Format(time.RFC3339), "promotions": report, "totals": totals})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromotions(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,category,organization_id) VALUES
+			('tea','Tea','TEA',50,100,60,'Beverages','org-1'),
+			('coffee','Coffee','COF',50,200,120,'beverages','org-1'),
+			('soap','Soap','SOAP',50,30,20,'Toiletries','org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	day := time.Now().Format("2006-01-02")
+	week := time.Now().AddDate(0, 0, 6).Format("2006-01-02")
+
+	if code, _ := call("cashier", "POST", "/api/promotions/", `{"name":"x","kind":"percent_off","category":"Beverages","percent":10,"start_date":"`+day+`","end_date":"`+week+`"}`); code != 403 {
+		t.Errorf("cashier creating a promotion: got %d, want 403", code)
+	}
+	for _, body := range []string{
+		`{"name":"x","kind":"percent_off","percent":10,"start_date":"` + day + `","end_date":"` + week + `"}`,
+		`{"name":"x","kind":"percent_off","category":"Beverages","percent":120,"start_date":"` + day + `","end_date":"` + week + `"}`,
+		`{"name":"x","kind":"buy_get","item_id":"soap","buy_quantity":2,"start_date":"` + day + `","end_date":"` + week + `"}`,
+		`{"name":"x","kind":"percent_off","category":"Beverages","percent":10,"start_date":"` + week + `","end_date":"` + day + `"}`,
+		`{"name":"x","kind":"bogof","item_id":"soap","start_date":"` + day + `","end_date":"` + week + `"}`,
+	} {
+		if code, _ := call("manager", "POST", "/api/promotions/", body); code != 400 {
+			t.Errorf("%s: got %d, want 400", body, code)
+		}
+	}
+	_, beverages := call("manager", "POST", "/api/promotions/", `{"name":"Beverage week","kind":"percent_off","category":"Beverages","percent":10,"start_date":"`+day+`","end_date":"`+week+`"}`)
+	_, soap := call("manager", "POST", "/api/promotions/", `{"name":"Soap 2+1","kind":"buy_get","item_id":"soap","buy_quantity":2,"free_quantity":1,"start_date":"`+day+`","end_date":"`+week+`"}`)
+	if _, err := db.Exec(`INSERT INTO promotions (id,organization_id,name,kind,item_id,percent,start_date,end_date,active) VALUES ('old','org-1','Last month','percent_off','tea',50,'2000-01-01','2000-01-31',1)`); err != nil {
+		t.Fatal(err)
+	}
+	if _, list := call("cashier", "GET", "/api/promotions/?status=running", ""); len(list["items"].([]interface{})) != 2 {
+		t.Errorf("running promotions: %v", list)
+	}
+
+	lines := `[{"item_id":"tea","quantity":2,"unit_price":100},{"item_id":"coffee","quantity":1,"unit_price":200},{"item_id":"soap","quantity":7,"unit_price":30}]`
+	code, quote := call("cashier", "POST", "/api/promotions/evaluate", `{"items":`+lines+`}`)
+	// 10% of 200 and of 200 for the drinks, 2 of 7 soaps free
+	if code != 200 || quote["discount"] != 100.0 || quote["total"] != 510.0 {
+		t.Fatalf("evaluate: %d %v", code, quote)
+	}
+
+	code, out := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":610,"paid_amount":600,"due_amount":10,"contact_id":"c-1","items":`+lines+`}`)
+	if code != 200 || out["discount"] != 100.0 {
+		t.Fatalf("sale: %d %v", code, out)
+	}
+	var amount, paid, due money
+	if err := db.QueryRow(`SELECT amount, paid_amount, due_amount FROM transactions WHERE id = ?`, out["id"]).Scan(&amount, &paid, &due); err != nil {
+		t.Fatal(err)
+	}
+	if amount != moneyOf(510) || due != 0 || paid != moneyOf(510) {
+		t.Errorf("sale amounts %v paid %v due %v, want 510 510 0", amount, paid, due)
+	}
+	var soapTotal money
+	var soapDiscount float64
+	var promo string
+	if err := db.QueryRow(`SELECT total_price, discount, promotion_id FROM transaction_items WHERE transaction_id = ? AND item_id = 'soap'`, out["id"]).Scan(&soapTotal, &soapDiscount, &promo); err != nil {
+		t.Fatal(err)
+	}
+	if soapTotal != moneyOf(150) || soapDiscount != 60 || promo != soap["id"] {
+		t.Errorf("soap line %v %v %v", soapTotal, soapDiscount, promo)
+	}
+
+	if code, _ := call("manager", "DELETE", "/api/promotions/"+toString(soap["id"]), ""); code != 409 {
+		t.Errorf("deleting a used promotion: got %d, want 409", code)
+	}
+	if code, out := call("manager", "PATCH", "/api/promotions/"+toString(beverages["id"]), `{"active":false}`); code != 200 || out["status"] != "inactive" {
+		t.Errorf("switch off: %d %v", code, out)
+	}
+	if _, quote := call("cashier", "POST", "/api/promotions/evaluate", `{"items":[{"item_id":"tea","quantity":1,"unit_price":100}]}`); quote["discount"] != 0.0 {
+		t.Errorf("inactive promotion applied: %v", quote)
+	}
+
+	_, report := call("viewer", "GET", "/api/reports/promotions?to="+week, "")
+	rows := report["promotions"].([]interface{})
+	if len(rows) != 2 {
+		t.Fatalf("report: %v", report)
+	}
+	first := rows[1].(map[string]interface{}) // by discount: soap 60, drinks 40
+	// beverages: revenue 360, cost 2*60 + 120 = 240; without it 400 - 240
+	if first["name"] != "Beverage week" || first["sales"] != 1.0 || first["units"] != 3.0 || first["margin"] != 120.0 || first["margin_without_promotion"] != 160.0 {
+		t.Errorf("beverage uptake: %v", first)
+	}
+	if totals := report["totals"].(map[string]interface{}); totals["discount"] != 100.0 {
+		t.Errorf("totals: %v", totals)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A supplier invoice is imported in one reviewed step: the same request is
+// sent first without confirm to get a preview (matched items, items that
+// will be created, totals), then with confirm=true to create the purchase
+// transaction, new items and stock increments atomically.
+//
+// Lines come either from a CSV upload (multipart field "file", header row
+// with sku,name,quantity,unit_cost and optional unit_price,category,
+// lot_number,expiry_date) or as JSON lines, e.g. from an OCR step on an
+// invoice photo. Each line's units go into a batch of their own.
+
+type purchaseLine struct {
+	SKU        string  `json:"sku"`
+	Name       string  `json:"name"`
+	Quantity   int     `json:"quantity"`
+	UnitCost   float64 `json:"unit_cost"`
+	UnitPrice  float64 `json:"unit_price"`
+	Category   string  `json:"category"`
+	LotNumber  string  `json:"lot_number"`
+	ExpiryDate string  `json:"expiry_date"`
+}
+
+type purchaseImportRequest struct {
+	SupplierID string         `json:"supplier_id"`
+	PaidAmount float64        `json:"paid_amount"`
+	Confirm    bool           `json:"confirm"`
+	Lines      []purchaseLine `json:"lines"`
+
+	// source is recorded on the purchase; "import" unless set
+	source string
+}
+
+func registerPurchaseImportRoutes(app *fiber.App) {
+	app.Post("/api/purchases/import", requireAuth, requireRole("admin", "manager"), handlePurchaseImport)
+}
+
+func handlePurchaseImport(c *fiber.Ctx) error {
+	var req purchaseImportRequest
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer f.Close()
+		lines, err := parsePurchaseCSV(f)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		req.Lines = lines
+		req.SupplierID = c.FormValue("supplier_id")
+		req.PaidAmount, _ = strconv.ParseFloat(c.FormValue("paid_amount", "0"), 64)
+		req.Confirm = c.FormValue("confirm") == "true"
+	} else if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.SupplierID == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "supplier_id is required"})
+	}
+	if len(req.Lines) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "no invoice lines"})
+	}
+	return importPurchase(c, req, nil)
+}
+
+// importPurchase previews or, confirmed, creates the purchase of req and
+// answers with the review. link, if given, runs inside the transaction
+// that creates the purchase, with its id.
+func importPurchase(c *fiber.Ctx, req purchaseImportRequest, link func(tx *Tx, transactionID string) error) error {
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+
+	orgID := currentOrgID(c)
+	var supplierName string
+	if err := tx.QueryRow(`SELECT name FROM contacts WHERE id = ? AND organization_id = ?`, req.SupplierID, orgID).Scan(&supplierName); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown supplier"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	review := []fiber.Map{}
+	lineErrors := []fiber.Map{}
+	itemIDs := make([]string, len(req.Lines))
+	createItem := make([]bool, len(req.Lines))
+	// an unknown SKU repeated on the invoice creates one item; later lines
+	// restock it
+	newSKUs := map[string]fiber.Map{}
+	var sum money
+	newItems := 0
+	for i, l := range req.Lines {
+		l.SKU = strings.TrimSpace(l.SKU)
+		if l.SKU == "" || l.Quantity <= 0 || l.UnitCost < 0 {
+			lineErrors = append(lineErrors, fiber.Map{"line": i + 1, "error": "sku, a positive quantity and unit_cost are required"})
+			continue
+		}
+		if !validExpiryDate(l.ExpiryDate) {
+			lineErrors = append(lineErrors, fiber.Map{"line": i + 1, "error": "expiry_date must be a date (YYYY-MM-DD)"})
+			continue
+		}
+		entry := fiber.Map{"line": i + 1, "sku": l.SKU, "quantity": l.Quantity, "unit_cost": l.UnitCost, "line_total": lineTotal(l.Quantity, l.UnitCost)}
+		var id, name, unit string
+		// the sku may also be another code of the item (item_codes.go),
+		// which may stand for one of its units
+		err := tx.QueryRow(`SELECT i.id, COALESCE(i.name, 'Unnamed Item'), CASE WHEN i.sku = ? THEN '' ELSE COALESCE(c.unit, '') END
+			FROM inventory_items i LEFT JOIN item_codes c ON c.item_id = i.id AND c.code = ?
+			WHERE i.organization_id = ? AND (i.sku = ? OR c.id IS NOT NULL)
+			ORDER BY CASE WHEN i.sku = ? THEN 0 ELSE 1 END LIMIT 1`, l.SKU, l.SKU, orgID, l.SKU, l.SKU).Scan(&id, &name, &unit)
+		if err == nil && unit != "" {
+			factor, err := unitFactor(tx, id, unit)
+			if err != nil {
+				lineErrors = append(lineErrors, fiber.Map{"line": i + 1, "error": err.Error()})
+				continue
+			}
+			l.Quantity, l.UnitCost = l.Quantity*factor, l.UnitCost/float64(factor)
+			req.Lines[i] = l
+			entry["unit"], entry["quantity"], entry["unit_cost"] = unit, l.Quantity, l.UnitCost
+		}
+		switch {
+		case err == sql.ErrNoRows && newSKUs[l.SKU] != nil:
+			created := newSKUs[l.SKU]
+			entry["action"] = "restock"
+			entry["item_id"] = created["item_id"]
+			entry["name"] = created["name"]
+			itemIDs[i] = created["item_id"].(string)
+		case err == sql.ErrNoRows:
+			if l.Name == "" {
+				lineErrors = append(lineErrors, fiber.Map{"line": i + 1, "error": "unknown sku needs a name to create the item"})
+				continue
+			}
+			entry["action"] = "create"
+			entry["name"] = l.Name
+			itemIDs[i] = genID()
+			createItem[i] = true
+			newSKUs[l.SKU] = fiber.Map{"item_id": itemIDs[i], "name": l.Name}
+			newItems++
+		case err != nil:
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		default:
+			entry["action"] = "restock"
+			entry["item_id"] = id
+			entry["name"] = name
+			itemIDs[i] = id
+		}
+		sum += lineTotal(l.Quantity, l.UnitCost)
+		review = append(review, entry)
+	}
+	total := sum.float()
+	summary := fiber.Map{"supplier_id": req.SupplierID, "supplier_name": supplierName, "lines": review, "errors": lineErrors, "new_items": newItems, "total": total, "paid_amount": req.PaidAmount, "due_amount": round2(total - req.PaidAmount)}
+	if !req.Confirm || len(lineErrors) > 0 {
+		summary["confirmed"] = false
+		return c.JSON(summary)
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	transactionID := genID()
+	if req.source == "" {
+		req.source = "import"
+	}
+	if _, err := tx.Exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,source,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,?)`, transactionID, "outflow", sum, moneyOf(req.PaidAmount), sum-moneyOf(req.PaidAmount), req.SupplierID, req.source, orgID, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for i, l := range req.Lines {
+		itemID := itemIDs[i]
+		if createItem[i] {
+			price := l.UnitPrice
+			if price == 0 {
+				price = l.UnitCost
+			}
+			if _, err := tx.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,reorder_level,category,supplier_id,organization_id,updated_at,created_at) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)`, itemID, l.Name, strings.TrimSpace(l.SKU), 0, price, l.UnitCost, 0, l.Category, req.SupplierID, orgID, now, now); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		} else if _, err := tx.Exec(`UPDATE inventory_items SET cost_price = ? WHERE id = ?`, l.UnitCost, itemID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := tx.Exec(`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES (?,?,?,?,?,?)`, genID(), transactionID, itemID, l.Quantity, moneyOf(l.UnitCost), lineTotal(l.Quantity, l.UnitCost)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if status, err := receiveStock(tx, itemID, l.Quantity, l.UnitCost, "outflow", "Purchase import"); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, _, err := receiveBatch(tx, orgID, itemID, transactionID, l.Quantity, l.UnitCost, l.LotNumber, l.ExpiryDate); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := refreshTransactionReadModel(tx, transactionID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if link != nil {
+		if err := link(tx, transactionID); err != nil {
+			if fe, ok := err.(*fiber.Error); ok {
+				return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+			}
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	summary["confirmed"] = true
+	summary["transaction_id"] = transactionID
+	return c.JSON(summary)
+}
+
+// parsePurchaseCSV reads invoice lines, locating columns by header name so
+// the column order of the supplier's export does not matter.
+func parsePurchaseCSV(r io.Reader) ([]purchaseLine, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fiber.NewError(400, "csv header row is required")
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"sku", "quantity", "unit_cost"} {
+		if _, ok := col[required]; !ok {
+			return nil, fiber.NewError(400, "csv is missing column "+required)
+		}
+	}
+	get := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	var lines []purchaseLine
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		qty, _ := strconv.Atoi(get(rec, "quantity"))
+		cost, _ := strconv.ParseFloat(get(rec, "unit_cost"), 64)
+		price, _ := strconv.ParseFloat(get(rec, "unit_price"), 64)
+		lines = append(lines, purchaseLine{SKU: get(rec, "sku"), Name: get(rec, "name"), Quantity: qty, UnitCost: cost, UnitPrice: price, Category: get(rec, "category"), LotNumber: get(rec, "lot_number"), ExpiryDate: get(rec, "expiry_date")})
+	}
+	return lines, nil
+}
@@ -0,0 +1,506 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A purchase order records what has been ordered from a supplier before
+// the goods arrive. It is drafted, sent, then received in one or more
+// deliveries; each delivery becomes a purchase transaction (type outflow,
+// source "purchase_order") that adds the delivered quantities to stock,
+// moving the order to partially_received and finally received. An order
+// can be cancelled until it is fully received; stock already received
+// stays. Only drafts can be edited.
+
+var purchaseOrderStatuses = []string{"draft", "sent", "partially_received", "received", "cancelled"}
+
+type purchaseOrderLine struct {
+	ItemID   string   `json:"item_id"`
+	Quantity int      `json:"quantity"`
+	UnitCost *float64 `json:"unit_cost"`
+	// Unit, when not the item's base unit, is what Quantity and UnitCost
+	// are in until checkPurchaseOrderLines converts them
+	Unit         string `json:"unit"`
+	unitQuantity int
+}
+
+type purchaseOrderRequest struct {
+	SupplierID *string             `json:"supplier_id"`
+	ExpectedAt *string             `json:"expected_at"`
+	Notes      *string             `json:"notes"`
+	Items      []purchaseOrderLine `json:"items"`
+}
+
+func registerPurchaseOrderRoutes(app *fiber.App) {
+	r := app.Group("/api/purchase-orders", requireAuth)
+	r.Get("/", handleListPurchaseOrders)
+	r.Get("/:id", handleGetPurchaseOrder)
+	r.Post("/", requireRole("admin", "manager"), handleCreatePurchaseOrder)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchPurchaseOrder)
+	r.Post("/:id/send", requireRole("admin", "manager"), handleSendPurchaseOrder)
+	r.Post("/:id/cancel", requireRole("admin", "manager"), handleCancelPurchaseOrder)
+	r.Post("/:id/receive", requireRole("admin", "manager"), handleReceivePurchaseOrder)
+}
+
+// checkPurchaseOrderLines validates lines against the items of orgID,
+// fills in a missing unit_cost from the item's cost price and converts
+// lines in other units to base units.
+func checkPurchaseOrderLines(q *DB, orgID string, lines []purchaseOrderLine) string {
+	if len(lines) == 0 {
+		return "at least one item required"
+	}
+	for i := range lines {
+		l := &lines[i]
+		if l.Quantity <= 0 {
+			return "item quantities must be positive"
+		}
+		var cost float64
+		if err := q.QueryRow(`SELECT cost_price FROM inventory_items WHERE id = ? AND organization_id = ?`, l.ItemID, orgID).Scan(&cost); err != nil {
+			return "unknown item " + l.ItemID
+		}
+		factor, err := unitFactor(q, l.ItemID, strings.TrimSpace(l.Unit))
+		if err != nil {
+			return err.Error()
+		}
+		if l.UnitCost == nil {
+			cost *= float64(factor)
+			l.UnitCost = &cost
+		} else if *l.UnitCost < 0 {
+			return "unit_cost cannot be negative"
+		}
+		if factor > 1 {
+			l.Unit, l.unitQuantity = strings.TrimSpace(l.Unit), l.Quantity
+			l.Quantity *= factor
+			perUnit := *l.UnitCost / float64(factor)
+			l.UnitCost = &perUnit
+		} else {
+			l.Unit = ""
+		}
+	}
+	return ""
+}
+
+// writePurchaseOrderLines replaces the lines of an order and returns its
+// new total.
+func writePurchaseOrderLines(tx *Tx, orderID string, lines []purchaseOrderLine) (float64, error) {
+	if _, err := tx.Exec(`DELETE FROM purchase_order_items WHERE purchase_order_id = ?`, orderID); err != nil {
+		return 0, err
+	}
+	var total money
+	for _, l := range lines {
+		if _, err := tx.Exec(`INSERT INTO purchase_order_items (id,purchase_order_id,item_id,quantity,unit_cost,received_quantity,unit,unit_quantity) VALUES (?,?,?,?,?,0,NULLIF(?, ''),NULLIF(?, 0))`,
+			genID(), orderID, l.ItemID, l.Quantity, *l.UnitCost, l.Unit, l.unitQuantity); err != nil {
+			return 0, err
+		}
+		total += lineTotal(l.Quantity, *l.UnitCost)
+	}
+	return total.float(), nil
+}
+
+func handleListPurchaseOrders(c *fiber.Ctx) error {
+	query := `SELECT p.id, p.number, p.supplier_id, s.name AS supplier_name, p.status, p.expected_at, p.total, p.created_at FROM purchase_orders p LEFT JOIN contacts s ON p.supplier_id = s.id WHERE p.organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	if s := c.Query("status"); s != "" {
+		valid := false
+		for _, v := range purchaseOrderStatuses {
+			valid = valid || v == s
+		}
+		if !valid {
+			return c.Status(400).JSON(fiber.Map{"error": "status must be one of " + strings.Join(purchaseOrderStatuses, ", ")})
+		}
+		query += ` AND p.status = ?`
+		args = append(args, s)
+	}
+	if s := c.Query("supplier_id"); s != "" {
+		query += ` AND p.supplier_id = ?`
+		args = append(args, s)
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY p.created_at DESC`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// loadPurchaseOrder reads an order of orgID with its lines and the
+// transactions that received it.
+func loadPurchaseOrder(q *DB, id, orgID string) (map[string]interface{}, error) {
+	rows, err := q.Query(`SELECT p.id, p.number, p.supplier_id, s.name AS supplier_name, p.status, p.expected_at, p.notes, p.total, p.created_by, p.created_at, p.updated_at FROM purchase_orders p LEFT JOIN contacts s ON p.supplier_id = s.id WHERE p.id = ? AND p.organization_id = ?`, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	order := orders[0]
+	for key, query := range map[string]string{
+		"items":    `SELECT l.id, l.item_id, i.name AS item_name, i.sku, l.quantity, l.unit_cost, l.quantity * l.unit_cost AS line_total, l.received_quantity, l.unit, l.unit_quantity FROM purchase_order_items l LEFT JOIN inventory_items i ON l.item_id = i.id WHERE l.purchase_order_id = ? ORDER BY i.name`,
+		"receipts": `SELECT id, amount, paid_amount, due_amount, created_at FROM transactions WHERE purchase_order_id = ? AND voided_at IS NULL ORDER BY created_at`,
+	} {
+		rows, err := q.Query(query, id)
+		if err != nil {
+			return nil, err
+		}
+		list, err := rowsToMaps(rows)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		moneyColumns(list, "amount", "paid_amount", "due_amount")
+		order[key] = list
+	}
+	return order, nil
+}
+
+func handleGetPurchaseOrder(c *fiber.Ctx) error {
+	order, err := loadPurchaseOrder(dbFor(c), c.Params("id"), currentOrgID(c))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "purchase order not found"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(order)
+}
+
+func handleCreatePurchaseOrder(c *fiber.Ctx) error {
+	var req purchaseOrderRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	if req.SupplierID == nil || !orgOwns(dbFor(c), "contacts", *req.SupplierID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "supplier_id must be a contact of this organization"})
+	}
+	if msg := checkPurchaseOrderLines(dbFor(c), orgID, req.Items); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	number, err := nextSequenceNumber(tx, orgID, "purchase_order")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	id := genID()
+	var expectedAt, notes interface{}
+	if req.ExpectedAt != nil {
+		expectedAt = *req.ExpectedAt
+	}
+	if req.Notes != nil {
+		notes = *req.Notes
+	}
+	now := time.Now().Format(time.RFC3339)
+	if _, err := tx.Exec(`INSERT INTO purchase_orders (id,organization_id,number,supplier_id,status,expected_at,notes,total,created_by,created_at,updated_at) VALUES (?,?,?,?,'draft',?,?,?,?,?,?)`,
+		id, orgID, number, *req.SupplierID, expectedAt, notes, 0, currentUserID(c), now, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	total, err := writePurchaseOrderLines(tx, id, req.Items)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`UPDATE purchase_orders SET total = ? WHERE id = ?`, total, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "number": number, "total": total})
+}
+
+// purchaseOrderStatus reads the status of an order of orgID, answering 404
+// itself when there is none.
+func purchaseOrderStatus(c *fiber.Ctx) (string, error) {
+	var status string
+	err := dbFor(c).QueryRow(`SELECT status FROM purchase_orders WHERE id = ? AND organization_id = ?`, c.Params("id"), currentOrgID(c)).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", c.Status(404).JSON(fiber.Map{"error": "purchase order not found"})
+	}
+	if err != nil {
+		return "", c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return status, nil
+}
+
+func handlePatchPurchaseOrder(c *fiber.Ctx) error {
+	var req purchaseOrderRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	status, err := purchaseOrderStatus(c)
+	if status == "" {
+		return err
+	}
+	if status != "draft" {
+		return c.Status(409).JSON(fiber.Map{"error": "only draft purchase orders can be edited"})
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	if req.SupplierID != nil && !orgOwns(dbFor(c), "contacts", *req.SupplierID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "supplier_id must be a contact of this organization"})
+	}
+	if req.Items != nil {
+		if msg := checkPurchaseOrderLines(dbFor(c), orgID, req.Items); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
+		}
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	updates := map[string]interface{}{"updated_at": time.Now().Format(time.RFC3339)}
+	if req.SupplierID != nil {
+		updates["supplier_id"] = *req.SupplierID
+	}
+	if req.ExpectedAt != nil {
+		updates["expected_at"] = *req.ExpectedAt
+	}
+	if req.Notes != nil {
+		updates["notes"] = *req.Notes
+	}
+	if req.Items != nil {
+		total, err := writePurchaseOrderLines(tx, id, req.Items)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		updates["total"] = total
+	}
+	for _, field := range []string{"supplier_id", "expected_at", "notes", "total", "updated_at"} {
+		if v, ok := updates[field]; ok {
+			if _, err := tx.Exec("UPDATE purchase_orders SET "+field+" = ? WHERE id = ?", v, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+// setPurchaseOrderStatus moves an order to status if it is in one of from.
+func setPurchaseOrderStatus(c *fiber.Ctx, status string, from ...string) error {
+	current, err := purchaseOrderStatus(c)
+	if current == "" {
+		return err
+	}
+	allowed := false
+	for _, s := range from {
+		allowed = allowed || s == current
+	}
+	if !allowed {
+		return c.Status(409).JSON(fiber.Map{"error": "purchase order is " + strings.ReplaceAll(current, "_", " ")})
+	}
+	if _, err := dbFor(c).Exec(`UPDATE purchase_orders SET status = ?, updated_at = ? WHERE id = ?`, status, time.Now().Format(time.RFC3339), c.Params("id")); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": c.Params("id"), "status": status})
+}
+
+func handleSendPurchaseOrder(c *fiber.Ctx) error {
+	return setPurchaseOrderStatus(c, "sent", "draft")
+}
+
+func handleCancelPurchaseOrder(c *fiber.Ctx) error {
+	return setPurchaseOrderStatus(c, "cancelled", "draft", "sent", "partially_received")
+}
+
+// handleReceivePurchaseOrder books a delivery against an order. Without
+// items everything still outstanding is received; otherwise items lists
+// {item_id, quantity, unit, lot_number, expiry_date} actually delivered,
+// each entry becoming a batch. payments (or paid_amount) record what was paid
+// the supplier on delivery.
+func handleReceivePurchaseOrder(c *fiber.Ctx) error {
+	var req struct {
+		Items []struct {
+			ItemID     string `json:"item_id"`
+			Quantity   int    `json:"quantity"`
+			Unit       string `json:"unit"`
+			LotNumber  string `json:"lot_number"`
+			ExpiryDate string `json:"expiry_date"`
+		} `json:"items"`
+		PaidAmount float64 `json:"paid_amount"`
+	}
+	body := map[string]interface{}{}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+		_ = json.Unmarshal(c.Body(), &body)
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	payments, err := parsePayments(dbFor(c), orgID, body)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var number, supplierID, status string
+	if err := tx.QueryRow(`SELECT number, supplier_id, status FROM purchase_orders WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&number, &supplierID, &status); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "purchase order not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status == "received" || status == "cancelled" {
+		return c.Status(409).JSON(fiber.Map{"error": "purchase order is " + status})
+	}
+
+	type lot struct {
+		quantity           int
+		number, expiryDate string
+	}
+	type orderLine struct {
+		id                           string
+		quantity, received, delivery int
+		unitCost                     float64
+		lots                         []lot
+	}
+	rows, err := tx.Query(`SELECT id, item_id, quantity, received_quantity, unit_cost FROM purchase_order_items WHERE purchase_order_id = ?`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	lines := map[string]*orderLine{}
+	var itemOrder []string
+	for rows.Next() {
+		var itemID string
+		l := &orderLine{}
+		if err := rows.Scan(&l.id, &itemID, &l.quantity, &l.received, &l.unitCost); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		lines[itemID] = l
+		itemOrder = append(itemOrder, itemID)
+	}
+	rows.Close()
+
+	if len(req.Items) == 0 {
+		for _, l := range lines {
+			l.delivery = l.quantity - l.received
+			l.lots = []lot{{quantity: l.delivery}}
+		}
+	}
+	for _, d := range req.Items {
+		l, ok := lines[d.ItemID]
+		if !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "item " + d.ItemID + " is not on this purchase order"})
+		}
+		if d.Quantity <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "received quantities must be positive"})
+		}
+		if !validExpiryDate(d.ExpiryDate) {
+			return c.Status(400).JSON(fiber.Map{"error": "expiry_date must be a date (YYYY-MM-DD)"})
+		}
+		factor, err := unitFactor(tx, d.ItemID, strings.TrimSpace(d.Unit))
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		d.Quantity *= factor
+		l.delivery += d.Quantity
+		l.lots = append(l.lots, lot{d.Quantity, d.LotNumber, d.ExpiryDate})
+		if l.received+l.delivery > l.quantity {
+			return c.Status(400).JSON(fiber.Map{"error": "more of item " + d.ItemID + " received than was ordered"})
+		}
+	}
+
+	var sum money
+	units := 0
+	for _, l := range lines {
+		sum += lineTotal(l.delivery, l.unitCost)
+		units += l.delivery
+	}
+	total := sum.float()
+	if units == 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "nothing left to receive"})
+	}
+	txBody := map[string]interface{}{"amount": total, "paid_amount": req.PaidAmount}
+	if _, ok := body["paid_amount"]; !ok {
+		delete(txBody, "paid_amount")
+	}
+	if err := applyPayments(txBody, payments); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	paid, _ := txBody["paid_amount"].(float64)
+	method, _ := txBody["payment_method"].(string)
+	if paid > total+0.005 {
+		return c.Status(400).JSON(fiber.Map{"error": "paid amount exceeds the delivery total"})
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	transactionID := genID()
+	if _, err := tx.Exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,payment_method,contact_id,source,purchase_order_id,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,?,?,?)`,
+		transactionID, "outflow", sum, moneyOf(paid), sum-moneyOf(paid), method, supplierID, "purchase_order", id, orgID, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := recordPayments(tx, orgID, transactionID, "outflow", payments); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	complete := true
+	for _, itemID := range itemOrder {
+		l := lines[itemID]
+		if l.delivery > 0 {
+			if _, err := tx.Exec(`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES (?,?,?,?,?,?)`,
+				genID(), transactionID, itemID, l.delivery, moneyOf(l.unitCost), lineTotal(l.delivery, l.unitCost)); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if status, err := receiveStock(tx, itemID, l.delivery, l.unitCost, "outflow", "Purchase order "+number); err != nil {
+				return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+			}
+			for _, b := range l.lots {
+				if b.quantity <= 0 {
+					continue
+				}
+				if _, _, err := receiveBatch(tx, orgID, itemID, transactionID, b.quantity, l.unitCost, b.number, b.expiryDate); err != nil {
+					return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+				}
+			}
+			if _, err := tx.Exec(`UPDATE inventory_items SET cost_price = ? WHERE id = ?`, l.unitCost, itemID); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if _, err := tx.Exec(`UPDATE purchase_order_items SET received_quantity = ? WHERE id = ?`, l.received+l.delivery, l.id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		complete = complete && l.received+l.delivery >= l.quantity
+	}
+	if err := refreshTransactionReadModel(tx, transactionID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	status = "partially_received"
+	if complete {
+		status = "received"
+	}
+	if _, err := tx.Exec(`UPDATE purchase_orders SET status = ?, updated_at = ? WHERE id = ?`, status, now, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "transactions", "create", transactionID)
+	for _, itemID := range itemOrder {
+		if lines[itemID].delivery > 0 {
+			publishRecord(orgID, "inventory_items", "update", itemID)
+		}
+	}
+	return c.JSON(fiber.Map{"id": id, "status": status, "transaction_id": transactionID, "amount": total})
+}
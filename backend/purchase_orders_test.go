@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPurchaseOrderReceivedInTwoDeliveries(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Wholesaler','017','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('i-1','Rice','RICE',5,80,60,'org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('i-2','Oil','OIL',0,200,150,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	quantity := func(item string) int {
+		var n int
+		if err := db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = ?`, item).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if code, _ := call("cashier", "POST", "/api/purchase-orders", `{"supplier_id":"s-1","items":[{"item_id":"i-1","quantity":10}]}`); code != 403 {
+		t.Errorf("cashier create: got %d, want 403", code)
+	}
+	if code, _ := call("manager", "POST", "/api/purchase-orders", `{"supplier_id":"s-1","items":[{"item_id":"nope","quantity":10}]}`); code != 400 {
+		t.Errorf("unknown item: got %d, want 400", code)
+	}
+	code, out := call("manager", "POST", "/api/purchase-orders", `{"supplier_id":"s-1","items":[{"item_id":"i-1","quantity":10},{"item_id":"i-2","quantity":4,"unit_cost":140}]}`)
+	if code != 200 {
+		t.Fatalf("create: %d %v", code, out)
+	}
+	id := out["id"].(string)
+	if out["number"] != "PO-00001" || out["total"] != float64(1160) {
+		t.Errorf("created %v, want PO-00001 totalling 1160", out)
+	}
+	if code, _ := call("manager", "POST", "/api/purchase-orders/"+id+"/send", ""); code != 200 {
+		t.Errorf("send: got %d", code)
+	}
+	if code, _ := call("manager", "PATCH", "/api/purchase-orders/"+id, `{"notes":"late"}`); code != 409 {
+		t.Errorf("editing a sent order: got %d, want 409", code)
+	}
+
+	if code, _ := call("manager", "POST", "/api/purchase-orders/"+id+"/receive", `{"items":[{"item_id":"i-2","quantity":5}]}`); code != 400 {
+		t.Errorf("receiving more than ordered: got %d, want 400", code)
+	}
+	code, out = call("manager", "POST", "/api/purchase-orders/"+id+"/receive", `{"items":[{"item_id":"i-1","quantity":6}],"paid_amount":100}`)
+	if code != 200 || out["status"] != "partially_received" || out["amount"] != float64(360) {
+		t.Fatalf("first delivery: %d %v", code, out)
+	}
+	var typ, source string
+	var due money
+	if err := db.QueryRow(`SELECT type, source, due_amount FROM transactions WHERE id = ?`, out["transaction_id"]).Scan(&typ, &source, &due); err != nil {
+		t.Fatal(err)
+	}
+	if typ != "outflow" || source != "purchase_order" || due != moneyOf(260) {
+		t.Errorf("delivery transaction %s/%s due %v", typ, source, due)
+	}
+	if q := quantity("i-1"); q != 11 {
+		t.Errorf("rice stock %d after first delivery, want 11", q)
+	}
+
+	code, out = call("manager", "POST", "/api/purchase-orders/"+id+"/receive", "")
+	if code != 200 || out["status"] != "received" || out["amount"] != float64(800) {
+		t.Fatalf("second delivery: %d %v", code, out)
+	}
+	if q1, q2 := quantity("i-1"), quantity("i-2"); q1 != 15 || q2 != 4 {
+		t.Errorf("stock %d rice, %d oil, want 15 and 4", q1, q2)
+	}
+	var cost float64
+	_ = db.QueryRow(`SELECT cost_price FROM inventory_items WHERE id = 'i-2'`).Scan(&cost)
+	if cost != 140 {
+		t.Errorf("oil cost price %v, want 140", cost)
+	}
+	_, order := call("cashier", "GET", "/api/purchase-orders/"+id, "")
+	if receipts := order["receipts"].([]interface{}); len(receipts) != 2 {
+		t.Errorf("order lists %d receipts, want 2", len(receipts))
+	}
+	if code, _ := call("manager", "POST", "/api/purchase-orders/"+id+"/receive", ""); code != 409 {
+		t.Errorf("receiving a received order: got %d, want 409", code)
+	}
+	if code, _ := call("manager", "POST", "/api/purchase-orders/"+id+"/cancel", ""); code != 409 {
+		t.Errorf("cancelling a received order: got %d, want 409", code)
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import "errors"
+
+// A QR code encoder for short texts such as SKUs: byte mode, error
+// correction level M, versions 1 to 10 (up to 213 bytes). It follows
+// ISO/IEC 18004; the mask is chosen by the standard's penalty rules.
+
+// qrBlocks gives, per version, the error correction codewords per block
+// and the data codewords of each block at level M.
+var qrBlocks = [11]struct {
+	ec   int
+	data []int
+}{
+	1:  {10, []int{16}},
+	2:  {16, []int{28}},
+	3:  {26, []int{44}},
+	4:  {18, []int{32, 32}},
+	5:  {24, []int{43, 43}},
+	6:  {16, []int{27, 27, 27, 27}},
+	7:  {18, []int{31, 31, 31, 31}},
+	8:  {22, []int{38, 38, 39, 39}},
+	9:  {22, []int{36, 36, 36, 37, 37}},
+	10: {26, []int{43, 43, 43, 43, 44}},
+}
+
+// qrAlignment gives the row and column centres of alignment patterns.
+var qrAlignment = [11][]int{
+	2: {6, 18}, 3: {6, 22}, 4: {6, 26}, 5: {6, 30}, 6: {6, 34},
+	7: {6, 22, 38}, 8: {6, 24, 42}, 9: {6, 26, 46}, 10: {6, 28, 50},
+}
+
+var errQRTooLong = errors.New("text too long for a QR code")
+
+type qrCode struct {
+	size     int
+	modules  [][]bool // true is dark, indexed [row][column]
+	function [][]bool
+}
+
+// encodeQR returns the QR code of s as rows of modules, dark being true,
+// without the quiet zone.
+func encodeQR(s string) ([][]bool, error) {
+	data := []byte(s)
+	version := 0
+	for v := 1; v <= 10; v++ {
+		countBits := 8
+		if v >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(data) <= 8*qrDataCodewords(v) {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, errQRTooLong
+	}
+	q := newQRCode(version)
+	q.placeCodewords(qrCodewords(version, data))
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask) // masking twice undoes it
+	}
+	q.applyMask(best)
+	q.drawFormat(best)
+	return q.modules, nil
+}
+
+func qrDataCodewords(version int) int {
+	n := 0
+	for _, d := range qrBlocks[version].data {
+		n += d
+	}
+	return n
+}
+
+// qrCodewords returns the data of version interleaved with its error
+// correction codewords.
+func qrCodewords(version int, data []byte) []byte {
+	var bits []bool
+	put := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, v>>uint(i)&1 == 1)
+		}
+	}
+	countBits := 8
+	if version >= 10 {
+		countBits = 16
+	}
+	put(4, 4) // byte mode
+	put(len(data), countBits)
+	for _, b := range data {
+		put(int(b), 8)
+	}
+	capacity := 8 * qrDataCodewords(version)
+	for i := 0; i < 4 && len(bits) < capacity; i++ {
+		bits = append(bits, false)
+	}
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+	codewords := make([]byte, 0, capacity/8)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << uint(7-j)
+			}
+		}
+		codewords = append(codewords, b)
+	}
+	for pad := byte(0xEC); len(codewords) < capacity/8; pad ^= 0xEC ^ 0x11 {
+		codewords = append(codewords, pad)
+	}
+
+	blocks := qrBlocks[version]
+	var dataBlocks, ecBlocks [][]byte
+	for _, n := range blocks.data {
+		dataBlocks = append(dataBlocks, codewords[:n])
+		ecBlocks = append(ecBlocks, reedSolomon(codewords[:n], blocks.ec))
+		codewords = codewords[n:]
+	}
+	var out []byte
+	longest := blocks.data[len(blocks.data)-1]
+	for i := 0; i < longest; i++ {
+		for _, b := range dataBlocks {
+			if i < len(b) {
+				out = append(out, b[i])
+			}
+		}
+	}
+	for i := 0; i < blocks.ec; i++ {
+		for _, b := range ecBlocks {
+			out = append(out, b[i])
+		}
+	}
+	return out
+}
+
+// gfMul multiplies in GF(256) with the QR polynomial x^8+x^4+x^3+x^2+1.
+func gfMul(a, b byte) byte {
+	var p byte
+	for ; b > 0; b >>= 1 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1D
+		}
+	}
+	return p
+}
+
+// reedSolomon returns the n error correction codewords of data.
+func reedSolomon(data []byte, n int) []byte {
+	// generator (x - a^0)(x - a^1)...(x - a^(n-1)), highest term implied
+	gen := make([]byte, n)
+	gen[n-1] = 1
+	root := byte(1)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			gen[j] = gfMul(gen[j], root)
+			if j+1 < n {
+				gen[j] ^= gen[j+1]
+			}
+		}
+		root = gfMul(root, 2)
+	}
+	rem := make([]byte, n)
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[n-1] = 0
+		for j := range rem {
+			rem[j] ^= gfMul(gen[j], factor)
+		}
+	}
+	return rem
+}
+
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	q := &qrCode{size: size, modules: make([][]bool, size), function: make([][]bool, size)}
+	for i := range q.modules {
+		q.modules[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {3, size - 4}, {size - 4, 3}} {
+		for dr := -4; dr <= 4; dr++ {
+			for dc := -4; dc <= 4; dc++ {
+				r, col := c[0]+dr, c[1]+dc
+				if r >= 0 && r < size && col >= 0 && col < size {
+					d := qrChebyshev(dr, dc)
+					q.set(r, col, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	centres := qrAlignment[version]
+	for i, r := range centres {
+		for j, col := range centres {
+			last := len(centres) - 1
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue // overlaps a finder
+			}
+			for dr := -2; dr <= 2; dr++ {
+				for dc := -2; dc <= 2; dc++ {
+					q.set(r+dr, col+dc, qrChebyshev(dr, dc) != 1)
+				}
+			}
+		}
+	}
+	q.drawFormat(0) // reserves the format areas and the dark module
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>uint(i)&1 == 1
+			a, b := size-11+i%3, i/3
+			q.set(b, a, dark)
+			q.set(a, b, dark)
+		}
+	}
+	return q
+}
+
+func qrChebyshev(a, b int) int {
+	if a < 0 {
+		a = -a
+	}
+	if b < 0 {
+		b = -b
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// set draws a function module.
+func (q *qrCode) set(row, col int, dark bool) {
+	q.modules[row][col] = dark
+	q.function[row][col] = true
+}
+
+// drawFormat draws the format information for level M and mask.
+func (q *qrCode) drawFormat(mask int) {
+	data := mask // level M is 00
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+	size := q.size
+	for i := 0; i <= 5; i++ {
+		q.set(i, 8, bit(i))
+	}
+	q.set(7, 8, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(8, 14-i, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(8, size-1-i, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(size-15+i, 8, bit(i))
+	}
+	q.set(size-8, 8, true)
+}
+
+// placeCodewords fills the data area in the standard zigzag, two columns
+// at a time from the bottom right.
+func (q *qrCode) placeCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < q.size; vert++ {
+			row := vert
+			if upward {
+				row = q.size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				col := right - j
+				if q.function[row][col] {
+					continue
+				}
+				if i < len(data)*8 {
+					q.modules[row][col] = data[i>>3]>>uint(7-i&7)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for r := 0; r < q.size; r++ {
+		for c := 0; c < q.size; c++ {
+			if q.function[r][c] {
+				continue
+			}
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (r+c)%2 == 0
+			case 1:
+				flip = r%2 == 0
+			case 2:
+				flip = c%3 == 0
+			case 3:
+				flip = (r+c)%3 == 0
+			case 4:
+				flip = (r/2+c/3)%2 == 0
+			case 5:
+				flip = r*c%2+r*c%3 == 0
+			case 6:
+				flip = (r*c%2+r*c%3)%2 == 0
+			case 7:
+				flip = ((r+c)%2+r*c%3)%2 == 0
+			}
+			if flip {
+				q.modules[r][c] = !q.modules[r][c]
+			}
+		}
+	}
+}
+
+// penalty scores the symbol by the four rules of the standard; the mask
+// with the lowest score is used.
+func (q *qrCode) penalty() int {
+	n := q.size
+	at := func(r, c int, transposed bool) bool {
+		if transposed {
+			return q.modules[c][r]
+		}
+		return q.modules[r][c]
+	}
+	score, dark := 0, 0
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, t := range []bool{false, true} {
+		for r := 0; r < n; r++ {
+			run := 1
+			for c := 1; c <= n; c++ {
+				if c < n && at(r, c, t) == at(r, c-1, t) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			for c := 0; c+7 <= n; c++ {
+				match := true
+				for k, v := range finder {
+					if at(r, c+k, t) != v {
+						match = false
+						break
+					}
+				}
+				if match && (qrLight(q, r, c-4, c, t) || qrLight(q, r, c+7, c+11, t)) {
+					score += 40
+				}
+			}
+		}
+	}
+	for r := 0; r < n; r++ {
+		for c := 0; c < n; c++ {
+			if q.modules[r][c] {
+				dark++
+			}
+			if r+1 < n && c+1 < n {
+				v := q.modules[r][c]
+				if q.modules[r][c+1] == v && q.modules[r+1][c] == v && q.modules[r+1][c+1] == v {
+					score += 3
+				}
+			}
+		}
+	}
+	deviation := dark*20 - n*n*10
+	if deviation < 0 {
+		deviation = -deviation
+	}
+	return score + deviation/(n*n)*10
+}
+
+// qrLight reports whether modules from through to-1 of line r are light;
+// modules outside the symbol count as light.
+func qrLight(q *qrCode, r, from, to int, transposed bool) bool {
+	for c := from; c < to; c++ {
+		if c < 0 || c >= q.size {
+			continue
+		}
+		if transposed && q.modules[c][r] || !transposed && q.modules[r][c] {
+			return false
+		}
+	}
+	return true
+}
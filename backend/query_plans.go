@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GET /api/admin/query-plans runs EXPLAIN over the queries the app leans on
+// most and flags those that would read a whole table, which on a growing
+// database usually means an index is missing. Queries run with the
+// caller's organization and placeholder values; nothing is modified.
+
+func registerQueryPlanRoutes(app *fiber.App) {
+	app.Get("/api/admin/query-plans", requireAuth, requireRole("admin"), handleQueryPlans)
+}
+
+type auditedQuery struct {
+	name  string
+	query string
+	args  func(orgID string) []interface{}
+}
+
+var auditedQueries = []auditedQuery{
+	{"transaction list", `SELECT id FROM transactions WHERE organization_id = ? ORDER BY created_at DESC LIMIT 50`,
+		func(org string) []interface{} { return []interface{}{org} }},
+	{"transactions in a period", `SELECT SUM(amount) FROM transactions WHERE organization_id = ? AND created_at >= ? AND created_at < ?`,
+		func(org string) []interface{} { return []interface{}{org, "", ""} }},
+	{"contact statement", `SELECT id, amount FROM transactions WHERE contact_id = ?`,
+		func(string) []interface{} { return []interface{}{""} }},
+	{"transaction lines", `SELECT quantity, unit_price FROM transaction_items WHERE transaction_id = ?`,
+		func(string) []interface{} { return []interface{}{""} }},
+	{"item sales", `SELECT SUM(quantity) FROM transaction_items WHERE item_id = ?`,
+		func(string) []interface{} { return []interface{}{""} }},
+	{"transaction payments", `SELECT method, amount FROM transaction_payments WHERE transaction_id = ?`,
+		func(string) []interface{} { return []interface{}{""} }},
+	{"stock movements of an item", `SELECT quantity_change FROM inventory_transactions WHERE item_id = ? ORDER BY created_at`,
+		func(string) []interface{} { return []interface{}{""} }},
+	{"SKU lookup", `SELECT id FROM inventory_items WHERE organization_id = ? AND sku = ?`,
+		func(org string) []interface{} { return []interface{}{org, ""} }},
+	{"contact list", `SELECT id, name FROM contacts WHERE organization_id = ?`,
+		func(org string) []interface{} { return []interface{}{org} }},
+	{"account movements", `SELECT amount FROM account_movements WHERE account_id = ? AND created_at >= ?`,
+		func(string) []interface{} { return []interface{}{"", ""} }},
+	{"consignment sales", `SELECT quantity FROM consignment_sales WHERE consignment_id = ?`,
+		func(string) []interface{} { return []interface{}{""} }},
+}
+
+// fullScans picks the lines of a query plan that read a whole table.
+func fullScans(d dialect, plan []string) []string {
+	var scans []string
+	for _, line := range plan {
+		switch d {
+		case postgresDialect:
+			if strings.Contains(line, "Seq Scan on ") {
+				scans = append(scans, strings.TrimSpace(line))
+			}
+		default:
+			// "SCAN t" reads the table; "SCAN t USING COVERING INDEX i"
+			// reads only the index
+			if strings.HasPrefix(line, "SCAN ") && !strings.Contains(line, " INDEX ") {
+				scans = append(scans, line)
+			}
+		}
+	}
+	return scans
+}
+
+// explain returns the plan of query as lines of text.
+func explain(q *DB, query string, args []interface{}) ([]string, error) {
+	prefix := "EXPLAIN QUERY PLAN "
+	if q.dialect == postgresDialect {
+		prefix = "EXPLAIN "
+	}
+	rows, err := q.Query(prefix+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var plan []string
+	for rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		// SQLite: id, parent, notused, detail; PostgreSQL: one text column
+		plan = append(plan, toString(vals[len(vals)-1]))
+	}
+	return plan, rows.Err()
+}
+
+func handleQueryPlans(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	results := []fiber.Map{}
+	warnings := 0
+	for _, q := range auditedQueries {
+		start := time.Now()
+		plan, err := explain(dbFor(c), q.query, q.args(orgID))
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": q.name + ": " + err.Error()})
+		}
+		r := fiber.Map{"name": q.name, "query": q.query, "plan": plan, "explain_ms": time.Since(start).Milliseconds()}
+		if scans := fullScans(db.dialect, plan); len(scans) > 0 {
+			r["warning"] = "full table scan, an index is probably missing: " + strings.Join(scans, "; ")
+			warnings++
+		}
+		results = append(results, r)
+	}
+	return c.JSON(fiber.Map{"dialect": db.dialect, "queries": results, "warnings": warnings})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryPlansUseIndexes(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	audit := func() (int, []map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/admin/query-plans", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out struct {
+			Warnings int                      `json:"warnings"`
+			Queries  []map[string]interface{} `json:"queries"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Warnings, out.Queries
+	}
+
+	if n, queries := audit(); n != 0 {
+		for _, q := range queries {
+			if q["warning"] != nil {
+				t.Errorf("%s: %v", q["name"], q["warning"])
+			}
+		}
+	}
+	if _, err := db.Exec(`DROP INDEX idx_transaction_items_transaction`); err != nil {
+		t.Fatal(err)
+	}
+	n, queries := audit()
+	if n != 1 {
+		t.Fatalf("warnings after dropping an index = %d, want 1", n)
+	}
+	for _, q := range queries {
+		if (q["warning"] != nil) != (q["name"] == "transaction lines") {
+			t.Errorf("%s: warning %v", q["name"], q["warning"])
+		}
+	}
+}
+
+func TestFullScans(t *testing.T) {
+	plan := []string{"SCAN t", "SEARCH u USING INDEX i (a=?)", "SCAN v USING COVERING INDEX j"}
+	if got := fullScans(sqliteDialect, plan); len(got) != 1 || got[0] != "SCAN t" {
+		t.Errorf("sqlite = %q", got)
+	}
+	plan = []string{"Limit  (cost=0.00..1.00 rows=1)", "  ->  Seq Scan on transactions  (cost=0.00..1.00 rows=1)", "Index Scan using idx on items"}
+	if got := fullScans(postgresDialect, plan); len(got) != 1 {
+		t.Errorf("postgres = %q", got)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A quotation offers a customer items at given prices until valid_until
+// (a date). It is drafted, sent, and then either declined or accepted by
+// converting it: POST /api/quotations/:id/convert creates a sale with the
+// quoted items and prices, takes them out of stock and marks the quote
+// accepted. A quote past its date reads as expired and can no longer be
+// converted; extending valid_until revives it. GET /api/quotations/:id/pdf
+// renders it with the invoice layout. Cashiers may draft and send quotes;
+// changing, declining and converting them is for managers.
+
+var quotationStatuses = []string{"draft", "sent", "accepted", "declined", "expired"}
+
+type quotationLine struct {
+	ItemID    string   `json:"item_id"`
+	Quantity  int      `json:"quantity"`
+	UnitPrice *float64 `json:"unit_price"`
+}
+
+type quotationRequest struct {
+	ContactID  *string         `json:"contact_id"`
+	ValidUntil *string         `json:"valid_until"`
+	Notes      *string         `json:"notes"`
+	Items      []quotationLine `json:"items"`
+}
+
+func registerQuotationRoutes(app *fiber.App) {
+	r := app.Group("/api/quotations", requireAuth)
+	r.Get("/", handleListQuotations)
+	r.Get("/:id", handleGetQuotation)
+	r.Get("/:id/pdf", handleQuotationPDF)
+	r.Post("/", requireRole("admin", "manager", "cashier"), handleCreateQuotation)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchQuotation)
+	r.Post("/:id/send", requireRole("admin", "manager", "cashier"), handleSendQuotation)
+	r.Post("/:id/decline", requireRole("admin", "manager"), handleDeclineQuotation)
+	r.Post("/:id/convert", requireRole("admin", "manager"), handleConvertQuotation)
+}
+
+// quotationStatusSQL gives a quote's status with open quotes past their
+// date read as expired; the date to compare with is its argument.
+const quotationStatusSQL = `CASE WHEN q.status IN ('draft','sent') AND q.valid_until IS NOT NULL AND q.valid_until <> '' AND q.valid_until < ? THEN 'expired' ELSE q.status END`
+
+func today() string {
+	return time.Now().Format("2006-01-02")
+}
+
+// checkQuotationLines validates lines against the items of orgID and
+// fills in a missing unit_price from the item's selling price.
+func checkQuotationLines(q *DB, orgID string, lines []quotationLine) string {
+	if len(lines) == 0 {
+		return "at least one item required"
+	}
+	for i := range lines {
+		l := &lines[i]
+		if l.Quantity <= 0 {
+			return "item quantities must be positive"
+		}
+		var price float64
+		if err := q.QueryRow(`SELECT unit_price FROM inventory_items WHERE id = ? AND organization_id = ?`, l.ItemID, orgID).Scan(&price); err != nil {
+			return "unknown item " + l.ItemID
+		}
+		if l.UnitPrice == nil {
+			l.UnitPrice = &price
+		} else if *l.UnitPrice < 0 {
+			return "unit_price cannot be negative"
+		}
+	}
+	return ""
+}
+
+func checkValidUntil(v *string) string {
+	if v == nil || *v == "" {
+		return ""
+	}
+	if _, err := time.Parse("2006-01-02", *v); err != nil {
+		return "valid_until must be a date (YYYY-MM-DD)"
+	}
+	return ""
+}
+
+// writeQuotationLines replaces the lines of a quote and returns its total.
+func writeQuotationLines(tx *Tx, quotationID string, lines []quotationLine) (float64, error) {
+	if _, err := tx.Exec(`DELETE FROM quotation_items WHERE quotation_id = ?`, quotationID); err != nil {
+		return 0, err
+	}
+	var total money
+	for _, l := range lines {
+		amount := lineTotal(l.Quantity, *l.UnitPrice)
+		if _, err := tx.Exec(`INSERT INTO quotation_items (id,quotation_id,item_id,quantity,unit_price,total_price) VALUES (?,?,?,?,?,?)`,
+			genID(), quotationID, l.ItemID, l.Quantity, *l.UnitPrice, amount.float()); err != nil {
+			return 0, err
+		}
+		total += amount
+	}
+	return total.float(), nil
+}
+
+func handleListQuotations(c *fiber.Ctx) error {
+	query := `SELECT * FROM (SELECT q.id, q.number, q.contact_id, ct.name AS contact_name, ` + quotationStatusSQL + ` AS status, q.valid_until, q.total, q.transaction_id, q.created_at FROM quotations q LEFT JOIN contacts ct ON q.contact_id = ct.id WHERE q.organization_id = ?) l`
+	args := []interface{}{today(), currentOrgID(c)}
+	if s := c.Query("status"); s != "" {
+		valid := false
+		for _, v := range quotationStatuses {
+			valid = valid || v == s
+		}
+		if !valid {
+			return c.Status(400).JSON(fiber.Map{"error": "status must be one of " + strings.Join(quotationStatuses, ", ")})
+		}
+		query += ` WHERE status = ?`
+		args = append(args, s)
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// loadQuotation reads a quote of orgID with its lines.
+func loadQuotation(q *DB, id, orgID string) (map[string]interface{}, error) {
+	rows, err := q.Query(`SELECT q.id, q.number, q.contact_id, ct.name AS contact_name, `+quotationStatusSQL+` AS status, q.valid_until, q.notes, q.total, q.transaction_id, q.created_by, q.created_at, q.updated_at FROM quotations q LEFT JOIN contacts ct ON q.contact_id = ct.id WHERE q.id = ? AND q.organization_id = ?`, today(), id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	quotes, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(quotes) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	quote := quotes[0]
+	rows, err = q.Query(`SELECT l.id, l.item_id, i.name AS item_name, i.sku, l.quantity, l.unit_price, l.total_price FROM quotation_items l LEFT JOIN inventory_items i ON l.item_id = i.id WHERE l.quotation_id = ? ORDER BY i.name`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if quote["items"], err = rowsToMaps(rows); err != nil {
+		return nil, err
+	}
+	return quote, nil
+}
+
+func handleGetQuotation(c *fiber.Ctx) error {
+	quote, err := loadQuotation(dbFor(c), c.Params("id"), currentOrgID(c))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "quotation not found"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(quote)
+}
+
+func handleQuotationPDF(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	inv := &invoiceData{id: c.Params("id"), typ: "inflow", quote: true}
+	var validUntil, createdAt sql.NullString
+	err := dbFor(c).QueryRow(`SELECT q.number, q.valid_until, q.total, q.created_at, COALESCE(ct.name, ''), COALESCE(ct.phone, '') FROM quotations q LEFT JOIN contacts ct ON q.contact_id = ct.id WHERE q.id = ? AND q.organization_id = ?`, inv.id, orgID).
+		Scan(&inv.ref, &validUntil, &inv.amount, &createdAt, &inv.contact.name, &inv.contact.phone)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "quotation not found"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	inv.validUntil, inv.createdAt = validUntil.String, createdAt.String
+	rows, err := dbFor(c).Query(`SELECT COALESCE(i.name, 'Unnamed Item'), COALESCE(i.sku, ''), l.quantity, l.unit_price, l.total_price
+		FROM quotation_items l LEFT JOIN inventory_items i ON i.id = l.item_id
+		WHERE l.quotation_id = ? ORDER BY i.name`, inv.id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l invoiceLine
+		if err := rows.Scan(&l.name, &l.sku, &l.quantity, &l.unitPrice, &l.total); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		inv.lines = append(inv.lines, l)
+	}
+	if inv.org, err = organizationProfile(dbFor(c), orgID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set("Content-Type", "application/pdf")
+	c.Set("Content-Disposition", fmt.Sprintf(`inline; filename="quotation-%s.pdf"`, inv.number()))
+	return c.Send(renderInvoice(inv))
+}
+
+func handleCreateQuotation(c *fiber.Ctx) error {
+	var req quotationRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	if req.ContactID == nil || !orgOwns(dbFor(c), "contacts", *req.ContactID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "contact_id must be a contact of this organization"})
+	}
+	if msg := checkValidUntil(req.ValidUntil); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	if msg := checkQuotationLines(dbFor(c), orgID, req.Items); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	number, err := nextSequenceNumber(tx, orgID, "quote")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	id := genID()
+	var validUntil, notes interface{}
+	if req.ValidUntil != nil && *req.ValidUntil != "" {
+		validUntil = *req.ValidUntil
+	}
+	if req.Notes != nil {
+		notes = *req.Notes
+	}
+	now := time.Now().Format(time.RFC3339)
+	if _, err := tx.Exec(`INSERT INTO quotations (id,organization_id,number,contact_id,status,valid_until,notes,total,created_by,created_at,updated_at) VALUES (?,?,?,?,'draft',?,?,0,?,?,?)`,
+		id, orgID, number, *req.ContactID, validUntil, notes, currentUserID(c), now, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	total, err := writeQuotationLines(tx, id, req.Items)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`UPDATE quotations SET total = ? WHERE id = ?`, total, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "number": number, "total": total})
+}
+
+// quotationStatus reads the stored status of a quote of orgID, answering
+// 404 itself when there is none.
+func quotationStatus(c *fiber.Ctx) (string, error) {
+	var status string
+	err := dbFor(c).QueryRow(`SELECT status FROM quotations WHERE id = ? AND organization_id = ?`, c.Params("id"), currentOrgID(c)).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", c.Status(404).JSON(fiber.Map{"error": "quotation not found"})
+	}
+	if err != nil {
+		return "", c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return status, nil
+}
+
+// handlePatchQuotation edits an open quote. Sent quotes may be revised,
+// e.g. to extend valid_until after the customer asks.
+func handlePatchQuotation(c *fiber.Ctx) error {
+	var req quotationRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	status, err := quotationStatus(c)
+	if status == "" {
+		return err
+	}
+	if status != "draft" && status != "sent" {
+		return c.Status(409).JSON(fiber.Map{"error": "quotation is already " + status})
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	if req.ContactID != nil && !orgOwns(dbFor(c), "contacts", *req.ContactID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "contact_id must be a contact of this organization"})
+	}
+	if msg := checkValidUntil(req.ValidUntil); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	if req.Items != nil {
+		if msg := checkQuotationLines(dbFor(c), orgID, req.Items); msg != "" {
+			return c.Status(400).JSON(fiber.Map{"error": msg})
+		}
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	updates := map[string]interface{}{"updated_at": time.Now().Format(time.RFC3339)}
+	if req.ContactID != nil {
+		updates["contact_id"] = *req.ContactID
+	}
+	if req.ValidUntil != nil {
+		updates["valid_until"] = *req.ValidUntil
+	}
+	if req.Notes != nil {
+		updates["notes"] = *req.Notes
+	}
+	if req.Items != nil {
+		total, err := writeQuotationLines(tx, id, req.Items)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		updates["total"] = total
+	}
+	for _, field := range []string{"contact_id", "valid_until", "notes", "total", "updated_at"} {
+		if v, ok := updates[field]; ok {
+			if _, err := tx.Exec("UPDATE quotations SET "+field+" = ? WHERE id = ?", v, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+// setQuotationStatus moves a quote to status if it is in one of from.
+func setQuotationStatus(c *fiber.Ctx, status string, from ...string) error {
+	current, err := quotationStatus(c)
+	if current == "" {
+		return err
+	}
+	allowed := false
+	for _, s := range from {
+		allowed = allowed || s == current
+	}
+	if !allowed {
+		return c.Status(409).JSON(fiber.Map{"error": "quotation is already " + current})
+	}
+	if _, err := dbFor(c).Exec(`UPDATE quotations SET status = ?, updated_at = ? WHERE id = ?`, status, time.Now().Format(time.RFC3339), c.Params("id")); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": c.Params("id"), "status": status})
+}
+
+func handleSendQuotation(c *fiber.Ctx) error {
+	return setQuotationStatus(c, "sent", "draft")
+}
+
+func handleDeclineQuotation(c *fiber.Ctx) error {
+	return setQuotationStatus(c, "declined", "draft", "sent")
+}
+
+// handleConvertQuotation turns an open quote into a sale at the quoted
+// prices. The body may carry payments or paid_amount as for any sale.
+func handleConvertQuotation(c *fiber.Ctx) error {
+	body := map[string]interface{}{}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+	payments, err := parsePayments(dbFor(c), orgID, body)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var number, contactID, status string
+	var total float64
+	if err := tx.QueryRow(`SELECT q.number, q.contact_id, `+quotationStatusSQL+`, q.total FROM quotations q WHERE q.id = ? AND q.organization_id = ?`, today(), id, orgID).Scan(&number, &contactID, &status, &total); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "quotation not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status != "draft" && status != "sent" {
+		return c.Status(409).JSON(fiber.Map{"error": "quotation is " + status})
+	}
+
+	txBody := map[string]interface{}{"amount": total}
+	if paid, ok := body["paid_amount"].(float64); ok {
+		txBody["paid_amount"] = paid
+	}
+	if err := applyPayments(txBody, payments); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	paid, _ := txBody["paid_amount"].(float64)
+	method, _ := txBody["payment_method"].(string)
+	if method == "" {
+		method, _ = body["payment_method"].(string)
+		if method != "" && paid > 0 {
+			payments = []paymentLine{{Method: method, Amount: paid}}
+		}
+	}
+	if paid > total+0.005 {
+		return c.Status(400).JSON(fiber.Map{"error": "paid amount exceeds the quotation total"})
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	transactionID := genID()
+	invoiceNo, err := nextSequenceNumber(tx, orgID, "invoice")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,payment_method,receipt_number,source,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,?,?,?)`,
+		transactionID, "inflow", moneyOf(total), moneyOf(paid), moneyOf(total)-moneyOf(paid), contactID, method, invoiceNo, "quotation", orgID, now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := recordPayments(tx, orgID, transactionID, "inflow", payments); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	type soldLine struct {
+		itemID    string
+		quantity  int
+		unitPrice float64
+	}
+	var sold []soldLine
+	rows, err := tx.Query(`SELECT item_id, quantity, unit_price FROM quotation_items WHERE quotation_id = ?`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		var l soldLine
+		if err := rows.Scan(&l.itemID, &l.quantity, &l.unitPrice); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		sold = append(sold, l)
+	}
+	rows.Close()
+	for _, l := range sold {
+		if _, err := tx.Exec(`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES (?,?,?,?,?,?)`,
+			genID(), transactionID, l.itemID, l.quantity, moneyOf(l.unitPrice), lineTotal(l.quantity, l.unitPrice)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if status, err := assembleShortfall(tx, orgID, l.itemID, l.quantity, "", "Quotation "+number); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		if status, err := adjustStock(tx, l.itemID, -l.quantity, "inflow", "Quotation "+number); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+		}
+		if err := consumeBatches(tx, l.itemID, transactionID, l.quantity); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	for _, l := range sold {
+		if err := recordConsignedSale(tx, orgID, transactionID, l.itemID, l.quantity, l.unitPrice); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := refreshTransactionReadModel(tx, transactionID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`UPDATE quotations SET status = 'accepted', transaction_id = ?, updated_at = ? WHERE id = ?`, transactionID, now, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "transactions", "create", transactionID)
+	for _, l := range sold {
+		publishRecord(orgID, "inventory_items", "update", l.itemID)
+	}
+	return c.JSON(fiber.Map{"id": id, "status": "accepted", "transaction_id": transactionID, "invoice_no": invoiceNo, "amount": total})
+}
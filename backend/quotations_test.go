@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestQuotationConvertsToSale(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Corner Shop','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','017','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Blue pen','PEN',10,15,'org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-2','Notebook','NB',2,60,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	role := "manager"
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("POST", "/api/quotations", `{"contact_id":"c-1","valid_until":"next week","items":[{"item_id":"i-1","quantity":1}]}`); code != 400 {
+		t.Errorf("bad valid_until: got %d, want 400", code)
+	}
+	code, out := call("POST", "/api/quotations", `{"contact_id":"c-1","valid_until":"2000-01-31","items":[{"item_id":"i-1","quantity":4,"unit_price":12},{"item_id":"i-2","quantity":2}]}`)
+	if code != 200 || out["number"] != "QT-00001" || out["total"] != float64(168) {
+		t.Fatalf("create: %d %v", code, out)
+	}
+	id := out["id"].(string)
+
+	_, quote := call("GET", "/api/quotations/"+id, "")
+	if quote["status"] != "expired" {
+		t.Errorf("status of a quote past its date: %v", quote["status"])
+	}
+	if code, _ := call("POST", "/api/quotations/"+id+"/convert", ""); code != 409 {
+		t.Errorf("converting an expired quote: got %d, want 409", code)
+	}
+	// cashiers quote, but changing a quote's prices or selling from it is
+	// for managers
+	role = "cashier"
+	for _, r := range []struct{ method, path string }{{"PATCH", ""}, {"POST", "/convert"}, {"POST", "/decline"}} {
+		if code, _ := call(r.method, "/api/quotations/"+id+r.path, `{"items":[{"item_id":"i-1","quantity":4,"unit_price":1}]}`); code != 403 {
+			t.Errorf("cashier %s %s: got %d, want 403", r.method, r.path, code)
+		}
+	}
+	role = "manager"
+	if code, _ := call("PATCH", "/api/quotations/"+id, `{"valid_until":"2999-12-31"}`); code != 200 {
+		t.Fatalf("extend: got %d", code)
+	}
+	if code, _ := call("POST", "/api/quotations/"+id+"/send", ""); code != 200 {
+		t.Errorf("send: got %d", code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/quotations/"+id+"/pdf", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "cashier"))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{"(QUOTATION)", "(No. QT-00001)", "(Valid until: 31 Dec 2999)", "(Notebook)", "(168.00)"} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("quotation PDF lacks %s", want)
+		}
+	}
+	if bytes.Contains(pdf, []byte("(Due)")) {
+		t.Error("quotation PDF shows a due amount")
+	}
+
+	code, out = call("POST", "/api/quotations/"+id+"/convert", `{"paid_amount":100,"payment_method":"cash"}`)
+	if code != 200 || out["status"] != "accepted" {
+		t.Fatalf("convert: %d %v", code, out)
+	}
+	var amount, due money
+	var typ string
+	if err := db.QueryRow(`SELECT type, amount, due_amount FROM transactions WHERE id = ?`, out["transaction_id"]).Scan(&typ, &amount, &due); err != nil {
+		t.Fatal(err)
+	}
+	if typ != "inflow" || amount != moneyOf(168) || due != moneyOf(68) {
+		t.Errorf("sale %s %v due %v, want inflow 168 due 68", typ, amount, due)
+	}
+	var pens, lines int
+	_ = db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = 'i-1'`).Scan(&pens)
+	_ = db.QueryRow(`SELECT COUNT(1) FROM transaction_items WHERE transaction_id = ?`, out["transaction_id"]).Scan(&lines)
+	if pens != 6 || lines != 2 {
+		t.Errorf("%d pens left and %d sale lines, want 6 and 2", pens, lines)
+	}
+	if code, _ := call("POST", "/api/quotations/"+id+"/convert", ""); code != 409 {
+		t.Errorf("converting twice: got %d, want 409", code)
+	}
+
+	// a quote for more than is in stock cannot be converted
+	_, out = call("POST", "/api/quotations", `{"contact_id":"c-1","items":[{"item_id":"i-2","quantity":5}]}`)
+	if code, _ := call("POST", "/api/quotations/"+out["id"].(string)+"/convert", ""); code != 409 {
+		t.Errorf("converting beyond stock: got %d, want 409", code)
+	}
+}
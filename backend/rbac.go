@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Roles, from most to least privileged: admin, manager, cashier, viewer.
+// Admins may do anything; for the other roles collectionPermissions lists
+// the HTTP methods allowed on each collection, with "*" as the fallback for
+// collections not named explicitly. Viewers only read (see requireAuth),
+// and which fields each role sees is up to masking.go.
+
+var validRoles = []string{"admin", "manager", "cashier", "viewer"}
+
+var collectionPermissions = map[string]map[string][]string{
+	"manager": {
+		"*":        {"GET", "POST", "PATCH", "DELETE"},
+		"contacts": {"GET", "POST", "PATCH"},
+	},
+	"cashier": {
+		"*":                      {"GET"},
+		"transactions":           {"GET", "POST"},
+		"contacts":               {"GET", "POST"},
+		"inventory_transactions": {"GET", "POST"},
+	},
+	"viewer": {
+		"*": {"GET"},
+	},
+}
+
+func currentRole(c *fiber.Ctx) string {
+	role, _ := c.Locals("role").(string)
+	return role
+}
+
+func roleAllows(role, collection, method string) bool {
+	if role == "admin" {
+		return true
+	}
+	perms, ok := collectionPermissions[role]
+	if !ok {
+		return false
+	}
+	methods, ok := perms[collection]
+	if !ok {
+		methods = perms["*"]
+	}
+	if method == "HEAD" {
+		method = "GET"
+	}
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeCollection enforces collectionPermissions on /api/collections
+// routes. It runs after requireAuth.
+func authorizeCollection(c *fiber.Ctx) error {
+	rest := strings.TrimPrefix(c.Path(), "/api/collections/")
+	collection := strings.SplitN(rest, "/", 2)[0]
+	if !roleAllows(currentRole(c), collection, c.Method()) {
+		return c.Status(403).JSON(fiber.Map{"error": "your role cannot " + c.Method() + " " + collection})
+	}
+	return c.Next()
+}
+
+// requireRole allows only the given roles through; use after requireAuth.
+func requireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role := currentRole(c)
+		for _, r := range roles {
+			if r == role {
+				return c.Next()
+			}
+		}
+		return c.Status(403).JSON(fiber.Map{"error": "insufficient role"})
+	}
+}
+
+func registerUserRoutes(app *fiber.App) {
+	r := app.Group("/api/users", requireAuth, requireRole("admin"))
+	r.Get("/", handleListUsers)
+	r.Post("/", handleAddMember)
+	r.Put("/:id/role", handleSetUserRole)
+	r.Delete("/:id", handleRemoveMember)
+}
+
+func isValidRole(role string) bool {
+	for _, r := range validRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func handleListUsers(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT u.id,u.email,u.name,m.role,m.organization_id,u.created_at FROM organization_members m JOIN users u ON u.id = m.user_id WHERE m.organization_id = ? ORDER BY m.created_at`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleSetUserRole changes a user's role in the caller's organization. It
+// takes effect on the user's next login, token refresh or switch.
+func handleSetUserRole(c *fiber.Ctx) error {
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if !isValidRole(req.Role) {
+		return c.Status(400).JSON(fiber.Map{"error": "role must be one of " + strings.Join(validRoles, ", ")})
+	}
+	id := c.Params("id")
+	if id == currentUserID(c) && req.Role != "admin" {
+		return c.Status(400).JSON(fiber.Map{"error": "you cannot demote yourself"})
+	}
+	res, err := dbFor(c).Exec(`UPDATE organization_members SET role = ? WHERE user_id = ? AND organization_id = ?`, req.Role, id, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	// users.role mirrors the role in the home organization
+	if _, err := dbFor(c).Exec(`UPDATE users SET role = ? WHERE id = ? AND organization_id = ?`, req.Role, id, currentOrgID(c)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "role": req.Role})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Each transaction keeps a copy of its contact's name (contact_name) and
+// of its item lines (items_summary, JSON) so the transactions list, which
+// nearly every screen opens with expand=contact,items, reads one table
+// instead of joining contacts and querying transaction_items per row.
+// Whatever writes a transaction or its items calls
+// refreshTransactionReadModel in the same database transaction; renaming
+// an item calls refreshItemReadModel (refreshItemTransactions within a
+// transaction).
+
+// transactionItemLine is one entry of items_summary, in the shape the
+// transactions list has always returned for expand=items.
+type transactionItemLine struct {
+	ItemID     string `json:"item_id"`
+	ItemName   string `json:"item_name"`
+	Name       string `json:"name"`
+	SKU        string `json:"sku"`
+	Quantity   int    `json:"quantity"`
+	UnitPrice  money  `json:"unit_price"`
+	TotalPrice money  `json:"total_price"`
+	// the unit and quantity as entered, for lines not in the base unit
+	Unit         string `json:"unit,omitempty"`
+	UnitQuantity int    `json:"unit_quantity,omitempty"`
+}
+
+// refreshTransactionReadModel recomputes contact_name and items_summary of
+// transaction id.
+func refreshTransactionReadModel(tx *Tx, id string) error {
+	rows, err := tx.Query(`SELECT ti.item_id, COALESCE(i.name, 'Unnamed Item'), COALESCE(i.sku, ''), ti.quantity, ti.unit_price, ti.total_price, COALESCE(ti.unit, ''), COALESCE(ti.unit_quantity, 0)
+		FROM transaction_items ti LEFT JOIN inventory_items i ON ti.item_id = i.id WHERE ti.transaction_id = ?`, id)
+	if err != nil {
+		return err
+	}
+	lines := []transactionItemLine{}
+	for rows.Next() {
+		var l transactionItemLine
+		if err := rows.Scan(&l.ItemID, &l.ItemName, &l.SKU, &l.Quantity, &l.UnitPrice, &l.TotalPrice, &l.Unit, &l.UnitQuantity); err != nil {
+			rows.Close()
+			return err
+		}
+		l.Name = l.ItemName
+		lines = append(lines, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	summary, _ := json.Marshal(lines)
+	_, err = tx.Exec(`UPDATE transactions SET contact_name = (SELECT name FROM contacts WHERE contacts.id = transactions.contact_id), items_summary = ? WHERE id = ?`, string(summary), id)
+	return err
+}
+
+// refreshItemReadModel refreshes the transactions that sold or bought
+// itemID, after its name or sku changed.
+func refreshItemReadModel(itemID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := refreshItemTransactions(tx, itemID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// refreshItemTransactions is refreshItemReadModel inside tx.
+func refreshItemTransactions(tx *Tx, itemID string) error {
+	rows, err := tx.Query(`SELECT DISTINCT transaction_id FROM transaction_items WHERE item_id = ?`, itemID)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	for _, id := range ids {
+		if err := refreshTransactionReadModel(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itemsSummary decodes a transaction's items_summary for a response.
+func itemsSummary(raw interface{}) []transactionItemLine {
+	lines := []transactionItemLine{}
+	_ = json.Unmarshal([]byte(toString(raw)), &lines)
+	return lines
+}
+
+// attachTransactionContacts adds the contact of each transaction record,
+// looked up for the whole page at once. A contact deleted since keeps the
+// name the transaction was recorded with.
+func attachTransactionContacts(q *DB, items []map[string]interface{}) error {
+	ids := map[string]bool{}
+	var marks []string
+	var args []interface{}
+	for _, m := range items {
+		if id := toString(m["contact_id"]); id != "" && !ids[id] {
+			ids[id] = true
+			marks = append(marks, "?")
+			args = append(args, id)
+		}
+	}
+	contacts := map[string]map[string]interface{}{}
+	if len(marks) > 0 {
+		rows, err := q.Query(`SELECT id,name,phone,nid,type,organization_id FROM contacts WHERE id IN (`+strings.Join(marks, ",")+`)`, args...)
+		if err != nil {
+			return err
+		}
+		found, err := rowsToMaps(rows)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		for _, ct := range found {
+			contacts[toString(ct["id"])] = ct
+		}
+	}
+	for _, m := range items {
+		if ct, ok := contacts[toString(m["contact_id"])]; ok {
+			m["contact"] = ct
+		} else {
+			m["contact"] = map[string]interface{}{"id": m["contact_id"], "name": m["contact_name"]}
+		}
+	}
+	return nil
+}
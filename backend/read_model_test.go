@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransactionListReadModel(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	// rows written before the columns existed are filled in by the migration
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := 0
+	for _, m := range migrations {
+		if m.version >= 39 {
+			steps++
+		}
+	}
+	if err := migrateDown(db, steps); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,nid,type,organization_id) VALUES ('c-1','Rahim','017','123','customer','org-1'),('c-2','Karim','018','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,'org-1'),('i-2','Ink','INK',10,40,'org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-old','inflow',30,30,0,'c-1','org-1','2024-01-01T00:00:00Z')`,
+		`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price) VALUES ('ti-1','t-old','i-1',2,15,30)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	app := newApp()
+	call := func(method, path, body string) map[string]interface{} {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s %s: %d", method, path, resp.StatusCode)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return out
+	}
+	created := call("POST", "/api/collections/transactions/records", `{"type":"inflow","amount":95,"paid_amount":95,"due_amount":0,"contact_id":"c-2","items":[{"item_id":"i-1","quantity":3,"unit_price":15},{"item_id":"i-2","quantity":1,"unit_price":40}]}`)
+	newID := created["id"].(string)
+
+	var contactName, summary string
+	if err := db.QueryRow(`SELECT contact_name, items_summary FROM transactions WHERE id = ?`, newID).Scan(&contactName, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if contactName != "Karim" || !strings.Contains(summary, `"sku":"INK"`) {
+		t.Errorf("stored read model: %q %s", contactName, summary)
+	}
+
+	list := func() map[string]map[string]interface{} {
+		t.Helper()
+		out := call("GET", "/api/collections/transactions/records?expand=contact,items", "")
+		byID := map[string]map[string]interface{}{}
+		for _, it := range out["items"].([]interface{}) {
+			it := it.(map[string]interface{})
+			byID[it["id"].(string)] = it
+		}
+		return byID
+	}
+	got := list()
+	old := got["t-old"]
+	if contact := old["contact"].(map[string]interface{}); contact["name"] != "Rahim" || contact["phone"] != "017" {
+		t.Errorf("backfilled contact: %v", contact)
+	}
+	if lines := old["items"].([]interface{}); len(lines) != 1 || lines[0].(map[string]interface{})["name"] != "Pen" || lines[0].(map[string]interface{})["total_price"] != 30.0 {
+		t.Errorf("backfilled items: %v", lines)
+	}
+	if lines := got[newID]["items"].([]interface{}); len(lines) != 2 {
+		t.Errorf("new transaction items: %v", lines)
+	}
+	if _, ok := got[newID]["items_summary"]; ok {
+		t.Error("items_summary should not be returned as is")
+	}
+
+	// renaming an item updates the transactions it appears on; a deleted
+	// contact keeps the recorded name
+	call("PATCH", "/api/collections/inventory_items/records/i-1", `{"name":"Gel Pen"}`)
+	if _, err := db.Exec(`DELETE FROM contacts WHERE id = 'c-1'`); err != nil {
+		t.Fatal(err)
+	}
+	got = list()
+	if name := got["t-old"]["items"].([]interface{})[0].(map[string]interface{})["item_name"]; name != "Gel Pen" {
+		t.Errorf("renamed item shows as %v", name)
+	}
+	if contact := got["t-old"]["contact"].(map[string]interface{}); contact["name"] != "Rahim" {
+		t.Errorf("deleted contact: %v", contact)
+	}
+	if out := call("GET", "/api/collections/transactions/records?filter="+strings.ReplaceAll(`contact_name = "Karim"`, " ", "%20"), ""); out["totalItems"] != 1.0 {
+		t.Errorf("filter on contact_name: %v", out["totalItems"])
+	}
+}
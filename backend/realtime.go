@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Realtime record events over server-sent events, following PocketBase's
+// protocol so the frontend adapter can use it as is:
+//
+//  1. GET /api/realtime opens the stream; the first event, PB_CONNECT,
+//     carries the clientId.
+//  2. POST /api/realtime {"clientId": ..., "subscriptions": [...]} with
+//     the usual bearer token sets what the client listens to and ties it
+//     to the caller's organization. A subscription is a collection
+//     ("transactions") or one record ("transactions/<id>").
+//  3. Each change is sent as an event named after the matching
+//     subscription, with data {"action": "create"|"update"|"delete",
+//     "record": {...}}.
+//
+// Opening the stream needs no token (EventSource cannot send headers);
+// nothing is delivered until an authenticated subscribe call.
+
+func registerRealtimeRoutes(app *fiber.App) {
+	app.Get("/api/realtime", handleRealtimeConnect)
+	app.Post("/api/realtime", requireAuth, handleRealtimeSubscribe)
+}
+
+type realtimeEvent struct {
+	name string
+	data []byte
+}
+
+type realtimeClient struct {
+	id            string
+	orgID         string
+	role          string
+	subscriptions map[string]bool
+	events        chan realtimeEvent
+}
+
+type realtimeHub struct {
+	sync.Mutex
+	clients map[string]*realtimeClient
+}
+
+var realtime = &realtimeHub{clients: map[string]*realtimeClient{}}
+
+func (h *realtimeHub) connect() *realtimeClient {
+	cl := &realtimeClient{id: genID(), subscriptions: map[string]bool{}, events: make(chan realtimeEvent, 64)}
+	h.Lock()
+	h.clients[cl.id] = cl
+	h.Unlock()
+	return cl
+}
+
+func (h *realtimeHub) disconnect(cl *realtimeClient) {
+	h.Lock()
+	delete(h.clients, cl.id)
+	h.Unlock()
+}
+
+// subscribe replaces a client's subscriptions, reporting false for an
+// unknown client.
+func (h *realtimeHub) subscribe(clientID, orgID, role string, subscriptions []string) bool {
+	h.Lock()
+	defer h.Unlock()
+	cl, ok := h.clients[clientID]
+	if !ok {
+		return false
+	}
+	cl.orgID = orgID
+	cl.role = role
+	cl.subscriptions = map[string]bool{}
+	for _, s := range subscriptions {
+		cl.subscriptions[s] = true
+	}
+	return true
+}
+
+// publish sends an event to every client of orgID subscribed to the
+// collection or the record. record is loaded only if someone listens.
+func (h *realtimeHub) publish(orgID, collection, action, id string, record func() map[string]interface{}) {
+	h.Lock()
+	type target struct {
+		cl   *realtimeClient
+		name string
+	}
+	var targets []target
+	for _, cl := range h.clients {
+		if cl.orgID != orgID {
+			continue
+		}
+		for _, name := range []string{collection, collection + "/" + id} {
+			if cl.subscriptions[name] {
+				targets = append(targets, target{cl, name})
+			}
+		}
+	}
+	h.Unlock()
+	if len(targets) == 0 {
+		return
+	}
+	data, err := json.Marshal(fiber.Map{"action": action, "record": record()})
+	if err != nil {
+		return
+	}
+	masked := map[string][]byte{}
+	for _, t := range targets {
+		out, ok := masked[t.cl.role]
+		if !ok {
+			out = maskJSON(data, hiddenFields(orgID, t.cl.role))
+			masked[t.cl.role] = out
+		}
+		select {
+		case t.cl.events <- realtimeEvent{name: t.name, data: out}:
+		default: // the client is not keeping up; drop rather than block writers
+		}
+	}
+}
+
+// publishRecord announces a change to a row of a collection table; new
+// transactions and contacts also go out to REST hooks (see rest_hooks.go).
+func publishRecord(orgID, collection, action, id string) {
+	if event, ok := hookEvents[collection]; ok && action == "create" {
+		fireRestHooks(orgID, event, id)
+	}
+	realtime.publish(orgID, collection, action, id, func() map[string]interface{} {
+		if action == "delete" {
+			return map[string]interface{}{"id": id}
+		}
+		rows, err := db.Query(`SELECT * FROM `+collection+` WHERE id = ?`, id)
+		if err != nil {
+			return map[string]interface{}{"id": id}
+		}
+		defer rows.Close()
+		records, err := rowsToMaps(rows)
+		if err != nil || len(records) == 0 {
+			return map[string]interface{}{"id": id}
+		}
+		return records[0]
+	})
+}
+
+func handleRealtimeConnect(c *fiber.Ctx) error {
+	// the event stream stays open for as long as the client wants; it is
+	// not held to the request deadline
+	requestDone(c)()
+	cl := realtime.connect()
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer realtime.disconnect(cl)
+		connect, _ := json.Marshal(fiber.Map{"clientId": cl.id})
+		if writeSSE(w, cl.id, "PB_CONNECT", connect) != nil {
+			return
+		}
+		ping := time.NewTicker(30 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case ev := <-cl.events:
+				if writeSSE(w, cl.id, ev.name, ev.data) != nil {
+					return
+				}
+			case <-ping.C:
+				// a comment line keeps proxies from closing the stream and
+				// tells us when the client has gone
+				if _, err := w.WriteString(": ping\n\n"); err != nil || w.Flush() != nil {
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+func writeSSE(w *bufio.Writer, id, event string, data []byte) error {
+	if _, err := fmt.Fprintf(w, "id:%s\nevent:%s\ndata:%s\n\n", id, event, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+func handleRealtimeSubscribe(c *fiber.Ctx) error {
+	var req struct {
+		ClientID      string   `json:"clientId"`
+		Subscriptions []string `json:"subscriptions"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	role := currentRole(c)
+	for _, s := range req.Subscriptions {
+		collection := strings.SplitN(s, "/", 2)[0]
+		if _, ok := collectionFields[collection]; !ok {
+			return c.Status(400).JSON(fiber.Map{"error": "unknown collection " + collection})
+		}
+		if !roleAllows(role, collection, fiber.MethodGet) {
+			return c.Status(403).JSON(fiber.Map{"error": "your role cannot read " + collection})
+		}
+	}
+	if !realtime.subscribe(req.ClientID, currentOrgID(c), role, req.Subscriptions) {
+		return c.Status(404).JSON(fiber.Map{"error": "unknown clientId"})
+	}
+	return c.SendStatus(204)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRealtimeDeliversToSubscribedOrganization(t *testing.T) {
+	mine, theirs := realtime.connect(), realtime.connect()
+	defer realtime.disconnect(mine)
+	defer realtime.disconnect(theirs)
+	if !realtime.subscribe(mine.id, "org-1", "admin", []string{"transactions"}) || !realtime.subscribe(theirs.id, "org-2", "admin", []string{"transactions"}) {
+		t.Fatal("subscribe failed")
+	}
+	if realtime.subscribe("nobody", "org-1", "admin", nil) {
+		t.Error("subscribing an unknown client succeeded")
+	}
+
+	realtime.publish("org-1", "transactions", "create", "t-1", func() map[string]interface{} {
+		return map[string]interface{}{"id": "t-1"}
+	})
+	select {
+	case ev := <-mine.events:
+		var data struct {
+			Action string                 `json:"action"`
+			Record map[string]interface{} `json:"record"`
+		}
+		if err := json.Unmarshal(ev.data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if ev.name != "transactions" || data.Action != "create" || data.Record["id"] != "t-1" {
+			t.Errorf("got %s %s", ev.name, ev.data)
+		}
+	default:
+		t.Fatal("subscriber got no event")
+	}
+	select {
+	case ev := <-theirs.events:
+		t.Errorf("another organization received %s", ev.data)
+	default:
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A till that may lose its connection reserves a block of invoice numbers
+// while online and numbers the sales it makes offline from it. The block
+// is taken from the invoice sequence (see sequences.go), so its numbers
+// are never issued to anyone else; when the sales are synced their
+// receipt_number is checked against the block of the device sending them.
+// Closing a block gives up its unused numbers, which are then gaps in the
+// invoice numbering that GET /api/receipt-blocks/:id accounts for.
+//
+//	POST /api/receipt-blocks             {"count": 100} reserves a block for the X-Device-ID
+//	GET  /api/receipt-blocks             blocks with the numbers used; ?device_id= filters
+//	GET  /api/receipt-blocks/:id         one block with its unused numbers
+//	POST /api/receipt-blocks/:id/close   gives up the rest of a block
+
+const receiptBlockMaxSize = 1000
+
+type receiptBlock struct {
+	ID        string `json:"id"`
+	DeviceKey string `json:"device_id"`
+	Prefix    string `json:"prefix"`
+	Padding   int    `json:"padding"`
+	First     int    `json:"first_number"`
+	Last      int    `json:"last_number"`
+	CreatedAt string `json:"created_at"`
+	ClosedAt  string `json:"closed_at,omitempty"`
+}
+
+func (b receiptBlock) number(n int) string {
+	return b.Prefix + fmt.Sprintf("%0*d", b.Padding, n)
+}
+
+// contains reports whether number is one of the block's.
+func (b receiptBlock) contains(number string) bool {
+	if !strings.HasPrefix(number, b.Prefix) {
+		return false
+	}
+	digits := number[len(b.Prefix):]
+	n, err := strconv.Atoi(digits)
+	return err == nil && len(digits) >= b.Padding && b.number(n) == number && n >= b.First && n <= b.Last
+}
+
+func registerReceiptBlockRoutes(app *fiber.App) {
+	r := app.Group("/api/receipt-blocks", requireAuth)
+	r.Get("/", handleListReceiptBlocks)
+	r.Post("/", requireRole("admin", "manager", "cashier"), handleReserveReceiptBlock)
+	r.Get("/:id", handleGetReceiptBlock)
+	r.Post("/:id/close", requireRole("admin", "manager", "cashier"), handleCloseReceiptBlock)
+}
+
+const receiptBlockColumns = `id, device_key, prefix, padding, first_number, last_number, COALESCE(created_at, ''), COALESCE(closed_at, '')`
+
+func scanReceiptBlock(row interface{ Scan(...interface{}) error }) (receiptBlock, error) {
+	var b receiptBlock
+	err := row.Scan(&b.ID, &b.DeviceKey, &b.Prefix, &b.Padding, &b.First, &b.Last, &b.CreatedAt, &b.ClosedAt)
+	return b, err
+}
+
+// receiptBlockFor finds the block number belongs to, if any.
+func receiptBlockFor(q *DB, orgID, number string) (*receiptBlock, error) {
+	rows, err := q.Query(`SELECT `+receiptBlockColumns+` FROM receipt_blocks
+		WHERE organization_id = ? AND substr(?, 1, length(prefix)) = prefix`, orgID, number)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		b, err := scanReceiptBlock(rows)
+		if err != nil {
+			return nil, err
+		}
+		if b.contains(number) {
+			return &b, rows.Err()
+		}
+	}
+	return nil, rows.Err()
+}
+
+// checkReceiptNumber vets the receipt_number of a sale being created and
+// returns the block it comes from, or "" for a number issued online. A
+// number may be used once, and one from a block only by the block's
+// device while the block is open.
+func checkReceiptNumber(c *fiber.Ctx, orgID, number string) (string, int, fiber.Map) {
+	var usedBy string
+	err := dbFor(c).QueryRow(`SELECT id FROM transactions WHERE organization_id = ? AND receipt_number = ?`, orgID, number).Scan(&usedBy)
+	if err == nil {
+		return "", 409, fiber.Map{"error": "receipt number " + number + " is already used", "transaction_id": usedBy}
+	}
+	if err != sql.ErrNoRows {
+		return "", 500, fiber.Map{"error": err.Error()}
+	}
+	b, err := receiptBlockFor(dbFor(c), orgID, number)
+	if err != nil {
+		return "", 500, fiber.Map{"error": err.Error()}
+	}
+	if b == nil {
+		return "", 0, nil
+	}
+	if device := strings.TrimSpace(c.Get(deviceHeader)); device != b.DeviceKey {
+		return "", 409, fiber.Map{"error": "receipt number " + number + " is reserved for another device", "block_id": b.ID}
+	}
+	if b.ClosedAt != "" {
+		return "", 409, fiber.Map{"error": "receipt number " + number + " is in a closed block", "block_id": b.ID}
+	}
+	return b.ID, 0, nil
+}
+
+func handleReserveReceiptBlock(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	req := struct {
+		Count int `json:"count"`
+	}{Count: 100}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	device := strings.TrimSpace(c.Get(deviceHeader))
+	if device == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "send the till's " + deviceHeader})
+	}
+	if req.Count < 1 || req.Count > receiptBlockMaxSize {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("count must be 1 to %d", receiptBlockMaxSize)})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	s, first, err := reserveSequenceNumbers(tx, orgID, "invoice", req.Count)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now()
+	// the prefix is fixed now, so a block reserved in December keeps its
+	// year in January
+	b := receiptBlock{ID: genID(), DeviceKey: device, Prefix: s.prefixFor(now), Padding: s.Padding,
+		First: first, Last: first + req.Count - 1, CreatedAt: now.Format(time.RFC3339)}
+	if _, err := tx.Exec(`INSERT INTO receipt_blocks (id,organization_id,device_key,reserved_by,prefix,padding,first_number,last_number,created_at) VALUES (?,?,?,?,?,?,?,?,?)`,
+		b.ID, orgID, b.DeviceKey, currentUserID(c), b.Prefix, b.Padding, b.First, b.Last, b.CreatedAt); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"block": b, "first": b.number(b.First), "last": b.number(b.Last)})
+}
+
+// receiptBlockUsage returns the numbers of a block that sales carry.
+func receiptBlockUsage(q *DB, b receiptBlock) (map[int]bool, error) {
+	rows, err := q.Query(`SELECT receipt_number FROM transactions WHERE receipt_block_id = ?`, b.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	used := map[int]bool{}
+	for rows.Next() {
+		var number string
+		if err := rows.Scan(&number); err != nil {
+			return nil, err
+		}
+		n, _ := strconv.Atoi(strings.TrimPrefix(number, b.Prefix))
+		used[n] = true
+	}
+	return used, rows.Err()
+}
+
+// receiptBlockReport sums up a block: how many of its numbers sales carry,
+// the highest of them and, with listUnused, the numbers no sale carries.
+func receiptBlockReport(q *DB, b receiptBlock, listUnused bool) (fiber.Map, error) {
+	used, err := receiptBlockUsage(q, b)
+	if err != nil {
+		return nil, err
+	}
+	out := fiber.Map{"block": b, "first": b.number(b.First), "last": b.number(b.Last), "used": len(used), "remaining": 0}
+	highest := 0
+	unused := []string{}
+	for n := b.First; n <= b.Last; n++ {
+		if used[n] {
+			highest = n
+		} else if listUnused {
+			unused = append(unused, b.number(n))
+		}
+	}
+	if highest > 0 {
+		out["last_used"] = b.number(highest)
+	}
+	if b.ClosedAt == "" {
+		out["remaining"] = b.Last - b.First + 1 - len(used)
+	}
+	if listUnused {
+		out["unused"] = unused
+	}
+	return out, nil
+}
+
+func handleListReceiptBlocks(c *fiber.Ctx) error {
+	query, args := `SELECT `+receiptBlockColumns+` FROM receipt_blocks WHERE organization_id = ?`, []interface{}{currentOrgID(c)}
+	if device := c.Query("device_id"); device != "" {
+		query, args = query+` AND device_key = ?`, append(args, device)
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY first_number`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var blocks []receiptBlock
+	for rows.Next() {
+		b, err := scanReceiptBlock(rows)
+		if err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		blocks = append(blocks, b)
+	}
+	rows.Close()
+	items := []fiber.Map{}
+	for _, b := range blocks {
+		report, err := receiptBlockReport(dbFor(c), b, false)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		items = append(items, report)
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func loadReceiptBlock(c *fiber.Ctx) (receiptBlock, error) {
+	b, err := scanReceiptBlock(dbFor(c).QueryRow(`SELECT `+receiptBlockColumns+` FROM receipt_blocks WHERE id = ? AND organization_id = ?`, c.Params("id"), currentOrgID(c)))
+	if err == sql.ErrNoRows {
+		err = errNotFound
+	}
+	return b, err
+}
+
+func handleGetReceiptBlock(c *fiber.Ctx) error {
+	b, err := loadReceiptBlock(c)
+	if err != nil {
+		return recordError(c, err)
+	}
+	report, err := receiptBlockReport(dbFor(c), b, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(report)
+}
+
+// handleCloseReceiptBlock gives up what is left of a block, e.g. when a
+// till is replaced. Sales already numbered from it keep their numbers.
+func handleCloseReceiptBlock(c *fiber.Ctx) error {
+	b, err := loadReceiptBlock(c)
+	if err != nil {
+		return recordError(c, err)
+	}
+	if currentRole(c) == "cashier" && strings.TrimSpace(c.Get(deviceHeader)) != b.DeviceKey {
+		return c.Status(403).JSON(fiber.Map{"error": "only the block's device or a manager may close it"})
+	}
+	if b.ClosedAt == "" {
+		b.ClosedAt = time.Now().Format(time.RFC3339)
+		if _, err := dbFor(c).Exec(`UPDATE receipt_blocks SET closed_at = ? WHERE id = ?`, b.ClosedAt, b.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	report, err := receiptBlockReport(dbFor(c), b, true)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(report)
+}
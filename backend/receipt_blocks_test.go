@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReceiptBlocks(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	call := func(role, device, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		if device != "" {
+			req.Header.Set("X-Device-ID", device)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	sale := func(device, number string) (int, map[string]interface{}) {
+		return call("cashier", device, "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":50,"paid_amount":50,"due_amount":0,"contact_id":"c-1","receipt_number":"`+number+`"}`)
+	}
+
+	if code, _ := call("cashier", "", "POST", "/api/receipt-blocks", `{"count":5}`); code != 400 {
+		t.Errorf("reserving without a device: got %d, want 400", code)
+	}
+	code, a := call("cashier", "till-a", "POST", "/api/receipt-blocks", `{"count":5}`)
+	if code != 200 || a["first"] != "INV-00001" || a["last"] != "INV-00005" {
+		t.Fatalf("block a: %d %v", code, a)
+	}
+	code, b := call("cashier", "till-b", "POST", "/api/receipt-blocks", `{"count":3}`)
+	if code != 200 || b["first"] != "INV-00006" {
+		t.Fatalf("block b: %d %v", code, b)
+	}
+	// numbers issued online carry on after the blocks
+	if _, out := call("manager", "", "POST", "/api/settings/sequences/invoice/next", ""); out["number"] != "INV-00009" {
+		t.Errorf("next online number: %v", out)
+	}
+
+	// till a syncs its offline sales
+	for _, n := range []string{"INV-00001", "INV-00002", "INV-00004"} {
+		if code, out := sale("till-a", n); code != 200 {
+			t.Fatalf("sale %s: %d %v", n, code, out)
+		}
+	}
+	if code, _ := sale("till-a", "INV-00002"); code != 409 {
+		t.Errorf("number used twice: got %d, want 409", code)
+	}
+	if code, _ := sale("till-a", "INV-00006"); code != 409 {
+		t.Errorf("number from another till's block: got %d, want 409", code)
+	}
+	if code, out := sale("till-b", "INV-00006"); code != 200 {
+		t.Errorf("till b's own number: %d %v", code, out)
+	}
+	if code, out := sale("", "INV-00009"); code != 200 {
+		t.Errorf("number issued online: %d %v", code, out)
+	}
+
+	blockA := toString(a["block"].(map[string]interface{})["id"])
+	code, report := call("cashier", "till-b", "POST", "/api/receipt-blocks/"+blockA+"/close", "")
+	if code != 403 {
+		t.Errorf("closing another till's block: got %d, want 403", code)
+	}
+	code, report = call("cashier", "till-a", "POST", "/api/receipt-blocks/"+blockA+"/close", "")
+	if code != 200 || report["used"] != 3.0 || report["last_used"] != "INV-00004" || len(report["unused"].([]interface{})) != 2 {
+		t.Fatalf("close: %d %v", code, report)
+	}
+	if code, _ := sale("till-a", "INV-00005"); code != 409 {
+		t.Errorf("number from a closed block: got %d, want 409", code)
+	}
+	code, list := call("viewer", "", "GET", "/api/receipt-blocks?device_id=till-b", "")
+	items, _ := list["items"].([]interface{})
+	if code != 200 || len(items) != 1 || items[0].(map[string]interface{})["remaining"] != 2.0 {
+		t.Errorf("list: %d %v", code, list)
+	}
+}
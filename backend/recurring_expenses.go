@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Recurring expenses are the bills that come round on a schedule (rent,
+// salaries, utilities). They are not posted automatically; they tell the
+// cash flow forecast (cash_forecast.go) what is going to leave the
+// business. next_date is moved on by the user, or by POST /:id/paid after
+// paying one.
+
+var recurringFrequencies = []string{"weekly", "monthly", "yearly"}
+
+func registerRecurringExpenseRoutes(app *fiber.App) {
+	r := app.Group("/api/recurring-expenses", requireAuth)
+	r.Get("/", handleListRecurringExpenses)
+	r.Post("/", requireRole("admin", "manager"), handleCreateRecurringExpense)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchRecurringExpense)
+	r.Post("/:id/paid", requireRole("admin", "manager"), handleRecurringExpensePaid)
+	r.Delete("/:id", requireRole("admin", "manager"), handleDeleteRecurringExpense)
+}
+
+func isRecurringFrequency(f string) bool {
+	for _, v := range recurringFrequencies {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
+
+// nextOccurrence returns the date one period after date.
+func nextOccurrence(date time.Time, frequency string) time.Time {
+	switch frequency {
+	case "weekly":
+		return date.AddDate(0, 0, 7)
+	case "yearly":
+		return date.AddDate(1, 0, 0)
+	}
+	return date.AddDate(0, 1, 0)
+}
+
+func handleListRecurringExpenses(c *fiber.Ctx) error {
+	query := `SELECT id, name, amount, category, frequency, next_date, end_date, active, created_at FROM recurring_expenses WHERE organization_id = ?`
+	if c.Query("include_inactive") != "true" {
+		query += ` AND active = 1`
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY next_date`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+type recurringExpenseRequest struct {
+	Name      *string  `json:"name"`
+	Amount    *float64 `json:"amount"`
+	Category  *string  `json:"category"`
+	Frequency *string  `json:"frequency"`
+	NextDate  *string  `json:"next_date"`
+	EndDate   *string  `json:"end_date"`
+	Active    *bool    `json:"active"`
+}
+
+// validate checks the fields that were sent.
+func (r recurringExpenseRequest) validate() string {
+	if r.Name != nil && strings.TrimSpace(*r.Name) == "" {
+		return "name cannot be empty"
+	}
+	if r.Amount != nil && *r.Amount <= 0 {
+		return "amount must be positive"
+	}
+	if r.Frequency != nil && !isRecurringFrequency(*r.Frequency) {
+		return "frequency must be one of " + strings.Join(recurringFrequencies, ", ")
+	}
+	for _, d := range []*string{r.NextDate, r.EndDate} {
+		if d != nil && *d != "" {
+			if _, err := time.Parse("2006-01-02", *d); err != nil {
+				return "dates must be YYYY-MM-DD"
+			}
+		}
+	}
+	return ""
+}
+
+func handleCreateRecurringExpense(c *fiber.Ctx) error {
+	var req recurringExpenseRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.Name == nil || req.Amount == nil || req.Frequency == nil || req.NextDate == nil || *req.NextDate == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "name, amount, frequency and next_date required"})
+	}
+	if msg := req.validate(); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	var category, endDate interface{}
+	if req.Category != nil {
+		category = *req.Category
+	}
+	if req.EndDate != nil && *req.EndDate != "" {
+		endDate = *req.EndDate
+	}
+	id := genID()
+	if _, err := dbFor(c).Exec(`INSERT INTO recurring_expenses (id,organization_id,name,amount,category,frequency,next_date,end_date,active,created_at) VALUES (?,?,?,?,?,?,?,?,1,?)`,
+		id, currentOrgID(c), strings.TrimSpace(*req.Name), *req.Amount, category, *req.Frequency, *req.NextDate, endDate, time.Now().Format(time.RFC3339)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+func handlePatchRecurringExpense(c *fiber.Ctx) error {
+	var req recurringExpenseRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if msg := req.validate(); msg != "" {
+		return c.Status(400).JSON(fiber.Map{"error": msg})
+	}
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "recurring_expenses", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "recurring expense not found"})
+	}
+	updates := map[string]interface{}{}
+	if req.Name != nil {
+		updates["name"] = strings.TrimSpace(*req.Name)
+	}
+	if req.Amount != nil {
+		updates["amount"] = *req.Amount
+	}
+	if req.Category != nil {
+		updates["category"] = *req.Category
+	}
+	if req.Frequency != nil {
+		updates["frequency"] = *req.Frequency
+	}
+	if req.NextDate != nil {
+		if *req.NextDate == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "next_date cannot be empty"})
+		}
+		updates["next_date"] = *req.NextDate
+	}
+	if req.EndDate != nil {
+		var endDate interface{}
+		if *req.EndDate != "" {
+			endDate = *req.EndDate
+		}
+		updates["end_date"] = endDate
+	}
+	if req.Active != nil {
+		active := 0
+		if *req.Active {
+			active = 1
+		}
+		updates["active"] = active
+	}
+	for _, field := range []string{"name", "amount", "category", "frequency", "next_date", "end_date", "active"} {
+		if v, ok := updates[field]; ok {
+			if _, err := dbFor(c).Exec("UPDATE recurring_expenses SET "+field+" = ? WHERE id = ?", v, id); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+	}
+	return c.JSON(fiber.Map{"id": id})
+}
+
+// handleRecurringExpensePaid moves next_date on by one period.
+func handleRecurringExpensePaid(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var frequency, nextDate string
+	err := dbFor(c).QueryRow(`SELECT frequency, next_date FROM recurring_expenses WHERE id = ? AND organization_id = ?`, id, currentOrgID(c)).Scan(&frequency, &nextDate)
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "recurring expense not found"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	d, err := time.Parse("2006-01-02", nextDate)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "stored next_date is not a date"})
+	}
+	next := nextOccurrence(d, frequency).Format("2006-01-02")
+	if _, err := dbFor(c).Exec(`UPDATE recurring_expenses SET next_date = ? WHERE id = ?`, next, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "next_date": next})
+}
+
+func handleDeleteRecurringExpense(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`DELETE FROM recurring_expenses WHERE id = ? AND organization_id = ?`, c.Params("id"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "recurring expense not found"})
+	}
+	return c.JSON(fiber.Map{"ok": true})
+}
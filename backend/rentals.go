@@ -0,0 +1,351 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Rentable units live in inventory_items.rental_stock, separate from the
+// sellable quantity. Units currently out are the sum of rentals with
+// status "out", so availability is rental_stock minus that sum.
+
+func registerRentalRoutes(app *fiber.App) {
+	r := app.Group("/api/rentals", requireAuth)
+	r.Get("/", handleListRentals)
+	r.Post("/checkout", handleRentalCheckout)
+	r.Post("/:id/return", handleRentalReturn)
+	r.Get("/availability/:itemId", handleRentalAvailability)
+	r.Post("/items/:itemId/pool", requireRole("admin", "manager"), handleRentalPool)
+}
+
+func handleListRentals(c *fiber.Ctx) error {
+	query := `SELECT r.id,r.item_id,COALESCE(i.name, 'Unnamed Item') as item_name,r.contact_id,r.quantity,r.rate,r.checkout_at,r.due_at,r.returned_at,r.rental_amount,r.late_fee,r.status,r.notes,r.created_at FROM rentals r LEFT JOIN inventory_items i ON r.item_id = i.id WHERE r.organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	if status := c.Query("status"); status != "" {
+		query += " AND r.status = ?"
+		args = append(args, status)
+	}
+	if itemID := c.Query("item_id"); itemID != "" {
+		query += " AND r.item_id = ?"
+		args = append(args, itemID)
+	}
+	if contactID := c.Query("contact_id"); contactID != "" {
+		query += " AND r.contact_id = ?"
+		args = append(args, contactID)
+	}
+	query += " ORDER BY r.checkout_at DESC"
+	rows, err := dbFor(c).Query(query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now()
+	for _, m := range items {
+		overdue := false
+		if m["status"] == "out" {
+			if due, err := parseTime(toString(m["due_at"])); err == nil && now.After(due) {
+				overdue = true
+			}
+		}
+		m["overdue"] = overdue
+	}
+	return c.JSON(fiber.Map{"items": items, "totalItems": len(items)})
+}
+
+type rentalCheckoutRequest struct {
+	ItemID    string   `json:"item_id"`
+	ContactID string   `json:"contact_id"`
+	Quantity  int      `json:"quantity"`
+	DueAt     string   `json:"due_at"`
+	Rate      *float64 `json:"rate"`
+	Notes     string   `json:"notes"`
+}
+
+func handleRentalCheckout(c *fiber.Ctx) error {
+	var req rentalCheckoutRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if req.ItemID == "" || req.Quantity <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "item_id and a positive quantity are required"})
+	}
+	if req.Rate != nil && *req.Rate < 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "rate cannot be negative"})
+	}
+	now := time.Now()
+	due, err := parseTime(req.DueAt)
+	if err != nil || !due.After(now) {
+		return c.Status(400).JSON(fiber.Map{"error": "due_at must be a future date"})
+	}
+	orgID := currentOrgID(c)
+	if req.ContactID != "" && !orgOwns(dbFor(c), "contacts", req.ContactID, orgID) {
+		return c.Status(400).JSON(fiber.Map{"error": "unknown contact"})
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+
+	var rentalStock int
+	var rentalRate float64
+	if err := tx.QueryRow(`SELECT rental_stock, rental_rate FROM inventory_items WHERE id = ? AND organization_id = ?`, req.ItemID, orgID).Scan(&rentalStock, &rentalRate); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "item not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	out, err := unitsOnRent(tx, req.ItemID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if available := rentalStock - out; req.Quantity > available {
+		return c.Status(409).JSON(fiber.Map{"error": "not enough rental units available", "available": available})
+	}
+	rate := rentalRate
+	if req.Rate != nil {
+		rate = *req.Rate
+	}
+
+	id := genID()
+	var contactID interface{}
+	if req.ContactID != "" {
+		contactID = req.ContactID
+	}
+	_, err = tx.Exec(`INSERT INTO rentals (id,item_id,contact_id,quantity,rate,checkout_at,due_at,status,notes,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,?,?,?)`, id, req.ItemID, contactID, req.Quantity, rate, now.Format(time.RFC3339), due.Format(time.RFC3339), "out", req.Notes, orgID, now.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "due_at": due.Format(time.RFC3339), "rate": rate})
+}
+
+type rentalReturnRequest struct {
+	ReturnedAt string `json:"returned_at"`
+}
+
+func handleRentalReturn(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var req rentalReturnRequest
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	returned := time.Now()
+	if req.ReturnedAt != "" {
+		t, err := parseTime(req.ReturnedAt)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid returned_at"})
+		}
+		returned = t
+	}
+
+	var itemID, status, checkoutAt, dueAt string
+	var quantity int
+	var rate, lateFeeRate float64
+	err := dbFor(c).QueryRow(`SELECT r.item_id,r.status,r.checkout_at,r.due_at,r.quantity,r.rate,COALESCE(i.late_fee_rate, 0) FROM rentals r LEFT JOIN inventory_items i ON r.item_id = i.id WHERE r.id = ? AND r.organization_id = ?`, id, currentOrgID(c)).Scan(&itemID, &status, &checkoutAt, &dueAt, &quantity, &rate, &lateFeeRate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status != "out" {
+		return c.Status(409).JSON(fiber.Map{"error": "rental already returned"})
+	}
+	checkout, _ := parseTime(checkoutAt)
+	due, _ := parseTime(dueAt)
+
+	// charge whole days, with a minimum of one day per rental
+	days := int(math.Ceil(returned.Sub(checkout).Hours() / 24))
+	if days < 1 {
+		days = 1
+	}
+	rentalAmount := float64(days*quantity) * rate
+	lateDays := 0
+	if returned.After(due) {
+		lateDays = int(math.Ceil(returned.Sub(due).Hours() / 24))
+	}
+	lateFee := float64(lateDays*quantity) * lateFeeRate
+
+	res, err := dbFor(c).Exec(`UPDATE rentals SET status = ?, returned_at = ?, rental_amount = ?, late_fee = ? WHERE id = ? AND status = 'out'`, "returned", returned.Format(time.RFC3339), rentalAmount, lateFee, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// returned by another request since it was read
+		return c.Status(409).JSON(fiber.Map{"error": "rental already returned"})
+	}
+	return c.JSON(fiber.Map{"id": id, "item_id": itemID, "days": days, "late_days": lateDays, "rental_amount": rentalAmount, "late_fee": lateFee, "total": rentalAmount + lateFee})
+}
+
+// handleRentalAvailability returns a per-day calendar of units out and
+// available for an item between from and to (default: the next 30 days).
+// Unreturned rentals past their due date are counted as out until today.
+// Days are the server's local days, like the dates from and to are read in.
+func handleRentalAvailability(c *fiber.Ctx) error {
+	itemID := c.Params("itemId")
+	today := localDay(time.Now())
+	from, to := today, today.AddDate(0, 0, 30)
+	if v := c.Query("from"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid from"})
+		}
+		from = localDay(t)
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid to"})
+		}
+		to = localDay(t)
+	}
+	if to.Before(from) || to.Sub(from) > 366*24*time.Hour {
+		return c.Status(400).JSON(fiber.Map{"error": "date range must be between 0 and 366 days"})
+	}
+
+	var rentalStock int
+	if err := dbFor(c).QueryRow(`SELECT rental_stock FROM inventory_items WHERE id = ? AND organization_id = ?`, itemID, currentOrgID(c)).Scan(&rentalStock); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "item not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	type span struct {
+		start, end time.Time
+		qty        int
+	}
+	var spans []span
+	rows, err := dbFor(c).Query(`SELECT checkout_at, due_at, returned_at, quantity FROM rentals WHERE item_id = ?`, itemID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		var checkoutAt, dueAt string
+		var returnedAt sql.NullString
+		var qty int
+		if err := rows.Scan(&checkoutAt, &dueAt, &returnedAt, &qty); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		start, _ := parseTime(checkoutAt)
+		end, _ := parseTime(dueAt)
+		if returnedAt.Valid && returnedAt.String != "" {
+			end, _ = parseTime(returnedAt.String)
+		} else if end.Before(today) {
+			end = today
+		}
+		spans = append(spans, span{start: start, end: end, qty: qty})
+	}
+	rows.Close()
+
+	days := []fiber.Map{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		dayEnd := d.AddDate(0, 0, 1)
+		out := 0
+		for _, s := range spans {
+			if s.start.Before(dayEnd) && !s.end.Before(d) {
+				out += s.qty
+			}
+		}
+		days = append(days, fiber.Map{"date": d.Format("2006-01-02"), "out": out, "available": rentalStock - out})
+	}
+	return c.JSON(fiber.Map{"item_id": itemID, "rental_stock": rentalStock, "days": days})
+}
+
+// localDay is the start of the local day t falls on.
+func localDay(t time.Time) time.Time {
+	t = t.In(time.Local)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
+
+type rentalPoolRequest struct {
+	Quantity    int      `json:"quantity"`
+	RentalRate  *float64 `json:"rental_rate"`
+	LateFeeRate *float64 `json:"late_fee_rate"`
+}
+
+// handleRentalPool moves units between sellable stock and the rental pool
+// (negative quantity moves them back) and updates the item's rental rates.
+func handleRentalPool(c *fiber.Ctx) error {
+	itemID := c.Params("itemId")
+	var req rentalPoolRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	if (req.RentalRate != nil && *req.RentalRate < 0) || (req.LateFeeRate != nil && *req.LateFeeRate < 0) {
+		return c.Status(400).JSON(fiber.Map{"error": "rates cannot be negative"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+
+	var quantity, rentalStock int
+	if err := tx.QueryRow(`SELECT quantity, rental_stock FROM inventory_items WHERE id = ? AND organization_id = ?`, itemID, currentOrgID(c)).Scan(&quantity, &rentalStock); err != nil {
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "item not found"})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now().Format(time.RFC3339)
+	if req.Quantity != 0 {
+		if req.Quantity > quantity {
+			return c.Status(409).JSON(fiber.Map{"error": "not enough sellable stock", "available": quantity})
+		}
+		if req.Quantity < 0 {
+			out, err := unitsOnRent(tx, itemID)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if -req.Quantity > rentalStock-out {
+				return c.Status(409).JSON(fiber.Map{"error": "units are out on rent", "available": rentalStock - out})
+			}
+		}
+		newQty := quantity - req.Quantity
+		if _, err := tx.Exec(`UPDATE inventory_items SET quantity = ?, rental_stock = ?, updated_at = ? WHERE id = ?`, newQty, rentalStock+req.Quantity, now, itemID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		stockChanged(tx, itemID)
+		if _, err := tx.Exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,organization_id,created_at) VALUES (?,?,?,?,?,?,?,`+itemOrgSQL+`,?)`, genID(), itemID, -req.Quantity, quantity, newQty, "rental_pool", "Moved to/from rental pool", itemID, now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		quantity = newQty
+		rentalStock += req.Quantity
+	}
+	if req.RentalRate != nil {
+		if _, err := tx.Exec(`UPDATE inventory_items SET rental_rate = ? WHERE id = ?`, *req.RentalRate, itemID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if req.LateFeeRate != nil {
+		if _, err := tx.Exec(`UPDATE inventory_items SET late_fee_rate = ? WHERE id = ?`, *req.LateFeeRate, itemID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": itemID, "quantity": quantity, "rental_stock": rentalStock})
+}
+
+func unitsOnRent(tx *Tx, itemID string) (int, error) {
+	var out int
+	err := tx.QueryRow(`SELECT COALESCE(SUM(quantity), 0) FROM rentals WHERE item_id = ? AND status = 'out'`, itemID).Scan(&out)
+	return out, err
+}
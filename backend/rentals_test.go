@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRentals(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Tent','TENT',10,500,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	pool := `{"quantity":4,"rental_rate":100,"late_fee_rate":50}`
+	if code, _ := call("cashier", "POST", "/api/rentals/items/i-1/pool", pool); code != 403 {
+		t.Errorf("cashier moving stock to the rental pool: got %d, want 403", code)
+	}
+	if code, _ := call("manager", "POST", "/api/rentals/items/i-1/pool", `{"rental_rate":-1}`); code != 400 {
+		t.Errorf("negative rental rate: got %d, want 400", code)
+	}
+	if code, out := call("manager", "POST", "/api/rentals/items/i-1/pool", pool); code != 200 || out["rental_stock"] != 4.0 || out["quantity"] != 6.0 {
+		t.Fatalf("pool: %d %v", code, out)
+	}
+
+	day := func(n int) string { return localDay(time.Now()).AddDate(0, 0, n).Format("2006-01-02") }
+	if code, _ := call("cashier", "POST", "/api/rentals/checkout", `{"item_id":"i-1","quantity":1,"due_at":"`+day(3)+`","rate":-10}`); code != 400 {
+		t.Errorf("negative rate: got %d, want 400", code)
+	}
+	code, first := call("cashier", "POST", "/api/rentals/checkout", `{"item_id":"i-1","contact_id":"c-1","quantity":3,"due_at":"`+day(3)+`"}`)
+	if code != 200 || first["rate"] != 100.0 {
+		t.Fatalf("checkout: %d %v", code, first)
+	}
+	if code, out := call("cashier", "POST", "/api/rentals/checkout", `{"item_id":"i-1","quantity":2,"due_at":"`+day(5)+`"}`); code != 409 || out["available"] != 1.0 {
+		t.Errorf("checking out more than is left: %d %v", code, out)
+	}
+	code, second := call("cashier", "POST", "/api/rentals/checkout", `{"item_id":"i-1","quantity":1,"due_at":"`+day(5)+`"}`)
+	if code != 200 {
+		t.Fatalf("second checkout: %d %v", code, second)
+	}
+
+	// both out over the first three days, only the second after
+	_, cal := call("cashier", "GET", "/api/rentals/availability/i-1?from="+day(0)+"&to="+day(6), "")
+	days, _ := cal["days"].([]interface{})
+	if len(days) != 7 {
+		t.Fatalf("calendar: %v", cal)
+	}
+	for i, want := range []float64{4, 4, 4, 4, 1, 1, 0} {
+		d := days[i].(map[string]interface{})
+		if d["date"] != day(i) || d["out"] != want {
+			t.Errorf("day %d: %v, want %v out", i, d, want)
+		}
+	}
+
+	ret := "/api/rentals/" + toString(first["id"]) + "/return"
+	if code, out := call("cashier", "POST", ret, ""); code != 200 || out["days"] != 1.0 || out["rental_amount"] != 300.0 || out["late_fee"] != 0.0 {
+		t.Errorf("return: %d %v", code, out)
+	}
+	if code, _ := call("cashier", "POST", ret, ""); code != 409 {
+		t.Errorf("returning twice: got %d, want 409", code)
+	}
+	var onRent int
+	if err := db.QueryRow(`SELECT COALESCE(SUM(quantity), 0) FROM rentals WHERE item_id = 'i-1' AND status = 'out'`).Scan(&onRent); err != nil || onRent != 1 {
+		t.Errorf("units on rent after the return: %v %d", err, onRent)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Reorder suggestions size purchase orders from how fast items sell. Sales
+// velocity is the units sold (sale movements, transaction_type inflow)
+// over the last days days, per day. An item is due for reordering when its
+// quantity plus what is already on order (open purchase orders, drafts
+// included) is at or below its reorder point: the larger of its
+// reorder_level and what sells during lead_days. The suggested quantity
+// brings it back up to the reorder point plus cover_days of sales.
+
+// reorderParam reads a whole-day query parameter between 0 (or 1 when
+// zero is not allowed) and 365.
+func reorderParam(c *fiber.Ctx, name string, def int, allowZero bool) (int, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n > 365 || n < 0 || (n == 0 && !allowZero) {
+		return 0, false
+	}
+	return n, true
+}
+
+// handleReorderSuggestions lists the items to reorder with suggested
+// quantities and their cost, and per supplier the lines of an order to
+// place (items without a supplier are grouped together). Optional
+// supplier_id narrows the list to one supplier's items.
+func handleReorderSuggestions(c *fiber.Ctx) error {
+	days, ok := reorderParam(c, "days", 30, false)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "days must be a whole number from 1 to 365"})
+	}
+	leadDays, ok := reorderParam(c, "lead_days", 7, true)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "lead_days must be a whole number from 0 to 365"})
+	}
+	coverDays, ok := reorderParam(c, "cover_days", 30, true)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "cover_days must be a whole number from 0 to 365"})
+	}
+	orgID := currentOrgID(c)
+	since := time.Now().AddDate(0, 0, -days)
+
+	sold := map[string]int{}
+	rows, err := dbFor(c).Query(`SELECT item_id, -SUM(quantity_change) FROM inventory_transactions WHERE organization_id = ? AND transaction_type = 'inflow' AND quantity_change < 0 AND created_at >= ? GROUP BY item_id`, orgID, since.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		var itemID string
+		var n int
+		if err := rows.Scan(&itemID, &n); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		sold[itemID] = n
+	}
+	rows.Close()
+
+	onOrder := map[string]int{}
+	rows, err = dbFor(c).Query(`SELECT l.item_id, SUM(l.quantity - l.received_quantity) FROM purchase_order_items l JOIN purchase_orders p ON p.id = l.purchase_order_id WHERE p.organization_id = ? AND p.status IN ('draft','sent','partially_received') AND l.quantity > l.received_quantity GROUP BY l.item_id`, orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		var itemID string
+		var n int
+		if err := rows.Scan(&itemID, &n); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		onOrder[itemID] = n
+	}
+	rows.Close()
+
+	query := `SELECT i.id, COALESCE(i.name, 'Unnamed Item'), COALESCE(i.sku, ''), i.quantity, i.reorder_level, i.cost_price, i.supplier_id, s.name FROM inventory_items i LEFT JOIN contacts s ON s.id = i.supplier_id WHERE i.organization_id = ?`
+	args := []interface{}{orgID}
+	if supplierID := c.Query("supplier_id"); supplierID != "" {
+		query += ` AND i.supplier_id = ?`
+		args = append(args, supplierID)
+	}
+	rows, err = dbFor(c).Query(query+` ORDER BY i.name`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+
+	items := []fiber.Map{}
+	suppliers := map[string]fiber.Map{}
+	totalCost := 0.0
+	for rows.Next() {
+		var id, name, sku string
+		var quantity, reorderLevel int
+		var costPrice float64
+		var supplierID, supplierName sql.NullString
+		if err := rows.Scan(&id, &name, &sku, &quantity, &reorderLevel, &costPrice, &supplierID, &supplierName); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		velocity := float64(sold[id]) / float64(days)
+		if velocity == 0 && reorderLevel <= 0 {
+			continue
+		}
+		reorderPoint := int(math.Max(float64(reorderLevel), math.Ceil(velocity*float64(leadDays))))
+		available := quantity + onOrder[id]
+		if available > reorderPoint {
+			continue
+		}
+		suggested := reorderPoint + int(math.Ceil(velocity*float64(coverDays))) - available
+		if suggested < 1 {
+			suggested = 1
+		}
+		cost := round2(float64(suggested) * costPrice)
+		item := fiber.Map{
+			"item_id":            id,
+			"name":               name,
+			"sku":                sku,
+			"supplier_id":        nil,
+			"supplier_name":      nil,
+			"quantity":           quantity,
+			"on_order":           onOrder[id],
+			"reorder_level":      reorderLevel,
+			"reorder_point":      reorderPoint,
+			"sold":               sold[id],
+			"daily_sales":        round2(velocity),
+			"days_of_stock":      nil,
+			"suggested_quantity": suggested,
+			"unit_cost":          costPrice,
+			"estimated_cost":     cost,
+		}
+		if velocity > 0 {
+			item["days_of_stock"] = round2(math.Max(0, float64(quantity)) / velocity)
+		}
+		key := ""
+		if supplierID.Valid && supplierID.String != "" {
+			key = supplierID.String
+			item["supplier_id"] = supplierID.String
+			if supplierName.Valid {
+				item["supplier_name"] = supplierName.String
+			}
+		}
+		group, ok := suppliers[key]
+		if !ok {
+			group = fiber.Map{"supplier_id": item["supplier_id"], "supplier_name": item["supplier_name"], "items": []fiber.Map{}, "estimated_cost": 0.0}
+			suppliers[key] = group
+		}
+		group["items"] = append(group["items"].([]fiber.Map), fiber.Map{"item_id": id, "name": name, "sku": sku, "quantity": suggested, "unit_cost": costPrice})
+		group["estimated_cost"] = round2(group["estimated_cost"].(float64) + cost)
+		totalCost += cost
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	// suppliers with the most to order first; items without one last
+	bySupplier := []fiber.Map{}
+	for _, g := range suppliers {
+		bySupplier = append(bySupplier, g)
+	}
+	sort.SliceStable(bySupplier, func(i, j int) bool {
+		a, b := bySupplier[i], bySupplier[j]
+		if (a["supplier_id"] == nil) != (b["supplier_id"] == nil) {
+			return b["supplier_id"] == nil
+		}
+		return a["estimated_cost"].(float64) > b["estimated_cost"].(float64)
+	})
+	return c.JSON(fiber.Map{
+		"days":           days,
+		"lead_days":      leadDays,
+		"cover_days":     coverDays,
+		"items":          items,
+		"by_supplier":    bySupplier,
+		"estimated_cost": round2(totalCost),
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReorderSuggestions(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	ago := func(days int) string { return time.Now().AddDate(0, 0, -days).Format(time.RFC3339) }
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Paper Co','018','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,reorder_level,supplier_id,organization_id) VALUES
+			('i-1','Pen','PEN',10,5,2,0,'s-1','org-1'),
+			('i-2','Ink','INK',5,20,10,8,'s-1','org-1'),
+			('i-3','Paper','PAP',100,8,4,0,'s-1','org-1'),
+			('i-4','Clip','CLP',0,2,1,3,NULL,'org-1'),
+			('i-5','Other','OTH',0,2,1,3,NULL,'org-2')`,
+		`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,organization_id,created_at) VALUES
+			('m-1','i-1',-60,70,10,'inflow','org-1','` + ago(2) + `'),
+			('m-2','i-1',50,20,70,'outflow','org-1','` + ago(3) + `'),
+			('m-3','i-3',-30,130,100,'inflow','org-1','` + ago(5) + `'),
+			('m-4','i-3',-1000,1130,130,'inflow','org-1','` + ago(40) + `')`,
+		`INSERT INTO purchase_orders (id,organization_id,number,supplier_id,status) VALUES ('po-1','org-1','PO-1','s-1','sent'),('po-2','org-1','PO-2','s-1','received')`,
+		`INSERT INTO purchase_order_items (id,purchase_order_id,item_id,quantity,unit_cost,received_quantity) VALUES
+			('pl-1','po-1','i-2',5,10,3),
+			('pl-2','po-2','i-4',10,1,10)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	get := func(path string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	_, out := get("/api/reports/reorder-suggestions")
+	items := map[string]map[string]interface{}{}
+	for _, it := range out["items"].([]interface{}) {
+		it := it.(map[string]interface{})
+		items[it["item_id"].(string)] = it
+	}
+	if len(items) != 3 || items["i-3"] != nil {
+		t.Fatalf("suggested items = %v, want Pen, Ink and Clip", out["items"])
+	}
+	// Pen sells 2 a day: reorder point 14 (a week), order up to 14 + 60
+	if pen := items["i-1"]; pen["daily_sales"] != 2.0 || pen["reorder_point"] != 14.0 || pen["suggested_quantity"] != 64.0 || pen["estimated_cost"] != 128.0 || pen["days_of_stock"] != 5.0 {
+		t.Errorf("pen = %v", pen)
+	}
+	// Ink has 2 still to arrive on an open order
+	if ink := items["i-2"]; ink["on_order"] != 2.0 || ink["suggested_quantity"] != 1.0 || ink["days_of_stock"] != nil {
+		t.Errorf("ink = %v", ink)
+	}
+	if clip := items["i-4"]; clip["on_order"] != 0.0 || clip["suggested_quantity"] != 3.0 || clip["supplier_id"] != nil {
+		t.Errorf("clip = %v", clip)
+	}
+	if out["estimated_cost"] != 141.0 {
+		t.Errorf("estimated_cost = %v, want 141", out["estimated_cost"])
+	}
+	groups := out["by_supplier"].([]interface{})
+	if len(groups) != 2 {
+		t.Fatalf("by_supplier = %v", groups)
+	}
+	first := groups[0].(map[string]interface{})
+	if first["supplier_id"] != "s-1" || first["supplier_name"] != "Paper Co" || first["estimated_cost"] != 138.0 || len(first["items"].([]interface{})) != 2 {
+		t.Errorf("first supplier group = %v", first)
+	}
+	if groups[1].(map[string]interface{})["supplier_id"] != nil {
+		t.Errorf("items without a supplier should come last: %v", groups)
+	}
+
+	// a longer lead time makes Paper due too
+	if _, out := get("/api/reports/reorder-suggestions?lead_days=120&supplier_id=s-1"); len(out["items"].([]interface{})) != 3 {
+		t.Errorf("with lead_days=120 for s-1: %v", out["items"])
+	}
+	if code, _ := get("/api/reports/reorder-suggestions?days=0"); code != 400 {
+		t.Errorf("days=0: got %d, want 400", code)
+	}
+}
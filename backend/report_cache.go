@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Heavy reports are cached in memory keyed by organization, path + query
+// parameters and the data version at render time. Every successful write request bumps the
+// version, so a cached body is only served while nothing has changed since
+// it was rendered. External systems that modify the database directly can
+// call POST /api/reports/cache/invalidate to do the same.
+
+var dataVersion int64
+
+type reportCacheEntry struct {
+	version   int64
+	body      []byte
+	createdAt time.Time
+}
+
+var reportCache = struct {
+	sync.Mutex
+	entries map[string]reportCacheEntry
+	hits    int64
+	misses  int64
+}{entries: map[string]reportCacheEntry{}}
+
+const reportCacheMaxEntries = 200
+
+func reportCacheTTL() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("REPORT_CACHE_TTL_SECONDS")); err == nil && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return 10 * time.Minute
+}
+
+// trackWrites bumps the data version after every successful mutating
+// request so cached reports are invalidated.
+func trackWrites(c *fiber.Ctx) error {
+	err := c.Next()
+	if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead && c.Response().StatusCode() < 400 {
+		atomic.AddInt64(&dataVersion, 1)
+	}
+	return err
+}
+
+// cachedReport serves a report from cache when the stored copy matches the
+// current data version, and stores fresh 200 responses otherwise. The
+// X-Cache header reports HIT or MISS and X-Cache-Version the data version.
+func cachedReport(c *fiber.Ctx) error {
+	key := reportCacheKey(c)
+	version := atomic.LoadInt64(&dataVersion)
+	c.Set("X-Cache-Version", strconv.FormatInt(version, 10))
+
+	reportCache.Lock()
+	entry, ok := reportCache.entries[key]
+	if ok && entry.version == version && time.Since(entry.createdAt) < reportCacheTTL() {
+		reportCache.hits++
+		reportCache.Unlock()
+		c.Set("X-Cache", "HIT")
+		c.Set("Age", strconv.Itoa(int(time.Since(entry.createdAt).Seconds())))
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Send(entry.body)
+	}
+	reportCache.misses++
+	reportCache.Unlock()
+
+	c.Set("X-Cache", "MISS")
+	if err := c.Next(); err != nil {
+		return err
+	}
+	if c.Response().StatusCode() != fiber.StatusOK {
+		return nil
+	}
+	body := append([]byte(nil), c.Response().Body()...)
+	reportCache.Lock()
+	if len(reportCache.entries) >= reportCacheMaxEntries {
+		evictOldestReport()
+	}
+	reportCache.entries[key] = reportCacheEntry{version: version, body: body, createdAt: time.Now()}
+	reportCache.Unlock()
+	return nil
+}
+
+// reportCacheKey identifies a report for the caller's organization, so one
+// organization is never served another's cached figures. cachedReport runs
+// after requireAuth.
+func reportCacheKey(c *fiber.Ctx) string {
+	params := []string{}
+	c.Context().QueryArgs().VisitAll(func(k, v []byte) {
+		params = append(params, string(k)+"="+string(v))
+	})
+	sort.Strings(params)
+	return currentOrgID(c) + ":" + c.Path() + "?" + strings.Join(params, "&")
+}
+
+// evictOldestReport drops the oldest entry; callers hold the lock.
+func evictOldestReport() {
+	oldestKey := ""
+	var oldest time.Time
+	for k, e := range reportCache.entries {
+		if oldestKey == "" || e.createdAt.Before(oldest) {
+			oldestKey, oldest = k, e.createdAt
+		}
+	}
+	delete(reportCache.entries, oldestKey)
+}
+
+func registerReportCacheRoutes(app *fiber.App) {
+	app.Get("/api/reports/cache", requireAuth, handleReportCacheStatus)
+	app.Post("/api/reports/cache/invalidate", requireAuth, requireRole("admin", "manager"), handleReportCacheInvalidate)
+}
+
+// handleReportCacheStatus lists the cached reports of the caller's
+// organization.
+func handleReportCacheStatus(c *fiber.Ctx) error {
+	prefix := currentOrgID(c) + ":"
+	reportCache.Lock()
+	defer reportCache.Unlock()
+	keys := []string{}
+	for k := range reportCache.entries {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, strings.TrimPrefix(k, prefix))
+		}
+	}
+	sort.Strings(keys)
+	return c.JSON(fiber.Map{"version": atomic.LoadInt64(&dataVersion), "entries": len(keys), "keys": keys, "hits": reportCache.hits, "misses": reportCache.misses})
+}
+
+func handleReportCacheInvalidate(c *fiber.Ctx) error {
+	version := atomic.AddInt64(&dataVersion, 1)
+	reportCache.Lock()
+	reportCache.entries = map[string]reportCacheEntry{}
+	reportCache.Unlock()
+	return c.JSON(fiber.Map{"ok": true, "version": version})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A cached report is served until a write changes the data, and never to
+// another organization.
+func TestReportCache(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active'),('org-2','Theirs','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-1','inflow',4500,4500,0,'c-1','org-1','` + time.Now().Add(-time.Hour).Format(time.RFC3339) + `')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	reportCache.Lock()
+	reportCache.entries = map[string]reportCacheEntry{}
+	reportCache.Unlock()
+	mine := testToken(t, "manager")
+	now := time.Now()
+	other, err := signToken(authClaims{Subject: "user-other", OrgID: "org-2", Role: "admin", Issued: now.Unix(), Expires: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := newApp()
+	call := func(token, method, path, body string) (int, string, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, resp.Header.Get("X-Cache"), out
+	}
+	from, to := now.AddDate(0, 0, -1).Format("2006-01-02"), now.AddDate(0, 0, 1).Format("2006-01-02")
+	report := "/api/reports/profit-loss?from=" + from + "&to=" + to
+	cached := func(token, path, want string) {
+		t.Helper()
+		if code, cache, _ := call(token, "GET", path, ""); code != 200 || cache != want {
+			t.Fatalf("%s: %d, X-Cache %q, want %s", path, code, cache, want)
+		}
+	}
+
+	cached(mine, report, "MISS")
+	cached(mine, report, "HIT")
+	// the same parameters in another order are the same report
+	cached(mine, "/api/reports/profit-loss?to="+to+"&from="+from, "HIT")
+	cached(other, report, "MISS")
+
+	// a refused write leaves the cache alone; a sale invalidates it
+	if code, _, _ := call(mine, "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":-1}`); code < 400 {
+		t.Fatalf("bad sale accepted: %d", code)
+	}
+	cached(mine, report, "HIT")
+	if code, _, out := call(mine, "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":30,"contact_id":"c-1","payments":[{"method":"cash","amount":30}]}`); code != 200 {
+		t.Fatalf("sale: %d %v", code, out)
+	}
+	code, cache, pl := call(mine, "GET", report, "")
+	if code != 200 || cache != "MISS" || pl["sales"] != 75.0 {
+		t.Errorf("after a sale: %d %s %v", code, cache, pl)
+	}
+	cached(mine, report, "HIT")
+
+	_, _, status := call(mine, "GET", "/api/reports/cache", "")
+	if keys, _ := status["keys"].([]interface{}); len(keys) != 1 || !strings.HasPrefix(toString(keys[0]), "/api/reports/profit-loss?") {
+		t.Errorf("cache status: %v", status)
+	}
+	if code, _, _ := call(testToken(t, "cashier"), "POST", "/api/reports/cache/invalidate", ""); code != 403 {
+		t.Errorf("cashier invalidating: got %d, want 403", code)
+	}
+	if code, _, _ := call(mine, "POST", "/api/reports/cache/invalidate", ""); code != 200 {
+		t.Errorf("invalidate: got %d", code)
+	}
+	cached(mine, report, "MISS")
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Financial reports are computed directly from transactions, transaction
+// items and inventory movements. Opening-balance transactions (source =
+// 'opening') carry balances only and are left out of period income.
+
+func registerReportRoutes(app *fiber.App) {
+	r := app.Group("/api/reports", requireAuth)
+	r.Get("/stock-valuation", cachedReport, handleStockValuation)
+	r.Get("/profit-loss", cachedReport, handleProfitLoss)
+	r.Get("/balance-sheet", cachedReport, handleBalanceSheet)
+	r.Get("/inventory-aging", cachedReport, handleInventoryAging)
+	r.Get("/sales-heatmap", cachedReport, handleSalesHeatmap)
+	r.Get("/inventory-valuation", cachedReport, handleInventoryValuation)
+	r.Get("/reorder-suggestions", cachedReport, handleReorderSuggestions)
+}
+
+// reportPeriod reads from/to query params, defaulting to the current month
+// up to now. to is exclusive.
+func reportPeriod(c *fiber.Ctx) (time.Time, time.Time, error) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	to := now
+	if v := c.Query("from"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return from, to, fiber.NewError(400, "invalid from")
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return from, to, fiber.NewError(400, "invalid to")
+		}
+		to = t
+	}
+	return from, to, nil
+}
+
+func handleStockValuation(c *fiber.Ctx) error {
+	asOf := time.Now()
+	if v := c.Query("as_of"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid as_of"})
+		}
+		asOf = t
+	}
+	items, total, err := stockValuation(c.UserContext(), dbFor(c), currentOrgID(c), asOf)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"as_of": asOf.Format(time.RFC3339), "items": items, "total_value": total})
+}
+
+func handleProfitLoss(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	pl, err := profitAndLoss(c.UserContext(), dbFor(c), currentOrgID(c), from, to)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(pl)
+}
+
+func handleBalanceSheet(c *fiber.Ctx) error {
+	asOf := time.Now()
+	if v := c.Query("as_of"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid as_of"})
+		}
+		asOf = t
+	}
+	bs, err := balanceSheet(c.UserContext(), dbFor(c), currentOrgID(c), asOf)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(bs)
+}
+
+// stockValuation values the stock orgID owns at asOf. Quantities are rolled
+// back from the current quantity using movements recorded after asOf;
+// supplier-owned inward consignment is excluded and our stock out on
+// consignment is included, both as they stood at asOf. Items are valued at
+// cost_price, falling back to unit_price for items without a recorded cost.
+func stockValuation(ctx context.Context, q execer, orgID string, asOf time.Time) ([]fiber.Map, float64, error) {
+	a := asOf.Format(time.RFC3339)
+	rows, err := q.QueryContext(ctx, `SELECT i.id, COALESCE(i.name, 'Unnamed Item'), COALESCE(i.sku, ''), i.quantity - COALESCE((SELECT SUM(quantity_change) FROM inventory_transactions WHERE item_id = i.id AND created_at > ?), 0), i.unit_price, i.cost_price,
+		`+consignedAsOfSQL("inward")+`,
+		`+consignedAsOfSQL("outward")+`
+		FROM inventory_items i WHERE i.organization_id = ? ORDER BY i.name`, a, a, a, a, a, a, a, orgID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	items := []fiber.Map{}
+	total := 0.0
+	for rows.Next() {
+		var id, name, sku string
+		var quantity, inward, outward int
+		var unitPrice, costPrice float64
+		if err := rows.Scan(&id, &name, &sku, &quantity, &unitPrice, &costPrice, &inward, &outward); err != nil {
+			return nil, 0, err
+		}
+		owned := quantity - inward + outward
+		unitValue := costPrice
+		if unitValue == 0 {
+			unitValue = unitPrice
+		}
+		value := float64(owned) * unitValue
+		total += value
+		items = append(items, fiber.Map{"item_id": id, "name": name, "sku": sku, "quantity": quantity, "consigned_in": inward, "consigned_out": outward, "owned_quantity": owned, "unit_value": unitValue, "value": value})
+	}
+	return items, total, rows.Err()
+}
+
+// consignedAsOfSQL is a subquery for the units of item i out on (or in
+// from) consignment in the given direction as of the report date: units
+// received or sent by then, less units sold and returned by then. Returns
+// are the consignment_return stock movements; inward returns take stock
+// out and outward returns bring it back, so the sign tells them apart.
+// It takes three as-of parameters.
+func consignedAsOfSQL(direction string) string {
+	returned := "-quantity_change"
+	sign := "< 0"
+	if direction == "outward" {
+		returned, sign = "quantity_change", "> 0"
+	}
+	return `(COALESCE((SELECT SUM(quantity) FROM consignments WHERE item_id = i.id AND direction = '` + direction + `' AND created_at <= ?), 0)
+		- COALESCE((SELECT SUM(s.quantity) FROM consignment_sales s JOIN consignments cs ON s.consignment_id = cs.id WHERE cs.item_id = i.id AND cs.direction = '` + direction + `' AND s.created_at <= ?), 0)
+		- COALESCE((SELECT SUM(` + returned + `) FROM inventory_transactions WHERE item_id = i.id AND transaction_type = 'consignment_return' AND quantity_change ` + sign + ` AND created_at <= ?), 0))`
+}
+
+// profitAndLoss summarizes orgID's sales, cost of goods sold (at item
+// cost_price) and purchases for [from, to).
+func profitAndLoss(ctx context.Context, q execer, orgID string, from, to time.Time) (fiber.Map, error) {
+	f, t := from.Format(time.RFC3339), to.Format(time.RFC3339)
+	var salesTotal, purchaseTotal money
+	var salesCount, purchaseCount int
+	err := q.QueryRowContext(ctx, `SELECT COALESCE(SUM(CASE WHEN type = 'inflow' THEN amount END), 0), COUNT(CASE WHEN type = 'inflow' THEN 1 END), COALESCE(SUM(CASE WHEN type = 'outflow' THEN amount END), 0), COUNT(CASE WHEN type = 'outflow' THEN 1 END) FROM transactions WHERE organization_id = ? AND created_at >= ? AND created_at < ? AND COALESCE(source, '') <> 'opening' AND voided_at IS NULL`, orgID, f, t).Scan(&salesTotal, &salesCount, &purchaseTotal, &purchaseCount)
+	if err != nil {
+		return nil, err
+	}
+	sales, purchases, cogs := salesTotal.float(), purchaseTotal.float(), 0.0
+	err = q.QueryRowContext(ctx, `SELECT COALESCE(SUM(ti.quantity * i.cost_price), 0) FROM transaction_items ti JOIN transactions tr ON ti.transaction_id = tr.id JOIN inventory_items i ON ti.item_id = i.id WHERE tr.organization_id = ? AND tr.type = 'inflow' AND tr.created_at >= ? AND tr.created_at < ? AND COALESCE(tr.source, '') <> 'opening' AND tr.voided_at IS NULL`, orgID, f, t).Scan(&cogs)
+	if err != nil {
+		return nil, err
+	}
+	// returns are netted out in the period their credit note is issued
+	var salesReturns, purchaseReturns, returnedCost float64
+	err = q.QueryRowContext(ctx, `SELECT COALESCE(SUM(CASE WHEN type = 'inflow' THEN amount END), 0), COALESCE(SUM(CASE WHEN type = 'outflow' THEN amount END), 0) FROM credit_notes WHERE organization_id = ? AND created_at >= ? AND created_at < ?`, orgID, f, t).Scan(&salesReturns, &purchaseReturns)
+	if err != nil {
+		return nil, err
+	}
+	err = q.QueryRowContext(ctx, `SELECT COALESCE(SUM(ci.quantity * i.cost_price), 0) FROM credit_note_items ci JOIN credit_notes n ON ci.credit_note_id = n.id JOIN inventory_items i ON ci.item_id = i.id WHERE n.organization_id = ? AND n.type = 'inflow' AND n.created_at >= ? AND n.created_at < ?`, orgID, f, t).Scan(&returnedCost)
+	if err != nil {
+		return nil, err
+	}
+	netSales := sales - salesReturns
+	cogs -= returnedCost
+	return fiber.Map{
+		"from":             f,
+		"to":               t,
+		"sales":            sales,
+		"sales_count":      salesCount,
+		"sales_returns":    salesReturns,
+		"net_sales":        netSales,
+		"cogs":             cogs,
+		"gross_profit":     netSales - cogs,
+		"purchases":        purchases,
+		"purchase_count":   purchaseCount,
+		"purchase_returns": purchaseReturns,
+	}, nil
+}
+
+// balanceSheet reports orgID's cash, receivables and stock against
+// payables as of asOf. Cash starts from the opening cash balance and moves with the paid
+// part of every transaction and with refunds; equity is the balancing figure.
+// Cash carried forward by a fiscal year close is already in those figures
+// and is not counted again.
+func balanceSheet(ctx context.Context, q execer, orgID string, asOf time.Time) (fiber.Map, error) {
+	a := asOf.Format(time.RFC3339)
+	var openingCash float64
+	var paidIn, paidOut, dueIn, dueOut money
+	if err := q.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount), 0) FROM opening_balances WHERE organization_id = ? AND kind = 'cash' AND fiscal_year_id IS NULL AND cutover_date <= ?`, orgID, asOf.Format("2006-01-02")).Scan(&openingCash); err != nil {
+		return nil, err
+	}
+	err := q.QueryRowContext(ctx, `SELECT COALESCE(SUM(CASE WHEN type = 'inflow' THEN paid_amount END), 0), COALESCE(SUM(CASE WHEN type = 'outflow' THEN paid_amount END), 0), COALESCE(SUM(CASE WHEN type = 'inflow' THEN due_amount END), 0), COALESCE(SUM(CASE WHEN type = 'outflow' THEN due_amount END), 0) FROM transactions WHERE organization_id = ? AND created_at <= ? AND voided_at IS NULL`, orgID, a).Scan(&paidIn, &paidOut, &dueIn, &dueOut)
+	if err != nil {
+		return nil, err
+	}
+	cashIn, cashOut, receivables, payables := paidIn.float(), paidOut.float(), dueIn.float(), dueOut.float()
+	var refunded, refundsReceived float64
+	err = q.QueryRowContext(ctx, `SELECT COALESCE(SUM(CASE WHEN type = 'inflow' THEN amount END), 0), COALESCE(SUM(CASE WHEN type = 'outflow' THEN amount END), 0) FROM refunds WHERE organization_id = ? AND created_at <= ?`, orgID, a).Scan(&refunded, &refundsReceived)
+	if err != nil {
+		return nil, err
+	}
+	_, stock, err := stockValuation(ctx, q, orgID, asOf)
+	if err != nil {
+		return nil, err
+	}
+	cash := openingCash + cashIn - cashOut - refunded + refundsReceived
+	assets := cash + receivables + stock
+	return fiber.Map{
+		"as_of": a,
+		"assets": fiber.Map{
+			"cash":        cash,
+			"receivables": receivables,
+			"inventory":   stock,
+			"total":       assets,
+		},
+		"liabilities": fiber.Map{
+			"payables": payables,
+			"total":    payables,
+		},
+		"equity": assets - payables,
+	}, nil
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Contacts, items and transactions are read and written through typed
+// repositories instead of scanning into interface{} maps. A repository
+// runs on whatever it is given, the database or a transaction, and takes
+// the request's context; every Scan and Exec error is returned to the
+// caller. Missing rows come back as errNotFound.
+
+var errNotFound = errors.New("not found")
+
+// execer is the database or a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// notFound turns sql.ErrNoRows into errNotFound.
+func notFound(err error) error {
+	if err == sql.ErrNoRows {
+		return errNotFound
+	}
+	return err
+}
+
+// nullableString passes a NULL for nil.
+func nullableString(s *string) interface{} {
+	if s == nil {
+		return nil
+	}
+	return *s
+}
+
+type Contact struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	Phone          string `json:"phone"`
+	Email          string `json:"email,omitempty"`
+	NID            string `json:"nid"`
+	Type           string `json:"type"`
+	PriceListID    string `json:"price_list_id,omitempty"`
+	SMSOptOut      bool   `json:"sms_opt_out"`
+	OrganizationID string `json:"organization_id"`
+}
+
+type ContactRepo struct{ q execer }
+
+func Contacts(q execer) ContactRepo { return ContactRepo{q} }
+
+// Get returns orgID's contact id.
+func (r ContactRepo) Get(ctx context.Context, orgID, id string) (Contact, error) {
+	var ct Contact
+	err := r.q.QueryRowContext(ctx, `SELECT id, name, phone, COALESCE(email, ''), COALESCE(nid, ''), type, COALESCE(price_list_id, ''), sms_opt_out, organization_id FROM contacts WHERE id = ? AND organization_id = ?`, id, orgID).
+		Scan(&ct.ID, &ct.Name, &ct.Phone, &ct.Email, &ct.NID, &ct.Type, &ct.PriceListID, &ct.SMSOptOut, &ct.OrganizationID)
+	return ct, notFound(err)
+}
+
+// Create adds ct, giving it an id when it has none.
+func (r ContactRepo) Create(ctx context.Context, ct *Contact) error {
+	if ct.ID == "" {
+		ct.ID = genID()
+	}
+	optOut := 0
+	if ct.SMSOptOut {
+		optOut = 1
+	}
+	_, err := r.q.ExecContext(ctx, `INSERT INTO contacts (id,name,phone,email,nid,type,price_list_id,sms_opt_out,organization_id,created_at) VALUES (?,?,?,NULLIF(?, ''),NULLIF(?, ''),?,NULLIF(?, ''),?,?,?)`,
+		ct.ID, ct.Name, ct.Phone, ct.Email, ct.NID, ct.Type, ct.PriceListID, optOut, ct.OrganizationID, time.Now().Format(time.RFC3339))
+	return err
+}
+
+type Item struct {
+	ID            string  `json:"id"`
+	Name          string  `json:"name"`
+	SKU           string  `json:"sku"`
+	Quantity      int     `json:"quantity"`
+	Unit          string  `json:"unit"`
+	UnitPrice     float64 `json:"unit_price"`
+	ReorderLevel  int     `json:"reorder_level"`
+	Category      string  `json:"category"`
+	Description   string  `json:"description"`
+	ImageFilename string  `json:"image_filename"`
+	ImageURL      string  `json:"image_url"`
+	// set on variants, see variants.go
+	ParentID string            `json:"parent_id,omitempty"`
+	Options  map[string]string `json:"options,omitempty"`
+}
+
+// NewItem is an item as created; Category and Description may be left
+// out, and Unit defaults to pcs. A variant names its ParentID and the
+// Options telling it apart from its siblings.
+type NewItem struct {
+	Name         string            `json:"name"`
+	SKU          string            `json:"sku"`
+	Quantity     int               `json:"quantity"`
+	Unit         string            `json:"unit"`
+	UnitPrice    float64           `json:"unit_price"`
+	ReorderLevel int               `json:"reorder_level"`
+	Category     *string           `json:"category"`
+	Description  *string           `json:"description"`
+	ParentID     *string           `json:"parent_id"`
+	Options      map[string]string `json:"options"`
+}
+
+// ItemPatch holds the fields of an item to change; nil fields are left
+// alone and the nullable ones are cleared when not Valid.
+type ItemPatch struct {
+	Name         *string
+	Quantity     *int
+	Unit         *string
+	UnitPrice    *float64
+	ReorderLevel *int
+	CostPrice    *sql.NullFloat64
+	MinPrice     *sql.NullFloat64
+	SupplierID   *sql.NullString
+	TaxRateID    *sql.NullString
+	Category     *sql.NullString
+	Description  *sql.NullString
+	Options      map[string]string
+}
+
+// parseItemPatch reads an inventory item PATCH body.
+func parseItemPatch(body map[string]interface{}) (ItemPatch, error) {
+	var p ItemPatch
+	if v, ok := body["name"]; ok {
+		name, isText := v.(string)
+		if !isText {
+			return p, errors.New("name must be text")
+		}
+		p.Name = &name
+	}
+	if v, ok := body["unit"]; ok {
+		unit, isText := v.(string)
+		if unit = strings.TrimSpace(unit); !isText || unit == "" {
+			return p, errors.New("unit must be a unit name")
+		}
+		p.Unit = &unit
+	}
+	for field, dst := range map[string]**int{"quantity": &p.Quantity, "reorder_level": &p.ReorderLevel} {
+		if v, ok := body[field]; ok {
+			f, isNum := v.(float64)
+			if !isNum || f != float64(int(f)) {
+				return p, errors.New(field + " must be a whole number")
+			}
+			n := int(f)
+			*dst = &n
+		}
+	}
+	if v, ok := body["unit_price"]; ok {
+		price, isNum := v.(float64)
+		if !isNum {
+			return p, errors.New("unit_price must be a number")
+		}
+		p.UnitPrice = &price
+	}
+	for field, dst := range map[string]**sql.NullFloat64{"cost_price": &p.CostPrice, "min_sale_price": &p.MinPrice} {
+		if v, ok := body[field]; ok {
+			price, isNum := v.(float64)
+			if v != nil && !isNum {
+				return p, errors.New(field + " must be a number or null")
+			}
+			*dst = &sql.NullFloat64{Float64: price, Valid: v != nil}
+		}
+	}
+	for field, dst := range map[string]**sql.NullString{"supplier_id": &p.SupplierID, "tax_rate_id": &p.TaxRateID, "category": &p.Category, "description": &p.Description} {
+		if v, ok := body[field]; ok {
+			text, isText := v.(string)
+			if v != nil && !isText {
+				return p, errors.New(field + " must be text or null")
+			}
+			*dst = &sql.NullString{String: text, Valid: v != nil}
+		}
+	}
+	if v, ok := body["options"]; ok {
+		options, isObject := v.(map[string]interface{})
+		if !isObject || len(options) == 0 {
+			return p, errors.New("options must be an object of text values")
+		}
+		p.Options = map[string]string{}
+		for k, o := range options {
+			text, isText := o.(string)
+			if !isText {
+				return p, errors.New("options must be an object of text values")
+			}
+			p.Options[k] = text
+		}
+	}
+	return p, nil
+}
+
+type ItemRepo struct{ q execer }
+
+func Items(q execer) ItemRepo { return ItemRepo{q} }
+
+const itemColumns = `id, COALESCE(name, ''), COALESCE(sku, ''), quantity, unit, unit_price, reorder_level, COALESCE(category, ''), COALESCE(description, ''), COALESCE(image_filename, ''), COALESCE(image_url, ''), COALESCE(parent_id, ''), COALESCE(variant_options, '')`
+
+func scanItem(row interface{ Scan(...interface{}) error }) (Item, error) {
+	var it Item
+	var options string
+	err := row.Scan(&it.ID, &it.Name, &it.SKU, &it.Quantity, &it.Unit, &it.UnitPrice, &it.ReorderLevel, &it.Category, &it.Description, &it.ImageFilename, &it.ImageURL, &it.ParentID, &options)
+	if err == nil && options != "" {
+		err = json.Unmarshal([]byte(options), &it.Options)
+	}
+	return it, err
+}
+
+// Get returns orgID's item id.
+func (r ItemRepo) Get(ctx context.Context, orgID, id string) (Item, error) {
+	it, err := scanItem(r.q.QueryRowContext(ctx, `SELECT `+itemColumns+` FROM inventory_items WHERE id = ? AND organization_id = ?`, id, orgID))
+	return it, notFound(err)
+}
+
+// Variants returns the variants of item parentID.
+func (r ItemRepo) Variants(ctx context.Context, parentID string) ([]Item, error) {
+	rows, err := r.q.QueryContext(ctx, `SELECT `+itemColumns+` FROM inventory_items WHERE parent_id = ? ORDER BY sku, id`, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []Item{}
+	for rows.Next() {
+		it, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// BySKU returns orgID's items with SKU sku, at most two: enough to tell
+// whether the SKU is taken more than once.
+func (r ItemRepo) BySKU(ctx context.Context, orgID, sku string) ([]Item, error) {
+	rows, err := r.q.QueryContext(ctx, `SELECT `+itemColumns+` FROM inventory_items WHERE organization_id = ? AND sku = ? LIMIT 2`, orgID, sku)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Item
+	for rows.Next() {
+		it, err := scanItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// ByCode returns the item code is another barcode or alias of (see
+// item_codes.go), with the unit the code stands for if any.
+func (r ItemRepo) ByCode(ctx context.Context, orgID, code string) (Item, string, error) {
+	var itemID, unit string
+	err := r.q.QueryRowContext(ctx, `SELECT item_id, COALESCE(unit, '') FROM item_codes WHERE organization_id = ? AND code = ?`, orgID, code).Scan(&itemID, &unit)
+	if err != nil {
+		return Item{}, "", notFound(err)
+	}
+	it, err := r.Get(ctx, orgID, itemID)
+	return it, unit, err
+}
+
+// Create adds an item to orgID and returns its id.
+func (r ItemRepo) Create(ctx context.Context, orgID string, in NewItem) (string, error) {
+	id, now := genID(), time.Now().Format(time.RFC3339)
+	if in.Unit = strings.TrimSpace(in.Unit); in.Unit == "" {
+		in.Unit = "pcs"
+	}
+	_, err := r.q.ExecContext(ctx, `INSERT INTO inventory_items (id,name,sku,quantity,unit,unit_price,reorder_level,category,description,parent_id,variant_options,organization_id,updated_at,created_at) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		id, in.Name, in.SKU, in.Quantity, in.Unit, in.UnitPrice, in.ReorderLevel, nullableString(in.Category), nullableString(in.Description), nullableString(in.ParentID), optionsJSON(in.Options), orgID, now, now)
+	return id, err
+}
+
+// Quantity returns the quantity in stock of item id.
+func (r ItemRepo) Quantity(ctx context.Context, id string) (int, error) {
+	var qty int
+	err := r.q.QueryRowContext(ctx, `SELECT quantity FROM inventory_items WHERE id = ?`, id).Scan(&qty)
+	return qty, notFound(err)
+}
+
+// Update applies p to item id, recording a changed unit price in the
+// item's price history, and reports whether anything was sent.
+func (r ItemRepo) Update(ctx context.Context, id string, p ItemPatch) (bool, error) {
+	var sets []string
+	var args []interface{}
+	field := func(column string, v interface{}) {
+		sets = append(sets, column+" = ?")
+		args = append(args, v)
+	}
+	if p.Name != nil {
+		field("name", *p.Name)
+	}
+	if p.Quantity != nil {
+		field("quantity", *p.Quantity)
+	}
+	if p.Unit != nil {
+		field("unit", *p.Unit)
+	}
+	if p.ReorderLevel != nil {
+		field("reorder_level", *p.ReorderLevel)
+	}
+	if p.CostPrice != nil {
+		field("cost_price", *p.CostPrice)
+	}
+	if p.MinPrice != nil {
+		field("min_sale_price", *p.MinPrice)
+	}
+	if p.SupplierID != nil {
+		field("supplier_id", *p.SupplierID)
+	}
+	if p.TaxRateID != nil {
+		field("tax_rate_id", *p.TaxRateID)
+	}
+	if p.Category != nil {
+		field("category", *p.Category)
+	}
+	if p.Description != nil {
+		field("description", *p.Description)
+	}
+	if p.Options != nil {
+		field("variant_options", optionsJSON(p.Options))
+	}
+	now := time.Now().Format(time.RFC3339)
+	if p.UnitPrice != nil {
+		var old float64
+		if err := r.q.QueryRowContext(ctx, `SELECT unit_price FROM inventory_items WHERE id = ?`, id).Scan(&old); err != nil {
+			return false, notFound(err)
+		}
+		if old != *p.UnitPrice {
+			field("unit_price", *p.UnitPrice)
+			if _, err := r.q.ExecContext(ctx, `INSERT INTO price_history (id,item_id,old_price,new_price,reason,created_at) VALUES (?,?,?,?,?,?)`, genID(), id, old, *p.UnitPrice, "manual", now); err != nil {
+				return false, err
+			}
+		}
+	}
+	if len(sets) == 0 && p.UnitPrice == nil {
+		return false, nil
+	}
+	field("updated_at", now)
+	_, err := r.q.ExecContext(ctx, `UPDATE inventory_items SET `+strings.Join(sets, ", ")+` WHERE id = ?`, append(args, id)...)
+	return true, err
+}
+
+type Transaction struct {
+	ID            string               `json:"id"`
+	Type          string               `json:"type"`
+	Amount        money                `json:"amount"`
+	PaidAmount    money                `json:"paid_amount"`
+	DueAmount     money                `json:"due_amount"`
+	ContactID     string               `json:"contact_id"`
+	PaymentMethod string               `json:"payment_method"`
+	ReceiptNumber string               `json:"receipt_number,omitempty"`
+	InvoiceNo     string               `json:"invoice_no,omitempty"`
+	Currency      string               `json:"currency,omitempty"`
+	ExchangeRate  float64              `json:"exchange_rate,omitempty"`
+	CurrencyTotal float64              `json:"currency_amount,omitempty"`
+	Payments      []TransactionPayment `json:"payments"`
+	ImageFilename string               `json:"image_filename"`
+	ImageURL      string               `json:"image_url"`
+	VoidedAt      string               `json:"voided_at"`
+	VoidedBy      string               `json:"voided_by,omitempty"`
+	VoidReason    string               `json:"void_reason,omitempty"`
+	CreatedAt     string               `json:"created_at"`
+}
+
+type TransactionPayment struct {
+	ID        string `json:"id"`
+	Method    string `json:"method"`
+	Amount    money  `json:"amount"`
+	Reference string `json:"reference"`
+	AccountID string `json:"account_id"`
+	CreatedAt string `json:"created_at"`
+}
+
+type TransactionRepo struct{ q execer }
+
+func Transactions(q execer) TransactionRepo { return TransactionRepo{q} }
+
+// Get returns orgID's transaction id with its payments.
+func (r TransactionRepo) Get(ctx context.Context, orgID, id string) (Transaction, error) {
+	var t Transaction
+	err := r.q.QueryRowContext(ctx, `SELECT id, type, amount, paid_amount, due_amount, COALESCE(contact_id, ''), COALESCE(payment_method, ''), COALESCE(receipt_number, ''), COALESCE(currency, ''), COALESCE(exchange_rate, 0), COALESCE(currency_amount, 0), COALESCE(image_filename, ''), COALESCE(image_url, ''), COALESCE(voided_at, ''), COALESCE(voided_by, ''), COALESCE(void_reason, ''), COALESCE(created_at, '')
+		FROM transactions WHERE id = ? AND organization_id = ?`, id, orgID).
+		Scan(&t.ID, &t.Type, &t.Amount, &t.PaidAmount, &t.DueAmount, &t.ContactID, &t.PaymentMethod, &t.ReceiptNumber, &t.Currency, &t.ExchangeRate, &t.CurrencyTotal, &t.ImageFilename, &t.ImageURL, &t.VoidedAt, &t.VoidedBy, &t.VoidReason, &t.CreatedAt)
+	if err != nil {
+		return t, notFound(err)
+	}
+	t.InvoiceNo = t.ReceiptNumber
+	t.Payments, err = r.Payments(ctx, id)
+	return t, err
+}
+
+// Payments returns how transaction id was paid, in the order the payments
+// were made.
+func (r TransactionRepo) Payments(ctx context.Context, id string) ([]TransactionPayment, error) {
+	rows, err := r.q.QueryContext(ctx, `SELECT id, method, amount, COALESCE(reference, ''), COALESCE(account_id, ''), COALESCE(created_at, '') FROM transaction_payments WHERE transaction_id = ? ORDER BY created_at`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	payments := []TransactionPayment{}
+	for rows.Next() {
+		var p TransactionPayment
+		if err := rows.Scan(&p.ID, &p.Method, &p.Amount, &p.Reference, &p.AccountID, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		payments = append(payments, p)
+	}
+	return payments, rows.Err()
+}
+
+// CreatedAt returns when transaction id was recorded, as stored.
+func (r TransactionRepo) CreatedAt(ctx context.Context, id string) (string, error) {
+	var createdAt string
+	err := r.q.QueryRowContext(ctx, `SELECT COALESCE(created_at, '') FROM transactions WHERE id = ?`, id).Scan(&createdAt)
+	return createdAt, notFound(err)
+}
+
+// SetImageURL points transaction id at a receipt image.
+func (r TransactionRepo) SetImageURL(ctx context.Context, id string, url sql.NullString) error {
+	_, err := r.q.ExecContext(ctx, `UPDATE transactions SET image_url = ? WHERE id = ?`, url, id)
+	return err
+}
+
+// SetDueDate sets or, when not Valid, clears when transaction id is due.
+func (r TransactionRepo) SetDueDate(ctx context.Context, id string, date sql.NullString) error {
+	_, err := r.q.ExecContext(ctx, `UPDATE transactions SET due_date = ? WHERE id = ?`, date, id)
+	return err
+}
+
+// recordError answers a failed repository call: 404 for a missing record,
+// 500 otherwise.
+func recordError(c *fiber.Ctx, err error) error {
+	if err == errNotFound {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
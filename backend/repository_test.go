@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRepositories(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	ct := Contact{Name: "Rahim", Phone: "017", Type: "customer", OrganizationID: "org-1"}
+	if err := Contacts(db).Create(ctx, &ct); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Contacts(db).Get(ctx, "org-1", ct.ID); err != nil || got != ct {
+		t.Errorf("contact: %+v %v", got, err)
+	}
+	if _, err := Contacts(db).Get(ctx, "org-2", ct.ID); err != errNotFound {
+		t.Errorf("another organization's contact: %v, want errNotFound", err)
+	}
+
+	category := "Stationery"
+	id, err := Items(db).Create(ctx, "org-1", NewItem{Name: "Pen", SKU: "PEN", Quantity: 5, UnitPrice: 10, Category: &category})
+	if err != nil {
+		t.Fatal(err)
+	}
+	price, cost := 12.0, sql.NullFloat64{Float64: 7, Valid: true}
+	if changed, err := Items(db).Update(ctx, id, ItemPatch{UnitPrice: &price, CostPrice: &cost, Category: &sql.NullString{}}); err != nil || !changed {
+		t.Fatalf("update: %v %v", changed, err)
+	}
+	it, err := Items(db).Get(ctx, "org-1", id)
+	if err != nil || it.UnitPrice != 12 || it.Category != "" || it.Quantity != 5 {
+		t.Errorf("item after update: %+v %v", it, err)
+	}
+	var history int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM price_history WHERE item_id = ? AND old_price = 10 AND new_price = 12`, id).Scan(&history); err != nil || history != 1 {
+		t.Errorf("price history: %d %v", history, err)
+	}
+	if changed, err := Items(db).Update(ctx, id, ItemPatch{}); err != nil || changed {
+		t.Errorf("empty update: %v %v", changed, err)
+	}
+	if _, err := Items(db).Quantity(ctx, "missing"); err != errNotFound {
+		t.Errorf("missing item: %v, want errNotFound", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-1','inflow',5000,5000,0,?,'org-1','2024-01-01T00:00:00Z')`, ct.ID); err != nil {
+		t.Fatal(err)
+	}
+	tr, err := Transactions(db).Get(ctx, "org-1", "t-1")
+	if err != nil || tr.Amount != moneyOf(50) || tr.ContactID != ct.ID || tr.Payments == nil || len(tr.Payments) != 0 {
+		t.Errorf("transaction: %+v %v", tr, err)
+	}
+}
+
+func TestItemPatchValidation(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	// fields left out of a new item take their defaults
+	code, created := call("POST", "/api/collections/inventory_items/records", `{"name":"Pen","sku":"PEN"}`)
+	if code != 200 {
+		t.Fatalf("create item: %d %v", code, created)
+	}
+	path := "/api/collections/inventory_items/records/" + created["id"].(string)
+	for _, body := range []string{`{"quantity":"lots"}`, `{"quantity":1.5}`, `{"reorder_level":null}`, `{"category":3}`} {
+		if code, _ := call("PATCH", path, body); code != 400 {
+			t.Errorf("PATCH %s: got %d, want 400", body, code)
+		}
+	}
+	if code, _ := call("PATCH", path, `{"quantity":4,"description":"Blue"}`); code != 200 {
+		t.Fatalf("patch: got %d", code)
+	}
+	if code, item := call("GET", path, ""); code != 200 || item["quantity"] != 4.0 || item["description"] != "Blue" {
+		t.Errorf("item: %d %v", code, item)
+	}
+	if code, _ := call("GET", "/api/collections/contacts/records/missing", ""); code != 404 {
+		t.Errorf("missing contact: got %d, want 404", code)
+	}
+	if code, _ := call("POST", "/api/collections/contacts/records", `{"phone":"017"}`); code != 400 {
+		t.Errorf("contact without a name: got %d, want 400", code)
+	}
+}
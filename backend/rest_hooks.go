@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// No-code tools such as Zapier and Make wire bizcalc into other apps with
+// triggers, following Zapier's conventions:
+//
+//   - REST hooks: the tool subscribes a URL to an event and is POSTed each
+//     new record as JSON as it happens. Subscribing answers the hook's id,
+//     which unsubscribes it later. A URL answering 410 Gone is dropped.
+//   - Polling: GET /api/triggers/:event answers a bare JSON array of the
+//     newest records, newest first, each with a stable id the tool
+//     deduplicates on. The records are the ones hooks send, so the tool
+//     also uses it for sample data.
+//
+// Events are new_transaction, new_contact and low_stock (a low stock
+// alert being raised; see alerts.go).
+//
+//	POST   /api/hooks                  {"target_url": "https://hooks.zapier.com/...", "event": "new_transaction"}
+//	GET    /api/hooks                  the organization's subscriptions
+//	DELETE /api/hooks/:id              unsubscribe
+//	GET    /api/triggers/:event        ?limit= (default 50, at most 100); new_transaction and new_contact take ?type=
+
+const (
+	triggerDefaultLimit = 50
+	triggerMaxLimit     = 100
+)
+
+// hookTrigger is how an event's records are read: query selects them for
+// an organization_id, id names their id column, and order lists them
+// newest first.
+type hookTrigger struct {
+	query, id, order string
+	// typed reports whether ?type= narrows the records
+	typed   bool
+	amounts []string
+}
+
+var hookTriggers = map[string]hookTrigger{
+	"new_transaction": {
+		query: `SELECT id, type, amount, paid_amount, due_amount, COALESCE(contact_id, '') AS contact_id, COALESCE(contact_name, '') AS contact_name, COALESCE(payment_method, '') AS payment_method,
+			COALESCE(receipt_number, '') AS receipt_number, COALESCE(tax_amount, 0) AS tax_amount, COALESCE(due_date, '') AS due_date, COALESCE(source, '') AS source, created_at
+			FROM transactions WHERE organization_id = ? AND voided_at IS NULL`,
+		id: "id", order: "created_at DESC, id DESC", typed: true,
+		amounts: []string{"amount", "paid_amount", "due_amount", "tax_amount"},
+	},
+	"new_contact": {
+		query: `SELECT id, name, phone, type, COALESCE(created_at, '') AS created_at FROM contacts WHERE organization_id = ?`,
+		id:    "id", order: "COALESCE(created_at, '') DESC, id DESC", typed: true,
+	},
+	"low_stock": {
+		query: `SELECT a.id, a.ref_id AS item_id, COALESCE(i.name, '') AS name, COALESCE(i.sku, '') AS sku, COALESCE(i.quantity, 0) AS quantity, COALESCE(i.reorder_level, 0) AS reorder_level,
+			a.message, a.status, a.created_at
+			FROM alerts a LEFT JOIN inventory_items i ON i.id = a.ref_id WHERE a.organization_id = ? AND a.kind = 'low_stock'`,
+		id: "a.id", order: "a.created_at DESC, a.id DESC",
+	},
+}
+
+// hookEvents maps the collections whose new rows are events to them.
+var hookEvents = map[string]string{
+	"transactions": "new_transaction",
+	"contacts":     "new_contact",
+}
+
+func registerRestHookRoutes(app *fiber.App) {
+	h := app.Group("/api/hooks", requireAuth, requireRole("admin", "manager"))
+	h.Get("/", handleListRestHooks)
+	h.Post("/", handleSubscribeRestHook)
+	h.Delete("/:id", handleUnsubscribeRestHook)
+	app.Get("/api/triggers/:event", requireAuth, handlePollTrigger)
+}
+
+// triggerRecords reads orgID's records of event, newest first: limit of
+// them, or the one with id when id is set.
+func triggerRecords(q *DB, orgID, event, id, typ string, limit int) ([]map[string]interface{}, error) {
+	t := hookTriggers[event]
+	query, args := t.query, []interface{}{orgID}
+	if id != "" {
+		query += ` AND ` + t.id + ` = ?`
+		args = append(args, id)
+	}
+	if typ != "" && t.typed {
+		query += ` AND type = ?`
+		args = append(args, typ)
+	}
+	query += ` ORDER BY ` + t.order + ` LIMIT ?`
+	args = append(args, limit)
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	records, err := rowsToMaps(rows)
+	if err != nil {
+		return nil, err
+	}
+	moneyColumns(records, t.amounts...)
+	return records, nil
+}
+
+func handlePollTrigger(c *fiber.Ctx) error {
+	event := c.Params("event")
+	if _, ok := hookTriggers[event]; !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "unknown trigger " + event})
+	}
+	limit := triggerDefaultLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > triggerMaxLimit {
+			return c.Status(400).JSON(fiber.Map{"error": "limit must be 1 to " + strconv.Itoa(triggerMaxLimit)})
+		}
+		limit = n
+	}
+	records, err := triggerRecords(dbFor(c), currentOrgID(c), event, "", c.Query("type"), limit)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(records)
+}
+
+func handleSubscribeRestHook(c *fiber.Ctx) error {
+	var req struct {
+		TargetURL string `json:"target_url"`
+		Event     string `json:"event"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	req.TargetURL, req.Event = strings.TrimSpace(req.TargetURL), strings.TrimSpace(req.Event)
+	if _, ok := hookTriggers[req.Event]; !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "event must be new_transaction, new_contact or low_stock"})
+	}
+	if u, err := url.Parse(req.TargetURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "target_url must be an http(s) URL"})
+	}
+	id, orgID, now := genID(), currentOrgID(c), time.Now().Format(time.RFC3339)
+	if _, err := dbFor(c).Exec(`INSERT INTO rest_hooks (id,organization_id,event,target_url,role,created_by,created_at) VALUES (?,?,?,?,?,?,?)`,
+		id, orgID, req.Event, req.TargetURL, currentRole(c), currentUserID(c), now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(fiber.Map{"id": id, "event": req.Event, "target_url": req.TargetURL, "created_at": now})
+}
+
+func handleListRestHooks(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT id, event, target_url, COALESCE(created_by, '') AS created_by, created_at FROM rest_hooks WHERE organization_id = ? ORDER BY created_at, id`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	hooks, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": hooks})
+}
+
+func handleUnsubscribeRestHook(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`DELETE FROM rest_hooks WHERE id = ? AND organization_id = ?`, c.Params("id"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.JSON(fiber.Map{"id": c.Params("id")})
+}
+
+// fireRestHooks sends record id of event to the URLs of orgID subscribed
+// to it. Nothing is read while nobody is subscribed; delivery happens in
+// the background and failures are only logged, as with alert webhooks.
+func fireRestHooks(orgID, event, id string) {
+	rows, err := db.Query(`SELECT id, target_url, role FROM rest_hooks WHERE organization_id = ? AND event = ?`, orgID, event)
+	if err != nil {
+		log.Printf("rest hooks: %v", err)
+		return
+	}
+	type hook struct{ id, url, role string }
+	var hooks []hook
+	for rows.Next() {
+		var h hook
+		if err := rows.Scan(&h.id, &h.url, &h.role); err != nil {
+			rows.Close()
+			log.Printf("rest hooks: %v", err)
+			return
+		}
+		hooks = append(hooks, h)
+	}
+	rows.Close()
+	if len(hooks) == 0 {
+		return
+	}
+	records, err := triggerRecords(db, orgID, event, id, "", 1)
+	if err != nil || len(records) == 0 {
+		return
+	}
+	data, err := json.Marshal(records[0])
+	if err != nil {
+		return
+	}
+	// each hook sees the record as its subscriber's role would
+	bodies := map[string][]byte{}
+	for _, h := range hooks {
+		if _, ok := bodies[h.role]; !ok {
+			bodies[h.role] = maskJSON(data, hiddenFields(orgID, h.role))
+		}
+	}
+	store := db
+	go func() {
+		client := &http.Client{Timeout: 10 * time.Second}
+		for _, h := range hooks {
+			resp, err := client.Post(h.url, "application/json", bytes.NewReader(bodies[h.role]))
+			if err != nil {
+				log.Printf("rest hooks: %s: %v", h.url, err)
+				continue
+			}
+			resp.Body.Close()
+			switch {
+			case resp.StatusCode == http.StatusGone:
+				// the subscriber has switched the hook off on their side
+				if _, err := store.Exec(`DELETE FROM rest_hooks WHERE id = ?`, h.id); err != nil {
+					log.Printf("rest hooks: %v", err)
+				}
+			case resp.StatusCode >= 300:
+				log.Printf("rest hooks: %s answered %s", h.url, resp.Status)
+			}
+		}
+	}()
+}
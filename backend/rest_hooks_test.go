@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRestHooks(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,reorder_level,organization_id) VALUES ('i-1','Pen','PEN',10,15,8,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	received := make(chan map[string]interface{}, 4)
+	zap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw, _ := io.ReadAll(r.Body)
+		var record map[string]interface{}
+		_ = json.Unmarshal(raw, &record)
+		received <- record
+	}))
+	defer zap.Close()
+	gone := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer gone.Close()
+
+	app := newApp()
+	call := func(role, method, path, body string) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, raw
+	}
+	subscribe := func(role, body string) (int, string) {
+		t.Helper()
+		code, raw := call(role, "POST", "/api/hooks", body)
+		var out map[string]interface{}
+		_ = json.Unmarshal(raw, &out)
+		return code, toString(out["id"])
+	}
+
+	if code, _ := subscribe("cashier", `{"target_url":"`+zap.URL+`","event":"new_transaction"}`); code != 403 {
+		t.Errorf("cashier subscribing: got %d, want 403", code)
+	}
+	if code, _ := subscribe("manager", `{"target_url":"`+zap.URL+`","event":"new_sale"}`); code != 400 {
+		t.Errorf("unknown event: got %d, want 400", code)
+	}
+	if code, _ := subscribe("manager", `{"target_url":"ftp://example.com","event":"new_transaction"}`); code != 400 {
+		t.Errorf("bad target_url: got %d, want 400", code)
+	}
+	code, hookID := subscribe("manager", `{"target_url":"`+zap.URL+`","event":"new_transaction"}`)
+	if code != 201 || hookID == "" {
+		t.Fatalf("subscribe: %d %q", code, hookID)
+	}
+	if code, _ := subscribe("manager", `{"target_url":"`+gone.URL+`","event":"new_contact"}`); code != 201 {
+		t.Fatalf("subscribe to contacts: %d", code)
+	}
+
+	code, raw := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":45,"paid_amount":45,"due_amount":0,"payment_method":"cash","contact_id":"c-1","items":[{"item_id":"i-1","quantity":3,"unit_price":15}]}`)
+	if code != 200 {
+		t.Fatalf("sale: %d %s", code, raw)
+	}
+	var sale map[string]interface{}
+	_ = json.Unmarshal(raw, &sale)
+	select {
+	case record := <-received:
+		if record["id"] != sale["id"] || record["amount"] != 45.0 || record["contact_name"] != "Walk-in" {
+			t.Errorf("hook payload: %v", record)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the hook was not called")
+	}
+
+	// a target answering 410 Gone has been switched off
+	if code, raw := call("manager", "POST", "/api/collections/contacts/records", `{"name":"Rahim","phone":"01711","type":"customer"}`); code != 200 {
+		t.Fatalf("contact: %d %s", code, raw)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(1) FROM rest_hooks WHERE event = 'new_contact'`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hook answered 410 but was kept")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// polling answers a bare array, newest first
+	var records []map[string]interface{}
+	_, raw = call("viewer", "GET", "/api/triggers/new_transaction", "")
+	if err := json.Unmarshal(raw, &records); err != nil || len(records) != 1 || records[0]["id"] != sale["id"] {
+		t.Errorf("new_transaction: %s %v", raw, err)
+	}
+	_, raw = call("viewer", "GET", "/api/triggers/new_transaction?type=outflow", "")
+	if strings.TrimSpace(string(raw)) != "[]" {
+		t.Errorf("purchases: %s", raw)
+	}
+	_, raw = call("viewer", "GET", "/api/triggers/new_contact?limit=1", "")
+	if err := json.Unmarshal(raw, &records); err != nil || len(records) != 1 || records[0]["name"] != "Rahim" {
+		t.Errorf("new_contact: %s %v", raw, err)
+	}
+	if code, _ := call("viewer", "GET", "/api/triggers/new_transaction?limit=500", ""); code != 400 {
+		t.Errorf("limit too large: got %d, want 400", code)
+	}
+	if code, _ := call("viewer", "GET", "/api/triggers/new_sale", ""); code != 404 {
+		t.Errorf("unknown trigger: got %d, want 404", code)
+	}
+	if _, _, err := checkLowStock(db, "org-1"); err != nil {
+		t.Fatal(err)
+	}
+	_, raw = call("viewer", "GET", "/api/triggers/low_stock", "")
+	if err := json.Unmarshal(raw, &records); err != nil || len(records) != 1 || records[0]["item_id"] != "i-1" || records[0]["quantity"] != 7.0 {
+		t.Errorf("low_stock: %s %v", raw, err)
+	}
+
+	if code, _ := call("manager", "DELETE", "/api/hooks/"+hookID, ""); code != 200 {
+		t.Errorf("unsubscribe: got %d", code)
+	}
+	if code, _ := call("manager", "DELETE", "/api/hooks/"+hookID, ""); code != 404 {
+		t.Errorf("unsubscribed twice: got %d, want 404", code)
+	}
+}
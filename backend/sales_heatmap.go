@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// handleSalesHeatmap reports sales by weekday and hour of the day in the
+// business's timezone setting, for deciding opening hours and staffing.
+// Averages are per occurrence of the weekday in the period, so a month
+// with five Fridays does not make Friday look busier. The staffing
+// suggestion is one person per sales_per_staff_hour (default 20) sales an
+// hour, at least one while the shop sees any sales.
+func handleSalesHeatmap(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	perStaff := 20.0
+	if v := c.Query("sales_per_staff_hour"); v != "" {
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil || n <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "sales_per_staff_hour must be a positive number"})
+		}
+		perStaff = n
+	}
+	orgID := currentOrgID(c)
+	loc := businessLocation(orgID)
+	counts, amounts, err := salesByHour(dbFor(c), orgID, from, to, loc)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var occurrences [7]int
+	start := from.In(loc)
+	for d := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc); d.Before(to); d = d.AddDate(0, 0, 1) {
+		occurrences[d.Weekday()]++
+	}
+
+	hours := make([]string, 24)
+	byHour := make([]fiber.Map, 24)
+	for h := range hours {
+		hours[h] = fmt.Sprintf("%02d", h)
+		byHour[h] = fiber.Map{"hour": hours[h], "sales": 0.0, "amount": 0.0}
+	}
+	days := []fiber.Map{}
+	staffing := []fiber.Map{}
+	var peak fiber.Map
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		average := make([]float64, 24)
+		firstHour, lastHour := -1, -1
+		totalSales, totalAmount := 0.0, 0.0
+		for h := 0; h < 24; h++ {
+			totalSales += counts[d][h]
+			totalAmount += amounts[d][h]
+			byHour[h]["sales"] = byHour[h]["sales"].(float64) + counts[d][h]
+			byHour[h]["amount"] = byHour[h]["amount"].(float64) + amounts[d][h]
+			if counts[d][h] == 0 {
+				continue
+			}
+			if firstHour < 0 {
+				firstHour = h
+			}
+			lastHour = h
+			if occurrences[d] > 0 {
+				average[h] = round2(counts[d][h] / float64(occurrences[d]))
+			}
+			staffing = append(staffing, fiber.Map{
+				"weekday":       d.String(),
+				"hour":          hours[h],
+				"average_sales": average[h],
+				"staff":         math.Max(1, math.Ceil(average[h]/perStaff)),
+			})
+			if peak == nil || average[h] > peak["average_sales"].(float64) {
+				peak = fiber.Map{"weekday": d.String(), "hour": hours[h], "average_sales": average[h]}
+			}
+		}
+		day := fiber.Map{
+			"weekday":       d.String(),
+			"occurrences":   occurrences[d],
+			"sales":         counts[d][:],
+			"amounts":       amounts[d][:],
+			"average_sales": average,
+			"total_sales":   totalSales,
+			"total_amount":  totalAmount,
+			"first_hour":    nil,
+			"last_hour":     nil,
+		}
+		if firstHour >= 0 {
+			day["first_hour"], day["last_hour"] = hours[firstHour], hours[lastHour]
+		}
+		days = append(days, day)
+	}
+
+	return c.JSON(fiber.Map{
+		"timezone": loc.String(),
+		"from":     from.Format(time.RFC3339),
+		"to":       to.Format(time.RFC3339),
+		"hours":    hours,
+		"days":     days,
+		"by_hour":  byHour,
+		"peak":     peak,
+		"staffing": staffing,
+	})
+}
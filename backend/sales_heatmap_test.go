@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSalesHeatmapUsesBusinessTimezone(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','017','customer','org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES
+			('t-1','inflow',10000,10000,0,'c-1','org-1','2024-01-05T04:30:00Z'),
+			('t-2','inflow',5000,5000,0,'c-1','org-1','2024-01-05T04:45:00Z'),
+			('t-3','inflow',3000,3000,0,'c-1','org-1','2024-01-12T04:10:00Z'),
+			('t-4','inflow',8000,8000,0,'c-1','org-1','2024-01-06T13:00:00Z'),
+			('t-5','outflow',90000,90000,0,'c-1','org-1','2024-01-05T04:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	period := "from=2024-01-01T00:00:00Z&to=2024-01-15T00:00:00Z"
+
+	// 04:30 UTC is 10:30 in Dhaka
+	_, out := call("GET", "/api/reports/sales-heatmap?sales_per_staff_hour=1&"+period, "")
+	if out["timezone"] != "Asia/Dhaka" {
+		t.Errorf("timezone %v", out["timezone"])
+	}
+	if peak := out["peak"].(map[string]interface{}); peak["weekday"] != "Friday" || peak["hour"] != "10" || peak["average_sales"] != 1.5 {
+		t.Errorf("peak %v", peak)
+	}
+	friday := out["days"].([]interface{})[5].(map[string]interface{})
+	if friday["occurrences"] != float64(2) || friday["total_amount"] != float64(180) || friday["first_hour"] != "10" {
+		t.Errorf("friday %v", friday)
+	}
+	staffing := out["staffing"].([]interface{})
+	if len(staffing) != 2 || staffing[0].(map[string]interface{})["staff"] != float64(2) || staffing[1].(map[string]interface{})["hour"] != "19" {
+		t.Errorf("staffing %v", staffing)
+	}
+
+	if code, _ := call("PUT", "/api/settings/organization", `{"timezone":"Mars/Olympus"}`); code != 400 {
+		t.Errorf("unknown timezone: got %d, want 400", code)
+	}
+	if code, _ := call("PUT", "/api/settings/organization", `{"timezone":"UTC"}`); code != 200 {
+		t.Fatalf("set timezone: got %d", code)
+	}
+	_, out = call("GET", "/api/reports/sales-heatmap?"+period, "")
+	if peak := out["peak"].(map[string]interface{}); peak["hour"] != "04" {
+		t.Errorf("peak in UTC %v", peak)
+	}
+}
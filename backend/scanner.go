@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Uploaded files can be checked by a virus scanner before they are written
+// under uploads/ and so become downloadable. A rejected file is moved to
+// data/quarantine and recorded in quarantined_uploads for admins to review
+// at GET /api/storage/quarantine.
+//
+// Configuration:
+//
+//	UPLOAD_SCANNER        "clamav", "http", or empty for no scanning
+//	CLAMAV_ADDRESS        clamd socket path or host:port (default 127.0.0.1:3310)
+//	UPLOAD_SCANNER_URL    for "http": the file is POSTed as the body and the
+//	                      scanner answers {"clean": bool, "signature": "..."}
+//	UPLOAD_SCAN_FAIL_OPEN "true" accepts files while the scanner is down;
+//	                      by default they are refused with 503
+
+// errUploadRejected is returned when the scanner flags a file.
+type errUploadRejected struct {
+	signature string
+}
+
+func (e *errUploadRejected) Error() string {
+	return "file rejected by virus scanner: " + e.signature
+}
+
+// errScannerUnavailable is returned when a file could not be scanned.
+var errScannerUnavailable = errors.New("virus scanner unavailable, try again later")
+
+// uploadScanner checks data and returns the name of what it found, or ""
+// for a clean file.
+type uploadScanner func(filename string, data []byte) (string, error)
+
+func configuredScanner() uploadScanner {
+	switch os.Getenv("UPLOAD_SCANNER") {
+	case "clamav":
+		addr := os.Getenv("CLAMAV_ADDRESS")
+		if addr == "" {
+			addr = "127.0.0.1:3310"
+		}
+		return func(_ string, data []byte) (string, error) { return clamdScan(addr, data) }
+	case "http":
+		url := os.Getenv("UPLOAD_SCANNER_URL")
+		return func(filename string, data []byte) (string, error) { return httpScan(url, filename, data) }
+	}
+	return nil
+}
+
+// scanUpload runs the configured scanner over a file headed for location
+// (the directory under uploads/), quarantining it if it is flagged.
+func scanUpload(q *DB, orgID, location, filename string, data []byte) error {
+	scan := configuredScanner()
+	if scan == nil {
+		return nil
+	}
+	signature, err := scan(filename, data)
+	if err != nil {
+		log.Printf("upload scanner: %v", err)
+		if os.Getenv("UPLOAD_SCAN_FAIL_OPEN") == "true" {
+			return nil
+		}
+		return errScannerUnavailable
+	}
+	if signature == "" {
+		return nil
+	}
+	if err := quarantineUpload(q, orgID, location, filename, signature, data); err != nil {
+		log.Printf("upload quarantine: %v", err)
+	}
+	return &errUploadRejected{signature: signature}
+}
+
+func quarantineUpload(q *DB, orgID, location, filename, signature string, data []byte) error {
+	dir := filepath.Join("data", "quarantine")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	id := genID()
+	if err := os.WriteFile(filepath.Join(dir, id), data, 0o600); err != nil {
+		return err
+	}
+	_, err := q.Exec(`INSERT INTO quarantined_uploads (id,organization_id,location,filename,size,signature,created_at) VALUES (?,?,?,?,?,?,?)`,
+		id, orgID, location, filename, len(data), signature, time.Now().Format(time.RFC3339))
+	return err
+}
+
+// uploadErrorResponse answers a failed upload with a status that tells the
+// client whether retrying can help.
+func uploadErrorResponse(c *fiber.Ctx, err error) error {
+	var rejected *errUploadRejected
+	switch {
+	case errors.As(err, &rejected):
+		return c.Status(422).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, errScannerUnavailable):
+		return c.Status(503).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+// clamdScan streams data to clamd with the INSTREAM command.
+func clamdScan(addr string, data []byte) (string, error) {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, addr, 5*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(time.Minute))
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", err
+	}
+	const chunk = 64 << 10
+	size := make([]byte, 4)
+	for off := 0; off < len(data); off += chunk {
+		end := off + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		binary.BigEndian.PutUint32(size, uint32(end-off))
+		if _, err := conn.Write(size); err != nil {
+			return "", err
+		}
+		if _, err := conn.Write(data[off:end]); err != nil {
+			return "", err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", err
+	}
+	reply, err := io.ReadAll(conn)
+	if err != nil {
+		return "", err
+	}
+	// "stream: OK", "stream: <signature> FOUND" or "... ERROR"
+	result := strings.TrimSpace(strings.TrimRight(string(reply), "\x00"))
+	result = strings.TrimPrefix(result, "stream: ")
+	switch {
+	case result == "OK":
+		return "", nil
+	case strings.HasSuffix(result, " FOUND"):
+		return strings.TrimSuffix(result, " FOUND"), nil
+	}
+	return "", fmt.Errorf("clamd: %s", result)
+}
+
+func httpScan(url, filename string, data []byte) (string, error) {
+	if url == "" {
+		return "", fmt.Errorf("UPLOAD_SCANNER_URL is not set")
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Filename", filename)
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("scanner returned %s", resp.Status)
+	}
+	var verdict struct {
+		Clean     bool   `json:"clean"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return "", fmt.Errorf("decode scanner response: %w", err)
+	}
+	if verdict.Clean {
+		return "", nil
+	}
+	if verdict.Signature == "" {
+		verdict.Signature = "unspecified"
+	}
+	return verdict.Signature, nil
+}
+
+func handleListQuarantine(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT id, location, filename, size, signature, created_at FROM quarantined_uploads WHERE organization_id = ? ORDER BY created_at DESC`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeClamd answers INSTREAM requests, flagging data containing "EICAR".
+func fakeClamd(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			cmd := make([]byte, len("zINSTREAM\x00"))
+			if _, err := io.ReadFull(conn, cmd); err != nil {
+				conn.Close()
+				continue
+			}
+			var data []byte
+			size := make([]byte, 4)
+			for {
+				if _, err := io.ReadFull(conn, size); err != nil {
+					break
+				}
+				n := binary.BigEndian.Uint32(size)
+				if n == 0 {
+					break
+				}
+				chunk := make([]byte, n)
+				if _, err := io.ReadFull(conn, chunk); err != nil {
+					break
+				}
+				data = append(data, chunk...)
+			}
+			if bytes.Contains(data, []byte("EICAR")) {
+				conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+			} else {
+				conn.Write([]byte("stream: OK\x00"))
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClamdScan(t *testing.T) {
+	addr := fakeClamd(t)
+	if sig, err := clamdScan(addr, bytes.Repeat([]byte("clean "), 30000)); err != nil || sig != "" {
+		t.Errorf("clean file: %q, %v", sig, err)
+	}
+	if sig, err := clamdScan(addr, []byte("X5O!P%@AP EICAR")); err != nil || sig != "Eicar-Test-Signature" {
+		t.Errorf("infected file: %q, %v", sig, err)
+	}
+}
+
+func TestInfectedUploadIsQuarantined(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,'org-1')`); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	scanner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if bytes.Contains(body, []byte("EICAR")) {
+			w.Write([]byte(`{"clean":false,"signature":"Eicar-Test-Signature"}`))
+			return
+		}
+		w.Write([]byte(`{"clean":true}`))
+	}))
+	defer scanner.Close()
+	t.Setenv("UPLOAD_SCANNER", "http")
+	t.Setenv("UPLOAD_SCANNER_URL", scanner.URL)
+
+	app := newApp()
+	token := testToken(t, "admin")
+	upload := func(name, content string) int {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", name)
+		fw.Write([]byte(content))
+		mw.Close()
+		req := httptest.NewRequest("POST", "/api/collections/inventory_items/records/i-1/files/image", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+
+	if code := upload("bad.txt", "X5O!P%@AP EICAR"); code != 422 {
+		t.Errorf("infected upload: got %d, want 422", code)
+	}
+	if _, err := os.Stat(filepath.Join("uploads", "inventory_items", "i-1", "bad.txt")); !os.IsNotExist(err) {
+		t.Errorf("infected file was stored: %v", err)
+	}
+	var id, sig string
+	if err := db.QueryRow(`SELECT id, signature FROM quarantined_uploads WHERE organization_id = 'org-1'`).Scan(&id, &sig); err != nil || sig != "Eicar-Test-Signature" {
+		t.Fatalf("quarantine row: %q, %v", sig, err)
+	}
+	if _, err := os.Stat(filepath.Join("data", "quarantine", id)); err != nil {
+		t.Errorf("quarantined file missing: %v", err)
+	}
+
+	if code := upload("good.txt", "hello"); code != 200 {
+		t.Errorf("clean upload: got %d, want 200", code)
+	}
+
+	scanner.Close()
+	if code := upload("later.txt", "hello"); code != 503 {
+		t.Errorf("scanner down: got %d, want 503", code)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Numbering sequences produce human-readable document numbers such as
+// INV-2026-00042. The prefix may contain {YYYY} or {YY}, which are replaced
+// with the current year; yearly sequences restart at 1 each January, and
+// daily ones (such as order tokens) each morning. Every sale is numbered
+// from the invoice sequence as it is created, and shows the number as its
+// invoice_no.
+// Sequences are kept per organization and created on first use from the
+// defaults below.
+
+type numberSequence struct {
+	Key        string `json:"key"`
+	Prefix     string `json:"prefix"`
+	Padding    int    `json:"padding"`
+	NextNumber int    `json:"next_number"`
+	Reset      string `json:"reset"`
+	Period     string `json:"period"`
+}
+
+var defaultSequences = map[string]numberSequence{
+	"invoice":        {Prefix: "INV-", Padding: 5, NextNumber: 1, Reset: "never"},
+	"quote":          {Prefix: "QT-", Padding: 5, NextNumber: 1, Reset: "never"},
+	"purchase_order": {Prefix: "PO-", Padding: 5, NextNumber: 1, Reset: "never"},
+	"grn":            {Prefix: "GRN-", Padding: 5, NextNumber: 1, Reset: "never"},
+	"credit_note":    {Prefix: "CN-", Padding: 5, NextNumber: 1, Reset: "never"},
+	"stocktake":      {Prefix: "ST-", Padding: 5, NextNumber: 1, Reset: "never"},
+	"batch":          {Prefix: "LOT-", Padding: 5, NextNumber: 1, Reset: "never"},
+	"order_token":    {Prefix: "", Padding: 0, NextNumber: 1, Reset: "daily"},
+}
+
+func registerSequenceRoutes(app *fiber.App) {
+	r := app.Group("/api/settings/sequences", requireAuth)
+	r.Get("/", handleListSequences)
+	r.Put("/:key", requireRole("admin", "manager"), handlePutSequence)
+	r.Get("/:key/preview", handlePreviewSequence)
+	r.Post("/:key/next", handleNextSequence)
+}
+
+func (s numberSequence) format(n int, now time.Time) string {
+	return s.prefixFor(now) + fmt.Sprintf("%0*d", s.Padding, n)
+}
+
+// prefixFor is the prefix with the year of now filled in.
+func (s numberSequence) prefixFor(now time.Time) string {
+	return strings.NewReplacer("{YYYY}", now.Format("2006"), "{YY}", now.Format("06")).Replace(s.Prefix)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func loadSequence(q queryer, orgID, key string) (numberSequence, error) {
+	s := numberSequence{Key: key}
+	err := q.QueryRow(`SELECT prefix, padding, next_number, reset, COALESCE(period, '') FROM number_sequences WHERE organization_id = ? AND key = ?`, orgID, key).Scan(&s.Prefix, &s.Padding, &s.NextNumber, &s.Reset, &s.Period)
+	if err == sql.ErrNoRows {
+		d, ok := defaultSequences[key]
+		if !ok {
+			return s, fiber.NewError(404, "unknown sequence "+key)
+		}
+		d.Key = key
+		return d, nil
+	}
+	return s, err
+}
+
+// periodOf is the period a resetting sequence numbers in at now: the year
+// or the day.
+func (s numberSequence) periodOf(now time.Time) string {
+	switch s.Reset {
+	case "yearly":
+		return now.Format("2006")
+	case "daily":
+		return now.Format("2006-01-02")
+	}
+	return ""
+}
+
+// numberFor returns the number the sequence would issue now, applying a
+// reset if the stored period is out of date.
+func (s numberSequence) numberFor(now time.Time) (int, string) {
+	period := s.Period
+	n := s.NextNumber
+	if p := s.periodOf(now); p != "" && period != p {
+		n = 1
+		period = p
+	}
+	return n, period
+}
+
+// nextSequenceNumber issues the next number of a sequence inside tx so the
+// number is only consumed if the surrounding write commits.
+func nextSequenceNumber(tx *Tx, orgID, key string) (string, error) {
+	s, n, err := reserveSequenceNumbers(tx, orgID, key, 1)
+	if err != nil {
+		return "", err
+	}
+	return s.format(n, time.Now()), nil
+}
+
+// reserveSequenceNumbers takes count numbers of a sequence inside tx and
+// returns the sequence and the first of them.
+func reserveSequenceNumbers(tx *Tx, orgID, key string, count int) (numberSequence, int, error) {
+	now := time.Now()
+	// touching the row first locks it, so two sales committing at once
+	// cannot both read the same next_number
+	if d, ok := defaultSequences[key]; ok {
+		if _, err := tx.Exec(`INSERT INTO number_sequences (organization_id,key,prefix,padding,next_number,reset,period,updated_at) VALUES (?,?,?,?,?,?,?,?)
+			ON CONFLICT(organization_id,key) DO UPDATE SET updated_at = excluded.updated_at`,
+			orgID, key, d.Prefix, d.Padding, d.NextNumber, d.Reset, "", now.Format(time.RFC3339)); err != nil {
+			return d, 0, err
+		}
+	}
+	s, err := loadSequence(tx, orgID, key)
+	if err != nil {
+		return s, 0, err
+	}
+	n, period := s.numberFor(now)
+	_, err = tx.Exec(`INSERT INTO number_sequences (organization_id,key,prefix,padding,next_number,reset,period,updated_at) VALUES (?,?,?,?,?,?,?,?)
+		ON CONFLICT(organization_id,key) DO UPDATE SET next_number = excluded.next_number, period = excluded.period, updated_at = excluded.updated_at`,
+		orgID, key, s.Prefix, s.Padding, n+count, s.Reset, period, now.Format(time.RFC3339))
+	return s, n, err
+}
+
+func handleListSequences(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	items := []fiber.Map{}
+	seen := map[string]bool{}
+	rows, err := dbFor(c).Query(`SELECT key FROM number_sequences WHERE organization_id = ? ORDER BY key`, orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var keys []string
+	for rows.Next() {
+		var k string
+		if err := rows.Scan(&k); err == nil {
+			keys = append(keys, k)
+		}
+	}
+	rows.Close()
+	for k := range defaultSequences {
+		keys = append(keys, k)
+	}
+	now := time.Now()
+	for _, k := range keys {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		s, err := loadSequence(dbFor(c), orgID, k)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		n, _ := s.numberFor(now)
+		items = append(items, fiber.Map{"key": k, "prefix": s.Prefix, "padding": s.Padding, "next_number": s.NextNumber, "reset": s.Reset, "preview": s.format(n, now)})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func handlePutSequence(c *fiber.Ctx) error {
+	key := c.Params("key")
+	orgID := currentOrgID(c)
+	var body map[string]interface{}
+	if err := json.Unmarshal(c.Body(), &body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	s, err := loadSequence(dbFor(c), orgID, key)
+	if err != nil {
+		// allow defining new custom sequences
+		s = numberSequence{Key: key, Padding: 5, NextNumber: 1, Reset: "never"}
+	}
+	if v, ok := body["prefix"].(string); ok {
+		s.Prefix = v
+	}
+	if v, ok := body["padding"].(float64); ok {
+		if v < 0 || v > 12 {
+			return c.Status(400).JSON(fiber.Map{"error": "padding must be between 0 and 12"})
+		}
+		s.Padding = int(v)
+	}
+	if v, ok := body["next_number"].(float64); ok {
+		if v < 1 {
+			return c.Status(400).JSON(fiber.Map{"error": "next_number must be at least 1"})
+		}
+		s.NextNumber = int(v)
+		s.Period = ""
+	}
+	if v, ok := body["reset"].(string); ok {
+		if v != "never" && v != "yearly" && v != "daily" {
+			return c.Status(400).JSON(fiber.Map{"error": "reset must be never, yearly or daily"})
+		}
+		if v != s.Reset {
+			s.Reset, s.Period = v, ""
+		}
+	}
+	if s.Period == "" {
+		s.Period = s.periodOf(time.Now())
+	}
+	_, err = dbFor(c).Exec(`INSERT INTO number_sequences (organization_id,key,prefix,padding,next_number,reset,period,updated_at) VALUES (?,?,?,?,?,?,?,?)
+		ON CONFLICT(organization_id,key) DO UPDATE SET prefix = excluded.prefix, padding = excluded.padding, next_number = excluded.next_number, reset = excluded.reset, period = excluded.period, updated_at = excluded.updated_at`,
+		orgID, key, s.Prefix, s.Padding, s.NextNumber, s.Reset, s.Period, time.Now().Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	n, _ := s.numberFor(time.Now())
+	return c.JSON(fiber.Map{"key": key, "prefix": s.Prefix, "padding": s.Padding, "next_number": s.NextNumber, "reset": s.Reset, "preview": s.format(n, time.Now())})
+}
+
+func handlePreviewSequence(c *fiber.Ctx) error {
+	s, err := loadSequence(dbFor(c), currentOrgID(c), c.Params("key"))
+	if err != nil {
+		if fe, ok := err.(*fiber.Error); ok {
+			return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now()
+	n, _ := s.numberFor(now)
+	return c.JSON(fiber.Map{"key": s.Key, "number": s.format(n, now), "next_number": n})
+}
+
+// handleNextSequence issues a number for documents numbered client-side.
+func handleNextSequence(c *fiber.Ctx) error {
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	number, err := nextSequenceNumber(tx, currentOrgID(c), c.Params("key"))
+	if err != nil {
+		if fe, ok := err.(*fiber.Error); ok {
+			return c.Status(fe.Code).JSON(fiber.Map{"error": fe.Message})
+		}
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"key": c.Params("key"), "number": number})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvoiceNumbers(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	create := func(typ string) map[string]interface{} {
+		t.Helper()
+		code, out := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"`+typ+`","amount":50,"paid_amount":50,"due_amount":0,"contact_id":"c-1"}`)
+		if code != 200 {
+			t.Fatalf("%s: %d %v", typ, code, out)
+		}
+		return out
+	}
+	year := time.Now().Format("2006")
+
+	if code, out := call("manager", "PUT", "/api/settings/sequences/invoice", `{"prefix":"INV-{YYYY}-","padding":4,"reset":"yearly"}`); code != 200 {
+		t.Fatalf("configure: %d %v", code, out)
+	}
+	first := create("inflow")
+	if first["invoice_no"] != "INV-"+year+"-0001" {
+		t.Errorf("first sale: %v", first)
+	}
+	if purchase := create("outflow"); purchase["invoice_no"] != nil {
+		t.Errorf("a purchase was numbered: %v", purchase)
+	}
+	if second := create("inflow"); second["invoice_no"] != "INV-"+year+"-0002" {
+		t.Errorf("second sale: %v", second)
+	}
+
+	_, got := call("viewer", "GET", "/api/collections/transactions/records/"+first["id"].(string), "")
+	if got["invoice_no"] != "INV-"+year+"-0001" {
+		t.Errorf("get: %v", got)
+	}
+	_, list := call("viewer", "GET", "/api/collections/transactions/records?filter=type='inflow'&sort=created_at", "")
+	items, _ := list["items"].([]interface{})
+	if len(items) != 2 || items[0].(map[string]interface{})["invoice_no"] == nil {
+		t.Errorf("list: %v", list)
+	}
+
+	// a new year starts the numbering again
+	for _, q := range []string{
+		`UPDATE transactions SET receipt_number = REPLACE(receipt_number, '` + year + `', '2001')`,
+		`UPDATE number_sequences SET period = '2001' WHERE organization_id = 'org-1' AND key = 'invoice'`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sale := create("inflow"); sale["invoice_no"] != "INV-"+year+"-0001" {
+		t.Errorf("after the year turned: %v", sale)
+	}
+}
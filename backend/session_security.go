@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Organizations can restrict where their sensitive roles (restricted_roles,
+// admin by default) work from:
+//
+//   - ip_allowlist lists the addresses and CIDR ranges those roles may use;
+//     empty allows any.
+//   - device_binding ties each of those users to the devices an admin has
+//     approved. Clients identify a device by sending a stable random
+//     X-Device-ID header; a user's first device is approved on first use,
+//     later ones are held until an admin approves them.
+//
+// Both are checked when tokens are issued and again on every request, so
+// changing the allowlist or revoking a device ends sessions at once. Logins
+// of restricted roles from a device the user has not used before raise a
+// new_device alert, sent like other alerts (see alerts.go).
+
+const deviceHeader = "X-Device-ID"
+
+func registerDeviceRoutes(app *fiber.App) {
+	r := app.Group("/api/devices", requireAuth, requireRole("admin"))
+	r.Get("/", handleListDevices)
+	r.Post("/:id/approve", handleApproveDevice)
+	r.Delete("/:id", handleRevokeDevice)
+}
+
+func isRestrictedRole(orgID, role string) bool {
+	for _, r := range toSlice(orgSetting(orgID, "restricted_roles")) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ipAllowed reports whether ip is in allowlist; an empty list allows all.
+func ipAllowed(ip string, allowlist []interface{}) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	addr := net.ParseIP(ip)
+	for _, entry := range allowlist {
+		s, _ := entry.(string)
+		if _, network, err := net.ParseCIDR(s); err == nil {
+			if addr != nil && network.Contains(addr) {
+				return true
+			}
+		} else if allowed := net.ParseIP(s); allowed != nil && allowed.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateIPAllowlist checks an ip_allowlist setting.
+func validateIPAllowlist(v interface{}) string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return "ip_allowlist must be a list of addresses or CIDR ranges"
+	}
+	for _, entry := range list {
+		s, _ := entry.(string)
+		if _, _, err := net.ParseCIDR(s); err != nil && net.ParseIP(s) == nil {
+			return fmt.Sprintf("ip_allowlist: %q is not an address or CIDR range", s)
+		}
+	}
+	return ""
+}
+
+// validateRestrictedRoles checks a restricted_roles setting.
+func validateRestrictedRoles(v interface{}) string {
+	list, ok := v.([]interface{})
+	if !ok {
+		return "restricted_roles must be a list of roles"
+	}
+	for _, r := range list {
+		if s, _ := r.(string); !isValidRole(s) {
+			return fmt.Sprintf("restricted_roles: %v is not a role", r)
+		}
+	}
+	return ""
+}
+
+// checkSessionAccess decides whether userID may get tokens for orgID with
+// role from this request, registering the device it comes from. It
+// returns 0 when allowed, else a status and message for the response.
+func checkSessionAccess(c *fiber.Ctx, userID, orgID, role string) (int, string) {
+	if !isRestrictedRole(orgID, role) {
+		return 0, ""
+	}
+	if !ipAllowed(c.IP(), toSlice(orgSetting(orgID, "ip_allowlist"))) {
+		return 403, "signing in from " + c.IP() + " is not allowed for your role"
+	}
+	deviceKey := strings.TrimSpace(c.Get(deviceHeader))
+	binding, _ := orgSetting(orgID, "device_binding").(bool)
+	if deviceKey == "" {
+		if binding {
+			return 403, "this organization only allows registered devices; send " + deviceHeader
+		}
+		return 0, ""
+	}
+	approved, err := registerDevice(c, userID, orgID, deviceKey)
+	if err != nil {
+		return 500, err.Error()
+	}
+	if binding && !approved {
+		return 403, "this device is waiting for an admin to approve it"
+	}
+	return 0, ""
+}
+
+// registerDevice records a login of userID in orgID from deviceKey and
+// reports whether the device is approved. A new device is approved when
+// it is the user's first there; otherwise it raises a new_device alert.
+func registerDevice(c *fiber.Ctx, userID, orgID, deviceKey string) (bool, error) {
+	now := time.Now().Format(time.RFC3339)
+	var id string
+	var approvedAt, revokedAt sql.NullString
+	err := dbFor(c).QueryRow(`SELECT id, approved_at, revoked_at FROM devices WHERE organization_id = ? AND user_id = ? AND device_key = ?`, orgID, userID, deviceKey).Scan(&id, &approvedAt, &revokedAt)
+	if err == nil {
+		_, err = dbFor(c).Exec(`UPDATE devices SET last_ip = ?, last_seen_at = ? WHERE id = ?`, c.IP(), now, id)
+		return approvedAt.Valid && !revokedAt.Valid, err
+	}
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	var known int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM devices WHERE organization_id = ? AND user_id = ?`, orgID, userID).Scan(&known); err != nil {
+		return false, err
+	}
+	id = genID()
+	var approved interface{}
+	if known == 0 {
+		approved = now
+	}
+	name := c.Get(fiber.HeaderUserAgent)
+	if _, err := dbFor(c).Exec(`INSERT INTO devices (id,organization_id,user_id,device_key,name,first_ip,last_ip,created_at,last_seen_at,approved_at) VALUES (?,?,?,?,?,?,?,?,?,?)`,
+		id, orgID, userID, deviceKey, name, c.IP(), c.IP(), now, now, approved); err != nil {
+		return false, err
+	}
+	if known > 0 {
+		var email string
+		if err := dbFor(c).QueryRow(`SELECT email FROM users WHERE id = ?`, userID).Scan(&email); err != nil {
+			return false, err
+		}
+		msg := fmt.Sprintf("%s signed in from a new device (%s, %s)", email, name, c.IP())
+		if _, err := raiseAlert(dbFor(c), orgID, "new_device", id, msg, fiber.Map{"user_id": userID, "email": email, "ip": c.IP(), "user_agent": name}); err != nil {
+			return false, err
+		}
+		notifyAlerts(dbFor(c), orgID, "new_device")
+	}
+	return known == 0, nil
+}
+
+// sessionAllowed re-checks the restrictions for an authenticated request,
+// so they apply to tokens issued before they changed.
+func sessionAllowed(c *fiber.Ctx, claims authClaims) (int, string) {
+	if !isRestrictedRole(claims.OrgID, claims.Role) {
+		return 0, ""
+	}
+	if !ipAllowed(c.IP(), toSlice(orgSetting(claims.OrgID, "ip_allowlist"))) {
+		return 403, "requests from " + c.IP() + " are not allowed for your role"
+	}
+	if binding, _ := orgSetting(claims.OrgID, "device_binding").(bool); binding {
+		var n int
+		if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM devices WHERE organization_id = ? AND user_id = ? AND device_key = ? AND approved_at IS NOT NULL AND revoked_at IS NULL`,
+			claims.OrgID, claims.Subject, strings.TrimSpace(c.Get(deviceHeader))).Scan(&n); err != nil {
+			return 500, err.Error()
+		}
+		if n == 0 {
+			return 401, "this device is not approved"
+		}
+	}
+	return 0, ""
+}
+
+// handleListDevices lists the devices of the organization's users,
+// optionally of one user or only those pending approval (?pending=true).
+func handleListDevices(c *fiber.Ctx) error {
+	query := `SELECT d.id, d.user_id, u.email, d.name, d.first_ip, d.last_ip, d.created_at, d.last_seen_at, d.approved_at, d.approved_by, d.revoked_at FROM devices d LEFT JOIN users u ON u.id = d.user_id WHERE d.organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	if userID := c.Query("user_id"); userID != "" {
+		query += ` AND d.user_id = ?`
+		args = append(args, userID)
+	}
+	if c.Query("pending") == "true" {
+		query += ` AND d.approved_at IS NULL AND d.revoked_at IS NULL`
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY d.last_seen_at DESC`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+func handleApproveDevice(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`UPDATE devices SET approved_at = ?, approved_by = ?, revoked_at = NULL WHERE id = ? AND organization_id = ?`,
+		time.Now().Format(time.RFC3339), currentUserID(c), c.Params("id"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "device not found"})
+	}
+	// approving the device settles its new_device alert
+	if _, err := dbFor(c).Exec(`UPDATE alerts SET status = 'resolved', resolved_at = ? WHERE organization_id = ? AND kind = 'new_device' AND ref_id = ? AND status <> 'resolved'`,
+		time.Now().Format(time.RFC3339), currentOrgID(c), c.Params("id")); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": c.Params("id"), "approved": true})
+}
+
+// handleRevokeDevice withdraws a device's approval; with device_binding
+// on, its sessions stop working on their next request.
+func handleRevokeDevice(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`UPDATE devices SET revoked_at = ? WHERE id = ? AND organization_id = ?`, time.Now().Format(time.RFC3339), c.Params("id"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "device not found"})
+	}
+	return c.SendStatus(204)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestIPAllowlistAndDeviceBinding(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if jwtSecret == nil {
+		jwtSecret = []byte("test-secret")
+	}
+	t.Setenv("PROXY_IP_HEADER", "X-Forwarded-For")
+	app := newApp()
+	type client struct{ ip, device, token string }
+	call := func(cl client, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", cl.ip)
+		if cl.device != "" {
+			req.Header.Set("X-Device-ID", cl.device)
+		}
+		if cl.token != "" {
+			req.Header.Set("Authorization", "Bearer "+cl.token)
+		}
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	login := `{"email":"owner@example.com","password":"password1"}`
+
+	office := client{ip: "10.1.2.3", device: "laptop"}
+	_, reg := call(office, "POST", "/api/auth/register", `{"email":"owner@example.com","password":"password1","name":"Owner"}`)
+	office.token = reg["token"].(string)
+
+	// the allowlist cannot shut out the admin setting it
+	home := client{ip: "192.168.1.5", device: "laptop", token: office.token}
+	if code, _ := call(home, "PUT", "/api/settings/organization", `{"ip_allowlist":["10.0.0.0/8"]}`); code != 400 {
+		t.Errorf("allowlist without own address: got %d, want 400", code)
+	}
+	if code, _ := call(office, "PUT", "/api/settings/organization", `{"ip_allowlist":["10.0.0.0/8","not-an-ip"]}`); code != 400 {
+		t.Errorf("invalid allowlist entry: got %d, want 400", code)
+	}
+	if code, _ := call(office, "PUT", "/api/settings/organization", `{"ip_allowlist":["10.0.0.0/8"]}`); code != 200 {
+		t.Fatalf("set allowlist: got %d", code)
+	}
+	if code, _ := call(home, "GET", "/api/auth/me", ""); code != 403 {
+		t.Errorf("existing session from outside the allowlist: got %d, want 403", code)
+	}
+	if code, _ := call(client{ip: "192.168.1.5", device: "laptop"}, "POST", "/api/auth/login", login); code != 403 {
+		t.Errorf("login from outside the allowlist: got %d, want 403", code)
+	}
+	if code, _ := call(office, "GET", "/api/auth/me", ""); code != 200 {
+		t.Errorf("session inside the allowlist: got %d", code)
+	}
+
+	// a new device is let in while binding is off, but raises an alert
+	if code, _ := call(client{ip: "10.9.9.9", device: "phone"}, "POST", "/api/auth/login", login); code != 200 {
+		t.Fatalf("login from a new device: got %d", code)
+	}
+	_, alerts := call(office, "GET", "/api/alerts?kind=new_device", "")
+	if items := alerts["items"].([]interface{}); len(items) != 1 || !strings.Contains(items[0].(map[string]interface{})["message"].(string), "10.9.9.9") {
+		t.Errorf("new device alerts = %v", alerts["items"])
+	}
+
+	if code, _ := call(office, "PUT", "/api/settings/organization", `{"device_binding":true}`); code != 200 {
+		t.Fatalf("enable device binding: got %d", code)
+	}
+	if code, _ := call(client{ip: "10.1.2.3"}, "POST", "/api/auth/login", login); code != 403 {
+		t.Errorf("login without a device id: got %d, want 403", code)
+	}
+	if code, _ := call(client{ip: "10.1.2.3", device: "tablet"}, "POST", "/api/auth/login", login); code != 403 {
+		t.Errorf("login from an unapproved device: got %d, want 403", code)
+	}
+	if code, _ := call(client{ip: "10.9.9.9", device: "phone", token: office.token}, "GET", "/api/auth/me", ""); code != 401 {
+		t.Errorf("request from an unapproved device: got %d, want 401", code)
+	}
+	_, devices := call(office, "GET", "/api/devices?pending=true", "")
+	pending := devices["items"].([]interface{})
+	if len(pending) != 2 {
+		t.Fatalf("pending devices = %v, want phone and tablet", pending)
+	}
+	var tabletID string
+	for _, d := range pending {
+		d := d.(map[string]interface{})
+		if d["last_ip"] == "10.1.2.3" {
+			tabletID = d["id"].(string)
+		}
+	}
+	if code, _ := call(office, "POST", "/api/devices/"+tabletID+"/approve", ""); code != 200 {
+		t.Fatalf("approve: got %d", code)
+	}
+	code, tabletLogin := call(client{ip: "10.1.2.3", device: "tablet"}, "POST", "/api/auth/login", login)
+	if code != 200 {
+		t.Fatalf("login from the approved device: got %d", code)
+	}
+
+	// revoking the laptop ends its session at once
+	_, devices = call(office, "GET", "/api/devices", "")
+	var laptopID string
+	for _, d := range devices["items"].([]interface{}) {
+		if d := d.(map[string]interface{}); d["approved_at"] != nil && d["id"] != tabletID {
+			laptopID = d["id"].(string)
+		}
+	}
+	tablet := client{ip: "10.1.2.3", device: "tablet", token: tabletLogin["token"].(string)}
+	if code, _ := call(tablet, "DELETE", "/api/devices/"+laptopID, ""); code != 204 {
+		t.Fatalf("revoke: got %d", code)
+	}
+	if code, _ := call(office, "GET", "/api/auth/me", ""); code != 401 {
+		t.Errorf("session on a revoked device: got %d, want 401", code)
+	}
+
+	// other roles are not restricted
+	if code, _ := call(client{ip: "203.0.113.7", token: testToken(t, "cashier")}, "GET", "/api/auth/me", ""); code == 403 || code == 401 {
+		t.Errorf("cashier outside the allowlist: got %d", code)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	// zone data for the timezone setting on hosts without /usr/share/zoneinfo
+	_ "time/tzdata"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Settings are free-form key/value pairs stored per organization and per
+// user. Values are kept as JSON so numbers and booleans round-trip. The
+// effective value of a key is the user's value, else the organization's,
+// else the built-in default below. The user and organization are always
+// the ones in the caller's token; organization settings such as
+// duplicate_transaction_mode can only be changed by admins and managers.
+
+var defaultSettings = map[string]interface{}{
+	"currency_symbol": "৳",
+	"date_format":     "DD/MM/YYYY",
+	"receipt_footer":  "",
+	// the percentage items with no tax rate of their own are taxed at, and
+	// whether unit prices already include the tax; see tax.go
+	"default_tax_rate":   0.0,
+	"prices_include_tax": false,
+	// "warn", "block" or "off"; see duplicates.go
+	"duplicate_transaction_mode": "warn",
+	"duplicate_window_minutes":   5.0,
+	// days after a credit sale or purchase that it falls due when the
+	// transaction has no due_date; see cash_forecast.go
+	"payment_terms_days": 30.0,
+	// IANA zone the business keeps its hours in, for reports by hour of day
+	"timezone": "Asia/Dhaka",
+	// where new low stock alerts are sent, if anywhere; see alerts.go
+	"low_stock_alert_email":   "",
+	"low_stock_alert_webhook": "",
+	// fields each role cannot see; see masking.go
+	"hidden_fields": defaultHiddenFields,
+	// where and from which devices restricted_roles may sign in, and where
+	// new device alerts go; see session_security.go
+	"restricted_roles":         []interface{}{"admin"},
+	"ip_allowlist":             []interface{}{},
+	"device_binding":           false,
+	"new_device_alert_email":   "",
+	"new_device_alert_webhook": "",
+	// suspicious activity checks and where their alerts go; see anomalies.go
+	"failed_login_alert_threshold": 5.0,
+	"void_alert_threshold":         5.0,
+	"business_hours":               map[string]interface{}{"open": "08:00", "close": "22:00"},
+	"anomaly_alert_email":          "",
+	"anomaly_alert_webhook":        "",
+	// "approve" lets managers approve sales below an item's
+	// min_sale_price, "reject" refuses them; see min_price.go
+	"below_minimum_price": "approve",
+	// how often the stock's value is recorded: "daily", "weekly" or "off";
+	// see stock_snapshots.go
+	"stock_snapshot_frequency": "daily",
+	// customers are texted once a sale has been due this many days (0
+	// sends no reminders), and again every due_reminder_repeat_days; see
+	// due_reminders.go
+	"due_reminder_days":        0.0,
+	"due_reminder_repeat_days": 7.0,
+	"due_reminder_template":    defaultDueReminderTemplate,
+}
+
+func registerSettingsRoutes(app *fiber.App) {
+	r := app.Group("/api/settings", requireAuth)
+	r.Get("/", handleGetSettings)
+	r.Put("/user", handlePutSettings("user"))
+	r.Delete("/user/:key", handleDeleteSetting("user"))
+	r.Put("/organization", requireRole("admin", "manager"), handlePutSettings("organization"))
+	r.Delete("/organization/:key", requireRole("admin", "manager"), handleDeleteSetting("organization"))
+}
+
+func settingsScopeID(c *fiber.Ctx, scope string) string {
+	if scope == "user" {
+		return currentUserID(c)
+	}
+	return currentOrgID(c)
+}
+
+func loadSettings(q *DB, scope, scopeID string) (map[string]interface{}, error) {
+	out := map[string]interface{}{}
+	rows, err := q.Query(`SELECT key, value FROM settings WHERE scope = ? AND scope_id = ?`, scope, scopeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, raw string
+		if err := rows.Scan(&key, &raw); err != nil {
+			return nil, err
+		}
+		var v interface{}
+		if json.Unmarshal([]byte(raw), &v) == nil {
+			out[key] = v
+		}
+	}
+	return out, rows.Err()
+}
+
+// effectiveSettings merges defaults, organization and user settings.
+func effectiveSettings(q *DB, userID, orgID string) (map[string]interface{}, map[string]interface{}, map[string]interface{}, error) {
+	org, err := loadSettings(q, "organization", orgID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	user := map[string]interface{}{}
+	if userID != "" {
+		if user, err = loadSettings(q, "user", userID); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	merged := map[string]interface{}{}
+	for k, v := range defaultSettings {
+		merged[k] = v
+	}
+	for k, v := range org {
+		merged[k] = v
+	}
+	for k, v := range user {
+		merged[k] = v
+	}
+	return merged, org, user, nil
+}
+
+// orgSetting returns an organization-level setting or its default, for
+// server-side consumers that have no user context.
+func orgSetting(orgID, key string) interface{} {
+	org, err := loadSettings(db, "organization", orgID)
+	if err == nil {
+		if v, ok := org[key]; ok {
+			return v
+		}
+	}
+	return defaultSettings[key]
+}
+
+// businessLocation returns the time zone of orgID's timezone setting, or
+// server local time when it is not a known zone.
+func businessLocation(orgID string) *time.Location {
+	name, _ := orgSetting(orgID, "timezone").(string)
+	if loc, err := time.LoadLocation(name); err == nil && name != "" {
+		return loc
+	}
+	return time.Local
+}
+
+func handleGetSettings(c *fiber.Ctx) error {
+	merged, org, user, err := effectiveSettings(dbFor(c), currentUserID(c), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"settings": merged, "organization": org, "user": user})
+}
+
+func handlePutSettings(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		scopeID := settingsScopeID(c, scope)
+		if scope == "user" && scopeID == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "user is required for user settings"})
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(c.Body(), &body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+		tx, err := dbFor(c).Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer tx.Rollback()
+		now := time.Now().Format(time.RFC3339)
+		if v, ok := body["hidden_fields"]; ok {
+			if scope != "organization" {
+				return c.Status(400).JSON(fiber.Map{"error": "hidden_fields is an organization setting"})
+			}
+			if msg := validateHiddenFields(v); msg != "" {
+				return c.Status(400).JSON(fiber.Map{"error": msg})
+			}
+		}
+		for key, validate := range map[string]func(interface{}) string{"ip_allowlist": validateIPAllowlist, "restricted_roles": validateRestrictedRoles, "business_hours": validateBusinessHours} {
+			if v, ok := body[key]; ok {
+				if scope != "organization" {
+					return c.Status(400).JSON(fiber.Map{"error": key + " is an organization setting"})
+				}
+				if msg := validate(v); msg != "" {
+					return c.Status(400).JSON(fiber.Map{"error": msg})
+				}
+			}
+		}
+		for _, key := range []string{"device_binding", "prices_include_tax"} {
+			if v, ok := body[key]; ok {
+				if _, isBool := v.(bool); !isBool || scope != "organization" {
+					return c.Status(400).JSON(fiber.Map{"error": key + " is an organization setting, true or false"})
+				}
+			}
+		}
+		if v, ok := body["default_tax_rate"]; ok {
+			if rate, isNum := v.(float64); !isNum || rate < 0 || rate > 100 || scope != "organization" {
+				return c.Status(400).JSON(fiber.Map{"error": "default_tax_rate is an organization setting, a percentage from 0 to 100"})
+			}
+		}
+		for key, least := range map[string]float64{"due_reminder_days": 0, "due_reminder_repeat_days": 1} {
+			if v, ok := body[key]; ok {
+				if days, isNum := v.(float64); !isNum || days < least || days != float64(int(days)) || scope != "organization" {
+					return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("%s is an organization setting, a whole number of days from %d", key, int(least))})
+				}
+			}
+		}
+		if v, ok := body["due_reminder_template"]; ok {
+			if text, _ := v.(string); strings.TrimSpace(text) == "" || scope != "organization" {
+				return c.Status(400).JSON(fiber.Map{"error": "due_reminder_template is an organization setting, the text of the reminder"})
+			}
+		}
+		// an allowlist the caller is outside of would lock them out
+		if v, ok := body["ip_allowlist"]; ok && isRestrictedRole(currentOrgID(c), currentRole(c)) && !ipAllowed(c.IP(), toSlice(v)) {
+			return c.Status(400).JSON(fiber.Map{"error": "ip_allowlist must include your own address, " + c.IP()})
+		}
+		if tz, ok := body["timezone"]; ok {
+			if name, _ := tz.(string); name == "" {
+				return c.Status(400).JSON(fiber.Map{"error": "timezone must be an IANA time zone such as Asia/Dhaka"})
+			} else if _, err := time.LoadLocation(name); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "unknown timezone " + name})
+			}
+		}
+		for key, value := range body {
+			raw, err := json.Marshal(value)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "invalid value for " + key})
+			}
+			if _, err := tx.Exec(`INSERT INTO settings (scope,scope_id,key,value,updated_at) VALUES (?,?,?,?,?) ON CONFLICT(scope,scope_id,key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`, scope, scopeID, key, string(raw), now); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		settings, err := loadSettings(dbFor(c), scope, scopeID)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"scope": scope, "settings": settings})
+	}
+}
+
+func handleDeleteSetting(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if _, err := dbFor(c).Exec(`DELETE FROM settings WHERE scope = ? AND scope_id = ? AND key = ?`, scope, settingsScopeID(c, scope), c.Params("key")); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"ok": true})
+	}
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Card and mobile wallet payments reach the bank later and short of the
+// provider's fee. A payment method with settlement_days set is settled by
+// a provider: each day's payments by it are expected as one credit,
+// settlement_days later (in the business timezone), less fee_percent and
+// fee_fixed per payment. Credits the bank actually received are imported
+// from statements, and the reconciliation report pairs them with the
+// expected settlements.
+
+func registerSettlementRoutes(app *fiber.App) {
+	s := app.Group("/api/settlements", requireAuth, requireRole("admin", "manager"))
+	s.Get("/expected", handleExpectedSettlements)
+	s.Get("/credits", handleListSettlementCredits)
+	s.Post("/credits", handleImportSettlementCredits)
+	s.Delete("/credits/:id", handleDeleteSettlementCredit)
+	app.Get("/api/reports/settlement-reconciliation", requireAuth, requireRole("admin", "manager"), cachedReport, handleSettlementReconciliation)
+}
+
+// settlementBatch is what one provider is expected to pay in on one day.
+type settlementBatch struct {
+	Method   string  `json:"method"`
+	Date     string  `json:"settlement_date"`
+	Payments int     `json:"payments"`
+	Gross    float64 `json:"gross"`
+	Fees     float64 `json:"fees"`
+	Net      float64 `json:"net"`
+}
+
+// expectedSettlements returns the settlements of orgID's provider-settled
+// sales payments due between the dates from and to (YYYY-MM-DD,
+// inclusive), optionally for one method, by date then method.
+func expectedSettlements(q *DB, orgID, method, from, to string) ([]*settlementBatch, error) {
+	type terms struct {
+		feePercent, feeFixed float64
+		days                 int
+	}
+	query := `SELECT code, fee_percent, fee_fixed, settlement_days FROM payment_methods WHERE organization_id = ? AND settlement_days IS NOT NULL`
+	args := []interface{}{orgID}
+	if method != "" {
+		query += ` AND code = ?`
+		args = append(args, method)
+	}
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	settled := map[string]terms{}
+	maxDays := 0
+	for rows.Next() {
+		var code string
+		var t terms
+		if err := rows.Scan(&code, &t.feePercent, &t.feeFixed, &t.days); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		settled[code] = t
+		if t.days > maxDays {
+			maxDays = t.days
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(settled) == 0 {
+		return nil, nil
+	}
+
+	loc := businessLocation(orgID)
+	start, err := time.ParseInLocation("2006-01-02", from, loc)
+	if err != nil {
+		return nil, err
+	}
+	end, err := time.ParseInLocation("2006-01-02", to, loc)
+	if err != nil {
+		return nil, err
+	}
+	rows, err = q.Query(`SELECT method, amount, created_at FROM (`+paymentLinesSQL+`) l
+		WHERE organization_id = ? AND type = 'inflow' AND COALESCE(source, '') <> 'opening' AND created_at >= ? AND created_at < ?`,
+		orgID, start.AddDate(0, 0, -maxDays-1).Format(time.RFC3339), end.AddDate(0, 0, 1).Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	batches := map[string]*settlementBatch{}
+	var out []*settlementBatch
+	for rows.Next() {
+		var code, createdAt string
+		var amount float64
+		if err := rows.Scan(&code, &amount, &createdAt); err != nil {
+			return nil, err
+		}
+		t, ok := settled[code]
+		if !ok {
+			continue
+		}
+		paidAt, err := parseTime(createdAt)
+		if err != nil {
+			continue
+		}
+		date := paidAt.In(loc).AddDate(0, 0, t.days).Format("2006-01-02")
+		if date < from || date > to {
+			continue
+		}
+		b, ok := batches[code+"|"+date]
+		if !ok {
+			b = &settlementBatch{Method: code, Date: date}
+			batches[code+"|"+date] = b
+			out = append(out, b)
+		}
+		fee := amount*t.feePercent/100 + t.feeFixed
+		b.Payments++
+		b.Gross += amount
+		b.Fees += fee
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, b := range out {
+		b.Gross, b.Fees = round2(b.Gross), round2(b.Fees)
+		b.Net = round2(b.Gross - b.Fees)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Date != out[j].Date {
+			return out[i].Date < out[j].Date
+		}
+		return out[i].Method < out[j].Method
+	})
+	return out, nil
+}
+
+// settlementPeriod reads from/to as dates in the business timezone.
+func settlementPeriod(c *fiber.Ctx) (string, string, error) {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return "", "", err
+	}
+	loc := businessLocation(currentOrgID(c))
+	return from.In(loc).Format("2006-01-02"), to.In(loc).Format("2006-01-02"), nil
+}
+
+func handleExpectedSettlements(c *fiber.Ctx) error {
+	from, to, err := settlementPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	batches, err := expectedSettlements(dbFor(c), currentOrgID(c), c.Query("method"), from, to)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if batches == nil {
+		batches = []*settlementBatch{}
+	}
+	return c.JSON(fiber.Map{"from": from, "to": to, "items": batches})
+}
+
+func handleListSettlementCredits(c *fiber.Ctx) error {
+	from, to, err := settlementPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	query := `SELECT id, method, credited_on, amount, reference, description, created_at FROM settlement_credits WHERE organization_id = ? AND credited_on >= ? AND credited_on <= ?`
+	args := []interface{}{currentOrgID(c), from, to}
+	if method := c.Query("method"); method != "" {
+		query += ` AND method = ?`
+		args = append(args, method)
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY credited_on, method`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+type settlementCredit struct {
+	Date        string  `json:"date"`
+	Amount      float64 `json:"amount"`
+	Reference   string  `json:"reference"`
+	Description string  `json:"description"`
+}
+
+// parseStatementCSV reads bank statement rows with date and amount columns
+// and optional reference and description, located by header name.
+func parseStatementCSV(r io.Reader) ([]settlementCredit, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fiber.NewError(400, "csv header row is required")
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"date", "amount"} {
+		if _, ok := col[required]; !ok {
+			return nil, fiber.NewError(400, "csv is missing column "+required)
+		}
+	}
+	get := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	var credits []settlementCredit
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		amount, _ := strconv.ParseFloat(strings.ReplaceAll(get(rec, "amount"), ",", ""), 64)
+		credits = append(credits, settlementCredit{Date: get(rec, "date"), Amount: amount, Reference: get(rec, "reference"), Description: get(rec, "description")})
+	}
+	return credits, nil
+}
+
+// handleImportSettlementCredits stores a provider's credits from a bank
+// statement, as a CSV file upload (with a method form field) or as JSON
+// {method, credits: [{date, amount, reference, description}]}. Rows already
+// imported are skipped, so a statement can be imported again.
+func handleImportSettlementCredits(c *fiber.Ctx) error {
+	var req struct {
+		Method  string             `json:"method"`
+		Credits []settlementCredit `json:"credits"`
+	}
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer f.Close()
+		credits, err := parseStatementCSV(f)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+		req.Method, req.Credits = c.FormValue("method"), credits
+	} else if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	var known int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM payment_methods WHERE organization_id = ? AND code = ?`, orgID, req.Method).Scan(&known); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if known == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "unknown payment method " + strconv.Quote(req.Method)})
+	}
+	if len(req.Credits) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "no credits"})
+	}
+	loc := businessLocation(orgID)
+	for i, cr := range req.Credits {
+		d, err := time.ParseInLocation("2006-01-02", cr.Date, loc)
+		if err != nil {
+			t, perr := parseTime(cr.Date)
+			if perr != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "date must be YYYY-MM-DD", "line": i + 1})
+			}
+			d = t.In(loc)
+		}
+		if cr.Amount <= 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "amount must be positive", "line": i + 1})
+		}
+		req.Credits[i].Date = d.Format("2006-01-02")
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	now := time.Now().Format(time.RFC3339)
+	imported := 0
+	for _, cr := range req.Credits {
+		res, err := tx.Exec(`INSERT INTO settlement_credits (id,organization_id,method,credited_on,amount,reference,description,created_at) VALUES (?,?,?,?,?,?,?,?) ON CONFLICT DO NOTHING`,
+			genID(), orgID, req.Method, cr.Date, cr.Amount, cr.Reference, cr.Description, now)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			imported++
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"imported": imported, "skipped": len(req.Credits) - imported})
+}
+
+func handleDeleteSettlementCredit(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`DELETE FROM settlement_credits WHERE id = ? AND organization_id = ?`, c.Params("id"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "credit not found"})
+	}
+	return c.JSON(fiber.Map{"ok": true})
+}
+
+// handleSettlementReconciliation pairs each expected settlement with a
+// credit by the same provider on its date or up to tolerance_days (default
+// 2) later, closest amount first. A pair is matched when the amounts agree
+// to the paisa and short or over otherwise; expected settlements without a
+// credit are missing once their date has passed (pending until then), and
+// credits nothing expected are unexpected.
+func handleSettlementReconciliation(c *fiber.Ctx) error {
+	from, to, err := settlementPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	tolerance := 2
+	if v := c.Query("tolerance_days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c.Status(400).JSON(fiber.Map{"error": "tolerance_days must be zero or more"})
+		}
+		tolerance = n
+	}
+	orgID := currentOrgID(c)
+	method := c.Query("method")
+	batches, err := expectedSettlements(dbFor(c), orgID, method, from, to)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	type credit struct {
+		id, method, date, reference string
+		amount                      float64
+		used                        bool
+	}
+	// credits for settlements late in the period may land after it
+	loc := businessLocation(orgID)
+	end, _ := time.ParseInLocation("2006-01-02", to, loc)
+	query := `SELECT id, method, credited_on, amount, reference FROM settlement_credits WHERE organization_id = ? AND credited_on >= ? AND credited_on <= ?`
+	args := []interface{}{orgID, from, end.AddDate(0, 0, tolerance).Format("2006-01-02")}
+	if method != "" {
+		query += ` AND method = ?`
+		args = append(args, method)
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY credited_on`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	var credits []*credit
+	for rows.Next() {
+		cr := &credit{}
+		if err := rows.Scan(&cr.id, &cr.method, &cr.date, &cr.amount, &cr.reference); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		credits = append(credits, cr)
+	}
+	rows.Close()
+
+	today := time.Now().In(loc).Format("2006-01-02")
+	items := []fiber.Map{}
+	totals := fiber.Map{"expected": 0.0, "fees": 0.0, "credited": 0.0, "difference": 0.0}
+	counts := map[string]int{}
+	add := func(key string, v float64) { totals[key] = round2(totals[key].(float64) + v) }
+	for _, b := range batches {
+		latest := ""
+		if d, err := time.ParseInLocation("2006-01-02", b.Date, loc); err == nil {
+			latest = d.AddDate(0, 0, tolerance).Format("2006-01-02")
+		}
+		var best *credit
+		for _, cr := range credits {
+			if cr.used || cr.method != b.Method || cr.date < b.Date || cr.date > latest {
+				continue
+			}
+			if best == nil || math.Abs(cr.amount-b.Net) < math.Abs(best.amount-b.Net) {
+				best = cr
+			}
+		}
+		item := fiber.Map{"method": b.Method, "settlement_date": b.Date, "payments": b.Payments, "gross": b.Gross, "fees": b.Fees, "expected": b.Net, "credit_id": nil, "credited_on": nil, "credited": 0.0, "difference": -b.Net}
+		add("expected", b.Net)
+		add("fees", b.Fees)
+		status := "pending"
+		if best != nil {
+			best.used = true
+			diff := round2(best.amount - b.Net)
+			item["credit_id"], item["credited_on"], item["credited"], item["difference"] = best.id, best.date, best.amount, diff
+			add("credited", best.amount)
+			add("difference", diff)
+			switch {
+			case math.Abs(diff) < 0.01:
+				status = "matched"
+			case diff < 0:
+				status = "short"
+			default:
+				status = "over"
+			}
+		} else {
+			add("difference", -b.Net)
+			if latest < today {
+				status = "missing"
+			}
+		}
+		item["status"] = status
+		counts[status]++
+		items = append(items, item)
+	}
+	for _, cr := range credits {
+		if cr.used || cr.date > to {
+			continue
+		}
+		items = append(items, fiber.Map{"method": cr.method, "settlement_date": nil, "payments": 0, "gross": 0.0, "fees": 0.0, "expected": 0.0, "credit_id": cr.id, "credited_on": cr.date, "credited": cr.amount, "difference": cr.amount, "status": "unexpected"})
+		add("credited", cr.amount)
+		add("difference", cr.amount)
+		counts["unexpected"]++
+	}
+	return c.JSON(fiber.Map{"from": from, "to": to, "tolerance_days": tolerance, "items": items, "totals": totals, "counts": counts})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSettlementReconciliation(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','017','customer','org-1')`,
+		`INSERT INTO payment_methods (id,code,name,active,organization_id) VALUES ('pm-card','card','Card',1,'org-1'),('pm-bkash','bkash','bKash',1,'org-1'),('pm-cash','cash','Cash',1,'org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,payment_method,contact_id,organization_id,created_at) VALUES
+			('t-1','inflow',100000,100000,0,'card','c-1','org-1','2024-03-10T10:00:00+06:00'),
+			('t-2','inflow',150000,150000,0,'split','c-1','org-1','2024-03-10T23:30:00+06:00'),
+			('t-3','inflow',20000,20000,0,'card','c-1','org-1','2024-03-11T09:00:00+06:00'),
+			('t-4','inflow',30000,30000,0,'cash','c-1','org-1','2024-03-11T09:30:00+06:00')`,
+		`INSERT INTO transaction_payments (id,transaction_id,method,amount,created_at) VALUES
+			('p-1','t-1','card',1000,'2024-03-10T10:00:00+06:00'),
+			('p-2','t-2','card',500,'2024-03-10T23:30:00+06:00'),
+			('p-3','t-2','bkash',1000,'2024-03-10T23:30:00+06:00'),
+			('p-4','t-3','card',200,'2024-03-11T09:00:00+06:00')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("PATCH", "/api/collections/payment_methods/records/pm-card", `{"settlement_days":1.5}`); code != 400 {
+		t.Errorf("fractional settlement_days: got %d, want 400", code)
+	}
+	for id, body := range map[string]string{"pm-card": `{"fee_percent":2,"settlement_days":1}`, "pm-bkash": `{"fee_percent":1.85,"settlement_days":0}`} {
+		if code, _ := call("PATCH", "/api/collections/payment_methods/records/"+id, body); code != 200 {
+			t.Fatalf("configure %s: got %d", id, code)
+		}
+	}
+	period := "from=2024-03-01T00:00:00%2B06:00&to=2024-03-31T00:00:00%2B06:00"
+
+	// card takings of the 10th (Dhaka time) settle on the 11th less 2%
+	_, out := call("GET", "/api/settlements/expected?"+period, "")
+	want := []string{"bkash 2024-03-10 981.5", "card 2024-03-11 1470", "card 2024-03-12 196"}
+	items := out["items"].([]interface{})
+	if len(items) != len(want) {
+		t.Fatalf("expected settlements %v", items)
+	}
+	for i, it := range items {
+		b := it.(map[string]interface{})
+		if got := b["method"].(string) + " " + b["settlement_date"].(string) + " " + jsonNumber(b["net"]); got != want[i] {
+			t.Errorf("settlement %d: %s, want %s", i, got, want[i])
+		}
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	_ = w.WriteField("method", "card")
+	fw, _ := w.CreateFormFile("file", "statement.csv")
+	_, _ = fw.Write([]byte("Date,Amount,Reference\n2024-03-11,\"1,470.00\",CARD-11\n2024-03-13,190,CARD-12\n"))
+	_ = w.Close()
+	req := httptest.NewRequest("POST", "/api/settlements/credits", &buf)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "manager"))
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := app.Test(req)
+	if err != nil || resp.StatusCode != 200 {
+		t.Fatalf("statement import: %v %v", resp.StatusCode, err)
+	}
+	if _, out := call("POST", "/api/settlements/credits", `{"method":"bkash","credits":[{"date":"2024-03-15","amount":50,"reference":"BK-1"}]}`); out["imported"] != float64(1) {
+		t.Errorf("json import %v", out)
+	}
+	if _, out := call("POST", "/api/settlements/credits", `{"method":"bkash","credits":[{"date":"2024-03-15","amount":50,"reference":"BK-1"}]}`); out["skipped"] != float64(1) {
+		t.Errorf("re-import %v", out)
+	}
+	if code, _ := call("POST", "/api/settlements/credits", `{"method":"crypto","credits":[{"date":"2024-03-15","amount":50}]}`); code != 400 {
+		t.Errorf("unknown method: got %d, want 400", code)
+	}
+
+	_, out = call("GET", "/api/reports/settlement-reconciliation?"+period, "")
+	counts := out["counts"].(map[string]interface{})
+	if counts["matched"] != float64(1) || counts["short"] != float64(1) || counts["missing"] != float64(1) || counts["unexpected"] != float64(1) {
+		t.Errorf("reconciliation counts %v", counts)
+	}
+	if totals := out["totals"].(map[string]interface{}); totals["expected"] != 2647.5 || totals["credited"] != float64(1710) || totals["difference"] != -937.5 {
+		t.Errorf("reconciliation totals %v", totals)
+	}
+	_, out = call("GET", "/api/reports/settlement-reconciliation?tolerance_days=0&method=card&"+period, "")
+	if counts := out["counts"].(map[string]interface{}); counts["missing"] != float64(1) || counts["unexpected"] != float64(1) {
+		t.Errorf("card with no tolerance %v", counts)
+	}
+}
+
+func jsonNumber(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
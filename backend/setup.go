@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A new business is walked through setting itself up rather than
+// starting from sample data. The steps are done in order, each through
+// the endpoints that already look after that part of the business, and
+// are then marked complete here; a step is only complete once what it
+// needs is in place (a profile needs a name and phone number to print on
+// invoices). The optional steps may be skipped, and a completed step may
+// be completed again after changing what it covers. Once every step is
+// complete or skipped the organization's setup is complete.
+//
+//	GET  /api/setup                      every step, its status and what it still needs; current_step is the next to do
+//	POST /api/setup/:step/complete
+//	POST /api/setup/:step/skip           optional steps only
+
+type setupStep struct {
+	Key      string   `json:"key"`
+	Title    string   `json:"title"`
+	Optional bool     `json:"optional"`
+	Use      []string `json:"use"`
+	// missing lists what the step still needs
+	missing func(q *DB, orgID string) ([]string, error)
+}
+
+var setupSteps = []setupStep{
+	{Key: "profile", Title: "Business profile", Use: []string{"PUT /api/organization", "POST /api/organization/logo"}, missing: missingProfile},
+	{Key: "currency_tax", Title: "Currency and tax", Use: []string{"PUT /api/settings/organization", "POST /api/tax-rates"}},
+	{Key: "opening_balances", Title: "Opening balances", Optional: true, Use: []string{"POST /api/opening-balances/stock", "POST /api/opening-balances/contacts", "POST /api/opening-balances/cash"},
+		missing: missingWithout("an opening balance", "opening_balances")},
+	{Key: "import", Title: "Items and contacts", Optional: true, Use: []string{"POST /api/collections/inventory_items/import", "POST /api/collections/contacts/import"},
+		missing: missingWithout("an item or contact", "inventory_items", "contacts")},
+}
+
+func registerSetupRoutes(app *fiber.App) {
+	r := app.Group("/api/setup", requireAuth)
+	r.Get("/", handleGetSetup)
+	r.Post("/:step/complete", requireRole("admin", "manager"), handleSetupStep("done"))
+	r.Post("/:step/skip", requireRole("admin", "manager"), handleSetupStep("skipped"))
+}
+
+func missingProfile(q *DB, orgID string) ([]string, error) {
+	var name, phone string
+	err := q.QueryRow(`SELECT COALESCE(name, ''), COALESCE(phone, '') FROM organizations WHERE id = ?`, orgID).Scan(&name, &phone)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	missing := []string{}
+	if name == "" {
+		missing = append(missing, "name")
+	}
+	if phone == "" {
+		missing = append(missing, "phone")
+	}
+	return missing, nil
+}
+
+// missingWithout needs orgID to have a row in one of tables, what
+// describes such a row.
+func missingWithout(what string, tables ...string) func(*DB, string) ([]string, error) {
+	return func(q *DB, orgID string) ([]string, error) {
+		for _, table := range tables {
+			var n int
+			if err := q.QueryRow(`SELECT COUNT(1) FROM `+table+` WHERE organization_id = ?`, orgID).Scan(&n); err != nil {
+				return nil, err
+			}
+			if n > 0 {
+				return []string{}, nil
+			}
+		}
+		return []string{what}, nil
+	}
+}
+
+// setupProgress is orgID's setup: every step with its status, the step
+// to do next and when setup was completed.
+func setupProgress(q *DB, orgID string) (fiber.Map, error) {
+	done := map[string]fiber.Map{}
+	rows, err := q.Query(`SELECT step, status, COALESCE(completed_by, ''), completed_at FROM setup_steps WHERE organization_id = ?`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var step, status, by, at string
+		if err := rows.Scan(&step, &status, &by, &at); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		done[step] = fiber.Map{"status": status, "completed_by": by, "completed_at": at}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var completedAt sql.NullString
+	if err := q.QueryRow(`SELECT setup_completed_at FROM organizations WHERE id = ?`, orgID).Scan(&completedAt); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	steps := []fiber.Map{}
+	current, finished := "", 0
+	for _, s := range setupSteps {
+		step := fiber.Map{"key": s.Key, "title": s.Title, "optional": s.Optional, "use": s.Use, "status": "pending", "missing": []string{}}
+		if d, ok := done[s.Key]; ok {
+			for k, v := range d {
+				step[k] = v
+			}
+			finished++
+		} else if current == "" {
+			current = s.Key
+		}
+		if s.missing != nil {
+			missing, err := s.missing(q, orgID)
+			if err != nil {
+				return nil, err
+			}
+			step["missing"] = missing
+		}
+		steps = append(steps, step)
+	}
+	return fiber.Map{
+		"complete":     completedAt.Valid,
+		"completed_at": completedAt.String,
+		"current_step": current,
+		"finished":     finished,
+		"total":        len(setupSteps),
+		"steps":        steps,
+	}, nil
+}
+
+func handleGetSetup(c *fiber.Ctx) error {
+	progress, err := setupProgress(dbFor(c), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(progress)
+}
+
+// handleSetupStep marks a step done or skipped, once every step before it
+// is out of the way.
+func handleSetupStep(status string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID, key := currentOrgID(c), c.Params("step")
+		index := -1
+		for i, s := range setupSteps {
+			if s.Key == key {
+				index = i
+			}
+		}
+		if index < 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "unknown setup step " + key})
+		}
+		step := setupSteps[index]
+		if status == "skipped" && !step.Optional {
+			return c.Status(400).JSON(fiber.Map{"error": "the " + key + " step cannot be skipped"})
+		}
+		for _, before := range setupSteps[:index] {
+			var n int
+			if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM setup_steps WHERE organization_id = ? AND step = ?`, orgID, before.Key).Scan(&n); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if n == 0 {
+				return c.Status(409).JSON(fiber.Map{"error": "finish the " + before.Key + " step first", "current_step": before.Key})
+			}
+		}
+		if status == "done" && step.missing != nil {
+			missing, err := step.missing(dbFor(c), orgID)
+			if err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+			if len(missing) > 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "the " + key + " step still needs more", "missing": missing})
+			}
+		}
+
+		tx, err := dbFor(c).Begin()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer tx.Rollback()
+		now := time.Now().Format(time.RFC3339)
+		if _, err := tx.Exec(`INSERT INTO setup_steps (organization_id,step,status,completed_by,completed_at) VALUES (?,?,?,?,?)
+			ON CONFLICT(organization_id,step) DO UPDATE SET status = excluded.status, completed_by = excluded.completed_by, completed_at = excluded.completed_at`,
+			orgID, key, status, currentUserID(c), now); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		var finished int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM setup_steps WHERE organization_id = ?`, orgID).Scan(&finished); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if finished == len(setupSteps) {
+			if _, err := tx.Exec(`UPDATE organizations SET setup_completed_at = ? WHERE id = ? AND setup_completed_at IS NULL`, now, orgID); err != nil {
+				return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return handleGetSetup(c)
+	}
+}
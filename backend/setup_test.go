@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetup(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','','','active')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	_, setup := call("viewer", "GET", "/api/setup", "")
+	if setup["complete"] != false || setup["current_step"] != "profile" || setup["total"] != 4.0 {
+		t.Fatalf("fresh setup: %v", setup)
+	}
+	if missing := setup["steps"].([]interface{})[0].(map[string]interface{})["missing"].([]interface{}); len(missing) != 2 {
+		t.Errorf("profile needs: %v", missing)
+	}
+	if code, _ := call("cashier", "POST", "/api/setup/profile/complete", ""); code != 403 {
+		t.Errorf("cashier: got %d, want 403", code)
+	}
+	if code, out := call("manager", "POST", "/api/setup/profile/complete", ""); code != 400 || len(out["missing"].([]interface{})) != 2 {
+		t.Errorf("empty profile: %d %v", code, out)
+	}
+	if code, _ := call("manager", "POST", "/api/setup/profile/skip", ""); code != 400 {
+		t.Errorf("skipping the profile: got %d, want 400", code)
+	}
+	if code, out := call("manager", "POST", "/api/setup/currency_tax/complete", ""); code != 409 || out["current_step"] != "profile" {
+		t.Errorf("out of order: %d %v", code, out)
+	}
+	if code, _ := call("manager", "POST", "/api/setup/nope/complete", ""); code != 404 {
+		t.Errorf("unknown step: got %d, want 404", code)
+	}
+
+	if code, _ := call("manager", "PUT", "/api/organization", `{"name":"Rahim Store","phone":"01711000000"}`); code != 200 {
+		t.Fatalf("profile: %d", code)
+	}
+	if code, out := call("manager", "POST", "/api/setup/profile/complete", ""); code != 200 || out["current_step"] != "currency_tax" || out["finished"] != 1.0 {
+		t.Errorf("profile done: %d %v", code, out)
+	}
+	if code, out := call("manager", "POST", "/api/setup/currency_tax/complete", ""); code != 200 || out["current_step"] != "opening_balances" {
+		t.Errorf("currency done: %d %v", code, out)
+	}
+	if code, out := call("manager", "POST", "/api/setup/opening_balances/skip", ""); code != 200 || out["current_step"] != "import" {
+		t.Errorf("opening balances skipped: %d %v", code, out)
+	}
+	if code, _ := call("manager", "POST", "/api/setup/import/complete", ""); code != 400 {
+		t.Errorf("nothing imported: got %d, want 400", code)
+	}
+	if code, _ := call("manager", "POST", "/api/collections/contacts/records", `{"name":"Karim","phone":"","type":"customer"}`); code != 200 {
+		t.Fatalf("contact: %d", code)
+	}
+	code, out := call("manager", "POST", "/api/setup/import/complete", "")
+	if code != 200 || out["complete"] != true || out["current_step"] != "" || out["completed_at"] == "" {
+		t.Fatalf("setup complete: %d %v", code, out)
+	}
+	if status := out["steps"].([]interface{})[2].(map[string]interface{})["status"]; status != "skipped" {
+		t.Errorf("opening balances: %v", status)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// adjustStock changes an item's sellable quantity inside tx and records the
+// matching inventory_transactions row. The returned status is the HTTP code
+// to use when err is non-nil.
+func adjustStock(tx *Tx, itemID string, change int, txType, notes string) (int, error) {
+	return adjustStockAtCost(tx, itemID, change, nil, txType, notes)
+}
+
+// receiveStock is adjustStock for purchased stock, recording what each unit
+// cost for inventory valuation.
+func receiveStock(tx *Tx, itemID string, quantity int, unitCost float64, txType, notes string) (int, error) {
+	return adjustStockAtCost(tx, itemID, quantity, &unitCost, txType, notes)
+}
+
+func adjustStockAtCost(tx *Tx, itemID string, change int, unitCost *float64, txType, notes string) (int, error) {
+	var current int
+	if err := tx.QueryRow(`SELECT quantity FROM inventory_items WHERE id = ?`, itemID).Scan(&current); err != nil {
+		if err == sql.ErrNoRows {
+			return 404, fiber.NewError(404, "item not found")
+		}
+		return 500, err
+	}
+	newQty := current + change
+	if newQty < 0 {
+		return 409, fiber.NewError(409, "not enough stock")
+	}
+	now := time.Now().Format(time.RFC3339)
+	if _, err := tx.Exec(`UPDATE inventory_items SET quantity = ?, updated_at = ? WHERE id = ?`, newQty, now, itemID); err != nil {
+		return 500, err
+	}
+	stockChanged(tx, itemID)
+	if _, err := tx.Exec(`INSERT INTO inventory_transactions (id,item_id,quantity_change,previous_quantity,new_quantity,transaction_type,notes,unit_cost,organization_id,created_at) VALUES (?,?,?,?,?,?,?,?,`+itemOrgSQL+`,?)`, genID(), itemID, change, current, newQty, txType, notes, unitCost, itemID, now); err != nil {
+		return 500, err
+	}
+	return 0, nil
+}
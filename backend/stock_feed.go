@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// POS terminals keep the stock on screen current without re-listing the
+// inventory:
+//
+//	GET /api/stock?ids=a,b,c         quantities of those items now
+//	GET /api/stock/stream?ids=a,b,c  the same as a snapshot event, then a
+//	                                 stock event whenever one changes
+//
+// The stream is server-sent events; it needs the bearer token, so read it
+// with fetch rather than EventSource. Changes are fed by the write path:
+// whatever changes an item's quantity inside a transaction calls
+// stockChanged, and the new quantities go out as soon as it commits.
+
+const stockFeedMaxItems = 500
+
+type stockSubscriber struct {
+	orgID  string
+	ids    map[string]bool
+	events chan []byte
+}
+
+type stockHub struct {
+	sync.Mutex
+	subscribers map[*stockSubscriber]bool
+}
+
+var stockFeed = &stockHub{subscribers: map[*stockSubscriber]bool{}}
+
+func registerStockFeedRoutes(app *fiber.App) {
+	app.Get("/api/stock", requireAuth, handleStockSnapshot)
+	app.Get("/api/stock/stream", requireAuth, handleStockStream)
+}
+
+// stockChanged notes that tx changed the quantity of itemID.
+func stockChanged(tx *Tx, itemID string) {
+	if tx.stockItems == nil {
+		tx.stockItems = map[string]bool{}
+	}
+	tx.stockItems[itemID] = true
+}
+
+// publish sends the current quantity of each changed item to the
+// subscribers watching it. Nothing is read while nobody listens.
+func (h *stockHub) publish(itemIDs map[string]bool) {
+	h.Lock()
+	listening := len(h.subscribers) > 0
+	h.Unlock()
+	if !listening {
+		return
+	}
+	ids := make([]string, 0, len(itemIDs))
+	for id := range itemIDs {
+		ids = append(ids, id)
+	}
+	levels, err := stockLevels(db, "", ids)
+	if err != nil {
+		return
+	}
+	h.Lock()
+	defer h.Unlock()
+	for _, l := range levels {
+		data, _ := json.Marshal(fiber.Map{"id": l["id"], "quantity": l["quantity"]})
+		for sub := range h.subscribers {
+			if sub.orgID != l["organization_id"] || !sub.ids[toString(l["id"])] {
+				continue
+			}
+			select {
+			case sub.events <- data:
+			default: // a terminal not keeping up misses the event rather than blocking the write
+			}
+		}
+	}
+}
+
+// stockLevels returns id and quantity of the items in ids, of orgID unless
+// orgID is empty.
+func stockLevels(q *DB, orgID string, ids []string) ([]fiber.Map, error) {
+	if len(ids) == 0 {
+		return []fiber.Map{}, nil
+	}
+	args := make([]interface{}, 0, len(ids)+1)
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	query := `SELECT id, quantity, organization_id FROM inventory_items WHERE id IN (?` + strings.Repeat(",?", len(ids)-1) + `)`
+	if orgID != "" {
+		query += ` AND organization_id = ?`
+		args = append(args, orgID)
+	}
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	levels := []fiber.Map{}
+	for rows.Next() {
+		var id, org string
+		var quantity int
+		if err := rows.Scan(&id, &quantity, &org); err != nil {
+			return nil, err
+		}
+		levels = append(levels, fiber.Map{"id": id, "quantity": quantity, "organization_id": org})
+	}
+	return levels, rows.Err()
+}
+
+// stockFeedIDs reads the ids parameter.
+func stockFeedIDs(c *fiber.Ctx) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("ids is required")
+	}
+	if len(ids) > stockFeedMaxItems {
+		return nil, fmt.Errorf("at most %d ids", stockFeedMaxItems)
+	}
+	return ids, nil
+}
+
+// snapshotFor returns the quantities of ids in orgID without the
+// organization.
+func snapshotFor(q *DB, orgID string, ids []string) ([]fiber.Map, error) {
+	levels, err := stockLevels(q, orgID, ids)
+	for _, l := range levels {
+		delete(l, "organization_id")
+	}
+	return levels, err
+}
+
+func handleStockSnapshot(c *fiber.Ctx) error {
+	ids, err := stockFeedIDs(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	levels, err := snapshotFor(dbFor(c), currentOrgID(c), ids)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": levels})
+}
+
+func handleStockStream(c *fiber.Ctx) error {
+	ids, err := stockFeedIDs(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	sub := &stockSubscriber{orgID: currentOrgID(c), ids: map[string]bool{}, events: make(chan []byte, 256)}
+	for _, id := range ids {
+		sub.ids[id] = true
+	}
+	// subscribe before reading the snapshot so no change falls in between
+	stockFeed.Lock()
+	stockFeed.subscribers[sub] = true
+	stockFeed.Unlock()
+	levels, err := snapshotFor(dbFor(c), sub.orgID, ids)
+	if err != nil {
+		stockFeed.unsubscribe(sub)
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	snapshot, _ := json.Marshal(fiber.Map{"items": levels})
+	// like the realtime stream, this stays open past the request deadline
+	requestDone(c)()
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer stockFeed.unsubscribe(sub)
+		if writeStockEvent(w, "snapshot", snapshot) != nil {
+			return
+		}
+		ping := time.NewTicker(30 * time.Second)
+		defer ping.Stop()
+		for {
+			select {
+			case data := <-sub.events:
+				if writeStockEvent(w, "stock", data) != nil {
+					return
+				}
+			case <-ping.C:
+				if _, err := w.WriteString(": ping\n\n"); err != nil || w.Flush() != nil {
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+func (h *stockHub) unsubscribe(sub *stockSubscriber) {
+	h.Lock()
+	delete(h.subscribers, sub)
+	h.Unlock()
+}
+
+func writeStockEvent(w *bufio.Writer, event string, data []byte) error {
+	if _, err := fmt.Fprintf(w, "event:%s\ndata:%s\n\n", event, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
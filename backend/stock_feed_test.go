@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStockFeed(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Tea','TEA',10,5,'org-1'),('i-2','Sugar','SUG',7,3,'org-1'),('i-3','Salt','SALT',4,2,'org-2')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "cashier"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	// another organization's item is not in the snapshot
+	code, out := call("GET", "/api/stock?ids=i-1,i-3", "")
+	if items := out["items"].([]interface{}); code != 200 || len(items) != 1 || items[0].(map[string]interface{})["quantity"] != 10.0 {
+		t.Errorf("snapshot: %d %v", code, out)
+	}
+	if code, _ := call("GET", "/api/stock", ""); code != 400 {
+		t.Errorf("snapshot without ids: got %d, want 400", code)
+	}
+
+	terminal := &stockSubscriber{orgID: "org-1", ids: map[string]bool{"i-1": true}, events: make(chan []byte, 8)}
+	stockFeed.Lock()
+	stockFeed.subscribers[terminal] = true
+	stockFeed.Unlock()
+	defer stockFeed.unsubscribe(terminal)
+
+	if code, out := call("POST", "/api/collections/transactions/records", `{"type":"inflow","amount":16,"paid_amount":16,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":2,"unit_price":5},{"item_id":"i-2","quantity":2,"unit_price":3}]}`); code != 200 {
+		t.Fatalf("sale: %d %v", code, out)
+	}
+	select {
+	case data := <-terminal.events:
+		if string(data) != `{"id":"i-1","quantity":8}` {
+			t.Errorf("stock event %s", data)
+		}
+	default:
+		t.Fatal("no stock event after the sale")
+	}
+	select {
+	case data := <-terminal.events:
+		t.Errorf("event for an item not watched: %s", data)
+	default:
+	}
+
+	// a write that is rolled back announces nothing
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := adjustStock(tx, "i-1", -1, "write_off", "test"); err != nil {
+		t.Fatal(err)
+	}
+	tx.Rollback()
+	select {
+	case data := <-terminal.events:
+		t.Errorf("event after rollback: %s", data)
+	default:
+	}
+}
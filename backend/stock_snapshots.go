@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// The stock valuation report works a past date out backwards from today's
+// quantities at today's cost prices, which drifts once prices change. So
+// the value of the stock is also recorded as it stands: once a business
+// day is over, a background job checks every STOCK_SNAPSHOT_CHECK_MINUTES
+// (default 60; 0 turns it off) and stores what the stock was worth at the
+// end of it, with each item's quantity and value. The
+// stock_snapshot_frequency setting has it do so every day ("daily", the
+// default), once a week ("weekly") or not at all ("off"). A snapshot can
+// also be taken by hand; it stands for the day so far and is replaced by
+// the end-of-day one.
+//
+//	GET  /api/reports/stock-value-history            ?from=, ?to= (dates; default the last 90 days): the series for a chart
+//	GET  /api/reports/stock-value-history/:date      one snapshot with its items
+//	POST /api/reports/stock-value-history/snapshot   take one now
+
+const stockHistoryDefaultDays = 90
+
+func registerStockSnapshotRoutes(app *fiber.App) {
+	app.Get("/api/reports/stock-value-history", requireAuth, cachedReport, handleStockValueHistory)
+	app.Post("/api/reports/stock-value-history/snapshot", requireAuth, requireRole("admin", "manager"), handleTakeStockSnapshot)
+	app.Get("/api/reports/stock-value-history/:date", requireAuth, handleGetStockSnapshot)
+}
+
+func startStockSnapshotter() {
+	minutes := 60
+	if v, err := strconv.Atoi(os.Getenv("STOCK_SNAPSHOT_CHECK_MINUTES")); err == nil {
+		minutes = v
+	}
+	if minutes <= 0 {
+		return
+	}
+	go func() {
+		for {
+			for _, orgID := range organizationIDs() {
+				if date, err := snapshotDueStock(orgID, time.Now()); err != nil {
+					log.Printf("stock snapshot for %s: %v", orgID, err)
+				} else if date != "" {
+					log.Printf("stock snapshot for %s: recorded %s", orgID, date)
+				}
+			}
+			time.Sleep(time.Duration(minutes) * time.Minute)
+		}
+	}()
+}
+
+// snapshotDueStock records the value of orgID's stock at the end of the
+// business day before now, if its stock_snapshot_frequency calls for one.
+// It returns the date recorded, or "" when none was due.
+func snapshotDueStock(orgID string, now time.Time) (string, error) {
+	freq, _ := orgSetting(orgID, "stock_snapshot_frequency").(string)
+	days := 1
+	switch freq {
+	case "off":
+		return "", nil
+	case "weekly":
+		days = 7
+	}
+	local := now.In(businessLocation(orgID))
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	day := midnight.AddDate(0, 0, -1)
+	// snapshots taken by hand during a day do not count
+	var taken int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM stock_value_snapshots WHERE organization_id = ? AND closing = 1 AND snapshot_date > ? AND snapshot_date <= ?`,
+		orgID, day.AddDate(0, 0, -days).Format("2006-01-02"), day.Format("2006-01-02")).Scan(&taken); err != nil {
+		return "", err
+	}
+	if taken > 0 {
+		return "", nil
+	}
+	if _, err := takeStockSnapshot(db, orgID, day.Format("2006-01-02"), midnight, true); err != nil {
+		return "", err
+	}
+	return day.Format("2006-01-02"), nil
+}
+
+// takeStockSnapshot records the value of orgID's stock as of asOf as the
+// snapshot of date, replacing any taken for it before; closing tells that
+// date was over.
+func takeStockSnapshot(q *DB, orgID, date string, asOf time.Time, closing bool) (fiber.Map, error) {
+	valued, total, err := stockValuation(q.context(), q, orgID, asOf)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]fiber.Map, 0, len(valued))
+	quantity := 0
+	for _, it := range valued {
+		owned := it["owned_quantity"].(int)
+		if owned == 0 {
+			continue
+		}
+		quantity += owned
+		items = append(items, fiber.Map{"item_id": it["item_id"], "name": it["name"], "sku": it["sku"], "quantity": owned, "unit_value": moneyValue(it["unit_value"]), "value": moneyValue(it["value"])})
+	}
+	raw, _ := json.Marshal(items)
+	value, now := moneyOf(total), time.Now().Format(time.RFC3339)
+	closed := 0
+	if closing {
+		closed = 1
+	}
+	_, err = q.Exec(`INSERT INTO stock_value_snapshots (id,organization_id,snapshot_date,total_value,total_quantity,item_count,items,closing,created_at) VALUES (?,?,?,?,?,?,?,?,?)
+		ON CONFLICT(organization_id,snapshot_date) DO UPDATE SET total_value = excluded.total_value, total_quantity = excluded.total_quantity, item_count = excluded.item_count, items = excluded.items, closing = excluded.closing, created_at = excluded.created_at`,
+		genID(), orgID, date, value.float(), quantity, len(items), string(raw), closed, now)
+	if err != nil {
+		return nil, err
+	}
+	return fiber.Map{"date": date, "value": value, "quantity": quantity, "item_count": len(items), "items": items, "closing": closing, "created_at": now}, nil
+}
+
+func handleStockValueHistory(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	to := time.Now().In(businessLocation(orgID))
+	from := to.AddDate(0, 0, -stockHistoryDefaultDays)
+	for _, p := range []struct {
+		param string
+		into  *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if v := c.Query(p.param); v != "" {
+			t, err := time.Parse("2006-01-02", v)
+			if err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": p.param + " must be a date (YYYY-MM-DD)"})
+			}
+			*p.into = t
+		}
+	}
+	rows, err := dbFor(c).Query(`SELECT snapshot_date, total_value, total_quantity, item_count, closing FROM stock_value_snapshots WHERE organization_id = ? AND snapshot_date >= ? AND snapshot_date <= ? ORDER BY snapshot_date`,
+		orgID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	series := []fiber.Map{}
+	for rows.Next() {
+		var date string
+		var value float64
+		var quantity, count int
+		var closing bool
+		if err := rows.Scan(&date, &value, &quantity, &count, &closing); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		series = append(series, fiber.Map{"date": date, "value": moneyOf(value), "quantity": quantity, "item_count": count, "closing": closing})
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{
+		"from":      from.Format("2006-01-02"),
+		"to":        to.Format("2006-01-02"),
+		"frequency": orgSetting(orgID, "stock_snapshot_frequency"),
+		"series":    series,
+	})
+}
+
+func handleGetStockSnapshot(c *fiber.Ctx) error {
+	var value float64
+	var quantity, count int
+	var closing bool
+	var items, createdAt string
+	err := dbFor(c).QueryRow(`SELECT total_value, total_quantity, item_count, items, closing, created_at FROM stock_value_snapshots WHERE organization_id = ? AND snapshot_date = ?`, currentOrgID(c), c.Params("date")).
+		Scan(&value, &quantity, &count, &items, &closing, &createdAt)
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	return c.JSON(fiber.Map{"date": c.Params("date"), "value": moneyOf(value), "quantity": quantity, "item_count": count, "items": json.RawMessage(items), "closing": closing, "created_at": createdAt})
+}
+
+func handleTakeStockSnapshot(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	now := time.Now()
+	snapshot, err := takeStockSnapshot(dbFor(c), orgID, now.In(businessLocation(orgID)).Format("2006-01-02"), now, false)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(snapshot)
+}
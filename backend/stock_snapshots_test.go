@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStockSnapshots(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO settings (scope,scope_id,key,value) VALUES ('organization','org-1','timezone','"UTC"')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('i-1','Pen','PEN',5,15,10,'org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('i-2','Ink','INK',0,20,12,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(""))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	now := time.Now().UTC()
+	yesterday := now.AddDate(0, 0, -1).Format("2006-01-02")
+	if date, err := snapshotDueStock("org-1", now); err != nil || date != yesterday {
+		t.Fatalf("snapshot: %q %v, want %s", date, err, yesterday)
+	}
+	if date, err := snapshotDueStock("org-1", now); err != nil || date != "" {
+		t.Errorf("second snapshot the same day: %q %v", date, err)
+	}
+	if code, _ := call("cashier", "POST", "/api/reports/stock-value-history/snapshot"); code != 403 {
+		t.Errorf("cashier taking a snapshot: got %d, want 403", code)
+	}
+	if code, out := call("manager", "POST", "/api/reports/stock-value-history/snapshot"); code != 201 || out["value"] != 50.0 || out["closing"] != false {
+		t.Errorf("snapshot by hand: %d %v", code, out)
+	}
+
+	_, history := call("viewer", "GET", "/api/reports/stock-value-history")
+	series, _ := history["series"].([]interface{})
+	if len(series) != 2 {
+		t.Fatalf("series: %v", history)
+	}
+	if p := series[0].(map[string]interface{}); p["date"] != yesterday || p["value"] != 50.0 || p["item_count"] != 1.0 || p["closing"] != true {
+		t.Errorf("point: %v", p)
+	}
+	code, snapshot := call("viewer", "GET", "/api/reports/stock-value-history/"+yesterday)
+	if items, _ := snapshot["items"].([]interface{}); code != 200 || len(items) != 1 || items[0].(map[string]interface{})["item_id"] != "i-1" {
+		t.Errorf("snapshot: %d %v", code, snapshot)
+	}
+	if code, _ := call("viewer", "GET", "/api/reports/stock-value-history/2001-01-01"); code != 404 {
+		t.Errorf("missing snapshot: got %d, want 404", code)
+	}
+
+	// weekly snapshots wait out the week
+	if _, err := db.Exec(`INSERT INTO settings (scope,scope_id,key,value) VALUES ('organization','org-1','stock_snapshot_frequency','"weekly"')`); err != nil {
+		t.Fatal(err)
+	}
+	if date, err := snapshotDueStock("org-1", now.AddDate(0, 0, 4)); err != nil || date != "" {
+		t.Errorf("weekly, four days on: %q %v", date, err)
+	}
+	if date, err := snapshotDueStock("org-1", now.AddDate(0, 0, 7)); err != nil || date == "" {
+		t.Errorf("weekly, a week on: %q %v", date, err)
+	}
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A stocktake is a physical count. Starting one takes every item (or the
+// items of one category) into the count; staff then submit counted
+// quantities, as JSON or as a CSV with sku (or item_id) and quantity
+// columns, as often as they like while it is open. Each count is compared
+// with the system quantity at the moment it is submitted, so sales made
+// while the shop is being counted do not show up as variances. Posting
+// adjusts every counted item by its variance in one transaction;
+// uncounted items are left alone, or set to zero with uncounted=zero.
+
+func registerStocktakeRoutes(app *fiber.App) {
+	r := app.Group("/api/stocktakes", requireAuth)
+	r.Get("/", handleListStocktakes)
+	r.Get("/:id", handleGetStocktake)
+	r.Post("/", requireRole("admin", "manager"), handleCreateStocktake)
+	r.Post("/:id/counts", handleSubmitCounts)
+	r.Post("/:id/post", requireRole("admin", "manager"), handlePostStocktake)
+	r.Post("/:id/cancel", requireRole("admin", "manager"), handleCancelStocktake)
+}
+
+type stocktakeCount struct {
+	ItemID   string `json:"item_id"`
+	SKU      string `json:"sku"`
+	Quantity *int   `json:"quantity"`
+}
+
+func handleCreateStocktake(c *fiber.Ctx) error {
+	var req struct {
+		Category string `json:"category"`
+		Notes    string `json:"notes"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	orgID := currentOrgID(c)
+	var open int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM stocktakes WHERE organization_id = ? AND status = 'open'`, orgID).Scan(&open); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if open > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "another stocktake is still open; post or cancel it first"})
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	number, err := nextSequenceNumber(tx, orgID, "stocktake")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	id := genID()
+	now := time.Now().Format(time.RFC3339)
+	if _, err := tx.Exec(`INSERT INTO stocktakes (id,organization_id,number,status,category,notes,created_by,created_at) VALUES (?,?,?,'open',NULLIF(?, ''),NULLIF(?, ''),?,?)`,
+		id, orgID, number, req.Category, req.Notes, currentUserID(c), now); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	query := `SELECT id, quantity FROM inventory_items WHERE organization_id = ?`
+	args := []interface{}{orgID}
+	if req.Category != "" {
+		query += ` AND category = ?`
+		args = append(args, req.Category)
+	}
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	type snapshot struct {
+		id       string
+		quantity int
+	}
+	var items []snapshot
+	for rows.Next() {
+		var s snapshot
+		if err := rows.Scan(&s.id, &s.quantity); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		items = append(items, s)
+	}
+	rows.Close()
+	for _, s := range items {
+		if _, err := tx.Exec(`INSERT INTO stocktake_lines (id,stocktake_id,item_id,system_quantity) VALUES (?,?,?,?)`, genID(), id, s.id, s.quantity); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(201).JSON(fiber.Map{"id": id, "number": number, "status": "open", "items": len(items)})
+}
+
+func handleListStocktakes(c *fiber.Ctx) error {
+	query := `SELECT s.id, s.number, s.status, s.category, s.created_at, s.posted_at,
+		(SELECT COUNT(1) FROM stocktake_lines l WHERE l.stocktake_id = s.id) AS items,
+		(SELECT COUNT(1) FROM stocktake_lines l WHERE l.stocktake_id = s.id AND l.counted_quantity IS NOT NULL) AS counted
+		FROM stocktakes s WHERE s.organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	if status := c.Query("status"); status != "" {
+		query += ` AND s.status = ?`
+		args = append(args, status)
+	}
+	rows, err := dbFor(c).Query(query+` ORDER BY s.created_at DESC`, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"items": items})
+}
+
+// handleGetStocktake returns a stocktake with its lines and variances.
+// show=variances lists only counted items that differ, show=uncounted only
+// items still to count.
+func handleGetStocktake(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	rows, err := dbFor(c).Query(`SELECT id, number, status, category, notes, created_by, created_at, posted_at, posted_by FROM stocktakes WHERE id = ? AND organization_id = ?`, c.Params("id"), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	found, err := rowsToMaps(rows)
+	rows.Close()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(found) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "stocktake not found"})
+	}
+	stocktake := found[0]
+
+	rows, err = dbFor(c).Query(`SELECT l.item_id, COALESCE(i.name, 'Unnamed Item'), COALESCE(i.sku, ''), i.cost_price, l.system_quantity, l.counted_quantity, l.counted_at, l.counted_by
+		FROM stocktake_lines l JOIN inventory_items i ON i.id = l.item_id WHERE l.stocktake_id = ? ORDER BY i.name`, c.Params("id"))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	show := c.Query("show")
+	lines := []fiber.Map{}
+	counted, withVariance := 0, 0
+	shortage, surplus := 0.0, 0.0
+	total := 0
+	for rows.Next() {
+		var itemID, name, sku string
+		var cost float64
+		var system int
+		var countedQty sql.NullInt64
+		var countedAt, countedBy sql.NullString
+		if err := rows.Scan(&itemID, &name, &sku, &cost, &system, &countedQty, &countedAt, &countedBy); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		total++
+		line := fiber.Map{"item_id": itemID, "name": name, "sku": sku, "system_quantity": system, "counted_quantity": nil, "variance": nil, "variance_value": nil, "counted_at": nil, "counted_by": nil}
+		if countedQty.Valid {
+			counted++
+			variance := int(countedQty.Int64) - system
+			value := round2(float64(variance) * cost)
+			line["counted_quantity"], line["variance"], line["variance_value"] = countedQty.Int64, variance, value
+			line["counted_at"], line["counted_by"] = countedAt.String, countedBy.String
+			if variance != 0 {
+				withVariance++
+				if value < 0 {
+					shortage += -value
+				} else {
+					surplus += value
+				}
+			}
+			if show == "uncounted" || (show == "variances" && variance == 0) {
+				continue
+			}
+		} else if show == "variances" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	stocktake["lines"] = lines
+	stocktake["summary"] = fiber.Map{
+		"items":          total,
+		"counted":        counted,
+		"uncounted":      total - counted,
+		"with_variance":  withVariance,
+		"shortage_value": round2(shortage),
+		"surplus_value":  round2(surplus),
+		"net_value":      round2(surplus - shortage),
+	}
+	return c.JSON(stocktake)
+}
+
+// parseCountCSV reads counts from a CSV with a quantity column and a sku
+// or item_id column.
+func parseCountCSV(r io.Reader) ([]stocktakeCount, error) {
+	cr := csv.NewReader(r)
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fiber.NewError(400, "csv header row is required")
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	_, hasSKU := col["sku"]
+	_, hasID := col["item_id"]
+	if _, ok := col["quantity"]; !ok || (!hasSKU && !hasID) {
+		return nil, fiber.NewError(400, "csv needs a quantity column and a sku or item_id column")
+	}
+	get := func(rec []string, name string) string {
+		if i, ok := col[name]; ok && i < len(rec) {
+			return strings.TrimSpace(rec[i])
+		}
+		return ""
+	}
+	var counts []stocktakeCount
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		count := stocktakeCount{ItemID: get(rec, "item_id"), SKU: get(rec, "sku")}
+		if q, err := strconv.Atoi(get(rec, "quantity")); err == nil {
+			count.Quantity = &q
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+// handleSubmitCounts records counted quantities, replacing earlier counts
+// of the same items. Items outside the stocktake's scope that turn up are
+// added to it. Lines that cannot be used are reported and the rest kept.
+func handleSubmitCounts(c *fiber.Ctx) error {
+	var counts []stocktakeCount
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer f.Close()
+		if counts, err = parseCountCSV(f); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+		}
+	} else {
+		var req struct {
+			Counts []stocktakeCount `json:"counts"`
+		}
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+		counts = req.Counts
+	}
+	if len(counts) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "no counts"})
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var status string
+	if err := tx.QueryRow(`SELECT status FROM stocktakes WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&status); err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "stocktake not found"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status != "open" {
+		return c.Status(409).JSON(fiber.Map{"error": "stocktake is " + status})
+	}
+
+	now := time.Now().Format(time.RFC3339)
+	recorded := 0
+	lineErrors := []fiber.Map{}
+	for i, count := range counts {
+		if count.Quantity == nil || *count.Quantity < 0 {
+			lineErrors = append(lineErrors, fiber.Map{"line": i + 1, "error": "quantity must be a whole number, zero or more"})
+			continue
+		}
+		var itemID string
+		var system int
+		var err error
+		if count.ItemID != "" {
+			err = tx.QueryRow(`SELECT id, quantity FROM inventory_items WHERE id = ? AND organization_id = ?`, count.ItemID, orgID).Scan(&itemID, &system)
+		} else {
+			err = tx.QueryRow(`SELECT id, quantity FROM inventory_items WHERE sku = ? AND organization_id = ?`, count.SKU, orgID).Scan(&itemID, &system)
+		}
+		if err == sql.ErrNoRows {
+			lineErrors = append(lineErrors, fiber.Map{"line": i + 1, "error": "unknown item " + count.ItemID + count.SKU})
+			continue
+		} else if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		if _, err := tx.Exec(`INSERT INTO stocktake_lines (id,stocktake_id,item_id,system_quantity,counted_quantity,counted_at,counted_by) VALUES (?,?,?,?,?,?,?)
+			ON CONFLICT(stocktake_id,item_id) DO UPDATE SET system_quantity = excluded.system_quantity, counted_quantity = excluded.counted_quantity, counted_at = excluded.counted_at, counted_by = excluded.counted_by`,
+			genID(), id, itemID, system, *count.Quantity, now, currentUserID(c)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		recorded++
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"recorded": recorded, "errors": lineErrors})
+}
+
+// handlePostStocktake applies the variances to stock and closes the
+// stocktake. Nothing is adjusted if any adjustment fails.
+func handlePostStocktake(c *fiber.Ctx) error {
+	var req struct {
+		Uncounted string `json:"uncounted"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	if req.Uncounted == "" {
+		req.Uncounted = "skip"
+	}
+	if req.Uncounted != "skip" && req.Uncounted != "zero" {
+		return c.Status(400).JSON(fiber.Map{"error": "uncounted must be skip or zero"})
+	}
+	id, orgID := c.Params("id"), currentOrgID(c)
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	var number, status string
+	if err := tx.QueryRow(`SELECT number, status FROM stocktakes WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&number, &status); err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "stocktake not found"})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if status != "open" {
+		return c.Status(409).JSON(fiber.Map{"error": "stocktake is " + status})
+	}
+
+	rows, err := tx.Query(`SELECT l.item_id, l.system_quantity, l.counted_quantity, i.quantity FROM stocktake_lines l JOIN inventory_items i ON i.id = l.item_id WHERE l.stocktake_id = ?`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	type adjustment struct {
+		itemID string
+		change int
+	}
+	var adjustments []adjustment
+	for rows.Next() {
+		var itemID string
+		var system, current int
+		var counted sql.NullInt64
+		if err := rows.Scan(&itemID, &system, &counted, &current); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		change := 0
+		if counted.Valid {
+			change = int(counted.Int64) - system
+		} else if req.Uncounted == "zero" {
+			change = -current
+		}
+		if change != 0 {
+			adjustments = append(adjustments, adjustment{itemID, change})
+		}
+	}
+	rows.Close()
+
+	applied := []fiber.Map{}
+	for _, a := range adjustments {
+		if status, err := adjustStock(tx, a.itemID, a.change, "stocktake", "Stocktake "+number); err != nil {
+			return c.Status(status).JSON(fiber.Map{"error": err.Error(), "item_id": a.itemID})
+		}
+		// units found missing are taken from the batches like a sale
+		if err := consumeBatches(tx, a.itemID, "", -a.change); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		applied = append(applied, fiber.Map{"item_id": a.itemID, "change": a.change})
+	}
+	if _, err := tx.Exec(`UPDATE stocktakes SET status = 'posted', posted_at = ?, posted_by = ? WHERE id = ?`, time.Now().Format(time.RFC3339), currentUserID(c), id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, a := range applied {
+		publishRecord(orgID, "inventory_items", "update", a["item_id"].(string))
+	}
+	if len(applied) > 0 {
+		checkAfterHoursAdjustment(dbFor(c), orgID, currentUserID(c), id, fmt.Sprintf("stocktake %s adjusting %d items", number, len(applied)))
+	}
+	return c.JSON(fiber.Map{"id": id, "number": number, "status": "posted", "adjustments": applied})
+}
+
+func handleCancelStocktake(c *fiber.Ctx) error {
+	res, err := dbFor(c).Exec(`UPDATE stocktakes SET status = 'cancelled' WHERE id = ? AND organization_id = ? AND status = 'open'`, c.Params("id"), currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if !orgOwns(dbFor(c), "stocktakes", c.Params("id"), currentOrgID(c)) {
+			return c.Status(404).JSON(fiber.Map{"error": "stocktake not found"})
+		}
+		return c.Status(409).JSON(fiber.Map{"error": "only open stocktakes can be cancelled"})
+	}
+	return c.JSON(fiber.Map{"id": c.Params("id"), "status": "cancelled"})
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStocktakeWorkflow(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,category,organization_id) VALUES
+		('i-1','Pen','PEN',10,15,9,'stationery','org-1'),
+		('i-2','Ink','INK',5,40,30,'stationery','org-1'),
+		('i-3','Clip','CLP',20,2,1,'stationery','org-1'),
+		('i-4','Soap','SOP',8,50,35,'grocery','org-1'),
+		('i-5','Other','OTH',3,2,1,'stationery','org-2')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("cashier", "POST", "/api/stocktakes", `{}`); code != 403 {
+		t.Errorf("cashier starting a stocktake: got %d, want 403", code)
+	}
+	code, created := call("manager", "POST", "/api/stocktakes", `{"category":"stationery"}`)
+	if code != 201 || created["number"] != "ST-00001" || created["items"] != float64(3) {
+		t.Fatalf("create: %d %v", code, created)
+	}
+	id := created["id"].(string)
+	if code, _ := call("manager", "POST", "/api/stocktakes", `{}`); code != 409 {
+		t.Errorf("second open stocktake: got %d, want 409", code)
+	}
+
+	// a sale while counting does not count as a variance
+	if _, err := db.Exec(`UPDATE inventory_items SET quantity = 9 WHERE id = 'i-1'`); err != nil {
+		t.Fatal(err)
+	}
+	_, counted := call("cashier", "POST", "/api/stocktakes/"+id+"/counts", `{"counts":[{"sku":"PEN","quantity":9},{"item_id":"i-2","quantity":3},{"sku":"OTH","quantity":1},{"sku":"CLP","quantity":-1}]}`)
+	if counted["recorded"] != float64(2) || len(counted["errors"].([]interface{})) != 2 {
+		t.Errorf("json counts: %v", counted)
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	fw, _ := w.CreateFormFile("file", "count.csv")
+	_, _ = fw.Write([]byte("SKU,Quantity\nSOP,10\n"))
+	_ = w.Close()
+	req := httptest.NewRequest("POST", "/api/stocktakes/"+id+"/counts", &buf)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "cashier"))
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	if resp, err := app.Test(req); err != nil || resp.StatusCode != 200 {
+		t.Fatalf("csv counts: %v %v", resp.StatusCode, err)
+	}
+
+	_, st := call("manager", "GET", "/api/stocktakes/"+id, "")
+	summary := st["summary"].(map[string]interface{})
+	if summary["items"] != float64(4) || summary["uncounted"] != float64(1) || summary["with_variance"] != float64(2) ||
+		summary["shortage_value"] != float64(60) || summary["surplus_value"] != float64(70) {
+		t.Errorf("summary: %v", summary)
+	}
+	_, st = call("manager", "GET", "/api/stocktakes/"+id+"?show=variances", "")
+	if lines := st["lines"].([]interface{}); len(lines) != 2 {
+		t.Errorf("variance lines: %v", lines)
+	}
+
+	if code, _ := call("cashier", "POST", "/api/stocktakes/"+id+"/post", ""); code != 403 {
+		t.Errorf("cashier posting: got %d, want 403", code)
+	}
+	code, posted := call("manager", "POST", "/api/stocktakes/"+id+"/post", "")
+	if code != 200 || len(posted["adjustments"].([]interface{})) != 2 {
+		t.Fatalf("post: %d %v", code, posted)
+	}
+	for item, want := range map[string]int{"i-1": 9, "i-2": 3, "i-3": 20, "i-4": 10, "i-5": 3} {
+		var q int
+		_ = db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = ?`, item).Scan(&q)
+		if q != want {
+			t.Errorf("%s quantity = %d, want %d", item, q, want)
+		}
+	}
+	var moves int
+	_ = db.QueryRow(`SELECT COUNT(1) FROM inventory_transactions WHERE transaction_type = 'stocktake' AND notes = 'Stocktake ST-00001'`).Scan(&moves)
+	if moves != 2 {
+		t.Errorf("stocktake movements = %d, want 2", moves)
+	}
+	if code, _ := call("manager", "POST", "/api/stocktakes/"+id+"/post", ""); code != 409 {
+		t.Errorf("posting twice: got %d, want 409", code)
+	}
+	if code, _ := call("cashier", "POST", "/api/stocktakes/"+id+"/counts", `{"counts":[{"sku":"PEN","quantity":1}]}`); code != 409 {
+		t.Errorf("counting a posted stocktake: got %d, want 409", code)
+	}
+
+	// zeroing uncounted items is all or nothing
+	_, second := call("manager", "POST", "/api/stocktakes", `{"category":"grocery"}`)
+	id = second["id"].(string)
+	if _, err := db.Exec(`UPDATE stocktake_lines SET system_quantity = 20, counted_quantity = 0 WHERE stocktake_id = ?`, id); err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := call("manager", "POST", "/api/stocktakes/"+id+"/post", `{"uncounted":"zero"}`); code != 409 {
+		t.Errorf("post driving stock negative: got %d, want 409", code)
+	}
+	_, st = call("manager", "GET", "/api/stocktakes/"+id, "")
+	if st["status"] != "open" {
+		t.Errorf("failed post changed status to %v", st["status"])
+	}
+	if code, _ := call("manager", "POST", "/api/stocktakes/"+id+"/cancel", ""); code != 200 {
+		t.Errorf("cancel: got %d", code)
+	}
+	_, list := call("viewer", "GET", "/api/stocktakes?status=posted", "")
+	if items := list["items"].([]interface{}); len(items) != 1 {
+		t.Errorf("posted stocktakes: %v", items)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Uploaded files are never removed when a record gets a new image, so
+// replaced item photos and receipts pile up under uploads/. The storage GC
+// finds files no record points to and removes them once they are older
+// than a grace period, so a file whose record is still being saved is
+// never collected. Files live on local disk only.
+//
+// The background job cleans up the whole uploads directory. The report
+// and manual run endpoints only show and remove files of the caller's
+// organization; orphans that can no longer be traced to one (their record
+// was deleted) are counted but not listed.
+//
+// Configuration:
+//
+//	STORAGE_GC_GRACE_HOURS     age before an orphan is removed (default 72)
+//	STORAGE_GC_INTERVAL_HOURS  how often the job runs (default 24, 0 disables)
+
+func registerStorageRoutes(app *fiber.App) {
+	r := app.Group("/api/storage", requireAuth, requireRole("admin"))
+	r.Get("/orphans", handleListOrphanedUploads)
+	r.Post("/gc", handleCollectOrphanedUploads)
+	r.Get("/quarantine", handleListQuarantine)
+}
+
+func storageGCGrace() time.Duration {
+	hours := 72
+	if v, err := strconv.Atoi(os.Getenv("STORAGE_GC_GRACE_HOURS")); err == nil && v >= 0 {
+		hours = v
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+type orphanedUpload struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	ModifiedAt string `json:"modified_at"`
+	Removable  bool   `json:"removable"`
+	orgID      string
+}
+
+// referencedUploads returns the paths, relative to uploads/, of every file
+// a record points to.
+func referencedUploads() (map[string]bool, error) {
+	refs := map[string]bool{}
+	for _, q := range []string{
+		`SELECT image_url FROM inventory_items WHERE image_url IS NOT NULL`,
+		`SELECT image_url FROM transactions WHERE image_url IS NOT NULL`,
+		`SELECT logo_url FROM organizations WHERE logo_url IS NOT NULL`,
+		`SELECT url FROM inbox_attachments`,
+	} {
+		rows, err := db.Query(q)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var url string
+			if err := rows.Scan(&url); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if strings.HasPrefix(url, "/api/files/") {
+				refs[strings.TrimPrefix(url, "/api/files/")] = true
+			}
+		}
+		rows.Close()
+	}
+	return refs, nil
+}
+
+// uploadOwner returns the organization a file under uploads/ belongs to,
+// judging by its collection/id directory, or "" if it cannot tell.
+func uploadOwner(path string) (string, error) {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 3 {
+		return "", nil
+	}
+	if parts[0] == "organizations" {
+		return parts[1], nil
+	}
+	if !isTenantTable(parts[0]) {
+		return "", nil
+	}
+	var orgID string
+	err := db.QueryRow(`SELECT COALESCE(organization_id, '') FROM `+parts[0]+` WHERE id = ?`, parts[1]).Scan(&orgID)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return orgID, err
+}
+
+// findOrphanedUploads lists files under uploads/ that no record refers
+// to; those older than grace are removable.
+func findOrphanedUploads(grace time.Duration) ([]orphanedUpload, error) {
+	refs, err := referencedUploads()
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-grace)
+	orphans := []orphanedUpload{}
+	err = filepath.WalkDir("uploads", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel("uploads", p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if refs[rel] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		owner, err := uploadOwner(rel)
+		if err != nil {
+			return err
+		}
+		orphans = append(orphans, orphanedUpload{
+			Path:       rel,
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime().Format(time.RFC3339),
+			Removable:  info.ModTime().Before(cutoff),
+			orgID:      owner,
+		})
+		return nil
+	})
+	return orphans, err
+}
+
+// removeOrphanedUploads deletes the removable files and any directories
+// left empty, returning how many files and bytes were freed.
+func removeOrphanedUploads(orphans []orphanedUpload) (int, int64) {
+	removed, freed := 0, int64(0)
+	for _, o := range orphans {
+		if !o.Removable {
+			continue
+		}
+		p := filepath.Join("uploads", filepath.FromSlash(o.Path))
+		if err := os.Remove(p); err != nil {
+			log.Printf("storage gc: %v", err)
+			continue
+		}
+		removed++
+		freed += o.Size
+		// fails harmlessly while the directory still has files
+		_ = os.Remove(filepath.Dir(p))
+	}
+	return removed, freed
+}
+
+// startStorageGC removes orphaned uploads on the configured interval.
+func startStorageGC() {
+	hours := 24
+	if v, err := strconv.Atoi(os.Getenv("STORAGE_GC_INTERVAL_HOURS")); err == nil {
+		hours = v
+	}
+	if hours <= 0 {
+		return
+	}
+	go func() {
+		for {
+			orphans, err := findOrphanedUploads(storageGCGrace())
+			if err != nil {
+				log.Printf("storage gc: %v", err)
+			} else if removed, freed := removeOrphanedUploads(orphans); removed > 0 {
+				log.Printf("storage gc: removed %d files (%d bytes)", removed, freed)
+			}
+			if n := removeStaleUploadSessions(); n > 0 {
+				log.Printf("storage gc: removed %d abandoned chunked uploads", n)
+			}
+			time.Sleep(time.Duration(hours) * time.Hour)
+		}
+	}()
+}
+
+// orgOrphans splits orphans into those of orgID and a count of those that
+// belong to no known organization.
+func orgOrphans(orphans []orphanedUpload, orgID string) ([]orphanedUpload, int) {
+	mine := []orphanedUpload{}
+	untraced := 0
+	for _, o := range orphans {
+		switch o.orgID {
+		case orgID:
+			mine = append(mine, o)
+		case "":
+			untraced++
+		}
+	}
+	return mine, untraced
+}
+
+func handleListOrphanedUploads(c *fiber.Ctx) error {
+	grace := storageGCGrace()
+	orphans, err := findOrphanedUploads(grace)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	mine, untraced := orgOrphans(orphans, currentOrgID(c))
+	var size, removable int64
+	for _, o := range mine {
+		size += o.Size
+		if o.Removable {
+			removable += o.Size
+		}
+	}
+	return c.JSON(fiber.Map{
+		"items":           mine,
+		"total_bytes":     size,
+		"removable_bytes": removable,
+		"grace_hours":     int(grace.Hours()),
+		"untraced_files":  untraced,
+	})
+}
+
+func handleCollectOrphanedUploads(c *fiber.Ctx) error {
+	orphans, err := findOrphanedUploads(storageGCGrace())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	mine, _ := orgOrphans(orphans, currentOrgID(c))
+	removed, freed := removeOrphanedUploads(mine)
+	return c.JSON(fiber.Map{"removed": removed, "freed_bytes": freed})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorageGCRemovesOldOrphansOfOrganization(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active'), ('org-2','Theirs','','active')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id,image_url) VALUES ('i-1','Pen','PEN',10,15,'org-1','/api/files/inventory_items/i-1/new.jpg')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	old := time.Now().Add(-30 * 24 * time.Hour)
+	files := map[string]bool{ // path -> backdated
+		"inventory_items/i-1/new.jpg":    true,
+		"inventory_items/i-1/old.jpg":    true,
+		"inventory_items/i-1/fresh.jpg":  false,
+		"organizations/org-2/logo.png":   true,
+		"inventory_items/gone/photo.jpg": true,
+	}
+	for path, backdate := range files {
+		p := filepath.Join("uploads", filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if backdate {
+			if err := os.Chtimes(p, old, old); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	app := newApp()
+	req := httptest.NewRequest("POST", "/api/storage/gc", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Removed int `json:"removed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Removed != 1 {
+		t.Errorf("removed %d files, want 1", out.Removed)
+	}
+	for path, want := range map[string]bool{
+		"inventory_items/i-1/new.jpg":    true,  // referenced
+		"inventory_items/i-1/old.jpg":    false, // replaced image
+		"inventory_items/i-1/fresh.jpg":  true,  // within the grace period
+		"organizations/org-2/logo.png":   true,  // another organization
+		"inventory_items/gone/photo.jpg": true,  // untraced, left to the background job
+	} {
+		_, err := os.Stat(filepath.Join("uploads", filepath.FromSlash(path)))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", path, exists, want)
+		}
+	}
+}
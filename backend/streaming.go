@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Lists that can run to a whole history (collection pages, account
+// movements, expenses, price history) are written to the client as they
+// are read instead of being collected into one slice and marshaled at the
+// end. streamItems sends {"items":[...], ...} with chunked transfer,
+// scanning and marshaling a chunk of rows at a time, so memory stays flat
+// however many rows there are.
+//
+// maskHiddenFields cannot rewrite a body that has not been produced yet,
+// so streamed records have the caller's hidden fields stripped as they
+// are written. A query error after the first row has gone out can no
+// longer change the status; it ends the items and is reported in an
+// "error" field instead. The rows stay under the request's deadline
+// while they are written, and the deadline is released once they are.
+
+// streamChunkRows is how many records are read before they are written
+// out and flushed.
+const streamChunkRows = 200
+
+// streamItems writes the records of rows as the items of a JSON object
+// whose other fields are tail, and closes rows when done. prepare, when
+// not nil, runs on each chunk of records before it is written, e.g. to
+// attach related records with one query per chunk.
+func streamItems(c *fiber.Ctx, rows *sql.Rows, prepare func([]map[string]interface{}) error, tail fiber.Map) error {
+	scan, err := rowMapScanner(rows)
+	if err != nil {
+		rows.Close()
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	hidden := hiddenFields(currentOrgID(c), currentRole(c))
+	done := requestDone(c)
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer done()
+		defer rows.Close()
+		err := writeItems(w, rows, scan, prepare, hidden)
+		if err == errStreamWrite {
+			return
+		}
+		end := fiber.Map{}
+		for k, v := range tail {
+			end[k] = v
+		}
+		if err != nil {
+			end["error"] = err.Error()
+		}
+		w.WriteString("]")
+		if len(end) > 0 {
+			rest, _ := json.Marshal(end)
+			w.WriteString(",")
+			w.Write(rest[1:])
+		} else {
+			w.WriteString("}")
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// errStreamWrite reports that the client went away mid-stream.
+var errStreamWrite = errors.New("client closed the stream")
+
+// writeItems writes the opening of the object and every record of rows,
+// leaving the items array open.
+func writeItems(w *bufio.Writer, rows *sql.Rows, scan func() (map[string]interface{}, error), prepare func([]map[string]interface{}) error, hidden map[string]bool) error {
+	if _, err := w.WriteString(`{"items":[`); err != nil {
+		return errStreamWrite
+	}
+	first := true
+	chunk := make([]map[string]interface{}, 0, streamChunkRows)
+	flush := func() error {
+		if len(chunk) == 0 {
+			return nil
+		}
+		if prepare != nil {
+			if err := prepare(chunk); err != nil {
+				return err
+			}
+		}
+		for _, m := range chunk {
+			if len(hidden) > 0 {
+				stripFields(m, hidden)
+			}
+			b, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			if !first {
+				w.WriteByte(',')
+			}
+			first = false
+			if _, err := w.Write(b); err != nil {
+				return errStreamWrite
+			}
+		}
+		chunk = chunk[:0]
+		if w.Flush() != nil {
+			return errStreamWrite
+		}
+		return nil
+	}
+	for rows.Next() {
+		m, err := scan()
+		if err != nil {
+			return err
+		}
+		chunk = append(chunk, m)
+		if len(chunk) == streamChunkRows {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStreamedLists(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	// more rows than one streamed chunk, with contacts to attach in each
+	const n = streamChunkRows*2 + 17
+	for i := 0; i < n; i++ {
+		if _, err := db.Exec(`INSERT INTO contacts (id,name,phone,nid,type,organization_id) VALUES (?,?,?,?,'customer','org-1')`, fmt.Sprintf("c-%03d", i), fmt.Sprintf("Customer %03d", i), "01", "nid"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,contact_name,organization_id,created_at) VALUES (?,'inflow',1000,1000,0,?,?,'org-1','2024-01-01T00:00:00Z')`, fmt.Sprintf("t-%03d", i), fmt.Sprintf("c-%03d", i), fmt.Sprintf("Customer %03d", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	get := func(role, path string) (string, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("%s: %d", path, resp.StatusCode)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		var out map[string]interface{}
+		if err := json.Unmarshal(body, &out); err != nil {
+			t.Fatalf("%s: %v in %.200s", path, err, body)
+		}
+		return strings.Join(resp.TransferEncoding, ","), out
+	}
+
+	t.Setenv("LIST_PAGE_LIMITS", fmt.Sprintf("transactions=%d/%d", n, n))
+	encoding, out := get("viewer", "/api/collections/transactions/records?expand=contact&sort=id")
+	if encoding != "chunked" {
+		t.Errorf("transfer encoding %q, want chunked", encoding)
+	}
+	items := out["items"].([]interface{})
+	if len(items) != n || out["totalItems"] != float64(n) || out["perPage"] != float64(n) || out["page"] != 1.0 || out["totalPages"] != 1.0 {
+		t.Fatalf("%d items, page fields %v %v %v %v", len(items), out["totalItems"], out["perPage"], out["page"], out["totalPages"])
+	}
+	for i, it := range items {
+		contact := it.(map[string]interface{})["contact"].(map[string]interface{})
+		if contact["name"] != fmt.Sprintf("Customer %03d", i) {
+			t.Fatalf("item %d has contact %v", i, contact)
+		}
+		// the viewer's hidden fields are stripped from streamed records too
+		if _, ok := contact["nid"]; ok {
+			t.Fatalf("nid shown to a viewer in item %d", i)
+		}
+	}
+	if _, out := get("admin", "/api/collections/transactions/records?expand=contact&perPage=1&sort=id"); out["items"].([]interface{})[0].(map[string]interface{})["contact"].(map[string]interface{})["nid"] != "nid" {
+		t.Errorf("admin should see nid: %v", out["items"])
+	}
+
+	// an empty list is still a complete document
+	if _, out := get("admin", "/api/collections/transactions/records?filter=id%3D'none'"); len(out["items"].([]interface{})) != 0 || out["totalItems"] != 0.0 {
+		t.Errorf("empty list: %v", out)
+	}
+}
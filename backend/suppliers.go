@@ -0,0 +1,351 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Accounts payable are the unpaid part (due_amount) of purchases
+// (outflow transactions). A supplier payment is spread over the supplier's
+// open purchases, earliest due first, and recorded against each as a
+// payment line, so paid_amount and due_amount stay the transaction's own.
+
+func registerSupplierRoutes(app *fiber.App) {
+	s := app.Group("/api/suppliers", requireAuth)
+	s.Get("/payables", handleSupplierPayables)
+	s.Get("/:id/payables", handleSupplierStatement)
+	s.Post("/:id/payments", requireRole("admin", "manager"), handleSupplierPayment)
+	app.Get("/api/reports/payables-aging", requireAuth, cachedReport, handlePayablesAging)
+}
+
+// agingBuckets are the days-past-due bands of the aging report.
+var agingBuckets = []struct {
+	key      string
+	min, max int
+}{
+	{"current", math.MinInt32, 0},
+	{"1_30", 1, 30},
+	{"31_60", 31, 60},
+	{"61_90", 61, 90},
+	{"over_90", 91, math.MaxInt32},
+}
+
+func agingBucket(daysOverdue int) string {
+	for _, b := range agingBuckets {
+		if daysOverdue >= b.min && daysOverdue <= b.max {
+			return b.key
+		}
+	}
+	return "current"
+}
+
+// daysBetween counts whole days from the date from (YYYY-MM-DD) to asOf.
+func daysBetween(from string, asOf time.Time) int {
+	d, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return 0
+	}
+	day := time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.UTC)
+	return int(day.Sub(d).Hours() / 24)
+}
+
+// nextDueDate is the earliest date any of a transaction's unpaid amount falls due.
+func (d openDue) nextDueDate() string {
+	next := ""
+	for _, e := range d.schedule {
+		if next == "" || e.date < next {
+			next = e.date
+		}
+	}
+	return next
+}
+
+func contactNames(q *DB, orgID string) (map[string]fiber.Map, error) {
+	rows, err := q.Query(`SELECT id, COALESCE(name, ''), COALESCE(phone, ''), COALESCE(type, '') FROM contacts WHERE organization_id = ?`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]fiber.Map{}
+	for rows.Next() {
+		var id, name, phone, typ string
+		if err := rows.Scan(&id, &name, &phone, &typ); err != nil {
+			return nil, err
+		}
+		out[id] = fiber.Map{"name": name, "phone": phone, "type": typ}
+	}
+	return out, rows.Err()
+}
+
+// handleSupplierPayables lists what is owed to each supplier, largest first.
+func handleSupplierPayables(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	open, err := openDues(dbFor(c), orgID, "outflow", "")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	contacts, err := contactNames(dbFor(c), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	today := time.Now().Format("2006-01-02")
+	bySupplier := map[string]fiber.Map{}
+	var order []string
+	total := 0.0
+	for _, d := range open {
+		s, ok := bySupplier[d.contactID]
+		if !ok {
+			s = fiber.Map{"contact_id": d.contactID, "name": "", "phone": "", "purchases": 0, "due": 0.0, "overdue": 0.0, "next_due_date": ""}
+			if info, ok := contacts[d.contactID]; ok {
+				s["name"], s["phone"] = info["name"], info["phone"]
+			}
+			bySupplier[d.contactID] = s
+			order = append(order, d.contactID)
+		}
+		s["purchases"] = s["purchases"].(int) + 1
+		s["due"] = s["due"].(float64) + d.due
+		for _, e := range d.schedule {
+			if e.date < today {
+				s["overdue"] = s["overdue"].(float64) + e.amount
+			}
+		}
+		if next := d.nextDueDate(); s["next_due_date"] == "" || next < s["next_due_date"].(string) {
+			s["next_due_date"] = next
+		}
+		total += d.due
+	}
+	items := make([]fiber.Map, 0, len(order))
+	for _, id := range order {
+		items = append(items, bySupplier[id])
+	}
+	sortMapsByFloat(items, "due")
+	return c.JSON(fiber.Map{"items": items, "total_due": total})
+}
+
+// handleSupplierStatement lists a supplier's open purchases and the
+// payments made to them.
+func handleSupplierStatement(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "contacts", id, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "contact not found"})
+	}
+	open, err := openDues(dbFor(c), orgID, "outflow", id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now()
+	purchases := []fiber.Map{}
+	total := 0.0
+	for _, d := range open {
+		schedule := []fiber.Map{}
+		for _, e := range d.schedule {
+			schedule = append(schedule, fiber.Map{"due_date": e.date, "amount": e.amount})
+		}
+		next := d.nextDueDate()
+		purchases = append(purchases, fiber.Map{
+			"transaction_id": d.id,
+			"created_at":     d.createdAt,
+			"due":            d.due,
+			"due_date":       next,
+			"days_overdue":   math.Max(0, float64(daysBetween(next, now))),
+			"schedule":       schedule,
+		})
+		total += d.due
+	}
+
+	rows, err := dbFor(c).Query(`SELECT p.id, p.transaction_id, p.method, p.amount, COALESCE(p.reference, ''), p.created_at
+		FROM transaction_payments p JOIN transactions t ON t.id = p.transaction_id
+		WHERE t.organization_id = ? AND t.contact_id = ? AND t.type = 'outflow' AND t.voided_at IS NULL
+		ORDER BY p.created_at DESC LIMIT 100`, orgID, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	payments, err := rowsToMaps(rows)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"contact_id": id, "total_due": total, "purchases": purchases, "payments": payments})
+}
+
+// handlePayablesAging buckets unpaid purchases by how far past due they
+// are as of as_of (default today), per supplier and in total.
+func handlePayablesAging(c *fiber.Ctx) error {
+	asOf := time.Now()
+	if v := c.Query("as_of"); v != "" {
+		t, err := parseTime(v)
+		if err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid as_of"})
+		}
+		asOf = t
+	}
+	orgID := currentOrgID(c)
+	open, err := openDues(dbFor(c), orgID, "outflow", "")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	contacts, err := contactNames(dbFor(c), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	newBuckets := func() fiber.Map {
+		m := fiber.Map{"total": 0.0}
+		for _, b := range agingBuckets {
+			m[b.key] = 0.0
+		}
+		return m
+	}
+	totals := newBuckets()
+	bySupplier := map[string]fiber.Map{}
+	var order []string
+	for _, d := range open {
+		s, ok := bySupplier[d.contactID]
+		if !ok {
+			s = newBuckets()
+			s["contact_id"] = d.contactID
+			s["name"] = ""
+			if info, ok := contacts[d.contactID]; ok {
+				s["name"] = info["name"]
+			}
+			bySupplier[d.contactID] = s
+			order = append(order, d.contactID)
+		}
+		for _, e := range d.schedule {
+			key := agingBucket(daysBetween(e.date, asOf))
+			for _, m := range []fiber.Map{s, totals} {
+				m[key] = m[key].(float64) + e.amount
+				m["total"] = m["total"].(float64) + e.amount
+			}
+		}
+	}
+	items := make([]fiber.Map, 0, len(order))
+	for _, id := range order {
+		items = append(items, bySupplier[id])
+	}
+	sortMapsByFloat(items, "total")
+	return c.JSON(fiber.Map{"as_of": asOf.Format("2006-01-02"), "items": items, "totals": totals})
+}
+
+type supplierPaymentRequest struct {
+	Amount        float64 `json:"amount"`
+	Method        string  `json:"method"`
+	AccountID     string  `json:"account_id"`
+	Reference     string  `json:"reference"`
+	TransactionID string  `json:"transaction_id"`
+}
+
+// handleSupplierPayment records a payment to a supplier as a contact
+// payment (contact_payments.go). It settles the given purchase, or the
+// supplier's open purchases earliest due first, and cannot exceed what is
+// owed.
+func handleSupplierPayment(c *fiber.Ctx) error {
+	var req supplierPaymentRequest
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	contactID := c.Params("id")
+	if !orgOwns(dbFor(c), "contacts", contactID, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "contact not found"})
+	}
+	if req.Amount <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "amount must be positive"})
+	}
+	if req.Method == "" {
+		req.Method = "cash"
+	}
+	line := paymentLine{Method: req.Method, Amount: req.Amount, Reference: req.Reference, AccountID: req.AccountID}
+	if _, err := parsePayments(dbFor(c), orgID, map[string]interface{}{"payments": []paymentLine{line}}); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	open, err := openDues(dbFor(c), orgID, "outflow", contactID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if req.TransactionID != "" {
+		var only []openDue
+		for _, d := range open {
+			if d.id == req.TransactionID {
+				only = append(only, d)
+			}
+		}
+		if len(only) == 0 {
+			return c.Status(404).JSON(fiber.Map{"error": "no unpaid purchase " + req.TransactionID + " from this supplier"})
+		}
+		open = only
+	}
+	sortOpenDues(open)
+	amount := moneyOf(req.Amount)
+	parts, status, problem := allocatePayment(open, amount, nil)
+	if status != 0 {
+		return c.Status(status).JSON(problem)
+	}
+
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if line.contactPaymentID, err = createContactPayment(tx, orgID, contactID, "outflow", amount, line, currentUserID(c)); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := settleDues(tx, orgID, "outflow", line, parts); err == errDuesChanged {
+		return c.Status(409).JSON(fiber.Map{"error": errDuesChanged.Message})
+	} else if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, p := range parts {
+		publishRecord(orgID, "transactions", "update", p.TransactionID)
+	}
+	var due money
+	for _, d := range open {
+		due += moneyOf(d.due)
+	}
+	return c.JSON(fiber.Map{"id": line.contactPaymentID, "contact_id": contactID, "amount": amount, "allocations": parts, "due": due - amount})
+}
+
+// backfillLegacyPayment gives a transaction paid before split payments
+// existed a payment line for what was already paid, so the line about to
+// be added does not hide it from the payment reports.
+func backfillLegacyPayment(tx *Tx, transactionID string) error {
+	var lines int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM transaction_payments WHERE transaction_id = ?`, transactionID).Scan(&lines); err != nil {
+		return err
+	}
+	if lines > 0 {
+		return nil
+	}
+	var paid money
+	var method, createdAt sql.NullString
+	if err := tx.QueryRow(`SELECT paid_amount, payment_method, created_at FROM transactions WHERE id = ?`, transactionID).Scan(&paid, &method, &createdAt); err != nil {
+		return err
+	}
+	if paid <= 0 {
+		return nil
+	}
+	if method.String == "" {
+		method.String = "unspecified"
+	}
+	_, err := tx.Exec(`INSERT INTO transaction_payments (id,transaction_id,method,amount,reference,account_id,created_at) VALUES (?,?,?,?,'','',?)`,
+		genID(), transactionID, method.String, paid.float(), createdAt.String)
+	return err
+}
+
+// sortOpenDues orders unpaid transactions by when they next fall due.
+func sortOpenDues(open []openDue) {
+	sort.SliceStable(open, func(i, j int) bool { return open[i].nextDueDate() < open[j].nextDueDate() })
+}
+
+func sortMapsByFloat(items []fiber.Map, key string) {
+	sort.SliceStable(items, func(i, j int) bool { return items[i][key].(float64) > items[j][key].(float64) })
+}
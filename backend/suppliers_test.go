@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupplierPayables(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Karim Traders','018','supplier','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','017','customer','org-1')`,
+		`INSERT INTO payment_methods (id,code,name,active,organization_id) VALUES ('pm-1','cash','Cash',1,'org-1'),('pm-2','bkash','bKash',1,'org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('p-old','outflow',100000,0,100000,'s-1','org-1','` + now.AddDate(0, 0, -100).Format(time.RFC3339) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,payment_method,due_date,contact_id,organization_id,created_at) VALUES ('p-new','outflow',50000,20000,30000,'cash','` + now.AddDate(0, 0, 10).Format("2006-01-02") + `','s-1','org-1','` + now.Format(time.RFC3339) + `')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-sale','inflow',70000,0,70000,'c-1','org-1','` + now.Format(time.RFC3339) + `')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	_, out := call("cashier", "GET", "/api/suppliers/payables", "")
+	items := out["items"].([]interface{})
+	if len(items) != 1 {
+		t.Fatalf("%d suppliers owed, want 1", len(items))
+	}
+	if s := items[0].(map[string]interface{}); s["name"] != "Karim Traders" || s["due"] != float64(1300) || s["overdue"] != float64(1000) || s["purchases"] != float64(2) {
+		t.Errorf("payables %v", s)
+	}
+
+	// the old purchase fell due 70 days ago under the default 30-day terms
+	_, out = call("cashier", "GET", "/api/reports/payables-aging", "")
+	if totals := out["totals"].(map[string]interface{}); totals["61_90"] != float64(1000) || totals["current"] != float64(300) || totals["total"] != float64(1300) {
+		t.Errorf("aging totals %v", totals)
+	}
+
+	if code, _ := call("cashier", "POST", "/api/suppliers/s-1/payments", `{"amount":100}`); code != 403 {
+		t.Errorf("cashier paying a supplier: got %d, want 403", code)
+	}
+	if code, _ := call("manager", "POST", "/api/suppliers/s-1/payments", `{"amount":2000}`); code != 400 {
+		t.Errorf("overpaying: got %d, want 400", code)
+	}
+	if code, _ := call("manager", "POST", "/api/suppliers/s-1/payments", `{"amount":100,"transaction_id":"t-sale"}`); code != 404 {
+		t.Errorf("paying a sale as a purchase: got %d, want 404", code)
+	}
+	code, out := call("manager", "POST", "/api/suppliers/s-1/payments", `{"amount":1100,"method":"bkash","reference":"TX9"}`)
+	if code != 200 || out["due"] != float64(200) || len(out["allocations"].([]interface{})) != 2 {
+		t.Fatalf("payment: %d %v", code, out)
+	}
+
+	var paid, due money
+	var method string
+	_ = db.QueryRow(`SELECT paid_amount, due_amount, payment_method FROM transactions WHERE id = 'p-new'`).Scan(&paid, &due, &method)
+	if paid != moneyOf(300) || due != moneyOf(200) || method != "split" {
+		t.Errorf("p-new paid %v due %v by %s, want 300, 200 and split", paid, due, method)
+	}
+	_ = db.QueryRow(`SELECT paid_amount, due_amount, payment_method FROM transactions WHERE id = 'p-old'`).Scan(&paid, &due, &method)
+	if paid != moneyOf(1000) || due != 0 || method != "bkash" {
+		t.Errorf("p-old paid %v due %v by %s, want 1000, 0 and bkash", paid, due, method)
+	}
+	// the cash paid at purchase time is kept as its own line
+	var lines int
+	var total float64
+	_ = db.QueryRow(`SELECT COUNT(1), SUM(amount) FROM transaction_payments WHERE transaction_id = 'p-new'`).Scan(&lines, &total)
+	if lines != 2 || total != 300 {
+		t.Errorf("p-new has %d payment lines totalling %v, want 2 and 300", lines, total)
+	}
+
+	// the payment counts on the day it was made, not the day of the purchase
+	code, out = call("manager", "GET", "/api/reports/daily-closing", "")
+	if code != 200 {
+		t.Fatalf("daily closing: %d %v", code, out)
+	}
+	paidOut := 0.0
+	for _, m := range out["paid_out"].([]interface{}) {
+		if m := m.(map[string]interface{}); m["method"] == "bkash" {
+			paidOut += m["amount"].(float64)
+		}
+	}
+	if paidOut != 1100 {
+		t.Errorf("bkash paid out today %v, want 1100", paidOut)
+	}
+
+	_, out = call("cashier", "GET", "/api/suppliers/s-1/payables", "")
+	if out["total_due"] != float64(200) || len(out["purchases"].([]interface{})) != 1 || len(out["payments"].([]interface{})) != 3 {
+		t.Errorf("statement %v", out)
+	}
+}
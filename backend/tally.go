@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Accountants in South Asia keep the books in Tally, which imports
+// vouchers as XML. The export turns a period's transactions into them:
+//
+//   - Sales: the customer is debited; Sales and, for tax, Output VAT are
+//     credited;
+//   - Purchase: Purchases and Input VAT are debited; the supplier is
+//     credited;
+//   - Receipt: each payment received on a sale, and each refund a supplier
+//     gave back, debits the cash or bank ledger it landed in;
+//   - Payment: each payment made on a purchase, and each refund given to a
+//     customer, credits the ledger it left.
+//
+// Party ledgers are named after contacts, and money ledgers after the cash
+// account (cash_accounts.go) or else the payment method; Sales and Cash
+// take the names they have in the chart of accounts. Sales and purchases
+// without a contact go to Walk-in Customer and Sundry Supplier. Opening
+// balances, voided transactions and payments from credit notes are left
+// out. Tally wants debits negative, with ISDEEMEDPOSITIVE Yes.
+//
+//	GET /api/exports/tally.xml    ?from=, ?to= (default this month)
+
+const (
+	tallyPurchases    = "Purchases"
+	tallyOutputTax    = "Output VAT"
+	tallyInputTax     = "Input VAT"
+	tallyWalkIn       = "Walk-in Customer"
+	tallySundrySupply = "Sundry Supplier"
+)
+
+func registerTallyRoutes(app *fiber.App) {
+	app.Get("/api/exports/tally.xml", requireAuth, requireRole("admin", "manager"), handleTallyExport)
+}
+
+type tallyEnvelope struct {
+	XMLName    xml.Name       `xml:"ENVELOPE"`
+	Request    string         `xml:"HEADER>TALLYREQUEST"`
+	ReportName string         `xml:"BODY>IMPORTDATA>REQUESTDESC>REPORTNAME"`
+	Company    string         `xml:"BODY>IMPORTDATA>REQUESTDESC>STATICVARIABLES>SVCURRENTCOMPANY,omitempty"`
+	Messages   []tallyMessage `xml:"BODY>IMPORTDATA>REQUESTDATA>TALLYMESSAGE"`
+}
+
+type tallyMessage struct {
+	Voucher tallyVoucher `xml:"VOUCHER"`
+}
+
+type tallyVoucher struct {
+	Type      string       `xml:"VCHTYPE,attr"`
+	Action    string       `xml:"ACTION,attr"`
+	Date      string       `xml:"DATE"`
+	TypeName  string       `xml:"VOUCHERTYPENAME"`
+	Number    string       `xml:"VOUCHERNUMBER"`
+	Reference string       `xml:"REFERENCE,omitempty"`
+	Party     string       `xml:"PARTYLEDGERNAME"`
+	Narration string       `xml:"NARRATION,omitempty"`
+	Entries   []tallyEntry `xml:"ALLLEDGERENTRIES.LIST"`
+}
+
+type tallyEntry struct {
+	Ledger         string `xml:"LEDGERNAME"`
+	DeemedPositive string `xml:"ISDEEMEDPOSITIVE"`
+	Amount         string `xml:"AMOUNT"`
+}
+
+func tallyDebit(ledger string, amount money) tallyEntry {
+	return tallyEntry{ledger, "Yes", (-amount).String()}
+}
+
+func tallyCredit(ledger string, amount money) tallyEntry {
+	return tallyEntry{ledger, "No", amount.String()}
+}
+
+// tallyVoucherOf builds a voucher of vchType dated at when, in loc.
+func tallyVoucherOf(vchType, when string, loc *time.Location, number, party string) tallyVoucher {
+	date := when
+	if t, err := parseTime(when); err == nil {
+		date = t.In(loc).Format("20060102")
+	}
+	return tallyVoucher{Type: vchType, Action: "Create", Date: date, TypeName: vchType, Number: number, Party: party}
+}
+
+// ledgerName returns the name orgID gives the default ledger account with
+// systemKey, or fallback.
+func ledgerName(q *DB, orgID, systemKey, fallback string) string {
+	var name string
+	if err := q.QueryRow(`SELECT name FROM ledger_accounts WHERE organization_id = ? AND system_key = ?`, orgID, systemKey).Scan(&name); err != nil || name == "" {
+		return fallback
+	}
+	return name
+}
+
+// tallyVouchers reads orgID's vouchers for [from, to).
+func tallyVouchers(c *fiber.Ctx, orgID string, from, to time.Time) ([]tallyMessage, error) {
+	f, t := from.Format(time.RFC3339), to.Format(time.RFC3339)
+	loc := businessLocation(orgID)
+	sales, cash := ledgerName(dbFor(c), orgID, "sales", "Sales"), ledgerName(dbFor(c), orgID, "cash", "Cash")
+	// money lands in the cash account named, else in a ledger named after
+	// the method
+	moneyLedger := func(account, method, methodName string) string {
+		switch {
+		case account != "":
+			return account
+		case method == "cash":
+			return cash
+		default:
+			return methodName
+		}
+	}
+	party := func(typ, name string) string {
+		switch {
+		case name != "":
+			return name
+		case typ == "inflow":
+			return tallyWalkIn
+		default:
+			return tallySundrySupply
+		}
+	}
+	var out []tallyMessage
+
+	rows, err := dbFor(c).Query(`SELECT t.id, t.type, t.amount, COALESCE(t.tax_amount, 0), COALESCE(NULLIF(t.receipt_number, ''), t.id), COALESCE(NULLIF(t.contact_name, ''), ct.name, ''), COALESCE(t.items_summary, ''), t.created_at
+		FROM transactions t LEFT JOIN contacts ct ON ct.id = t.contact_id
+		WHERE t.organization_id = ? AND t.created_at >= ? AND t.created_at < ? AND t.type IN ('inflow', 'outflow') AND COALESCE(t.source, '') <> 'opening' AND t.voided_at IS NULL
+		ORDER BY t.created_at, t.id`, orgID, f, t)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id, typ, number, name, summary, createdAt string
+		var total money
+		var tax float64
+		if err := rows.Scan(&id, &typ, &total, &tax, &number, &name, &summary, &createdAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		taxed := moneyOf(tax)
+		p := party(typ, name)
+		var v tallyVoucher
+		if typ == "inflow" {
+			v = tallyVoucherOf("Sales", createdAt, loc, number, p)
+			v.Entries = append(v.Entries, tallyDebit(p, total), tallyCredit(sales, total-taxed))
+			if taxed != 0 {
+				v.Entries = append(v.Entries, tallyCredit(tallyOutputTax, taxed))
+			}
+		} else {
+			v = tallyVoucherOf("Purchase", createdAt, loc, number, p)
+			v.Entries = append(v.Entries, tallyDebit(tallyPurchases, total-taxed))
+			if taxed != 0 {
+				v.Entries = append(v.Entries, tallyDebit(tallyInputTax, taxed))
+			}
+			v.Entries = append(v.Entries, tallyCredit(p, total))
+		}
+		v.Narration = summary
+		out = append(out, tallyMessage{v})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// payment lines, and what was paid on transactions from before them
+	rows, err = dbFor(c).Query(`SELECT t.id, t.type, COALESCE(NULLIF(t.receipt_number, ''), t.id), COALESCE(NULLIF(t.contact_name, ''), ct.name, ''), l.method, COALESCE(a.name, ''), COALESCE(pm.name, l.method), l.amount, l.reference, l.created_at
+		FROM (SELECT p.transaction_id, p.method, p.amount, COALESCE(p.reference, '') AS reference, COALESCE(p.account_id, '') AS account_id, COALESCE(p.created_at, t.created_at) AS created_at
+			FROM transaction_payments p JOIN transactions t ON t.id = p.transaction_id WHERE p.method <> 'credit_note'
+			UNION ALL
+			SELECT t.id, COALESCE(NULLIF(t.payment_method, ''), 'cash'), t.paid_amount / 100.0, '', '', t.created_at
+			FROM transactions t WHERE t.paid_amount > 0 AND NOT EXISTS (SELECT 1 FROM transaction_payments p WHERE p.transaction_id = t.id)) l
+		JOIN transactions t ON t.id = l.transaction_id LEFT JOIN contacts ct ON ct.id = t.contact_id
+		LEFT JOIN cash_accounts a ON a.id = l.account_id
+		LEFT JOIN payment_methods pm ON pm.code = l.method AND pm.organization_id = t.organization_id
+		WHERE t.organization_id = ? AND l.created_at >= ? AND l.created_at < ? AND t.type IN ('inflow', 'outflow') AND COALESCE(t.source, '') <> 'opening' AND t.voided_at IS NULL
+		ORDER BY l.created_at, t.id`, orgID, f, t)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]int{}
+	for rows.Next() {
+		var id, typ, number, name, method, account, methodName, reference, paidAt string
+		var amount float64
+		if err := rows.Scan(&id, &typ, &number, &name, &method, &account, &methodName, &amount, &reference, &paidAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ledger := moneyLedger(account, method, methodName)
+		seen[number]++
+		number += "/" + strconv.Itoa(seen[number])
+		p := party(typ, name)
+		var v tallyVoucher
+		if typ == "inflow" {
+			v = tallyVoucherOf("Receipt", paidAt, loc, number, p)
+			v.Entries = []tallyEntry{tallyDebit(ledger, moneyOf(amount)), tallyCredit(p, moneyOf(amount))}
+		} else {
+			v = tallyVoucherOf("Payment", paidAt, loc, number, p)
+			v.Entries = []tallyEntry{tallyDebit(p, moneyOf(amount)), tallyCredit(ledger, moneyOf(amount))}
+		}
+		v.Reference = reference
+		out = append(out, tallyMessage{v})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = dbFor(c).Query(`SELECT r.type, COALESCE(n.number, r.credit_note_id), COALESCE(ct.name, ''), r.method, COALESCE(a.name, ''), COALESCE(pm.name, r.method), r.amount, COALESCE(r.reference, ''), r.created_at
+		FROM refunds r LEFT JOIN credit_notes n ON n.id = r.credit_note_id LEFT JOIN contacts ct ON ct.id = r.contact_id
+		LEFT JOIN cash_accounts a ON a.id = r.account_id
+		LEFT JOIN payment_methods pm ON pm.code = r.method AND pm.organization_id = r.organization_id
+		WHERE r.organization_id = ? AND r.created_at >= ? AND r.created_at < ? ORDER BY r.created_at, r.id`, orgID, f, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var typ, number, name, method, account, methodName, reference, refundedAt string
+		var amount float64
+		if err := rows.Scan(&typ, &number, &name, &method, &account, &methodName, &amount, &reference, &refundedAt); err != nil {
+			return nil, err
+		}
+		ledger := moneyLedger(account, method, methodName)
+		seen[number]++
+		number += "/" + strconv.Itoa(seen[number])
+		p := party(typ, name)
+		var v tallyVoucher
+		if typ == "inflow" {
+			v = tallyVoucherOf("Payment", refundedAt, loc, number, p)
+			v.Entries = []tallyEntry{tallyDebit(p, moneyOf(amount)), tallyCredit(ledger, moneyOf(amount))}
+		} else {
+			v = tallyVoucherOf("Receipt", refundedAt, loc, number, p)
+			v.Entries = []tallyEntry{tallyDebit(ledger, moneyOf(amount)), tallyCredit(p, moneyOf(amount))}
+		}
+		v.Reference, v.Narration = reference, "Refund"
+		out = append(out, tallyMessage{v})
+	}
+	return out, rows.Err()
+}
+
+func handleTallyExport(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	orgID := currentOrgID(c)
+	messages, err := tallyVouchers(c, orgID, from, to)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	profile, err := organizationProfile(dbFor(c), orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	company, _ := profile["name"].(string)
+	data, err := xml.MarshalIndent(tallyEnvelope{Request: "Import Data", ReportName: "Vouchers", Company: company, Messages: messages}, "", "  ")
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="tally-%s-%s.xml"`, from.Format("20060102"), to.Format("20060102")))
+	return c.Send(append([]byte(xml.Header), data...))
+}
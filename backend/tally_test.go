@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTallyExport(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Rahim','','customer','org-1')`,
+		`INSERT INTO cash_accounts (id,name,kind,opening_balance,active,organization_id) VALUES ('a-1','City Bank','bank',0,1,'org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,contact_name,tax_amount,receipt_number,organization_id,created_at) VALUES ('t-1','inflow',11500,10000,1500,'c-1','Rahim',15,'R-1','org-1','2024-03-10T10:00:00Z')`,
+		`INSERT INTO transaction_payments (id,transaction_id,method,amount,reference,account_id,created_at) VALUES ('p-1','t-1','bank_transfer',100,'CHQ 7','a-1','2024-03-11T10:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,payment_method,organization_id,created_at) VALUES ('t-2','outflow',4000,4000,0,'','cash','org-1','2024-03-12T10:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at,voided_at) VALUES ('t-3','inflow',7000,7000,0,'c-1','org-1','2024-03-12T10:00:00Z','2024-03-12T11:00:00Z')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('t-4','inflow',9000,9000,0,'c-1','org-1','2024-04-02T10:00:00Z')`,
+		`INSERT INTO credit_notes (id,organization_id,number,transaction_id,contact_id,type,amount,settlement,created_at) VALUES ('n-1','org-1','CN-00001','t-1','c-1','inflow',23,'refund','2024-03-20T10:00:00Z')`,
+		`INSERT INTO refunds (id,organization_id,credit_note_id,transaction_id,contact_id,type,method,amount,created_at) VALUES ('r-1','org-1','n-1','t-1','c-1','inflow','cash',23,'2024-03-20T10:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	seedAllLedgerAccounts()
+	app := newApp()
+	get := func(role, path string) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, raw
+	}
+
+	if code, _ := get("cashier", "/api/exports/tally.xml"); code != 403 {
+		t.Errorf("cashier exporting: got %d, want 403", code)
+	}
+	code, raw := get("manager", "/api/exports/tally.xml?from=2024-03-01&to=2024-04-01")
+	var env tallyEnvelope
+	if err := xml.Unmarshal(raw, &env); code != 200 || err != nil {
+		t.Fatalf("export: %d %v %s", code, err, raw)
+	}
+	vouchers := map[string]tallyVoucher{}
+	for _, m := range env.Messages {
+		v := m.Voucher
+		var sum money
+		for _, e := range v.Entries {
+			amount, err := parseMoney(e.Amount)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (amount < 0) != (e.DeemedPositive == "Yes") {
+				t.Errorf("%s %s: %s is deemed positive %q", v.Type, v.Number, e.Ledger, e.DeemedPositive)
+			}
+			sum += amount
+		}
+		if sum != 0 {
+			t.Errorf("%s %s does not balance: %s", v.Type, v.Number, sum)
+		}
+		vouchers[v.Type+" "+v.Number] = v
+	}
+	if len(vouchers) != 5 {
+		t.Fatalf("vouchers: %s", raw)
+	}
+	if v := vouchers["Sales R-1"]; v.Date != "20240310" || v.Party != "Rahim" || len(v.Entries) != 3 || v.Entries[1].Ledger != "Sales" || v.Entries[1].Amount != "100.00" || v.Entries[2].Ledger != "Output VAT" {
+		t.Errorf("sale: %+v", v)
+	}
+	if v := vouchers["Receipt R-1/1"]; v.Reference != "CHQ 7" || v.Entries[0].Ledger != "City Bank" || v.Entries[0].Amount != "-100.00" {
+		t.Errorf("receipt: %+v", v)
+	}
+	if v := vouchers["Purchase t-2"]; v.Party != "Sundry Supplier" || v.Entries[0].Ledger != "Purchases" {
+		t.Errorf("purchase: %+v", v)
+	}
+	if v := vouchers["Payment t-2/1"]; v.Entries[1].Ledger != "Cash" || v.Entries[1].Amount != "40.00" {
+		t.Errorf("payment: %+v", v)
+	}
+	if v := vouchers["Payment CN-00001/1"]; v.Party != "Rahim" || v.Entries[1].Amount != "23.00" || v.Narration != "Refund" {
+		t.Errorf("refund: %+v", v)
+	}
+	if !strings.HasPrefix(string(raw), "<?xml") {
+		t.Errorf("no xml declaration: %.40s", raw)
+	}
+}
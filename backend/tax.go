@@ -0,0 +1,256 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Tax rates are the tax categories items are put in ("Standard 15%",
+// "Reduced 5%", "Exempt 0%"). An item is taxed at its own rate, a variant
+// without one at its parent's, and anything else at the default_tax_rate
+// setting. Sales and purchases are taxed on the server when recorded:
+// each line keeps the rate, the amount taxed and the tax, and the
+// transaction the sum of its lines' tax. With prices_include_tax the tax
+// is taken out of the line totals; without it, it is added to them and
+// the transaction's amount goes up by it, what is due first.
+//
+//	GET    /api/tax-rates
+//	POST   /api/tax-rates          {"name": ..., "rate": 15}
+//	PATCH  /api/tax-rates/:id      lines already taxed keep their rate
+//	DELETE /api/tax-rates/:id      only when no item is in it
+//	GET    /api/reports/tax-summary?from=&to=
+//
+// The summary is for filing returns: per rate, what was sold and bought
+// and the tax on it, and the tax collected less the tax paid.
+
+type taxRate struct {
+	ID        string  `json:"id"`
+	Name      string  `json:"name"`
+	Rate      float64 `json:"rate"`
+	Items     int     `json:"items"`
+	CreatedAt string  `json:"created_at"`
+}
+
+func registerTaxRoutes(app *fiber.App) {
+	r := app.Group("/api/tax-rates", requireAuth)
+	r.Get("/", handleListTaxRates)
+	r.Post("/", requireRole("admin", "manager"), handleCreateTaxRate)
+	r.Patch("/:id", requireRole("admin", "manager"), handlePatchTaxRate)
+	r.Delete("/:id", requireRole("admin", "manager"), handleDeleteTaxRate)
+	app.Get("/api/reports/tax-summary", requireAuth, cachedReport, handleTaxSummary)
+}
+
+// itemTaxRate returns the percentage itemID is taxed at.
+func itemTaxRate(q queryer, orgID, itemID string) (float64, error) {
+	var rate sql.NullFloat64
+	err := q.QueryRow(`SELECT COALESCE(r.rate, pr.rate) FROM inventory_items i
+		LEFT JOIN tax_rates r ON r.id = i.tax_rate_id
+		LEFT JOIN inventory_items p ON p.id = i.parent_id
+		LEFT JOIN tax_rates pr ON pr.id = p.tax_rate_id
+		WHERE i.id = ?`, itemID).Scan(&rate)
+	if err != nil {
+		return 0, err
+	}
+	if rate.Valid {
+		return rate.Float64, nil
+	}
+	fallback, _ := orgSetting(orgID, "default_tax_rate").(float64)
+	return fallback, nil
+}
+
+// applyTax taxes the lines of a transaction, setting each line's
+// tax_rate, taxable_amount and tax_amount. It returns the tax on all the
+// lines and how much of it comes on top of their totals.
+func applyTax(q queryer, orgID string, lines []interface{}) (float64, float64, error) {
+	included, _ := orgSetting(orgID, "prices_include_tax").(bool)
+	var tax money
+	for _, line := range lines {
+		l, ok := line.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rate, err := itemTaxRate(q, orgID, toString(l["item_id"]))
+		if err != nil {
+			return 0, 0, err
+		}
+		total := moneyValue(l["total_price"])
+		taxable, lineTax := total, total.percent(rate)
+		if included {
+			lineTax = total.percent(100 * rate / (100 + rate))
+			taxable = total - lineTax
+		}
+		l["tax_rate"] = rate
+		l["taxable_amount"] = taxable.float()
+		l["tax_amount"] = lineTax.float()
+		tax += lineTax
+	}
+	if included {
+		return tax.float(), 0, nil
+	}
+	return tax.float(), tax.float(), nil
+}
+
+func handleListTaxRates(c *fiber.Ctx) error {
+	rows, err := dbFor(c).Query(`SELECT r.id, r.name, r.rate, (SELECT COUNT(1) FROM inventory_items WHERE tax_rate_id = r.id), COALESCE(r.created_at, '')
+		FROM tax_rates r WHERE r.organization_id = ? ORDER BY r.rate DESC, r.name`, currentOrgID(c))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	items := []taxRate{}
+	for rows.Next() {
+		var r taxRate
+		if err := rows.Scan(&r.ID, &r.Name, &r.Rate, &r.Items, &r.CreatedAt); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		items = append(items, r)
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	fallback, _ := orgSetting(currentOrgID(c), "default_tax_rate").(float64)
+	return c.JSON(fiber.Map{"items": items, "default_tax_rate": fallback})
+}
+
+// taxRateBody reads a tax rate body over r, checking what was sent.
+func taxRateBody(c *fiber.Ctx, r *taxRate) (int, string) {
+	var req struct {
+		Name *string  `json:"name"`
+		Rate *float64 `json:"rate"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return 400, "invalid json"
+	}
+	if req.Name != nil {
+		r.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Rate != nil {
+		r.Rate = *req.Rate
+	}
+	if r.Name == "" {
+		return 400, "name is required"
+	}
+	if r.Rate < 0 || r.Rate > 100 {
+		return 400, "rate must be a percentage from 0 to 100"
+	}
+	var taken int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM tax_rates WHERE organization_id = ? AND name = ? AND id <> ?`, currentOrgID(c), r.Name, r.ID).Scan(&taken); err != nil {
+		return 500, err.Error()
+	}
+	if taken > 0 {
+		return 409, "there is already a tax rate named " + r.Name
+	}
+	return 0, ""
+}
+
+func handleCreateTaxRate(c *fiber.Ctx) error {
+	r := taxRate{ID: genID(), Rate: -1, CreatedAt: time.Now().Format(time.RFC3339)}
+	if status, problem := taxRateBody(c, &r); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": problem})
+	}
+	if _, err := dbFor(c).Exec(`INSERT INTO tax_rates (id,organization_id,name,rate,created_at,updated_at) VALUES (?,?,?,?,?,?)`, r.ID, currentOrgID(c), r.Name, r.Rate, r.CreatedAt, r.CreatedAt); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(r)
+}
+
+func handlePatchTaxRate(c *fiber.Ctx) error {
+	r := taxRate{ID: c.Params("id")}
+	err := dbFor(c).QueryRow(`SELECT name, rate, COALESCE(created_at, '') FROM tax_rates WHERE id = ? AND organization_id = ?`, r.ID, currentOrgID(c)).Scan(&r.Name, &r.Rate, &r.CreatedAt)
+	if err != nil {
+		return recordError(c, notFound(err))
+	}
+	if status, problem := taxRateBody(c, &r); status != 0 {
+		return c.Status(status).JSON(fiber.Map{"error": problem})
+	}
+	if _, err := dbFor(c).Exec(`UPDATE tax_rates SET name = ?, rate = ?, updated_at = ? WHERE id = ?`, r.Name, r.Rate, time.Now().Format(time.RFC3339), r.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(r)
+}
+
+func handleDeleteTaxRate(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "tax_rates", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	var items int
+	if err := dbFor(c).QueryRow(`SELECT COUNT(1) FROM inventory_items WHERE tax_rate_id = ?`, id).Scan(&items); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if items > 0 {
+		return c.Status(409).JSON(fiber.Map{"error": "items are still taxed at this rate", "items": items})
+	}
+	if _, err := dbFor(c).Exec(`DELETE FROM tax_rates WHERE id = ?`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"deleted": true})
+}
+
+// handleTaxSummary totals the tax on the sales and purchases of a period
+// by rate. Voided transactions are left out.
+func handleTaxSummary(c *fiber.Ctx) error {
+	from, to, err := reportPeriod(c)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	rows, err := dbFor(c).Query(`SELECT ti.tax_rate, t.type, SUM(ti.taxable_amount), SUM(ti.tax_amount)
+		FROM transaction_items ti JOIN transactions t ON ti.transaction_id = t.id
+		WHERE t.organization_id = ? AND t.created_at >= ? AND t.created_at < ? AND t.voided_at IS NULL
+		GROUP BY ti.tax_rate, t.type`, currentOrgID(c), from.Format(time.RFC3339), to.Format(time.RFC3339))
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	type rateTotals struct {
+		Rate             float64 `json:"rate"`
+		SalesTaxable     float64 `json:"sales_taxable"`
+		OutputTax        float64 `json:"output_tax"`
+		PurchasesTaxable float64 `json:"purchases_taxable"`
+		InputTax         float64 `json:"input_tax"`
+	}
+	byRate := map[float64]*rateTotals{}
+	var totals rateTotals
+	for rows.Next() {
+		var rate, taxable, tax float64
+		var typ string
+		if err := rows.Scan(&rate, &typ, &taxable, &tax); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		r, ok := byRate[rate]
+		if !ok {
+			r = &rateTotals{Rate: rate}
+			byRate[rate] = r
+		}
+		if typ == "inflow" {
+			r.SalesTaxable, r.OutputTax = round2(r.SalesTaxable+taxable), round2(r.OutputTax+tax)
+			totals.SalesTaxable, totals.OutputTax = totals.SalesTaxable+taxable, totals.OutputTax+tax
+		} else {
+			r.PurchasesTaxable, r.InputTax = round2(r.PurchasesTaxable+taxable), round2(r.InputTax+tax)
+			totals.PurchasesTaxable, totals.InputTax = totals.PurchasesTaxable+taxable, totals.InputTax+tax
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	rates := []*rateTotals{}
+	for _, r := range byRate {
+		rates = append(rates, r)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Rate > rates[j].Rate })
+	return c.JSON(fiber.Map{
+		"from":              from.Format(time.RFC3339),
+		"to":                to.Format(time.RFC3339),
+		"rates":             rates,
+		"sales_taxable":     round2(totals.SalesTaxable),
+		"output_tax":        round2(totals.OutputTax),
+		"purchases_taxable": round2(totals.PurchasesTaxable),
+		"input_tax":         round2(totals.InputTax),
+		"net_tax":           round2(totals.OutputTax - totals.InputTax),
+	})
+}
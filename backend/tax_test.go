@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTax(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Supplier','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('tv','TV','TV',10,1000,'org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('rice','Rice','RICE',100,100,'org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,parent_id,organization_id) VALUES ('tv-43','TV, 43"','TV-43',5,1000,'tv','org-1')`,
+		`INSERT INTO settings (scope,scope_id,key,value) VALUES ('organization','org-1','default_tax_rate','5')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := call("manager", "POST", "/api/tax-rates", `{"name":"Standard","rate":150}`); code != 400 {
+		t.Errorf("rate over 100: got %d, want 400", code)
+	}
+	code, standard := call("manager", "POST", "/api/tax-rates", `{"name":"Standard","rate":15}`)
+	if code != 200 {
+		t.Fatalf("create: %d %v", code, standard)
+	}
+	rateID := toString(standard["id"])
+	if code, out := call("manager", "PATCH", "/api/collections/inventory_items/records/tv", `{"tax_rate_id":"`+rateID+`"}`); code != 200 {
+		t.Fatalf("tax category: %d %v", code, out)
+	}
+	if code, _ := call("manager", "PATCH", "/api/collections/inventory_items/records/rice", `{"tax_rate_id":"nope"}`); code != 400 {
+		t.Errorf("unknown tax rate: got %d, want 400", code)
+	}
+
+	// the variant is taxed at its parent's 15%, the rice at the default 5%
+	code, sale := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":1100,"paid_amount":1000,"due_amount":100,"contact_id":"c-1","items":[
+		{"item_id":"tv-43","quantity":1,"unit_price":1000,"total_price":1000},
+		{"item_id":"rice","quantity":1,"unit_price":100,"total_price":100}]}`)
+	if code != 200 || sale["tax_amount"] != 155.0 || sale["amount"] != 1255.0 {
+		t.Fatalf("sale: %d %v", code, sale)
+	}
+	var due money
+	var tax float64
+	if err := db.QueryRow(`SELECT due_amount, tax_amount FROM transactions WHERE id = ?`, sale["id"]).Scan(&due, &tax); err != nil || due != moneyOf(255) || tax != 155 {
+		t.Errorf("sale due %v tax %v %v, want 255 and 155", due, tax, err)
+	}
+	var rate, taxable, lineTax float64
+	if err := db.QueryRow(`SELECT tax_rate, taxable_amount, tax_amount FROM transaction_items WHERE transaction_id = ? AND item_id = 'tv-43'`, sale["id"]).Scan(&rate, &taxable, &lineTax); err != nil || rate != 15 || taxable != 1000 || lineTax != 150 {
+		t.Errorf("line: %v %v %v %v", rate, taxable, lineTax, err)
+	}
+
+	// with prices including tax, the tax comes out of the prices
+	if code, out := call("manager", "PUT", "/api/settings/organization", `{"prices_include_tax":true}`); code != 200 {
+		t.Fatalf("setting: %d %v", code, out)
+	}
+	code, purchase := call("manager", "POST", "/api/collections/transactions/records", `{"type":"outflow","amount":1150,"paid_amount":1150,"due_amount":0,"contact_id":"s-1","items":[
+		{"item_id":"tv-43","quantity":1,"unit_price":1150,"total_price":1150}]}`)
+	if code != 200 || purchase["tax_amount"] != 150.0 || purchase["amount"] != nil {
+		t.Fatalf("purchase: %d %v", code, purchase)
+	}
+
+	code, summary := call("viewer", "GET", "/api/reports/tax-summary?to="+time.Now().Add(time.Hour).Format(time.RFC3339), "")
+	if code != 200 || summary["output_tax"] != 155.0 || summary["input_tax"] != 150.0 || summary["net_tax"] != 5.0 || summary["sales_taxable"] != 1100.0 || summary["purchases_taxable"] != 1000.0 {
+		t.Errorf("summary: %d %v", code, summary)
+	}
+	if rates, _ := summary["rates"].([]interface{}); len(rates) != 2 || rates[0].(map[string]interface{})["rate"] != 15.0 {
+		t.Errorf("rates: %v", summary["rates"])
+	}
+
+	if code, _ := call("manager", "DELETE", "/api/tax-rates/"+rateID, ""); code != 409 {
+		t.Errorf("deleting a rate in use: got %d, want 409", code)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Every business record belongs to an organization. The collection API
+// only ever reads and writes rows of the caller's organization (taken from
+// their token), so several businesses can share one deployment.
+
+// tenantTables are the tables carrying an organization_id column. Child
+// tables (transaction_items, consignment_sales, account_movements, ...)
+// belong to the organization of their parent row.
+var tenantTables = []string{
+	"contacts", "inventory_items", "inventory_transactions", "transactions",
+	"rentals", "consignments", "opening_balances", "fiscal_years",
+	"payment_methods", "cash_accounts", "deposits", "ledger_accounts", "campaigns",
+	"purchase_orders", "quotations", "recurring_expenses", "settlement_credits",
+	"alerts", "invites", "devices", "stocktakes", "locations",
+	"item_batches", "item_units", "item_components", "promotions", "price_overrides", "price_lists", "price_list_items",
+	"receipt_blocks", "tax_rates", "item_codes", "contact_payments", "inbox_documents", "rest_hooks", "accountant_shares", "credit_notes", "refunds", "stock_value_snapshots", "kpi_digests", "invoice_deliveries", "due_reminders", "mobile_payments", "payment_links",
+}
+
+func isTenantTable(table string) bool {
+	for _, t := range tenantTables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+// assignOrphanRecords moves rows created before organizations were
+// enforced into the first organization, creating one if the database has
+// none yet.
+func assignOrphanRecords() {
+	orgID, err := firstOrganizationID(db)
+	if err != nil {
+		log.Printf("find default organization: %v", err)
+		return
+	}
+	if orgID == "" {
+		orgID = genID()
+		if _, err := db.Exec(`INSERT INTO organizations (id,name,owner_id,status,created_at) VALUES (?,?,?,?,?)`, orgID, "My Business", "", "active", time.Now().Format(time.RFC3339)); err != nil {
+			log.Printf("create default organization: %v", err)
+			return
+		}
+	}
+	for _, table := range tenantTables {
+		if _, err := db.Exec(`UPDATE `+table+` SET organization_id = ? WHERE organization_id IS NULL OR organization_id = ''`, orgID); err != nil {
+			log.Printf("assign %s to organization: %v", table, err)
+		}
+	}
+}
+
+// createOrganization creates a new organization owned by userID, with
+// the default payment methods and chart of accounts.
+func createOrganization(q *DB, name, userID string) (string, error) {
+	id := genID()
+	if _, err := q.Exec(`INSERT INTO organizations (id,name,owner_id,status,created_at) VALUES (?,?,?,?,?)`, id, name, userID, "active", time.Now().Format(time.RFC3339)); err != nil {
+		return "", err
+	}
+	if err := seedPaymentMethods(q, id); err != nil {
+		return "", err
+	}
+	if err := seedLedgerAccounts(q, id); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// organizationIDs lists every organization.
+func organizationIDs() []string {
+	rows, err := db.Query(`SELECT id FROM organizations`)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// firstOrganizationID returns the organization that holds data created
+// before there were several, or "" when there is none yet.
+func firstOrganizationID(q queryer) (string, error) {
+	var id string
+	err := q.QueryRow(`SELECT id FROM organizations ORDER BY ` + db.dialect.insertionOrder() + ` LIMIT 1`).Scan(&id)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return id, err
+}
+
+// orgOwns reports whether the row id of a tenant table belongs to orgID.
+// table must be one of tenantTables. A failed lookup is logged and owns
+// nothing, so the row stays hidden.
+func orgOwns(q *DB, table, id, orgID string) bool {
+	var n int
+	if err := q.PreparedQueryRow(`SELECT COUNT(1) FROM `+table+` WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&n); err != nil {
+		log.Printf("org check on %s %s: %v", table, id, err)
+		return false
+	}
+	return n > 0
+}
+
+// itemOrgSQL is the value expression that gives an inventory movement the
+// organization of its item; it takes the item id as its argument.
+const itemOrgSQL = `(SELECT organization_id FROM inventory_items WHERE id = ?)`
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// publicRoutes are the only /api paths reachable without a token; an
+// entry may be limited to one method ("GET /api/realtime").
+var publicRoutes = []string{"/api/auth/register", "/api/auth/login", "/api/auth/refresh", "/api/auth/logout", "/api/health", "/api/openapi.json", "/api/docs", "GET /api/realtime", "POST /api/messages/delivery", "POST /api/inbox/mailgun", "/api/shared/", "GET /api/invites/:token", "POST /api/invites/:token/accept", "GET /api/mobile-payments/:provider/callback", "POST /api/payment-links/stripe/webhook"}
+
+func TestAPIRoutesRequireAuth(t *testing.T) {
+	app := newApp()
+	checked := 0
+	for _, r := range app.GetRoutes(true) {
+		if !strings.HasPrefix(r.Path, "/api") || r.Method == "HEAD" || r.Method == "OPTIONS" || r.Method == "CONNECT" || r.Method == "TRACE" {
+			continue
+		}
+		public := false
+		for _, p := range publicRoutes {
+			if method, path, ok := strings.Cut(p, " "); ok {
+				public = public || (r.Method == method && r.Path == path)
+			} else if strings.HasPrefix(r.Path, p) {
+				public = true
+			}
+		}
+		if public {
+			continue
+		}
+		path := r.Path
+		for _, p := range r.Params {
+			path = strings.Replace(path, ":"+p, "x", 1)
+		}
+		resp, err := app.Test(httptest.NewRequest(r.Method, path, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 401 {
+			t.Errorf("%s %s without a token: status %d, want 401", r.Method, r.Path, resp.StatusCode)
+		}
+		checked++
+	}
+	if checked == 0 {
+		t.Fatal("no routes checked")
+	}
+}
+
+func TestReportCacheKeyIncludesOrganization(t *testing.T) {
+	app := fiber.New()
+	app.Get("/r", func(c *fiber.Ctx) error {
+		c.Locals("orgID", c.Query("org"))
+		return c.SendString(reportCacheKey(c))
+	})
+	key := func(org string) string {
+		resp, err := app.Test(httptest.NewRequest("GET", "/r?as_of=2024-01-01&org="+org, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(body)
+	}
+	if key("org-1") == key("org-2") {
+		t.Fatal("two organizations share a report cache key")
+	}
+}
+
+// Cash accounts and payment methods of another organization are neither
+// listed nor usable.
+func TestCashAccountsScopedToOrganization(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active'), ('org-2','Theirs','','active')`,
+		`INSERT INTO cash_accounts (id,name,kind,opening_balance,active,organization_id) VALUES ('a-1','Till','cash',100,1,'org-1'), ('a-2','Their bank','bank',5000,1,'org-2')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	app := newApp()
+	token := testToken(t, "admin")
+
+	req := httptest.NewRequest("GET", "/api/cash-accounts", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		Items []map[string]interface{} `json:"items"`
+		Total float64                  `json:"total"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 || list.Items[0]["id"] != "a-1" || list.Total != 100 {
+		t.Fatalf("listed %v (total %v), want only a-1", list.Items, list.Total)
+	}
+
+	req = httptest.NewRequest("GET", "/api/cash-accounts/a-2/movements", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	if resp, err = app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 404 {
+		t.Errorf("other organization's account: status %d, want 404", resp.StatusCode)
+	}
+
+	var methods int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM payment_methods WHERE organization_id = 'org-2'`).Scan(&methods); err != nil {
+		t.Fatal(err)
+	}
+	if methods != len(defaultPaymentMethods) {
+		t.Errorf("org-2 has %d payment methods, want %d", methods, len(defaultPaymentMethods))
+	}
+	if _, err := parsePayments(db, "org-1", map[string]interface{}{"payments": []interface{}{map[string]interface{}{"method": "cash", "amount": 10.0, "account_id": "a-2"}}}); err == nil {
+		t.Error("paying into another organization's account was accepted")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Every API request runs under a deadline, REQUEST_TIMEOUT_SECONDS (default
+// 30), or REPORT_TIMEOUT_SECONDS (default 15) for /api/reports and
+// /api/analytics. Handlers reach the database through dbFor(c), which is
+// bound to the request's context, so a runaway report query is canceled at
+// the deadline instead of holding a connection (and, on SQLite, the
+// writer) until it finishes. A request that ran out of time answers 504.
+//
+// fasthttp does not tell a handler when the client disconnects, so an
+// abandoned request is only cut short by its deadline; a streamed list
+// stops as soon as a write to the gone client fails.
+
+func timeoutSetting(name string, def time.Duration) time.Duration {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return def
+}
+
+func requestTimeout(path string) time.Duration {
+	if strings.HasPrefix(path, "/api/reports") || strings.HasPrefix(path, "/api/analytics") {
+		return timeoutSetting("REPORT_TIMEOUT_SECONDS", 15*time.Second)
+	}
+	return timeoutSetting("REQUEST_TIMEOUT_SECONDS", 30*time.Second)
+}
+
+// requestDeadline puts the request's context under its deadline. A
+// streamed response outlives the handler, so its writer releases the
+// context through requestDone instead.
+func requestDeadline(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), requestTimeout(c.Path()))
+	c.SetUserContext(ctx)
+	c.Locals("cancelRequest", cancel)
+	err := c.Next()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) && c.Response().StatusCode() >= 500 {
+		c.Response().ResetBody()
+		err = c.Status(504).JSON(fiber.Map{"error": "request timed out"})
+	}
+	if !c.Response().IsBodyStream() {
+		cancel()
+	}
+	return err
+}
+
+// requestDone returns the function that releases the request's context,
+// for a body stream writer to call when it is finished.
+func requestDone(c *fiber.Ctx) context.CancelFunc {
+	if cancel, ok := c.Locals("cancelRequest").(context.CancelFunc); ok {
+		return cancel
+	}
+	return func() {}
+}
+
+// dbFor returns the database bound to the request's context.
+func dbFor(c *fiber.Ctx) *DB {
+	return db.WithContext(c.UserContext())
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// slowQuery counts far enough that it only ends by being canceled.
+const slowQuery = `WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 1000000000) SELECT COUNT(*) FROM n`
+
+func TestRequestDeadline(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	var n int
+	if err := db.WithContext(ctx).QueryRow(slowQuery).Scan(&n); err == nil {
+		t.Fatal("query outlived its context")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("canceled query took %v", time.Since(start))
+	}
+
+	t.Setenv("REPORT_TIMEOUT_SECONDS", "1")
+	app := newApp()
+	app.Get("/api/reports/slow", requireAuth, func(c *fiber.Ctx) error {
+		var n int
+		if err := dbFor(c).QueryRow(slowQuery).Scan(&n); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return c.JSON(fiber.Map{"n": n})
+	})
+	req := httptest.NewRequest("GET", "/api/reports/slow", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out map[string]interface{}
+	_ = json.NewDecoder(resp.Body).Decode(&out)
+	if resp.StatusCode != 504 || out["error"] != "request timed out" {
+		t.Errorf("slow report: %d %v", resp.StatusCode, out)
+	}
+
+	// a transaction begun for the request is rolled back with it
+	tx, err := db.WithContext(ctx).Begin()
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO contacts (id,name,type,organization_id) VALUES ('c-1','Late','customer','org-1')`)
+		tx.Rollback()
+	}
+	if err == nil {
+		t.Error("expected an expired context to stop the transaction")
+	}
+}
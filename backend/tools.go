@@ -0,0 +1,125 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Calculators the frontend uses for what-if questions. They read query
+// params and save nothing, so they are GETs and do not invalidate cached
+// reports.
+
+func registerToolRoutes(app *fiber.App) {
+	t := app.Group("/api/tools", requireAuth)
+	t.Get("/break-even", handleBreakEven)
+	t.Get("/sale-price", handleSalePrice)
+}
+
+// queryFloats reads the named query params as numbers. Missing params are
+// zero; required ones must be present.
+func queryFloats(c *fiber.Ctx, required []string, optional ...string) (map[string]float64, error) {
+	out := map[string]float64{}
+	for i, name := range append(append([]string{}, required...), optional...) {
+		v := c.Query(name)
+		if v == "" {
+			if i < len(required) {
+				return nil, fmt.Errorf("%s is required", name)
+			}
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%s must be a number", name)
+		}
+		if f < 0 {
+			return nil, fmt.Errorf("%s cannot be negative", name)
+		}
+		out[name] = f
+	}
+	return out, nil
+}
+
+// round2 rounds v to two decimals the way amounts are; see money.go.
+func round2(v float64) float64 {
+	return moneyOf(v).float()
+}
+
+// handleBreakEven works out how many units must be sold at unit_price to
+// cover fixed_costs when each unit costs variable_cost, and how many more
+// reach target_profit.
+func handleBreakEven(c *fiber.Ctx) error {
+	q, err := queryFloats(c, []string{"fixed_costs", "unit_price"}, "variable_cost", "target_profit")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	contribution := q["unit_price"] - q["variable_cost"]
+	if contribution <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "unit_price must be more than variable_cost"})
+	}
+	units := math.Ceil(q["fixed_costs"] / contribution)
+	out := fiber.Map{
+		"contribution_margin":       round2(contribution),
+		"contribution_margin_ratio": round2(contribution / q["unit_price"] * 100),
+		"break_even_units":          units,
+		"break_even_revenue":        round2(units * q["unit_price"]),
+	}
+	if q["target_profit"] > 0 {
+		target := math.Ceil((q["fixed_costs"] + q["target_profit"]) / contribution)
+		out["target_units"] = target
+		out["target_revenue"] = round2(target * q["unit_price"])
+	}
+	return c.JSON(out)
+}
+
+// handleSalePrice suggests a unit price earning target_margin (percent of
+// the price) on the landed cost. The landed cost is landed_cost, the cost
+// price of item_id, or purchase_price plus freight, duty and other_costs
+// spread over quantity. round_ending (e.g. 0.99) rounds the price up to
+// that ending, as in bulk repricing.
+func handleSalePrice(c *fiber.Ctx) error {
+	q, err := queryFloats(c, []string{"target_margin"}, "landed_cost", "purchase_price", "freight", "duty", "other_costs", "quantity", "round_ending")
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+	if q["target_margin"] >= 100 {
+		return c.Status(400).JSON(fiber.Map{"error": "target_margin must be below 100"})
+	}
+	cost := q["landed_cost"]
+	if id := c.Query("item_id"); id != "" {
+		var costPrice sql.NullFloat64
+		err := dbFor(c).QueryRow(`SELECT cost_price FROM inventory_items WHERE id = ? AND organization_id = ?`, id, currentOrgID(c)).Scan(&costPrice)
+		if err == sql.ErrNoRows {
+			return c.Status(404).JSON(fiber.Map{"error": "item not found"})
+		} else if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		cost = costPrice.Float64
+	} else if cost == 0 {
+		quantity := q["quantity"]
+		if quantity == 0 {
+			quantity = 1
+		}
+		cost = (q["purchase_price"] + q["freight"] + q["duty"] + q["other_costs"]) / quantity
+	}
+	if cost <= 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "landed_cost, item_id or purchase_price is required"})
+	}
+
+	ops := []priceOperation{{Op: "set_margin", Value: q["target_margin"]}}
+	if _, ok := q["round_ending"]; ok {
+		ops = append(ops, priceOperation{Op: "round_ending", Value: q["round_ending"]})
+	}
+	price, _ := applyPriceOperations(0, cost, ops)
+	profit := price - cost
+	return c.JSON(fiber.Map{
+		"landed_cost":     round2(cost),
+		"price":           price,
+		"profit_per_unit": round2(profit),
+		"margin_percent":  round2(profit / price * 100),
+		"markup_percent":  round2(profit / cost * 100),
+	})
+}
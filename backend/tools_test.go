@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCalculatorTools(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('i-1','Blue pen','PEN',10,15,12,'org-1')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	get := func(path string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "cashier"))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	_, out := get("/api/tools/break-even?fixed_costs=10000&unit_price=150&variable_cost=100&target_profit=5000")
+	if out["break_even_units"] != float64(200) || out["break_even_revenue"] != float64(30000) || out["contribution_margin_ratio"] != 33.33 || out["target_units"] != float64(300) {
+		t.Errorf("break-even %v", out)
+	}
+	for _, path := range []string{
+		"/api/tools/break-even?fixed_costs=10000&unit_price=100&variable_cost=100",
+		"/api/tools/break-even?unit_price=100",
+		"/api/tools/break-even?fixed_costs=ten&unit_price=100",
+		"/api/tools/sale-price?landed_cost=80&target_margin=100",
+		"/api/tools/sale-price?target_margin=20",
+	} {
+		if code, _ := get(path); code != 400 {
+			t.Errorf("%s: got %d, want 400", path, code)
+		}
+	}
+
+	cases := []struct {
+		path  string
+		price float64
+	}{
+		{"/api/tools/sale-price?landed_cost=80&target_margin=20", 100},
+		{"/api/tools/sale-price?purchase_price=1000&freight=100&duty=100&quantity=20&target_margin=25", 80},
+		{"/api/tools/sale-price?purchase_price=1000&freight=100&duty=100&quantity=20&target_margin=25&round_ending=0.99", 80.99},
+		{"/api/tools/sale-price?item_id=i-1&target_margin=20", 15},
+	}
+	for _, tc := range cases {
+		if _, out := get(tc.path); out["price"] != tc.price {
+			t.Errorf("%s: price %v, want %v", tc.path, out["price"], tc.price)
+		}
+	}
+	if _, out := get("/api/tools/sale-price?landed_cost=80&target_margin=20"); out["markup_percent"] != float64(25) || out["profit_per_unit"] != float64(20) {
+		t.Errorf("sale price %v", out)
+	}
+}
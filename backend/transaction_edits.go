@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A transaction's lines and amount can be corrected after it was saved,
+// with PATCH /api/collections/transactions/records/:id. "items" replaces
+// the lines; the server compares them with the old ones, item by item and
+// location by location, and moves only the difference in stock and
+// batches, as if the transaction had been entered right the first time.
+// "amount" sets the amount; without it the amount moves by what the lines
+// (and tax on top of them) moved. Payments stay as they were, so the
+// amount may not fall below what has been paid. Each edit keeps the lines
+// and amount it replaced.
+//
+//	GET /api/transactions/:id/edits    the edits of a transaction, oldest first
+
+func registerTransactionEditRoutes(app *fiber.App) {
+	app.Get("/api/transactions/:id/edits", requireAuth, handleListTransactionEdits)
+}
+
+// editedLine is a transaction line as an edit found it.
+type editedLine struct {
+	ItemID     string `json:"item_id"`
+	Quantity   int    `json:"quantity"`
+	UnitPrice  money  `json:"unit_price"`
+	TotalPrice money  `json:"total_price"`
+	LocationID string `json:"location_id,omitempty"`
+}
+
+// editKey is where a line moves stock: an item at a location.
+type editKey struct{ itemID, locationID string }
+
+// editTransaction applies the items and amount of a PATCH to transaction
+// id, returning what goes in the response or a status and problem.
+func editTransaction(c *fiber.Ctx, id string, body map[string]interface{}) (fiber.Map, int, fiber.Map) {
+	orgID := currentOrgID(c)
+	// what the edit is measured against is read in the transaction that
+	// writes it, and the write only lands if the amounts are still the
+	// ones read, so two edits at once cannot both apply their difference
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+	defer tx.Rollback()
+	var typ, source, voidedAt, currency string
+	var amount, paid, due money
+	var taxAmount float64
+	if err := tx.QueryRow(`SELECT type, amount, paid_amount, due_amount, COALESCE(tax_amount, 0), COALESCE(source, ''), COALESCE(voided_at, ''), COALESCE(currency, '') FROM transactions WHERE id = ?`, id).
+		Scan(&typ, &amount, &paid, &due, &taxAmount, &source, &voidedAt, &currency); err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+	switch {
+	case voidedAt != "":
+		return nil, 409, fiber.Map{"error": "a voided transaction cannot be edited"}
+	case source == "opening":
+		return nil, 409, fiber.Map{"error": "opening balance; change it under opening balances"}
+	case currency != "":
+		return nil, 409, fiber.Map{"error": "a transaction in a foreign currency cannot be edited; void it and enter it again"}
+	}
+	var consigned int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM consignment_sales WHERE transaction_id = ?`, id).Scan(&consigned); err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+	if consigned > 0 {
+		return nil, 409, fiber.Map{"error": "it sold consigned units; void it and enter it again"}
+	}
+	var returns int
+	if err := tx.QueryRow(`SELECT COUNT(1) FROM credit_notes WHERE transaction_id = ?`, id).Scan(&returns); err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+	if returns > 0 {
+		return nil, 409, fiber.Map{"error": "goods have been returned against it; return more or issue a new transaction"}
+	}
+
+	rows, err := tx.Query(`SELECT item_id, quantity, unit_price, total_price, COALESCE(location_id, '') FROM transaction_items WHERE transaction_id = ?`, id)
+	if err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+	oldLines := []editedLine{}
+	var oldTotal money
+	for rows.Next() {
+		var l editedLine
+		if err := rows.Scan(&l.ItemID, &l.Quantity, &l.UnitPrice, &l.TotalPrice, &l.LocationID); err != nil {
+			rows.Close()
+			return nil, 500, fiber.Map{"error": err.Error()}
+		}
+		oldLines = append(oldLines, l)
+		oldTotal += l.TotalPrice
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+
+	roundAmounts(body)
+	newAmount, tax := amount, moneyOf(taxAmount)
+	raw, editItems := body["items"]
+	lines, ok := raw.([]interface{})
+	if editItems && !ok {
+		return nil, 400, fiber.Map{"error": "items must be a list of lines"}
+	}
+	for _, line := range lines {
+		l, ok := line.(map[string]interface{})
+		if !ok {
+			return nil, 400, fiber.Map{"error": "items must be a list of lines"}
+		}
+		itemID := toString(l["item_id"])
+		if !orgOwns(dbFor(c), "inventory_items", itemID, orgID) {
+			return nil, 400, fiber.Map{"error": "unknown item " + itemID}
+		}
+		variants, err := hasVariants(tx, itemID)
+		if err != nil {
+			return nil, 500, fiber.Map{"error": err.Error()}
+		}
+		if variants {
+			return nil, 400, fiber.Map{"error": "item " + itemID + " has variants; choose one of them"}
+		}
+		if quantity, _ := l["quantity"].(float64); quantity <= 0 || quantity != float64(int(quantity)) {
+			return nil, 400, fiber.Map{"error": "quantity must be a whole number above 0"}
+		}
+		if loc := toString(l["location_id"]); loc != "" && !orgOwns(dbFor(c), "locations", loc, orgID) {
+			return nil, 400, fiber.Map{"error": "unknown location " + loc}
+		}
+		if !validExpiryDate(toString(l["expiry_date"])) {
+			return nil, 400, fiber.Map{"error": "expiry_date must be a date (YYYY-MM-DD)"}
+		}
+		if err := convertLineUnit(tx, l); err != nil {
+			return nil, 400, fiber.Map{"error": err.Error()}
+		}
+		if typ == "inflow" {
+			if status, problem := checkMinimumPrice(c, orgID, l); status != 0 {
+				return nil, status, problem
+			}
+		}
+	}
+	if editItems {
+		lineTax, added, err := applyTax(tx, orgID, lines)
+		if err != nil {
+			return nil, 500, fiber.Map{"error": err.Error()}
+		}
+		// tax that came on top of the old lines leaves with them
+		var newTotal, oldAdded money
+		for _, line := range lines {
+			newTotal += moneyValue(line.(map[string]interface{})["total_price"])
+		}
+		if included, _ := orgSetting(orgID, "prices_include_tax").(bool); !included {
+			oldAdded = tax
+		}
+		newAmount += newTotal + moneyOf(added) - oldTotal - oldAdded
+		tax = moneyOf(lineTax)
+	}
+	if v, ok := body["amount"]; ok {
+		f, isNum := v.(float64)
+		if !isNum || f < 0 {
+			return nil, 400, fiber.Map{"error": "amount must be a number of 0 or more"}
+		}
+		newAmount = moneyOf(f)
+	}
+	if newAmount < paid {
+		return nil, 400, fiber.Map{"error": "amount is below what has been paid", "paid_amount": paid}
+	}
+
+	// a sale takes units out of stock and a purchase puts them in; the
+	// edit moves what the new lines move less what the old ones did
+	sign := -1
+	if typ == "outflow" {
+		sign = 1
+	}
+	moved, newLine := map[editKey]int{}, map[editKey]map[string]interface{}{}
+	var keys []editKey
+	track := func(k editKey) {
+		if _, ok := moved[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for _, l := range oldLines {
+		k := editKey{l.ItemID, l.LocationID}
+		track(k)
+		moved[k] -= sign * l.Quantity
+	}
+	for _, line := range lines {
+		l := line.(map[string]interface{})
+		k := editKey{toString(l["item_id"]), toString(l["location_id"])}
+		track(k)
+		quantity, _ := l["quantity"].(float64)
+		moved[k] += sign * int(quantity)
+		newLine[k] = l
+	}
+	changes := []bulkStockChange{}
+	for _, k := range keys {
+		if moved[k] == 0 {
+			continue
+		}
+		ch := bulkStockChange{ItemID: k.itemID, Change: moved[k], LocationID: k.locationID}
+		if err := tx.QueryRow(`SELECT COALESCE(name, '') FROM inventory_items WHERE id = ?`, k.itemID).Scan(&ch.Name); err != nil {
+			return nil, 500, fiber.Map{"error": err.Error()}
+		}
+		changes = append(changes, ch)
+	}
+	// units coming back go in before any go out, so stock moved between
+	// locations of an item never dips below zero on the way
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Change > changes[j].Change })
+
+	why := "Edit of transaction " + id
+	for _, ch := range changes {
+		k := editKey{ch.ItemID, ch.LocationID}
+		var unitCost float64
+		if l := newLine[k]; l != nil {
+			unitCost, _ = l["unit_price"].(float64)
+		}
+		var status int
+		if typ == "outflow" && ch.Change > 0 {
+			status, err = receiveStock(tx, ch.ItemID, ch.Change, unitCost, typ, why)
+		} else {
+			status, err = adjustStock(tx, ch.ItemID, ch.Change, typ, why)
+		}
+		if err != nil {
+			if status == 409 {
+				return nil, 409, fiber.Map{"error": ch.Name + ": " + err.Error()}
+			}
+			return nil, status, fiber.Map{"error": err.Error()}
+		}
+		if status, err := moveLocationStock(tx, orgID, ch.ItemID, ch.LocationID, ch.Change); err != nil {
+			return nil, status, fiber.Map{"error": err.Error()}
+		}
+		// more units bought fill a new batch and more sold empty the one
+		// expiring first; units taken off a line undo its latest batches
+		switch {
+		case typ == "outflow" && ch.Change > 0:
+			l := newLine[k]
+			_, _, err = receiveBatch(tx, orgID, ch.ItemID, id, ch.Change, unitCost, toString(l["lot_number"]), toString(l["expiry_date"]))
+		case typ == "inflow" && ch.Change < 0:
+			err = consumeBatches(tx, ch.ItemID, id, -ch.Change)
+		case ch.Change > 0:
+			err = unwindBatches(tx, ch.ItemID, id, ch.Change)
+		default:
+			err = unwindBatches(tx, ch.ItemID, id, -ch.Change)
+		}
+		if err != nil {
+			return nil, 500, fiber.Map{"error": err.Error()}
+		}
+	}
+	if editItems {
+		if _, err := tx.Exec(`DELETE FROM transaction_items WHERE transaction_id = ?`, id); err != nil {
+			return nil, 500, fiber.Map{"error": err.Error()}
+		}
+		for _, line := range lines {
+			l := line.(map[string]interface{})
+			quantity, _ := l["quantity"].(float64)
+			if _, err := tx.Exec(`INSERT INTO transaction_items (id,transaction_id,item_id,quantity,unit_price,total_price,location_id,unit,unit_quantity,discount,tax_rate,taxable_amount,tax_amount) VALUES (?,?,?,?,?,?,NULLIF(?, ''),?,?,?,?,?,?)`,
+				genID(), id, l["item_id"], int(quantity), moneyValue(l["unit_price"]), moneyValue(l["total_price"]), toString(l["location_id"]), l["unit"], l["unit_quantity"], moneyValue(l["discount"]).float(), l["tax_rate"], l["taxable_amount"], l["tax_amount"]); err != nil {
+				return nil, 500, fiber.Map{"error": err.Error()}
+			}
+		}
+	}
+	newDue := newAmount - paid
+	res, err := tx.Exec(`UPDATE transactions SET amount = ?, due_amount = ?, tax_amount = ? WHERE id = ? AND amount = ? AND due_amount = ?`, newAmount, newDue, tax.float(), id, amount, due)
+	if err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	} else if n == 0 {
+		return nil, 409, fiber.Map{"error": "the transaction changed while it was being edited; reload it and try again"}
+	}
+	replaced, _ := json.Marshal(oldLines)
+	if _, err := tx.Exec(`INSERT INTO transaction_edits (id,transaction_id,old_amount,new_amount,old_items,edited_by,edited_at) VALUES (?,?,?,?,?,?,?)`,
+		genID(), id, amount.float(), newAmount.float(), string(replaced), currentUserID(c), time.Now().Format(time.RFC3339)); err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+	if err := refreshTransactionReadModel(tx, id); err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, 500, fiber.Map{"error": err.Error()}
+	}
+	for _, ch := range changes {
+		publishRecord(orgID, "inventory_items", "update", ch.ItemID)
+	}
+	return fiber.Map{"amount": newAmount, "due_amount": newDue, "stock": changes}, 0, nil
+}
+
+func handleListTransactionEdits(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if !orgOwns(dbFor(c), "transactions", id, currentOrgID(c)) {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	rows, err := dbFor(c).Query(`SELECT e.id, e.old_amount, e.new_amount, e.old_items, COALESCE(e.edited_by, ''), COALESCE(u.name, ''), e.edited_at
+		FROM transaction_edits e LEFT JOIN users u ON u.id = e.edited_by WHERE e.transaction_id = ? ORDER BY e.edited_at, e.id`, id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer rows.Close()
+	edits := []fiber.Map{}
+	for rows.Next() {
+		var editID, oldItems, editedBy, editorName, editedAt string
+		var oldAmount, newAmount float64
+		if err := rows.Scan(&editID, &oldAmount, &newAmount, &oldItems, &editedBy, &editorName, &editedAt); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		edits = append(edits, fiber.Map{"id": editID, "old_amount": moneyOf(oldAmount), "new_amount": moneyOf(newAmount), "old_items": json.RawMessage(oldItems),
+			"edited_by": editedBy, "edited_by_name": editorName, "edited_at": editedAt})
+	}
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"id": id, "edits": edits})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEditTransaction(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('s-1','Pran Foods','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,'org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-2','Ink','INK',5,10,'org-1')`,
+		`INSERT INTO cash_accounts (id,name,kind,opening_balance,active,organization_id) VALUES ('a-1','Till','cash',0,1,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	app := newApp()
+	call := func(role, method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	stock := func(itemID string) int {
+		t.Helper()
+		var quantity int
+		if err := db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = ?`, itemID).Scan(&quantity); err != nil {
+			t.Fatal(err)
+		}
+		return quantity
+	}
+
+	// a sale is held to the same quantities as an edit
+	for _, quantity := range []string{"-2", "0", "1.5"} {
+		if code, _ := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":15,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":`+quantity+`,"unit_price":15}]}`); code != 400 {
+			t.Errorf("selling %s: got %d, want 400", quantity, code)
+		}
+	}
+	if stock("i-1") != 10 {
+		t.Errorf("refused sales moved stock: %d", stock("i-1"))
+	}
+
+	code, sale := call("cashier", "POST", "/api/collections/transactions/records", `{"type":"inflow","amount":45,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":3,"unit_price":15}],"payments":[{"method":"cash","amount":20,"account_id":"a-1"}]}`)
+	if code != 200 {
+		t.Fatalf("sale: %d %v", code, sale)
+	}
+	path := "/api/collections/transactions/records/" + toString(sale["id"])
+	if code, _ := call("cashier", "PATCH", path, `{"items":[]}`); code != 403 {
+		t.Errorf("cashier editing: got %d, want 403", code)
+	}
+	code, out := call("manager", "PATCH", path, `{"items":[{"item_id":"i-1","quantity":2,"unit_price":15},{"item_id":"i-2","quantity":1,"unit_price":10}]}`)
+	if code != 200 || out["amount"] != 40.0 || out["due_amount"] != 20.0 {
+		t.Fatalf("edit: %d %v", code, out)
+	}
+	if stock("i-1") != 8 || stock("i-2") != 4 {
+		t.Errorf("stock after edit = %d, %d; want 8, 4", stock("i-1"), stock("i-2"))
+	}
+	var note string
+	var change int
+	if err := db.QueryRow(`SELECT quantity_change, notes FROM inventory_transactions WHERE item_id = 'i-1' ORDER BY rowid DESC LIMIT 1`).Scan(&change, &note); err != nil || change != 1 || !strings.HasPrefix(note, "Edit of transaction") {
+		t.Errorf("corrective movement: %d %q %v", change, note, err)
+	}
+	var lines int
+	if err := db.QueryRow(`SELECT COUNT(1) FROM transaction_items WHERE transaction_id = ?`, sale["id"]).Scan(&lines); err != nil || lines != 2 {
+		t.Errorf("lines after edit = %d (%v), want 2", lines, err)
+	}
+
+	if code, _ := call("manager", "PATCH", path, `{"amount":10}`); code != 400 {
+		t.Errorf("amount below what was paid: got %d, want 400", code)
+	}
+	if code, _ := call("manager", "PATCH", path, `{"items":[{"item_id":"i-1","quantity":50,"unit_price":15}]}`); code != 409 {
+		t.Errorf("selling more than in stock: got %d, want 409", code)
+	}
+	if stock("i-1") != 8 {
+		t.Errorf("a refused edit moved stock: %d", stock("i-1"))
+	}
+	_, history := call("viewer", "GET", "/api/transactions/"+toString(sale["id"])+"/edits", "")
+	edits, _ := history["edits"].([]interface{})
+	if len(edits) != 1 {
+		t.Fatalf("edits: %v", history)
+	}
+	if e := edits[0].(map[string]interface{}); e["old_amount"] != 45.0 || e["new_amount"] != 40.0 || len(e["old_items"].([]interface{})) != 1 {
+		t.Errorf("edit: %v", e)
+	}
+
+	// a purchase made smaller gives back units from the batch it filled
+	code, purchase := call("manager", "POST", "/api/collections/transactions/records", `{"type":"outflow","amount":40,"paid_amount":0,"due_amount":40,"contact_id":"s-1","items":[{"item_id":"i-2","quantity":5,"unit_price":8}]}`)
+	if code != 200 {
+		t.Fatalf("purchase: %d %v", code, purchase)
+	}
+	if code, out := call("admin", "PATCH", "/api/collections/transactions/records/"+toString(purchase["id"]), `{"items":[{"item_id":"i-2","quantity":2,"unit_price":8}]}`); code != 200 || out["amount"] != 16.0 {
+		t.Fatalf("purchase edit: %d %v", code, out)
+	}
+	var batched int
+	if err := db.QueryRow(`SELECT quantity FROM item_batches WHERE transaction_id = ?`, purchase["id"]).Scan(&batched); err != nil || batched != 2 || stock("i-2") != 6 {
+		t.Errorf("batch %d (%v), stock %d; want 2, 6", batched, err, stock("i-2"))
+	}
+
+	if code, _ := call("manager", "POST", "/api/transactions/"+toString(sale["id"])+"/void", ""); code != 200 {
+		t.Fatalf("void: %d", code)
+	}
+	if code, _ := call("manager", "PATCH", path, `{"amount":45}`); code != 409 {
+		t.Errorf("editing a voided sale: got %d, want 409", code)
+	}
+	if stock("i-1") != 10 || stock("i-2") != 7 {
+		t.Errorf("stock after void = %d, %d; want 10, 7", stock("i-1"), stock("i-2"))
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Stock is always counted in an item's base unit (its unit field: pcs, kg,
+// ...). An item can also be bought or sold in other units, each holding a
+// whole number of base units: carton = 24 pcs, dozen = 12 pcs. A sale or
+// purchase line, or a purchase order line, may name one of them as its
+// unit; the line is converted to base units before anything else sees it,
+// so a purchase in cartons and a sale in pieces move the same quantity.
+// The line keeps the unit and the quantity as entered (unit,
+// unit_quantity) for display.
+
+type ItemUnit struct {
+	Name   string `json:"name"`
+	Factor int    `json:"factor"`
+}
+
+func registerUnitRoutes(app *fiber.App) {
+	app.Get("/api/inventory_items/:id/units", requireAuth, handleGetItemUnits)
+	app.Put("/api/inventory_items/:id/units", requireAuth, requireRole("admin", "manager"), handlePutItemUnits)
+}
+
+// itemUnits returns the units itemID is traded in besides its base unit.
+func itemUnits(q *DB, itemID string) ([]ItemUnit, error) {
+	rows, err := q.Query(`SELECT name, factor FROM item_units WHERE item_id = ? ORDER BY factor, name`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	units := []ItemUnit{}
+	for rows.Next() {
+		var u ItemUnit
+		if err := rows.Scan(&u.Name, &u.Factor); err != nil {
+			return nil, err
+		}
+		units = append(units, u)
+	}
+	return units, rows.Err()
+}
+
+// unitFactor returns how many base units of itemID one unit holds. The
+// empty unit and the base unit itself hold one.
+func unitFactor(q queryer, itemID, unit string) (int, error) {
+	if unit == "" {
+		return 1, nil
+	}
+	var base string
+	var factor sql.NullInt64
+	err := q.QueryRow(`SELECT i.unit, u.factor FROM inventory_items i LEFT JOIN item_units u ON u.item_id = i.id AND u.name = ? WHERE i.id = ?`, unit, itemID).Scan(&base, &factor)
+	if err != nil {
+		return 0, err
+	}
+	if unit == base {
+		return 1, nil
+	}
+	if !factor.Valid {
+		return 0, errors.New("unknown unit " + unit + " for item " + itemID)
+	}
+	return int(factor.Int64), nil
+}
+
+// convertLineUnit rewrites a transaction line entered in another unit to
+// base units: quantity becomes base units, unit_price the price of one
+// base unit, and unit and unit_quantity keep what was entered. total_price
+// is set for every line, from the quantity and price as entered.
+func convertLineUnit(q queryer, line map[string]interface{}) error {
+	quantity, _ := line["quantity"].(float64)
+	unitPrice, _ := line["unit_price"].(float64)
+	line["total_price"] = (moneyOf(unitPrice) * money(quantity)).float()
+	unit := strings.TrimSpace(toString(line["unit"]))
+	factor, err := unitFactor(q, toString(line["item_id"]), unit)
+	if err != nil {
+		return err
+	}
+	if factor == 1 {
+		delete(line, "unit")
+		return nil
+	}
+	line["unit"] = unit
+	line["unit_quantity"] = int(quantity)
+	line["quantity"] = quantity * float64(factor)
+	line["unit_price"] = round2(unitPrice / float64(factor))
+	return nil
+}
+
+func handleGetItemUnits(c *fiber.Ctx) error {
+	id := c.Params("id")
+	var base string
+	if err := dbFor(c).QueryRow(`SELECT unit FROM inventory_items WHERE id = ? AND organization_id = ?`, id, currentOrgID(c)).Scan(&base); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	units, err := itemUnits(dbFor(c), id)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(fiber.Map{"unit": base, "units": units})
+}
+
+// handlePutItemUnits replaces the other units of an item:
+// {"units": [{"name": "carton", "factor": 24}]}.
+func handlePutItemUnits(c *fiber.Ctx) error {
+	id := c.Params("id")
+	orgID := currentOrgID(c)
+	var req struct {
+		Units []ItemUnit `json:"units"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	var base string
+	if err := dbFor(c).QueryRow(`SELECT unit FROM inventory_items WHERE id = ? AND organization_id = ?`, id, orgID).Scan(&base); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	seen := map[string]bool{base: true}
+	for i := range req.Units {
+		u := &req.Units[i]
+		u.Name = strings.TrimSpace(u.Name)
+		if u.Name == "" {
+			return c.Status(400).JSON(fiber.Map{"error": "every unit needs a name"})
+		}
+		if seen[u.Name] {
+			return c.Status(400).JSON(fiber.Map{"error": "unit " + u.Name + " is listed twice or is the base unit"})
+		}
+		seen[u.Name] = true
+		if u.Factor < 2 {
+			return c.Status(400).JSON(fiber.Map{"error": "factor of " + u.Name + " must be a whole number of " + base + " above 1"})
+		}
+	}
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`DELETE FROM item_units WHERE item_id = ?`, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for _, u := range req.Units {
+		if _, err := tx.Exec(`INSERT INTO item_units (id,organization_id,item_id,name,factor) VALUES (?,?,?,?,?)`, genID(), orgID, id, u.Name, u.Factor); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, "inventory_items", "update", id)
+	if req.Units == nil {
+		req.Units = []ItemUnit{}
+	}
+	return c.JSON(fiber.Map{"unit": base, "units": req.Units})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUnitsOfMeasure(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Supplier','','supplier','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,cost_price,organization_id) VALUES ('i-1','Soap','SOAP',10,25,18,'org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	quantity := func() int {
+		t.Helper()
+		var q int
+		if err := db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = 'i-1'`).Scan(&q); err != nil {
+			t.Fatal(err)
+		}
+		return q
+	}
+
+	for _, body := range []string{`{"units":[{"name":"carton","factor":1}]}`, `{"units":[{"name":"pcs","factor":6}]}`, `{"units":[{"name":"box","factor":6},{"name":"box","factor":12}]}`} {
+		if code, _ := call("PUT", "/api/inventory_items/i-1/units", body); code != 400 {
+			t.Errorf("units %s: got %d, want 400", body, code)
+		}
+	}
+	if code, out := call("PUT", "/api/inventory_items/i-1/units", `{"units":[{"name":"carton","factor":24},{"name":"dozen","factor":12}]}`); code != 200 {
+		t.Fatalf("set units: %d %v", code, out)
+	}
+	if code, item := call("GET", "/api/collections/inventory_items/records/i-1", ""); code != 200 || item["unit"] != "pcs" || len(item["units"].([]interface{})) != 2 {
+		t.Errorf("item: %d %v", code, item)
+	}
+
+	// 2 cartons bought, 1 dozen and 3 pieces sold, all in pieces
+	if code, out := call("POST", "/api/collections/transactions/records", `{"type":"outflow","amount":864,"paid_amount":864,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":2,"unit":"carton","unit_price":432}]}`); code != 200 {
+		t.Fatalf("purchase: %d %v", code, out)
+	}
+	if got := quantity(); got != 58 {
+		t.Errorf("after buying 2 cartons: %d, want 58", got)
+	}
+	var unit string
+	var qty, unitQty int
+	var price, total money
+	if err := db.QueryRow(`SELECT unit, unit_quantity, quantity, unit_price, total_price FROM transaction_items WHERE unit = 'carton'`).Scan(&unit, &unitQty, &qty, &price, &total); err != nil {
+		t.Fatal(err)
+	}
+	if unitQty != 2 || qty != 48 || price != moneyOf(18) || total != moneyOf(864) {
+		t.Errorf("carton line: %d %s = %d at %v, total %v", unitQty, unit, qty, price, total)
+	}
+	sale := `{"type":"inflow","amount":375,"paid_amount":375,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":1,"unit":"dozen","unit_price":300},{"item_id":"i-1","quantity":3,"unit_price":25}]}`
+	if code, out := call("POST", "/api/collections/transactions/records", sale); code != 200 {
+		t.Fatalf("sale: %d %v", code, out)
+	}
+	if got := quantity(); got != 43 {
+		t.Errorf("after selling 15 pieces: %d, want 43", got)
+	}
+	if code, _ := call("POST", "/api/collections/transactions/records", `{"type":"inflow","amount":1,"paid_amount":1,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":1,"unit":"pallet","unit_price":1}]}`); code != 400 {
+		t.Errorf("unknown unit: got %d, want 400", code)
+	}
+
+	// a purchase order in cartons is received in pieces
+	code, po := call("POST", "/api/purchase-orders", `{"supplier_id":"c-1","items":[{"item_id":"i-1","quantity":1,"unit":"carton"}]}`)
+	if code != 200 {
+		t.Fatalf("purchase order: %d %v", code, po)
+	}
+	if po["total"] != 432.0 {
+		t.Errorf("purchase order total %v, want 432", po["total"])
+	}
+	if code, out := call("POST", "/api/purchase-orders/"+po["id"].(string)+"/receive", `{"items":[{"item_id":"i-1","quantity":1,"unit":"dozen"}]}`); code != 200 {
+		t.Fatalf("receive: %d %v", code, out)
+	}
+	if got := quantity(); got != 55 {
+		t.Errorf("after receiving a dozen: %d, want 55", got)
+	}
+
+	if code, _ := call("PATCH", "/api/collections/inventory_items/records/i-1", `{"unit":"carton"}`); code != 400 {
+		t.Errorf("base unit clashing with another unit: got %d, want 400", code)
+	}
+}
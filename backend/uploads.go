@@ -0,0 +1,326 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Large attachments over flaky mobile connections are sent in chunks, in
+// the spirit of tus.io:
+//
+//	POST   /api/uploads       {"collection", "record_id", "filename", "size"}
+//	                          starts a session
+//	PATCH  /api/uploads/:id   body = next chunk, Upload-Offset header = bytes
+//	                          already sent; answers with the new offset
+//	GET    /api/uploads/:id   current offset, to resume after a dropped
+//	                          connection (also in the Upload-Offset header)
+//	DELETE /api/uploads/:id   abandons the upload
+//
+// Chunks must fit in one request body (4 MB by default). Partial files
+// live in data/partial-uploads until the last chunk arrives; the file is
+// then attached to the record exactly like a single multipart upload.
+// Sessions idle for a day are removed by the storage GC.
+//
+// UPLOAD_MAX_BYTES caps the size of one file (default 50 MB).
+//
+// Stored files are served back, to the organization that owns them, at
+//
+//	GET    /api/files/:collection/:id/:filename
+//
+// where the owner is that of the record (or, for a logo, the
+// organization) the file belongs to.
+
+var partialUploadsDir = filepath.Join("data", "partial-uploads")
+
+const uploadSessionTTL = 24 * time.Hour
+
+func registerUploadRoutes(app *fiber.App) {
+	r := app.Group("/api/uploads", requireAuth)
+	r.Post("/", handleCreateUploadSession)
+	r.Get("/:id", handleGetUploadSession)
+	r.Patch("/:id", handleUploadChunk)
+	r.Delete("/:id", handleDeleteUploadSession)
+	app.Get("/api/files/*", requireAuth, handleGetFile)
+}
+
+// handleGetFile serves a file under uploads/ if it belongs to the
+// caller's organization. Anything else, including paths that try to
+// leave uploads/, is answered 404 so it gives nothing away.
+func handleGetFile(c *fiber.Ctx) error {
+	rel, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	parts := strings.Split(rel, "/")
+	if len(parts) != 3 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.Contains(p, `\`) {
+			return c.Status(404).JSON(fiber.Map{"error": "not found"})
+		}
+	}
+	collection, id, orgID := parts[0], parts[1], currentOrgID(c)
+	owned := false
+	switch {
+	case collection == "organizations":
+		owned = id == orgID
+	case isTenantTable(collection):
+		owned = orgOwns(dbFor(c), collection, id, orgID)
+	}
+	if !owned {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	path := filepath.Join("uploads", collection, id, parts[2])
+	if _, err := os.Stat(path); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	return c.SendFile(path)
+}
+
+func maxUploadBytes() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("UPLOAD_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return 50 << 20
+}
+
+func readUpload(file *multipart.FileHeader) ([]byte, error) {
+	in, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	return io.ReadAll(in)
+}
+
+// attachRecordFile stores data as a record's file, once it has passed the
+// virus scanner, and points the record's image columns at it, returning
+// the file's URL.
+func attachRecordFile(q *DB, orgID, collection, id, filename string, data []byte) (string, error) {
+	uploadsDir := filepath.Join("uploads", collection, id)
+	if err := scanUpload(q, orgID, filepath.ToSlash(uploadsDir), filename, data); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(uploadsDir, 0o755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(uploadsDir, filename), sanitizeImage(data), 0o644); err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("/api/files/%s/%s/%s", collection, id, filename)
+	// update record to store file info
+	var err error
+	if collection == "inventory_items" {
+		_, err = q.Exec("UPDATE inventory_items SET image_filename = ?, image_url = ? WHERE id = ?", filename, url, id)
+	} else if collection == "transactions" {
+		_, err = q.Exec("UPDATE transactions SET image_filename = ?, image_url = ? WHERE id = ?", filename, url, id)
+	}
+	if err != nil {
+		return "", err
+	}
+	return url, nil
+}
+
+type uploadSession struct {
+	ID         string `json:"id"`
+	Collection string `json:"collection"`
+	RecordID   string `json:"record_id"`
+	Filename   string `json:"filename"`
+	Size       int64  `json:"size"`
+	Offset     int64  `json:"offset"`
+	Status     string `json:"status"`
+	URL        string `json:"url,omitempty"`
+}
+
+func loadUploadSession(q *DB, orgID, id string) (uploadSession, error) {
+	s := uploadSession{ID: id}
+	var url sql.NullString
+	err := q.QueryRow(`SELECT collection, record_id, filename, size, received, status, url FROM upload_sessions WHERE id = ? AND organization_id = ?`, id, orgID).
+		Scan(&s.Collection, &s.RecordID, &s.Filename, &s.Size, &s.Offset, &s.Status, &url)
+	s.URL = url.String
+	return s, err
+}
+
+func (s uploadSession) partialPath() string {
+	return filepath.Join(partialUploadsDir, s.ID)
+}
+
+func uploadSessionResponse(c *fiber.Ctx, status int, s uploadSession) error {
+	c.Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(s.Size, 10))
+	return c.Status(status).JSON(s)
+}
+
+func handleCreateUploadSession(c *fiber.Ctx) error {
+	var req struct {
+		Collection string `json:"collection"`
+		RecordID   string `json:"record_id"`
+		Filename   string `json:"filename"`
+		Size       int64  `json:"size"`
+	}
+	if err := json.Unmarshal(c.Body(), &req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+	}
+	orgID := currentOrgID(c)
+	if !isTenantTable(req.Collection) || !orgOwns(dbFor(c), req.Collection, req.RecordID, orgID) {
+		return c.Status(404).JSON(fiber.Map{"error": "record not found"})
+	}
+	if !roleAllows(currentRole(c), req.Collection, fiber.MethodPost) {
+		return c.Status(403).JSON(fiber.Map{"error": "your role cannot upload to " + req.Collection})
+	}
+	filename := filepath.Base(req.Filename)
+	if filename == "." || filename == "/" || filename == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "filename required"})
+	}
+	if req.Size <= 0 || req.Size > maxUploadBytes() {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("size must be between 1 and %d bytes", maxUploadBytes())})
+	}
+	if err := os.MkdirAll(partialUploadsDir, 0o755); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	s := uploadSession{ID: genID(), Collection: req.Collection, RecordID: req.RecordID, Filename: filename, Size: req.Size, Status: "uploading"}
+	if err := os.WriteFile(s.partialPath(), nil, 0o644); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	now := time.Now().Format(time.RFC3339)
+	if _, err := dbFor(c).Exec(`INSERT INTO upload_sessions (id,organization_id,user_id,collection,record_id,filename,size,received,status,created_at,updated_at) VALUES (?,?,?,?,?,?,?,0,?,?,?)`,
+		s.ID, orgID, currentUserID(c), s.Collection, s.RecordID, s.Filename, s.Size, s.Status, now, now); err != nil {
+		os.Remove(s.partialPath())
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Location("/api/uploads/" + s.ID)
+	return uploadSessionResponse(c, 201, s)
+}
+
+func handleGetUploadSession(c *fiber.Ctx) error {
+	s, err := loadUploadSession(dbFor(c), currentOrgID(c), c.Params("id"))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "upload not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return uploadSessionResponse(c, 200, s)
+}
+
+// handleUploadChunk appends the request body at Upload-Offset and, once
+// the last byte is in, attaches the file to its record.
+func handleUploadChunk(c *fiber.Ctx) error {
+	orgID := currentOrgID(c)
+	s, err := loadUploadSession(dbFor(c), orgID, c.Params("id"))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "upload not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if s.Status != "uploading" {
+		return c.Status(409).JSON(fiber.Map{"error": "upload is already " + s.Status})
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Upload-Offset header required"})
+	}
+	if offset != s.Offset {
+		// the client lost track, e.g. a chunk landed but the response did not
+		c.Set("Upload-Offset", strconv.FormatInt(s.Offset, 10))
+		return c.Status(409).JSON(fiber.Map{"error": "offset mismatch", "offset": s.Offset})
+	}
+	chunk := c.Body()
+	if s.Offset+int64(len(chunk)) > s.Size {
+		return c.Status(400).JSON(fiber.Map{"error": "chunk runs past the declared size"})
+	}
+	f, err := os.OpenFile(s.partialPath(), os.O_WRONLY, 0o644)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	_, err = f.WriteAt(chunk, s.Offset)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	s.Offset += int64(len(chunk))
+	now := time.Now().Format(time.RFC3339)
+	if s.Offset < s.Size {
+		if _, err := dbFor(c).Exec(`UPDATE upload_sessions SET received = ?, updated_at = ? WHERE id = ?`, s.Offset, now, s.ID); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		return uploadSessionResponse(c, 200, s)
+	}
+
+	data, err := os.ReadFile(s.partialPath())
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	url, err := attachRecordFile(dbFor(c), orgID, s.Collection, s.RecordID, s.Filename, data)
+	os.Remove(s.partialPath())
+	if err != nil {
+		s.Status = "failed"
+		if _, dbErr := dbFor(c).Exec(`UPDATE upload_sessions SET received = ?, status = ?, updated_at = ? WHERE id = ?`, s.Offset, s.Status, now, s.ID); dbErr != nil {
+			log.Printf("uploads: %s: %v", s.ID, dbErr)
+		}
+		return uploadErrorResponse(c, err)
+	}
+	s.Status, s.URL = "complete", url
+	if _, err := dbFor(c).Exec(`UPDATE upload_sessions SET received = ?, status = ?, url = ?, updated_at = ? WHERE id = ?`, s.Offset, s.Status, s.URL, now, s.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	publishRecord(orgID, s.Collection, "update", s.RecordID)
+	return uploadSessionResponse(c, 200, s)
+}
+
+func handleDeleteUploadSession(c *fiber.Ctx) error {
+	s, err := loadUploadSession(dbFor(c), currentOrgID(c), c.Params("id"))
+	if err == sql.ErrNoRows {
+		return c.Status(404).JSON(fiber.Map{"error": "upload not found"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	os.Remove(s.partialPath())
+	if _, err := dbFor(c).Exec(`DELETE FROM upload_sessions WHERE id = ?`, s.ID); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.SendStatus(204)
+}
+
+// removeStaleUploadSessions drops sessions not touched for a day, along
+// with their partial files, and returns how many it removed.
+func removeStaleUploadSessions() int {
+	cutoff := time.Now().Add(-uploadSessionTTL).Format(time.RFC3339)
+	rows, err := db.Query(`SELECT id FROM upload_sessions WHERE updated_at < ?`, cutoff)
+	if err != nil {
+		log.Printf("upload sessions: %v", err)
+		return 0
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		os.Remove(filepath.Join(partialUploadsDir, id))
+		if _, err := db.Exec(`DELETE FROM upload_sessions WHERE id = ?`, id); err != nil {
+			log.Printf("uploads: %s: %v", id, err)
+		}
+	}
+	return len(ids)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestChunkedUploadResumesAndAssembles(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,'org-1')`); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	app := newApp()
+	token := testToken(t, "admin")
+	send := func(method, path, offset string, body []byte) (int, uploadSession) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if offset != "" {
+			req.Header.Set("Upload-Offset", offset)
+		} else {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s uploadSession
+		_ = json.NewDecoder(resp.Body).Decode(&s)
+		return resp.StatusCode, s
+	}
+
+	content := []byte("a receipt that arrives in three pieces")
+	code, s := send("POST", "/api/uploads", "", []byte(`{"collection":"inventory_items","record_id":"i-1","filename":"../receipt.txt","size":38}`))
+	if code != 201 || s.Filename != "receipt.txt" {
+		t.Fatalf("create: %d %+v", code, s)
+	}
+	path := "/api/uploads/" + s.ID
+
+	if code, s = send("PATCH", path, "0", content[:10]); code != 200 || s.Offset != 10 {
+		t.Fatalf("first chunk: %d %+v", code, s)
+	}
+	// a retried chunk the server already has is refused with the real offset
+	if code, _ = send("PATCH", path, "0", content[:10]); code != 409 {
+		t.Fatalf("stale offset: got %d, want 409", code)
+	}
+	if code, s = send("GET", path, "", nil); code != 200 || s.Offset != 10 {
+		t.Fatalf("resume: %d %+v", code, s)
+	}
+	if code, s = send("PATCH", path, "10", content[10:]); code != 200 || s.Status != "complete" || s.Offset != 38 {
+		t.Fatalf("last chunk: %d %+v", code, s)
+	}
+	got, err := os.ReadFile(filepath.Join("uploads", "inventory_items", "i-1", "receipt.txt"))
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("assembled file = %q, %v", got, err)
+	}
+	var url string
+	if err := db.QueryRow(`SELECT image_url FROM inventory_items WHERE id = 'i-1'`).Scan(&url); err != nil || !strings.HasSuffix(url, "/receipt.txt") {
+		t.Errorf("image_url = %q, %v", url, err)
+	}
+	if _, err := os.Stat(filepath.Join(partialUploadsDir, s.ID)); !os.IsNotExist(err) {
+		t.Errorf("partial file left behind: %v", err)
+	}
+
+	code, s = send("POST", "/api/uploads", "", []byte(`{"collection":"inventory_items","record_id":"i-1","filename":"x.txt","size":4}`))
+	if code != 201 {
+		t.Fatalf("create: %d", code)
+	}
+	if code, _ = send("PATCH", "/api/uploads/"+s.ID, "0", []byte("too long")); code != 400 {
+		t.Errorf("oversized chunk: got %d, want 400", code)
+	}
+	if code, _ = send("POST", "/api/uploads", "", []byte(`{"collection":"inventory_items","record_id":"nope","filename":"x.txt","size":4}`)); code != 404 {
+		t.Errorf("unknown record: got %d, want 404", code)
+	}
+}
+
+// Stored files are served only to a signed-in member of the organization
+// that owns the record they belong to, and never from outside uploads/.
+func TestFilesServedToOwningOrganization(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active'), ('org-2','Theirs','','active')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,'org-1'), ('i-2','Pad','PAD',10,30,'org-2')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for path, content := range map[string]string{
+		"uploads/inventory_items/i-1/pen.jpg":  "mine",
+		"uploads/inventory_items/i-2/pad.jpg":  "theirs",
+		"uploads/organizations/org-1/logo.png": "my logo",
+		"uploads/organizations/org-2/logo.png": "their logo",
+		"secret.txt":                           "outside uploads",
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := newApp()
+	token := testToken(t, "viewer")
+	get := func(path string, auth bool) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		if auth {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if code, _ := get("/api/files/inventory_items/i-1/pen.jpg", false); code != 401 {
+		t.Errorf("without a token: got %d, want 401", code)
+	}
+	for path, want := range map[string]string{
+		"/api/files/inventory_items/i-1/pen.jpg":  "mine",
+		"/api/files/organizations/org-1/logo.png": "my logo",
+	} {
+		if code, body := get(path, true); code != 200 || body != want {
+			t.Errorf("%s: %d %q", path, code, body)
+		}
+	}
+	for _, path := range []string{
+		"/api/files/inventory_items/i-2/pad.jpg",
+		"/api/files/organizations/org-2/logo.png",
+		"/api/files/inventory_items/i-1/missing.jpg",
+		"/api/files/inventory_items/nope/pen.jpg",
+		"/api/files/users/u-1/photo.jpg",
+		"/api/files/inventory_items/i-1/..%2F..%2F..%2Fsecret.txt",
+		"/api/files/organizations/org-1/%2E%2E",
+		"/api/files/organizations/org-1/..%5C..%5C..%5Csecret.txt",
+		"/api/files/secret.txt",
+	} {
+		if code, _ := get(path, true); code != 404 {
+			t.Errorf("%s: got %d, want 404", path, code)
+		}
+	}
+}
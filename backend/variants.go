@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// A product sold in several sizes or colours is a parent item with a
+// variant per combination. Each variant is an inventory item of its own,
+// with its own SKU, price and stock, a parent_id and the options telling
+// it apart (variant_options, e.g. {"size":"L","color":"red"}); it shares
+// its parent's name, which renaming the parent carries over. Stock is
+// sold and bought per variant, never on a parent that has variants.
+//
+// The item list returns variants as items like any other; with
+// ?group=variants it lists parents and standalone items only, each parent
+// carrying its variants, so the frontend can show one row per product.
+
+// optionsJSON is the stored form of variant options, NULL when there are
+// none.
+func optionsJSON(options map[string]string) interface{} {
+	if len(options) == 0 {
+		return nil
+	}
+	b, _ := json.Marshal(options)
+	return string(b)
+}
+
+// checkVariantParent reports why parentID cannot take variants in orgID.
+func checkVariantParent(q queryer, orgID, parentID string) error {
+	var grandparent string
+	if err := q.QueryRow(`SELECT COALESCE(parent_id, '') FROM inventory_items WHERE id = ? AND organization_id = ?`, parentID, orgID).Scan(&grandparent); err != nil {
+		return errors.New("unknown parent item " + parentID)
+	}
+	if grandparent != "" {
+		return errors.New("a variant cannot have variants of its own")
+	}
+	return nil
+}
+
+// hasVariants reports whether itemID is a parent with variants.
+func hasVariants(q queryer, itemID string) (bool, error) {
+	var n int
+	err := q.QueryRow(`SELECT COUNT(1) FROM inventory_items WHERE parent_id = ?`, itemID).Scan(&n)
+	return n > 0, err
+}
+
+// renameVariants gives the variants of parentID its new name and
+// refreshes the transactions they appear on.
+func renameVariants(tx *Tx, parentID, name string) error {
+	rows, err := tx.Query(`SELECT id FROM inventory_items WHERE parent_id = ?`, parentID)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE inventory_items SET name = ? WHERE parent_id = ?`, name, parentID); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := refreshItemTransactions(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeVariantOptions turns the stored variant_options of listed items
+// into an options object.
+func decodeVariantOptions(items []map[string]interface{}) {
+	for _, it := range items {
+		if raw := toString(it["variant_options"]); raw != "" {
+			var options map[string]interface{}
+			if json.Unmarshal([]byte(raw), &options) == nil {
+				it["options"] = options
+			}
+		}
+		delete(it, "variant_options")
+	}
+}
+
+// attachVariants adds the variants of each listed parent as its variants,
+// with one query for the whole chunk.
+func attachVariants(q *DB, items []map[string]interface{}) error {
+	if len(items) == 0 {
+		return nil
+	}
+	ids := make([]interface{}, len(items))
+	for i, it := range items {
+		ids[i] = toString(it["id"])
+	}
+	rows, err := q.Query(`SELECT id,name,sku,quantity,unit,unit_price,reorder_level,cost_price,image_url,parent_id,variant_options FROM inventory_items WHERE parent_id IN (?`+strings.Repeat(",?", len(ids)-1)+`) ORDER BY sku, id`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	variants, err := rowsToMaps(rows)
+	if err != nil {
+		return err
+	}
+	decodeVariantOptions(variants)
+	byParent := map[string][]interface{}{}
+	for _, v := range variants {
+		parent := toString(v["parent_id"])
+		byParent[parent] = append(byParent[parent], v)
+	}
+	for _, it := range items {
+		if vs := byParent[toString(it["id"])]; vs != nil {
+			it["variants"] = vs
+		}
+	}
+	return nil
+}
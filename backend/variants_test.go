@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProductVariants(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`); err != nil {
+		t.Fatal(err)
+	}
+	app := newApp()
+	call := func(method, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+testToken(t, "admin"))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	_, shirt := call("POST", "/api/collections/inventory_items/records", `{"name":"Polo Shirt","sku":"POLO"}`)
+	parent := shirt["id"].(string)
+	_, mug := call("POST", "/api/collections/inventory_items/records", `{"name":"Mug","sku":"MUG","quantity":3,"unit_price":150}`)
+	var variants []string
+	for _, body := range []string{
+		`{"parent_id":"` + parent + `","sku":"POLO-M-RED","quantity":5,"unit_price":450,"options":{"size":"M","color":"red"}}`,
+		`{"parent_id":"` + parent + `","sku":"POLO-L-RED","quantity":2,"unit_price":480,"options":{"size":"L","color":"red"}}`,
+	} {
+		code, out := call("POST", "/api/collections/inventory_items/records", body)
+		if code != 200 {
+			t.Fatalf("create variant: %d %v", code, out)
+		}
+		variants = append(variants, out["id"].(string))
+	}
+	for _, body := range []string{
+		`{"parent_id":"` + variants[0] + `","sku":"X","options":{"size":"S"}}`,
+		`{"parent_id":"missing","sku":"X","options":{"size":"S"}}`,
+		`{"parent_id":"` + parent + `","options":{"size":"S"}}`,
+		`{"name":"Cap","options":{"size":"S"}}`,
+	} {
+		if code, _ := call("POST", "/api/collections/inventory_items/records", body); code != 400 {
+			t.Errorf("%s: got %d, want 400", body, code)
+		}
+	}
+
+	// one row per product, variants under the parent
+	_, list := call("GET", "/api/collections/inventory_items/records?group=variants&sort=name", "")
+	items := list["items"].([]interface{})
+	if len(items) != 2 || list["totalItems"] != 2.0 {
+		t.Fatalf("grouped list: %v", list)
+	}
+	if m := items[0].(map[string]interface{}); m["id"] != mug["id"] || m["variants"] != nil {
+		t.Errorf("standalone item: %v", m)
+	}
+	grouped := items[1].(map[string]interface{})["variants"].([]interface{})
+	if len(grouped) != 2 || grouped[0].(map[string]interface{})["sku"] != "POLO-L-RED" || grouped[0].(map[string]interface{})["options"].(map[string]interface{})["size"] != "L" {
+		t.Errorf("variants: %v", grouped)
+	}
+	if _, flat := call("GET", "/api/collections/inventory_items/records", ""); flat["totalItems"] != 4.0 {
+		t.Errorf("flat list has %v items, want 4", flat["totalItems"])
+	}
+	if _, got := call("GET", "/api/collections/inventory_items/records/"+parent, ""); len(got["variants"].([]interface{})) != 2 {
+		t.Errorf("parent record: %v", got)
+	}
+
+	// variants are sold on their own stock; the parent is not sold
+	sale := `{"type":"inflow","amount":450,"paid_amount":450,"due_amount":0,"contact_id":"c-1","items":[{"item_id":"%s","quantity":1,"unit_price":450}]}`
+	if code, _ := call("POST", "/api/collections/transactions/records", strings.Replace(sale, "%s", parent, 1)); code != 400 {
+		t.Errorf("selling the parent: got %d, want 400", code)
+	}
+	if code, out := call("POST", "/api/collections/transactions/records", strings.Replace(sale, "%s", variants[0], 1)); code != 200 {
+		t.Fatalf("selling a variant: %d %v", code, out)
+	}
+	if _, v := call("GET", "/api/collections/inventory_items/records/"+variants[0], ""); v["quantity"] != 4.0 || v["name"] != "Polo Shirt" || v["parent_id"] != parent {
+		t.Errorf("variant after sale: %v", v)
+	}
+
+	// renaming the parent renames its variants
+	if code, _ := call("PATCH", "/api/collections/inventory_items/records/"+variants[0], `{"name":"Other"}`); code != 400 {
+		t.Errorf("renaming a variant: got %d, want 400", code)
+	}
+	if code, _ := call("PATCH", "/api/collections/inventory_items/records/"+parent, `{"name":"Pique Polo"}`); code != 200 {
+		t.Fatalf("rename parent: %d", code)
+	}
+	if _, v := call("GET", "/api/collections/inventory_items/records/"+variants[1], ""); v["name"] != "Pique Polo" {
+		t.Errorf("variant name after rename: %v", v["name"])
+	}
+}
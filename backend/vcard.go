@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// vCard (.vcf) import/export for contacts, so owners can move numbers
+// between their phone's address book and bizcalc. Only the name and first
+// phone number of each card are used.
+
+func registerVCardRoutes(app *fiber.App) {
+	app.Get("/api/contacts/export.vcf", requireAuth, handleExportVCard)
+	app.Post("/api/contacts/import-vcf", requireAuth, handleImportVCard)
+}
+
+func handleExportVCard(c *fiber.Ctx) error {
+	query := `SELECT name, phone, type FROM contacts WHERE organization_id = ?`
+	args := []interface{}{currentOrgID(c)}
+	if typ := c.Query("type"); typ != "" {
+		query += " AND type = ?"
+		args = append(args, typ)
+	}
+	query += " ORDER BY name"
+	rows, err := dbFor(c).Query(query, args...)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	c.Set(fiber.HeaderContentType, "text/vcard; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="contacts.vcf"`)
+	// cards are written out as they are read, like streamed JSON lists
+	done := requestDone(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer done()
+		defer rows.Close()
+		for n := 1; rows.Next(); n++ {
+			var name, phone, typ string
+			if err := rows.Scan(&name, &phone, &typ); err != nil {
+				return
+			}
+			w.WriteString("BEGIN:VCARD\r\nVERSION:3.0\r\n")
+			fmt.Fprintf(w, "FN:%s\r\n", escapeVCard(name))
+			fmt.Fprintf(w, "N:%s;;;;\r\n", escapeVCard(name))
+			fmt.Fprintf(w, "TEL;TYPE=CELL:%s\r\n", escapeVCard(phone))
+			fmt.Fprintf(w, "CATEGORIES:%s\r\n", escapeVCard(typ))
+			w.WriteString("END:VCARD\r\n")
+			if n%streamChunkRows == 0 && w.Flush() != nil {
+				return
+			}
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// handleImportVCard creates a contact for every card with a name and phone
+// number. Cards whose number already exists are skipped. The .vcf can be
+// sent as multipart field "file" or as the raw request body; ?type= sets
+// the contact type (default customer).
+func handleImportVCard(c *fiber.Ctx) error {
+	var r io.Reader
+	if file, err := c.FormFile("file"); err == nil {
+		f, err := file.Open()
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		defer f.Close()
+		r = f
+	} else {
+		r = strings.NewReader(string(c.Body()))
+	}
+	contactType := c.Query("type", "customer")
+	if contactType != "customer" && contactType != "supplier" {
+		return c.Status(400).JSON(fiber.Map{"error": "type must be customer or supplier"})
+	}
+	cards, err := parseVCards(r)
+	if err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	orgID := currentOrgID(c)
+	existing := map[string]bool{}
+	rows, err := dbFor(c).Query(`SELECT COALESCE(phone, '') FROM contacts WHERE organization_id = ?`, orgID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	for rows.Next() {
+		var phone string
+		if err := rows.Scan(&phone); err != nil {
+			rows.Close()
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		existing[normalizePhone(phone)] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	created, skipped := []fiber.Map{}, []fiber.Map{}
+	for _, card := range cards {
+		if card.name == "" || card.phone == "" {
+			skipped = append(skipped, fiber.Map{"name": card.name, "phone": card.phone, "reason": "missing name or phone"})
+			continue
+		}
+		key := normalizePhone(card.phone)
+		if existing[key] {
+			skipped = append(skipped, fiber.Map{"name": card.name, "phone": card.phone, "reason": "phone already exists"})
+			continue
+		}
+		id := genID()
+		if _, err := dbFor(c).Exec(`INSERT INTO contacts (id,name,phone,type,organization_id,created_at) VALUES (?,?,?,?,?,?)`, id, card.name, card.phone, contactType, orgID, time.Now().Format(time.RFC3339)); err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		}
+		publishRecord(orgID, "contacts", "create", id)
+		existing[key] = true
+		created = append(created, fiber.Map{"id": id, "name": card.name, "phone": card.phone})
+	}
+	return c.JSON(fiber.Map{"created": created, "skipped": skipped})
+}
+
+type vCard struct {
+	name  string
+	phone string
+}
+
+// parseVCards reads the FN/N and first TEL of each card, unfolding
+// continuation lines and ignoring property parameters and group prefixes.
+func parseVCards(r io.Reader) ([]vCard, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	var cards []vCard
+	var cur *vCard
+	var structuredName string
+	for _, line := range lines {
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		prop := strings.ToUpper(line[:colon])
+		value := unescapeVCard(line[colon+1:])
+		if i := strings.Index(prop, ";"); i >= 0 {
+			prop = prop[:i]
+		}
+		if i := strings.LastIndex(prop, "."); i >= 0 {
+			prop = prop[i+1:]
+		}
+		switch prop {
+		case "BEGIN":
+			cur = &vCard{}
+			structuredName = ""
+		case "END":
+			if cur != nil {
+				if cur.name == "" {
+					cur.name = structuredName
+				}
+				cards = append(cards, *cur)
+				cur = nil
+			}
+		case "FN":
+			if cur != nil {
+				cur.name = strings.TrimSpace(value)
+			}
+		case "N":
+			// N is family;given;additional;prefix;suffix
+			parts := strings.Split(value, ";")
+			if len(parts) > 1 {
+				structuredName = strings.TrimSpace(parts[1] + " " + parts[0])
+			} else {
+				structuredName = strings.TrimSpace(value)
+			}
+		case "TEL":
+			if cur != nil && cur.phone == "" {
+				cur.phone = strings.TrimPrefix(strings.TrimSpace(value), "tel:")
+			}
+		}
+	}
+	return cards, nil
+}
+
+func escapeVCard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(s)
+}
+
+func unescapeVCard(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\,`, ",", `\;`, ";", `\n`, "\n", `\N`, "\n").Replace(s)
+}
+
+// normalizePhone keeps only digits and a leading +, so "+880 1711-000000"
+// and "+8801711000000" compare equal.
+func normalizePhone(phone string) string {
+	var b strings.Builder
+	for i, r := range strings.TrimSpace(phone) {
+		if (r >= '0' && r <= '9') || (r == '+' && i == 0) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
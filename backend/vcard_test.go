@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Contacts exported to a .vcf come back unchanged when the file is
+// imported, and a phone's own export is read for names and numbers.
+func TestVCardRoundTrip(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Khan, Rahim; Jr','+880 1711-000000','customer','org-1'),('c-2','Bina','01811000000','customer','org-1'),('s-1','Karim Traders','01911000000','supplier','org-1')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := newApp()
+	token := testToken(t, "manager")
+	send := func(method, path, contentType string, body io.Reader) (int, []byte) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, body)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, out
+	}
+	imported := func(out []byte) (created, skipped map[string]string) {
+		t.Helper()
+		var res struct{ Created, Skipped []map[string]string }
+		if err := json.Unmarshal(out, &res); err != nil {
+			t.Fatalf("import response %s: %v", out, err)
+		}
+		created, skipped = map[string]string{}, map[string]string{}
+		for _, c := range res.Created {
+			created[c["name"]] = c["phone"]
+		}
+		for _, s := range res.Skipped {
+			skipped[s["name"]] = s["reason"]
+		}
+		return created, skipped
+	}
+
+	code, vcf := send("GET", "/api/contacts/export.vcf?type=customer", "", nil)
+	if code != 200 || strings.Count(string(vcf), "BEGIN:VCARD") != 2 || !strings.Contains(string(vcf), `FN:Khan\, Rahim\; Jr`) {
+		t.Fatalf("export: %d\n%s", code, vcf)
+	}
+	cards, err := parseVCards(bytes.NewReader(vcf))
+	if err != nil || len(cards) != 2 || cards[1] != (vCard{name: "Khan, Rahim; Jr", phone: "+880 1711-000000"}) {
+		t.Errorf("exported cards: %v %+v", err, cards)
+	}
+
+	// imported again, every card is a number we already have
+	code, out := send("POST", "/api/contacts/import-vcf", "text/vcard", bytes.NewReader(vcf))
+	if created, skipped := imported(out); code != 200 || len(created) != 0 || skipped["Bina"] != "phone already exists" {
+		t.Errorf("re-import: %d %s", code, out)
+	}
+	// into an empty book the export restores the same contacts
+	if _, err := db.Exec(`DELETE FROM contacts WHERE type = 'customer'`); err != nil {
+		t.Fatal(err)
+	}
+	code, out = send("POST", "/api/contacts/import-vcf", "text/vcard", bytes.NewReader(vcf))
+	if created, _ := imported(out); code != 200 || len(created) != 2 || created["Khan, Rahim; Jr"] != "+880 1711-000000" || created["Bina"] != "01811000000" {
+		t.Errorf("restore: %d %s", code, out)
+	}
+
+	// a phone's export: folded lines, grouped properties, N without FN
+	phone := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:Hossain;Nadia;;;\r\nitem1.TEL;type=CELL:+8801511\r\n 000000\r\nTEL:0000\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nFN:No Number\r\nEND:VCARD\r\n" +
+		"BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Karim again\r\nTEL:019-1100-0000\r\nEND:VCARD\r\n"
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("file", "phone.vcf")
+	fw.Write([]byte(phone))
+	mw.Close()
+	code, out = send("POST", "/api/contacts/import-vcf?type=supplier", mw.FormDataContentType(), &body)
+	created, skipped := imported(out)
+	if code != 200 || len(created) != 1 || created["Nadia Hossain"] != "+8801511000000" {
+		t.Errorf("phone import created: %d %s", code, out)
+	}
+	if skipped["No Number"] != "missing name or phone" || skipped["Karim again"] != "phone already exists" {
+		t.Errorf("phone import skipped: %v", skipped)
+	}
+	var typ string
+	if err := db.QueryRow(`SELECT type FROM contacts WHERE name = 'Nadia Hossain'`).Scan(&typ); err != nil || typ != "supplier" {
+		t.Errorf("imported contact type = %q (%v), want supplier", typ, err)
+	}
+	if code, _ := send("POST", "/api/contacts/import-vcf?type=staff", "text/vcard", strings.NewReader(phone)); code != 400 {
+		t.Errorf("unknown type: got %d, want 400", code)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// A transaction entered by mistake is voided rather than deleted: it is
+// kept, with who voided it, when and why, and its effects are reversed
+// the way a bulk void reverses them (bulk.go): the stock it moved, its
+// batches, account movements and consigned units. Reports leave voided
+// transactions out. Cashiers may void the day's sales; anything else is
+// for managers.
+//
+//	POST /api/transactions/:id/void    {"reason": "rang up twice"}
+
+func registerVoidRoutes(app *fiber.App) {
+	app.Post("/api/transactions/:id/void", requireAuth, requireRole("admin", "manager", "cashier"), handleVoidTransaction)
+}
+
+func handleVoidTransaction(c *fiber.Ctx) error {
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), &req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "invalid json"})
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	id, orgID, userID := c.Params("id"), currentOrgID(c), currentUserID(c)
+	tx, err := dbFor(c).Begin()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	defer tx.Rollback()
+	records, err := planBulk(tx, orgID, "transactions", "void", "id = ?", []interface{}{id})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if len(records) == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "not found"})
+	}
+	r := records[0]
+	if currentRole(c) == "cashier" {
+		created, _ := parseTime(toString(r.Record["created_at"]))
+		if toString(r.Record["type"]) != "inflow" || created.Local().Format("2006-01-02") != time.Now().Format("2006-01-02") {
+			return c.Status(403).JSON(fiber.Map{"error": "cashiers can only void today's sales"})
+		}
+	}
+	if r.Skipped != "" {
+		return c.Status(409).JSON(fiber.Map{"error": "cannot void: " + r.Skipped})
+	}
+	r.note = "Void of transaction " + id
+	if req.Reason != "" {
+		r.note += ": " + req.Reason
+	}
+	if status, err := applyBulk(tx, "transactions", "void", r); err != nil {
+		return c.Status(status).JSON(fiber.Map{"error": err.Error()})
+	}
+	if _, err := tx.Exec(`UPDATE transactions SET voided_by = ?, void_reason = NULLIF(?, '') WHERE id = ?`, userID, req.Reason, id); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	if err := tx.Commit(); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+	checkVoidSpike(dbFor(c), orgID, userID)
+	publishRecord(orgID, "transactions", "update", id)
+	for _, ch := range r.Stock {
+		publishRecord(orgID, "inventory_items", "update", ch.ItemID)
+	}
+	t, err := Transactions(dbFor(c)).Get(c.UserContext(), orgID, id)
+	if err != nil {
+		return recordError(c, err)
+	}
+	return c.JSON(fiber.Map{"transaction": t, "stock": r.Stock, "accounts": r.Accounts})
+}
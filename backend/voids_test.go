@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVoidTransaction(t *testing.T) {
+	db = openTestDB(t)
+	if err := migrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []string{
+		`INSERT INTO organizations (id,name,owner_id,status) VALUES ('org-1','Mine','','active')`,
+		`INSERT INTO contacts (id,name,phone,type,organization_id) VALUES ('c-1','Walk-in','','customer','org-1')`,
+		`INSERT INTO inventory_items (id,name,sku,quantity,unit_price,organization_id) VALUES ('i-1','Pen','PEN',10,15,'org-1')`,
+		`INSERT INTO cash_accounts (id,name,kind,opening_balance,active,organization_id) VALUES ('a-1','Till','cash',0,1,'org-1')`,
+		`INSERT INTO transactions (id,type,amount,paid_amount,due_amount,contact_id,organization_id,created_at) VALUES ('old','inflow',1500,1500,0,'c-1','org-1','2024-01-01T10:00:00Z')`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seedAllPaymentMethods()
+	app := newApp()
+	call := func(role, path, body string) (int, map[string]interface{}) {
+		t.Helper()
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+testToken(t, role))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]interface{}
+		_ = json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	code, sale := call("cashier", "/api/collections/transactions/records", `{"type":"inflow","amount":45,"contact_id":"c-1","items":[{"item_id":"i-1","quantity":3,"unit_price":15}],"payments":[{"method":"cash","amount":45,"account_id":"a-1"}]}`)
+	if code != 200 {
+		t.Fatalf("sale: %d %v", code, sale)
+	}
+	path := "/api/transactions/" + toString(sale["id"]) + "/void"
+	if code, _ := call("viewer", path, ""); code != 403 {
+		t.Errorf("viewer voiding: got %d, want 403", code)
+	}
+	code, out := call("cashier", path, `{"reason":"rang up twice"}`)
+	if code != 200 {
+		t.Fatalf("void: %d %v", code, out)
+	}
+	if tr := out["transaction"].(map[string]interface{}); tr["voided_at"] == "" || tr["void_reason"] != "rang up twice" || tr["voided_by"] == nil {
+		t.Errorf("voided transaction: %v", tr)
+	}
+	var quantity int
+	var note string
+	if err := db.QueryRow(`SELECT quantity FROM inventory_items WHERE id = 'i-1'`).Scan(&quantity); err != nil || quantity != 10 {
+		t.Errorf("stock after void = %d (%v), want 10", quantity, err)
+	}
+	if err := db.QueryRow(`SELECT notes FROM inventory_transactions WHERE item_id = 'i-1' ORDER BY rowid DESC LIMIT 1`).Scan(&note); err != nil || !strings.Contains(note, "rang up twice") {
+		t.Errorf("stock movement note: %q %v", note, err)
+	}
+	if balance, err := accountBalance(db, "org-1", "a-1"); err != nil || balance != 0 {
+		t.Errorf("till after void = %v (%v), want 0", balance, err)
+	}
+	pl, err := profitAndLoss(context.Background(), db, "org-1", time.Now().AddDate(0, 0, -1), time.Now().AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pl["sales"] != 0.0 {
+		t.Errorf("voided sale still in P&L: %v", pl["sales"])
+	}
+	if code, _ := call("manager", path, ""); code != 409 {
+		t.Errorf("voided twice: got %d, want 409", code)
+	}
+
+	// older sales are for managers
+	if code, _ := call("cashier", "/api/transactions/old/void", ""); code != 403 {
+		t.Errorf("cashier voiding an old sale: got %d, want 403", code)
+	}
+	if code, out := call("manager", "/api/transactions/old/void", ""); code != 200 {
+		t.Errorf("manager voiding an old sale: %d %v", code, out)
+	}
+	if code, _ := call("manager", "/api/transactions/nope/void", ""); code != 404 {
+		t.Errorf("unknown transaction: got %d, want 404", code)
+	}
+}
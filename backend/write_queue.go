@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SQLite allows one writer at a time; concurrent writers fail with
+// SQLITE_BUSY. Mutating requests are therefore run one after another:
+// each waits for its turn up to WRITE_QUEUE_TIMEOUT_MS (default 5000),
+// and at most WRITE_QUEUE_MAX_WAITING requests (default 50) wait at once.
+// A request that cannot get its turn is answered 503 with Retry-After, so
+// a burst from several POS terminals slows down instead of failing with
+// database errors. Reads are never queued.
+//
+// Writes by background jobs are not queued; SQLite's busy timeout covers
+// them. The queue is off with PostgreSQL, or with WRITE_QUEUE=off.
+
+type writeQueue struct {
+	turn       chan struct{}
+	waiting    int64
+	maxWaiting int64
+	timeout    time.Duration
+}
+
+func newWriteQueue() *writeQueue {
+	q := &writeQueue{turn: make(chan struct{}, 1), maxWaiting: 50, timeout: 5 * time.Second}
+	if v, err := strconv.ParseInt(os.Getenv("WRITE_QUEUE_MAX_WAITING"), 10, 64); err == nil && v >= 0 {
+		q.maxWaiting = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("WRITE_QUEUE_TIMEOUT_MS")); err == nil && v >= 0 {
+		q.timeout = time.Duration(v) * time.Millisecond
+	}
+	return q
+}
+
+// acquire waits for the writer's turn, reporting false when the queue is
+// full or the wait timed out.
+func (q *writeQueue) acquire() bool {
+	select {
+	case q.turn <- struct{}{}:
+		return true
+	default:
+	}
+	if atomic.AddInt64(&q.waiting, 1) > q.maxWaiting {
+		atomic.AddInt64(&q.waiting, -1)
+		return false
+	}
+	defer atomic.AddInt64(&q.waiting, -1)
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	select {
+	case q.turn <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (q *writeQueue) release() {
+	<-q.turn
+}
+
+// serializeWrites returns middleware running mutating requests through
+// the write queue.
+func serializeWrites() fiber.Handler {
+	if os.Getenv("WRITE_QUEUE") == "off" {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+	q := newWriteQueue()
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		}
+		if db == nil || db.dialect != sqliteDialect {
+			return c.Next()
+		}
+		if !q.acquire() {
+			// roughly how long the requests ahead of this one will take
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(1+int(atomic.LoadInt64(&q.waiting))/10))
+			return c.Status(503).JSON(fiber.Map{"error": "server busy, retry shortly"})
+		}
+		defer q.release()
+		return c.Next()
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestWriteQueueTimesOutWith503(t *testing.T) {
+	db = openTestDB(t)
+	t.Setenv("WRITE_QUEUE_TIMEOUT_MS", "50")
+	app := fiber.New()
+	app.Use(serializeWrites())
+	release := make(chan struct{})
+	app.Post("/slow", func(c *fiber.Ctx) error {
+		<-release
+		return c.SendStatus(204)
+	})
+	app.Post("/fast", func(c *fiber.Ctx) error { return c.SendStatus(204) })
+	app.Get("/read", func(c *fiber.Ctx) error { return c.SendStatus(204) })
+
+	done := make(chan int)
+	go func() {
+		resp, err := app.Test(httptest.NewRequest("POST", "/slow", nil), -1)
+		if err != nil {
+			done <- 0
+			return
+		}
+		done <- resp.StatusCode
+	}()
+	time.Sleep(20 * time.Millisecond) // let /slow take the writer's turn
+
+	resp, err := app.Test(httptest.NewRequest("POST", "/fast", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 503 || resp.Header.Get("Retry-After") == "" {
+		t.Errorf("queued write: got %d, Retry-After %q", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	if resp, _ := app.Test(httptest.NewRequest("GET", "/read", nil), -1); resp.StatusCode != 204 {
+		t.Errorf("read during a write: got %d, want 204", resp.StatusCode)
+	}
+
+	close(release)
+	if code := <-done; code != 204 {
+		t.Errorf("slow write: got %d", code)
+	}
+	if resp, _ := app.Test(httptest.NewRequest("POST", "/fast", nil), -1); resp.StatusCode != 204 {
+		t.Errorf("write after the queue drained: got %d", resp.StatusCode)
+	}
+}
+
+func TestSQLiteBusyTimeout(t *testing.T) {
+	db := openTestDB(t)
+	var ms int
+	if err := db.QueryRow(`PRAGMA busy_timeout`).Scan(&ms); err != nil || ms != 5000 {
+		t.Errorf("busy_timeout = %d, %v", ms, err)
+	}
+}
@@ -37,16 +37,13 @@ cp -R "$FRONTEND_DIR/dist/"* "$OUT_DIR/frontend/"
 echo "Building backend (Go)"
 cd "$BACKEND_DIR"
 mkdir -p "$OUT_DIR/bin"
-go build -o "$OUT_DIR/bin/bizcalc-server" ./main.go
+go build -o "$OUT_DIR/bin/bizcalc-server" .
 
 echo "Copy onboard server and templates"
 mkdir -p "$OUT_DIR/onboard"
 cp -R "$REPO_ROOT/scripts/onboard_server.py" "$OUT_DIR/onboard/" 2>/dev/null || true
 cp -R "$REPO_ROOT/scripts/templates" "$OUT_DIR/onboard/" 2>/dev/null || true
 
-echo "Copy migrate.sql (if present)"
-[ -f "$BACKEND_DIR/migrate.sql" ] && cp "$BACKEND_DIR/migrate.sql" "$OUT_DIR/" || true
-
 echo "Writing README into bundle"
 cat > "$OUT_DIR/README.md" <<'README'
 BizCalc bundle
@@ -55,7 +52,6 @@ Contents:
 - /bin/bizcalc-server    - backend binary
 - /frontend/             - built frontend (static files)
 - /onboard/onboard_server.py - tiny onboarding web form (requires Python + Flask)
-- migrate.sql (optional)
 
 Usage on a VPS:
 1) Copy the bundle directory to the VPS (e.g., /opt/bizcalc-bundle)
@@ -61,8 +61,7 @@ chown -R www-data:www-data "$APP_DIR/frontend/dist"
 echo "Building backend (Go)..."
 cd "$BACKEND_DIR"
 GOBIN_PATH="$APP_DIR/bizcalc-server"
-go build -o "$GOBIN_PATH" ./main.go
-cp -f migrate.sql "$APP_DIR/" || true
+go build -o "$GOBIN_PATH" .
 chmod +x "$GOBIN_PATH"
 chown root:root "$GOBIN_PATH"
 
@@ -1,12 +1,27 @@
-import { useState } from 'react';
+import { useEffect, useState } from 'react';
 import { Calculator } from './components/Calculator';
 import { Ledger } from './components/Ledger';
 import { Inventory } from './components/Inventory';
-import { Calculator as CalcIcon, BookOpen, Package } from 'lucide-react';
+import { Login } from './components/Login';
+import { Calculator as CalcIcon, BookOpen, Package, LogOut } from 'lucide-react';
 import { cn } from './lib/utils';
+import { AUTH_LOGOUT_EVENT, getCurrentUser, getToken, logout, type AuthUser } from './lib/auth';
+import { useTranslation } from './i18n';
 
 function App() {
+  const { t } = useTranslation();
   const [currentPage, setCurrentPage] = useState<'calculator' | 'ledger' | 'inventory'>('calculator');
+  const [user, setUser] = useState<AuthUser | null>(() => (getToken() ? getCurrentUser() : null));
+
+  useEffect(() => {
+    const onLogout = () => setUser(null);
+    window.addEventListener(AUTH_LOGOUT_EVENT, onLogout);
+    return () => window.removeEventListener(AUTH_LOGOUT_EVENT, onLogout);
+  }, []);
+
+  if (!user) {
+    return <Login onLogin={setUser} />;
+  }
 
   return (
     <div className="fixed inset-0 flex flex-col">
@@ -49,6 +64,14 @@ function App() {
           <Package size={24} />
           <span className="text-sm mt-1 font-medium">Inventory</span>
         </button>
+        <button
+          onClick={() => logout()}
+          title={user.email}
+          className="flex flex-col items-center justify-center w-full h-full transition-colors text-gray-500 hover:text-gray-700"
+        >
+          <LogOut size={24} />
+          <span className="text-sm mt-1 font-medium">{t('auth.logout')}</span>
+        </button>
       </nav>
     </div>
   );
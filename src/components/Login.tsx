@@ -0,0 +1,71 @@
+import React, { useState } from 'react';
+import { login, register, type AuthUser } from '../lib/auth';
+import { useTranslation } from '../i18n';
+import { LanguageToggle } from './LanguageToggle';
+
+export const Login: React.FC<{ onLogin: (user: AuthUser) => void }> = ({ onLogin }) => {
+  const { t } = useTranslation();
+  const [mode, setMode] = useState<'login' | 'register'>('login');
+  const [email, setEmail] = useState('');
+  const [password, setPassword] = useState('');
+  const [name, setName] = useState('');
+  const [organizationName, setOrganizationName] = useState('');
+  const [error, setError] = useState('');
+  const [submitting, setSubmitting] = useState(false);
+
+  const submit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError('');
+    setSubmitting(true);
+    try {
+      const user = mode === 'login'
+        ? await login(email, password)
+        : await register(email, password, name, organizationName);
+      onLogin(user);
+    } catch (err: any) {
+      setError(err.message || String(err));
+    } finally {
+      setSubmitting(false);
+    }
+  };
+
+  const inputClass = 'w-full px-3 py-2 border border-gray-300 rounded-md focus:outline-none focus:ring-2 focus:ring-blue-500';
+
+  return (
+    <div className="fixed inset-0 flex items-center justify-center bg-gray-50 p-4">
+      <form onSubmit={submit} className="w-full max-w-sm bg-white rounded-lg shadow-lg p-6 space-y-4">
+        <div className="flex items-center justify-between">
+          <h1 className="text-xl font-semibold text-gray-800">
+            {mode === 'login' ? t('auth.login') : t('auth.register')}
+          </h1>
+          <LanguageToggle />
+        </div>
+        {mode === 'register' && (
+          <>
+            <input className={inputClass} placeholder={t('auth.name')} value={name} onChange={(e) => setName(e.target.value)} />
+            <input className={inputClass} placeholder={t('auth.businessName')} value={organizationName} onChange={(e) => setOrganizationName(e.target.value)} />
+          </>
+        )}
+        <input className={inputClass} type="email" placeholder={t('auth.email')} value={email} onChange={(e) => setEmail(e.target.value)} required />
+        <input className={inputClass} type="password" placeholder={t('auth.password')} value={password} onChange={(e) => setPassword(e.target.value)} required minLength={mode === 'register' ? 8 : undefined} />
+        {error && <p className="text-sm text-red-600">{error}</p>}
+        <button
+          type="submit"
+          disabled={submitting}
+          className="w-full py-2 bg-blue-500 text-white rounded-md font-medium hover:bg-blue-600 disabled:opacity-50"
+        >
+          {submitting ? t('processing') : mode === 'login' ? t('auth.login') : t('auth.register')}
+        </button>
+        <button
+          type="button"
+          onClick={() => { setMode(mode === 'login' ? 'register' : 'login'); setError(''); }}
+          className="w-full text-sm text-blue-500 hover:underline"
+        >
+          {mode === 'login' ? t('auth.needAccount') : t('auth.haveAccount')}
+        </button>
+      </form>
+    </div>
+  );
+};
+
+export default Login;
@@ -84,7 +84,16 @@ const translations = {
     'history.change': 'Change',
     'history.previous': 'Previous',
     'history.new': 'New',
-    'history.notes': 'Notes'
+    'history.notes': 'Notes',
+    'auth.login': 'Log in',
+    'auth.register': 'Create account',
+    'auth.logout': 'Log out',
+    'auth.email': 'Email',
+    'auth.password': 'Password',
+    'auth.name': 'Your name',
+    'auth.businessName': 'Business name',
+    'auth.needAccount': 'No account yet? Create one',
+    'auth.haveAccount': 'Already have an account? Log in'
   },
   bn: {
     sale: 'বিক্রয়',
@@ -171,7 +180,16 @@ const translations = {
     'history.change': 'পরিবর্তন',
     'history.previous': 'আগে',
     'history.new': 'নতুন',
-    'history.notes': 'নোটস'
+    'history.notes': 'নোটস',
+    'auth.login': 'লগ ইন',
+    'auth.register': 'অ্যাকাউন্ট তৈরি করুন',
+    'auth.logout': 'লগ আউট',
+    'auth.email': 'ইমেইল',
+    'auth.password': 'পাসওয়ার্ড',
+    'auth.name': 'আপনার নাম',
+    'auth.businessName': 'ব্যবসার নাম',
+    'auth.needAccount': 'অ্যাকাউন্ট নেই? তৈরি করুন',
+    'auth.haveAccount': 'অ্যাকাউন্ট আছে? লগ ইন করুন'
   }
 };
 
@@ -1,7 +1,9 @@
+import { authFetch } from './auth';
+
 const API_URL = import.meta.env.VITE_API_URL || '';
 
 async function fetchJson(path: string, options: RequestInit = {}) {
-  const res = await fetch(`${API_URL}${path}`, options);
+  const res = await authFetch(`${API_URL}${path}`, options);
   if (!res.ok) {
     const text = await res.text();
     throw new Error(`API error: ${res.status} ${text}`);
@@ -42,7 +44,7 @@ export async function uploadFileToRecord(collection: string, recordId: string, f
   const path = `/api/collections/${collection}/records/${recordId}/files/${fieldName}`;
   const fd = new FormData();
   fd.append('file', file, file.name);
-  const res = await fetch(`${API_URL}${path}`, { method: 'POST', body: fd });
+  const res = await authFetch(`${API_URL}${path}`, { method: 'POST', body: fd });
   if (!res.ok) {
     const text = await res.text();
     throw new Error(`File upload error: ${res.status} ${text}`);
@@ -0,0 +1,119 @@
+const API_URL = import.meta.env.VITE_API_URL || '';
+
+// Session tokens issued by /api/auth. The access token goes in the
+// Authorization header of every API call; when it expires the refresh token
+// is exchanged once for a new pair before giving up and logging out.
+
+const TOKEN_KEY = 'auth.token';
+const REFRESH_KEY = 'auth.refreshToken';
+const USER_KEY = 'auth.user';
+
+export type AuthUser = {
+  id: string;
+  email: string;
+  name?: string;
+  organization_id: string;
+  role: string;
+};
+
+export const AUTH_LOGOUT_EVENT = 'auth:logout';
+
+function read(key: string) {
+  try {
+    return localStorage.getItem(key);
+  } catch (e) {
+    return null;
+  }
+}
+
+export function getToken() {
+  return read(TOKEN_KEY);
+}
+
+export function getCurrentUser(): AuthUser | null {
+  const raw = read(USER_KEY);
+  if (!raw) return null;
+  try {
+    return JSON.parse(raw);
+  } catch (e) {
+    return null;
+  }
+}
+
+function saveSession(json: any) {
+  try {
+    localStorage.setItem(TOKEN_KEY, json.token);
+    localStorage.setItem(REFRESH_KEY, json.refresh_token);
+    if (json.user) localStorage.setItem(USER_KEY, JSON.stringify(json.user));
+  } catch (e) {}
+}
+
+export function clearSession() {
+  try {
+    localStorage.removeItem(TOKEN_KEY);
+    localStorage.removeItem(REFRESH_KEY);
+    localStorage.removeItem(USER_KEY);
+  } catch (e) {}
+  window.dispatchEvent(new Event(AUTH_LOGOUT_EVENT));
+}
+
+async function postAuth(path: string, body: any) {
+  const res = await fetch(`${API_URL}/api/auth/${path}`, {
+    method: 'POST',
+    headers: { 'Content-Type': 'application/json' },
+    body: JSON.stringify(body),
+  });
+  const json = await res.json().catch(() => ({}));
+  if (!res.ok) throw new Error(json.error || `Auth error: ${res.status}`);
+  return json;
+}
+
+export async function login(email: string, password: string): Promise<AuthUser> {
+  const json = await postAuth('login', { email, password });
+  saveSession(json);
+  return json.user;
+}
+
+export async function register(email: string, password: string, name: string, organizationName: string): Promise<AuthUser> {
+  const json = await postAuth('register', { email, password, name, organization_name: organizationName });
+  saveSession(json);
+  return json.user;
+}
+
+export async function logout() {
+  const refreshToken = read(REFRESH_KEY);
+  if (refreshToken) {
+    await postAuth('logout', { refresh_token: refreshToken }).catch(() => undefined);
+  }
+  clearSession();
+}
+
+let refreshing: Promise<boolean> | null = null;
+
+async function refreshSession() {
+  const refreshToken = read(REFRESH_KEY);
+  if (!refreshToken) return false;
+  // concurrent 401s share one refresh; refresh tokens are single use
+  if (!refreshing) {
+    refreshing = postAuth('refresh', { refresh_token: refreshToken })
+      .then((json) => { saveSession(json); return true; })
+      .catch(() => false)
+      .finally(() => { refreshing = null; });
+  }
+  return refreshing;
+}
+
+// authFetch is fetch with the session's bearer token. A 401 triggers one
+// refresh and retry; if that fails the session is cleared.
+export async function authFetch(url: string, options: RequestInit = {}, retry = true): Promise<Response> {
+  const headers = new Headers(options.headers);
+  const token = getToken();
+  if (token) headers.set('Authorization', `Bearer ${token}`);
+  const res = await fetch(url, { ...options, headers });
+  if (res.status !== 401) return res;
+  if (retry && await refreshSession()) {
+    return authFetch(url, options, false);
+  }
+  clearSession();
+  return res;
+}